@@ -18,11 +18,12 @@ const docTemplate = `{
     "paths": {
         "/": {
             "get": {
-                "description": "Return the running API version.",
+                "description": "Return the running API version, along with the version, git commit and build date of the\nbinary and its compiled-in signing backends.",
                 "produces": [
                     "application/json"
                 ],
                 "summary": "Get API version",
+                "operationId": "getIndex",
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -33,28 +34,38 @@ const docTemplate = `{
                 }
             }
         },
-        "/{host}": {
+        "/admin/audit": {
             "get": {
-                "description": "Return the CA public key and supported OpenID Connect providers with their required scopes.",
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Return audit events, such as issued and denied certificates, since the given time (default: last 24 hours).",
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Get host information",
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Get audit trail",
+                "operationId": "getAdminAudit",
                 "parameters": [
                     {
                         "type": "string",
-                        "example": "\"example.com\"",
-                        "description": "Host",
-                        "name": "host",
-                        "in": "path",
-                        "required": true
+                        "description": "RFC 3339 timestamp",
+                        "name": "since",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/api.ApiResponseHost"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/storage.AuditEvent"
+                            }
                         }
                     },
                     "400": {
@@ -63,8 +74,14 @@ const docTemplate = `{
                             "$ref": "#/definitions/api.ApiResponseError"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
                             "$ref": "#/definitions/api.ApiResponseError"
                         }
@@ -74,9 +91,64 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/api.ApiResponseError"
                         }
+                    }
+                }
+            }
+        },
+        "/admin/certificates": {
+            "get": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Return the most recently issued certificates, newest first.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "List issued certificates",
+                "operationId": "getAdminCertificates",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Maximum number of certificates",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.ApiResponseAdminCertificate"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
                     },
-                    "502": {
-                        "description": "Bad Gateway",
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
                         "schema": {
                             "$ref": "#/definitions/api.ApiResponseError"
                         }
@@ -84,40 +156,113 @@ const docTemplate = `{
                 }
             }
         },
-        "/{host}/certificate": {
+        "/admin/certificates/{serial}": {
+            "get": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Return the certificate with the given serial number and whether, when and why it was revoked.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Get certificate status",
+                "operationId": "getAdminCertificate",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Certificate serial number",
+                        "name": "serial",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseAdminCertificate"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/certificates/{serial}/revoke": {
             "post": {
-                "description": "Generate and return a new SSH certificate using the given public key and access token.",
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Revoke the certificate with the given serial number. It is added to the KRL of its CA. The\nreason must be one of key-compromise, user-left, policy-violation or superseded.",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Generate SSH certificate",
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Revoke certificate",
+                "operationId": "revokeCertificate",
                 "parameters": [
                     {
-                        "type": "string",
-                        "example": "\"example.com\"",
-                        "description": "Host",
-                        "name": "host",
+                        "type": "integer",
+                        "description": "Certificate serial number",
+                        "name": "serial",
                         "in": "path",
                         "required": true
                     },
                     {
-                        "description": "Public key and access token",
+                        "description": "Revocation reason",
                         "name": "body",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/api.FormHostCertificate"
+                            "$ref": "#/definitions/api.FormAdminRevoke"
                         }
                     }
                 ],
                 "responses": {
-                    "201": {
-                        "description": "Created",
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/api.ApiResponseCertificate"
+                            "$ref": "#/definitions/storage.Revocation"
                         }
                     },
                     "400": {
@@ -132,87 +277,2783 @@ const docTemplate = `{
                             "$ref": "#/definitions/api.ApiResponseError"
                         }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
                     "404": {
                         "description": "Not Found",
                         "schema": {
                             "$ref": "#/definitions/api.ApiResponseError"
                         }
                     },
-                    "500": {
-                        "description": "Internal Server Error",
+                    "409": {
+                        "description": "Conflict",
                         "schema": {
                             "$ref": "#/definitions/api.ApiResponseError"
                         }
                     },
-                    "502": {
-                        "description": "Bad Gateway",
+                    "500": {
+                        "description": "Internal Server Error",
                         "schema": {
                             "$ref": "#/definitions/api.ApiResponseError"
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "api.ApiResponseCertificate": {
-            "type": "object",
-            "properties": {
-                "certificate": {
-                    "type": "string"
-                }
-            }
-        },
-        "api.ApiResponseError": {
-            "type": "object",
-            "properties": {
-                "error": {
-                    "type": "string"
-                }
-            }
         },
-        "api.ApiResponseHost": {
-            "type": "object",
-            "properties": {
-                "providers": {
-                    "type": "array",
-                    "items": {
-                        "$ref": "#/definitions/api.Provider"
+        "/admin/decisions/{request_id}": {
+            "get": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Return the checks made for a certificate request, its outcome and the claims of the access token, given the request ID returned in the X-Request-Id header or error response.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Get decision trace",
+                "operationId": "getAdminDecision",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Request ID",
+                        "name": "request_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/storage.Decision"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
                     }
-                },
-                "publickey": {
-                    "type": "string"
-                }
-            }
-        },
-        "api.ApiResponseIndex": {
-            "type": "object",
-            "properties": {
-                "version": {
-                    "type": "string"
                 }
             }
         },
-        "api.FormHostCertificate": {
-            "type": "object",
-            "required": [
-                "publickey",
-                "token"
-            ],
-            "properties": {
-                "publickey": {
-                    "type": "string"
-                },
-                "token": {
-                    "type": "string"
+        "/admin/deployments": {
+            "get": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Return the state of deployments on other hosts of a hostgroup with eager-deploy, which are started\nafter issuing a certificate. Deployments are kept for one hour, most recently updated first.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "List eager deployments",
+                "operationId": "getAdminDeployments",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.ApiResponseAdminDeployment"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    }
                 }
             }
         },
-        "api.Provider": {
-            "type": "object",
-            "properties": {
-                "scopes": {
-                    "type": "array",
+        "/admin/dns": {
+            "get": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Return SSHFP records of the host keys and CERT records of the host certificates reported by all hosts, in zone file format. These can be published in a DNSSEC-signed zone, so clients can verify hosts using VerifyHostKeyDNS.",
+                "produces": [
+                    "text/plain"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Get DNS records",
+                "operationId": "getAdminDns",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "TTL of the records",
+                        "name": "ttl",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/enrollments": {
+            "get": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Return host enrollments in the given state (pending, approved or rejected), or all of them.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "List host enrollments",
+                "operationId": "getAdminEnrollments",
+                "parameters": [
+                    {
+                        "enum": [
+                            "pending",
+                            "approved",
+                            "rejected"
+                        ],
+                        "type": "string",
+                        "description": "State",
+                        "name": "state",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/storage.Enrollment"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/enrollments/{id}/approve": {
+            "post": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Approve a pending host enrollment, which issues the host certificate.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Approve host enrollment",
+                "operationId": "approveEnrollment",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Enrollment ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/storage.Enrollment"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/enrollments/{id}/reject": {
+            "post": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Reject a pending host enrollment.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Reject host enrollment",
+                "operationId": "rejectEnrollment",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Enrollment ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/storage.Enrollment"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/freezes": {
+            "get": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Return for every hostgroup whether certificate issuance is currently paused, either by a\nconfigured freeze window or by an admin.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "List issuance freezes",
+                "operationId": "getAdminFreezes",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.ApiResponseAdminFreeze"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/hostgroups": {
+            "get": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Return all configured host groups.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "List host groups",
+                "operationId": "getAdminHostGroups",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.ApiResponseAdminHostGroup"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/hostgroups/{name}/freeze": {
+            "put": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Pause certificate issuance for a hostgroup, e.g. during a security incident, or lift its\nfreeze windows with frozen set to false. Certificate requests are answered with 503 and the\nreason. This overrides the freeze windows until it expires or is removed.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Freeze issuance",
+                "operationId": "putAdminFreeze",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Hostgroup name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Freeze",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.FormAdminFreeze"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/storage.Freeze"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Remove the freeze set by an admin for a hostgroup, so that its freeze windows apply again.",
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Remove issuance freeze",
+                "operationId": "deleteAdminFreeze",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Hostgroup name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/metrics": {
+            "get": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Return metrics in the Prometheus text format: a histogram of the validity of certificates issued since\nthe CA started, and the number of unexpired certificates with token-bound validity which were valid\nfor less than five minutes when issued, the number of clients delayed by the tarpit middleware, and\nthe health of motley_cue instances: state of the circuit breaker, requests, failures, error rate,\nlast success, pooled connections and a latency histogram.",
+                "produces": [
+                    "text/plain"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Get metrics",
+                "operationId": "getAdminMetrics",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/replica/promote": {
+            "post": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Promote this CA from a warm standby replica to the primary CA, which signs certificates and\npersists its state. Only promote the replica after the primary CA was stopped and replication of\nits storage file ended, as both would sign certificates otherwise. Replicas can also be promoted\nby creating their promote-file. Promoting a promoted replica again does nothing.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Promote replica",
+                "operationId": "postAdminPromote",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseAdminReplica"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/upstreams": {
+            "get": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Check reachability of all configured motley_cue instances, along with their health according to\nthe requests made since the CA started: state of the circuit breaker, last success, error rate and\nlatency percentiles of recent requests, and connections reused from the pool.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Check upstreams",
+                "operationId": "getAdminUpstreams",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.ApiResponseAdminUpstream"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/vos": {
+            "get": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Return the number of certificates issued to each configured community/VO since the given time (default: start of the current UTC day), along with its daily quota.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Get usage per VO",
+                "operationId": "getAdminVos",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "RFC 3339 timestamp",
+                        "name": "since",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.ApiResponseAdminVO"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/whoami": {
+            "get": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Return name, role and permissions of the admin token used.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Get admin identity",
+                "operationId": "getAdminIdentity",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseAdminIdentity"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/health": {
+            "get": {
+                "description": "Return the health of the CA. The CA is degraded if its clock is off by more than the clock skew tolerance, as hosts would reject issued certificates.\nReplicas are healthy, but report their role so that load balancers can send requests for certificates to the primary CA.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get CA health",
+                "operationId": "getHealth",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseHealth"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseHealth"
+                        }
+                    }
+                }
+            }
+        },
+        "/requests/{id}": {
+            "get": {
+                "description": "Return the response of a certificate request that was answered with 202 Accepted, which is the\nsame as if the request had been handled synchronously, or 202 Accepted while it is still\nprocessed. The access token of the request must be sent. Responses are kept for five minutes.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get result of asynchronous request",
+                "operationId": "getRequest",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Request ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Bearer access token of the request",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseCertificate"
+                        }
+                    },
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseAsync"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/stats": {
+            "get": {
+                "description": "Return anonymous usage statistics of the last 30 days, such as the number of certificates issued per\nday and the number of distinct hosts. Only counts are included. The endpoint must be enabled with\nthe usage-stats option, so operators can opt in to demonstrating adoption.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get usage statistics",
+                "operationId": "getStats",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseStats"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/trust-bundle": {
+            "get": {
+                "description": "Return @cert-authority lines for all hosts served by this CA and its peer CAs, suitable for\nknown_hosts files.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get host CA trust bundle",
+                "operationId": "getTrustBundle",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseTrustBundle"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/{host}": {
+            "get": {
+                "description": "Return the CA public key and supported OpenID Connect providers with their required scopes.\nIf configured, a message of the hostgroup for users is included, such as announced maintenance.\nFor hosts delegated to a site CA, its user CA key is included, certified by the user CA key of the\nhostgroup. Hosts served by a peer CA are redirected to it with 307, along with its host CA key.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get host information",
+                "operationId": "getHost",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "\"example.com\"",
+                        "description": "Host",
+                        "name": "host",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseHost"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponsePeer"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "502": {
+                        "description": "Bad Gateway",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/{host}/certificate": {
+            "post": {
+                "description": "Generate and return a new SSH certificate using the given public key and access token.\nIf dry_run is set, the certificate is not signed and its fields are returned instead.\nDry runs have no side effects: users are not deployed by motley_cue, so they must have been deployed before.\nThe access token should be sent in the Authorization header rather than in the body.\nRetries with the same Idempotency-Key return the certificate issued for the first request.\nRequests for hosts delegated to a site CA are forwarded to it, or redirected with 307, as are\nrequests for hosts served by a peer CA.\nWith format=bundle, a gzipped tarball is returned instead of JSON, containing the certificate, a\nknown_hosts file trusting the host CA, the KRL of the user CA and an ssh_config snippet, so users of\nplain ssh can set up access in one download.\nClients sending \"Prefer: respond-async\" receive 202 Accepted if the request takes longer than\nasync-after, and poll GET /requests/{id} until the response is ready.\nWhile issuance for the hostgroup is frozen, requests are answered with 503, along with a\nRetry-After header if the freeze ends.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/gzip"
+                ],
+                "summary": "Generate SSH certificate",
+                "operationId": "signCertificate",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "\"example.com\"",
+                        "description": "Host",
+                        "name": "host",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Do not sign, only return certificate fields",
+                        "name": "dry_run",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "json",
+                            "bundle"
+                        ],
+                        "type": "string",
+                        "description": "Response format",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Bearer access token",
+                        "name": "Authorization",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unique key of the request, reused for retries",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "respond-async to receive 202 Accepted if the request is slow",
+                        "name": "Prefer",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Public key and access token",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.FormHostCertificate"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseCertificateDryRun"
+                        }
+                    },
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseCertificate"
+                        }
+                    },
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseAsync"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponsePeer"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "502": {
+                        "description": "Bad Gateway",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/{host}/emergency": {
+            "post": {
+                "description": "Request a certificate without OIDC or motley_cue, for incident responders who must reach hosts\nduring outages of the identity provider. The request must be signed by one of the hardware-backed\nkeys of emergency-keys within the last 5 minutes using \"ssh-keygen -Y sign -n oinit-emergency\",\ne.g. by scripts/oinit-emergency.sh, and each request is accepted once. Certificates are valid for\nemergency-validity seconds, marked with the extension emergency@oinit, and the security contacts\nare alerted.",
+                "consumes": [
+                    "application/json",
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Request an emergency certificate",
+                "operationId": "postHostEmergency",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "\"example.com\"",
+                        "description": "Host",
+                        "name": "host",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Signed emergency request",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.FormHostEmergency"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseCertificate"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/{host}/enroll": {
+            "post": {
+                "description": "Request a host certificate for a host key, if host-enrollment is enabled for the hostgroup. The\nrequest is held pending until an admin approves it, unless it matches a rule of\nenroll-auto-approve, and the host polls the URL in the Location header for the certificate. At\nmost 5 enrollments may be pending per host and 100 per hostgroup.",
+                "consumes": [
+                    "application/json",
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Request a host certificate",
+                "operationId": "postHostEnroll",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "\"example.com\"",
+                        "description": "Host",
+                        "name": "host",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Host public key",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.FormHostEnroll"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseEnrollment"
+                        }
+                    },
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseEnrollment"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/{host}/enroll/{id}": {
+            "get": {
+                "description": "Return the state of a host enrollment, and the host certificate once it is approved.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get host enrollment",
+                "operationId": "getHostEnrollment",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "\"example.com\"",
+                        "description": "Host",
+                        "name": "host",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Enrollment ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseEnrollment"
+                        }
+                    },
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseEnrollment"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/{host}/hostkeys": {
+            "get": {
+                "description": "Return the SSH host keys and host certificate last reported by the given host, so clients can verify its identity on first connect.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get host keys",
+                "operationId": "getHostKeys",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "\"example.com\"",
+                        "description": "Host",
+                        "name": "host",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseHostKeys"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Report the SSH host keys of a host, which are then returned by GET /{host}/hostkeys.\nThe report must be signed with the key of a valid host certificate for this host, e.g. using scripts/oinit-report-hostkeys.sh.",
+                "consumes": [
+                    "application/json",
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Report host keys",
+                "operationId": "reportHostKeys",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "\"example.com\"",
+                        "description": "Host",
+                        "name": "host",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Signed host keys",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.FormHostKeys"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseHostKeys"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/{host}/krl": {
+            "get": {
+                "description": "Return the OpenSSH key revocation list (KRL) of certificates revoked for the user CA of the given host, suitable for the RevokedKeys option of sshd.\nThe ETag is the SHA-256 hash of the KRL, so hosts can send the hash of their installed KRL in If-None-Match.",
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "summary": "Get key revocation list",
+                "operationId": "getHostKrl",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "\"example.com\"",
+                        "description": "Host",
+                        "name": "host",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the installed KRL",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "304": {
+                        "description": "Not Modified"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/{host}/renew": {
+            "post": {
+                "description": "Issue a new host certificate for the key of the current host certificate of an enrolled host, if\nhost-enrollment is enabled for the hostgroup. The request must be signed with the key of the current,\nunexpired and unrevoked host certificate, e.g. using scripts/oinit-renew-host.sh.",
+                "consumes": [
+                    "application/json",
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Renew a host certificate",
+                "operationId": "postHostRenew",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "\"example.com\"",
+                        "description": "Host",
+                        "name": "host",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Signed renewal request",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.FormHostRenew"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseHostRenewal"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/{host}/revoke": {
+            "post": {
+                "description": "Revoke all valid certificates issued to the user of the access token for the host, e.g. if the\ndevice holding them was lost. They are added to the KRL of the host as key-compromise. The token\nmust be accepted by motley_cue, but the account need not be deployed.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Revoke the user's certificates",
+                "operationId": "postHostRevoke",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "\"example.com\"",
+                        "description": "Host",
+                        "name": "host",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Bearer access token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseRevoked"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "502": {
+                        "description": "Bad Gateway",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/{host}/status": {
+            "get": {
+                "description": "Return the state of the account of the user of the access token on the host, as reported by\nmotley_cue, without deploying it. Clients can show it before connecting, e.g. that the account is\nstill pending. Unless deployed, a remediation tells users what they can do about it.\nStates are cached for the status-cache-duration of the CA.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get the state of the user's account",
+                "operationId": "getHostStatus",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "\"example.com\"",
+                        "description": "Host",
+                        "name": "host",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Bearer access token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseUserStatus"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponsePeer"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "502": {
+                        "description": "Bad Gateway",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiResponseError"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "api.ApiResponseAdminCertificate": {
+            "type": "object",
+            "properties": {
+                "ca": {
+                    "description": "fingerprint of the signing CA key",
+                    "type": "string"
+                },
+                "fingerprint": {
+                    "type": "string"
+                },
+                "host": {
+                    "type": "string"
+                },
+                "hostgroup": {
+                    "type": "string"
+                },
+                "issued_at": {
+                    "type": "string"
+                },
+                "key_id": {
+                    "type": "string"
+                },
+                "revocation_reason": {
+                    "type": "string",
+                    "example": "key-compromise"
+                },
+                "revoked": {
+                    "type": "boolean"
+                },
+                "revoked_at": {
+                    "type": "string"
+                },
+                "serial": {
+                    "type": "integer"
+                },
+                "subject": {
+                    "type": "string"
+                },
+                "username": {
+                    "type": "string"
+                },
+                "valid_after": {
+                    "type": "string"
+                },
+                "valid_before": {
+                    "type": "string"
+                },
+                "vos": {
+                    "description": "Communities/VOs the certificate is accounted to",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "api.ApiResponseAdminDeployment": {
+            "type": "object",
+            "properties": {
+                "attempts": {
+                    "type": "integer"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "host": {
+                    "type": "string"
+                },
+                "state": {
+                    "type": "string",
+                    "enum": [
+                        "pending",
+                        "deployed",
+                        "failed"
+                    ]
+                },
+                "subject": {
+                    "type": "string"
+                },
+                "updated_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.ApiResponseAdminFreeze": {
+            "type": "object",
+            "properties": {
+                "frozen": {
+                    "description": "Whether certificate issuance is currently paused",
+                    "type": "boolean"
+                },
+                "hostgroup": {
+                    "type": "string"
+                },
+                "override": {
+                    "description": "Freeze set by an admin, which overrides the freeze windows",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/storage.Freeze"
+                        }
+                    ]
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "source": {
+                    "description": "\"schedule\" or \"admin\", if a freeze window or a freeze set by an admin\napplies",
+                    "type": "string",
+                    "example": "schedule"
+                },
+                "until": {
+                    "type": "string"
+                },
+                "windows": {
+                    "description": "Configured freeze windows",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "api.ApiResponseAdminHostGroup": {
+            "type": "object",
+            "properties": {
+                "cache_duration": {
+                    "type": "integer"
+                },
+                "cert_validity": {
+                    "type": "string"
+                },
+                "host_ca_publickey": {
+                    "type": "string"
+                },
+                "hosts": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "name": {
+                    "type": "string"
+                },
+                "user_ca_publickey": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.ApiResponseAdminIdentity": {
+            "type": "object",
+            "properties": {
+                "name": {
+                    "type": "string"
+                },
+                "permissions": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "role": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.ApiResponseAdminReplica": {
+            "type": "object",
+            "properties": {
+                "promoted": {
+                    "$ref": "#/definitions/storage.Promotion"
+                },
+                "role": {
+                    "description": "\"primary\" or \"replica\"",
+                    "type": "string",
+                    "example": "primary"
+                }
+            }
+        },
+        "api.ApiResponseAdminUpstream": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "latency_ms": {
+                    "type": "integer"
+                },
+                "reachable": {
+                    "type": "boolean"
+                },
+                "url": {
+                    "type": "string"
+                },
+                "health": {
+                    "description": "Health according to requests made since the CA started",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/api.ApiResponseUpstreamHealth"
+                        }
+                    ]
+                }
+            }
+        },
+        "api.ApiResponseAdminVO": {
+            "type": "object",
+            "properties": {
+                "issued": {
+                    "description": "Certificates issued since the requested time",
+                    "type": "integer"
+                },
+                "quota": {
+                    "description": "Maximum number of certificates per day, 0 = unlimited",
+                    "type": "integer"
+                },
+                "subjects": {
+                    "description": "Distinct subjects that certificates were issued to",
+                    "type": "integer"
+                },
+                "vo": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.ApiResponseAsync": {
+            "type": "object",
+            "properties": {
+                "request_id": {
+                    "type": "string",
+                    "example": "9f86d081884c7d659a2feaa0c55ad015"
+                },
+                "status": {
+                    "type": "string",
+                    "example": "pending"
+                }
+            }
+        },
+        "api.ApiResponseBuild": {
+            "type": "object",
+            "properties": {
+                "commit": {
+                    "type": "string",
+                    "example": "5db67d0c3f4e2a1b9d8c7e6f5a4b3c2d1e0f9a8b"
+                },
+                "date": {
+                    "type": "string",
+                    "example": "2024-01-02T03:04:05Z"
+                },
+                "go_version": {
+                    "type": "string",
+                    "example": "go1.21.5"
+                },
+                "signing_backends": {
+                    "description": "Signing backends compiled into the binary",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "file"
+                    ]
+                },
+                "version": {
+                    "type": "string",
+                    "example": "v1.2.3"
+                }
+            }
+        },
+        "api.ApiResponseCertificate": {
+            "type": "object",
+            "properties": {
+                "certificate": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.ApiResponseCertificateDryRun": {
+            "type": "object",
+            "properties": {
+                "critical_options": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "extensions": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "keyid": {
+                    "type": "string"
+                },
+                "principals": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "publickey": {
+                    "type": "string"
+                },
+                "valid_after": {
+                    "type": "string"
+                },
+                "valid_before": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.ApiResponseClockHealth": {
+            "type": "object",
+            "properties": {
+                "checked_at": {
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "offset_ms": {
+                    "description": "Offset of the CA clock, positive if ahead",
+                    "type": "integer"
+                },
+                "server": {
+                    "type": "string",
+                    "example": "pool.ntp.org"
+                },
+                "status": {
+                    "type": "string",
+                    "example": "ok"
+                },
+                "tolerance_ms": {
+                    "type": "integer"
+                }
+            }
+        },
+        "api.ApiResponseDelegation": {
+            "type": "object",
+            "properties": {
+                "publickey": {
+                    "description": "User CA key of the site CA, trusted by the host",
+                    "type": "string"
+                },
+                "signature": {
+                    "description": "Armored SSH signature over statement by the user CA key of the\nhostgroup, in namespace oinit-delegation",
+                    "type": "string"
+                },
+                "statement": {
+                    "description": "Signed statement \"\u003chost\u003e\\n\u003curl\u003e\\n\u003cpublickey\u003e\\n\u003cvalid before\u003e\", with\nvalid before as Unix timestamp",
+                    "type": "string"
+                },
+                "url": {
+                    "description": "URL of the site CA",
+                    "type": "string",
+                    "example": "https://ca.site.example.com"
+                },
+                "valid_before": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.ApiResponseEnrollment": {
+            "type": "object",
+            "properties": {
+                "certificate": {
+                    "description": "Host certificate, once the enrollment is approved",
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string",
+                    "example": "9f86d081884c7d659a2feaa0c55ad015"
+                },
+                "state": {
+                    "type": "string",
+                    "example": "approved"
+                }
+            }
+        },
+        "api.ApiResponseError": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "Machine-readable error code",
+                    "type": "string",
+                    "example": "bad_body"
+                },
+                "error": {
+                    "description": "Message in the language requested by Accept-Language",
+                    "type": "string",
+                    "example": "Request body is malformed."
+                },
+                "errors": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.FieldError"
+                    }
+                },
+                "remediation": {
+                    "description": "What users can do about denied requests",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/api.Remediation"
+                        }
+                    ]
+                },
+                "request_id": {
+                    "description": "ID of the request, which support staff can use to look up why it\nfailed",
+                    "type": "string",
+                    "example": "9f86d081884c7d659a2feaa0c55ad015"
+                }
+            }
+        },
+        "api.ApiResponseForceCommand": {
+            "type": "object",
+            "properties": {
+                "features": {
+                    "description": "Kinds of sessions the force-command supports",
+                    "type": "array",
+                    "items": {
+                        "type": "string",
+                        "enum": [
+                            "shell",
+                            "exec",
+                            "scp",
+                            "sftp"
+                        ]
+                    },
+                    "example": [
+                        "shell",
+                        "exec",
+                        "scp",
+                        "sftp"
+                    ]
+                },
+                "program": {
+                    "description": "Program run by the force-command",
+                    "type": "string",
+                    "example": "oinit-switch"
+                },
+                "signed": {
+                    "description": "Whether the force-command is signed with a key shared with the hosts",
+                    "type": "boolean"
+                },
+                "wrapper": {
+                    "description": "Whether a wrapper other than oinit-switch is run",
+                    "type": "boolean"
+                }
+            }
+        },
+        "api.ApiResponseHealth": {
+            "type": "object",
+            "properties": {
+                "clock": {
+                    "$ref": "#/definitions/api.ApiResponseClockHealth"
+                },
+                "status": {
+                    "type": "string",
+                    "example": "ok"
+                },
+                "role": {
+                    "description": "\"primary\", or \"replica\" if the CA is a warm standby that doesn't sign\ncertificates until it is promoted",
+                    "type": "string",
+                    "example": "primary"
+                }
+            }
+        },
+        "api.ApiResponseHost": {
+            "type": "object",
+            "properties": {
+                "delegation": {
+                    "description": "Site CA that issues certificates for the host, if delegated",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/api.ApiResponseDelegation"
+                        }
+                    ]
+                },
+                "force_command": {
+                    "description": "Force-command of issued certificates, omitted for delegated hosts",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/api.ApiResponseForceCommand"
+                        }
+                    ]
+                },
+                "key_algorithms": {
+                    "description": "Client key types that certificates are issued for, so clients can\ngenerate a compatible key. Omitted for delegated hosts.",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.KeyAlgorithm"
+                    }
+                },
+                "message": {
+                    "description": "Message of the hostgroup that clients show to users before connecting",
+                    "type": "string",
+                    "example": "Maintenance on Saturday, 8-12 UTC"
+                },
+                "profiles": {
+                    "description": "Certificate profiles that may be requested, the first is the default",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "interactive",
+                        "file-transfer-only"
+                    ]
+                },
+                "providers": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.Provider"
+                    }
+                },
+                "publickey": {
+                    "type": "string"
+                },
+                "signature_algorithm": {
+                    "description": "Algorithm the CA signs certificates with",
+                    "type": "string",
+                    "example": "ssh-ed25519"
+                },
+                "token_binding": {
+                    "description": "Whether the host requires logins to present the access token the\ncertificate was issued for, which clients send as OINIT_BOUND_TOKEN",
+                    "type": "boolean"
+                }
+            }
+        },
+        "api.ApiResponseHostKeys": {
+            "type": "object",
+            "properties": {
+                "certificate": {
+                    "type": "string"
+                },
+                "host": {
+                    "type": "string"
+                },
+                "hostkeys": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "reported_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.ApiResponseHostRenewal": {
+            "type": "object",
+            "properties": {
+                "certificate": {
+                    "type": "string"
+                },
+                "serial": {
+                    "type": "integer"
+                },
+                "valid_before": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.ApiResponseIndex": {
+            "type": "object",
+            "properties": {
+                "build": {
+                    "$ref": "#/definitions/api.ApiResponseBuild"
+                },
+                "version": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.ApiResponsePeer": {
+            "type": "object",
+            "properties": {
+                "name": {
+                    "type": "string",
+                    "example": "other"
+                },
+                "publickey": {
+                    "description": "Host CA key of the peer, as configured on this CA",
+                    "type": "string"
+                },
+                "url": {
+                    "description": "Base URL of the peer CA, the Location header points to the endpoint\nof the host there",
+                    "type": "string",
+                    "example": "https://ca.other.example.org"
+                }
+            }
+        },
+        "api.ApiResponseRevoked": {
+            "type": "object",
+            "properties": {
+                "serials": {
+                    "description": "Serial numbers of the revoked certificates, empty if the user held\nno valid certificate for the host",
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                }
+            }
+        },
+        "api.ApiResponseStats": {
+            "type": "object",
+            "properties": {
+                "days": {
+                    "description": "Counts of each day, oldest first",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.ApiResponseStatsDay"
+                    }
+                },
+                "issuances": {
+                    "description": "Certificates issued since then",
+                    "type": "integer",
+                    "example": 1234
+                },
+                "since": {
+                    "description": "First day covered, in UTC",
+                    "type": "string",
+                    "example": "2024-01-01"
+                },
+                "unique_hosts": {
+                    "description": "Number of distinct hosts that certificates were issued for",
+                    "type": "integer",
+                    "example": 42
+                },
+                "unique_users": {
+                    "description": "Number of distinct users that certificates were issued to",
+                    "type": "integer",
+                    "example": 87
+                },
+                "version": {
+                    "description": "Version of the CA",
+                    "type": "string",
+                    "example": "v1.2.3"
+                }
+            }
+        },
+        "api.ApiResponseStatsDay": {
+            "type": "object",
+            "properties": {
+                "date": {
+                    "type": "string",
+                    "example": "2024-01-01"
+                },
+                "issuances": {
+                    "type": "integer",
+                    "example": 41
+                },
+                "unique_hosts": {
+                    "type": "integer",
+                    "example": 12
+                }
+            }
+        },
+        "api.ApiResponseTrustBundle": {
+            "type": "object",
+            "properties": {
+                "known_hosts": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "api.ApiResponseUpstreamHealth": {
+            "type": "object",
+            "properties": {
+                "circuit_breaker": {
+                    "description": "State of the circuit breaker, \"closed\" or \"open\" if the instance is\nskipped in favor of its replicas after it recently failed",
+                    "type": "string",
+                    "example": "closed"
+                },
+                "error_rate": {
+                    "description": "Share of failed requests and latency percentiles (in milliseconds)\nof the last 100 requests",
+                    "type": "number",
+                    "example": 0.02
+                },
+                "failures": {
+                    "type": "integer"
+                },
+                "last_error": {
+                    "type": "string"
+                },
+                "last_failure": {
+                    "type": "string"
+                },
+                "last_success": {
+                    "type": "string"
+                },
+                "latency_p50_ms": {
+                    "type": "number"
+                },
+                "latency_p90_ms": {
+                    "type": "number"
+                },
+                "latency_p99_ms": {
+                    "type": "number"
+                },
+                "new_connections": {
+                    "description": "Connections opened for requests and reused from the pool",
+                    "type": "integer"
+                },
+                "requests": {
+                    "description": "Requests and failures since the CA started",
+                    "type": "integer"
+                },
+                "reused_connections": {
+                    "type": "integer"
+                }
+            }
+        },
+        "api.ApiResponseUserStatus": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "description": "Message of motley_cue, such as why the account is suspended",
+                    "type": "string"
+                },
+                "remediation": {
+                    "description": "What users can do to be able to log in, unless deployed",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/api.Remediation"
+                        }
+                    ]
+                },
+                "state": {
+                    "description": "State reported by motley_cue",
+                    "type": "string",
+                    "enum": [
+                        "deployed",
+                        "not_deployed",
+                        "pending",
+                        "rejected",
+                        "suspended",
+                        "limited",
+                        "undefined"
+                    ],
+                    "example": "deployed"
+                },
+                "user": {
+                    "description": "Account that the user logs in as, only set if deployed",
+                    "type": "string",
+                    "example": "alice"
+                }
+            }
+        },
+        "api.FieldError": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string"
+                },
+                "field": {
+                    "type": "string"
+                },
+                "message": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.FormAdminFreeze": {
+            "type": "object",
+            "required": [
+                "frozen"
+            ],
+            "properties": {
+                "duration": {
+                    "description": "Duration (in seconds) of the freeze, 0 = until it is removed",
+                    "type": "integer",
+                    "minimum": 0,
+                    "example": 3600
+                },
+                "frozen": {
+                    "description": "Whether to pause issuance, false lifts the freeze windows",
+                    "type": "boolean",
+                    "example": true
+                },
+                "reason": {
+                    "description": "Shown to users, instead of the freeze-message of the hostgroup",
+                    "type": "string",
+                    "example": "Security incident, issuance resumes after investigation."
+                }
+            }
+        },
+        "api.FormAdminRevoke": {
+            "type": "object",
+            "required": [
+                "reason"
+            ],
+            "properties": {
+                "reason": {
+                    "type": "string",
+                    "example": "key-compromise"
+                }
+            }
+        },
+        "api.FormHostCertificate": {
+            "type": "object",
+            "properties": {
+                "command": {
+                    "description": "If set, the certificate only permits running this command, optionally\nfollowed by arguments.",
+                    "type": "string",
+                    "example": "rsync --server"
+                },
+                "extensions": {
+                    "description": "Extensions the certificate should contain, a subset of those allowed\nby the CA. If omitted, all allowed extensions are included.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "permit-pty"
+                    ]
+                },
+                "profile": {
+                    "description": "Certificate profile offered by the host, see GET /{host}. If omitted,\nthe default profile of the host is used.",
+                    "type": "string",
+                    "example": "file-transfer-only"
+                },
+                "publickey": {
+                    "type": "string"
+                },
+                "signed_request": {
+                    "description": "Request signed by the private key of the public key, which relays\nsuch as portals can't alter, see package oinitca. If set, publickey,\nextensions, command and profile are taken from it and must be omitted.",
+                    "type": "string",
+                    "example": "oinit-request-v1.eyJwdWJsaWNrZXkiOiJzc2gtZWQyNTUxOSBBQUFBQzNOemFDMWxaREkxTlRFNUFBQUEifQ.AAAAC3NzaC1lZDI1NTE5"
+                },
+                "token": {
+                    "description": "Access token, may instead be sent in the Authorization header",
+                    "type": "string"
+                }
+            }
+        },
+        "api.FormHostEmergency": {
+            "type": "object",
+            "required": [
+                "request",
+                "signature"
+            ],
+            "properties": {
+                "request": {
+                    "description": "JSON of an EmergencyRequest, exactly as it was signed",
+                    "type": "string"
+                },
+                "signature": {
+                    "description": "Armored SSH signature of the request, as created by\n\"ssh-keygen -Y sign -n oinit-emergency\"",
+                    "type": "string"
+                }
+            }
+        },
+        "api.FormHostEnroll": {
+            "type": "object",
+            "required": [
+                "publickey"
+            ],
+            "properties": {
+                "publickey": {
+                    "description": "Host public key in authorized_keys format, e.g. the content of\n/etc/ssh/ssh_host_ed25519_key.pub",
+                    "type": "string"
+                }
+            }
+        },
+        "api.FormHostKeys": {
+            "type": "object",
+            "required": [
+                "certificate",
+                "hostkeys",
+                "signature",
+                "timestamp"
+            ],
+            "properties": {
+                "certificate": {
+                    "description": "Host certificate issued by the host CA of this host",
+                    "type": "string"
+                },
+                "hostkeys": {
+                    "description": "Public keys in authorized_keys format, one per line",
+                    "type": "string"
+                },
+                "signature": {
+                    "description": "Armored SSH signature over \"\u003chost\u003e\\n\u003ctimestamp\u003e\\n\u003chostkeys\u003e\"",
+                    "type": "string"
+                },
+                "timestamp": {
+                    "description": "Unix timestamp of the report",
+                    "type": "integer"
+                }
+            }
+        },
+        "api.FormHostRenew": {
+            "type": "object",
+            "required": [
+                "certificate",
+                "signature",
+                "timestamp"
+            ],
+            "properties": {
+                "certificate": {
+                    "description": "Current, unexpired host certificate issued by the CA",
+                    "type": "string"
+                },
+                "signature": {
+                    "description": "Armored SSH signature over \"\u003chost\u003e\\n\u003ctimestamp\u003e\\n\u003ccertificate serial\u003e\"",
+                    "type": "string"
+                },
+                "timestamp": {
+                    "description": "Unix timestamp of the request",
+                    "type": "integer"
+                }
+            }
+        },
+        "api.KeyAlgorithm": {
+            "type": "object",
+            "properties": {
+                "min_bits": {
+                    "description": "Minimum size of RSA keys, omitted for other types",
+                    "type": "integer",
+                    "example": 3072
+                },
+                "type": {
+                    "type": "string",
+                    "example": "ssh-rsa"
+                }
+            }
+        },
+        "api.Provider": {
+            "type": "object",
+            "properties": {
+                "scopes": {
+                    "type": "array",
                     "items": {
                         "type": "string"
                     }
@@ -221,6 +3062,211 @@ const docTemplate = `{
                     "type": "string"
                 }
             }
+        },
+        "api.Remediation": {
+            "type": "object",
+            "properties": {
+                "contact": {
+                    "description": "Who to contact if the hint doesn't help",
+                    "type": "string",
+                    "example": "support@example.com"
+                },
+                "hint": {
+                    "description": "Next step in the language requested by Accept-Language",
+                    "type": "string",
+                    "example": "Register your account on the enrollment page, then try again."
+                },
+                "url": {
+                    "description": "Page to register accounts at or learn more, if the hint refers to it",
+                    "type": "string",
+                    "example": "https://login.example.com/enroll"
+                }
+            }
+        },
+        "storage.AuditEvent": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string"
+                },
+                "actor": {
+                    "type": "string"
+                },
+                "details": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "time": {
+                    "type": "string"
+                }
+            }
+        },
+        "storage.Decision": {
+            "type": "object",
+            "properties": {
+                "claims": {
+                    "description": "Unverified claims of the access token",
+                    "type": "object",
+                    "additionalProperties": true
+                },
+                "code": {
+                    "type": "string"
+                },
+                "host": {
+                    "type": "string"
+                },
+                "outcome": {
+                    "type": "string"
+                },
+                "request_id": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "integer"
+                },
+                "steps": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/storage.DecisionStep"
+                    }
+                },
+                "subject": {
+                    "type": "string"
+                },
+                "time": {
+                    "type": "string"
+                }
+            }
+        },
+        "storage.DecisionStep": {
+            "type": "object",
+            "properties": {
+                "detail": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "passed": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "storage.Enrollment": {
+            "type": "object",
+            "properties": {
+                "certificate": {
+                    "type": "string"
+                },
+                "decided_at": {
+                    "type": "string"
+                },
+                "decided_by": {
+                    "description": "Admin or auto-approval rule that decided on the enrollment",
+                    "type": "string"
+                },
+                "fingerprint": {
+                    "type": "string"
+                },
+                "host": {
+                    "type": "string"
+                },
+                "hostgroup": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "publickey": {
+                    "description": "Host public key in authorized_keys format",
+                    "type": "string"
+                },
+                "remote_addr": {
+                    "description": "Address the request was received from",
+                    "type": "string"
+                },
+                "requested_at": {
+                    "type": "string"
+                },
+                "serial": {
+                    "description": "Host certificate issued for approved enrollments",
+                    "type": "integer"
+                },
+                "state": {
+                    "type": "string",
+                    "example": "pending"
+                }
+            }
+        },
+        "storage.Freeze": {
+            "type": "object",
+            "properties": {
+                "frozen": {
+                    "description": "Whether issuance is paused, false lifts scheduled freeze windows",
+                    "type": "boolean"
+                },
+                "hostgroup": {
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string",
+                    "example": "Incident 2024-17"
+                },
+                "set_at": {
+                    "type": "string"
+                },
+                "set_by": {
+                    "type": "string"
+                },
+                "until": {
+                    "description": "Zero if the freeze lasts until it is removed",
+                    "type": "string"
+                }
+            }
+        },
+        "storage.Promotion": {
+            "type": "object",
+            "properties": {
+                "at": {
+                    "type": "string"
+                },
+                "by": {
+                    "type": "string"
+                }
+            }
+        },
+        "storage.Revocation": {
+            "type": "object",
+            "properties": {
+                "ca": {
+                    "type": "string"
+                },
+                "reason": {
+                    "description": "One of RevocationReasons, empty for revocations recorded before\nreasons were required",
+                    "type": "string",
+                    "example": "key-compromise"
+                },
+                "revoked_at": {
+                    "type": "string"
+                },
+                "serial": {
+                    "type": "integer"
+                },
+                "valid_before": {
+                    "description": "Expiry of the revoked certificate, after which the revocation is\nirrelevant",
+                    "type": "string"
+                }
+            }
+        }
+    },
+    "securityDefinitions": {
+        "AdminToken": {
+            "description": "Admin token or OIDC access token of an admin, prefixed with \"Bearer \".",
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
         }
     }
 }`
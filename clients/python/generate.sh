@@ -0,0 +1,25 @@
+#!/bin/sh
+
+# Generates the Python package "oinitca" from the Swagger files of the CA API
+# (see 'make swagger') into clients/python. The generated files are not
+# committed, so the package is always in sync with the API it is built with.
+#
+# openapi-generator-cli is used if installed, otherwise its docker image.
+
+set -eu
+
+GENERATOR_VERSION="v7.0.1"
+
+cd "$(dirname "$0")/../.."
+
+VERSION="$(cat VERSION)"
+
+if command -v openapi-generator-cli > /dev/null; then
+    set -- openapi-generator-cli
+else
+    set -- docker run --rm --user "$(id -u):$(id -g)" --volume "$(pwd):/local" --workdir /local \
+        "openapitools/openapi-generator-cli:${GENERATOR_VERSION}"
+fi
+
+"$@" generate --config clients/python/openapi-generator.yaml \
+    --additional-properties "packageVersion=${VERSION}"
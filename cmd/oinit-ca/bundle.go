@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/lbrocke/oinit/internal/bundle"
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/mattn/go-tty"
+)
+
+// readPassphrase returns the bundle passphrase from the environment or, if
+// not set, prompts for it on the TTY. If confirm is true, the passphrase must
+// be entered twice.
+func readPassphrase(confirm bool) (string, error) {
+	if pass := os.Getenv(ENV_PASSPHRASE); pass != "" {
+		return pass, nil
+	}
+
+	t, err := tty.Open()
+	if err != nil {
+		return "", err
+	}
+	defer t.Close()
+
+	fmt.Fprint(t.Output(), "Passphrase: ")
+	pass, err := t.ReadPasswordNoEcho()
+	if err != nil {
+		return "", err
+	}
+
+	if confirm {
+		fmt.Fprint(t.Output(), "Repeat passphrase: ")
+		repeat, err := t.ReadPasswordNoEcho()
+		if err != nil {
+			return "", err
+		}
+
+		if pass != repeat {
+			return "", errors.New("passphrases do not match")
+		}
+	}
+
+	return pass, nil
+}
+
+// handleCommandExport handles the 'export' command, which writes the config
+// file and all referenced keys into an encrypted bundle.
+func handleCommandExport(args []string) {
+	if len(args) != 2 {
+		log.Fatal(USAGE)
+	}
+
+	cfg, err := config.Load(args[0])
+	if err != nil {
+		log.Fatalln("Error while loading config: " + err.Error())
+	}
+
+	// The storage file doesn't exist before the first certificate is issued.
+	b, err := bundle.New(args[0], cfg.Files(), []string{storage.Path(cfg.Server.Storage)})
+	if err != nil {
+		log.Fatalln("Error while reading files: " + err.Error())
+	}
+
+	pass, err := readPassphrase(true)
+	if err != nil {
+		log.Fatalln("Error while reading passphrase: " + err.Error())
+	}
+
+	data, err := b.Encrypt(pass)
+	if err != nil {
+		log.Fatalln("Error while encrypting bundle: " + err.Error())
+	}
+
+	if err := os.WriteFile(args[1], data, 0600); err != nil {
+		log.Fatalln("Error while writing bundle: " + err.Error())
+	}
+
+	log.Printf("Exported %d files to %s", len(b.Files), args[1])
+}
+
+// handleCommandImport handles the 'import' command, which restores all files
+// from a bundle below the given root directory (default: /).
+func handleCommandImport(args []string) {
+	if len(args) != 1 && len(args) != 2 {
+		log.Fatal(USAGE)
+	}
+
+	root := string(os.PathSeparator)
+	if len(args) == 2 {
+		root = args[1]
+	}
+
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		log.Fatalln("Error while reading bundle: " + err.Error())
+	}
+
+	pass, err := readPassphrase(false)
+	if err != nil {
+		log.Fatalln("Error while reading passphrase: " + err.Error())
+	}
+
+	b, err := bundle.Decrypt(data, pass)
+	if err != nil {
+		log.Fatalln("Error while decrypting bundle: " + err.Error())
+	}
+
+	written, err := b.Restore(root)
+	if err != nil {
+		log.Fatalln("Error while restoring files: " + err.Error())
+	}
+
+	log.Printf("Restored %d files from bundle created at %s:\n\t%s",
+		len(written), b.Created, strings.Join(written, "\n\t"))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/lbrocke/oinit/internal/config"
+	pkglog "github.com/lbrocke/oinit/pkg/log"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	CONFIG_PRINT_DEFAULTS = "print-defaults"
+	CONFIG_DUMP           = "dump"
+)
+
+// handleCommandConfig handles the 'config' command, which prints the default
+// values of all options, or the configuration as written or as resolved by
+// the CA, in YAML.
+func handleCommandConfig(args []string) {
+	if len(args) == 0 {
+		log.Fatal(USAGE)
+	}
+
+	var out interface{}
+
+	switch args[0] {
+	case CONFIG_PRINT_DEFAULTS:
+		if len(args) != 1 {
+			log.Fatal(USAGE)
+		}
+
+		out = config.Defaults()
+	case CONFIG_DUMP:
+		flags := flag.NewFlagSet(CONFIG_DUMP, flag.ExitOnError)
+		effective := flags.Bool("effective", false, "resolve defaults and overrides")
+		flags.Parse(args[1:])
+
+		if flags.NArg() != 1 {
+			log.Fatal(USAGE)
+		}
+
+		if *effective {
+			cfg, err := config.Load(flags.Arg(0))
+			if err != nil {
+				pkglog.LogFatal("Error while loading config: " + err.Error())
+			}
+
+			out = cfg.Effective()
+		} else {
+			raw, err := config.Raw(flags.Arg(0))
+			if err != nil {
+				pkglog.LogFatal("Error while reading config: " + err.Error())
+			}
+
+			out = raw
+		}
+	default:
+		log.Fatal(USAGE)
+	}
+
+	encoder := yaml.NewEncoder(os.Stdout)
+	encoder.SetIndent(2)
+
+	if err := encoder.Encode(out); err != nil {
+		pkglog.LogFatal("Error while encoding config: " + err.Error())
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/lbrocke/oinit/internal/api"
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/storage"
+	pkglog "github.com/lbrocke/oinit/pkg/log"
+)
+
+const (
+	NSUPDATE = "nsupdate"
+)
+
+// nsupdateScript returns input for nsupdate that replaces all SSHFP and CERT
+// records of the hosts contained in records.
+func nsupdateScript(server string, records []string) string {
+	var script strings.Builder
+
+	script.WriteString("server " + server + "\n")
+
+	deleted := make(map[string]bool)
+	for _, record := range records {
+		name, _, _ := strings.Cut(record, " ")
+		if !deleted[name] {
+			script.WriteString("update delete " + name + " SSHFP\n")
+			script.WriteString("update delete " + name + " CERT\n")
+			deleted[name] = true
+		}
+	}
+
+	for _, record := range records {
+		script.WriteString("update add " + record + "\n")
+	}
+
+	script.WriteString("send\n")
+
+	return script.String()
+}
+
+// handleCommandDNS handles the 'dns' command, which prints DNS records of the
+// host keys reported by hosts, or pushes them to a DNS server by dynamic
+// update (RFC 2136) using nsupdate.
+func handleCommandDNS(args []string) {
+	flags := flag.NewFlagSet(COMMAND_DNS, flag.ExitOnError)
+	ttl := flags.Int("ttl", api.DEFAULT_DNS_TTL, "TTL of the records")
+	server := flags.String("nsupdate", "", "DNS server to push records to")
+	tsigKey := flags.String("tsig-key", "", "TSIG key file passed to nsupdate")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 || *ttl <= 0 {
+		log.Fatal(USAGE)
+	}
+
+	cfg, err := config.Load(flags.Arg(0))
+	if err != nil {
+		pkglog.LogFatal("Error while loading config: " + err.Error())
+	}
+
+	store, err := storage.Open(cfg.Server.Storage, cfg.Server.StorageOptions()...)
+	if err != nil {
+		pkglog.LogFatal("Error while opening storage: " + err.Error())
+	}
+	defer store.Close()
+
+	records, err := api.DNSRecords(cfg, store, *ttl)
+	if err != nil {
+		store.Close()
+		pkglog.LogFatal("Error while generating records: " + err.Error())
+	}
+
+	if *server == "" {
+		for _, record := range records {
+			fmt.Println(record)
+		}
+		return
+	}
+
+	if len(records) == 0 {
+		pkglog.LogWarn("No host has reported its host keys yet.")
+		return
+	}
+
+	nsupdateArgs := []string{}
+	if *tsigKey != "" {
+		nsupdateArgs = append(nsupdateArgs, "-k", *tsigKey)
+	}
+
+	cmd := exec.Command(NSUPDATE, nsupdateArgs...)
+	cmd.Stdin = strings.NewReader(nsupdateScript(*server, records))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		store.Close()
+		pkglog.LogFatal("nsupdate failed: " + err.Error())
+	}
+
+	pkglog.LogSuccess(fmt.Sprintf("Pushed %d records to %s.", len(records), *server))
+}
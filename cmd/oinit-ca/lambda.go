@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/lbrocke/oinit/internal/api"
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/lambda"
+	"github.com/lbrocke/oinit/internal/server"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/exp/slices"
+)
+
+// Actor of audit events of configuration changes detected when a Lambda
+// environment loads the config
+const AUDIT_ACTOR_LAMBDA = "oinit-ca lambda"
+
+// handleCommandLambda handles the 'lambda' command, which serves the CA REST
+// API as an AWS Lambda function, see deploy/lambda.
+func handleCommandLambda(args []string) {
+	flags := flag.NewFlagSet(COMMAND_LAMBDA, flag.ExitOnError)
+	mode := flags.String("mode", MODE_API, "routes to serve: api, admin, all or health")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		log.Fatal(USAGE)
+	}
+
+	if !slices.Contains(config.ListenModes, *mode) {
+		log.Fatalln("Unknown mode: " + *mode)
+	}
+
+	runtime, err := lambda.NewRuntime()
+	if err != nil {
+		log.Fatalln("Error while starting: " + err.Error())
+	}
+
+	gin.SetMode(gin.ReleaseMode)
+	server.InitSwagger()
+
+	// The config and keys are loaded by the first invocation, which is not
+	// subject to the time limit of the initialization
+	handler := lambda.Lazy(func() (http.Handler, error) {
+		return lambdaHandler(flags.Arg(0), *mode)
+	})
+
+	if err := runtime.Serve(handler); err != nil {
+		log.Fatalln("Error while serving: " + err.Error())
+	}
+}
+
+// lambdaHandler loads the config and returns the handler serving the routes
+// of the mode. Listeners declared in the config don't apply, and neither do
+// the sandbox options, as Lambda environments are sandboxed by AWS. Clock
+// checks are disabled, as AWS synchronizes the clock and NTP is usually not
+// reachable. The memory backend is used by default, with random serial
+// numbers.
+func lambdaHandler(path, mode string) (http.Handler, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, errors.New("could not load config: " + err.Error())
+	}
+
+	// Lambda functions can't sync the replicated state in the background
+	if cfg.Server.Replica {
+		return nil, errors.New("replica is not supported in AWS Lambda")
+	}
+
+	// Instances are short-lived and the default storage path is not
+	// writable, so state is kept in memory unless another file is
+	// configured. Serial numbers restart with each instance, and must not
+	// collide with those of other instances and regular CAs sharing the keys.
+	backend := cfg.Server.Storage
+	if backend == config.DEFAULT_STORAGE {
+		backend = storage.BACKEND_MEMORY
+	}
+
+	opts := cfg.Server.StorageOptions()
+	if storage.Path(backend) == "" {
+		opts = append(opts, storage.WithRandomSerials())
+	}
+
+	store, err := storage.Open(backend, opts...)
+	if err != nil {
+		return nil, errors.New("could not open storage: " + err.Error())
+	}
+
+	if err := api.RecordConfig(store, cfg, AUDIT_ACTOR_LAMBDA); err != nil {
+		log.Println("Could not record configuration changes: " + err.Error())
+	}
+
+	if cfg.Server.User != "" || cfg.Server.Chroot != "" || cfg.Server.Sandbox != "" {
+		log.Println("Warning: user, chroot and sandbox are ignored in AWS Lambda")
+	}
+
+	return server.NewHandler(cfg, store, nil, server.DefaultListener(cfg, "", 0, mode)), nil
+}
@@ -1,72 +1,170 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"strings"
 
-	docs "github.com/lbrocke/oinit/api/docs"
 	"github.com/lbrocke/oinit/internal/api"
-	"github.com/lbrocke/oinit/internal/config"
-
-	"github.com/gin-gonic/gin"
+	"github.com/lbrocke/oinit/internal/buildinfo"
 )
 
 const (
-	USAGE = "Usage: oinit-ca <host:port> <path/to/config>"
+	COMMAND_SERVE        = "serve"
+	COMMAND_LAMBDA       = "lambda"
+	COMMAND_CHECK_CONFIG = "check-config"
+	COMMAND_KEYGEN       = "keygen"
+	COMMAND_REVOKE       = "revoke"
+	COMMAND_SIGN         = "sign"
+	COMMAND_EXPORT       = "export"
+	COMMAND_IMPORT       = "import"
+	COMMAND_DOCTOR       = "doctor"
+	COMMAND_DNS          = "dns"
+	COMMAND_CONFIG       = "config"
+	COMMAND_PROBE        = "probe"
+	COMMAND_VERIFY_LOGIN = "verify-login"
+	COMMAND_STATS        = "stats"
+	FLAG_VERSION         = "--version"
+
+	USAGE = "Usage:\n" +
+		"\toinit-ca serve [--listen <host:port>|unix:<path>] [--socket-mode 0660]\n" +
+		"\t\t[--mode api|admin|all|health] [--allow-core-dumps] [--no-mlock] <path/to/config>\n" +
+		"\t\tRun the CA. The mode selects whether the public API, the admin API\n" +
+		"\t\tand dashboard, both, or only health and metrics are served (default:\n" +
+		"\t\tall). Listeners declared in the config replace --listen and --mode.\n" +
+		"\t\tIf started by systemd socket activation, the passed socket is used.\n" +
+		"\t\tThe CA refuses to run with core dumps enabled and locks its memory,\n" +
+		"\t\twhich requires CAP_IPC_LOCK, unless overridden.\n" +
+		"\toinit-ca lambda [--mode api|admin|all|health] <path/to/config>\n" +
+		"\t\tRun the CA as an AWS Lambda function behind API Gateway or a\n" +
+		"\t\tfunction URL (default mode: api). The config is loaded by the first\n" +
+		"\t\trequest. See deploy/lambda for state that is kept per instance.\n" +
+		"\toinit-ca check-config <path/to/config>\n" +
+		"\t\tCheck that the config and all keys it references can be loaded.\n" +
+		"\toinit-ca keygen [-t ed25519|ecdsa|rsa|shared] [-b bits] <path>\n" +
+		"\t\tGenerate a CA key pair, or a shared key for signing the force-command.\n" +
+		"\toinit-ca revoke <path/to/config> <serial> <reason>\n" +
+		"\t\tRevoke a certificate for the reason key-compromise, user-left,\n" +
+		"\t\tpolicy-violation or superseded. Stop the CA first if file storage\n" +
+		"\t\tis used.\n" +
+		"\toinit-ca sign --host <host> --pubkey <path> --principal <user> [--principal ...]\n" +
+		"\t\t--reason <text> [--validity <seconds>] [--out <path>] <path/to/config>\n" +
+		"\t\tSign a user certificate without the REST API and motley_cue, e.g.\n" +
+		"\t\twhile either is down. The policy of the host applies and the\n" +
+		"\t\tcertificate is audited with the reason. Stop the CA first if file\n" +
+		"\t\tstorage is used.\n" +
+		"\toinit-ca export <path/to/config> <path/to/bundle>\n" +
+		"\t\tExport an encrypted disaster-recovery bundle.\n" +
+		"\toinit-ca import <path/to/bundle> [root]\n" +
+		"\t\tRestore files from a disaster-recovery bundle.\n" +
+		"\toinit-ca doctor <path/to/config>\n" +
+		"\t\tCheck the CA setup for problems.\n" +
+		"\toinit-ca dns [--ttl <seconds>] [--nsupdate <server>] [--tsig-key <path>]\n" +
+		"\t\t<path/to/config>\n" +
+		"\t\tPrint SSHFP and CERT records of the host keys reported by hosts, or\n" +
+		"\t\tpush them to the given DNS server using nsupdate.\n" +
+		"\toinit-ca config print-defaults\n" +
+		"\t\tPrint the default values of all options in YAML.\n" +
+		"\toinit-ca config dump [--effective] <path/to/config>\n" +
+		"\t\tPrint the config in YAML. With --effective, all defaults and\n" +
+		"\t\toverrides are resolved as applied by the CA. Secrets are masked.\n" +
+		"\toinit-ca probe [--url <url>|<host:port>|unix:<path>] [--check liveness|readiness]\n" +
+		"\t\t[--timeout 5s] [--ca-file <path>] [--insecure]\n" +
+		"\t\tCheck a running CA for container health checks. Exits with 1 if the\n" +
+		"\t\tCA is unhealthy and 2 if it can't be reached. Liveness checks pass\n" +
+		"\t\tif the CA is degraded, readiness checks don't (default: readiness).\n" +
+		"\toinit-ca verify-login --user <name> [--principals <path>] [--krl <path>]\n" +
+		"\t\t[--from <ip>] [--at <time>] <path/to/cert> <path/to/TrustedUserCAKeys>\n" +
+		"\t\tSimulate the validation of a user certificate by sshd with the\n" +
+		"\t\tgiven AuthorizedPrincipalsFile and RevokedKeys file, and report why\n" +
+		"\t\ta login would fail. The time defaults to now, in RFC 3339 format.\n" +
+		"\toinit-ca stats [--json] <path/to/config>\n" +
+		"\t\tPrint anonymous usage statistics of the last 30 days, as served at\n" +
+		"\t\t/api/v1/stats if usage-stats is enabled.\n" +
+		"\toinit-ca --version\n" +
+		"\t\tPrint the version, git commit, build date and signing backends.\n"
 
-	SWAGGER_TITLE = "oinit CA API"
-	SWAGGER_DESC  = "Swagger documentation for the oinit CA REST API."
+	// Environment variable that may contain the bundle passphrase, so
+	// export and import can be run non-interactively.
+	ENV_PASSPHRASE = "OINIT_CA_PASSPHRASE"
 )
 
-// ConfigMiddleware is a middleware function that attaches a configuration object
-// to the Gin context. This allows handlers downstream to access the configuration.
-func ConfigMiddleware(config config.Config) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		c.Set("config", config)
-		c.Next()
-	}
+// Set at build time using -ldflags "-X main.version=...", see Makefile and
+// .goreleaser.yaml.
+var (
+	version = ""
+	commit  = ""
+	date    = ""
+)
+
+func init() {
+	buildinfo.Set(version, commit, date)
 }
 
+// @securityDefinitions.apikey	AdminToken
+// @in							header
+// @name						Authorization
+// @description				Admin token or OIDC access token of an admin, prefixed with "Bearer ".
 func main() {
 	args := os.Args[1:]
-	if len(args) != 2 {
-		log.Fatalln(USAGE)
+	if len(args) == 0 {
+		log.Fatal(USAGE)
 	}
 
-	addr := args[0]
-	conf := args[1]
-
-	cfg, err := config.Load(conf)
-	if err != nil {
-		log.Fatalln("Error while loading config: " + err.Error())
-	}
-
-	gin.SetMode(gin.ReleaseMode)
-
-	router := gin.Default()
-	router.Use(ConfigMiddleware(cfg))
-
-	gAPI := router.Group("/api")
-	{
-		gAPI.GET("/docs/*any", api.GetSwagger)
-
-		v1 := gAPI.Group("/v1")
-		{
-			v1.GET("/", api.GetIndex)
-			v1.GET("/:host", api.GetHost)
-			// Although from the client perspective this route _gets_ a certificate, it
-			//  a) generates a new certificate every time (and thus is not cacheable), and
-			//  b) must accept an access token (which is a sensitive information better
-			//     transmitted in the request body, not as query parameter).
-			// Therefore this route uses the POST method rather then GET.
-			v1.POST("/:host/certificate", api.PostHostCertificate)
+	switch args[0] {
+	case COMMAND_SERVE:
+		handleCommandServe(args[1:])
+	case COMMAND_LAMBDA:
+		handleCommandLambda(args[1:])
+	case COMMAND_CHECK_CONFIG:
+		handleCommandCheckConfig(args[1:])
+	case COMMAND_KEYGEN:
+		handleCommandKeygen(args[1:])
+	case COMMAND_REVOKE:
+		handleCommandRevoke(args[1:])
+	case COMMAND_SIGN:
+		handleCommandSign(args[1:])
+	case COMMAND_EXPORT:
+		handleCommandExport(args[1:])
+	case COMMAND_IMPORT:
+		handleCommandImport(args[1:])
+	case COMMAND_DOCTOR:
+		handleCommandDoctor(args[1:])
+	case COMMAND_DNS:
+		handleCommandDNS(args[1:])
+	case COMMAND_CONFIG:
+		handleCommandConfig(args[1:])
+	case COMMAND_PROBE:
+		handleCommandProbe(args[1:])
+	case COMMAND_VERIFY_LOGIN:
+		handleCommandVerifyLogin(args[1:])
+	case COMMAND_STATS:
+		handleCommandStats(args[1:])
+	case FLAG_VERSION:
+		handleFlagVersion()
+	default:
+		// Previous versions were invoked as 'oinit-ca <host:port> <config>'.
+		if len(args) == 2 && strings.Contains(args[0], ":") {
+			log.Println("Deprecated invocation, please use 'oinit-ca serve --listen " + args[0] + " " + args[1] + "'.")
+			protectMemory(false, false)
+			serve(args[0], DEFAULT_SOCKET_MODE, MODE_ALL, args[1])
+			return
 		}
+
+		log.Fatal(USAGE)
 	}
+}
 
-	docs.SwaggerInfo.Version = api.API_VERSION
-	docs.SwaggerInfo.BasePath = "/api/v1"
-	docs.SwaggerInfo.Title = SWAGGER_TITLE
-	docs.SwaggerInfo.Description = SWAGGER_DESC
+// handleFlagVersion prints the build information that is also returned by
+// GET /api/v1/, so the binary can be compared to a running CA.
+func handleFlagVersion() {
+	info := buildinfo.Get()
 
-	router.Run(addr)
+	fmt.Println("oinit-ca " + info.Version)
+	fmt.Println("API version:      " + api.API_VERSION)
+	fmt.Println("Commit:           " + info.Commit)
+	fmt.Println("Date:             " + info.Date)
+	fmt.Println("Go version:       " + info.GoVersion)
+	fmt.Println("Signing backends: " + strings.Join(info.Backends, ", "))
 }
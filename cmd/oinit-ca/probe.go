@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/api"
+	"github.com/lbrocke/oinit/internal/listener"
+)
+
+const (
+	// Liveness checks pass if the CA answers at all, readiness checks only
+	// if it is healthy and can issue valid certificates.
+	PROBE_LIVENESS  = "liveness"
+	PROBE_READINESS = "readiness"
+
+	DEFAULT_PROBE_TIMEOUT = 5 * time.Second
+
+	// Exit codes of the probe command
+	EXIT_PROBE_UNHEALTHY   = 1
+	EXIT_PROBE_UNREACHABLE = 2
+
+	PROBE_HEALTH_PATH = "/api/v1/health"
+)
+
+// probeClient returns an HTTP client and base URL for the target, which is
+// an http(s) URL, a host:port as accepted by --listen of serve, or a unix
+// socket path prefixed with "unix:".
+func probeClient(target, caFile string, insecure bool, timeout time.Duration) (*http.Client, string, error) {
+	client := &http.Client{Timeout: timeout}
+
+	if path, isUnix := strings.CutPrefix(target, listener.PREFIX_UNIX); isUnix {
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		}
+
+		return client, "http://unix", nil
+	}
+
+	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+		target = "http://" + target
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecure}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, "", err
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, "", errors.New("no certificates found in " + caFile)
+		}
+	}
+
+	client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+
+	return client, strings.TrimSuffix(target, "/"), nil
+}
+
+// probe checks the health endpoint of the CA and returns 0 if the check
+// passes, or the exit code and reason of the failure.
+func probe(client *http.Client, base, check string) (int, error) {
+	res, err := client.Get(base + PROBE_HEALTH_PATH)
+	if err != nil {
+		return EXIT_PROBE_UNREACHABLE, err
+	}
+	defer res.Body.Close()
+
+	var health api.ApiResponseHealth
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<16))
+	if err != nil || json.Unmarshal(body, &health) != nil || health.Status == "" {
+		return EXIT_PROBE_UNHEALTHY, fmt.Errorf("unexpected response with status %d", res.StatusCode)
+	}
+
+	// A degraded CA is alive, but must not receive requests
+	if check == PROBE_LIVENESS && (res.StatusCode == http.StatusOK || res.StatusCode == http.StatusServiceUnavailable) {
+		return 0, nil
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return EXIT_PROBE_UNHEALTHY, fmt.Errorf("CA is %s (status %d)", health.Status, res.StatusCode)
+	}
+
+	return 0, nil
+}
+
+// handleCommandProbe handles the 'probe' command, which checks a running CA
+// for container health checks in images without curl or wget.
+func handleCommandProbe(args []string) {
+	flags := flag.NewFlagSet(COMMAND_PROBE, flag.ExitOnError)
+	target := flags.String("url", DEFAULT_LISTEN, "URL, host:port or unix:<path> of the CA")
+	check := flags.String("check", PROBE_READINESS, "liveness or readiness")
+	timeout := flags.Duration("timeout", DEFAULT_PROBE_TIMEOUT, "timeout of the check")
+	caFile := flags.String("ca-file", "", "PEM file of CA certificates to verify TLS with")
+	insecure := flags.Bool("insecure", false, "do not verify the TLS certificate")
+	flags.Parse(args)
+
+	if flags.NArg() != 0 || (*check != PROBE_LIVENESS && *check != PROBE_READINESS) {
+		log.Fatal(USAGE)
+	}
+
+	client, base, err := probeClient(*target, *caFile, *insecure, *timeout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error while configuring probe: "+err.Error())
+		os.Exit(EXIT_PROBE_UNREACHABLE)
+	}
+
+	if code, err := probe(client, base, *check); code != 0 {
+		fmt.Fprintln(os.Stderr, *check+" check failed: "+err.Error())
+		os.Exit(code)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/api"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbe(t *testing.T) {
+	status := http.StatusOK
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, PROBE_HEALTH_PATH, r.URL.Path)
+
+		health := api.ApiResponseHealth{Status: api.HEALTH_OK}
+		if status != http.StatusOK {
+			health.Status = api.HEALTH_DEGRADED
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(health)
+	})
+
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	client, base, err := probeClient(srv.Listener.Addr().String(), "", false, time.Second)
+	require.NoError(t, err)
+
+	code, err := probe(client, base, PROBE_READINESS)
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+
+	// Degraded CAs are alive, but not ready
+	status = http.StatusServiceUnavailable
+
+	code, _ = probe(client, base, PROBE_LIVENESS)
+	assert.Equal(t, 0, code)
+
+	code, err = probe(client, base, PROBE_READINESS)
+	assert.Equal(t, EXIT_PROBE_UNHEALTHY, code)
+	assert.EqualError(t, err, "CA is degraded (status 503)")
+
+	// Unix sockets as passed to --listen
+	path := filepath.Join(t.TempDir(), "oinit-ca.sock")
+	l, err := net.Listen("unix", path)
+	require.NoError(t, err)
+
+	status = http.StatusOK
+	go http.Serve(l, handler)
+	defer l.Close()
+
+	client, base, err = probeClient("unix:"+path, "", false, time.Second)
+	require.NoError(t, err)
+
+	code, err = probe(client, base, PROBE_READINESS)
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+
+	// CAs that are not running
+	srv.Close()
+	client, base, _ = probeClient(srv.URL, "", false, time.Second)
+
+	code, _ = probe(client, base, PROBE_LIVENESS)
+	assert.Equal(t, EXIT_PROBE_UNREACHABLE, code)
+}
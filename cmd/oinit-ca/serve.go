@@ -0,0 +1,271 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/api"
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/listener"
+	"github.com/lbrocke/oinit/internal/memprotect"
+	"github.com/lbrocke/oinit/internal/ntp"
+	"github.com/lbrocke/oinit/internal/sandbox"
+	"github.com/lbrocke/oinit/internal/server"
+	"github.com/lbrocke/oinit/internal/storage"
+	"github.com/lbrocke/oinit/internal/subjects"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// The public API used by clients
+	MODE_API = config.LISTEN_MODE_API
+	// The admin API and dashboard
+	MODE_ADMIN = config.LISTEN_MODE_ADMIN
+	// Both of the above
+	MODE_ALL = config.LISTEN_MODE_ALL
+	// Health and metrics without authentication, for internal addresses
+	MODE_HEALTH = config.LISTEN_MODE_HEALTH
+
+	DEFAULT_LISTEN = "127.0.0.1:8080"
+
+	// Permissions of a unix socket, so a reverse proxy in the same group
+	// can connect.
+	DEFAULT_SOCKET_MODE os.FileMode = 0660
+
+	// Interval of clock checks against the NTP server
+	NTP_CHECK_INTERVAL = 15 * time.Minute
+
+	// Actor of audit events of configuration changes and key rotations,
+	// which are detected at startup
+	AUDIT_ACTOR_SERVE = "oinit-ca serve"
+)
+
+// handleCommandServe handles the 'serve' command, which runs the CA REST API.
+func handleCommandServe(args []string) {
+	flags := flag.NewFlagSet(COMMAND_SERVE, flag.ExitOnError)
+	listen := flags.String("listen", DEFAULT_LISTEN, "address to listen on")
+	mode := flags.String("mode", MODE_ALL, "routes to serve: api, admin, all or health")
+	socketMode := flags.String("socket-mode", fmt.Sprintf("%#o", DEFAULT_SOCKET_MODE), "permissions of the unix socket")
+	allowCoreDumps := flags.Bool("allow-core-dumps", false, "run even if core dumps are enabled")
+	noMlock := flags.Bool("no-mlock", false, "do not lock memory")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		log.Fatal(USAGE)
+	}
+
+	if !slices.Contains(config.ListenModes, *mode) {
+		log.Fatalln("Unknown mode: " + *mode)
+	}
+
+	perm, err := strconv.ParseUint(*socketMode, 8, 32)
+	if err != nil {
+		log.Fatalln("Invalid socket mode: " + *socketMode)
+	}
+
+	// Before the private keys are loaded with the config
+	protectMemory(*allowCoreDumps, *noMlock)
+
+	serve(*listen, os.FileMode(perm), *mode, flags.Arg(0))
+}
+
+// protectMemory refuses to run if core dumps are enabled, which would write
+// the private keys to disk, and locks the memory so they aren't swapped out.
+// Both can be overridden for environments that don't support them.
+func protectMemory(allowCoreDumps, noMlock bool) {
+	enabled, err := memprotect.CoreDumpsEnabled()
+	if err != nil {
+		log.Fatalln("Could not check whether core dumps are enabled: " + err.Error())
+	}
+
+	if enabled && !allowCoreDumps {
+		log.Fatalln("Core dumps are enabled and could leak CA private keys, disable them (ulimit -c 0, LimitCORE=0) or pass --allow-core-dumps.")
+	}
+
+	if noMlock {
+		log.Println("Warning: memory is not locked, CA private keys may be swapped to disk")
+		return
+	}
+
+	if err := memprotect.LockMemory(); errors.Is(err, memprotect.ErrUnsupported) {
+		log.Println("Warning: memory can't be locked on this platform, CA private keys may be swapped to disk")
+	} else if err != nil {
+		log.Fatalln("Could not lock memory: " + err.Error() + ". Grant CAP_IPC_LOCK, raise LimitMEMLOCK or pass --no-mlock.")
+	}
+}
+
+// listen binds the address of the listener and wraps it in TLS if
+// configured. The socket passed by systemd, if any, is used instead of the
+// address of the default listener.
+func listen(l config.Listener, systemd net.Listener) (net.Listener, error) {
+	nl := systemd
+
+	if nl == nil {
+		var err error
+		if nl, err = listener.Listen(l.Address, l.Perm); err != nil {
+			return nil, err
+		}
+	}
+
+	if l.PathTLSCert == "" {
+		return nl, nil
+	}
+
+	// Loaded now, as the files may not be accessible after sandboxing
+	cert, err := tls.LoadX509KeyPair(l.PathTLSCert, l.PathTLSKey)
+	if err != nil {
+		nl.Close()
+		return nil, err
+	}
+
+	return tls.NewListener(nl, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}), nil
+}
+
+// serve runs the routes of the given mode on the given address, which may be
+// a unix socket ("unix:/path"). If the process was socket-activated by
+// systemd, the passed socket is used instead. If the config declares
+// listeners, all of them are served instead of the given address.
+func serve(addr string, socketMode os.FileMode, mode, conf string) {
+	cfg, err := config.Load(conf)
+	if err != nil {
+		log.Fatalln("Error while loading config: " + err.Error())
+	}
+
+	store, err := server.OpenStore(cfg)
+	if err != nil {
+		log.Fatalln("Error while opening storage: " + err.Error())
+	}
+	defer store.Close()
+
+	if storage.Path(cfg.Server.Storage) == "" {
+		log.Println("Warning: using memory storage, serial numbers, revocations and the audit trail are lost on restart")
+	}
+
+	if err := api.RecordConfig(store, cfg, AUDIT_ACTOR_SERVE); err != nil {
+		log.Println("Could not record configuration changes: " + err.Error())
+	}
+
+	gin.SetMode(gin.ReleaseMode)
+
+	monitor := monitorClock(cfg)
+
+	server.InitSwagger()
+
+	listeners := cfg.Listeners
+	if len(listeners) == 0 {
+		listeners = []config.Listener{server.DefaultListener(cfg, addr, socketMode, mode)}
+	}
+
+	systemd, err := listener.Systemd()
+	if err != nil {
+		log.Fatalln("Error while using systemd socket: " + err.Error())
+	}
+
+	// Only a single socket is passed by systemd, which cannot be assigned
+	// to one of multiple listeners
+	if systemd != nil && len(cfg.Listeners) > 0 {
+		log.Fatalln("Error while using systemd socket: listeners are declared in the config")
+	}
+
+	bound := make([]net.Listener, len(listeners))
+	for i, l := range listeners {
+		if bound[i], err = listen(l, systemd); err != nil {
+			log.Fatalln("Error while listening on " + l.Address + ": " + err.Error())
+		}
+	}
+
+	if err := restrict(cfg); err != nil {
+		log.Fatalln("Error while sandboxing: " + err.Error())
+	}
+
+	errs := make(chan error)
+
+	for i, l := range listeners {
+		handler := server.NewHandler(cfg, store, monitor, l)
+
+		log.Printf("Listening on %s (%s, mode %s)", bound[i].Addr(), l.Name, l.Mode)
+
+		go func(nl net.Listener) {
+			errs <- http.Serve(nl, handler)
+		}(bound[i])
+	}
+
+	log.Fatalln((<-errs).Error())
+}
+
+// restrict drops privileges and applies the sandboxes of the config. This
+// happens after keys and config were loaded and the socket was bound, as
+// neither may be possible afterwards.
+func restrict(cfg config.Config) error {
+	landlock, seccomp, err := sandbox.Parse(cfg.Server.Sandbox)
+	if err != nil {
+		return err
+	}
+
+	opts := sandbox.Options{
+		User:     cfg.Server.User,
+		Chroot:   cfg.Server.Chroot,
+		Landlock: landlock,
+		Seccomp:  seccomp,
+	}
+
+	if path := storage.Path(cfg.Server.Storage); path != "" {
+		opts.WritePaths = append(opts.WritePaths, filepath.Dir(path))
+	}
+
+	// Replicas create the promote file when promoted by the admin API
+	if cfg.Server.Replica && cfg.Server.PromoteFile != "" {
+		opts.WritePaths = append(opts.WritePaths, filepath.Dir(cfg.Server.PromoteFile))
+	}
+
+	// Subject lists are reloaded when they change. Editors replace files, so
+	// their directories are allowed rather than the files.
+	for _, group := range cfg.HostGroups {
+		for _, list := range []*subjects.List{group.SubjectAllow, group.SubjectDeny} {
+			if list != nil {
+				opts.ExtraReadPaths = append(opts.ExtraReadPaths, filepath.Dir(list.Path()))
+			}
+		}
+	}
+
+	return sandbox.Apply(opts)
+}
+
+// monitorClock starts checking the local clock against the configured NTP
+// server in the background, and warns if it is skewed. nil is returned if
+// checks are disabled.
+func monitorClock(cfg config.Config) *ntp.Monitor {
+	if cfg.Server.NTPServer == config.NTP_SERVER_NONE {
+		return nil
+	}
+
+	monitor := ntp.NewMonitor(cfg.Server.NTPServer)
+	tolerance := time.Duration(cfg.Server.ClockSkewTolerance) * time.Second
+
+	go func() {
+		clock := monitor.Check()
+		if clock.Err != nil {
+			log.Println("Could not check clock against " + clock.Server + ": " + clock.Err.Error())
+		} else if clock.Skewed(tolerance) {
+			log.Printf("Warning: local clock is off by %s (tolerance %s), hosts may reject issued certificates", clock.Offset.Round(time.Millisecond), tolerance)
+		}
+
+		monitor.Run(NTP_CHECK_INTERVAL)
+	}()
+
+	return monitor
+}
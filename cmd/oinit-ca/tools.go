@@ -0,0 +1,454 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/api"
+	"github.com/lbrocke/oinit/internal/approved"
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/doctor"
+	"github.com/lbrocke/oinit/internal/storage"
+	pkglog "github.com/lbrocke/oinit/pkg/log"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	KEY_TYPE_ED25519 = "ed25519"
+	KEY_TYPE_ECDSA   = "ecdsa"
+	KEY_TYPE_RSA     = "rsa"
+	// Shared key for signing the force-command, see force-command-key
+	KEY_TYPE_SHARED = "shared"
+
+	DEFAULT_RSA_BITS   = 4096
+	DEFAULT_ECDSA_BITS = 384
+	SHARED_KEY_SIZE    = 32
+
+	// Actors of audit events caused by the command line
+	AUDIT_ACTOR_CLI  = "oinit-ca revoke"
+	AUDIT_ACTOR_SIGN = "oinit-ca sign"
+)
+
+// handleCommandDoctor handles the 'doctor' command, which runs self-tests
+// against the given config and prints remediation steps for failed checks.
+func handleCommandDoctor(args []string) {
+	if len(args) != 1 {
+		log.Fatal(USAGE)
+	}
+
+	cfg, err := config.Load(args[0])
+	if err != nil {
+		pkglog.LogFatal("Error while loading config: " + err.Error())
+	}
+
+	printResults(doctor.Run(cfg))
+}
+
+// printResults prints the results of doctor checks and exits with 1 if any
+// of them failed.
+func printResults(results []doctor.Result) {
+	for _, res := range results {
+		msg := res.Name + ": " + res.Message
+
+		switch res.Status {
+		case doctor.StatusOK:
+			pkglog.LogSuccess(msg)
+		case doctor.StatusWarn:
+			pkglog.LogWarn(msg)
+		case doctor.StatusFail:
+			pkglog.LogError(msg)
+		}
+
+		if res.Remediation != "" {
+			pkglog.Log("\t" + res.Remediation)
+		}
+	}
+
+	if doctor.Failed(results) {
+		os.Exit(1)
+	}
+}
+
+// handleCommandVerifyLogin handles the 'verify-login' command, which
+// simulates the validation of a user certificate by sshd using copies of the
+// files of the host.
+func handleCommandVerifyLogin(args []string) {
+	flags := flag.NewFlagSet(COMMAND_VERIFY_LOGIN, flag.ExitOnError)
+	user := flags.String("user", "", "account that is logged in to")
+	principals := flags.String("principals", "", "AuthorizedPrincipalsFile of the user")
+	krl := flags.String("krl", "", "RevokedKeys file")
+	from := flags.String("from", "", "address of the client")
+	at := flags.String("at", "", "time of the login in RFC 3339 format")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 || *user == "" {
+		log.Fatal(USAGE)
+	}
+
+	login := doctor.Login{User: *user, Time: time.Now()}
+
+	var err error
+	if login.Certificate, err = os.ReadFile(flags.Arg(0)); err != nil {
+		pkglog.LogFatal("Error while reading certificate: " + err.Error())
+	}
+
+	if login.TrustedUserCAKeys, err = os.ReadFile(flags.Arg(1)); err != nil {
+		pkglog.LogFatal("Error while reading trusted CA keys: " + err.Error())
+	}
+
+	if *principals != "" {
+		if login.AuthorizedPrincipals, err = os.ReadFile(*principals); err != nil {
+			pkglog.LogFatal("Error while reading principals file: " + err.Error())
+		}
+	}
+
+	if *krl != "" {
+		if login.RevokedKeys, err = os.ReadFile(*krl); err != nil {
+			pkglog.LogFatal("Error while reading KRL: " + err.Error())
+		}
+	}
+
+	if *from != "" {
+		if login.From = net.ParseIP(*from); login.From == nil {
+			pkglog.LogFatal("Invalid address " + *from + ".")
+		}
+	}
+
+	if *at != "" {
+		if login.Time, err = time.Parse(time.RFC3339, *at); err != nil {
+			pkglog.LogFatal("Invalid time " + *at + ", use RFC 3339 format.")
+		}
+	}
+
+	printResults(doctor.VerifyLogin(login))
+}
+
+// handleCommandCheckConfig handles the 'check-config' command, which loads
+// the given config including all keys and prints a summary.
+func handleCommandCheckConfig(args []string) {
+	if len(args) != 1 {
+		log.Fatal(USAGE)
+	}
+
+	cfg, err := config.Load(args[0])
+	if err != nil {
+		pkglog.LogFatal("Config is invalid: " + err.Error())
+	}
+
+	hosts := 0
+	for _, group := range cfg.HostGroups {
+		hosts += len(group.Hosts)
+	}
+
+	pkglog.LogSuccess("Config is valid.")
+	pkglog.Log(fmt.Sprintf("\t%d host groups with %d hosts", len(cfg.HostGroups), hosts))
+	pkglog.Log("\tstorage: " + cfg.Server.Storage)
+	pkglog.Log(fmt.Sprintf("\t%d admin tokens, %d admin OIDC rules", len(cfg.AdminTokens), len(cfg.AdminOIDCRules)))
+}
+
+// generateKey generates a private key of the given type and size and returns
+// it PEM encoded, as well as the public key in authorized_keys format.
+func generateKey(keyType string, bits int) ([]byte, []byte, error) {
+	var key interface{}
+	var err error
+
+	switch keyType {
+	case KEY_TYPE_ED25519:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	case KEY_TYPE_ECDSA:
+		var curve elliptic.Curve
+
+		switch bits {
+		case 256:
+			curve = elliptic.P256()
+		case 0, 384:
+			curve = elliptic.P384()
+		case 521:
+			curve = elliptic.P521()
+		default:
+			return nil, nil, fmt.Errorf("unsupported ecdsa key size %d", bits)
+		}
+
+		key, err = ecdsa.GenerateKey(curve, rand.Reader)
+	case KEY_TYPE_RSA:
+		if bits == 0 {
+			bits = DEFAULT_RSA_BITS
+		}
+
+		key, err = rsa.GenerateKey(rand.Reader, bits)
+	default:
+		return nil, nil, fmt.Errorf("unsupported key type %s", keyType)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, err := ssh.MarshalPrivateKey(key, "oinit-ca")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pem.EncodeToMemory(block), ssh.MarshalAuthorizedKey(signer.PublicKey()), nil
+}
+
+// handleCommandKeygen handles the 'keygen' command, which generates a CA key
+// pair (<path> and <path>.pub) or a shared force-command key.
+func handleCommandKeygen(args []string) {
+	defaultKeyType := KEY_TYPE_ED25519
+	if approved.FORCED {
+		defaultKeyType = KEY_TYPE_ECDSA
+	}
+
+	flags := flag.NewFlagSet(COMMAND_KEYGEN, flag.ExitOnError)
+	keyType := flags.String("t", defaultKeyType, "key type: ed25519, ecdsa, rsa or shared")
+	bits := flags.Int("b", 0, "key size in bits for ecdsa and rsa keys")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		log.Fatal(USAGE)
+	}
+
+	path := flags.Arg(0)
+
+	if _, err := os.Stat(path); err == nil {
+		pkglog.LogFatal(path + " already exists.")
+	}
+
+	if *keyType == KEY_TYPE_SHARED {
+		key := make([]byte, SHARED_KEY_SIZE)
+		if _, err := rand.Read(key); err != nil {
+			pkglog.LogFatal("Error while generating key: " + err.Error())
+		}
+
+		if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600); err != nil {
+			pkglog.LogFatal("Error while writing key: " + err.Error())
+		}
+
+		pkglog.LogSuccess("Generated shared key " + path + ".")
+		return
+	}
+
+	priv, pub, err := generateKey(*keyType, *bits)
+	if err != nil {
+		pkglog.LogFatal("Error while generating key: " + err.Error())
+	}
+
+	if approved.FORCED {
+		pk, _, _, _, _ := ssh.ParseAuthorizedKey(pub)
+		if err := approved.PublicKey(pk); err != nil {
+			pkglog.LogFatal("Generated key is not approved: " + err.Error())
+		}
+	}
+
+	if err := os.WriteFile(path, priv, 0600); err != nil {
+		pkglog.LogFatal("Error while writing private key: " + err.Error())
+	}
+
+	if err := os.WriteFile(path+".pub", pub, 0644); err != nil {
+		pkglog.LogFatal("Error while writing public key: " + err.Error())
+	}
+
+	pkglog.LogSuccess("Generated " + *keyType + " key pair " + path + " and " + path + ".pub.")
+}
+
+// handleCommandRevoke handles the 'revoke' command, which revokes a
+// certificate for the given reason directly in the storage of the given config. With file
+// storage, the CA must not be running at the same time, otherwise the
+// revocation may be overwritten; use the admin API instead.
+func handleCommandRevoke(args []string) {
+	if len(args) != 3 {
+		log.Fatal(USAGE)
+	}
+
+	if !slices.Contains(storage.RevocationReasons, args[2]) {
+		pkglog.LogFatal("Unknown reason " + args[2] + ", use one of: " + strings.Join(storage.RevocationReasons, ", "))
+	}
+
+	serial, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		log.Fatal(USAGE)
+	}
+
+	cfg, err := config.Load(args[0])
+	if err != nil {
+		pkglog.LogFatal("Error while loading config: " + err.Error())
+	}
+
+	store := openPrimaryStore(cfg)
+	defer store.Close()
+
+	revocation, err := api.Revoke(store, serial, AUDIT_ACTOR_CLI, args[2])
+	if err != nil {
+		store.Close()
+		pkglog.LogFatal("Could not revoke certificate " + args[1] + ": " + err.Error())
+	}
+
+	pkglog.LogSuccess(fmt.Sprintf("Revoked certificate %d of CA %s (%s).", revocation.Serial, revocation.CA, revocation.Reason))
+}
+
+// openPrimaryStore opens the storage of the config for commands that modify
+// it, which refuse to run on replicas that were not promoted: certificates
+// signed by them could reuse serial numbers of the primary CA, and other
+// modifications would be overwritten by replication.
+func openPrimaryStore(cfg config.Config) storage.Store {
+	if !cfg.Server.Replica {
+		store, err := storage.Open(cfg.Server.Storage, cfg.Server.StorageOptions()...)
+		if err != nil {
+			pkglog.LogFatal("Error while opening storage: " + err.Error())
+		}
+
+		return store
+	}
+
+	replica, err := storage.NewReplicaStore(storage.Path(cfg.Server.Storage), cfg.Server.PromoteFile, cfg.Server.StorageOptions()...)
+	if err != nil {
+		pkglog.LogFatal("Error while opening storage: " + err.Error())
+	}
+
+	if !replica.Promoted() {
+		replica.Close()
+		pkglog.LogFatal("This CA is a replica, run the command on the primary CA or promote the replica first.")
+	}
+
+	return replica
+}
+
+// handleCommandSign handles the 'sign' command, which signs a user
+// certificate directly with the keys and storage of the given config, for
+// emergencies in which the REST API or motley_cue is down. The request is
+// checked against the policy of the host and audited with the reason and the
+// account running the command. As with 'revoke', the CA must not be running
+// at the same time if file storage is used.
+func handleCommandSign(args []string) {
+	var principals []string
+
+	flags := flag.NewFlagSet(COMMAND_SIGN, flag.ExitOnError)
+	host := flags.String("host", "", "host the certificate is valid for")
+	pubkey := flags.String("pubkey", "", "public key to sign")
+	flags.Func("principal", "account the certificate permits logins as, may be repeated", func(value string) error {
+		principals = append(principals, value)
+		return nil
+	})
+	validity := flags.Int("validity", 0, "validity in seconds (default: cert-validity of the hostgroup)")
+	reason := flags.String("reason", "", "reason recorded in the audit trail")
+	out := flags.String("out", "", "file to write the certificate to (default: stdout)")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 || *host == "" || *pubkey == "" || len(principals) == 0 || *reason == "" {
+		log.Fatal(USAGE)
+	}
+
+	data, err := os.ReadFile(*pubkey)
+	if err != nil {
+		pkglog.LogFatal("Error while reading public key: " + err.Error())
+	}
+
+	pk, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		pkglog.LogFatal("Error while parsing public key: " + err.Error())
+	}
+
+	cfg, err := config.Load(flags.Arg(0))
+	if err != nil {
+		pkglog.LogFatal("Error while loading config: " + err.Error())
+	}
+
+	store := openPrimaryStore(cfg)
+	defer store.Close()
+
+	actor := AUDIT_ACTOR_SIGN
+	if current, err := user.Current(); err == nil {
+		actor += " (" + current.Username + ")"
+	}
+
+	cert, err := api.SignOffline(cfg, store, api.OfflineRequest{
+		Host:       *host,
+		PublicKey:  pk,
+		Principals: principals,
+		Validity:   *validity,
+		Actor:      actor,
+		Reason:     *reason,
+	})
+	if err != nil {
+		store.Close()
+		pkglog.LogFatal("Could not sign certificate: " + err.Error())
+	}
+
+	certificate := ssh.MarshalAuthorizedKey(&cert)
+
+	if *out == "" {
+		os.Stdout.Write(certificate)
+	} else if err := os.WriteFile(*out, certificate, 0644); err != nil {
+		store.Close()
+		pkglog.LogFatal("Error while writing certificate: " + err.Error())
+	}
+
+	pkglog.LogSuccess(fmt.Sprintf("Signed certificate %d for %s, valid until %s.", cert.Serial,
+		strings.Join(cert.ValidPrincipals, ","), time.Unix(int64(cert.ValidBefore), 0).UTC().Format(time.RFC3339)))
+}
+
+// handleCommandStats handles the 'stats' command, which prints anonymous
+// usage statistics computed from the storage.
+func handleCommandStats(args []string) {
+	flags := flag.NewFlagSet(COMMAND_STATS, flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the statistics as served by the API")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		log.Fatal(USAGE)
+	}
+
+	cfg, err := config.Load(flags.Arg(0))
+	if err != nil {
+		pkglog.LogFatal("Error while loading config: " + err.Error())
+	}
+
+	store, err := storage.Open(cfg.Server.Storage, cfg.Server.StorageOptions()...)
+	if err != nil {
+		pkglog.LogFatal("Error while opening storage: " + err.Error())
+	}
+	defer store.Close()
+
+	stats, err := api.Stats(store, time.Now())
+	if err != nil {
+		store.Close()
+		pkglog.LogFatal("Error while reading certificates: " + err.Error())
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(stats)
+		return
+	}
+
+	fmt.Printf("Since %s: %d certificates issued for %d hosts to %d users\n\n", stats.Since, stats.Issuances, stats.UniqueHosts, stats.UniqueUsers)
+	fmt.Printf("%-10s  %9s  %5s\n", "DATE", "ISSUANCES", "HOSTS")
+
+	for _, day := range stats.Days {
+		fmt.Printf("%-10s  %9d  %5d\n", day.Date, day.Issuances, day.UniqueHosts)
+	}
+}
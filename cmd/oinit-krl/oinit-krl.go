@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/krl"
+)
+
+const (
+	USAGE = "Usage:\n" +
+		"\toinit-krl [-interval <duration>] <ca> <host> <path>\n" +
+		"\n" +
+		"Downloads the KRL of the user CA of host from the CA (e.g.\n" +
+		"https://ca.example.com) and installs it at path, which should be set as\n" +
+		"RevokedKeys in sshd_config. Without -interval, the KRL is updated once,\n" +
+		"e.g. when run by a systemd timer.\n"
+
+	// Timeout of a single update
+	UPDATE_TIMEOUT = time.Minute
+)
+
+// update updates the KRL once and logs the result. Errors are logged only,
+// the installed KRL is kept in that case.
+func update(ctx context.Context, client *http.Client, url, path string) error {
+	ctx, cancel := context.WithTimeout(ctx, UPDATE_TIMEOUT)
+	defer cancel()
+
+	updated, err := krl.Update(ctx, client, url, path)
+	if err != nil {
+		log.Printf("Could not update KRL from %s: %s", url, err)
+		return err
+	}
+
+	if updated {
+		log.Printf("Installed updated KRL from %s at %s", url, path)
+	}
+
+	return nil
+}
+
+func main() {
+	flags := flag.NewFlagSet("oinit-krl", flag.ExitOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, USAGE) }
+	interval := flags.Duration("interval", 0, "update the KRL in this interval until stopped")
+	flags.Parse(os.Args[1:])
+
+	if flags.NArg() != 3 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	url := krl.URL(flags.Arg(0), flags.Arg(1))
+	path := flags.Arg(2)
+	client := &http.Client{}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *interval <= 0 {
+		if update(ctx, client, url, path) != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		update(ctx, client, url, path)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 	"os/exec"
+	"path"
 	"strings"
 	"syscall"
 
@@ -18,19 +19,23 @@ const (
 
 // This program will be invoked by OpenSSH as
 //
-//	oinit-shell -c 'oinit-switch <target>'
+//	oinit-shell -c 'oinit-switch <target> [signed payload]'
 //
 // Ensure that only FORCE_COMMAND can be run and no interactive login shell is
-// provided.
+// provided. FORCE_COMMAND may be given by absolute path if the hostgroup
+// configures a force-command template.
 func main() {
 	if len(os.Args) != 3 || os.Args[1] != "-c" {
 		log.LogFatal(ERR_PROHIBITED)
 	}
 
-	command := os.Args[2]
-	argv := strings.Fields(command)
+	argv := strings.Fields(os.Args[2])
+	if len(argv) == 0 {
+		log.LogFatal(ERR_PROHIBITED)
+	}
 
-	if !strings.HasPrefix(command, FORCE_COMMAND) || len(argv) != 2 {
+	if (argv[0] != FORCE_COMMAND && (!path.IsAbs(argv[0]) || path.Base(argv[0]) != FORCE_COMMAND)) ||
+		(len(argv) != 2 && len(argv) != 3) {
 		log.LogFatal(ERR_PROHIBITED)
 	}
 
@@ -1,14 +1,26 @@
+//go:build unix
+
+// oinit-switch is the force-command of certificates on hosts running an
+// OpenSSH server, which are only supported on Unix.
+
 package main
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"os/exec"
 	"os/user"
 	"strconv"
+	"strings"
 	"syscall"
 
+	"github.com/lbrocke/oinit/internal/forcecmd"
+	"github.com/lbrocke/oinit/internal/tokenbind"
+	"github.com/lbrocke/oinit/pkg/libmotleycue"
 	"github.com/lbrocke/oinit/pkg/log"
 	"github.com/mattn/go-isatty"
+	"golang.org/x/exp/slices"
 )
 
 const (
@@ -16,8 +28,56 @@ const (
 	OINIT_USER  = "oinit"
 	SYS_UID_MAX = 999
 
+	// If this file exists, the force-command must carry a payload signed
+	// with the key contained in it, see package forcecmd.
+	FORCE_COMMAND_KEY = "/etc/ssh/oinit-switch.key"
+	FORCE_COMMAND     = "oinit-switch"
+
+	// Logins with certificates that were requested for a host not listed in
+	// this file are refused. It is shared with oinit-enroll-host and
+	// oinit-principals and lists one name per line. If it doesn't exist, the
+	// fully qualified host name of the system is used, see
+	// forcecmd.SystemHostNames, so hosts reached under aliases or
+	// load-balanced names must list them here.
+	HOST_NAMES = "/etc/ssh/oinit-hosts"
+
+	// If this file exists, it must contain the URL of the local motley_cue
+	// instance and logins must present the access token the certificate was
+	// issued for in ENV_TOKEN. This requires 'ExposeAuthInfo yes' and
+	// 'AcceptEnv OINIT_BOUND_TOKEN' in sshd_config, and token-binding in the
+	// hostgroup of the CA, so that the oinit client sends OINIT_BOUND_TOKEN.
+	// Users must export it, e.g. using
+	// 'export OINIT_BOUND_TOKEN=$(oinit token <host>)'.
+	TOKEN_BINDING_MOTLEY_CUE = "/etc/ssh/oinit-switch.motley_cue"
+	ENV_TOKEN                = tokenbind.ENV_TOKEN
+
+	// If this file exists, it lists the kinds of sessions allowed on this
+	// host (shell, exec, scp and sftp), separated by commas or whitespace,
+	// e.g. "scp, sftp" for hosts that only serve file transfers. Hostgroups
+	// should advertise the same in force-command-features.
+	ALLOWED_SESSIONS = "/etc/ssh/oinit-switch.sessions"
+
+	// If this file exists, it lists certificate extensions that site policy
+	// forbids, e.g. "permit-X11-forwarding, permit-agent-forwarding", and
+	// logins with certificates permitting any of them are refused. This
+	// requires 'ExposeAuthInfo yes' in sshd_config.
+	FORBIDDEN_EXTENSIONS = "/etc/ssh/oinit-switch.forbid"
+
+	// If this file exists, it contains a command that interactive sessions
+	// are wrapped with to record them, e.g. using script or tlog-rec, see
+	// forcecmd.Recorder. It is run as the oinit user, so that users can't
+	// tamper with recordings.
+	SESSION_RECORDER = "/etc/ssh/oinit-switch.record"
+
+	// If this file exists, the start and stop of sessions are logged to
+	// syslog (facility authpriv) with the serial number of the certificate.
+	// Its content is ignored. The serial number requires 'ExposeAuthInfo
+	// yes' in sshd_config.
+	SESSION_EVENTS = "/etc/ssh/oinit-switch.events"
+
 	ERR_NOT_ALLOWED = "This is not allowed."
 	ERR_INTERNAL    = "Internal error. oinit might not be set up correctly."
+	ERR_FORBIDDEN   = "Certificates permitting %s are not allowed on this host."
 )
 
 // getUser returns the uid for the given username. If the user doesn't exist,
@@ -36,12 +96,97 @@ func getUid(name string) (int, error) {
 	return uid, nil
 }
 
+// hostNames returns the names of this host from HOST_NAMES, or the host name
+// of the system if the file doesn't exist.
+func hostNames() ([]string, error) {
+	names, err := forcecmd.LoadHostNames(HOST_NAMES)
+	if errors.Is(err, os.ErrNotExist) {
+		return forcecmd.SystemHostNames()
+	}
+
+	return names, err
+}
+
+// verifyTokenBinding verifies that the certificate used for authentication is
+// bound to the access token presented by the client, and that motley_cue
+// still maps this token to the target user.
+func verifyTokenBinding(motleyCue string, target string) error {
+	token := os.Getenv(ENV_TOKEN)
+	if token == "" {
+		return errors.New("no access token presented")
+	}
+
+	cert, err := tokenbind.AuthCertificate()
+	if err != nil {
+		return err
+	}
+
+	if err := tokenbind.Verify(cert, token); err != nil {
+		return err
+	}
+
+	status, err := libmotleycue.NewClient(motleyCue).GetUserStatus(token)
+	if err != nil {
+		return err
+	}
+
+	if status.State != libmotleycue.StateDeployed ||
+		(status.Credentials.SSHUser != target && !slices.Contains(status.Credentials.SSHUsers, target)) {
+		return errors.New("token is not authorized for target user")
+	}
+
+	return nil
+}
+
 func main() {
-	if len(os.Args) != 2 {
+	if len(os.Args) != 2 && len(os.Args) != 3 {
 		log.LogFatal(ERR_NOT_ALLOWED)
 	}
 
-	target := os.Args[1]
+	// The force-command lists all accounts the certificate permits logins
+	// as. Users logging in as one of them directly stay that user, the oinit
+	// user switches to the first one.
+	targets := strings.Split(os.Args[1], ",")
+	target := targets[0]
+
+	if curUser, err := user.Current(); err == nil && slices.Contains(targets, curUser.Username) {
+		target = curUser.Username
+	}
+
+	var payload forcecmd.Payload
+
+	// Verify that the force-command was set by the oinit CA, if a key is
+	// configured on this host.
+	if key, err := forcecmd.LoadKey(FORCE_COMMAND_KEY); err == nil {
+		if len(os.Args) != 3 {
+			log.LogFatal(ERR_NOT_ALLOWED)
+		}
+
+		if payload, err = forcecmd.Verify(key, FORCE_COMMAND, os.Args[1], os.Args[2]); err != nil {
+			log.LogFatal(ERR_NOT_ALLOWED)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		log.LogFatal(ERR_INTERNAL)
+	} else if len(os.Args) == 3 {
+		// Without a key, the payload is trusted as part of the certificate,
+		// but it must still be honoured if it restricts the command.
+		if payload, err = forcecmd.Parse(os.Args[2]); err != nil {
+			log.LogFatal(ERR_NOT_ALLOWED)
+		}
+	}
+
+	// Refuse certificates that were requested for another host of the same
+	// CA, which sshd accepts as both trust the same user CA key.
+	if len(os.Args) == 3 {
+		names, err := hostNames()
+		if err != nil {
+			log.LogFatal(ERR_INTERNAL)
+		}
+
+		if forcecmd.VerifyHost(payload, names) != nil {
+			log.LogFatal(ERR_NOT_ALLOWED)
+		}
+	}
 
 	// Make sure target user is not a system user. This is not strictly
 	// necessary because (a) oinit-ca would never issue a certificate
@@ -53,6 +198,33 @@ func main() {
 		log.LogFatal(ERR_NOT_ALLOWED)
 	}
 
+	if content, err := os.ReadFile(TOKEN_BINDING_MOTLEY_CUE); err == nil {
+		if verifyTokenBinding(strings.TrimSpace(string(content)), target) != nil {
+			log.LogFatal(ERR_NOT_ALLOWED)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		log.LogFatal(ERR_INTERNAL)
+	}
+
+	// The token must not reach the shell of the user or the session
+	// recorder
+	os.Unsetenv(ENV_TOKEN)
+
+	// Refuse certificates permitting features that site policy forbids, in
+	// case a misconfigured CA issued them. sshd would grant them otherwise.
+	if forbidden, err := forcecmd.LoadForbiddenExtensions(FORBIDDEN_EXTENSIONS); err == nil {
+		cert, err := tokenbind.AuthCertificate()
+		if err != nil {
+			log.LogFatal(ERR_NOT_ALLOWED)
+		}
+
+		if violations := forcecmd.Violations(cert, forbidden); len(violations) > 0 {
+			log.LogFatal(fmt.Sprintf(ERR_FORBIDDEN, strings.Join(violations, ", ")))
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		log.LogFatal(ERR_INTERNAL)
+	}
+
 	curUser, err := user.Current()
 	if err != nil {
 		log.LogFatal(ERR_INTERNAL)
@@ -91,8 +263,29 @@ func main() {
 		log.LogFatal(ERR_INTERNAL)
 	}
 
+	sshCmd, hasCmd := os.LookupEnv("SSH_ORIGINAL_COMMAND")
+
+	// Certificates restricted to a command can't be used for anything else,
+	// including interactive sessions.
+	if payload.Command != "" && (!hasCmd || !forcecmd.Permits(payload.Command, sshCmd)) {
+		log.LogFatal(ERR_NOT_ALLOWED)
+	}
+
+	session := forcecmd.FEATURE_SHELL
+	if hasCmd {
+		session = forcecmd.Classify(sshCmd)
+	}
+
+	if allowed, err := forcecmd.LoadAllowedSessions(ALLOWED_SESSIONS); err == nil {
+		if !slices.Contains(allowed, session) {
+			log.LogFatal(ERR_NOT_ALLOWED)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		log.LogFatal(ERR_INTERNAL)
+	}
+
 	var argv []string
-	if sshCmd, ok := os.LookupEnv("SSH_ORIGINAL_COMMAND"); ok {
+	if hasCmd {
 		// In case a command was given to ssh, execute this command instead of
 		// starting an interactive shell session.
 
@@ -110,11 +303,37 @@ func main() {
 			log.LogFatal(ERR_NOT_ALLOWED)
 		}
 
-		argv = []string{SU_COMMAND, "-", target, "-c", sshCmd}
+		// Subsystems such as sftp are passed on as their command, which
+		// can't be run by su if sshd serves them itself (internal-sftp).
+		command, err := forcecmd.Dispatch(sshCmd)
+		if err != nil {
+			log.LogFatal(ERR_INTERNAL)
+		}
+
+		argv = []string{SU_COMMAND, "-", target, "-c", command}
 	} else {
 		argv = []string{SU_COMMAND, "-", target, "-P"}
 	}
 
+	info := newSessionInfo(target, session, sshCmd)
+
+	if !hasCmd {
+		if recorder, err := forcecmd.LoadRecorder(SESSION_RECORDER); err == nil {
+			argv = recorder.Argv(map[string]string{
+				forcecmd.VAR_COMMAND: strings.Join(append([]string{argv0}, argv[1:]...), " "),
+				forcecmd.VAR_USER:    target,
+				forcecmd.VAR_SERIAL:  info.Serial,
+			})
+			argv0 = argv[0]
+		} else if !errors.Is(err, os.ErrNotExist) {
+			log.LogFatal(ERR_INTERNAL)
+		}
+	}
+
+	if _, err := os.Stat(SESSION_EVENTS); err == nil {
+		os.Exit(runLogged(argv0, argv, info))
+	}
+
 	// Use syscall.Exec (which calls execve) instead of exec.Command (which does fork + evecve)
 	// to prevent unnecessary resource hogging and hide this script in htop
 	if err := syscall.Exec(argv0, argv, os.Environ()); err != nil {
@@ -0,0 +1,123 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"log/syslog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/tokenbind"
+	"github.com/lbrocke/oinit/pkg/log"
+)
+
+const (
+	// Value of certificate fields in session events if the certificate
+	// isn't exposed by sshd
+	UNKNOWN = "unknown"
+)
+
+// sessionInfo describes a session in the events logged to syslog.
+type sessionInfo struct {
+	User    string
+	Kind    string
+	Command string
+	Serial  string
+	KeyID   string
+	Client  string
+}
+
+func (s sessionInfo) String() string {
+	info := "user=" + s.User + " kind=" + s.Kind + " serial=" + s.Serial +
+		" key_id=" + strconv.Quote(s.KeyID) + " client=" + s.Client
+
+	if s.Command != "" {
+		info += " command=" + strconv.Quote(s.Command)
+	}
+
+	return info
+}
+
+// newSessionInfo returns the description of the session of user, with serial
+// number and key ID of the certificate if sshd exposes it.
+func newSessionInfo(user, kind, command string) sessionInfo {
+	info := sessionInfo{
+		User:    user,
+		Kind:    kind,
+		Command: command,
+		Serial:  UNKNOWN,
+		KeyID:   UNKNOWN,
+		Client:  UNKNOWN,
+	}
+
+	if cert, err := tokenbind.AuthCertificate(); err == nil {
+		info.Serial = strconv.FormatUint(cert.Serial, 10)
+		info.KeyID = cert.KeyId
+	}
+
+	// SSH_CLIENT contains the client address, client port and server port
+	if client := strings.Fields(os.Getenv("SSH_CLIENT")); len(client) > 0 {
+		info.Client = client[0]
+	}
+
+	return info
+}
+
+// runLogged runs the session as child process and logs its start and stop to
+// syslog, as the stop can't be logged if the process is replaced using exec.
+// Signals are forwarded to the session. It returns the exit code of the
+// session.
+func runLogged(argv0 string, argv []string, info sessionInfo) int {
+	logger, err := syslog.New(syslog.LOG_AUTHPRIV|syslog.LOG_INFO, FORCE_COMMAND)
+	if err != nil {
+		log.LogFatal(ERR_INTERNAL)
+	}
+	defer logger.Close()
+
+	cmd := &exec.Cmd{
+		Path:   argv0,
+		Args:   argv,
+		Env:    os.Environ(),
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM)
+
+	start := time.Now()
+
+	if err := cmd.Start(); err != nil {
+		logger.Err("session failed: " + info.String() + " error=" + strconv.Quote(err.Error()))
+		return 1
+	}
+
+	logger.Info(fmt.Sprintf("session start: %s pid=%d", info, cmd.Process.Pid))
+
+	go func() {
+		for sig := range signals {
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	cmd.Wait()
+	signal.Stop(signals)
+
+	code := cmd.ProcessState.ExitCode()
+	if code < 0 {
+		// Terminated by a signal
+		code = 1
+	}
+
+	logger.Info(fmt.Sprintf("session stop: %s duration=%s status=%d",
+		info, time.Since(start).Round(time.Second), code))
+
+	return code
+}
@@ -1,23 +1,33 @@
 package main
 
 import (
-	"crypto/ed25519"
+	"context"
+	"crypto"
 	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
+	"path/filepath"
+	"runtime/debug"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/lbrocke/oinit/internal/dnsutil"
-	"github.com/lbrocke/oinit/internal/liboinitca"
+	"github.com/lbrocke/oinit/internal/minisign"
 	"github.com/lbrocke/oinit/internal/oidc"
 	"github.com/lbrocke/oinit/internal/oinit"
+	"github.com/lbrocke/oinit/internal/secretstore"
 	"github.com/lbrocke/oinit/internal/sshutil"
+	"github.com/lbrocke/oinit/internal/tokenbind"
+	"github.com/lbrocke/oinit/internal/trace"
+	"github.com/lbrocke/oinit/internal/update"
 	"github.com/lbrocke/oinit/internal/util"
 	"github.com/lbrocke/oinit/pkg/log"
+	"github.com/lbrocke/oinit/pkg/oinitca"
 
 	"github.com/mattn/go-tty"
 	"golang.org/x/crypto/ssh"
@@ -30,13 +40,90 @@ const (
 	COMMAND_DELETE = "delete"
 	COMMAND_LIST   = "list"
 	COMMAND_MATCH  = "match"
+	COMMAND_REVOKE = "revoke"
+	COMMAND_STATUS = "status"
+	COMMAND_TOKEN  = "token"
+	COMMAND_TRUST  = "trust"
+	COMMAND_UPDATE = "self-update"
+
+	// Flag of the match command, see matchTokenBinding
+	FLAG_TOKEN_BINDING = "--token-binding"
 
 	USAGE = "Usage:\n" +
-		"\toinit add    <host>[:port] [ca]\tAdd a host managed by oinit.\n" +
-		"\toinit delete <host>[:port]\tDelete a host.\n" +
-		"\toinit list\t\t\tList all hosts managed by oinit.\n"
+		"\toinit [options] add    <host>[:port] [ca]\tAdd a host managed by oinit.\n" +
+		"\toinit [options] delete <host>[:port]\t\tDelete a host.\n" +
+		"\toinit [options] list\t\t\t\tList all hosts managed by oinit.\n" +
+		"\toinit [options] revoke <host>[:port]\t\tRevoke and forget your certificates for a host.\n" +
+		"\toinit [options] status\t\t\t\tShow certificates, token sources and CAs of all hosts.\n" +
+		"\toinit [options] status <host>[:port]\t\tShow the state of your account on a host.\n" +
+		"\toinit [options] token  <host>[:port]\t\tPrint the access token used for a host.\n" +
+		"\toinit [options] trust [ca]\t\t\tInstall the host CA trust bundle into known_hosts.\n" +
+		"\toinit [options] self-update [--check]\t\tUpdate oinit to the latest signed release.\n" +
+		"\n" +
+		"Options:\n" +
+		"\t-v, -vv, -vvv\t\tPrint steps, timings and details of the flow.\n" +
+		"\t--report <file>\t\tWrite a debug report for support requests to file.\n" +
+		"\n" +
+		"As oinit is invoked by ssh, the options can also be set using the\n" +
+		"OINIT_VERBOSE (1-3) and OINIT_REPORT environment variables.\n" +
+		"\n" +
+		"To request certificates with fewer permissions than the CA allows, set\n" +
+		"OINIT_EXTENSIONS to a comma-separated list of certificate extensions\n" +
+		"(e.g. permit-pty) or to \"none\". To request certificates that only\n" +
+		"permit running a specific command (e.g. \"rsync --server\"), set\n" +
+		"OINIT_COMMAND. To request a certificate profile offered by the host\n" +
+		"(e.g. \"file-transfer-only\"), set OINIT_PROFILE.\n" +
+		"\n" +
+		"Certificates are requested for a fresh ed25519 key pair each time. Set\n" +
+		"OINIT_KEY_TYPE to ecdsa or rsa to use another key type, and\n" +
+		"OINIT_KEY_REUSE to per-host to keep one key pair per host, stored in\n" +
+		"OINIT_KEY_DIR or the oinit/keys directory in your config directory.\n" +
+		"\n" +
+		"For automation, oinit reads the token from the file set in\n" +
+		"OINIT_TOKEN_FILE (e.g. a Kubernetes service account token) or requests\n" +
+		"one from GitHub Actions, with the audience set in OINIT_TOKEN_AUDIENCE\n" +
+		"or the CA URL by default.\n" +
+		"\n" +
+		"Hosts may require logins to present the access token the certificate\n" +
+		"was issued for. ssh sends it to these hosts if OINIT_BOUND_TOKEN is\n" +
+		"set, e.g. using 'export OINIT_BOUND_TOKEN=$(oinit token <host>)'.\n"
+
+	// Certificates expiring within this duration are renewed even if a
+	// ControlMaster for the host is running
+	CONTROL_RENEW_MARGIN = 5 * time.Minute
+
+	FLAG_REPORT = "--report"
+	FLAG_CHECK  = "--check"
+
+	ENV_VERBOSE = "OINIT_VERBOSE"
+	ENV_REPORT  = "OINIT_REPORT"
+	// Overrides the release manifest URL set at build time
+	ENV_UPDATE_URL = "OINIT_UPDATE_URL"
+	// Certificate extensions to request, see requestedExtensions
+	ENV_EXTENSIONS = "OINIT_EXTENSIONS"
+	// Command that certificates should be restricted to
+	ENV_COMMAND = "OINIT_COMMAND"
+	// Certificate profile to request
+	ENV_PROFILE = "OINIT_PROFILE"
+)
+
+// Set at build time using -ldflags "-X main.version=...", see Makefile. The
+// public key is the minisign key that releases are signed with, self-update
+// is disabled if it is not set.
+var (
+	version         = ""
+	updateURL       = ""
+	updatePublicKey = ""
 )
 
+// Path of the debug report requested with --report, empty if none.
+var reportPath string
+
+// Environment variables that may contain an access token, in order of
+// precedence.
+var tokenEnvVars = []string{"ACCESS_TOKEN", "OIDC", "OS_ACCESS_TOKEN",
+	"OIDC_ACCESS_TOKEN", "WATTS_TOKEN", "WATTSON_TOKEN"}
+
 // handleCommandAdd handles the 'add' command to add a host managed by oinit.
 // It takes the host and optional CA as arguments.
 func handleCommandAdd(args []string) {
@@ -90,10 +177,16 @@ func handleCommandAdd(args []string) {
 
 	// Try to contact CA, which returns the host CA public key to be added
 	// to the user's known_hosts file.
-	if res, err := liboinitca.NewClient(ca).GetHost(host); err != nil {
+	if res, err := newCAClient(ca).GetHost(context.Background(), host); err != nil {
 		log.LogError("Could not contact CA: " + err.Error())
 		return
 	} else {
+		if res.Message != "" {
+			log.LogInfo("Message from the CA: " + res.Message)
+		}
+
+		warnForceCommand(host, res.ForceCommand)
+
 		if err := sshutil.AddSSHKnownHost(host, port, res.PublicKey); err != nil {
 			log.LogWarn("Could not add public key to your known_hosts file.")
 
@@ -142,6 +235,9 @@ func handleCommandDelete(args []string) {
 		host = strings.TrimSpace(hostport)
 	}
 
+	// Look up the CA before deleting, to forget cached tokens for this host.
+	ca, _ := oinit.GetCA(hostport)
+
 	found, err := oinit.DeleteHostUser(hostport)
 	if err != nil {
 		log.LogFatal("Could not delete host: " + err.Error())
@@ -156,9 +252,90 @@ func handleCommandDelete(args []string) {
 		sshutil.AgentRemoveCertificates(sshAgent, host)
 	}
 
+	if secrets, err := secretstore.Open(); err == nil && ca != "" {
+		oinit.ForgetToken(secrets, ca, host)
+	}
+
 	log.LogSuccess(hostport + " was deleted.")
 }
 
+// handleCommandRevoke handles the 'revoke' command, which removes the
+// certificates for a host from ssh-agent, deletes the key pairs kept for it
+// and the cached access token, and asks the CA to revoke all certificates
+// issued to the user for the host. It is meant for users who suspect that a
+// device holding their certificates is compromised, and can be run from
+// another device.
+func handleCommandRevoke(args []string) {
+	if len(args) < 1 {
+		fmt.Print(USAGE)
+		return
+	}
+
+	hostport := args[0]
+
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = strings.TrimSpace(hostport)
+		hostport = net.JoinHostPort(host, "22")
+	}
+
+	ca, err := oinit.GetCA(hostport)
+	if err != nil {
+		log.LogFatal("The CA managing '" + host + "' could not be determined.\n" +
+			"Did you run 'oinit add " + hostport + "' yet?")
+	}
+
+	if sshutil.AgentIsRunning() {
+		sshAgent, _ := sshutil.GetAgent()
+
+		if err := sshutil.AgentRemoveCertificates(sshAgent, host); err != nil {
+			log.LogWarn("Could not remove certificates from ssh-agent: " + err.Error())
+		}
+	}
+
+	if keyOpts, err := oinit.KeyOptionsFromEnv(); err == nil {
+		if _, err := keyOpts.ForgetKeys(host); err != nil {
+			log.LogWarn("Could not delete key pairs: " + err.Error())
+		}
+	}
+
+	// A fresh token is requested, as the cached one may be compromised as
+	// well, and it is not cached again.
+	if secrets, err := secretstore.Open(); err == nil {
+		oinit.ForgetToken(secrets, ca, host)
+	}
+
+	caClient := newCAClient(ca)
+	token, _ := getToken(nil, caClient, ca, host)
+
+	revoked, err := caClient.Revoke(context.Background(), host, token)
+	if err != nil {
+		// CAs without the revoke endpoint respond with 404 and no error code
+		var caErr *oinitca.Error
+		if errors.As(err, &caErr) && caErr.StatusCode == http.StatusNotFound && caErr.Code == "" {
+			log.LogWarn("Local certificates and keys were removed, but the CA does not support revocation.\n" +
+				"Please ask its operators to revoke your certificates.")
+			exit(1)
+		}
+
+		log.LogFatal("Local certificates and keys were removed, but the CA could not revoke your certificates: " +
+			err.Error() + remediation(err))
+	}
+
+	if len(revoked.Serials) == 0 {
+		log.LogSuccess("Local certificates and keys were removed, the CA holds no valid certificates for you.")
+		return
+	}
+
+	serials := make([]string, len(revoked.Serials))
+	for i, serial := range revoked.Serials {
+		serials[i] = strconv.FormatUint(serial, 10)
+	}
+
+	log.LogSuccess(fmt.Sprintf("Local certificates and keys were removed, the CA revoked %d certificates (serials %s).",
+		len(serials), strings.Join(serials, ", ")))
+}
+
 // handleCommandList handles the 'list' command to list all hosts managed by oinit.
 func handleCommandList() {
 	all, err := oinit.GetManagedHosts()
@@ -180,15 +357,255 @@ func handleCommandList() {
 	}
 }
 
+// handleCommandStatus handles the 'status' command to show the state of the
+// user's account on a managed host, e.g. whether it is still pending, without
+// requesting a certificate. Without a host, an overview of all managed hosts
+// is shown, see handleStatusOverview.
+func handleCommandStatus(args []string) {
+	if len(args) < 1 {
+		handleStatusOverview()
+		return
+	}
+
+	hostport := args[0]
+
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = strings.TrimSpace(hostport)
+		hostport = net.JoinHostPort(host, "22")
+	}
+
+	ca, err := oinit.GetCA(hostport)
+	if err != nil {
+		log.LogFatal("The CA managing '" + host + "' could not be determined.\n" +
+			"Did you run 'oinit add " + hostport + "' yet?")
+	}
+
+	caClient := newCAClient(ca)
+	secrets, _ := secretstore.Open()
+
+	if res, err := caClient.GetHost(context.Background(), host); err == nil {
+		warnForceCommand(host, res.ForceCommand)
+	}
+
+	token, cached := getToken(secrets, caClient, ca, host)
+
+	status, err := caClient.GetUserStatus(context.Background(), host, token)
+	if err != nil && cached {
+		// The cached token may have been revoked, retry with a fresh one.
+		oinit.ForgetToken(secrets, ca, host)
+
+		token, _ = getToken(secrets, caClient, ca, host)
+		status, err = caClient.GetUserStatus(context.Background(), host, token)
+	}
+
+	if err != nil {
+		log.LogFatal("Could not get the state of your account: " + err.Error() + remediation(err))
+	}
+
+	if status.State == oinitca.STATE_DEPLOYED {
+		log.LogSuccess("Your account on " + host + " is deployed as " + status.User + ".")
+		return
+	}
+
+	msg := "Your account on " + host + " is " + strings.ReplaceAll(status.State, "_", " ") + "."
+	if status.Message != "" {
+		msg += "\n" + status.Message
+	}
+
+	msg += formatRemediation(status.Remediation)
+
+	log.LogWarn(msg)
+}
+
+// handleCommandToken handles the 'token' command, which prints the access
+// token that certificates for the host are requested with. Hosts binding
+// logins to this token (see package tokenbind) receive it from ssh via
+// SendEnv, if it is exported as OINIT_BOUND_TOKEN.
+func handleCommandToken(args []string) {
+	if len(args) != 1 {
+		fmt.Print(USAGE)
+		exit(1)
+	}
+
+	hostport := args[0]
+
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = strings.TrimSpace(hostport)
+		hostport = net.JoinHostPort(host, "22")
+	}
+
+	ca, err := oinit.GetCA(hostport)
+	if err != nil {
+		log.LogFatal("The CA managing '" + host + "' could not be determined.\n" +
+			"Did you run 'oinit add " + hostport + "' yet?")
+	}
+
+	secrets, _ := secretstore.Open()
+
+	token, _ := getToken(secrets, newCAClient(ca), ca, host)
+
+	fmt.Println(token)
+}
+
+// handleCommandTrust handles the 'trust' command to install or update the
+// trust bundle of the given CA, or of all CAs of managed hosts, in the user's
+// known_hosts file.
+func handleCommandTrust(args []string) {
+	var cas []string
+
+	if len(args) >= 1 {
+		cas = []string{args[0]}
+	} else {
+		all, err := oinit.GetManagedHosts()
+		if err != nil {
+			log.LogFatal("Could not load hosts: " + err.Error())
+		}
+
+		for _, ca := range all {
+			if !slices.Contains(cas, ca) {
+				cas = append(cas, ca)
+			}
+		}
+		sort.Strings(cas)
+	}
+
+	if len(cas) == 0 {
+		log.LogFatal("No CA given and no hosts managed by oinit.")
+	}
+
+	for _, ca := range cas {
+		res, err := newCAClient(ca).GetTrustBundle(context.Background())
+		if err != nil {
+			log.LogError("Could not get trust bundle from " + ca + ": " + err.Error())
+			continue
+		}
+
+		if err := sshutil.InstallSSHKnownHostsBundle(ca, res.KnownHosts); err != nil {
+			log.LogError("Could not update your known_hosts file: " + err.Error())
+			continue
+		}
+
+		log.LogSuccess(fmt.Sprintf("Installed %d entries from %s.", len(res.KnownHosts), ca))
+	}
+}
+
+// acceptLanguage returns the language of the user's locale in the format of
+// the Accept-Language header, e.g. de-DE for de_DE.UTF-8, so that error
+// messages of the CA are translated.
+func acceptLanguage() string {
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+
+		locale, _, _ := strings.Cut(value, ".")
+		if locale == "C" || locale == "POSIX" {
+			return ""
+		}
+
+		return strings.ReplaceAll(locale, "_", "-")
+	}
+
+	return ""
+}
+
+// newCAClient returns a client for the given CA, which receives error
+// messages in the language of the user.
+func newCAClient(ca string) *oinitca.Client {
+	return oinitca.NewClient(ca,
+		oinitca.WithLanguage(acceptLanguage()),
+		oinitca.WithUserAgent("oinit/"+currentVersion()),
+	)
+}
+
+// currentVersion returns the version of this binary.
+func currentVersion() string {
+	if version != "" {
+		return version
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		return info.Main.Version
+	}
+
+	return ""
+}
+
+// handleCommandSelfUpdate handles the 'self-update' command to replace the
+// running binary with the latest signed release. With --check, it only
+// reports whether an update is available.
+func handleCommandSelfUpdate(args []string) {
+	check := len(args) >= 1 && args[0] == FLAG_CHECK
+
+	if updatePublicKey == "" {
+		log.LogFatal("Self-update is not available in this build of oinit.")
+	}
+
+	pk, err := minisign.ParsePublicKey(updatePublicKey)
+	if err != nil {
+		log.LogFatal("The update public key of this build is invalid: " + err.Error())
+	}
+
+	url := os.Getenv(ENV_UPDATE_URL)
+	if url == "" {
+		url = updateURL
+	}
+	if url == "" {
+		log.LogFatal("No update URL configured, please set " + ENV_UPDATE_URL + ".")
+	}
+
+	release, err := update.Check(url)
+	if err != nil {
+		log.LogFatal("Could not check for updates: " + err.Error())
+	}
+
+	current := currentVersion()
+
+	if !update.IsNewer(release.Version, current) {
+		log.LogSuccess("oinit is up to date (" + current + ").")
+		return
+	}
+
+	if check {
+		log.LogInfo("oinit " + release.Version + " is available, you are using " + current + ".")
+		log.LogInfo("Run 'oinit self-update' to update.")
+		return
+	}
+
+	binary, err := update.Download(release, pk)
+	if err != nil {
+		log.LogFatal("Could not download update: " + err.Error())
+	}
+
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		log.LogFatal("Could not determine path of oinit: " + err.Error())
+	}
+
+	if err := update.Replace(exe, binary); err != nil {
+		log.LogFatal("Could not replace " + exe + ": " + err.Error())
+	}
+
+	log.LogSuccess("Updated oinit from " + current + " to " + release.Version + ".")
+}
+
 // getTokenFromOidcAgent prompts the user to select a supported OIDC issuer
 // and then requests an access token via oidc-agent. It takes the CA client
 // and host as arguments and returns the access token.
-func getTokenFromOidcAgent(caClient liboinitca.Client, host string) string {
+func getTokenFromOidcAgent(caClient *oinitca.Client, host string) string {
 	if !oidc.AgentIsRunning() {
 		log.LogFatalTTY("oidc-agent is not running, please start it first.")
 	}
 
-	hostRes, err := caClient.GetHost(host)
+	done := trace.Step("Requesting supported providers from CA")
+	hostRes, err := caClient.GetHost(context.Background(), host)
+	done()
 	if err != nil {
 		log.LogFatalTTY("Contacting the CA failed: " + err.Error())
 	}
@@ -200,11 +617,15 @@ func getTokenFromOidcAgent(caClient liboinitca.Client, host string) string {
 	}
 	sort.Strings(providers)
 
+	trace.Logf(trace.LEVEL_DETAILS, "Supported providers: %s", strings.Join(providers, ", "))
+
 	provider, err := promptProviders(providers)
 	if err != nil {
 		log.LogFatalTTY(err.Error())
 	}
 
+	trace.Logf(trace.LEVEL_STEPS, "Selected provider %s", provider)
+
 	// Get scopes for selected provider
 	var scopes []string
 	for _, info := range hostRes.Providers {
@@ -215,7 +636,11 @@ func getTokenFromOidcAgent(caClient liboinitca.Client, host string) string {
 		scopes = info.Scopes
 	}
 
+	trace.Logf(trace.LEVEL_DETAILS, "Requesting scopes: %s", strings.Join(scopes, " "))
+
+	done = trace.Step("Requesting access token from oidc-agent")
 	token, err := oidc.GetToken(provider, scopes)
+	done()
 	if err != nil {
 		log.LogFatalTTY("Could not get token from oidc-agent: " + err.Error())
 	}
@@ -286,45 +711,353 @@ func promptProviders(providers []string) (string, error) {
 	return providers[selected-1], nil
 }
 
-// generateEd25519Keys generates a new ED25519 key pair and returns the
-// marshalled public key (ssh-ed25519 AAA...) as well as private key.
-func generateEd25519Keys() (string, ed25519.PrivateKey, error) {
-	pubkey, privkey, err := ed25519.GenerateKey(nil)
+// certificateRequest is a certificate to be requested for a host, including
+// the results of the request.
+type certificateRequest struct {
+	host     string
+	ca       string
+	caClient *oinitca.Client
+	token    string
+	cached   bool
+
+	cert    *ssh.Certificate
+	privkey crypto.Signer
+	err     error
+}
+
+// getToken returns an access token for the host from the environment, the
+// secret store or oidc-agent, and whether it was cached.
+func getToken(secrets secretstore.Store, caClient *oinitca.Client, ca, host string) (string, bool) {
+	// Workload identity tokens take precedence, as they are only available
+	// in automation
+	token, source, err := oinit.WorkloadToken(context.Background(), ca)
 	if err != nil {
-		return "", nil, err
+		log.LogFatal("Could not get workload token from " + source + ": " + err.Error())
+	}
+
+	if token != "" {
+		trace.Logf(trace.LEVEL_STEPS, "Using workload token from %s", source)
+
+		return token, false
 	}
 
-	pubkeyInst, err := ssh.NewPublicKey(pubkey)
+	for _, name := range tokenEnvVars {
+		if token := os.Getenv(name); token != "" {
+			trace.Logf(trace.LEVEL_STEPS, "Using access token from environment variable %s", name)
+
+			// Tokens from the environment are not cached, as they are
+			// managed by the user already.
+			return token, false
+		}
+	}
+
+	if secrets != nil {
+		if token := oinit.GetCachedToken(secrets, ca, host); token != "" {
+			trace.Logf(trace.LEVEL_STEPS, "Using cached access token from %s", secrets.Name())
+
+			return token, true
+		}
+	}
+
+	// Use oidc-agent to get token.
+	// getTokenFromOidcAgent() exits with -1 for any errors.
+	token = getTokenFromOidcAgent(caClient, host)
+
+	if secrets != nil {
+		oinit.CacheToken(secrets, ca, host, token)
+	}
+
+	return token, false
+}
+
+// requestedExtensions returns the certificate extensions set in
+// OINIT_EXTENSIONS, or nil if not set so that the CA includes all extensions
+// it allows. An empty value or "none" requests no extensions at all.
+func requestedExtensions() []string {
+	value, ok := os.LookupEnv(ENV_EXTENSIONS)
+	if !ok {
+		return nil
+	}
+
+	extensions := []string{}
+	for _, ext := range strings.Split(value, ",") {
+		if ext = strings.TrimSpace(ext); ext != "" && ext != "none" {
+			extensions = append(extensions, ext)
+		}
+	}
+
+	return extensions
+}
+
+// remediation returns the next steps that the CA suggests for the error on
+// separate lines, or an empty string if there are none.
+func remediation(err error) string {
+	var caErr *oinitca.Error
+	if !errors.As(err, &caErr) {
+		return ""
+	}
+
+	return formatRemediation(caErr.Remediation)
+}
+
+// formatRemediation returns the hint, URL and contact of the remediation on
+// separate lines, or an empty string if it is nil.
+func formatRemediation(r *oinitca.Remediation) string {
+	if r == nil {
+		return ""
+	}
+
+	msg := "\n" + r.Hint
+	if r.URL != "" {
+		msg += "\nSee " + r.URL
+	}
+
+	if r.Contact != "" {
+		msg += "\nSupport: " + r.Contact
+	}
+
+	return msg
+}
+
+// warnForceCommand warns about the kinds of sessions, such as sftp, that the
+// force-command of host doesn't support, as they would fail after login.
+func warnForceCommand(host string, fc *oinitca.ForceCommand) {
+	if fc == nil {
+		return
+	}
+
+	unsupported := fc.Unsupported()
+	if len(unsupported) == 0 {
+		return
+	}
+
+	msg := "Logins to " + host + " run " + fc.Program
+	if fc.Wrapper {
+		msg += " (a wrapper)"
+	}
+
+	log.LogWarn(msg + ", which doesn't support " + strings.Join(unsupported, ", ") + " sessions.")
+}
+
+// requestCertificate generates a temporary key pair, or uses the one kept
+// for the host, and requests a certificate for it, see oinit.KeyOptions.
+// Errors are stored in req.err.
+func requestCertificate(req *certificateRequest) {
+	trace.Logf(trace.LEVEL_DETAILS, "Access token for %s: %s", req.host, trace.TokenInfo(req.token))
+
+	keyOpts, err := oinit.KeyOptionsFromEnv()
 	if err != nil {
-		return "", nil, err
+		//lint:ignore ST1005 Error is display to user directly
+		req.err = errors.New("Invalid key options: " + err.Error())
+		return
+	}
+
+	trace.Logf(trace.LEVEL_DETAILS, "Using %s key (%s) for %s", keyOpts.Type, keyOpts.Reuse, req.host)
+
+	privkey, pubkey, err := keyOpts.Key(req.host)
+	if err != nil {
+		trace.Logf(trace.LEVEL_STEPS, "Could not get key pair: %s", err.Error())
+
+		//lint:ignore ST1005 Error is display to user directly
+		req.err = errors.New("There was an error generating a temporary key pair.")
+		return
+	}
+
+	done := trace.Step("Requesting certificate for " + req.host + " from CA")
+	res, err := req.caClient.SignCertificate(context.Background(), req.host, oinitca.CertificateRequest{
+		PublicKey:  pubkey,
+		Token:      req.token,
+		Extensions: requestedExtensions(),
+		Command:    os.Getenv(ENV_COMMAND),
+		Profile:    os.Getenv(ENV_PROFILE),
+	})
+	done()
+	if err != nil {
+		//lint:ignore ST1005 Error is display to user directly
+		req.err = errors.New("CA responded: " + err.Error() + remediation(err))
+		return
+	}
+
+	cert, err := res.Parse()
+	if err != nil {
+		//lint:ignore ST1005 Error is display to user directly
+		req.err = errors.New("Cannot parse certificate.")
+		return
+	}
+
+	req.cert = cert
+	req.privkey = privkey
+	req.err = nil
+}
+
+// addCertificate adds the certificate of a successful request to ssh-agent
+// and returns the time until which it is valid.
+func addCertificate(sshAgent agent.ExtendedAgent, req *certificateRequest) (time.Time, error) {
+	validUntil := time.Unix(int64(req.cert.ValidBefore-1), 0)
+
+	traceCertificate(req.cert)
+
+	done := trace.Step("Adding certificate for " + req.host + " to ssh-agent")
+	err := sshAgent.Add(agent.AddedKey{
+		PrivateKey:   req.privkey,
+		Certificate:  req.cert,
+		LifetimeSecs: uint32(time.Until(validUntil).Seconds()),
+	})
+	done()
+
+	if err != nil {
+		//lint:ignore ST1005 Error is display to user directly
+		return validUntil, errors.New("Cannot add private key and certificate to ssh-agent.")
+	}
+
+	return validUntil, nil
+}
+
+// proxyJumpRequests returns certificate requests for all hosts of the
+// ProxyJump chain of the given host that are managed by oinit and for which
+// ssh-agent doesn't hold a certificate yet.
+func proxyJumpRequests(sshAgent agent.ExtendedAgent, host, port string) []*certificateRequest {
+	var reqs []*certificateRequest
+
+	done := trace.Step("Resolving ProxyJump chain")
+	chain, err := sshutil.ProxyJumpChain(host, port)
+	done()
+	if err != nil {
+		trace.Logf(trace.LEVEL_STEPS, "Could not resolve ProxyJump chain: %s", err.Error())
+		return nil
+	}
+
+	for _, hop := range chain {
+		hopHost := strings.ToLower(hop.Host)
+		hostport := net.JoinHostPort(hopHost, hop.Port)
+
+		ca, err := oinit.GetCA(hostport)
+		if err != nil || ca == "" {
+			trace.Logf(trace.LEVEL_STEPS, "Jump host %s is not managed by oinit", hostport)
+			continue
+		}
+
+		if exists, err := sshutil.AgentHasCertificate(sshAgent, hopHost); err == nil && exists {
+			trace.Logf(trace.LEVEL_STEPS, "ssh-agent already holds a valid certificate for jump host %s", hopHost)
+			continue
+		}
+
+		trace.Logf(trace.LEVEL_STEPS, "Prefetching certificate for jump host %s from CA %s", hostport, ca)
+
+		reqs = append(reqs, &certificateRequest{
+			host:     hopHost,
+			ca:       ca,
+			caClient: newCAClient(ca),
+		})
+	}
+
+	return reqs
+}
+
+// findManagedHost returns the managed host and port that ssh connects to for
+// the destination given to match. As the Match block added by oinit comes
+// first in the ssh config, the destination may be an alias or short name, so
+// the ssh config is evaluated with ssh -G to find the actual host name. The
+// destination itself is used as a fallback.
+func findManagedHost(host, port string) (string, string, bool) {
+	candidates := []sshutil.Hop{{Host: host, Port: port}}
+
+	if resolved, err := sshutil.ResolveHost(host, port); err == nil {
+		trace.Logf(trace.LEVEL_DETAILS, "ssh config resolves %s to %s", net.JoinHostPort(host, port),
+			net.JoinHostPort(resolved.Host, resolved.Port))
+
+		candidates = append([]sshutil.Hop{resolved}, candidates...)
+	} else {
+		trace.Logf(trace.LEVEL_STEPS, "Could not evaluate ssh config: %s", err.Error())
+	}
+
+	for _, candidate := range candidates {
+		hostport := net.JoinHostPort(candidate.Host, candidate.Port)
+
+		trace.Logf(trace.LEVEL_STEPS, "Looking up %s in managed hosts", hostport)
+
+		if is, err := oinit.IsManagedHost(hostport); err == nil && is {
+			return candidate.Host, candidate.Port, true
+		}
+	}
+
+	trace.Logf(trace.LEVEL_STEPS, "%s is not managed by oinit", net.JoinHostPort(host, port))
+
+	return host, port, false
+}
+
+// matchTokenBinding exits with 0 if the token in tokenbind.ENV_TOKEN is set
+// and the CA marks the managed host as binding logins to it, and with 1
+// otherwise. It takes the host and port as arguments.
+func matchTokenBinding(args []string) {
+	if os.Getenv(tokenbind.ENV_TOKEN) == "" {
+		os.Exit(1)
+	}
+
+	host, port, found := findManagedHost(strings.ToLower(args[0]), args[1])
+	if !found {
+		exit(1)
+	}
+
+	ca, err := oinit.GetCA(net.JoinHostPort(host, port))
+	if err != nil || ca == "" {
+		exit(1)
 	}
 
-	return strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(pubkeyInst)), "\n"), privkey, nil
+	res, err := newCAClient(ca).GetHost(context.Background(), host)
+	if err != nil || !res.TokenBinding {
+		trace.Logf(trace.LEVEL_STEPS, "Not sending %s to %s, as the CA doesn't bind logins to it", tokenbind.ENV_TOKEN, host)
+		exit(1)
+	}
+
+	trace.Logf(trace.LEVEL_STEPS, "Sending %s to %s", tokenbind.ENV_TOKEN, host)
 }
 
 // handleCommandMatch handles the 'match' command to match a host managed by oinit.
 // It takes the host and port as arguments.
+//
+// If ssh multiplexes the connection over a running ControlMaster, no
+// certificate is requested, unless the certificate in ssh-agent expires
+// within CONTROL_RENEW_MARGIN. It is then renewed, so that sessions opened
+// later, e.g. after the ControlMaster exits, don't fail unexpectedly.
+//
+// If the host is connected to through a ProxyJump chain, certificates for all
+// managed jump hosts are requested concurrently as well, so the connection
+// doesn't wait for each hop in turn.
+//
+// With --token-binding, it only matches managed hosts that the CA marks as
+// binding logins to the access token, so that ssh sends OINIT_BOUND_TOKEN to
+// them, see matchTokenBinding.
 func handleCommandMatch(args []string) {
-	if len(args) != 2 {
+	// Invoked by ssh -G while resolving a ProxyJump chain, see below.
+	if os.Getenv(sshutil.ENV_RESOLVING_PROXYJUMP) != "" {
 		os.Exit(1)
 	}
 
-	host := strings.ToLower(args[0])
-	port := args[1]
-	hostport := strings.ToLower(net.JoinHostPort(host, port))
+	if len(args) == 3 && args[0] == FLAG_TOKEN_BINDING {
+		matchTokenBinding(args[1:])
+		return
+	}
 
-	if is, err := oinit.IsManagedHost(hostport); err != nil || !is {
-		// Return non-zero exit code to indicate that host/port do not match
+	if len(args) != 2 {
 		os.Exit(1)
 	}
 
+	host, port, found := findManagedHost(strings.ToLower(args[0]), args[1])
+	if !found {
+		// Return non-zero exit code to indicate that host/port do not match
+		exit(1)
+	}
+
+	hostport := net.JoinHostPort(host, port)
+
 	ca, err := oinit.GetCA(hostport)
 	if err != nil {
 		log.LogFatalTTY("The CA managing '" + host + "' could not be determined.\n" +
 			"Did you run 'oinit add " + hostport + "' yet?")
 	}
 
-	caClient := liboinitca.NewClient(ca)
+	trace.Logf(trace.LEVEL_STEPS, "Host is managed by CA %s", ca)
 
 	// Verify that ssh-agent is running, which is required in any case
 	if !sshutil.AgentIsRunning() {
@@ -333,58 +1066,233 @@ func handleCommandMatch(args []string) {
 
 	sshAgent, _ := sshutil.GetAgent()
 
-	if exists, err := sshutil.AgentHasCertificate(sshAgent, host); err == nil && exists {
+	expiry, err := sshutil.AgentCertificateExpiry(sshAgent, host)
+	hasCert := err == nil && !expiry.IsZero()
+
+	// The destination is checked as given, as the ControlPath may be
+	// configured for an alias.
+	renew := false
+	if sshutil.ControlMasterAlive(strings.ToLower(args[0]), args[1]) {
+		if !hasCert || time.Until(expiry) > CONTROL_RENEW_MARGIN {
+			trace.Logf(trace.LEVEL_STEPS, "ssh multiplexes the connection to %s over a running ControlMaster", host)
+
+			// The connection is already authenticated, therefore do not
+			// request a new certificate
+			return
+		}
+
+		trace.Logf(trace.LEVEL_STEPS, "Certificate of the ControlMaster for %s expires at %s, renewing it", host,
+			expiry.Format(time.RFC3339))
+
+		renew = true
+	} else if token := os.Getenv(tokenbind.ENV_TOKEN); hasCert && token != "" && !sshutil.AgentCertificateBound(sshAgent, host, token) {
+		trace.Logf(trace.LEVEL_STEPS, "Certificate for %s is bound to another access token than %s, replacing it", host,
+			tokenbind.ENV_TOKEN)
+
+		// ssh sends the token, which the host would reject for this
+		// certificate
+		renew = true
+	} else if hasCert {
+		trace.Logf(trace.LEVEL_STEPS, "ssh-agent already holds a valid certificate for %s", host)
+
 		// Agent already holds certificate, therefore do not request a new one
 		return
 	}
 
-	// Try to get token from environment variable
-	token := util.Getenvs("ACCESS_TOKEN", "OIDC", "OS_ACCESS_TOKEN",
-		"OIDC_ACCESS_TOKEN", "WATTS_TOKEN", "WATTSON_TOKEN")
+	target := &certificateRequest{
+		host:     host,
+		ca:       ca,
+		caClient: newCAClient(ca),
+	}
+
+	// The chain is resolved for the destination as given, as the ProxyJump
+	// option may be configured for an alias.
+	reqs := append([]*certificateRequest{target}, proxyJumpRequests(sshAgent, strings.ToLower(args[0]), args[1])...)
 
-	if token == "" {
-		// Use oidc-agent to get token.
-		// getTokenFromOidcAgent() exits with -1 for any errors.
-		token = getTokenFromOidcAgent(caClient, host)
+	// Show the message of the hostgroup (e.g. announced maintenance) before
+	// connecting, failures are noticed when requesting the certificate.
+	if res, err := target.caClient.GetHost(context.Background(), host); err == nil && res.Message != "" {
+		log.LogInfoTTY("Message for " + host + ": " + res.Message)
 	}
 
-	pubkey, privkey, err := generateEd25519Keys()
-	if err != nil {
-		log.LogFatalTTY("There was an error generating a temporary key pair.")
+	secrets, _ := secretstore.Open()
+
+	// Tokens are obtained one after another, as oidc-agent may prompt the
+	// user. Only the certificate requests run concurrently.
+	for _, req := range reqs {
+		req.token, req.cached = getToken(secrets, req.caClient, req.ca, req.host)
 	}
 
-	res, err := caClient.PostHostCertificate(host, pubkey, token)
-	if err != nil {
-		log.LogFatalTTY("CA responded: " + err.Error())
+	var wg sync.WaitGroup
+	for _, req := range reqs {
+		wg.Add(1)
+		go func(req *certificateRequest) {
+			defer wg.Done()
+			requestCertificate(req)
+		}(req)
 	}
+	wg.Wait()
+
+	for _, req := range reqs {
+		if req.err != nil && req.cached {
+			trace.Logf(trace.LEVEL_STEPS, "CA rejected cached access token for %s: %s", req.host, req.err.Error())
 
-	certPk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(res.Certificate))
+			// The cached token may have been revoked, retry with a fresh one.
+			oinit.ForgetToken(secrets, req.ca, req.host)
+
+			req.token, req.cached = getToken(secrets, req.caClient, req.ca, req.host)
+			requestCertificate(req)
+		}
+	}
+
+	// Certificates for jump hosts are added first, failures are not fatal as
+	// they are requested again when connecting to the jump host.
+	for _, req := range reqs[1:] {
+		if req.err == nil {
+			_, req.err = addCertificate(sshAgent, req)
+		}
+
+		if req.err != nil {
+			log.LogWarnTTY("Could not prefetch certificate for " + req.host + ": " + req.err.Error())
+		}
+	}
+
+	if target.err != nil {
+		log.LogFatalTTY(target.err.Error())
+	}
+
+	// The expiring certificate is replaced, so ssh doesn't offer it
+	if renew {
+		sshutil.AgentRemoveCertificates(sshAgent, host)
+	}
+
+	validUntil, err := addCertificate(sshAgent, target)
 	if err != nil {
-		log.LogFatalTTY("Cannot parse certificate.")
+		log.LogFatalTTY(err.Error())
 	}
 
-	cert := certPk.(*ssh.Certificate)
-	validUntil := time.Unix(int64(cert.ValidBefore-1), 0)
+	log.LogSuccessTTY(fmt.Sprintf("Received a certificate which is valid until %s", validUntil))
+}
 
-	if sshAgent.Add(agent.AddedKey{
-		PrivateKey:   privkey,
-		Certificate:  cert,
-		LifetimeSecs: uint32(time.Until(validUntil).Seconds()),
-	}) != nil {
-		log.LogFatalTTY("Cannot add private key and certificate to ssh-agent.")
+// traceCertificate logs the fields of a certificate received from the CA.
+func traceCertificate(cert *ssh.Certificate) {
+	trace.Logf(trace.LEVEL_DETAILS, "Certificate key id: %s, serial: %d", cert.KeyId, cert.Serial)
+	trace.Logf(trace.LEVEL_DETAILS, "Certificate principals: %s", strings.Join(cert.ValidPrincipals, ", "))
+	trace.Logf(trace.LEVEL_DETAILS, "Certificate valid from %s to %s",
+		time.Unix(int64(cert.ValidAfter), 0).Format(time.RFC3339),
+		time.Unix(int64(cert.ValidBefore), 0).Format(time.RFC3339))
+	trace.Logf(trace.LEVEL_DETAILS, "Certificate signed by %s", ssh.FingerprintSHA256(cert.SignatureKey))
+
+	for _, options := range []map[string]string{cert.CriticalOptions, cert.Extensions} {
+		names := make([]string, 0, len(options))
+		for name := range options {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			trace.Logf(trace.LEVEL_DETAILS, "Certificate option %s", name)
+		}
+	}
+}
+
+// parseOptions parses the global options preceding the command and returns
+// the remaining arguments. Options may also be set in the environment.
+func parseOptions(args []string) []string {
+	verbosity, _ := strconv.Atoi(os.Getenv(ENV_VERBOSE))
+	report := os.Getenv(ENV_REPORT)
+
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		switch arg := args[0]; {
+		case arg == FLAG_REPORT && len(args) >= 2:
+			report = args[1]
+			args = args[1:]
+		case strings.Trim(arg, "-v") == "" && strings.Count(arg, "-") == 1:
+			verbosity = strings.Count(arg, "v")
+		default:
+			return nil
+		}
+
+		args = args[1:]
+	}
+
+	trace.SetLevel(verbosity)
+
+	if report != "" {
+		reportPath = report
+		trace.Record()
+		log.OnFatal(writeReport)
+	}
+
+	return args
+}
+
+// exit writes the debug report, if requested, and exits with the given code.
+func exit(code int) {
+	writeReport()
+	os.Exit(code)
+}
+
+// writeReport writes the debug report requested with --report.
+func writeReport() {
+	if reportPath == "" {
+		return
+	}
+
+	sections := map[string]string{}
+
+	var env []string
+	for _, name := range append([]string{"SSH_AUTH_SOCK", "OIDC_SOCK", "OIDC_REMOTE_SOCK",
+		"OIDC_AGENT_ACCOUNT", "OIDC_ISS", "OIDC_ISSUER", secretstore.ENV_BACKEND, ENV_EXTENSIONS, ENV_COMMAND,
+		oinit.ENV_KEY_TYPE, oinit.ENV_KEY_REUSE, oinit.ENV_KEY_DIR, oinit.ENV_TOKEN_FILE, oinit.ENV_TOKEN_AUDIENCE, oidc.ENV_GITHUB_REQUEST_URL}, tokenEnvVars...) {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		if slices.Contains(tokenEnvVars, name) {
+			value = trace.Redact(value)
+		}
+
+		env = append(env, name+"="+value)
+	}
+	sections["environment"] = strings.Join(env, "\n")
+
+	if hosts, err := oinit.GetManagedHosts(); err == nil {
+		var lines []string
+		for hostport, ca := range hosts {
+			lines = append(lines, hostport+" "+ca)
+		}
+		sort.Strings(lines)
+
+		sections["managed hosts"] = strings.Join(lines, "\n")
 	} else {
-		log.LogSuccessTTY(fmt.Sprintf("Received a certificate which is valid until %s", validUntil))
+		sections["managed hosts"] = "error: " + err.Error()
+	}
+
+	if secrets, err := secretstore.Open(); err == nil {
+		sections["secret store"] = secrets.Name()
+	} else {
+		sections["secret store"] = "unavailable: " + err.Error()
+	}
+
+	if err := trace.WriteReport(reportPath, sections); err != nil {
+		log.LogErrorTTY("Could not write debug report: " + err.Error())
+	} else {
+		log.LogInfoTTY("Debug report written to " + reportPath)
 	}
 }
 
 func main() {
-	args := os.Args[1:]
+	args := parseOptions(os.Args[1:])
 
 	if len(args) == 0 {
 		fmt.Print(USAGE)
 		return
 	}
 
+	defer writeReport()
+
 	switch args[0] {
 	case COMMAND_ADD:
 		handleCommandAdd(args[1:])
@@ -394,6 +1302,16 @@ func main() {
 		handleCommandList()
 	case COMMAND_MATCH:
 		handleCommandMatch(args[1:])
+	case COMMAND_REVOKE:
+		handleCommandRevoke(args[1:])
+	case COMMAND_STATUS:
+		handleCommandStatus(args[1:])
+	case COMMAND_TOKEN:
+		handleCommandToken(args[1:])
+	case COMMAND_TRUST:
+		handleCommandTrust(args[1:])
+	case COMMAND_UPDATE:
+		handleCommandSelfUpdate(args[1:])
 	default:
 		fmt.Print(USAGE)
 	}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/oidc"
+	"github.com/lbrocke/oinit/internal/oinit"
+	"github.com/lbrocke/oinit/internal/secretstore"
+	"github.com/lbrocke/oinit/internal/sshutil"
+	"github.com/lbrocke/oinit/pkg/log"
+	"github.com/lbrocke/oinit/pkg/oinitca"
+
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const (
+	// Timeout of the reachability check of each CA in the status overview
+	STATUS_CA_TIMEOUT = 5 * time.Second
+)
+
+// countdown returns the time remaining until t, rounded to seconds, or
+// "expired" if t has passed.
+func countdown(t time.Time) string {
+	remaining := time.Until(t).Round(time.Second)
+	if remaining <= 0 {
+		return "expired"
+	}
+
+	return "valid for " + remaining.String() + " (until " + t.Format(time.RFC3339) + ")"
+}
+
+// caReachability checks all CAs concurrently and returns a description of
+// their state by CA.
+func caReachability(cas []string) map[string]string {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	states := make(map[string]string, len(cas))
+
+	for _, ca := range cas {
+		wg.Add(1)
+		go func(ca string) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), STATUS_CA_TIMEOUT)
+			defer cancel()
+
+			state := "reachable"
+
+			health, err := newCAClient(ca).Health(ctx)
+
+			var caErr *oinitca.Error
+			switch {
+			case errors.As(err, &caErr):
+				state = "unhealthy: " + caErr.Error()
+			case err != nil:
+				state = "unreachable: " + err.Error()
+			case health.Status != "" && health.Status != "ok":
+				state = "reachable, status " + health.Status
+			}
+
+			mu.Lock()
+			states[ca] = state
+			mu.Unlock()
+		}(ca)
+	}
+
+	wg.Wait()
+
+	return states
+}
+
+// printTokenSources prints which sources of access tokens are available, in
+// the order that getToken uses them.
+func printTokenSources(secrets secretstore.Store) {
+	fmt.Println("Token sources:")
+
+	switch path := os.Getenv(oinit.ENV_TOKEN_FILE); {
+	case path != "":
+		if _, err := os.Stat(path); err != nil {
+			fmt.Println("\tworkload token:\t" + path + " (" + err.Error() + ")")
+		} else {
+			fmt.Println("\tworkload token:\t" + path)
+		}
+	case oidc.GitHubActionsAvailable():
+		fmt.Println("\tworkload token:\tGitHub Actions")
+	default:
+		fmt.Println("\tworkload token:\tnone")
+	}
+
+	env := "none"
+	for _, name := range tokenEnvVars {
+		if os.Getenv(name) != "" {
+			env = name
+			break
+		}
+	}
+	fmt.Println("\tenvironment:\t" + env)
+
+	if secrets != nil {
+		fmt.Println("\tsecret store:\t" + secrets.Name())
+	} else {
+		fmt.Println("\tsecret store:\tunavailable, tokens are not cached")
+	}
+
+	if oidc.AgentIsRunning() {
+		fmt.Println("\toidc-agent:\trunning")
+	} else {
+		fmt.Println("\toidc-agent:\tnot running")
+	}
+}
+
+// handleStatusOverview handles the 'status' command without arguments. It
+// prints whether ssh is configured to use oinit, which sources of access
+// tokens are available and, for all managed hosts, whether their CA is
+// reachable, as well as the certificates in ssh-agent and cached tokens.
+// No tokens are requested, so it never prompts.
+func handleStatusOverview() {
+	if present, err := sshutil.HasSSHMatchBlock(); err != nil {
+		log.LogError("Could not read ssh config: " + err.Error())
+	} else if present {
+		log.LogSuccess("ssh is configured to use oinit.")
+	} else {
+		log.LogWarn("ssh is not configured to use oinit, run 'oinit add' to configure it.")
+	}
+
+	var sshAgent agent.ExtendedAgent
+	if sshutil.AgentIsRunning() {
+		if a, err := sshutil.GetAgent(); err == nil {
+			sshAgent = a
+		}
+	}
+
+	if sshAgent == nil {
+		log.LogWarn("ssh-agent is not running, certificates cannot be used.")
+	}
+
+	secrets, _ := secretstore.Open()
+
+	printTokenSources(secrets)
+
+	all, err := oinit.GetManagedHosts()
+	if err != nil {
+		log.LogError("Could not load hosts: " + err.Error())
+		return
+	}
+
+	if len(all) == 0 {
+		log.LogInfo("No hosts are managed by oinit.")
+		return
+	}
+
+	hosts := make([]string, 0, len(all))
+	cas := []string{}
+	seen := make(map[string]bool)
+	for hostport, ca := range all {
+		hosts = append(hosts, hostport)
+
+		if !seen[ca] {
+			seen[ca] = true
+			cas = append(cas, ca)
+		}
+	}
+	sort.Strings(hosts)
+
+	reachability := caReachability(cas)
+
+	fmt.Println("Hosts:")
+
+	for _, hostport := range hosts {
+		ca := all[hostport]
+
+		host, _, err := net.SplitHostPort(hostport)
+		if err != nil {
+			host = hostport
+		}
+
+		fmt.Println("\t" + hostport)
+		fmt.Println("\t\tCA:\t\t" + ca + " (" + reachability[ca] + ")")
+
+		certificate := "unknown, ssh-agent is not running"
+		if sshAgent != nil {
+			if expiry, err := sshutil.AgentCertificateExpiry(sshAgent, host); err != nil {
+				certificate = "unknown: " + err.Error()
+			} else if expiry.IsZero() {
+				certificate = "none"
+			} else {
+				certificate = countdown(expiry)
+			}
+		}
+		fmt.Println("\t\tcertificate:\t" + certificate)
+
+		cached := "none"
+		if secrets != nil {
+			if expiry, ok := oinit.CachedTokenExpiry(secrets, ca, host); ok {
+				cached = countdown(expiry)
+			}
+		}
+		fmt.Println("\t\tcached token:\t" + cached)
+	}
+}
@@ -0,0 +1,604 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/oidc"
+	"github.com/lbrocke/oinit/internal/storage"
+	"github.com/lbrocke/oinit/internal/tokenbind"
+	"github.com/lbrocke/oinit/internal/util"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	ERR_ADMIN_UNAUTHORIZED = "admin_unauthorized"
+	ERR_ADMIN_DISABLED     = "admin_disabled"
+	ERR_ADMIN_FORBIDDEN    = "admin_forbidden"
+	ERR_NOT_FOUND          = "not_found"
+	ERR_ALREADY_REVOKED    = "already_revoked"
+	ERR_INVALID_REASON     = "invalid_revocation_reason"
+
+	AUDIT_REVOKE = "revoke"
+
+	// Permissions required by admin endpoints
+	PERM_VIEW    = "view"
+	PERM_AUDIT   = "audit"
+	PERM_REVOKE  = "revoke"
+	PERM_ENROLL  = "enroll"
+	PERM_FREEZE  = "freeze"
+	PERM_PROMOTE = "promote"
+
+	// Number of certificates returned by default
+	ADMIN_CERTIFICATES_LIMIT = 50
+	// Audit events of this duration are returned by default
+	ADMIN_AUDIT_DURATION = 24 * time.Hour
+	// Duration (in seconds) that admins authenticated via OIDC are cached
+	ADMIN_OIDC_CACHE_DURATION = 60
+)
+
+// oidcAdmins caches admins authenticated by OIDC access tokens, the key is
+// the token hash. This avoids a userinfo request for every admin request.
+var oidcAdmins = util.NewTimedCache[string, adminIdentity]()
+
+type adminIdentity struct {
+	Name string
+	Role string
+}
+
+// RolePermissions maps the roles of admin tokens to their permissions.
+var RolePermissions = map[string][]string{
+	config.ROLE_VIEWER:           {PERM_VIEW},
+	config.ROLE_OPERATOR:         {PERM_VIEW, PERM_AUDIT, PERM_FREEZE},
+	config.ROLE_SECURITY_OFFICER: {PERM_VIEW, PERM_AUDIT, PERM_REVOKE, PERM_ENROLL, PERM_FREEZE, PERM_PROMOTE},
+}
+
+type ApiResponseAdminIdentity struct {
+	Name        string   `json:"name"`
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions"`
+}
+
+type ApiResponseAdminHostGroup struct {
+	Name            string            `json:"name"`
+	Hosts           map[string]string `json:"hosts"`
+	CertValidity    string            `json:"cert_validity"`
+	CacheDuration   int               `json:"cache_duration"`
+	HostCAPublicKey string            `json:"host_ca_publickey"`
+	UserCAPublicKey string            `json:"user_ca_publickey"`
+}
+
+type ApiResponseAdminUpstream struct {
+	URL       string `json:"url"`
+	Reachable bool   `json:"reachable"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+	// Health according to requests made since the CA started
+	Health ApiResponseUpstreamHealth `json:"health"`
+}
+
+type ApiResponseAdminCertificate struct {
+	storage.Certificate
+	Revoked          bool       `json:"revoked"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+	RevocationReason string     `json:"revocation_reason,omitempty" example:"key-compromise"`
+}
+
+type FormAdminRevoke struct {
+	Reason string `json:"reason" binding:"required" example:"key-compromise"`
+}
+
+type UriSerial struct {
+	Serial uint64 `uri:"serial" binding:"required"`
+}
+
+type QueryAdminCertificates struct {
+	Limit int `form:"limit"`
+}
+
+type QueryAdminAudit struct {
+	Since time.Time `form:"since" time_format:"2006-01-02T15:04:05Z07:00"`
+}
+
+// AdminAuth is a middleware that aborts requests without a valid admin token
+// or OIDC access token of an admin in the Authorization header. The name and
+// role of the admin are attached to the context as "admin" and "admin_role".
+func AdminAuth(c *gin.Context) {
+	conf, ok := c.MustGet("config").(config.Config)
+	if !ok {
+		c.Abort()
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	if len(conf.AdminTokens) == 0 && len(conf.AdminOIDCRules) == 0 {
+		c.Abort()
+		Error(c, http.StatusForbidden, ERR_ADMIN_DISABLED)
+		return
+	}
+
+	if token := bearerToken(c); token != "" {
+		if admin, ok := authenticateAdmin(c.Request.Context(), conf, token); ok {
+			c.Set("admin", admin.Name)
+			c.Set("admin_role", admin.Role)
+			c.Next()
+			return
+		}
+	}
+
+	c.Abort()
+	Error(c, http.StatusUnauthorized, ERR_ADMIN_UNAUTHORIZED)
+}
+
+// authenticateAdmin returns the admin holding the given token, which is
+// either one of the configured admin tokens or an OIDC access token matching
+// the admin OIDC rules.
+func authenticateAdmin(ctx context.Context, conf config.Config, token string) (adminIdentity, bool) {
+	for _, adminToken := range conf.AdminTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken.Token)) == 1 {
+			return adminIdentity{Name: adminToken.Name, Role: adminToken.Role}, true
+		}
+	}
+
+	if len(conf.AdminOIDCRules) == 0 {
+		return adminIdentity{}, false
+	}
+
+	hash := tokenbind.Hash(token)
+	if admin, ok := oidcAdmins.Get(hash); ok {
+		return admin, true
+	}
+
+	admin, err := authenticateOIDCAdmin(ctx, conf.AdminOIDCRules, token)
+	if err != nil {
+		return adminIdentity{}, false
+	}
+
+	oidcAdmins.Set(hash, admin, ADMIN_OIDC_CACHE_DURATION)
+
+	return admin, true
+}
+
+// authenticateOIDCAdmin verifies the access token at the userinfo endpoint of
+// its issuer and returns the admin with the most privileged role granted by
+// the rules.
+func authenticateOIDCAdmin(ctx context.Context, rules []config.AdminOIDCRule, token string) (adminIdentity, error) {
+	jwtToken, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		return adminIdentity{}, err
+	}
+
+	issuer, err := jwtToken.Claims.GetIssuer()
+	if err != nil {
+		return adminIdentity{}, err
+	}
+
+	if !slices.ContainsFunc(rules, func(rule config.AdminOIDCRule) bool { return rule.Issuer == issuer }) {
+		return adminIdentity{}, errors.New(ERR_ADMIN_UNAUTHORIZED)
+	}
+
+	claims, err := oidc.UserInfo(ctx, issuer, token)
+	if err != nil {
+		return adminIdentity{}, err
+	}
+
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return adminIdentity{}, errors.New(ERR_ADMIN_UNAUTHORIZED)
+	}
+
+	admin := adminIdentity{Name: sub + "@" + issuer}
+	privilege := -1
+
+	for _, rule := range rules {
+		if rule.Issuer != issuer || !slices.Contains(oidc.ClaimValues(claims, rule.Claim), rule.Value) {
+			continue
+		}
+
+		if p := slices.Index(config.Roles, rule.Role); p > privilege {
+			admin.Role = rule.Role
+			privilege = p
+		}
+	}
+
+	if admin.Role == "" {
+		return adminIdentity{}, errors.New(ERR_ADMIN_UNAUTHORIZED)
+	}
+
+	return admin, nil
+}
+
+// RequirePermission returns a middleware that aborts requests of admins whose
+// role lacks the given permission. It must be used after AdminAuth.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !slices.Contains(RolePermissions[c.GetString("admin_role")], permission) {
+			c.Abort()
+			Error(c, http.StatusForbidden, ERR_ADMIN_FORBIDDEN)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func marshalPublicKey(pk ssh.PublicKey) string {
+	if pk == nil {
+		return ""
+	}
+
+	return strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(pk)), "\n")
+}
+
+// GetAdminIdentity is the handler for GET /admin/whoami
+//
+//	@Summary		Get admin identity
+//	@ID				getAdminIdentity
+//	@Description	Return name, role and permissions of the admin token used.
+//	@Tags			admin
+//	@Produce		json
+//	@Security		AdminToken
+//	@Success		200	{object}	ApiResponseAdminIdentity
+//	@Failure		401	{object}	ApiResponseError
+//	@Failure		403	{object}	ApiResponseError
+//	@Router			/admin/whoami [get]
+func GetAdminIdentity(c *gin.Context) {
+	role := c.GetString("admin_role")
+
+	c.JSON(http.StatusOK, ApiResponseAdminIdentity{
+		Name:        c.GetString("admin"),
+		Role:        role,
+		Permissions: RolePermissions[role],
+	})
+}
+
+// GetAdminHostGroups is the handler for GET /admin/hostgroups
+//
+//	@Summary		List host groups
+//	@ID				getAdminHostGroups
+//	@Description	Return all configured host groups.
+//	@Tags			admin
+//	@Produce		json
+//	@Security		AdminToken
+//	@Success		200	{array}		ApiResponseAdminHostGroup
+//	@Failure		401	{object}	ApiResponseError
+//	@Failure		403	{object}	ApiResponseError
+//	@Router			/admin/hostgroups [get]
+func GetAdminHostGroups(c *gin.Context) {
+	conf := c.MustGet("config").(config.Config)
+
+	groups := []ApiResponseAdminHostGroup{}
+	for _, group := range conf.HostGroups {
+		groups = append(groups, ApiResponseAdminHostGroup{
+			Name:            group.Name,
+			Hosts:           group.Hosts,
+			CertValidity:    group.CertValidity,
+			CacheDuration:   group.CacheDuration,
+			HostCAPublicKey: marshalPublicKey(group.HostCAPublicKey),
+			UserCAPublicKey: marshalPublicKey(group.UserCAPublicKey),
+		})
+	}
+
+	c.JSON(http.StatusOK, groups)
+}
+
+// GetAdminUpstreams is the handler for GET /admin/upstreams
+//
+//	@Summary		Check upstreams
+//	@ID				getAdminUpstreams
+//	@Description	Check reachability of all configured motley_cue instances, along with their health according to
+//	@Description	the requests made since the CA started: state of the circuit breaker, last success, error rate and
+//	@Description	latency percentiles of recent requests, and connections reused from the pool.
+//	@Tags			admin
+//	@Produce		json
+//	@Security		AdminToken
+//	@Success		200	{array}		ApiResponseAdminUpstream
+//	@Failure		401	{object}	ApiResponseError
+//	@Failure		403	{object}	ApiResponseError
+//	@Router			/admin/upstreams [get]
+func GetAdminUpstreams(c *gin.Context) {
+	conf := c.MustGet("config").(config.Config)
+
+	// Signing keys by URL, so that instances requiring signed requests
+	// are checked correctly
+	urls := make(map[string][]byte)
+	for _, group := range conf.HostGroups {
+		for _, value := range group.Hosts {
+			for _, url := range config.SplitURLs(value) {
+				urls[url] = group.MotleyCueKey
+			}
+		}
+	}
+
+	sorted := make([]string, 0, len(urls))
+	for url := range urls {
+		sorted = append(sorted, url)
+	}
+	sort.Strings(sorted)
+
+	upstreams := make([]ApiResponseAdminUpstream, len(sorted))
+	done := make(chan bool)
+
+	for i, url := range sorted {
+		go func(i int, url string) {
+			start := time.Now()
+			_, err := motleyCueClient(url, urls[url]).GetInfoContext(c.Request.Context())
+
+			upstreams[i] = ApiResponseAdminUpstream{
+				URL:       url,
+				Reachable: err == nil,
+				LatencyMs: time.Since(start).Milliseconds(),
+				Health:    upstreamHealth.health(url),
+			}
+			if err != nil {
+				upstreams[i].Error = err.Error()
+			}
+
+			done <- true
+		}(i, url)
+	}
+
+	for range sorted {
+		<-done
+	}
+
+	c.JSON(http.StatusOK, upstreams)
+}
+
+// GetAdminCertificates is the handler for GET /admin/certificates
+//
+//	@Summary		List issued certificates
+//	@ID				getAdminCertificates
+//	@Description	Return the most recently issued certificates, newest first.
+//	@Tags			admin
+//	@Produce		json
+//	@Security		AdminToken
+//	@Param			limit	query		int	false	"Maximum number of certificates"
+//	@Success		200		{array}		ApiResponseAdminCertificate
+//	@Failure		400		{object}	ApiResponseError
+//	@Failure		401		{object}	ApiResponseError
+//	@Failure		403		{object}	ApiResponseError
+//	@Failure		500		{object}	ApiResponseError
+//	@Router			/admin/certificates [get]
+func GetAdminCertificates(c *gin.Context) {
+	var query QueryAdminCertificates
+
+	if c.ShouldBindQuery(&query) != nil {
+		Error(c, http.StatusBadRequest, ERR_BAD_BODY)
+		return
+	}
+
+	if query.Limit <= 0 {
+		query.Limit = ADMIN_CERTIFICATES_LIMIT
+	}
+
+	store := c.MustGet("store").(storage.Store)
+
+	certs, err := store.ListCertificates(storage.CertificateFilter{})
+	if err != nil {
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	revocations, err := store.ListRevocations()
+	if err != nil {
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	revoked := make(map[uint64]storage.Revocation)
+	for _, rev := range revocations {
+		revoked[rev.Serial] = rev
+	}
+
+	res := []ApiResponseAdminCertificate{}
+	for i := len(certs) - 1; i >= 0 && len(res) < query.Limit; i-- {
+		res = append(res, adminCertificate(certs[i], revoked))
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// adminCertificate returns the certificate along with its revocation, if it
+// is contained in revoked.
+func adminCertificate(cert storage.Certificate, revoked map[uint64]storage.Revocation) ApiResponseAdminCertificate {
+	res := ApiResponseAdminCertificate{Certificate: cert}
+
+	if rev, ok := revoked[cert.Serial]; ok {
+		res.Revoked = true
+		res.RevokedAt = &rev.RevokedAt
+		res.RevocationReason = rev.Reason
+	}
+
+	return res
+}
+
+// GetAdminCertificate is the handler for GET /admin/certificates/:serial
+//
+//	@Summary		Get certificate status
+//	@ID				getAdminCertificate
+//	@Description	Return the certificate with the given serial number and whether, when and why it was revoked.
+//	@Tags			admin
+//	@Produce		json
+//	@Security		AdminToken
+//	@Param			serial	path		int	true	"Certificate serial number"
+//	@Success		200		{object}	ApiResponseAdminCertificate
+//	@Failure		400		{object}	ApiResponseError
+//	@Failure		401		{object}	ApiResponseError
+//	@Failure		403		{object}	ApiResponseError
+//	@Failure		404		{object}	ApiResponseError
+//	@Failure		500		{object}	ApiResponseError
+//	@Router			/admin/certificates/{serial} [get]
+func GetAdminCertificate(c *gin.Context) {
+	var uri UriSerial
+
+	if c.ShouldBindUri(&uri) != nil {
+		Error(c, http.StatusBadRequest, ERR_BAD_BODY)
+		return
+	}
+
+	store := c.MustGet("store").(storage.Store)
+
+	cert, err := store.GetCertificate(uri.Serial)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			Error(c, http.StatusNotFound, ERR_NOT_FOUND)
+		} else {
+			Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		}
+		return
+	}
+
+	revocations, err := store.ListRevocations()
+	if err != nil {
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	revoked := make(map[uint64]storage.Revocation)
+	for _, rev := range revocations {
+		revoked[rev.Serial] = rev
+	}
+
+	c.JSON(http.StatusOK, adminCertificate(cert, revoked))
+}
+
+// PostAdminRevoke is the handler for POST /admin/certificates/:serial/revoke
+//
+//	@Summary		Revoke certificate
+//	@ID				revokeCertificate
+//	@Description	Revoke the certificate with the given serial number. It is added to the KRL of its CA. The
+//	@Description	reason must be one of key-compromise, user-left, policy-violation or superseded.
+//	@Tags			admin
+//	@Accept			json
+//	@Produce		json
+//	@Security		AdminToken
+//	@Param			serial	path		int				true	"Certificate serial number"
+//	@Param			body	body		FormAdminRevoke	true	"Revocation reason"
+//	@Success		200		{object}	storage.Revocation
+//	@Failure		400		{object}	ApiResponseError
+//	@Failure		401		{object}	ApiResponseError
+//	@Failure		403		{object}	ApiResponseError
+//	@Failure		404		{object}	ApiResponseError
+//	@Failure		409		{object}	ApiResponseError
+//	@Failure		500		{object}	ApiResponseError
+//	@Router			/admin/certificates/{serial}/revoke [post]
+func PostAdminRevoke(c *gin.Context) {
+	var uri UriSerial
+
+	if c.ShouldBindUri(&uri) != nil {
+		Error(c, http.StatusBadRequest, ERR_BAD_BODY)
+		return
+	}
+
+	var body FormAdminRevoke
+
+	if c.ShouldBindJSON(&body) != nil {
+		Error(c, http.StatusBadRequest, ERR_INVALID_REASON)
+		return
+	}
+
+	revocation, err := Revoke(c.MustGet("store").(storage.Store), uri.Serial, c.GetString("admin"), body.Reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrUnknownReason):
+			Error(c, http.StatusBadRequest, ERR_INVALID_REASON)
+		case errors.Is(err, storage.ErrNotFound):
+			Error(c, http.StatusNotFound, ERR_NOT_FOUND)
+		case errors.Is(err, storage.ErrAlreadyRevoked):
+			Error(c, http.StatusConflict, ERR_ALREADY_REVOKED)
+		default:
+			Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, revocation)
+}
+
+// Revoke revokes the certificate with the given serial number for the given
+// reason, which must be one of storage.RevocationReasons, and adds an audit
+// event for the given actor.
+func Revoke(store storage.Store, serial uint64, actor, reason string) (storage.Revocation, error) {
+	if !slices.Contains(storage.RevocationReasons, reason) {
+		return storage.Revocation{}, fmt.Errorf("%w: %s", storage.ErrUnknownReason, reason)
+	}
+
+	cert, err := store.GetCertificate(serial)
+	if err != nil {
+		return storage.Revocation{}, err
+	}
+
+	revocation := storage.Revocation{
+		Serial:      cert.Serial,
+		CA:          cert.CA,
+		RevokedAt:   time.Now(),
+		Reason:      reason,
+		ValidBefore: cert.ValidBefore,
+	}
+
+	if err := store.Revoke(revocation); err != nil {
+		return revocation, err
+	}
+
+	store.AddAuditEvent(storage.AuditEvent{
+		Time:   revocation.RevokedAt,
+		Action: AUDIT_REVOKE,
+		Actor:  actor,
+		Details: map[string]string{
+			"serial":  strconv.FormatUint(cert.Serial, 10),
+			"subject": cert.Subject,
+			"reason":  reason,
+		},
+	})
+
+	return revocation, nil
+}
+
+// GetAdminAudit is the handler for GET /admin/audit
+//
+//	@Summary		Get audit trail
+//	@ID				getAdminAudit
+//	@Description	Return audit events, such as issued and denied certificates, since the given time (default: last 24 hours).
+//	@Tags			admin
+//	@Produce		json
+//	@Security		AdminToken
+//	@Param			since	query		string	false	"RFC 3339 timestamp"
+//	@Success		200		{array}		storage.AuditEvent
+//	@Failure		400		{object}	ApiResponseError
+//	@Failure		401		{object}	ApiResponseError
+//	@Failure		403		{object}	ApiResponseError
+//	@Failure		500		{object}	ApiResponseError
+//	@Router			/admin/audit [get]
+func GetAdminAudit(c *gin.Context) {
+	var query QueryAdminAudit
+
+	if c.ShouldBindQuery(&query) != nil {
+		Error(c, http.StatusBadRequest, ERR_BAD_BODY)
+		return
+	}
+
+	if query.Since.IsZero() {
+		query.Since = time.Now().Add(-ADMIN_AUDIT_DURATION)
+	}
+
+	events, err := c.MustGet("store").(storage.Store).ListAuditEvents(query.Since)
+	if err != nil {
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	c.JSON(http.StatusOK, events)
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminPermissions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	conf := config.Config{
+		AdminTokens: []config.AdminToken{
+			{Name: "alice", Token: "viewer-token", Role: config.ROLE_VIEWER},
+			{Name: "bob", Token: "officer-token", Role: config.ROLE_SECURITY_OFFICER},
+			{Name: "carol", Token: "operator-token", Role: config.ROLE_OPERATOR},
+		},
+	}
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("config", conf) })
+
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	admin := router.Group("/admin", AdminAuth)
+	admin.GET("/view", RequirePermission(PERM_VIEW), ok)
+	admin.POST("/revoke", RequirePermission(PERM_REVOKE), ok)
+	admin.POST("/promote", RequirePermission(PERM_PROMOTE), ok)
+
+	tests := []struct {
+		method string
+		path   string
+		token  string
+		status int
+	}{
+		{http.MethodGet, "/admin/view", "", http.StatusUnauthorized},
+		{http.MethodGet, "/admin/view", "wrong", http.StatusUnauthorized},
+		{http.MethodGet, "/admin/view", "viewer-token", http.StatusOK},
+		{http.MethodPost, "/admin/revoke", "viewer-token", http.StatusForbidden},
+		{http.MethodGet, "/admin/view", "officer-token", http.StatusOK},
+		{http.MethodPost, "/admin/revoke", "officer-token", http.StatusOK},
+		{http.MethodPost, "/admin/promote", "operator-token", http.StatusForbidden},
+		{http.MethodPost, "/admin/promote", "officer-token", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		if test.token != "" {
+			req.Header.Set("Authorization", "Bearer "+test.token)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, test.status, w.Code, test.method+" "+test.path+" with "+test.token)
+	}
+}
+
+func TestAuthenticateOIDCAdmin(t *testing.T) {
+	var issuer string
+
+	op := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{"userinfo_endpoint": issuer + "/userinfo"})
+		case "/userinfo":
+			if r.Header.Get("Authorization") == "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"sub":                   "alice",
+				"eduperson_entitlement": []string{"urn:example:ca-viewers", "urn:example:ca-admins"},
+			})
+		}
+	}))
+	defer op.Close()
+
+	issuer = op.URL
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"iss": issuer}).SignedString([]byte("secret"))
+	assert.NoError(t, err)
+
+	rules := []config.AdminOIDCRule{
+		{Issuer: issuer, Claim: "eduperson_entitlement", Value: "urn:example:ca-viewers", Role: config.ROLE_VIEWER},
+		{Issuer: issuer, Claim: "eduperson_entitlement", Value: "urn:example:ca-admins", Role: config.ROLE_SECURITY_OFFICER},
+		{Issuer: issuer, Claim: "eduperson_entitlement", Value: "urn:example:ca-operators", Role: config.ROLE_OPERATOR},
+	}
+
+	admin, err := authenticateOIDCAdmin(context.Background(), rules, token)
+	assert.NoError(t, err)
+	assert.Equal(t, "alice@"+issuer, admin.Name)
+	assert.Equal(t, config.ROLE_SECURITY_OFFICER, admin.Role)
+
+	// No rule matches the entitlements.
+	_, err = authenticateOIDCAdmin(context.Background(), rules[2:], token)
+	assert.EqualError(t, err, ERR_ADMIN_UNAUTHORIZED)
+
+	// Issuer is not configured.
+	other, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"iss": "https://op.example.com"}).SignedString([]byte("secret"))
+	assert.NoError(t, err)
+
+	_, err = authenticateOIDCAdmin(context.Background(), rules, other)
+	assert.EqualError(t, err, ERR_ADMIN_UNAUTHORIZED)
+}
+
+func TestAdminRevokeReason(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	store := storage.NewMemoryStore()
+	addTestCertificates(store, "alice@issuer", 2)
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("store", store) })
+	router.GET("/certificates/:serial", GetAdminCertificate)
+	router.POST("/certificates/:serial/revoke", PostAdminRevoke)
+
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		return w
+	}
+
+	w := request(http.MethodPost, "/certificates/1/revoke", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), ERR_INVALID_REASON)
+
+	w = request(http.MethodPost, "/certificates/1/revoke", `{"reason":"bored"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), ERR_INVALID_REASON)
+
+	w = request(http.MethodPost, "/certificates/1/revoke", `{"reason":"user-left"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var res ApiResponseAdminCertificate
+
+	w = request(http.MethodGet, "/certificates/1", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.True(t, res.Revoked)
+	assert.NotNil(t, res.RevokedAt)
+	assert.Equal(t, storage.REASON_USER_LEFT, res.RevocationReason)
+
+	res = ApiResponseAdminCertificate{}
+	w = request(http.MethodGet, "/certificates/2", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.False(t, res.Revoked)
+	assert.Nil(t, res.RevokedAt)
+
+	w = request(http.MethodGet, "/certificates/3", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,17 @@
+package api
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed ui/admin.html
+var adminUI []byte
+
+// GetAdminUI serves the admin dashboard. The page itself is public, all data
+// is loaded from the admin API using the token entered by the user.
+func GetAdminUI(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", adminUI)
+}
@@ -0,0 +1,326 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/tokenbind"
+	"github.com/lbrocke/oinit/internal/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Preference of clients that accept 202 Accepted, see RFC 7240
+	PREFER_RESPOND_ASYNC = "respond-async"
+
+	// Duration (in seconds) that responses of asynchronous requests are
+	// kept after they completed
+	ASYNC_RESULT_DURATION = 300
+
+	// Seconds that clients should wait before polling again
+	ASYNC_RETRY_AFTER = 1
+
+	ASYNC_PENDING = "pending"
+
+	// Maximum size of request bodies handled asynchronously
+	MAX_ASYNC_BODY = 1 << 20
+)
+
+// ApiResponseAsync is returned with 202 Accepted while a request is
+// processed. The Location header points to GET /requests/{id}.
+type ApiResponseAsync struct {
+	RequestID string `json:"request_id" example:"9f86d081884c7d659a2feaa0c55ad015"`
+	Status    string `json:"status" example:"pending"`
+}
+
+type UriRequest struct {
+	ID string `uri:"id" binding:"required"`
+}
+
+// asyncRequest is a request that is handled in the background. The response
+// can only be read once done is closed.
+type asyncRequest struct {
+	// Hash of the access token of the request, which polls must present
+	token  string
+	done   chan struct{}
+	writer *asyncWriter
+}
+
+// asyncRequests contains requests that are processed in the background or
+// whose responses were not yet expired, by request ID.
+var asyncRequests = util.NewTimedCache[string, *asyncRequest]()
+
+// asyncWriter records the response of a request handled in the background.
+type asyncWriter struct {
+	mu     sync.Mutex
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newAsyncWriter() *asyncWriter {
+	return &asyncWriter{header: make(http.Header)}
+}
+
+func (w *asyncWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *asyncWriter) WriteHeader(status int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.body.Len() == 0 {
+		w.status = status
+	}
+}
+
+func (w *asyncWriter) WriteHeaderNow() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+}
+
+func (w *asyncWriter) Write(data []byte) (int, error) {
+	w.WriteHeaderNow()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.body.Write(data)
+}
+
+func (w *asyncWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *asyncWriter) Status() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.status == 0 {
+		return http.StatusOK
+	}
+
+	return w.status
+}
+
+func (w *asyncWriter) Size() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.body.Len()
+}
+
+func (w *asyncWriter) Written() bool {
+	return w.Size() > 0
+}
+
+func (w *asyncWriter) Flush() {}
+
+func (w *asyncWriter) Pusher() http.Pusher {
+	return nil
+}
+
+func (w *asyncWriter) CloseNotify() <-chan bool {
+	return nil
+}
+
+func (w *asyncWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errors.New("hijacking is not supported")
+}
+
+// replay writes the recorded response to c.
+func (w *asyncWriter) replay(c *gin.Context) {
+	for key, values := range w.header {
+		for _, value := range values {
+			c.Writer.Header().Add(key, value)
+		}
+	}
+
+	c.Data(w.Status(), w.header.Get("Content-Type"), w.body.Bytes())
+}
+
+// detachedContext carries the values of its parent, but is not canceled
+// with it, so that requests can complete after the client received 202
+// Accepted.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+// prefersAsync reports whether the Prefer header contains respond-async.
+func prefersAsync(header string) bool {
+	for _, preference := range strings.Split(header, ",") {
+		name, _, _ := strings.Cut(preference, ";")
+		name, _, _ = strings.Cut(name, "=")
+
+		if strings.EqualFold(strings.TrimSpace(name), PREFER_RESPOND_ASYNC) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requestToken returns the access token of the request, from either the
+// Authorization header or the token field of the body.
+func requestToken(c *gin.Context, body []byte) string {
+	if token := bearerToken(c); token != "" {
+		return token
+	}
+
+	var form FormHostCertificate
+	json.Unmarshal(body, &form)
+
+	return form.Token
+}
+
+// Async wraps the handler, so that clients that prefer respond-async receive
+// 202 Accepted if the request takes longer than async-after, e.g. because
+// motley_cue stalls, instead of being cut off by load balancer timeouts. The
+// request is then completed in the background and its response is returned
+// by GET /requests/{id} to clients presenting the same access token.
+func Async(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		conf, ok := c.MustGet("config").(config.Config)
+		if !ok || conf.Server.AsyncAfter < 0 || !prefersAsync(c.GetHeader("Prefer")) {
+			handler(c)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, MAX_ASYNC_BODY))
+		if err != nil {
+			Error(c, http.StatusBadRequest, ERR_BAD_BODY)
+			return
+		}
+
+		token := requestToken(c, body)
+		if token == "" {
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+			handler(c)
+			return
+		}
+
+		// The deadline of the request still applies, but not the
+		// cancellation once the client received 202 Accepted
+		ctx := context.Context(detachedContext{c.Request.Context()})
+		cancel := context.CancelFunc(func() {})
+		if deadline, ok := c.Request.Context().Deadline(); ok {
+			ctx, cancel = context.WithDeadline(ctx, deadline)
+		}
+
+		background := c.Copy()
+		background.Request = c.Request.Clone(ctx)
+		background.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		req := &asyncRequest{
+			token:  tokenbind.Hash(token),
+			done:   make(chan struct{}),
+			writer: newAsyncWriter(),
+		}
+		background.Writer = req.writer
+
+		id := c.GetString("request_id")
+		asyncRequests.Prune()
+		asyncRequests.Set(id, req, ASYNC_RESULT_DURATION)
+
+		go func() {
+			defer func() {
+				// Not covered by the recovery middleware
+				if r := recover(); r != nil {
+					Logger.Printf("Panic while handling request %s: %v", id, r)
+					Error(background, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+				}
+
+				cancel()
+				close(req.done)
+
+				// Keep the response for the full duration after completion
+				asyncRequests.Set(id, req, ASYNC_RESULT_DURATION)
+			}()
+
+			handler(background)
+		}()
+
+		select {
+		case <-req.done:
+			req.writer.replay(c)
+		case <-time.After(time.Duration(conf.Server.AsyncAfter) * time.Second):
+			c.Header("Location", basePath(c)+"/api/v1/requests/"+id)
+			c.Header("Retry-After", strconv.Itoa(ASYNC_RETRY_AFTER))
+			c.Header("Preference-Applied", PREFER_RESPOND_ASYNC)
+			c.JSON(http.StatusAccepted, ApiResponseAsync{
+				RequestID: id,
+				Status:    ASYNC_PENDING,
+			})
+		}
+	}
+}
+
+// GetRequest is the handler for GET /requests/:id
+//
+//	@Summary		Get result of asynchronous request
+//	@ID				getRequest
+//	@Description	Return the response of a certificate request that was answered with 202 Accepted, which is the
+//	@Description	same as if the request had been handled synchronously, or 202 Accepted while it is still
+//	@Description	processed. The access token of the request must be sent. Responses are kept for five minutes.
+//	@Produce		json
+//	@Param			id				path		string	true	"Request ID"
+//	@Param			Authorization	header		string	true	"Bearer access token of the request"
+//	@Success		201				{object}	ApiResponseCertificate
+//	@Success		202				{object}	ApiResponseAsync
+//	@Failure		404				{object}	ApiResponseError
+//	@Router			/requests/{id} [get]
+func GetRequest(c *gin.Context) {
+	var uri UriRequest
+
+	if c.ShouldBindUri(&uri) != nil {
+		Error(c, http.StatusBadRequest, ERR_BAD_BODY)
+		return
+	}
+
+	// Requests of other users are indistinguishable from unknown ones
+	req, ok := asyncRequests.Get(uri.ID)
+	if !ok || req.token != tokenbind.Hash(bearerToken(c)) {
+		Error(c, http.StatusNotFound, ERR_NOT_FOUND)
+		return
+	}
+
+	select {
+	case <-req.done:
+		req.writer.replay(c)
+	default:
+		c.Header("Retry-After", strconv.Itoa(ASYNC_RETRY_AFTER))
+		c.JSON(http.StatusAccepted, ApiResponseAsync{
+			RequestID: uri.ID,
+			Status:    ASYNC_PENDING,
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAsync(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	conf := config.Config{Server: config.ServerOptions{AsyncAfter: 1}}
+	release := make(chan struct{})
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("config", conf) })
+	router.Use(RequestID)
+	router.POST("/:host/certificate", Async(func(c *gin.Context) {
+		var body FormHostCertificate
+		assert.NoError(t, c.ShouldBindJSON(&body))
+
+		if c.Param("host") == "slow.example.com" {
+			<-release
+		}
+
+		c.JSON(http.StatusCreated, ApiResponseCertificate{Certificate: body.Publickey})
+	}))
+	router.GET("/requests/:id", GetRequest)
+
+	request := func(method, path, token string, async bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(`{"publickey": "ssh-ed25519 AAAA"}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		if async {
+			req.Header.Set("Prefer", "respond-async, wait=5")
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		return w
+	}
+
+	// Fast requests are answered directly
+	w := request(http.MethodPost, "/fast.example.com/certificate", TEST_TOKEN, true)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.JSONEq(t, `{"certificate": "ssh-ed25519 AAAA"}`, w.Body.String())
+
+	w = request(http.MethodPost, "/slow.example.com/certificate", TEST_TOKEN, true)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	var accepted ApiResponseAsync
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &accepted))
+	assert.Equal(t, ASYNC_PENDING, accepted.Status)
+	assert.Equal(t, "/api/v1/requests/"+accepted.RequestID, w.Header().Get("Location"))
+
+	poll := "/requests/" + accepted.RequestID
+
+	assert.Equal(t, http.StatusAccepted, request(http.MethodGet, poll, TEST_TOKEN, false).Code)
+
+	// Only the client of the request may poll it
+	assert.Equal(t, http.StatusNotFound, request(http.MethodGet, poll, "other", false).Code)
+	assert.Equal(t, http.StatusNotFound, request(http.MethodGet, "/requests/unknown", TEST_TOKEN, false).Code)
+
+	close(release)
+
+	assert.Eventually(t, func() bool {
+		return request(http.MethodGet, poll, TEST_TOKEN, false).Code == http.StatusCreated
+	}, time.Second, 10*time.Millisecond)
+
+	w = request(http.MethodGet, poll, TEST_TOKEN, false)
+	assert.JSONEq(t, `{"certificate": "ssh-ed25519 AAAA"}`, w.Body.String())
+	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+
+	assert.True(t, prefersAsync("wait=10; foo, Respond-Async"))
+	assert.False(t, prefersAsync("return=minimal"))
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/lbrocke/oinit/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	HEADER_FORWARDED_PREFIX = "X-Forwarded-Prefix"
+)
+
+// BasePath returns a middleware that determines the path that clients reach
+// the CA at, which self-referential URLs in responses (Location headers,
+// redirects and the API documentation) are prefixed with. It is taken from
+// the X-Forwarded-Prefix header of trusted proxies, and defaults to base.
+func BasePath(base string, proxies []string) gin.HandlerFunc {
+	isTrusted := trustedProxies(proxies)
+
+	return func(c *gin.Context) {
+		path := base
+
+		if header := c.GetHeader(HEADER_FORWARDED_PREFIX); header != "" && isTrusted(net.ParseIP(c.RemoteIP())) {
+			// Only the first of several values appended by proxies is used
+			first, _, _ := strings.Cut(header, ",")
+			if prefix, err := config.CleanBasePath(strings.TrimSpace(first)); err == nil {
+				path = prefix
+			}
+		}
+
+		c.Set("base_path", path)
+		c.Next()
+	}
+}
+
+// basePath returns the path that the client reached the CA at, without a
+// trailing slash, see BasePath.
+func basePath(c *gin.Context) string {
+	return c.GetString("base_path")
+}
+
+// StripBasePath returns a handler that removes the base path from the paths
+// of requests before passing them to h, for reverse proxies that forward
+// requests without removing it. Other requests are passed unchanged, so that
+// proxies may also remove it.
+func StripBasePath(base string, h http.Handler) http.Handler {
+	if base == "" {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, ok := strings.CutPrefix(r.URL.Path, base)
+		if !ok || (path != "" && !strings.HasPrefix(path, "/")) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		if path == "" {
+			path = "/"
+		}
+
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = path
+		r2.URL.RawPath = ""
+		if rawPath, ok := strings.CutPrefix(r.URL.RawPath, base); ok {
+			r2.URL.RawPath = rawPath
+		}
+
+		h.ServeHTTP(w, r2)
+	})
+}
+
+// trustedProxies returns a function reporting whether an address is one of
+// the addresses and CIDR ranges of trusted-proxies.
+func trustedProxies(proxies []string) func(net.IP) bool {
+	var trusted []*net.IPNet
+	for _, proxy := range proxies {
+		if _, ipnet, err := net.ParseCIDR(proxy); err == nil {
+			trusted = append(trusted, ipnet)
+		} else if ip := net.ParseIP(proxy); ip != nil {
+			trusted = append(trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+		}
+	}
+
+	return func(ip net.IP) bool {
+		for _, ipnet := range trusted {
+			if ipnet.Contains(ip) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
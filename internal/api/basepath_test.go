@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBasePath(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(BasePath("/oinit-ca", []string{"10.0.0.0/8", "192.0.2.1"}))
+	router.GET("/api/v1/path", func(c *gin.Context) {
+		c.String(http.StatusOK, basePath(c))
+	})
+
+	handler := StripBasePath("/oinit-ca", router)
+
+	for _, test := range []struct {
+		path, remote, prefix string
+		status               int
+		base                 string
+	}{
+		{"/api/v1/path", "198.51.100.1:1234", "", http.StatusOK, "/oinit-ca"},
+		{"/oinit-ca/api/v1/path", "198.51.100.1:1234", "", http.StatusOK, "/oinit-ca"},
+		{"/oinit-caa/api/v1/path", "198.51.100.1:1234", "", http.StatusNotFound, ""},
+		// Only trusted proxies may set the prefix
+		{"/api/v1/path", "198.51.100.1:1234", "/portal", http.StatusOK, "/oinit-ca"},
+		{"/api/v1/path", "10.1.2.3:1234", "/portal/", http.StatusOK, "/portal"},
+		{"/api/v1/path", "192.0.2.1:1234", "/portal, /other", http.StatusOK, "/portal"},
+		{"/api/v1/path", "192.0.2.1:1234", "https://evil.example.com", http.StatusOK, "/oinit-ca"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, test.path, nil)
+		req.RemoteAddr = test.remote
+		if test.prefix != "" {
+			req.Header.Set(HEADER_FORWARDED_PREFIX, test.prefix)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		assert.Equal(t, test.status, w.Code, test.path)
+		if test.status == http.StatusOK {
+			assert.Equal(t, test.base, w.Body.String(), test.path+" "+test.prefix)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/sshutil"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// Response formats of POST /:host/certificate
+	FORMAT_JSON   = "json"
+	FORMAT_BUNDLE = "bundle"
+
+	// Directory that the bundle is meant to be extracted to, as referenced
+	// by its ssh_config snippet
+	BUNDLE_DIR = "~/.ssh/oinit"
+
+	BUNDLE_CONTENT_TYPE = "application/gzip"
+)
+
+// bundleFile is a file of a certificate bundle.
+type bundleFile struct {
+	name string
+	data []byte
+}
+
+// identityFile returns the default path of the private key matching the
+// public key type, as used by ssh if no IdentityFile is configured.
+func identityFile(pubkey ssh.PublicKey) string {
+	switch pubkey.Type() {
+	case ssh.KeyAlgoED25519:
+		return "~/.ssh/id_ed25519"
+	case ssh.KeyAlgoSKED25519:
+		return "~/.ssh/id_ed25519_sk"
+	case ssh.KeyAlgoSKECDSA256:
+		return "~/.ssh/id_ecdsa_sk"
+	case ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521:
+		return "~/.ssh/id_ecdsa"
+	default:
+		return "~/.ssh/id_rsa"
+	}
+}
+
+// sshConfigSnippet returns a Host block that uses the certificate and known
+// hosts file of the bundle for the host.
+func sshConfigSnippet(host string, cert *ssh.Certificate) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# Generated by oinit-ca for %s, valid until %s.\n", host, time.Unix(int64(cert.ValidBefore), 0).UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "# Extract the bundle to %s and append this block to ~/.ssh/config.\n", BUNDLE_DIR)
+	fmt.Fprintf(&b, "Host %s\n", host)
+
+	// Principals restricted to the host are suffixed with it
+	if len(cert.ValidPrincipals) > 0 {
+		fmt.Fprintf(&b, "\tUser %s\n", strings.TrimSuffix(cert.ValidPrincipals[0], "@"+host))
+	}
+
+	fmt.Fprintf(&b, "\t# Adjust if the private key of the certificate is stored elsewhere\n")
+	fmt.Fprintf(&b, "\tIdentityFile %s\n", identityFile(cert.Key))
+	fmt.Fprintf(&b, "\tCertificateFile %s/%s-cert.pub\n", BUNDLE_DIR, host)
+	fmt.Fprintf(&b, "\tUserKnownHostsFile %s/known_hosts ~/.ssh/known_hosts\n", BUNDLE_DIR)
+
+	return b.String()
+}
+
+// certificateBundle returns a gzipped tarball containing the certificate,
+// a known_hosts file trusting the host CA, the KRL of the user CA and an
+// ssh_config snippet, so users of plain ssh can set up access in one
+// download.
+func certificateBundle(store storage.Store, info config.HostInfo, host, certificate string) ([]byte, error) {
+	pubkey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(certificate))
+	if err != nil {
+		return nil, err
+	}
+
+	cert, ok := pubkey.(*ssh.Certificate)
+	if !ok {
+		return nil, errors.New("not a certificate")
+	}
+
+	hostCA := strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(info.HostCAPublicKey)), "\n")
+	knownHosts, err := sshutil.GenerateKnownHosts(host, "22", hostCA)
+	if err != nil {
+		return nil, err
+	}
+
+	krl, err := generateKRL(store, info.UserCAPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []bundleFile{
+		{host + "-cert.pub", []byte(certificate + "\n")},
+		{"known_hosts", []byte(knownHosts + "\n")},
+		{"revoked.krl", krl},
+		{"ssh_config", []byte(sshConfigSnippet(host, cert))},
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+
+	for _, file := range files {
+		header := &tar.Header{
+			Name:    "oinit-" + host + "/" + file.name,
+			Mode:    0644,
+			Size:    int64(len(file.data)),
+			ModTime: now,
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, err
+		}
+
+		if _, err := tw.Write(file.data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// respondCertificate returns the issued certificate in the requested format.
+func respondCertificate(c *gin.Context, store storage.Store, info config.HostInfo, host, format, certificate string) {
+	if format != FORMAT_BUNDLE {
+		c.JSON(http.StatusCreated, ApiResponseCertificate{
+			Certificate: certificate,
+		})
+		return
+	}
+
+	bundle, err := certificateBundle(store, info, host, certificate)
+	if err != nil {
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="oinit-`+host+`.tar.gz"`)
+	c.Data(http.StatusCreated, BUNDLE_CONTENT_TYPE, bundle)
+}
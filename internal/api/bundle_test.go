@@ -0,0 +1,72 @@
+package api
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCertificateBundle(t *testing.T) {
+	userPub, userPriv, _ := ed25519.GenerateKey(rand.Reader)
+	hostPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	keyPub, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	userCA, _ := ssh.NewSignerFromKey(userPriv)
+	userCAPubkey, _ := ssh.NewPublicKey(userPub)
+	hostCAPubkey, _ := ssh.NewPublicKey(hostPub)
+	pubkey, _ := ssh.NewPublicKey(keyPub)
+
+	cert := ssh.Certificate{
+		Key:             pubkey,
+		Serial:          1,
+		CertType:        ssh.UserCert,
+		KeyId:           "oinit@login.example.com",
+		ValidPrincipals: []string{"alice"},
+		ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+	}
+	assert.NoError(t, cert.SignCert(rand.Reader, userCA))
+
+	certificate := strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(&cert)), "\n")
+	info := config.HostInfo{Keys: config.Keys{HostCAPublicKey: hostCAPubkey, UserCAPublicKey: userCAPubkey}}
+
+	data, err := certificateBundle(storage.NewMemoryStore(), info, "login.example.com", certificate)
+	assert.NoError(t, err)
+
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	assert.NoError(t, err)
+
+	files := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+
+		content, _ := io.ReadAll(tr)
+		files[header.Name] = string(content)
+	}
+
+	assert.Len(t, files, 4)
+	assert.Equal(t, certificate+"\n", files["oinit-login.example.com/login.example.com-cert.pub"])
+	assert.True(t, strings.HasPrefix(files["oinit-login.example.com/known_hosts"], "@cert-authority login.example.com ssh-ed25519 "))
+	assert.Contains(t, files, "oinit-login.example.com/revoked.krl")
+
+	snippet := files["oinit-login.example.com/ssh_config"]
+	assert.Contains(t, snippet, "Host login.example.com\n\tUser alice\n")
+	assert.Contains(t, snippet, "\tIdentityFile ~/.ssh/id_ed25519\n")
+	assert.Contains(t, snippet, "\tCertificateFile ~/.ssh/oinit/login.example.com-cert.pub\n")
+}
@@ -1,22 +1,98 @@
 package api
 
 import (
+	"strings"
 	"time"
 
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/pkg/libmotleycue"
+
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slices"
 )
 
 const (
 	PRINCIPAL     = "oinit"
 	FORCE_COMMAND = "oinit-switch"
+
+	// Maximum number of accounts a certificate permits logins as
+	MAX_PRINCIPALS = 16
 )
 
+// applyProfile overrides the options of the host and the request with those
+// set in the profile.
+func applyProfile(profile config.Profile, info config.HostInfo, body FormHostCertificate) (config.HostInfo, FormHostCertificate) {
+	if profile.CertValidity != "" {
+		info.CertDuration = profile.CertDuration
+	}
+
+	if profile.Extensions != "" {
+		info.Extensions = profile.AllowedExtensions
+	}
+
+	if profile.Command != "" {
+		body.Command = profile.Command
+	}
+
+	if profile.Principals != "" {
+		info.Principals = profile.Principals
+	}
+
+	return info, body
+}
+
+// certificateUsernames returns the accounts that a certificate permits logins
+// as, the account motley_cue deployed the user as first. With the
+// PRINCIPALS_ALL policy, all further accounts motley_cue maps the user to are
+// added, except names that can't be used as principals, up to MAX_PRINCIPALS.
+func certificateUsernames(policy string, credentials libmotleycue.Credentials) []string {
+	usernames := []string{credentials.SSHUser}
+
+	if policy != config.PRINCIPALS_ALL {
+		return usernames
+	}
+
+	for _, username := range credentials.SSHUsers {
+		if len(usernames) == MAX_PRINCIPALS {
+			break
+		}
+
+		if username == "" || username == PRINCIPAL || strings.ContainsAny(username, ", \t\r\n") ||
+			slices.Contains(usernames, username) {
+			continue
+		}
+
+		usernames = append(usernames, username)
+	}
+
+	return usernames
+}
+
+// hostPrincipals returns the principals suffixed with "@<host>". Hosts
+// accept them using scripts/oinit-principals.sh as AuthorizedPrincipalsCommand,
+// so that a certificate can't be used to log in to other hosts.
+func hostPrincipals(principals []string, host string) []string {
+	restricted := make([]string, 0, len(principals))
+	for _, principal := range principals {
+		restricted = append(restricted, principal+"@"+host)
+	}
+
+	return restricted
+}
+
 // generateUserCertificate generates a new OpenSSH certificate based on the
-// given public key.
-func generateUserCertificate(host string, pubkey ssh.PublicKey, username string, duration uint64) ssh.Certificate {
+// given public key, containing the given extensions. The certificate permits
+// logins as all given usernames, the first of which the oinit user switches
+// to. It is valid from skew seconds in the past.
+func generateUserCertificate(host string, pubkey ssh.PublicKey, usernames []string, duration, skew uint64, extensions []string) ssh.Certificate {
 	validAfter := uint64(time.Now().Unix())
 	validBefore := validAfter + duration
 
+	permitted := make(map[string]string)
+	for _, ext := range extensions {
+		permitted[ext] = ""
+	}
+
 	return ssh.Certificate{
 		Key: pubkey,
 		// From OpenSSH PROTOCOL.certkeys:
@@ -34,22 +110,37 @@ func generateUserCertificate(host string, pubkey ssh.PublicKey, username string,
 		// Set KeyId to "user@host" which can be used by the client to check
 		// which host this certificate was issued for.
 		KeyId:           PRINCIPAL + "@" + host,
-		ValidPrincipals: []string{PRINCIPAL, username},
+		ValidPrincipals: append([]string{PRINCIPAL}, usernames...),
 		// From OpenSSH PROTOCOL.certkeys:
 		//   "valid after" and "valid before" specify a validity period for the
 		//   certificate. Each represents a time in seconds since 1970-01-01
 		//   00:00:00. A certificate is considered valid if:
 		//     valid after <= current time < valid before
-		ValidAfter:  validAfter - 10, // account for slight clock differences
+		ValidAfter:  validAfter - skew, // account for slight clock differences
 		ValidBefore: validBefore,
 		Permissions: ssh.Permissions{
 			CriticalOptions: map[string]string{
-				"force-command": FORCE_COMMAND + " " + username,
-			},
-			Extensions: map[string]string{
-				"permit-agent-forwarding": "",
-				"permit-pty":              "",
+				"force-command": FORCE_COMMAND + " " + strings.Join(usernames, ","),
 			},
+			Extensions: permitted,
 		},
 	}
 }
+
+// allowedExtensions returns the requested extensions that are allowed by the
+// policy. If the client did not request specific extensions, all extensions
+// of the policy are returned.
+func allowedExtensions(policy, requested []string) []string {
+	if requested == nil {
+		return policy
+	}
+
+	var allowed []string
+	for _, ext := range requested {
+		if slices.Contains(policy, ext) && !slices.Contains(allowed, ext) {
+			allowed = append(allowed, ext)
+		}
+	}
+
+	return allowed
+}
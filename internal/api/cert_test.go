@@ -2,9 +2,14 @@ package api
 
 import (
 	"crypto/ed25519"
+	"fmt"
 	"testing"
 	"time"
 
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/pkg/libmotleycue"
+
+	"github.com/stretchr/testify/assert"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -17,7 +22,7 @@ func TestGenerateUserCertificate(t *testing.T) {
 	username := "testuser"
 	duration := uint64(3600)
 
-	certificate := generateUserCertificate(host, pubkey, username, duration)
+	certificate := generateUserCertificate(host, pubkey, []string{username}, duration, 10, []string{"permit-agent-forwarding", "permit-pty"})
 
 	if certificate.Serial != 0 {
 		t.Error("Expected Serial to be 0")
@@ -54,6 +59,52 @@ func TestGenerateUserCertificate(t *testing.T) {
 	if _, ok := certificate.Permissions.Extensions["permit-pty"]; !ok {
 		t.Error("Expected permit-pty extension to be present")
 	}
+
+	if _, ok := certificate.Permissions.Extensions["permit-X11-forwarding"]; ok {
+		t.Error("Expected permit-X11-forwarding extension to be absent")
+	}
+}
+
+func TestAllowedExtensions(t *testing.T) {
+	policy := []string{"permit-agent-forwarding", "permit-pty"}
+
+	if got := allowedExtensions(policy, nil); !stringSlicesEqual(got, policy) {
+		t.Errorf("Expected policy %v if nothing is requested, but got %v", policy, got)
+	}
+
+	if got := allowedExtensions(policy, []string{}); len(got) != 0 {
+		t.Errorf("Expected no extensions, but got %v", got)
+	}
+
+	got := allowedExtensions(policy, []string{"permit-pty", "permit-X11-forwarding"})
+	if !stringSlicesEqual(got, []string{"permit-pty"}) {
+		t.Errorf("Expected [permit-pty], but got %v", got)
+	}
+}
+
+func TestCertificateUsernames(t *testing.T) {
+	credentials := libmotleycue.Credentials{
+		SSHUser:  "alice",
+		SSHUsers: []string{"project", "alice", PRINCIPAL, "a b", "shared,admin", "", "service"},
+	}
+
+	assert.Equal(t, []string{"alice"}, certificateUsernames(config.PRINCIPALS_USER, credentials))
+	assert.Equal(t, []string{"alice", "project", "service"}, certificateUsernames(config.PRINCIPALS_ALL, credentials))
+
+	credentials.SSHUsers = nil
+	for i := 0; i < 2*MAX_PRINCIPALS; i++ {
+		credentials.SSHUsers = append(credentials.SSHUsers, fmt.Sprintf("project%d", i))
+	}
+	assert.Len(t, certificateUsernames(config.PRINCIPALS_ALL, credentials), MAX_PRINCIPALS)
+
+	pk, _, _ := ed25519.GenerateKey(nil)
+	pubkey, _ := ssh.NewPublicKey(pk)
+
+	certificate := generateUserCertificate("example.com", pubkey, []string{"alice", "project"}, 3600, 10, nil)
+	assert.Equal(t, []string{PRINCIPAL, "alice", "project"}, certificate.ValidPrincipals)
+	assert.Equal(t, FORCE_COMMAND+" alice,project", certificate.CriticalOptions["force-command"])
+
+	assert.Equal(t, []string{"oinit@example.com", "alice@example.com", "project@example.com"}, hostPrincipals(certificate.ValidPrincipals, "example.com"))
 }
 
 func stringSlicesEqual(slice1, slice2 []string) bool {
@@ -67,3 +118,27 @@ func stringSlicesEqual(slice1, slice2 []string) bool {
 	}
 	return true
 }
+
+func TestApplyProfile(t *testing.T) {
+	info := config.HostInfo{CertDuration: 3600, Extensions: []string{"permit-pty"}}
+	body := FormHostCertificate{Extensions: []string{"permit-pty"}}
+
+	// Unset options of the profile keep those of the host
+	gotInfo, gotBody := applyProfile(config.Profile{Name: "interactive"}, info, body)
+	assert.Equal(t, info, gotInfo)
+	assert.Equal(t, body, gotBody)
+
+	gotInfo, gotBody = applyProfile(config.Profile{
+		Name:              "file-transfer-only",
+		CertValidity:      "600",
+		CertDuration:      600,
+		Extensions:        config.EXTENSIONS_NONE,
+		AllowedExtensions: []string{},
+		Command:           "internal-sftp",
+		Principals:        config.PRINCIPALS_ALL,
+	}, info, body)
+	assert.Equal(t, 600, gotInfo.CertDuration)
+	assert.Empty(t, gotInfo.Extensions)
+	assert.Equal(t, "internal-sftp", gotBody.Command)
+	assert.Equal(t, config.PRINCIPALS_ALL, gotInfo.Principals)
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	HEADER_REQUEST_ID = "X-Request-Id"
+
+	// Outcomes of decisions
+	OUTCOME_ISSUED  = "issued"
+	OUTCOME_DRY_RUN = "dry_run"
+	OUTCOME_DENIED  = "denied"
+	// Redirected to the site CA the host is delegated to
+	OUTCOME_DELEGATED = "delegated"
+
+	// Steps of decisions
+	STEP_VALIDATE   = "validate"
+	STEP_HOST       = "host"
+	STEP_FREEZE     = "freeze"
+	STEP_DELEGATE   = "delegate"
+	STEP_PROFILE    = "profile"
+	STEP_GEO        = "geo"
+	STEP_SUBJECT    = "subject"
+	STEP_MOTLEY_CUE = "motley_cue"
+	STEP_REPLAY     = "replay"
+	STEP_IDEMPOTENT = "idempotency"
+	STEP_QUOTA      = "quota"
+	STEP_VO_QUOTA   = "vo_quota"
+	STEP_SIGN       = "sign"
+)
+
+type UriRequestID struct {
+	RequestID string `uri:"request_id" binding:"required"`
+}
+
+// RequestID is a middleware that assigns a random ID to every request, which
+// is returned in the X-Request-Id header and in error responses. Users can
+// pass it to support staff, who look up the decision using GetAdminDecision.
+func RequestID(c *gin.Context) {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	c.Set("request_id", hex.EncodeToString(id))
+	c.Header(HEADER_REQUEST_ID, c.GetString("request_id"))
+	c.Next()
+}
+
+// decisionTrace collects the checks made while handling a certificate
+// request.
+type decisionTrace struct {
+	storage.Decision
+}
+
+func newDecisionTrace(c *gin.Context, host string) *decisionTrace {
+	return &decisionTrace{storage.Decision{
+		RequestID: c.GetString("request_id"),
+		Time:      time.Now(),
+		Host:      host,
+		Steps:     []storage.DecisionStep{},
+	}}
+}
+
+// token records the subject and claims of the access token. Its signature is
+// not included, so the token itself can't be recovered.
+func (d *decisionTrace) token(token *jwt.Token) {
+	d.Subject = tokenSubject(token)
+
+	if claims, ok := token.Claims.(jwt.MapClaims); ok {
+		d.Claims = claims
+	}
+}
+
+func (d *decisionTrace) step(name string, passed bool, detail string) {
+	d.Steps = append(d.Steps, storage.DecisionStep{Name: name, Passed: passed, Detail: detail})
+}
+
+// save records the decision after the response has been written. Requests
+// without ID or store (such as in tests) are not recorded.
+func (d *decisionTrace) save(c *gin.Context) {
+	store, ok := c.Value("store").(storage.Store)
+	if d.RequestID == "" || !ok {
+		return
+	}
+
+	d.Status = c.Writer.Status()
+	d.Code = c.GetString("error_code")
+
+	switch d.Status {
+	case http.StatusCreated:
+		d.Outcome = OUTCOME_ISSUED
+	case http.StatusOK:
+		d.Outcome = OUTCOME_DRY_RUN
+	case http.StatusTemporaryRedirect:
+		d.Outcome = OUTCOME_DELEGATED
+	default:
+		d.Outcome = OUTCOME_DENIED
+	}
+
+	store.AddDecision(d.Decision)
+}
+
+// GetAdminDecision is the handler for GET /admin/decisions/:request_id
+//
+//	@Summary		Get decision trace
+//	@ID				getAdminDecision
+//	@Description	Return the checks made for a certificate request, its outcome and the claims of the access token, given the request ID returned in the X-Request-Id header or error response.
+//	@Tags			admin
+//	@Produce		json
+//	@Security		AdminToken
+//	@Param			request_id	path		string	true	"Request ID"
+//	@Success		200			{object}	storage.Decision
+//	@Failure		400			{object}	ApiResponseError
+//	@Failure		401			{object}	ApiResponseError
+//	@Failure		403			{object}	ApiResponseError
+//	@Failure		404			{object}	ApiResponseError
+//	@Router			/admin/decisions/{request_id} [get]
+func GetAdminDecision(c *gin.Context) {
+	var uri UriRequestID
+
+	if c.ShouldBindUri(&uri) != nil {
+		Error(c, http.StatusBadRequest, ERR_BAD_BODY)
+		return
+	}
+
+	decision, err := c.MustGet("store").(storage.Store).GetDecision(uri.RequestID)
+	if err != nil {
+		Error(c, http.StatusNotFound, ERR_NOT_FOUND)
+		return
+	}
+
+	c.JSON(http.StatusOK, decision)
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/sshsig"
+	"github.com/lbrocke/oinit/internal/sshversion"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// Namespace of the signature over delegations, which can be verified
+	// with 'ssh-keygen -Y verify -n oinit-delegation'
+	DELEGATION_NAMESPACE = "oinit-delegation"
+
+	// Delegations are signed on request and valid for this duration
+	DELEGATION_VALIDITY = 24 * time.Hour
+
+	// Maximum size of responses of site CAs that are proxied
+	MAX_DELEGATE_RESPONSE = 1 << 20
+)
+
+// ApiResponseDelegation states that certificates for a host are issued by a
+// site CA, whose user CA key is certified by the user CA key of the hostgroup.
+type ApiResponseDelegation struct {
+	// URL of the site CA
+	URL string `json:"url" example:"https://ca.site.example.com"`
+	// User CA key of the site CA, trusted by the host
+	PublicKey   string    `json:"publickey"`
+	ValidBefore time.Time `json:"valid_before"`
+	// Signed statement "<host>\n<url>\n<publickey>\n<valid before>", with
+	// valid before as Unix timestamp
+	Statement string `json:"statement"`
+	// Armored SSH signature over statement by the user CA key of the
+	// hostgroup, in namespace oinit-delegation
+	Signature string `json:"signature"`
+}
+
+// delegationStatement returns the data that is signed by the central CA.
+func delegationStatement(host, delegate string, pubkey ssh.PublicKey, validBefore time.Time) string {
+	key := strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(pubkey)), "\n")
+
+	return host + "\n" + delegate + "\n" + key + "\n" + strconv.FormatInt(validBefore.Unix(), 10)
+}
+
+// delegation returns the signed delegation of the host to a site CA, or nil
+// if the host is not delegated.
+func delegation(conf config.Config, info config.HostInfo, host string, now time.Time) (*ApiResponseDelegation, error) {
+	if info.Delegate == "" {
+		return nil, nil
+	}
+
+	signer, err := certSigner(conf, info, sshversion.Version{}, false)
+	if err != nil {
+		return nil, err
+	}
+
+	validBefore := now.Add(DELEGATION_VALIDITY).Truncate(time.Second)
+	statement := delegationStatement(host, info.Delegate, info.DelegateCAPublicKey, validBefore)
+
+	sig, err := sshsig.Sign(signer, []byte(statement), DELEGATION_NAMESPACE)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ApiResponseDelegation{
+		URL:         info.Delegate,
+		PublicKey:   strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(info.DelegateCAPublicKey)), "\n"),
+		ValidBefore: validBefore.UTC(),
+		Statement:   statement,
+		Signature:   string(sig),
+	}, nil
+}
+
+// hostURL returns the URL of the endpoint of the host at another CA with base
+// URL base, such as "/certificate", including the query of the request.
+func hostURL(base, host, endpoint, rawQuery string) string {
+	target := base + "/api/v1/" + url.PathEscape(host) + endpoint
+	if rawQuery != "" {
+		target += "?" + rawQuery
+	}
+
+	return target
+}
+
+// proxyCertificate forwards the certificate request to the site CA of the
+// host and writes its response. The access token is sent in the Authorization
+// header. It returns the status of the site CA.
+func proxyCertificate(c *gin.Context, info config.HostInfo, host string, body FormHostCertificate) (int, error) {
+	token := body.Token
+	body.Token = ""
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodPost, hostURL(info.Delegate, host, "/certificate", c.Request.URL.RawQuery), bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	for _, header := range []string{"Accept-Language", HEADER_IDEMPOTENCY_KEY} {
+		if value := c.GetHeader(header); value != "" {
+			req.Header.Set(header, value)
+		}
+	}
+
+	// Site CAs are trusted to answer themselves, redirects are not followed
+	client := http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+
+	defer res.Body.Close()
+
+	response, err := io.ReadAll(io.LimitReader(res.Body, MAX_DELEGATE_RESPONSE))
+	if err != nil {
+		return 0, err
+	}
+
+	if value := res.Header.Get(HEADER_IDEMPOTENT_REPLAYED); value != "" {
+		c.Header(HEADER_IDEMPOTENT_REPLAYED, value)
+	}
+
+	c.Data(res.StatusCode, res.Header.Get("Content-Type"), response)
+
+	return res.StatusCode, nil
+}
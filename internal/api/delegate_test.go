@@ -0,0 +1,124 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/sshsig"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func delegateTestConfig(t *testing.T, delegate, mode string) config.Config {
+	_, central, _ := ed25519.GenerateKey(rand.Reader)
+	site, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	sitePubkey, err := ssh.NewPublicKey(site)
+	assert.NoError(t, err)
+
+	return config.Config{
+		HostGroups: []config.HostGroup{
+			{
+				DefaultOptions: config.DefaultOptions{Delegate: delegate, DelegateMode: mode},
+				Keys:           config.Keys{UserCAPrivateKey: central, DelegateCAPublicKey: sitePubkey},
+				Name:           "site.example.com",
+				Hosts:          map[string]string{"login.site.example.com": "https://login.site.example.com"},
+			},
+		},
+	}
+}
+
+func TestDelegation(t *testing.T) {
+	conf := delegateTestConfig(t, "https://ca.site.example.com", config.DELEGATE_PROXY)
+
+	info, err := conf.GetInfo("login.site.example.com")
+	assert.NoError(t, err)
+
+	now := time.Now()
+
+	res, err := delegation(conf, info, "login.site.example.com", now)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://ca.site.example.com", res.URL)
+	assert.True(t, res.ValidBefore.After(now))
+	assert.True(t, strings.HasPrefix(res.Statement, "login.site.example.com\nhttps://ca.site.example.com\n"+res.PublicKey+"\n"))
+
+	// The statement is signed by the user CA key of the hostgroup
+	signer, err := sshsig.Verify([]byte(res.Signature), []byte(res.Statement), DELEGATION_NAMESPACE)
+	assert.NoError(t, err)
+
+	central, err := ssh.NewSignerFromKey(info.UserCAPrivateKey)
+	assert.NoError(t, err)
+	assert.Equal(t, central.PublicKey().Marshal(), signer.Marshal())
+
+	// Hosts that are not delegated have no delegation
+	info.Delegate = ""
+	res, err = delegation(conf, info, "login.site.example.com", now)
+	assert.NoError(t, err)
+	assert.Nil(t, res)
+}
+
+func TestPostHostCertificateDelegate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/login.site.example.com/certificate", r.URL.Path)
+		assert.Equal(t, "true", r.URL.Query().Get("dry_run"))
+		assert.Equal(t, "Bearer "+TEST_TOKEN, r.Header.Get("Authorization"))
+		assert.Equal(t, "key", r.Header.Get(HEADER_IDEMPOTENCY_KEY))
+
+		var body FormHostCertificate
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Empty(t, body.Token)
+		assert.Equal(t, "interactive", body.Profile)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"keyid": "oinit@login.site.example.com"}`))
+	}))
+	defer site.Close()
+
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	pubkey, _ := ssh.NewPublicKey(pub)
+	body := `{"publickey": "` + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pubkey))) + `", "profile": "interactive"}`
+
+	request := func(conf config.Config) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Set("config", conf)
+			c.Set("store", storage.NewMemoryStore())
+		})
+		router.POST("/:host/certificate", PostHostCertificate)
+
+		req := httptest.NewRequest(http.MethodPost, "/login.site.example.com/certificate?dry_run=true", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+TEST_TOKEN)
+		req.Header.Set(HEADER_IDEMPOTENCY_KEY, "key")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		return w
+	}
+
+	w := request(delegateTestConfig(t, site.URL, config.DELEGATE_PROXY))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"keyid": "oinit@login.site.example.com"}`, w.Body.String())
+
+	w = request(delegateTestConfig(t, site.URL, config.DELEGATE_REDIRECT))
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	assert.Equal(t, site.URL+"/api/v1/login.site.example.com/certificate?dry_run=true", w.Header().Get("Location"))
+
+	// Unreachable site CAs are reported like unreachable motley_cue instances
+	site.Close()
+	w = request(delegateTestConfig(t, site.URL, config.DELEGATE_PROXY))
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/pkg/libmotleycue"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Attempts to deploy a user on a host, waiting EAGER_DEPLOY_BACKOFF before
+	// the first retry and doubling it for every further retry
+	EAGER_DEPLOY_ATTEMPTS = 3
+	EAGER_DEPLOY_BACKOFF  = 2 * time.Second
+	// Duration that the status of eager deployments is kept for
+	EAGER_DEPLOY_RETENTION = time.Hour
+
+	DEPLOY_PENDING  = "pending"
+	DEPLOY_DEPLOYED = "deployed"
+	DEPLOY_FAILED   = "failed"
+)
+
+type ApiResponseAdminDeployment struct {
+	Host      string    `json:"host"`
+	Subject   string    `json:"subject"`
+	State     string    `json:"state" enums:"pending,deployed,failed"`
+	Attempts  int       `json:"attempts"`
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// deployments tracks the state of eager deployments, keyed by subject and
+// host.
+type deployments struct {
+	mu      sync.Mutex
+	entries map[string]ApiResponseAdminDeployment
+}
+
+var eagerDeployments = &deployments{entries: make(map[string]ApiResponseAdminDeployment)}
+
+func (d *deployments) set(deployment ApiResponseAdminDeployment) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	deployment.UpdatedAt = time.Now()
+	d.entries[deployment.Subject+" "+deployment.Host] = deployment
+
+	for key, entry := range d.entries {
+		if time.Since(entry.UpdatedAt) > EAGER_DEPLOY_RETENTION {
+			delete(d.entries, key)
+		}
+	}
+}
+
+// list returns all deployments, most recently updated first.
+func (d *deployments) list() []ApiResponseAdminDeployment {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	list := []ApiResponseAdminDeployment{}
+	for _, entry := range d.entries {
+		list = append(list, entry)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].UpdatedAt.After(list[j].UpdatedAt)
+	})
+
+	return list
+}
+
+// eagerDeployHosts returns the hosts of the hostgroup other than the given
+// one whose motley_cue instances the user should be deployed on, at most one
+// host per instance. Wildcard hosts are skipped.
+func eagerDeployHosts(conf config.Config, info config.HostInfo, host string) map[string][]string {
+	hosts := make(map[string][]string)
+
+	seen := map[string]bool{info.URL: true}
+
+	for _, group := range conf.HostGroups {
+		if group.Name != info.HostGroup {
+			continue
+		}
+
+		names := make([]string, 0, len(group.Hosts))
+		for name := range group.Hosts {
+			names = append(names, strings.ToLower(name))
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			urls := config.SplitURLs(group.Hosts[name])
+			if name == host || strings.HasPrefix(name, "*.") || seen[urls[0]] {
+				continue
+			}
+
+			seen[urls[0]] = true
+			hosts[name] = urls
+		}
+	}
+
+	return hosts
+}
+
+// eagerDeploy deploys the user on all other hosts of the hostgroup in the
+// background, so that the first login there doesn't wait for the deployment.
+// Unavailable instances are retried.
+func eagerDeploy(conf config.Config, info config.HostInfo, host, subject, accessToken string) {
+	timeout := time.Duration(conf.Server.RequestTimeout) * time.Second
+
+	for name, urls := range eagerDeployHosts(conf, info, host) {
+		go func(name string, urls []string) {
+			deployment := ApiResponseAdminDeployment{Host: name, Subject: subject, State: DEPLOY_PENDING}
+			eagerDeployments.set(deployment)
+
+			backoff := EAGER_DEPLOY_BACKOFF
+
+			for deployment.Attempts < EAGER_DEPLOY_ATTEMPTS {
+				if deployment.Attempts > 0 {
+					time.Sleep(backoff)
+					backoff *= 2
+				}
+
+				deployment.Attempts++
+
+				ctx, cancel := context.WithTimeout(context.Background(), timeout)
+				status, _, err := withUpstream(ctx, config.HostInfo{URLs: urls}, func(ctx context.Context, client libmotleycue.Client) (libmotleycue.ApiResponseUserStatus, error) {
+					return client.GetUserDeployContext(ctx, accessToken)
+				})
+				cancel()
+
+				switch {
+				case err == nil && status.State == libmotleycue.StateDeployed:
+					deployment.State = DEPLOY_DEPLOYED
+					deployment.Error = ""
+				case err == nil:
+					deployment.State = DEPLOY_FAILED
+					deployment.Error = "state: " + string(status.State)
+				default:
+					deployment.State = DEPLOY_FAILED
+					deployment.Error = err.Error()
+				}
+
+				eagerDeployments.set(deployment)
+
+				// Only unavailable instances may succeed on retry
+				if err == nil || !libmotleycue.Unavailable(err) {
+					break
+				}
+			}
+
+			if deployment.State == DEPLOY_FAILED {
+				Logger.Printf("Could not deploy %s on %s: %s", subject, name, deployment.Error)
+			}
+		}(name, urls)
+	}
+}
+
+// GetAdminDeployments is the handler for GET /admin/deployments
+//
+//	@Summary		List eager deployments
+//	@ID				getAdminDeployments
+//	@Description	Return the state of deployments on other hosts of a hostgroup with eager-deploy, which are started
+//	@Description	after issuing a certificate. Deployments are kept for one hour, most recently updated first.
+//	@Tags			admin
+//	@Produce		json
+//	@Security		AdminToken
+//	@Success		200	{array}		ApiResponseAdminDeployment
+//	@Failure		401	{object}	ApiResponseError
+//	@Failure		403	{object}	ApiResponseError
+//	@Router			/admin/deployments [get]
+func GetAdminDeployments(c *gin.Context) {
+	c.JSON(http.StatusOK, eagerDeployments.list())
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/mockmotleycue"
+	"github.com/lbrocke/oinit/pkg/libmotleycue"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEagerDeploy(t *testing.T) {
+	mock := mockmotleycue.New()
+	mock.AddUser(TEST_TOKEN, mockmotleycue.User{SSHUser: "alice", State: libmotleycue.StateDeployed})
+
+	srv := httptest.NewServer(mock)
+	defer srv.Close()
+
+	conf := config.Config{
+		Server: config.ServerOptions{RequestTimeout: 5},
+		HostGroups: []config.HostGroup{{
+			Name: "example.com",
+			Hosts: map[string]string{
+				"login.example.com":  "https://login.example.com",
+				"shared.example.com": "https://login.example.com",
+				"*.example.com":      "https://login.example.com",
+				"node.example.com":   srv.URL,
+			},
+		}},
+	}
+
+	info, err := conf.GetInfo("login.example.com")
+	assert.NoError(t, err)
+
+	// Hosts sharing the instance of the requested host are skipped
+	assert.Equal(t, map[string][]string{"node.example.com": {srv.URL}}, eagerDeployHosts(conf, info, "login.example.com"))
+
+	eagerDeploy(conf, info, "login.example.com", "alice@https://issuer", TEST_TOKEN)
+
+	assert.Eventually(t, func() bool {
+		for _, deployment := range eagerDeployments.list() {
+			if deployment.Host == "node.example.com" && deployment.State == DEPLOY_DEPLOYED {
+				return true
+			}
+		}
+
+		return false
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/dnsutil"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// TTL of DNS records if none is given
+	DEFAULT_DNS_TTL = 3600
+)
+
+type QueryAdminDNS struct {
+	TTL int `form:"ttl"`
+}
+
+// DNSRecords returns SSHFP records of the host keys and CERT records of the
+// host certificates reported by all configured hosts, see PostHostKeys.
+// Hosts that have not reported their keys are skipped.
+func DNSRecords(conf config.Config, store storage.Store, ttl int) ([]string, error) {
+	var hosts []string
+	for _, group := range conf.HostGroups {
+		for host := range group.Hosts {
+			if !strings.HasPrefix(host, "*.") {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	sort.Strings(hosts)
+
+	records := []string{}
+	for _, host := range hosts {
+		hostKeys, err := store.GetHostKeys(host)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		for _, line := range hostKeys.Keys {
+			key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+			if err != nil {
+				return nil, err
+			}
+
+			sshfp, err := dnsutil.SSHFP(host, ttl, key)
+			if err != nil {
+				// Key types without SSHFP algorithm number
+				continue
+			}
+
+			records = append(records, sshfp...)
+		}
+
+		pk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hostKeys.Certificate))
+		if err != nil {
+			return nil, err
+		}
+
+		if cert, ok := pk.(*ssh.Certificate); ok {
+			records = append(records, dnsutil.CERT(host, ttl, cert))
+		}
+	}
+
+	return records, nil
+}
+
+// GetAdminDNS is the handler for GET /admin/dns
+//
+//	@Summary		Get DNS records
+//	@ID				getAdminDns
+//	@Description	Return SSHFP records of the host keys and CERT records of the host certificates reported by all hosts, in zone file format. These can be published in a DNSSEC-signed zone, so clients can verify hosts using VerifyHostKeyDNS.
+//	@Tags			admin
+//	@Produce		plain
+//	@Security		AdminToken
+//	@Param			ttl	query		int	false	"TTL of the records"
+//	@Success		200	{string}	string
+//	@Failure		400	{object}	ApiResponseError
+//	@Failure		401	{object}	ApiResponseError
+//	@Failure		403	{object}	ApiResponseError
+//	@Failure		500	{object}	ApiResponseError
+//	@Router			/admin/dns [get]
+func GetAdminDNS(c *gin.Context) {
+	var query QueryAdminDNS
+
+	if c.ShouldBindQuery(&query) != nil || query.TTL < 0 {
+		Error(c, http.StatusBadRequest, ERR_BAD_BODY)
+		return
+	}
+
+	if query.TTL == 0 {
+		query.TTL = DEFAULT_DNS_TTL
+	}
+
+	records, err := DNSRecords(c.MustGet("config").(config.Config), c.MustGet("store").(storage.Store), query.TTL)
+	if err != nil {
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	var zone strings.Builder
+	for _, record := range records {
+		zone.WriteString(record + "\n")
+	}
+
+	c.String(http.StatusOK, zone.String())
+}
@@ -0,0 +1,227 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/i18n"
+	"github.com/lbrocke/oinit/internal/notify"
+	"github.com/lbrocke/oinit/internal/sshsig"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// Namespace of SSH signatures of emergency requests, as passed to
+	// ssh-keygen -Y sign -n
+	EMERGENCY_NAMESPACE = "oinit-emergency"
+
+	// Extension marking emergency certificates, its value is the name of
+	// the responder. Extensions unknown to sshd are ignored.
+	EXTENSION_EMERGENCY = "emergency@oinit"
+
+	// Prefix of the keys that signatures of emergency requests are
+	// recorded with, see Store.MarkSeen
+	EMERGENCY_SEEN_SIGNATURE = "emergency-signature:"
+
+	AUDIT_EMERGENCY = "emergency"
+
+	ERR_EMERGENCY_DISABLED     = "emergency_disabled"
+	ERR_EMERGENCY_UNAUTHORIZED = "emergency_unauthorized"
+	ERR_EMERGENCY_DENIED       = "emergency_denied"
+
+	MSG_EMERGENCY_SUBJECT = "emergency_subject"
+	MSG_EMERGENCY_BODY    = "emergency_body"
+)
+
+// EmergencyRequest is a request for an emergency certificate, which is signed
+// by the hardware-backed key of an incident responder, see emergency-keys.
+type EmergencyRequest struct {
+	Host string `json:"host"`
+	// Public key to certify in authorized_keys format
+	PublicKey  string   `json:"publickey"`
+	Principals []string `json:"principals"`
+	// Why the certificate is needed, e.g. an incident number
+	Reason string `json:"reason"`
+	// Unix time of signing, the CA rejects requests that are too old
+	IssuedAt int64 `json:"iat"`
+}
+
+// FormHostEmergency is an emergency request along with its signature.
+type FormHostEmergency struct {
+	// JSON of an EmergencyRequest, exactly as it was signed
+	Request string `form:"request" json:"request" binding:"required"`
+	// Armored SSH signature of the request, as created by
+	// "ssh-keygen -Y sign -n oinit-emergency"
+	Signature string `form:"signature" json:"signature" binding:"required"`
+}
+
+// emergencyResponder returns the responder whose emergency key made the
+// signature of the request.
+func emergencyResponder(keys []config.EmergencyKey, request, signature string) (config.EmergencyKey, bool) {
+	pubkey, err := sshsig.Verify([]byte(signature), []byte(request), EMERGENCY_NAMESPACE)
+	if err != nil {
+		return config.EmergencyKey{}, false
+	}
+
+	for _, key := range keys {
+		if bytes.Equal(key.PublicKey.Marshal(), pubkey.Marshal()) {
+			return key, true
+		}
+	}
+
+	return config.EmergencyKey{}, false
+}
+
+// alertEmergency notifies the security contacts of an emergency certificate
+// via all notifiers. Alerts are sent before the certificate is returned, and
+// failures are logged.
+func alertEmergency(conf config.Config, responder, host, reason string, cert ssh.Certificate) {
+	msg := notify.Message{
+		Subject: i18n.Translate(i18n.DEFAULT_LANGUAGE, MSG_EMERGENCY_SUBJECT, host),
+		Body: i18n.Translate(i18n.DEFAULT_LANGUAGE, MSG_EMERGENCY_BODY, host, strings.Join(cert.ValidPrincipals, ","),
+			responder, reason, cert.Serial, time.Unix(int64(cert.ValidBefore), 0).UTC().Format(time.RFC1123)),
+	}
+
+	for _, contact := range conf.Server.EmergencyContactList() {
+		for _, notifier := range conf.Notifiers {
+			if err := notifier.Notify(contact, msg); err != nil {
+				Logger.Printf("Could not alert %s of emergency certificate %d: %s", contact, cert.Serial, err)
+			}
+		}
+	}
+}
+
+// PostHostEmergency is the handler for POST /:host/emergency
+//
+//	@Summary		Request an emergency certificate
+//	@ID				postHostEmergency
+//	@Description	Request a certificate without OIDC or motley_cue, for incident responders who must reach hosts
+//	@Description	during outages of the identity provider. The request must be signed by one of the hardware-backed
+//	@Description	keys of emergency-keys within the last 5 minutes using "ssh-keygen -Y sign -n oinit-emergency",
+//	@Description	e.g. by scripts/oinit-emergency.sh, and each request is accepted once. Certificates are valid for
+//	@Description	emergency-validity seconds, marked with the extension emergency@oinit, and the security contacts
+//	@Description	are alerted.
+//	@Accept			json,mpfd
+//	@Produce		json
+//	@Param			host	path		string				true	"Host"	example("example.com")
+//	@Param			body	body		FormHostEmergency	true	"Signed emergency request"
+//	@Success		200		{object}	ApiResponseCertificate
+//	@Failure		400		{object}	ApiResponseError
+//	@Failure		401		{object}	ApiResponseError
+//	@Failure		403		{object}	ApiResponseError
+//	@Failure		404		{object}	ApiResponseError
+//	@Failure		500		{object}	ApiResponseError
+//	@Router			/{host}/emergency [post]
+func PostHostEmergency(c *gin.Context) {
+	var host UriHost
+	var body FormHostEmergency
+
+	if c.ShouldBindUri(&host) != nil || c.ShouldBind(&body) != nil {
+		Error(c, http.StatusBadRequest, ERR_BAD_BODY)
+		return
+	}
+
+	host.Host = strings.ToLower(host.Host)
+
+	conf, ok := c.MustGet("config").(config.Config)
+	if !ok {
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	store, ok := c.MustGet("store").(storage.Store)
+	if !ok {
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	if len(conf.EmergencyKeys) == 0 {
+		Error(c, http.StatusForbidden, ERR_EMERGENCY_DISABLED)
+		return
+	}
+
+	responder, ok := emergencyResponder(conf.EmergencyKeys, body.Request, body.Signature)
+	if !ok {
+		Error(c, http.StatusUnauthorized, ERR_EMERGENCY_UNAUTHORIZED)
+		return
+	}
+
+	var req EmergencyRequest
+	if err := json.Unmarshal([]byte(body.Request), &req); err != nil || req.Reason == "" {
+		Error(c, http.StatusBadRequest, ERR_BAD_BODY)
+		return
+	}
+
+	pubkey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(req.PublicKey))
+	if err != nil {
+		Error(c, http.StatusBadRequest, ERR_BAD_BODY)
+		return
+	}
+
+	// Requests are only valid for the signed host and shortly after signing
+	if age := time.Now().Unix() - req.IssuedAt; age > SIGNED_REQUEST_MAX_AGE || age < -SIGNED_REQUEST_MAX_AGE ||
+		!strings.EqualFold(req.Host, host.Host) {
+		Error(c, http.StatusUnauthorized, ERR_EMERGENCY_UNAUTHORIZED)
+		return
+	}
+
+	info, err := conf.GetInfo(host.Host)
+	if err != nil {
+		Error(c, http.StatusNotFound, ERR_UNKNOWN_HOST)
+		return
+	}
+
+	// Each request may only be used once, so intercepted requests can't be
+	// replayed
+	hash := sha256.Sum256([]byte(body.Request))
+	if previous, err := store.MarkSeen(EMERGENCY_SEEN_SIGNATURE+hex.EncodeToString(hash[:]), responder.Name,
+		2*SIGNED_REQUEST_MAX_AGE*time.Second); err != nil {
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	} else if previous != "" {
+		Error(c, http.StatusUnauthorized, ERR_EMERGENCY_UNAUTHORIZED)
+		return
+	}
+
+	validity := conf.Server.EmergencyValidity
+	if info.CertDuration > 0 && info.CertDuration < validity {
+		validity = info.CertDuration
+	}
+
+	cert, err := signOffline(conf, store, OfflineRequest{
+		Host:       host.Host,
+		PublicKey:  pubkey,
+		Principals: req.Principals,
+		Validity:   validity,
+		Actor:      AUDIT_EMERGENCY + ":" + responder.Name,
+		Reason:     req.Reason,
+	}, AUDIT_EMERGENCY, map[string]string{EXTENSION_EMERGENCY: responder.Name})
+	if err != nil {
+		Logger.Printf("Denied emergency certificate for %s to %s: %s", host.Host, responder.Name, err)
+
+		if errors.Is(err, ErrPolicyViolation) {
+			Error(c, http.StatusForbidden, ERR_EMERGENCY_DENIED)
+		} else {
+			Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		}
+		return
+	}
+
+	Logger.Printf("Issued emergency certificate %d for %s to %s: %s", cert.Serial, host.Host, responder.Name, req.Reason)
+
+	alertEmergency(conf, responder.Name, host.Host, req.Reason, cert)
+
+	c.JSON(http.StatusOK, ApiResponseCertificate{
+		Certificate: strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(&cert)), "\n"),
+	})
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/notify"
+	"github.com/lbrocke/oinit/internal/sshsig"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestPostHostEmergency(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	_, caKey, _ := ed25519.GenerateKey(rand.Reader)
+	responder := newTestSigner()
+	notifier := make(testNotifier, 4)
+
+	conf := config.Config{
+		Server: config.ServerOptions{
+			EmergencyValidity: 300,
+			EmergencyContacts: "security@example.com",
+		},
+		EmergencyKeys: []config.EmergencyKey{{Name: "alice", PublicKey: responder.PublicKey()}},
+		Notifiers:     []notify.Notifier{notifier},
+		HostGroups: []config.HostGroup{{
+			Keys:         config.Keys{UserCAPrivateKey: caKey},
+			CertDuration: 3600,
+			Name:         "example.com",
+			Hosts:        map[string]string{"example.com": "https://login.example.com"},
+		}},
+	}
+
+	store := storage.NewMemoryStore()
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("config", conf)
+		c.Set("store", store)
+	})
+	router.POST("/:host/emergency", PostHostEmergency)
+
+	request := func(signer ssh.Signer, req EmergencyRequest) (int, string) {
+		payload, _ := json.Marshal(req)
+		sig, _ := sshsig.Sign(signer, payload, EMERGENCY_NAMESPACE)
+		body, _ := json.Marshal(FormHostEmergency{Request: string(payload), Signature: string(sig)})
+
+		r := httptest.NewRequest(http.MethodPost, "/example.com/emergency", strings.NewReader(string(body)))
+		r.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+
+		var res ApiResponseCertificate
+		json.Unmarshal(w.Body.Bytes(), &res)
+
+		return w.Code, res.Certificate
+	}
+
+	req := EmergencyRequest{
+		Host:       "example.com",
+		PublicKey:  string(ssh.MarshalAuthorizedKey(newTestSigner().PublicKey())),
+		Principals: []string{"root"},
+		Reason:     "INC-1234",
+		IssuedAt:   time.Now().Unix(),
+	}
+
+	code, certificate := request(responder, req)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "security@example.com: Emergency SSH certificate issued for example.com", <-notifier)
+
+	pk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(certificate))
+	assert.NoError(t, err)
+	cert := pk.(*ssh.Certificate)
+	assert.Equal(t, "alice", cert.Extensions[EXTENSION_EMERGENCY])
+	assert.Equal(t, uint64(300), cert.ValidBefore-cert.ValidAfter-uint64(conf.Server.ClockSkewTolerance))
+
+	stored, err := store.GetCertificate(cert.Serial)
+	assert.NoError(t, err)
+	assert.Equal(t, AUDIT_EMERGENCY+":alice", stored.Subject)
+
+	// Requests can't be replayed
+	code, _ = request(responder, req)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// Only emergency keys are accepted
+	req.IssuedAt++
+	code, _ = request(newTestSigner(), req)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	expired := req
+	expired.IssuedAt -= 2 * SIGNED_REQUEST_MAX_AGE
+	code, _ = request(responder, expired)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	other := req
+	other.Host = "other.example.com"
+	code, _ = request(responder, other)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	denied := req
+	denied.Principals = []string{PRINCIPAL}
+	code, _ = request(responder, denied)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	assert.Empty(t, notifier)
+}
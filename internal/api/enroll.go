@@ -0,0 +1,259 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lbrocke/oinit/internal/service"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Seconds that hosts should wait before polling a pending enrollment
+	ENROLL_RETRY_AFTER = 60
+
+	ERR_ENROLLMENT_DISABLED  = service.ERR_ENROLLMENT_DISABLED
+	ERR_ENROLLMENT_REJECTED  = service.ERR_ENROLLMENT_REJECTED
+	ERR_ENROLLMENT_DECIDED   = service.ERR_ENROLLMENT_DECIDED
+	ERR_TOO_MANY_ENROLLMENTS = service.ERR_TOO_MANY_ENROLLMENTS
+	ERR_INVALID_HOST_KEY     = service.ERR_INVALID_HOST_KEY
+)
+
+// FormHostEnroll is a request of a host for a host certificate.
+type FormHostEnroll struct {
+	// Host public key in authorized_keys format, e.g. the content of
+	// /etc/ssh/ssh_host_ed25519_key.pub
+	PublicKey string `form:"publickey" json:"publickey" binding:"required"`
+}
+
+type ApiResponseEnrollment struct {
+	ID    string `json:"id" example:"9f86d081884c7d659a2feaa0c55ad015"`
+	State string `json:"state" example:"approved"`
+	// Host certificate, once the enrollment is approved
+	Certificate string `json:"certificate,omitempty"`
+}
+
+type UriEnrollment struct {
+	Host string `uri:"host" binding:"required"`
+	ID   string `uri:"id" binding:"required"`
+}
+
+type UriEnrollmentID struct {
+	ID string `uri:"id" binding:"required"`
+}
+
+type QueryAdminEnrollments struct {
+	State string `form:"state"`
+}
+
+// enrollmentResponse writes the state of the enrollment: 200 OK with the
+// host certificate if approved, 202 Accepted if pending and 403 Forbidden if
+// rejected.
+func enrollmentResponse(c *gin.Context, enrollment storage.Enrollment) {
+	res := ApiResponseEnrollment{
+		ID:          enrollment.ID,
+		State:       enrollment.State,
+		Certificate: enrollment.Certificate,
+	}
+
+	switch enrollment.State {
+	case storage.ENROLLMENT_APPROVED:
+		c.JSON(http.StatusOK, res)
+	case storage.ENROLLMENT_REJECTED:
+		Error(c, http.StatusForbidden, ERR_ENROLLMENT_REJECTED)
+	default:
+		c.Header("Location", basePath(c)+"/api/v1/"+enrollment.Host+"/enroll/"+enrollment.ID)
+		c.Header("Retry-After", strconv.Itoa(ENROLL_RETRY_AFTER))
+		c.JSON(http.StatusAccepted, res)
+	}
+}
+
+// PostHostEnroll is the handler for POST /:host/enroll
+//
+//	@Summary		Request a host certificate
+//	@ID				postHostEnroll
+//	@Description	Request a host certificate for a host key, if host-enrollment is enabled for the hostgroup. The
+//	@Description	request is held pending until an admin approves it, unless it matches a rule of
+//	@Description	enroll-auto-approve, and the host polls the URL in the Location header for the certificate. At
+//	@Description	most 5 enrollments may be pending per host and 100 per hostgroup.
+//	@Accept			json,mpfd
+//	@Produce		json
+//	@Param			host	path		string			true	"Host"	example("example.com")
+//	@Param			body	body		FormHostEnroll	true	"Host public key"
+//	@Success		200		{object}	ApiResponseEnrollment
+//	@Success		202		{object}	ApiResponseEnrollment
+//	@Failure		400		{object}	ApiResponseError
+//	@Failure		403		{object}	ApiResponseError
+//	@Failure		404		{object}	ApiResponseError
+//	@Failure		429		{object}	ApiResponseError
+//	@Failure		500		{object}	ApiResponseError
+//	@Failure		504		{object}	ApiResponseError
+//	@Router			/{host}/enroll [post]
+func PostHostEnroll(c *gin.Context) {
+	var host UriHost
+	var body FormHostEnroll
+
+	if c.ShouldBindUri(&host) != nil || c.ShouldBind(&body) != nil {
+		Error(c, http.StatusBadRequest, ERR_BAD_BODY)
+		return
+	}
+
+	svc, ok := newService(c)
+	if !ok {
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	enrollment, err := svc.Enroll(c.Request.Context(), strings.ToLower(host.Host), body.PublicKey, c.ClientIP())
+	if err != nil {
+		serviceError(c, err)
+		return
+	}
+
+	enrollmentResponse(c, enrollment)
+}
+
+// GetHostEnrollment is the handler for GET /:host/enroll/:id
+//
+//	@Summary		Get host enrollment
+//	@ID				getHostEnrollment
+//	@Description	Return the state of a host enrollment, and the host certificate once it is approved.
+//	@Produce		json
+//	@Param			host	path		string	true	"Host"	example("example.com")
+//	@Param			id		path		string	true	"Enrollment ID"
+//	@Success		200		{object}	ApiResponseEnrollment
+//	@Success		202		{object}	ApiResponseEnrollment
+//	@Failure		400		{object}	ApiResponseError
+//	@Failure		403		{object}	ApiResponseError
+//	@Failure		404		{object}	ApiResponseError
+//	@Router			/{host}/enroll/{id} [get]
+func GetHostEnrollment(c *gin.Context) {
+	var uri UriEnrollment
+
+	if c.ShouldBindUri(&uri) != nil {
+		Error(c, http.StatusBadRequest, ERR_BAD_BODY)
+		return
+	}
+
+	svc, ok := newService(c)
+	if !ok {
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	enrollment, err := svc.Enrollment(strings.ToLower(uri.Host), uri.ID)
+	if err != nil {
+		serviceError(c, err)
+		return
+	}
+
+	enrollmentResponse(c, enrollment)
+}
+
+// GetAdminEnrollments is the handler for GET /admin/enrollments
+//
+//	@Summary		List host enrollments
+//	@ID				getAdminEnrollments
+//	@Description	Return host enrollments in the given state (pending, approved or rejected), or all of them.
+//	@Tags			admin
+//	@Produce		json
+//	@Security		AdminToken
+//	@Param			state	query		string	false	"State"	Enums(pending, approved, rejected)
+//	@Success		200		{array}		storage.Enrollment
+//	@Failure		400		{object}	ApiResponseError
+//	@Failure		401		{object}	ApiResponseError
+//	@Failure		403		{object}	ApiResponseError
+//	@Failure		500		{object}	ApiResponseError
+//	@Router			/admin/enrollments [get]
+func GetAdminEnrollments(c *gin.Context) {
+	var query QueryAdminEnrollments
+
+	if c.ShouldBindQuery(&query) != nil {
+		Error(c, http.StatusBadRequest, ERR_BAD_BODY)
+		return
+	}
+
+	svc, ok := newService(c)
+	if !ok {
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	enrollments, err := svc.Enrollments(query.State)
+	if err != nil {
+		serviceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollments)
+}
+
+// decideEnrollment is the common part of the handlers approving and
+// rejecting enrollments.
+func decideEnrollment(c *gin.Context, approve bool) {
+	var uri UriEnrollmentID
+
+	if c.ShouldBindUri(&uri) != nil {
+		Error(c, http.StatusBadRequest, ERR_BAD_BODY)
+		return
+	}
+
+	svc, ok := newService(c)
+	if !ok {
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	enrollment, err := svc.DecideEnrollment(c.Request.Context(), uri.ID, approve, c.GetString("admin"))
+	if err != nil {
+		serviceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// PostAdminEnrollmentApprove is the handler for POST /admin/enrollments/:id/approve
+//
+//	@Summary		Approve host enrollment
+//	@ID				approveEnrollment
+//	@Description	Approve a pending host enrollment, which issues the host certificate.
+//	@Tags			admin
+//	@Produce		json
+//	@Security		AdminToken
+//	@Param			id	path		string	true	"Enrollment ID"
+//	@Success		200	{object}	storage.Enrollment
+//	@Failure		400	{object}	ApiResponseError
+//	@Failure		401	{object}	ApiResponseError
+//	@Failure		403	{object}	ApiResponseError
+//	@Failure		404	{object}	ApiResponseError
+//	@Failure		409	{object}	ApiResponseError
+//	@Failure		500	{object}	ApiResponseError
+//	@Router			/admin/enrollments/{id}/approve [post]
+func PostAdminEnrollmentApprove(c *gin.Context) {
+	decideEnrollment(c, true)
+}
+
+// PostAdminEnrollmentReject is the handler for POST /admin/enrollments/:id/reject
+//
+//	@Summary		Reject host enrollment
+//	@ID				rejectEnrollment
+//	@Description	Reject a pending host enrollment.
+//	@Tags			admin
+//	@Produce		json
+//	@Security		AdminToken
+//	@Param			id	path		string	true	"Enrollment ID"
+//	@Success		200	{object}	storage.Enrollment
+//	@Failure		400	{object}	ApiResponseError
+//	@Failure		401	{object}	ApiResponseError
+//	@Failure		403	{object}	ApiResponseError
+//	@Failure		404	{object}	ApiResponseError
+//	@Failure		409	{object}	ApiResponseError
+//	@Failure		500	{object}	ApiResponseError
+//	@Router			/admin/enrollments/{id}/reject [post]
+func PostAdminEnrollmentReject(c *gin.Context) {
+	decideEnrollment(c, false)
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/service"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestHostEnrollment(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	_, caKey, _ := ed25519.GenerateKey(rand.Reader)
+	_, network, _ := net.ParseCIDR("198.51.100.0/24")
+
+	conf := config.Config{
+		Server: config.ServerOptions{RequestTimeout: 5},
+		HostGroups: []config.HostGroup{{
+			DefaultOptions: config.DefaultOptions{HostEnrollment: true, HostCertValidity: 3600},
+			Keys:           config.Keys{HostCAPrivateKey: caKey},
+			AutoApprove:    config.EnrollmentRules{Networks: []*net.IPNet{network}},
+			Name:           "example.com",
+			Hosts:          map[string]string{"enroll.example.com": "https://login.example.com"},
+		}, {
+			Name:  "other.example.com",
+			Hosts: map[string]string{"other.example.com": "https://login.example.com"},
+		}},
+	}
+
+	store := storage.NewMemoryStore()
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("config", conf)
+		c.Set("store", store)
+		c.Set("admin", "admin")
+	})
+	router.POST("/:host/enroll", PostHostEnroll)
+	router.GET("/:host/enroll/:id", GetHostEnrollment)
+	router.POST("/admin/enrollments/:id/approve", PostAdminEnrollmentApprove)
+	router.POST("/admin/enrollments/:id/reject", PostAdminEnrollmentReject)
+
+	request := func(method, path, remote string, body any) (int, ApiResponseEnrollment) {
+		data, _ := json.Marshal(body)
+
+		req := httptest.NewRequest(method, path, strings.NewReader(string(data)))
+		req.Header.Set("Content-Type", "application/json")
+		if remote != "" {
+			req.RemoteAddr = remote + ":40000"
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var res ApiResponseEnrollment
+		json.Unmarshal(w.Body.Bytes(), &res)
+
+		return w.Code, res
+	}
+
+	hostKey := func() string {
+		pub, _, _ := ed25519.GenerateKey(rand.Reader)
+		pk, _ := ssh.NewPublicKey(pub)
+
+		return string(ssh.MarshalAuthorizedKey(pk))
+	}
+
+	code, _ := request(http.MethodPost, "/other.example.com/enroll", "", FormHostEnroll{PublicKey: hostKey()})
+	assert.Equal(t, http.StatusForbidden, code)
+
+	code, _ = request(http.MethodPost, "/enroll.example.com/enroll", "", FormHostEnroll{PublicKey: "ssh-ed25519 garbage"})
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// Requests from outside the auto-approved network are held pending,
+	// repeated requests for the same key return the same enrollment
+	key := hostKey()
+
+	code, pending := request(http.MethodPost, "/enroll.example.com/enroll", "192.0.2.1", FormHostEnroll{PublicKey: key})
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, storage.ENROLLMENT_PENDING, pending.State)
+
+	code, res := request(http.MethodPost, "/enroll.example.com/enroll", "192.0.2.1", FormHostEnroll{PublicKey: key})
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, pending.ID, res.ID)
+
+	code, _ = request(http.MethodGet, "/other.example.com/enroll/"+pending.ID, "", nil)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, _ = request(http.MethodPost, "/admin/enrollments/"+pending.ID+"/approve", "", nil)
+	assert.Equal(t, http.StatusOK, code)
+
+	code, _ = request(http.MethodPost, "/admin/enrollments/"+pending.ID+"/reject", "", nil)
+	assert.Equal(t, http.StatusConflict, code)
+
+	code, res = request(http.MethodGet, "/enroll.example.com/enroll/"+pending.ID, "", nil)
+	assert.Equal(t, http.StatusOK, code)
+
+	pk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(res.Certificate))
+	assert.NoError(t, err)
+
+	cert := pk.(*ssh.Certificate)
+	assert.Equal(t, uint32(ssh.HostCert), cert.CertType)
+	assert.Equal(t, []string{"enroll.example.com"}, cert.ValidPrincipals)
+	assert.Equal(t, uint64(3600), cert.ValidBefore-cert.ValidAfter)
+
+	// Rejected enrollments are reported as such
+	code, pending = request(http.MethodPost, "/enroll.example.com/enroll", "192.0.2.1", FormHostEnroll{PublicKey: hostKey()})
+	assert.Equal(t, http.StatusAccepted, code)
+
+	code, _ = request(http.MethodPost, "/admin/enrollments/"+pending.ID+"/reject", "", nil)
+	assert.Equal(t, http.StatusOK, code)
+
+	code, _ = request(http.MethodGet, "/enroll.example.com/enroll/"+pending.ID, "", nil)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	// Requests from the auto-approved network are approved immediately
+	code, res = request(http.MethodPost, "/enroll.example.com/enroll", "198.51.100.7", FormHostEnroll{PublicKey: hostKey()})
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotEmpty(t, res.Certificate)
+
+	enrollment, err := store.GetEnrollment(res.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, service.ENROLL_AUTO_PREFIX+"198.51.100.0/24", enrollment.DecidedBy)
+
+	// The number of pending enrollments per host is limited
+	for i := 0; i < service.MAX_PENDING_ENROLLMENTS; i++ {
+		request(http.MethodPost, "/enroll.example.com/enroll", "192.0.2.1", FormHostEnroll{PublicKey: hostKey()})
+	}
+
+	code, _ = request(http.MethodPost, "/enroll.example.com/enroll", "192.0.2.1", FormHostEnroll{PublicKey: hostKey()})
+	assert.Equal(t, http.StatusTooManyRequests, code)
+}
@@ -0,0 +1,17 @@
+package api
+
+import (
+	"github.com/lbrocke/oinit/internal/fault"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Faults returns a middleware that attaches the injector to the context of
+// each request, so that requests to motley_cue and signing are delayed and
+// failed as configured. For staging deployments only.
+func Faults(injector *fault.Injector) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(fault.WithInjector(c.Request.Context(), injector))
+		c.Next()
+	}
+}
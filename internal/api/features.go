@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lbrocke/oinit/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ERR_FEATURE_DISABLED = "feature_disabled"
+)
+
+// featureUsed reports whether a request uses a feature, for features that
+// are only used by some requests of a route. Features not listed are used
+// by all requests of the routes they are required for.
+var featureUsed = map[string]func(c *gin.Context) bool{
+	config.FEATURE_DRY_RUN: func(c *gin.Context) bool {
+		dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+		return dryRun
+	},
+	config.FEATURE_BUNDLE: func(c *gin.Context) bool {
+		return c.Query("format") == FORMAT_BUNDLE
+	},
+}
+
+// RequireFeature returns a middleware that rejects requests using the
+// feature with 403 Forbidden if it is disabled for the hostgroup of the
+// host. Requests for unknown hosts are passed on to the handler.
+func RequireFeature(feature string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if used, ok := featureUsed[feature]; ok && !used(c) {
+			c.Next()
+			return
+		}
+
+		conf, ok := c.MustGet("config").(config.Config)
+		if !ok {
+			c.Next()
+			return
+		}
+
+		info, err := conf.GetInfo(strings.ToLower(c.Param("host")))
+		if err == nil && info.DisabledFeatures[feature] {
+			c.Abort()
+			Error(c, http.StatusForbidden, ERR_FEATURE_DISABLED)
+			return
+		}
+
+		c.Next()
+	}
+}
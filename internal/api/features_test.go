@@ -0,0 +1,54 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lbrocke/oinit/internal/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireFeature(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	conf := config.Config{
+		HostGroups: []config.HostGroup{
+			{
+				Name:             "staging",
+				Hosts:            map[string]string{"staging.example.com": "https://staging.example.com"},
+				DisabledFeatures: map[string]bool{config.FEATURE_KRL: false},
+			},
+			{
+				Name:             "production",
+				Hosts:            map[string]string{"*.example.com": "https://login.example.com"},
+				DisabledFeatures: map[string]bool{config.FEATURE_KRL: true, config.FEATURE_DRY_RUN: true},
+			},
+		},
+	}
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("config", conf) })
+	router.GET("/:host/krl", RequireFeature(config.FEATURE_KRL), func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.POST("/:host/certificate", RequireFeature(config.FEATURE_DRY_RUN), func(c *gin.Context) { c.Status(http.StatusCreated) })
+
+	request := func(method, path string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/staging.example.com/krl"))
+	assert.Equal(t, http.StatusForbidden, request(http.MethodGet, "/login.example.com/krl"))
+
+	// Only requests using the feature are rejected
+	assert.Equal(t, http.StatusCreated, request(http.MethodPost, "/login.example.com/certificate"))
+	assert.Equal(t, http.StatusForbidden, request(http.MethodPost, "/login.example.com/certificate?dry_run=true"))
+	assert.Equal(t, http.StatusCreated, request(http.MethodPost, "/staging.example.com/certificate?dry_run=true"))
+
+	// Unknown hosts are left to the handler
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/example.org/krl"))
+}
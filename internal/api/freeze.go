@@ -0,0 +1,303 @@
+package api
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/i18n"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ERR_ISSUANCE_FROZEN = "issuance_frozen"
+
+	AUDIT_FREEZE   = "freeze"
+	AUDIT_UNFREEZE = "unfreeze"
+
+	// Sources of freezes
+	FREEZE_SCHEDULE = "schedule"
+	FREEZE_ADMIN    = "admin"
+)
+
+// freezeState describes whether certificate issuance for a hostgroup is
+// paused.
+type freezeState struct {
+	Frozen bool
+	// FREEZE_SCHEDULE or FREEZE_ADMIN, empty if neither applies
+	Source string
+	// End of the freeze, zero if it lasts until it is removed
+	Until  time.Time
+	Reason string
+}
+
+// issuanceFreeze returns whether certificate issuance for the hostgroup is
+// paused at now. Freezes set by admins take precedence over freeze windows,
+// which they can also lift.
+func issuanceFreeze(store storage.Store, hostGroup string, windows config.FreezeWindows, message string, now time.Time) (freezeState, error) {
+	override, err := store.GetFreeze(hostGroup)
+	if err == nil {
+		state := freezeState{Frozen: override.Frozen, Source: FREEZE_ADMIN, Until: override.Until, Reason: override.Reason}
+		if state.Frozen && state.Reason == "" {
+			state.Reason = message
+		}
+
+		return state, nil
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return freezeState{}, err
+	}
+
+	if end, ok := windows.Active(now); ok {
+		return freezeState{Frozen: true, Source: FREEZE_SCHEDULE, Until: end, Reason: message}, nil
+	}
+
+	return freezeState{}, nil
+}
+
+// describe returns a description of the freeze for decision traces.
+func (f freezeState) describe() string {
+	detail := "frozen by " + f.Source
+
+	if !f.Until.IsZero() {
+		detail += " until " + f.Until.UTC().Format(time.RFC3339)
+	}
+
+	if f.Reason != "" {
+		detail += ": " + f.Reason
+	}
+
+	return detail
+}
+
+// frozenError responds with 503 Service Unavailable, along with the reason of
+// the freeze and a Retry-After header if it ends.
+func frozenError(c *gin.Context, freeze freezeState) {
+	if !freeze.Until.IsZero() {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(freeze.Until).Seconds()))))
+	}
+
+	msg := i18n.Translate(language(c), ERR_ISSUANCE_FROZEN)
+	if freeze.Reason != "" {
+		msg += " " + freeze.Reason
+	}
+
+	c.Set("error_code", ERR_ISSUANCE_FROZEN)
+	c.JSON(http.StatusServiceUnavailable, ApiResponseError{
+		Code:      ERR_ISSUANCE_FROZEN,
+		Error:     msg,
+		RequestID: c.GetString("request_id"),
+	})
+}
+
+type ApiResponseAdminFreeze struct {
+	HostGroup string `json:"hostgroup"`
+	// Whether certificate issuance is currently paused
+	Frozen bool `json:"frozen"`
+	// "schedule" or "admin", if a freeze window or a freeze set by an admin
+	// applies
+	Source string     `json:"source,omitempty" example:"schedule"`
+	Until  *time.Time `json:"until,omitempty"`
+	Reason string     `json:"reason,omitempty"`
+	// Configured freeze windows
+	Windows []string `json:"windows"`
+	// Freeze set by an admin, which overrides the freeze windows
+	Override *storage.Freeze `json:"override,omitempty"`
+}
+
+type UriHostGroup struct {
+	Name string `uri:"name" binding:"required"`
+}
+
+type FormAdminFreeze struct {
+	// Whether to pause issuance, false lifts the freeze windows
+	Frozen *bool `json:"frozen" binding:"required" example:"true"`
+	// Shown to users, instead of the freeze-message of the hostgroup
+	Reason string `json:"reason" example:"Security incident, issuance resumes after investigation."`
+	// Duration (in seconds) of the freeze, 0 = until it is removed
+	Duration int `json:"duration" binding:"min=0" example:"3600"`
+}
+
+// GetAdminFreezes is the handler for GET /admin/freezes
+//
+//	@Summary		List issuance freezes
+//	@ID				getAdminFreezes
+//	@Description	Return for every hostgroup whether certificate issuance is currently paused, either by a
+//	@Description	configured freeze window or by an admin.
+//	@Tags			admin
+//	@Produce		json
+//	@Security		AdminToken
+//	@Success		200	{array}		ApiResponseAdminFreeze
+//	@Failure		401	{object}	ApiResponseError
+//	@Failure		403	{object}	ApiResponseError
+//	@Failure		500	{object}	ApiResponseError
+//	@Router			/admin/freezes [get]
+func GetAdminFreezes(c *gin.Context) {
+	conf := c.MustGet("config").(config.Config)
+	store := c.MustGet("store").(storage.Store)
+	now := time.Now()
+
+	freezes := []ApiResponseAdminFreeze{}
+	for _, group := range conf.HostGroups {
+		state, err := issuanceFreeze(store, group.Name, group.FreezeWindows, group.FreezeMessage, now)
+		if err != nil {
+			Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+			return
+		}
+
+		freeze := ApiResponseAdminFreeze{
+			HostGroup: group.Name,
+			Frozen:    state.Frozen,
+			Source:    state.Source,
+			Reason:    state.Reason,
+			Windows:   []string{},
+		}
+
+		if !state.Until.IsZero() {
+			freeze.Until = &state.Until
+		}
+
+		for _, window := range group.FreezeWindows {
+			freeze.Windows = append(freeze.Windows, window.Spec)
+		}
+
+		if override, err := store.GetFreeze(group.Name); err == nil {
+			freeze.Override = &override
+		}
+
+		freezes = append(freezes, freeze)
+	}
+
+	c.JSON(http.StatusOK, freezes)
+}
+
+// hasHostGroup reports whether the hostgroup is configured.
+func hasHostGroup(conf config.Config, name string) bool {
+	for _, group := range conf.HostGroups {
+		if group.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PutAdminFreeze is the handler for PUT /admin/hostgroups/:name/freeze
+//
+//	@Summary		Freeze issuance
+//	@ID				putAdminFreeze
+//	@Description	Pause certificate issuance for a hostgroup, e.g. during a security incident, or lift its
+//	@Description	freeze windows with frozen set to false. Certificate requests are answered with 503 and the
+//	@Description	reason. This overrides the freeze windows until it expires or is removed.
+//	@Tags			admin
+//	@Accept			json
+//	@Produce		json
+//	@Security		AdminToken
+//	@Param			name	path		string			true	"Hostgroup name"
+//	@Param			body	body		FormAdminFreeze	true	"Freeze"
+//	@Success		200		{object}	storage.Freeze
+//	@Failure		400		{object}	ApiResponseError
+//	@Failure		401		{object}	ApiResponseError
+//	@Failure		403		{object}	ApiResponseError
+//	@Failure		404		{object}	ApiResponseError
+//	@Failure		500		{object}	ApiResponseError
+//	@Router			/admin/hostgroups/{name}/freeze [put]
+func PutAdminFreeze(c *gin.Context) {
+	var uri UriHostGroup
+	var body FormAdminFreeze
+
+	if c.ShouldBindUri(&uri) != nil || c.ShouldBindJSON(&body) != nil {
+		Error(c, http.StatusBadRequest, ERR_BAD_BODY)
+		return
+	}
+
+	if !hasHostGroup(c.MustGet("config").(config.Config), uri.Name) {
+		Error(c, http.StatusNotFound, ERR_NOT_FOUND)
+		return
+	}
+
+	store := c.MustGet("store").(storage.Store)
+
+	freeze := storage.Freeze{
+		HostGroup: uri.Name,
+		Frozen:    *body.Frozen,
+		Reason:    body.Reason,
+		SetBy:     c.GetString("admin"),
+		SetAt:     time.Now(),
+	}
+
+	if body.Duration != 0 {
+		freeze.Until = freeze.SetAt.Add(time.Duration(body.Duration) * time.Second)
+	}
+
+	if err := store.SetFreeze(freeze); err != nil {
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	details := map[string]string{
+		"hostgroup": freeze.HostGroup,
+		"frozen":    strconv.FormatBool(freeze.Frozen),
+		"reason":    freeze.Reason,
+	}
+	if !freeze.Until.IsZero() {
+		details["until"] = freeze.Until.UTC().Format(time.RFC3339)
+	}
+
+	store.AddAuditEvent(storage.AuditEvent{
+		Time:    freeze.SetAt,
+		Action:  AUDIT_FREEZE,
+		Actor:   freeze.SetBy,
+		Details: details,
+	})
+
+	c.JSON(http.StatusOK, freeze)
+}
+
+// DeleteAdminFreeze is the handler for DELETE /admin/hostgroups/:name/freeze
+//
+//	@Summary		Remove issuance freeze
+//	@ID				deleteAdminFreeze
+//	@Description	Remove the freeze set by an admin for a hostgroup, so that its freeze windows apply again.
+//	@Tags			admin
+//	@Security		AdminToken
+//	@Param			name	path	string	true	"Hostgroup name"
+//	@Success		204
+//	@Failure		400	{object}	ApiResponseError
+//	@Failure		401	{object}	ApiResponseError
+//	@Failure		403	{object}	ApiResponseError
+//	@Failure		404	{object}	ApiResponseError
+//	@Failure		500	{object}	ApiResponseError
+//	@Router			/admin/hostgroups/{name}/freeze [delete]
+func DeleteAdminFreeze(c *gin.Context) {
+	var uri UriHostGroup
+
+	if c.ShouldBindUri(&uri) != nil {
+		Error(c, http.StatusBadRequest, ERR_BAD_BODY)
+		return
+	}
+
+	store := c.MustGet("store").(storage.Store)
+
+	if err := store.DeleteFreeze(uri.Name); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			Error(c, http.StatusNotFound, ERR_NOT_FOUND)
+		} else {
+			Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		}
+		return
+	}
+
+	store.AddAuditEvent(storage.AuditEvent{
+		Time:    time.Now(),
+		Action:  AUDIT_UNFREEZE,
+		Actor:   c.GetString("admin"),
+		Details: map[string]string{"hostgroup": uri.Name},
+	})
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/cron"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIssuanceFreeze(t *testing.T) {
+	store := storage.NewMemoryStore()
+	now := time.Now()
+
+	schedule, err := cron.Parse("* * * * *")
+	assert.NoError(t, err)
+	windows := config.FreezeWindows{{Spec: "* * * * * 1h", Schedule: schedule, Duration: time.Hour}}
+
+	freeze, err := issuanceFreeze(store, "a", nil, "maintenance", now)
+	assert.NoError(t, err)
+	assert.False(t, freeze.Frozen)
+
+	freeze, err = issuanceFreeze(store, "a", windows, "maintenance", now)
+	assert.NoError(t, err)
+	assert.Equal(t, freezeState{Frozen: true, Source: FREEZE_SCHEDULE, Until: now.Truncate(time.Minute).Add(time.Hour), Reason: "maintenance"}, freeze)
+
+	// Admins can lift freeze windows
+	assert.NoError(t, store.SetFreeze(storage.Freeze{HostGroup: "a"}))
+
+	freeze, err = issuanceFreeze(store, "a", windows, "maintenance", now)
+	assert.NoError(t, err)
+	assert.False(t, freeze.Frozen)
+	assert.Equal(t, FREEZE_ADMIN, freeze.Source)
+
+	assert.NoError(t, store.SetFreeze(storage.Freeze{HostGroup: "a", Frozen: true}))
+
+	freeze, err = issuanceFreeze(store, "a", nil, "maintenance", now)
+	assert.NoError(t, err)
+	assert.Equal(t, freezeState{Frozen: true, Source: FREEZE_ADMIN, Reason: "maintenance"}, freeze)
+}
+
+func TestAdminFreeze(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	conf := config.Config{HostGroups: []config.HostGroup{{Name: "a"}}}
+	store := storage.NewMemoryStore()
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("config", conf)
+		c.Set("store", store)
+		c.Set("admin", "alice")
+	})
+	router.GET("/admin/freezes", GetAdminFreezes)
+	router.PUT("/admin/hostgroups/:name/freeze", PutAdminFreeze)
+	router.DELETE("/admin/hostgroups/:name/freeze", DeleteAdminFreeze)
+
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	assert.Equal(t, http.StatusBadRequest, request(http.MethodPut, "/admin/hostgroups/a/freeze", `{"reason": "incident"}`).Code)
+	assert.Equal(t, http.StatusNotFound, request(http.MethodPut, "/admin/hostgroups/b/freeze", `{"frozen": true}`).Code)
+	assert.Equal(t, http.StatusOK, request(http.MethodPut, "/admin/hostgroups/a/freeze", `{"frozen": true, "reason": "incident", "duration": 3600}`).Code)
+
+	var freezes []ApiResponseAdminFreeze
+	w := request(http.MethodGet, "/admin/freezes", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &freezes))
+	assert.Len(t, freezes, 1)
+	assert.True(t, freezes[0].Frozen)
+	assert.Equal(t, FREEZE_ADMIN, freezes[0].Source)
+	assert.Equal(t, "incident", freezes[0].Reason)
+	assert.Equal(t, "alice", freezes[0].Override.SetBy)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), *freezes[0].Until, time.Minute)
+
+	assert.Equal(t, http.StatusNoContent, request(http.MethodDelete, "/admin/hostgroups/a/freeze", "").Code)
+	assert.Equal(t, http.StatusNotFound, request(http.MethodDelete, "/admin/hostgroups/a/freeze", "").Code)
+
+	events, err := store.ListAuditEvents(time.Time{})
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+	assert.Equal(t, AUDIT_FREEZE, events[0].Action)
+	assert.Equal(t, AUDIT_UNFREEZE, events[1].Action)
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/geoip"
+)
+
+const (
+	ERR_GEO_DENIED = "geo_denied"
+
+	HINT_NETWORK = "hint_network"
+)
+
+// describeGeo returns a description of the network for decision traces.
+func describeGeo(ip net.IP, geo geoip.Info) string {
+	detail := ip.String()
+
+	if geo.Country != "" {
+		detail += ", country " + geo.Country
+	}
+
+	if geo.ASN != 0 {
+		detail += ", AS" + strconv.FormatUint(uint64(geo.ASN), 10)
+		if geo.Organization != "" {
+			detail += " (" + geo.Organization + ")"
+		}
+	}
+
+	return detail
+}
+
+// geoPolicy looks up the client address in the GeoIP databases and returns
+// the action of the geo rules of the host, see config.HostInfo.GeoAction,
+// along with a description of the network.
+func geoPolicy(conf config.Config, info config.HostInfo, clientIP string) (string, string, error) {
+	ip := net.ParseIP(clientIP)
+	if ip == nil || len(info.GeoDeny)+len(info.GeoLimit) == 0 {
+		return "", "", nil
+	}
+
+	geo, err := conf.GeoIP.Lookup(ip)
+	if err != nil {
+		return "", "", err
+	}
+
+	action, rule := info.GeoAction(geo)
+	detail := describeGeo(ip, geo)
+	if action != "" {
+		detail += ": " + action + " by rule " + rule
+	}
+
+	return action, detail, nil
+}
+
+// geoLimit returns the certificate duration, shortened to the validity of
+// the geo-limit rules if the action is config.GEO_LIMIT.
+func geoLimit(info config.HostInfo, action string, certDuration int) int {
+	if action == config.GEO_LIMIT && (certDuration <= 0 || info.GeoLimitValidity < certDuration) {
+		return info.GeoLimitValidity
+	}
+
+	return certDuration
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"net"
+	"testing"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/geoip"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeoPolicy(t *testing.T) {
+	info := config.HostInfo{GeoDeny: []string{"KP"}, GeoLimit: []string{"AS64496"}, GeoLimitValidity: 3600}
+
+	assert.Equal(t, 3600, geoLimit(info, config.GEO_LIMIT, 86400))
+	assert.Equal(t, 3600, geoLimit(info, config.GEO_LIMIT, 0))
+	assert.Equal(t, 600, geoLimit(info, config.GEO_LIMIT, 600))
+	assert.Equal(t, 86400, geoLimit(info, "", 86400))
+
+	assert.Equal(t, "192.0.2.1, country DE, AS64496 (Example)",
+		describeGeo(net.ParseIP("192.0.2.1"), geoip.Info{Country: "DE", ASN: 64496, Organization: "Example"}))
+	assert.Equal(t, "2001:db8::1", describeGeo(net.ParseIP("2001:db8::1"), geoip.Info{}))
+
+	// Without databases, addresses are not found in any network
+	action, detail, err := geoPolicy(config.Config{}, info, "192.0.2.1")
+	assert.NoError(t, err)
+	assert.Equal(t, "", action)
+	assert.Equal(t, "192.0.2.1", detail)
+
+	action, detail, err = geoPolicy(config.Config{}, config.HostInfo{}, "192.0.2.1")
+	assert.NoError(t, err)
+	assert.Equal(t, "", action+detail)
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/util"
+	"github.com/lbrocke/oinit/pkg/libmotleycue"
+)
+
+// authorization is the state of a user as last reported by motley_cue.
+type authorization struct {
+	status libmotleycue.ApiResponseUserStatus
+	time   time.Time
+}
+
+// authorizations contains the last successful authorization by subject and
+// host, kept for the grace-period of the hostgroup.
+var authorizations = util.NewTimedCache[string, authorization]()
+
+// rememberAuthorization records that motley_cue authorized the subject on
+// the host, if the hostgroup allows grace certificates.
+func rememberAuthorization(info config.HostInfo, subject, host string, status libmotleycue.ApiResponseUserStatus) {
+	if info.GracePeriod <= 0 || subject == "" {
+		return
+	}
+
+	authorizations.Prune()
+	authorizations.Set(subject+" "+host, authorization{status, time.Now()}, time.Duration(info.GracePeriod))
+}
+
+// graceAuthorization returns the last authorization of the subject on the
+// host if motley_cue failed with err because it is unreachable, and the
+// subject was authorized within the grace-period of the hostgroup.
+func graceAuthorization(info config.HostInfo, subject, host string, err error) (authorization, bool) {
+	if info.GracePeriod <= 0 || subject == "" || !libmotleycue.Unavailable(err) {
+		return authorization{}, false
+	}
+
+	auth, ok := authorizations.Get(subject + " " + host)
+	if !ok {
+		return authorization{}, false
+	}
+
+	Logger.Printf("GRACE: motley_cue is unreachable (%s), issuing grace certificate to %s for %s, last authorized %s ago",
+		err, subject, host, time.Since(auth.time).Round(time.Second))
+
+	return auth, true
+}
+
+// graceLimit limits the duration of grace certificates to the grace-validity
+// of the hostgroup.
+func graceLimit(info config.HostInfo, grace bool, certDuration int) int {
+	if grace && (certDuration <= 0 || info.GraceValidity < certDuration) {
+		return info.GraceValidity
+	}
+
+	return certDuration
+}
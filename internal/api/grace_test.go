@@ -0,0 +1,41 @@
+package api
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/pkg/libmotleycue"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraceAuthorization(t *testing.T) {
+	info := config.HostInfo{GracePeriod: 300, GraceValidity: 600}
+	status := libmotleycue.ApiResponseUserStatus{State: libmotleycue.StateDeployed}
+	unavailable := libmotleycue.StatusError{StatusCode: 503}
+
+	_, ok := graceAuthorization(info, "alice@https://issuer", "grace.example.com", unavailable)
+	assert.False(t, ok)
+
+	rememberAuthorization(info, "alice@https://issuer", "grace.example.com", status)
+
+	auth, ok := graceAuthorization(info, "alice@https://issuer", "grace.example.com", unavailable)
+	assert.True(t, ok)
+	assert.Equal(t, status, auth.status)
+
+	// Only unreachable instances are bridged, not denials
+	_, ok = graceAuthorization(info, "alice@https://issuer", "grace.example.com", errors.New("token expired"))
+	assert.False(t, ok)
+
+	_, ok = graceAuthorization(info, "alice@https://issuer", "other.example.com", unavailable)
+	assert.False(t, ok)
+
+	_, ok = graceAuthorization(config.HostInfo{}, "alice@https://issuer", "grace.example.com", unavailable)
+	assert.False(t, ok)
+
+	assert.Equal(t, 600, graceLimit(info, true, 3600))
+	assert.Equal(t, 600, graceLimit(info, true, 0))
+	assert.Equal(t, 300, graceLimit(info, true, 300))
+	assert.Equal(t, 3600, graceLimit(info, false, 3600))
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/ntp"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	HEALTH_OK       = "ok"
+	HEALTH_DEGRADED = "degraded"
+
+	CLOCK_OK       = "ok"
+	CLOCK_SKEWED   = "skewed"
+	CLOCK_UNKNOWN  = "unknown"
+	CLOCK_DISABLED = "disabled"
+)
+
+type ApiResponseHealth struct {
+	Status string `json:"status" example:"ok"`
+	// "primary", or "replica" if the CA is a warm standby that doesn't sign
+	// certificates until it is promoted
+	Role  string                 `json:"role" example:"primary"`
+	Clock ApiResponseClockHealth `json:"clock"`
+}
+
+type ApiResponseClockHealth struct {
+	Status string `json:"status" example:"ok"`
+	Server string `json:"server,omitempty" example:"pool.ntp.org"`
+	// Offset of the CA clock, positive if ahead
+	OffsetMs    int64      `json:"offset_ms"`
+	ToleranceMs int64      `json:"tolerance_ms"`
+	CheckedAt   *time.Time `json:"checked_at,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// clockHealth returns the health of the clock as measured by the monitor,
+// which is nil if NTP checks are disabled.
+func clockHealth(monitor *ntp.Monitor, tolerance time.Duration) ApiResponseClockHealth {
+	health := ApiResponseClockHealth{
+		Status:      CLOCK_DISABLED,
+		ToleranceMs: tolerance.Milliseconds(),
+	}
+
+	if monitor == nil {
+		return health
+	}
+
+	clock := monitor.Clock()
+
+	health.Server = clock.Server
+	health.OffsetMs = clock.Offset.Milliseconds()
+
+	switch {
+	case clock.CheckedAt.IsZero():
+		health.Status = CLOCK_UNKNOWN
+		return health
+	case clock.Err != nil:
+		health.Status = CLOCK_UNKNOWN
+		health.Error = clock.Err.Error()
+	case clock.Skewed(tolerance):
+		health.Status = CLOCK_SKEWED
+	default:
+		health.Status = CLOCK_OK
+	}
+
+	health.CheckedAt = &clock.CheckedAt
+
+	return health
+}
+
+// GetHealth is the handler for GET /health
+//
+//	@Summary		Get CA health
+//	@ID				getHealth
+//	@Description	Return the health of the CA. The CA is degraded if its clock is off by more than the clock skew tolerance, as hosts would reject issued certificates.
+//	@Description	Replicas are healthy, but report their role so that load balancers can send requests for certificates to the primary CA.
+//	@Produce		json
+//	@Success		200	{object}	ApiResponseHealth
+//	@Failure		500	{object}	ApiResponseError
+//	@Failure		503	{object}	ApiResponseHealth
+//	@Router			/health [get]
+func GetHealth(c *gin.Context) {
+	conf, ok := c.MustGet("config").(config.Config)
+	if !ok {
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	monitor, _ := c.MustGet("clock").(*ntp.Monitor)
+
+	health := ApiResponseHealth{
+		Status: HEALTH_OK,
+		Role:   role(c),
+		Clock:  clockHealth(monitor, time.Duration(conf.Server.ClockSkewTolerance)*time.Second),
+	}
+
+	code := http.StatusOK
+	if health.Clock.Status == CLOCK_SKEWED {
+		health.Status = HEALTH_DEGRADED
+		code = http.StatusServiceUnavailable
+	}
+
+	c.JSON(code, health)
+}
@@ -0,0 +1,21 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/ntp"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClockHealth(t *testing.T) {
+	assert.Equal(t, CLOCK_DISABLED, clockHealth(nil, time.Second).Status)
+
+	// Not checked yet
+	monitor := ntp.NewMonitor("127.0.0.1")
+	assert.Equal(t, CLOCK_UNKNOWN, clockHealth(monitor, time.Second).Status)
+
+	assert.False(t, ntp.Clock{Offset: 2 * time.Second, CheckedAt: time.Now()}.Skewed(5*time.Second))
+	assert.True(t, ntp.Clock{Offset: -6 * time.Second, CheckedAt: time.Now()}.Skewed(5*time.Second))
+}
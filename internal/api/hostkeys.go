@@ -0,0 +1,125 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/service"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ERR_INVALID_HOSTKEYS = service.ERR_INVALID_HOSTKEYS
+	ERR_NO_HOSTKEYS      = service.ERR_NO_HOSTKEYS
+)
+
+// FormHostKeys is a report of the host keys of a host, signed with the key
+// of its host certificate using 'ssh-keygen -Y sign'.
+type FormHostKeys struct {
+	// Public keys in authorized_keys format, one per line
+	HostKeys string `form:"hostkeys" json:"hostkeys" binding:"required"`
+	// Unix timestamp of the report
+	Timestamp int64 `form:"timestamp" json:"timestamp" binding:"required"`
+	// Host certificate issued by the host CA of this host
+	Certificate string `form:"certificate" json:"certificate" binding:"required"`
+	// Armored SSH signature over "<host>\n<timestamp>\n<hostkeys>"
+	Signature string `form:"signature" json:"signature" binding:"required"`
+}
+
+type ApiResponseHostKeys struct {
+	Host        string    `json:"host"`
+	HostKeys    []string  `json:"hostkeys"`
+	Certificate string    `json:"certificate"`
+	ReportedAt  time.Time `json:"reported_at"`
+}
+
+// PostHostKeys is the handler for POST /:host/hostkeys
+//
+//	@Summary		Report host keys
+//	@ID				reportHostKeys
+//	@Description	Report the SSH host keys of a host, which are then returned by GET /{host}/hostkeys.
+//	@Description	The report must be signed with the key of a valid host certificate for this host, e.g. using scripts/oinit-report-hostkeys.sh.
+//	@Accept			json,mpfd
+//	@Produce		json
+//	@Param			host	path		string			true	"Host"	example("example.com")
+//	@Param			body	body		FormHostKeys	true	"Signed host keys"
+//	@Success		200		{object}	ApiResponseHostKeys
+//	@Failure		400		{object}	ApiResponseError
+//	@Failure		401		{object}	ApiResponseError
+//	@Failure		403		{object}	ApiResponseError
+//	@Failure		404		{object}	ApiResponseError
+//	@Failure		500		{object}	ApiResponseError
+//	@Failure		504		{object}	ApiResponseError
+//	@Router			/{host}/hostkeys [post]
+func PostHostKeys(c *gin.Context) {
+	var host UriHost
+	var body FormHostKeys
+
+	if c.ShouldBindUri(&host) != nil || c.ShouldBind(&body) != nil {
+		Error(c, http.StatusBadRequest, ERR_BAD_BODY)
+		return
+	}
+
+	svc, ok := newService(c)
+	if !ok {
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	hostKeys, err := svc.ReportHostKeys(c.Request.Context(), strings.ToLower(host.Host), service.HostKeysReport(body))
+	if err != nil {
+		serviceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, hostKeysResponse(hostKeys))
+}
+
+// GetHostKeys is the handler for GET /:host/hostkeys
+//
+//	@Summary		Get host keys
+//	@ID				getHostKeys
+//	@Description	Return the SSH host keys and host certificate last reported by the given host, so clients can verify its identity on first connect.
+//	@Produce		json
+//	@Param			host	path		string	true	"Host"	example("example.com")
+//	@Success		200		{object}	ApiResponseHostKeys
+//	@Failure		400		{object}	ApiResponseError
+//	@Failure		403		{object}	ApiResponseError
+//	@Failure		404		{object}	ApiResponseError
+//	@Failure		500		{object}	ApiResponseError
+//	@Failure		504		{object}	ApiResponseError
+//	@Router			/{host}/hostkeys [get]
+func GetHostKeys(c *gin.Context) {
+	var host UriHost
+
+	if c.ShouldBindUri(&host) != nil {
+		Error(c, http.StatusBadRequest, ERR_BAD_BODY)
+		return
+	}
+
+	svc, ok := newService(c)
+	if !ok {
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	hostKeys, err := svc.HostKeys(c.Request.Context(), strings.ToLower(host.Host))
+	if err != nil {
+		serviceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, hostKeysResponse(hostKeys))
+}
+
+func hostKeysResponse(hostKeys storage.HostKeys) ApiResponseHostKeys {
+	return ApiResponseHostKeys{
+		Host:        hostKeys.Host,
+		HostKeys:    hostKeys.Keys,
+		Certificate: hostKeys.Certificate,
+		ReportedAt:  hostKeys.ReportedAt,
+	}
+}
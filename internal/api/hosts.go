@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/util"
+)
+
+const (
+	// Number of lookups for an unknown host after which it is answered from
+	// the negative cache.
+	UNKNOWN_HOST_THRESHOLD = 3
+
+	// Maximum number of unknown hosts that are counted, so that requests
+	// for random host names don't grow the negative cache without bound.
+	// Further unknown hosts are looked up each time until entries expire.
+	MAX_UNKNOWN_HOSTS = 10000
+)
+
+// unknownHosts counts lookups of hosts that are not configured (or do not
+// exist in DNS in strict mode).
+var unknownHosts = util.NewTimedCache[string, int]()
+
+// resolvedHosts remembers hosts that were found in DNS in strict mode.
+var resolvedHosts = util.NewTimedCache[string, bool]()
+
+// lookupHost returns the host info for the given host. Hosts that repeatedly
+// turned out to be unknown are rejected from a negative cache without looking
+// them up again. In strict mode, the host must also exist in DNS; if ctx is
+// done before the lookup completes, its error is returned and the host is not
+// counted as unknown.
+func lookupHost(ctx context.Context, conf config.Config, host string) (config.HostInfo, error) {
+	negativeDuration := time.Duration(conf.Server.NegativeCacheDuration)
+
+	if count, ok := unknownHosts.Get(host); ok && count >= UNKNOWN_HOST_THRESHOLD {
+		return config.HostInfo{}, fmt.Errorf("%w: %s is cached as unknown", config.ErrHostNotFound, host)
+	}
+
+	info, err := conf.GetInfo(host)
+	if err == nil && conf.Server.StrictHosts && !hostResolves(ctx, host, time.Duration(info.CacheDuration)) {
+		err = fmt.Errorf("%w: %s does not resolve", config.ErrHostNotFound, host)
+	}
+
+	if err != nil && ctx.Err() != nil {
+		return config.HostInfo{}, ctx.Err()
+	}
+
+	if err != nil {
+		count, known := unknownHosts.Get(host)
+
+		if !known {
+			unknownHosts.Prune()
+		}
+
+		if known || unknownHosts.Len() < MAX_UNKNOWN_HOSTS {
+			unknownHosts.Set(host, count+1, negativeDuration)
+		}
+
+		return config.HostInfo{}, err
+	}
+
+	return info, nil
+}
+
+// hostResolves returns whether the given host exists in DNS. Positive results
+// are cached for the given duration.
+func hostResolves(ctx context.Context, host string, duration time.Duration) bool {
+	if _, ok := resolvedHosts.Get(host); ok {
+		return true
+	}
+
+	if addrs, err := net.DefaultResolver.LookupHost(ctx, host); err != nil || len(addrs) == 0 {
+		return false
+	}
+
+	resolvedHosts.Prune()
+	resolvedHosts.Set(host, true, duration)
+
+	return true
+}
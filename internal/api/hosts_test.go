@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/util"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupHost(t *testing.T) {
+	conf := config.Config{
+		Server: config.ServerOptions{
+			NegativeCacheDuration: 60,
+		},
+		HostGroups: []config.HostGroup{
+			{
+				Name:  "example.com",
+				Hosts: map[string]string{"login.example.com": "https://login.example.com:8443"},
+			},
+		},
+	}
+
+	info, err := lookupHost(context.Background(), conf, "login.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://login.example.com:8443", info.URL)
+
+	for i := 0; i < UNKNOWN_HOST_THRESHOLD; i++ {
+		_, err := lookupHost(context.Background(), conf, "unknown.example.com")
+		assert.ErrorIs(t, err, config.ErrHostNotFound)
+	}
+
+	count, ok := unknownHosts.Get("unknown.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, UNKNOWN_HOST_THRESHOLD, count)
+
+	// Answered from negative cache, so the counter doesn't increase anymore.
+	_, err = lookupHost(context.Background(), conf, "unknown.example.com")
+	assert.ErrorIs(t, err, config.ErrHostNotFound)
+
+	count, _ = unknownHosts.Get("unknown.example.com")
+	assert.Equal(t, UNKNOWN_HOST_THRESHOLD, count)
+}
+
+func TestLookupHostBounded(t *testing.T) {
+	saved := unknownHosts
+	t.Cleanup(func() { unknownHosts = saved })
+
+	conf := config.Config{Server: config.ServerOptions{NegativeCacheDuration: -1}}
+
+	// Expired entries are pruned before new hosts are counted
+	unknownHosts = util.NewTimedCache[string, int]()
+	for i := 0; i < 100; i++ {
+		lookupHost(context.Background(), conf, fmt.Sprintf("random%d.example.com", i))
+	}
+	assert.Equal(t, 1, unknownHosts.Len())
+
+	// Further unknown hosts are not counted once the cache is full
+	conf.Server.NegativeCacheDuration = 60
+	unknownHosts = util.NewTimedCache[string, int]()
+	for i := 0; i < MAX_UNKNOWN_HOSTS+10; i++ {
+		_, err := lookupHost(context.Background(), conf, fmt.Sprintf("random%d.example.com", i))
+		assert.ErrorIs(t, err, config.ErrHostNotFound)
+	}
+	assert.Equal(t, MAX_UNKNOWN_HOSTS, unknownHosts.Len())
+
+	// Hosts that are already counted still are
+	lookupHost(context.Background(), conf, "random0.example.com")
+	count, _ := unknownHosts.Get("random0.example.com")
+	assert.Equal(t, 2, count)
+}
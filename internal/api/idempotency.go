@@ -0,0 +1,153 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/storage"
+	"github.com/lbrocke/oinit/internal/tokenbind"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	HEADER_IDEMPOTENCY_KEY = "Idempotency-Key"
+	// Set to "true" if the response was recorded for an earlier request
+	HEADER_IDEMPOTENT_REPLAYED = "Idempotent-Replayed"
+
+	MAX_IDEMPOTENCY_KEY_LENGTH = 255
+
+	ERR_INVALID_IDEMPOTENCY_KEY = "invalid_idempotency_key"
+	ERR_IDEMPOTENCY_KEY_REUSED  = "idempotency_key_reused"
+	ERR_IDEMPOTENCY_IN_PROGRESS = "idempotency_in_progress"
+)
+
+var (
+	ErrIdempotencyKeyReused  = errors.New(ERR_IDEMPOTENCY_KEY_REUSED)
+	ErrIdempotencyInProgress = errors.New(ERR_IDEMPOTENCY_IN_PROGRESS)
+)
+
+// validIdempotencyKey reports whether the key consists of 1 to
+// MAX_IDEMPOTENCY_KEY_LENGTH printable ASCII characters.
+func validIdempotencyKey(key string) bool {
+	if len(key) == 0 || len(key) > MAX_IDEMPOTENCY_KEY_LENGTH {
+		return false
+	}
+
+	for _, r := range key {
+		if r < 0x21 || r > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+// idempotency deduplicates certificate requests of a user carrying the same
+// Idempotency-Key. Keys are scoped to the subject, which must have been
+// verified, so users can't obtain the certificates of others.
+type idempotency struct {
+	store storage.Store
+	key   string
+	// Hash of the request, a key must not be reused for a different request
+	hash   string
+	window time.Duration
+}
+
+// newIdempotency returns nil if no key was sent or idempotency is disabled.
+func newIdempotency(store storage.Store, key, subject, host string, pubkey ssh.PublicKey, body FormHostCertificate, window time.Duration) *idempotency {
+	if key == "" || window <= 0 {
+		return nil
+	}
+
+	extensions := append([]string{}, body.Extensions...)
+	sort.Strings(extensions)
+
+	// Certificates are bound to the token, so retries must use the same one
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		tokenbind.Hash(body.Token),
+		host,
+		ssh.FingerprintSHA256(pubkey),
+		strings.Join(extensions, ","),
+		body.Command,
+		body.Profile,
+	}, "\n")))
+
+	return &idempotency{
+		store:  store,
+		key:    "idempotency " + subject + " " + key,
+		hash:   hex.EncodeToString(sum[:]),
+		window: window,
+	}
+}
+
+// previous returns the certificate issued for an earlier request with the
+// same key, or an empty string if there is none or it has been revoked.
+// ErrIdempotencyKeyReused is returned if the key was used for a different
+// request.
+func (i *idempotency) previous() (string, error) {
+	response, err := i.store.GetIdempotentResponse(i.key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	if response.RequestHash != i.hash {
+		return "", fmt.Errorf("%w: %s", ErrIdempotencyKeyReused, i.key)
+	}
+
+	revocations, err := i.store.ListRevocations()
+	if err != nil {
+		return "", err
+	}
+
+	for _, rev := range revocations {
+		if rev.Serial == response.Serial {
+			return "", nil
+		}
+	}
+
+	return response.Certificate, nil
+}
+
+// lock prevents concurrent requests with the same key from being processed
+// for the duration of timeout, after which the first request has either
+// recorded its certificate or failed. ErrIdempotencyInProgress is returned if
+// another request holds the lock.
+func (i *idempotency) lock(timeout time.Duration) error {
+	previous, err := i.store.MarkSeen(i.key+" lock", i.hash, timeout)
+	if err != nil {
+		return err
+	}
+
+	if previous != "" {
+		return fmt.Errorf("%w: %s", ErrIdempotencyInProgress, i.key)
+	}
+
+	return nil
+}
+
+// record remembers the issued certificate until the window has passed or the
+// certificate expires.
+func (i *idempotency) record(cert ssh.Certificate, certificate string) error {
+	expires := time.Now().Add(i.window)
+	if validBefore := time.Unix(int64(cert.ValidBefore), 0); validBefore.Before(expires) {
+		expires = validBefore
+	}
+
+	return i.store.SetIdempotentResponse(storage.IdempotentResponse{
+		Key:         i.key,
+		RequestHash: i.hash,
+		Serial:      cert.Serial,
+		Certificate: certificate,
+		Expires:     expires,
+	})
+}
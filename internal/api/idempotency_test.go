@@ -0,0 +1,70 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestIdempotency(t *testing.T) {
+	store := storage.NewMemoryStore()
+
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	pubkey, _ := ssh.NewPublicKey(pub)
+	body := FormHostCertificate{Token: "token", Extensions: []string{"permit-pty", "permit-agent-forwarding"}}
+
+	assert.Nil(t, newIdempotency(store, "", "alice", "example.com", pubkey, body, time.Hour))
+	assert.Nil(t, newIdempotency(store, "key", "alice", "example.com", pubkey, body, 0))
+
+	first := newIdempotency(store, "key", "alice", "example.com", pubkey, body, time.Hour)
+
+	previous, err := first.previous()
+	assert.NoError(t, err)
+	assert.Empty(t, previous)
+
+	assert.NoError(t, first.lock(time.Minute))
+	// Concurrent retries wait for the first request
+	assert.ErrorIs(t, first.lock(time.Minute), ErrIdempotencyInProgress)
+
+	cert := ssh.Certificate{Serial: 1, ValidBefore: uint64(time.Now().Add(time.Hour).Unix())}
+	assert.NoError(t, first.record(cert, "ssh-ed25519-cert-v01@openssh.com AAAA"))
+
+	// The order of extensions does not matter
+	body.Extensions = []string{"permit-agent-forwarding", "permit-pty"}
+	retry := newIdempotency(store, "key", "alice", "example.com", pubkey, body, time.Hour)
+
+	previous, err = retry.previous()
+	assert.NoError(t, err)
+	assert.Equal(t, "ssh-ed25519-cert-v01@openssh.com AAAA", previous)
+
+	// Keys are scoped to the user
+	other := newIdempotency(store, "key", "bob", "example.com", pubkey, body, time.Hour)
+	previous, err = other.previous()
+	assert.NoError(t, err)
+	assert.Empty(t, previous)
+
+	// The key must not be reused for a different request
+	different := newIdempotency(store, "key", "alice", "other.example.com", pubkey, body, time.Hour)
+	_, err = different.previous()
+	assert.ErrorIs(t, err, ErrIdempotencyKeyReused)
+
+	// Revoked certificates are not returned again
+	assert.NoError(t, store.Revoke(storage.Revocation{Serial: 1, ValidBefore: time.Now().Add(time.Hour)}))
+	previous, err = retry.previous()
+	assert.NoError(t, err)
+	assert.Empty(t, previous)
+}
+
+func TestValidIdempotencyKey(t *testing.T) {
+	assert.True(t, validIdempotencyKey("8e03978e-40d5-43e8-bc93-6894a57f9324"))
+	assert.False(t, validIdempotencyKey(""))
+	assert.False(t, validIdempotencyKey("with space"))
+	assert.False(t, validIdempotencyKey("ümlaut"))
+	assert.False(t, validIdempotencyKey(string(make([]byte, MAX_IDEMPOTENCY_KEY_LENGTH+1))))
+}
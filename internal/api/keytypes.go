@@ -0,0 +1,91 @@
+package api
+
+import (
+	"crypto/rsa"
+
+	"github.com/lbrocke/oinit/internal/approved"
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/sshversion"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// Message codes, see package i18n
+	MSG_KEY_NOT_ACCEPTED  = "key_not_accepted"
+	MSG_RSA_KEY_TOO_SMALL = "rsa_key_too_small"
+)
+
+// KeyAlgorithm is a client key type accepted for a host.
+type KeyAlgorithm struct {
+	Type string `json:"type" example:"ssh-rsa"`
+	// Minimum size of RSA keys, omitted for other types
+	MinBits int `json:"min_bits,omitempty" example:"3072"`
+}
+
+// acceptedKeyAlgorithms returns the client key types that certificates are
+// issued for on the host: those accepted by its hostgroup which are also
+// approved, if approved-algorithms is set, and supported by the OpenSSH
+// version of the host, if known.
+func acceptedKeyAlgorithms(conf config.Config, info config.HostInfo, version sshversion.Version, known bool) []KeyAlgorithm {
+	types := info.KeyTypes
+	if len(types) == 0 {
+		types = config.KeyTypes
+	}
+
+	minBits := info.MinRSABits
+	if conf.Server.ApprovedAlgorithms && minBits < approved.MIN_RSA_BITS {
+		minBits = approved.MIN_RSA_BITS
+	}
+
+	algorithms := []KeyAlgorithm{}
+	for _, keyType := range types {
+		if conf.Server.ApprovedAlgorithms && !slices.Contains(approved.KeyTypes, keyType) {
+			continue
+		}
+
+		if known && !version.SupportsKeyType(keyType) {
+			continue
+		}
+
+		algorithm := KeyAlgorithm{Type: keyType}
+		if keyType == ssh.KeyAlgoRSA {
+			algorithm.MinBits = minBits
+		}
+
+		algorithms = append(algorithms, algorithm)
+	}
+
+	return algorithms
+}
+
+// rsaBits returns the size of an RSA public key, or 0 for other keys.
+func rsaBits(pk ssh.PublicKey) int {
+	cpk, ok := pk.(ssh.CryptoPublicKey)
+	if !ok {
+		return 0
+	}
+
+	rsaKey, ok := cpk.CryptoPublicKey().(*rsa.PublicKey)
+	if !ok {
+		return 0
+	}
+
+	return rsaKey.N.BitLen()
+}
+
+// signatureAlgorithm returns the algorithm the signer signs certificates
+// with, which is the first algorithm of signers supporting several, see
+// ssh.Certificate.SignCert.
+func signatureAlgorithm(signer ssh.Signer) string {
+	if multi, ok := signer.(ssh.MultiAlgorithmSigner); ok && len(multi.Algorithms()) != 0 {
+		return multi.Algorithms()[0]
+	}
+
+	if signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		return ssh.KeyAlgoRSASHA512
+	}
+
+	return signer.PublicKey().Type()
+}
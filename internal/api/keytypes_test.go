@@ -0,0 +1,55 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/lbrocke/oinit/internal/approved"
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/sshversion"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestAcceptedKeyAlgorithms(t *testing.T) {
+	info := config.HostInfo{KeyTypes: []string{ssh.KeyAlgoED25519, ssh.KeyAlgoRSA, ssh.KeyAlgoSKECDSA256}, MinRSABits: 2048}
+
+	assert.Equal(t, []KeyAlgorithm{
+		{Type: ssh.KeyAlgoED25519},
+		{Type: ssh.KeyAlgoRSA, MinBits: 2048},
+		{Type: ssh.KeyAlgoSKECDSA256},
+	}, acceptedKeyAlgorithms(config.Config{}, info, sshversion.Version{}, false))
+
+	// Security keys are not supported by OpenSSH 7.4
+	assert.Equal(t, []KeyAlgorithm{
+		{Type: ssh.KeyAlgoED25519},
+		{Type: ssh.KeyAlgoRSA, MinBits: 2048},
+	}, acceptedKeyAlgorithms(config.Config{}, info, sshversion.Version{Major: 7, Minor: 4}, true))
+
+	var conf config.Config
+	conf.Server.ApprovedAlgorithms = true
+
+	assert.Equal(t, []KeyAlgorithm{
+		{Type: ssh.KeyAlgoRSA, MinBits: approved.MIN_RSA_BITS},
+	}, acceptedKeyAlgorithms(conf, info, sshversion.Version{}, false))
+
+	assert.Len(t, acceptedKeyAlgorithms(config.Config{}, config.HostInfo{}, sshversion.Version{}, false), len(config.KeyTypes))
+}
+
+func TestSignatureAlgorithm(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	edSigner, _ := ssh.NewSignerFromKey(edKey)
+	assert.Equal(t, ssh.KeyAlgoED25519, signatureAlgorithm(edSigner))
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsaSigner, _ := ssh.NewSignerFromKey(rsaKey)
+	assert.Equal(t, ssh.KeyAlgoRSASHA256, signatureAlgorithm(rsaSigner))
+	assert.Equal(t, 2048, rsaBits(rsaSigner.PublicKey()))
+	assert.Zero(t, rsaBits(edSigner.PublicKey()))
+
+	sha1Signer, _ := ssh.NewSignerWithAlgorithms(rsaSigner.(ssh.AlgorithmSigner), []string{ssh.KeyAlgoRSA})
+	assert.Equal(t, ssh.KeyAlgoRSA, signatureAlgorithm(sha1Signer))
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/krl"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/ssh"
+)
+
+// GetHostKRL is the handler for GET /:host/krl
+//
+//	@Summary		Get key revocation list
+//	@ID				getHostKrl
+//	@Description	Return the OpenSSH key revocation list (KRL) of certificates revoked for the user CA of the given host, suitable for the RevokedKeys option of sshd.
+//	@Description	The ETag is the SHA-256 hash of the KRL, so hosts can send the hash of their installed KRL in If-None-Match.
+//	@Produce		octet-stream
+//	@Param			host			path		string	true	"Host"	example("example.com")
+//	@Param			If-None-Match	header		string	false	"ETag of the installed KRL"
+//	@Success		200				{file}		binary
+//	@Success		304
+//	@Failure		400		{object}	ApiResponseError
+//	@Failure		403		{object}	ApiResponseError
+//	@Failure		404		{object}	ApiResponseError
+//	@Failure		500		{object}	ApiResponseError
+//	@Failure		504		{object}	ApiResponseError
+//	@Router			/{host}/krl [get]
+func GetHostKRL(c *gin.Context) {
+	var host UriHost
+
+	if c.ShouldBindUri(&host) != nil {
+		Error(c, http.StatusBadRequest, ERR_BAD_BODY)
+		return
+	}
+
+	host.Host = strings.ToLower(host.Host)
+
+	conf, ok := c.MustGet("config").(config.Config)
+	if !ok {
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	info, err := lookupHost(c.Request.Context(), conf, host.Host)
+	if err != nil {
+		if timedOut(c) {
+			return
+		}
+
+		Error(c, http.StatusNotFound, ERR_UNKNOWN_HOST)
+		return
+	}
+
+	data, err := generateKRL(c.MustGet("store").(storage.Store), info.UserCAPublicKey)
+	if err != nil {
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	etag := krl.ETag(data)
+	c.Header("ETag", etag)
+
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/octet-stream", data)
+}
+
+// generateKRL returns a KRL of all certificates revoked for the given CA. The
+// KRL version is the time of the latest revocation, so it increases whenever
+// a certificate is revoked.
+func generateKRL(store storage.Store, caKey ssh.PublicKey) ([]byte, error) {
+	revocations, err := store.ListRevocations()
+	if err != nil {
+		return nil, err
+	}
+
+	ca := ssh.FingerprintSHA256(caKey)
+
+	var serials []uint64
+	var version uint64
+
+	for _, rev := range revocations {
+		if rev.CA != ca {
+			continue
+		}
+
+		serials = append(serials, rev.Serial)
+
+		if v := uint64(rev.RevokedAt.Unix()); v > version {
+			version = v
+		}
+	}
+
+	return krl.Generate(caKey, serials, version, "oinit-ca"), nil
+}
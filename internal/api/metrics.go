@@ -0,0 +1,105 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/metrics"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// Certificates with token-bound validity that expire within this
+	// duration after issuance indicate providers issuing almost expired
+	// access tokens.
+	NEAR_EXPIRY = 5 * time.Minute
+)
+
+// validityBuckets are the upper bounds (in seconds) of the validity
+// histogram, from one minute to one week.
+var validityBuckets = []float64{60, 300, 900, 1800, 3600, 4 * 3600, 12 * 3600, 24 * 3600, 7 * 24 * 3600}
+
+var certificateValidity = metrics.NewHistogram(
+	"oinit_ca_certificate_validity_seconds",
+	"Validity of issued certificates in seconds.",
+	"hostgroup",
+	validityBuckets,
+)
+
+// observeValidity records the validity of an issued certificate and warns if
+// its validity is token-bound and about to end.
+func observeValidity(info config.HostInfo, cert ssh.Certificate) {
+	validity := time.Until(time.Unix(int64(cert.ValidBefore), 0))
+
+	certificateValidity.Observe(info.HostGroup, validity.Seconds())
+
+	if info.CertDuration <= 0 && validity < NEAR_EXPIRY {
+		Logger.Printf("Certificate %d for host %s is only valid for %s, as the access token is about to expire", cert.Serial, info.Name, validity.Round(time.Second))
+	}
+}
+
+// GetAdminMetrics is the handler for GET /admin/metrics
+//
+//	@Summary		Get metrics
+//	@ID				getAdminMetrics
+//	@Description	Return metrics in the Prometheus text format: a histogram of the validity of certificates issued since
+//	@Description	the CA started, and the number of unexpired certificates with token-bound validity which were valid
+//	@Description	for less than five minutes when issued, the number of clients delayed by the tarpit middleware, and
+//	@Description	the health of motley_cue instances: state of the circuit breaker, requests, failures, error rate,
+//	@Description	last success, pooled connections and a latency histogram.
+//	@Tags			admin
+//	@Produce		plain
+//	@Security		AdminToken
+//	@Success		200	{string}	string
+//	@Failure		401	{object}	ApiResponseError
+//	@Failure		403	{object}	ApiResponseError
+//	@Failure		500	{object}	ApiResponseError
+//	@Router			/admin/metrics [get]
+func GetAdminMetrics(c *gin.Context) {
+	conf := c.MustGet("config").(config.Config)
+	store := c.MustGet("store").(storage.Store)
+
+	certs, err := store.ListCertificates(storage.CertificateFilter{ValidAt: time.Now()})
+	if err != nil {
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	nearExpiry := make(map[string]float64)
+	for _, group := range conf.HostGroups {
+		if group.CertDuration <= 0 {
+			nearExpiry[group.Name] = 0
+		}
+	}
+
+	for _, cert := range certs {
+		if _, ok := nearExpiry[cert.HostGroup]; ok && cert.ValidBefore.Sub(cert.IssuedAt) < NEAR_EXPIRY {
+			nearExpiry[cert.HostGroup]++
+		}
+	}
+
+	c.Header("Content-Type", metrics.CONTENT_TYPE)
+	c.Status(http.StatusOK)
+
+	certificateValidity.Write(c.Writer)
+	metrics.WriteGauge(c.Writer,
+		"oinit_ca_certificates_near_expiry",
+		"Unexpired certificates with token-bound validity that were valid for less than five minutes when issued.",
+		"hostgroup",
+		nearExpiry,
+	)
+
+	delayed, alerted := failedAuth.stats(conf.Server.TarpitThreshold, time.Now())
+	metrics.WriteGauge(c.Writer,
+		"oinit_ca_tarpit_clients",
+		"Client addresses and subjects that recently failed to authenticate and are delayed by the tarpit middleware or raised an alert.",
+		"state",
+		map[string]float64{"delayed": float64(delayed), "alerted": float64(alerted)},
+	)
+
+	upstreamHealth.writeMetrics(c.Writer)
+}
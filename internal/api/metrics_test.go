@@ -0,0 +1,49 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestGetAdminMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	conf := config.Config{
+		HostGroups: []config.HostGroup{
+			{Name: "token-bound", CertDuration: 0},
+			{Name: "fixed", CertDuration: 3600},
+		},
+	}
+
+	now := time.Now()
+	store := storage.NewMemoryStore()
+	store.AddCertificate(storage.Certificate{Serial: 1, HostGroup: "token-bound", IssuedAt: now, ValidAfter: now.Add(-time.Minute), ValidBefore: now.Add(time.Minute)})
+	store.AddCertificate(storage.Certificate{Serial: 2, HostGroup: "token-bound", IssuedAt: now, ValidAfter: now.Add(-time.Minute), ValidBefore: now.Add(time.Hour)})
+	store.AddCertificate(storage.Certificate{Serial: 3, HostGroup: "fixed", IssuedAt: now, ValidAfter: now.Add(-time.Minute), ValidBefore: now.Add(time.Minute)})
+
+	observeValidity(config.HostInfo{HostGroup: "fixed", CertDuration: 3600}, ssh.Certificate{ValidBefore: uint64(now.Add(time.Hour).Unix())})
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("config", conf)
+		c.Set("store", storage.Store(store))
+	})
+	router.GET("/metrics", GetAdminMetrics)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `oinit_ca_certificate_validity_seconds_bucket{hostgroup="fixed",le="3600"} 1`)
+	assert.Contains(t, w.Body.String(), `oinit_ca_certificates_near_expiry{hostgroup="token-bound"} 1`)
+	assert.NotContains(t, w.Body.String(), `oinit_ca_certificates_near_expiry{hostgroup="fixed"}`)
+}
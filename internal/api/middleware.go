@@ -0,0 +1,184 @@
+package api
+
+import (
+	"compress/gzip"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	ERR_RATE_LIMITED = "rate_limited"
+
+	// Seconds browsers may cache the response to a preflight request
+	CORS_MAX_AGE = 600
+)
+
+// rateLimiter is a token bucket per client. Buckets hold up to a minute of
+// requests, so clients may send them in bursts.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // tokens per second
+	burst   float64
+	buckets map[string]*bucket
+	pruned  time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token from the bucket of the client. If it is empty, the
+// time until the next token is returned.
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Full buckets are dropped, they are the same as new ones
+	if now.Sub(l.pruned) > time.Minute {
+		for key, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+				delete(l.buckets, key)
+			}
+		}
+		l.pruned = now
+	}
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+
+	b.tokens--
+
+	return true, 0
+}
+
+// RateLimit returns a middleware that limits each client address to the
+// number of requests per minute. Further requests are rejected with 429 Too
+// Many Requests and a Retry-After header.
+func RateLimit(perMinute int) gin.HandlerFunc {
+	limiter := &rateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(perMinute),
+		buckets: make(map[string]*bucket),
+	}
+
+	return func(c *gin.Context) {
+		if ok, wait := limiter.allow(c.ClientIP(), time.Now()); !ok {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			Error(c, http.StatusTooManyRequests, ERR_RATE_LIMITED)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// CORS returns a middleware that allows browsers to make cross-origin
+// requests from the origins, or from any origin if they contain "*".
+// Preflight requests are answered with 204 No Content. Credentials are not
+// allowed, as the API authenticates with bearer tokens.
+func CORS(origins []string) gin.HandlerFunc {
+	anyOrigin := slices.Contains(origins, "*")
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		c.Writer.Header().Add("Vary", "Origin")
+
+		// Browsers block the response without the headers
+		if !anyOrigin && !slices.Contains(origins, origin) {
+			c.Next()
+			return
+		}
+
+		c.Header("Access-Control-Allow-Origin", origin)
+		c.Header("Access-Control-Expose-Headers", strings.Join([]string{HEADER_REQUEST_ID, "Retry-After", "Location"}, ", "))
+
+		if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+			c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE")
+			c.Header("Access-Control-Allow-Headers", "Authorization, Content-Type, Accept-Language, Idempotency-Key, Prefer")
+			c.Header("Access-Control-Max-Age", strconv.Itoa(CORS_MAX_AGE))
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// gzipWriter compresses the response body. Compression starts with the
+// first write, so responses without a body are sent as is.
+type gzipWriter struct {
+	gin.ResponseWriter
+	gz *gzip.Writer
+}
+
+func (w *gzipWriter) Write(data []byte) (int, error) {
+	if w.gz == nil {
+		if w.Header().Get("Content-Encoding") != "" {
+			return w.ResponseWriter.Write(data)
+		}
+
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Del("Content-Length")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+
+	return w.gz.Write(data)
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *gzipWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+
+	w.ResponseWriter.Flush()
+}
+
+// Gzip returns a middleware that compresses responses for clients that
+// accept gzip.
+func Gzip() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+
+		if !strings.Contains(c.GetHeader("Accept-Encoding"), "gzip") {
+			c.Next()
+			return
+		}
+
+		w := &gzipWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+
+		c.Next()
+
+		if w.gz != nil {
+			w.gz.Close()
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/i18n"
+	"github.com/lbrocke/oinit/internal/notify"
+	"github.com/lbrocke/oinit/internal/oidc"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// Prefixes of the keys that known subjects, IP addresses and public keys
+	// are recorded with, see Store.MarkSeen
+	NOTIFY_SEEN_SUBJECT = "notify-subject:"
+	NOTIFY_SEEN_IP      = "notify-ip:"
+	NOTIFY_SEEN_KEY     = "notify-key:"
+
+	MSG_NOTIFY_SUBJECT = "notify_subject"
+	MSG_NOTIFY_BODY    = "notify_body"
+	MSG_NOTIFY_NEW_IP  = "notify_new_ip"
+	MSG_NOTIFY_NEW_KEY = "notify_new_key"
+)
+
+// issuanceChanges returns the reasons to notify the subject of an issuance,
+// which are a new IP address or a new public key. The first issuance of a
+// subject only records the IP address and key, as everything would be new.
+func issuanceChanges(store storage.Store, lang, subject, ip, fingerprint string, window time.Duration) ([]string, error) {
+	known, err := store.MarkSeen(NOTIFY_SEEN_SUBJECT+subject, subject, window)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []string
+
+	if previous, err := store.MarkSeen(NOTIFY_SEEN_IP+subject+" "+ip, ip, window); err != nil {
+		return nil, err
+	} else if previous == "" && known != "" {
+		changes = append(changes, i18n.Translate(lang, MSG_NOTIFY_NEW_IP, ip))
+	}
+
+	if previous, err := store.MarkSeen(NOTIFY_SEEN_KEY+subject+" "+fingerprint, fingerprint, window); err != nil {
+		return nil, err
+	} else if previous == "" && known != "" {
+		changes = append(changes, i18n.Translate(lang, MSG_NOTIFY_NEW_KEY, fingerprint))
+	}
+
+	return changes, nil
+}
+
+// notifyIssuance notifies the owner of the access token if the certificate
+// was issued from a new IP address or for a new public key, as a tripwire
+// for compromised accounts. Messages are sent in the background, failures
+// are only logged.
+func notifyIssuance(conf config.Config, store storage.Store, lang string, token *jwt.Token, accessToken, ip, host, username string, cert ssh.Certificate) {
+	subject := tokenSubject(token)
+	if len(conf.Notifiers) == 0 || subject == "" {
+		return
+	}
+
+	window := time.Duration(conf.Server.NotifyWindow) * time.Second
+
+	changes, err := issuanceChanges(store, lang, subject, ip, ssh.FingerprintSHA256(cert.Key), window)
+	if err != nil {
+		Logger.Printf("Could not check certificate %d for notification: %s", cert.Serial, err)
+		return
+	}
+
+	if len(changes) == 0 {
+		return
+	}
+
+	msg := notify.Message{
+		Subject: i18n.Translate(lang, MSG_NOTIFY_SUBJECT, host),
+		Body: i18n.Translate(lang, MSG_NOTIFY_BODY, host, username, subject, strings.Join(changes, "\n"),
+			cert.Serial, time.Unix(int64(cert.ValidBefore), 0).UTC().Format(time.RFC1123)),
+	}
+
+	go func() {
+		var email string
+		if claims, ok := token.Claims.(jwt.MapClaims); ok {
+			email, _ = claims["email"].(string)
+		}
+
+		// Access tokens often lack the email claim, it is then requested
+		// from the userinfo endpoint.
+		if email == "" && conf.NotifyEmail {
+			if issuer, err := token.Claims.GetIssuer(); err == nil {
+				if claims, err := oidc.UserInfo(context.Background(), issuer, accessToken); err == nil {
+					email, _ = claims["email"].(string)
+				}
+			}
+		}
+
+		for _, notifier := range conf.Notifiers {
+			if err := notifier.Notify(email, msg); err != nil {
+				Logger.Printf("Could not notify %s of certificate %d: %s", subject, cert.Serial, err)
+			}
+		}
+	}()
+}
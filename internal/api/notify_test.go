@@ -0,0 +1,83 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/notify"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+type testNotifier chan string
+
+func (n testNotifier) Notify(recipient string, msg notify.Message) error {
+	n <- recipient + ": " + msg.Subject
+
+	return nil
+}
+
+func newTestSigner() ssh.Signer {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	signer, _ := ssh.NewSignerFromKey(priv)
+
+	return signer
+}
+
+func TestIssuanceChanges(t *testing.T) {
+	store := storage.NewMemoryStore()
+
+	// Everything is new on the first issuance, which is not reported.
+	changes, err := issuanceChanges(store, "en", "alice", "192.0.2.1", "SHA256:a", time.Hour)
+	assert.NoError(t, err)
+	assert.Empty(t, changes)
+
+	changes, err = issuanceChanges(store, "en", "alice", "192.0.2.1", "SHA256:a", time.Hour)
+	assert.NoError(t, err)
+	assert.Empty(t, changes)
+
+	changes, err = issuanceChanges(store, "en", "alice", "192.0.2.2", "SHA256:b", time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"It was requested from a new IP address: 192.0.2.2",
+		"It was issued for a new public key: SHA256:b",
+	}, changes)
+
+	// Other subjects are tracked separately.
+	changes, err = issuanceChanges(store, "en", "bob", "192.0.2.3", "SHA256:c", time.Hour)
+	assert.NoError(t, err)
+	assert.Empty(t, changes)
+}
+
+func TestNotifyIssuance(t *testing.T) {
+	store := storage.NewMemoryStore()
+	notifier := make(testNotifier, 1)
+	conf := config.Config{Notifiers: []notify.Notifier{notifier}}
+	conf.Server.NotifyWindow = 3600
+
+	signer := newTestSigner()
+	cert := ssh.Certificate{Key: signer.PublicKey(), Serial: 1}
+	token := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{
+		"sub":   "alice",
+		"iss":   "https://op.example.com",
+		"email": "alice@example.com",
+	})
+
+	notifyIssuance(conf, store, "en", token, "", "192.0.2.1", "login.example.com", "alice", cert)
+	notifyIssuance(conf, store, "en", token, "", "192.0.2.2", "login.example.com", "alice", cert)
+
+	select {
+	case msg := <-notifier:
+		assert.Equal(t, "alice@example.com: New SSH certificate for login.example.com", msg)
+	case <-time.After(time.Second):
+		t.Error("no notification sent")
+	}
+
+	assert.Empty(t, notifier)
+}
@@ -0,0 +1,201 @@
+package api
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/approved"
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/forcecmd"
+	"github.com/lbrocke/oinit/internal/sshversion"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	AUDIT_OFFLINE_SIGN = "offline-sign"
+
+	ERR_POLICY_VIOLATION = "request violates the policy of the host"
+)
+
+var ErrPolicyViolation = errors.New(ERR_POLICY_VIOLATION)
+
+// OfflineRequest describes a certificate that is signed without the REST API
+// and motley_cue, e.g. by "oinit-ca sign" while either of them is down.
+type OfflineRequest struct {
+	Host      string
+	PublicKey ssh.PublicKey
+	// Accounts the certificate permits logins as, the first of which the
+	// oinit user switches to
+	Principals []string
+	// Validity in seconds, 0 = the cert-validity of the hostgroup
+	Validity int
+	// Who requested the certificate and why, recorded in the audit trail
+	Actor  string
+	Reason string
+}
+
+// SignOffline signs a user certificate for the request, after checking it
+// against the policy of the host: the host must not be delegated or frozen,
+// the key must be accepted, and the validity must not exceed the
+// cert-validity of the hostgroup. Policies that apply to OIDC subjects, such
+// as quotas and subject lists, don't apply. The certificate is recorded and
+// audited like those issued by the REST API, with the actor as subject, and
+// an additional audit event records the reason.
+func SignOffline(conf config.Config, store storage.Store, req OfflineRequest) (ssh.Certificate, error) {
+	return signOffline(conf, store, req, AUDIT_OFFLINE_SIGN, nil)
+}
+
+// signOffline implements SignOffline, adding the given extensions to the
+// certificate and auditing the request with the given action.
+func signOffline(conf config.Config, store storage.Store, req OfflineRequest, action string, extensions map[string]string) (ssh.Certificate, error) {
+	host := strings.ToLower(req.Host)
+
+	info, err := conf.GetInfo(host)
+	if err != nil {
+		return ssh.Certificate{}, err
+	}
+
+	if err := checkOfflineRequest(conf, store, info, req); err != nil {
+		return ssh.Certificate{}, err
+	}
+
+	validity := req.Validity
+	if validity == 0 {
+		validity = info.CertDuration
+	}
+
+	cert := generateUserCertificate(host, req.PublicKey, req.Principals, uint64(validity), uint64(conf.Server.ClockSkewTolerance), info.Extensions)
+
+	if info.HostPrincipals {
+		cert.ValidPrincipals = hostPrincipals(cert.ValidPrincipals, host)
+	}
+
+	forceCommand, err := info.ForceCommand.Render(map[string]string{
+		forcecmd.VAR_USERNAMES: strings.Join(req.Principals, ","),
+		forcecmd.VAR_USERNAME:  req.Principals[0],
+		forcecmd.VAR_HOST:      host,
+	})
+	if err != nil {
+		return ssh.Certificate{}, fmt.Errorf("could not create force-command: %w", err)
+	}
+
+	if info.ForceCommandKey != nil {
+		arg, err := forcecmd.SignArgument(info.ForceCommandKey, FORCE_COMMAND, strings.Join(req.Principals, ","), forcecmd.Payload{
+			Host:      host,
+			IssuedAt:  int64(cert.ValidAfter),
+			ExpiresAt: int64(cert.ValidBefore),
+		})
+		if err != nil {
+			return ssh.Certificate{}, fmt.Errorf("could not create force-command: %w", err)
+		}
+
+		forceCommand += " " + arg
+	}
+
+	cert.CriticalOptions["force-command"] = forceCommand
+
+	for name, value := range extensions {
+		cert.Extensions[name] = value
+	}
+
+	// The OpenSSH version of the host is not probed, as it may not be
+	// reachable either
+	signer, err := certSigner(conf, info, sshversion.Version{}, false)
+	if err != nil {
+		return ssh.Certificate{}, err
+	}
+
+	if cert.Serial, err = store.NextSerial(); err != nil {
+		return ssh.Certificate{}, err
+	}
+
+	if err := cert.SignCert(rand.Reader, signer); err != nil {
+		return ssh.Certificate{}, err
+	}
+
+	if err := recordCertificate(store, cert, host, info.HostGroup, req.Actor, req.Principals[0], nil); err != nil {
+		return ssh.Certificate{}, err
+	}
+
+	store.AddAuditEvent(storage.AuditEvent{
+		Time:   time.Now(),
+		Action: action,
+		Actor:  req.Actor,
+		Details: map[string]string{
+			"serial":      strconv.FormatUint(cert.Serial, 10),
+			"host":        host,
+			"principals":  strings.Join(req.Principals, ","),
+			"fingerprint": ssh.FingerprintSHA256(req.PublicKey),
+			"validity":    strconv.Itoa(validity),
+			"reason":      req.Reason,
+		},
+	})
+
+	return cert, nil
+}
+
+// checkOfflineRequest returns an error wrapping ErrPolicyViolation if the
+// request is not permitted by the policy of the host.
+func checkOfflineRequest(conf config.Config, store storage.Store, info config.HostInfo, req OfflineRequest) error {
+	if info.Delegate != "" {
+		return fmt.Errorf("%w: host is delegated to %s", ErrPolicyViolation, info.Delegate)
+	}
+
+	freeze, err := issuanceFreeze(store, info.HostGroup, info.FreezeWindows, info.FreezeMessage, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if freeze.Frozen {
+		return fmt.Errorf("%w: %s", ErrPolicyViolation, freeze.describe())
+	}
+
+	if req.PublicKey == nil {
+		return fmt.Errorf("%w: no public key", ErrPolicyViolation)
+	}
+
+	if conf.Server.ApprovedAlgorithms {
+		if err := approved.PublicKey(req.PublicKey); err != nil {
+			return fmt.Errorf("%w: %s", ErrPolicyViolation, err)
+		}
+	}
+
+	if len(info.KeyTypes) != 0 && !slices.Contains(info.KeyTypes, req.PublicKey.Type()) {
+		return fmt.Errorf("%w: key type %s is not accepted by host group %s", ErrPolicyViolation, req.PublicKey.Type(), info.HostGroup)
+	}
+
+	if bits := rsaBits(req.PublicKey); bits != 0 && bits < info.MinRSABits {
+		return fmt.Errorf("%w: rsa key has %d < %d bits", ErrPolicyViolation, bits, info.MinRSABits)
+	}
+
+	if len(req.Principals) == 0 || len(req.Principals) > MAX_PRINCIPALS {
+		return fmt.Errorf("%w: between 1 and %d principals are required", ErrPolicyViolation, MAX_PRINCIPALS)
+	}
+
+	for i, principal := range req.Principals {
+		if principal == "" || principal == PRINCIPAL || strings.ContainsAny(principal, ", \t\r\n") ||
+			slices.Contains(req.Principals[:i], principal) {
+			return fmt.Errorf("%w: invalid principal %q", ErrPolicyViolation, principal)
+		}
+	}
+
+	// Certificates valid until the token expires can't be issued without a
+	// token, so the validity must be given
+	switch {
+	case req.Validity < 0:
+		return fmt.Errorf("%w: negative validity", ErrPolicyViolation)
+	case req.Validity == 0 && info.CertDuration <= 0:
+		return fmt.Errorf("%w: host group %s issues certificates valid until the token expires, a validity is required", ErrPolicyViolation, info.HostGroup)
+	case info.CertDuration > 0 && req.Validity > info.CertDuration:
+		return fmt.Errorf("%w: validity exceeds %d seconds", ErrPolicyViolation, info.CertDuration)
+	}
+
+	return nil
+}
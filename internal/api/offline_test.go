@@ -0,0 +1,90 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSignOffline(t *testing.T) {
+	_, caKey, _ := ed25519.GenerateKey(rand.Reader)
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	pubkey, _ := ssh.NewPublicKey(pub)
+
+	conf := config.Config{
+		HostGroups: []config.HostGroup{{
+			DefaultOptions: config.DefaultOptions{HostPrincipals: true},
+			Keys:           config.Keys{UserCAPrivateKey: caKey},
+			CertDuration:   3600,
+			Name:           "example.com",
+			Hosts:          map[string]string{"example.com": "https://login.example.com"},
+		}, {
+			DefaultOptions: config.DefaultOptions{Delegate: "https://ca.example.org"},
+			Name:           "example.org",
+			Hosts:          map[string]string{"example.org": "https://login.example.org"},
+		}, {
+			Keys:  config.Keys{UserCAPrivateKey: caKey},
+			Name:  "example.net",
+			Hosts: map[string]string{"example.net": "https://login.example.net"},
+		}},
+	}
+
+	store := storage.NewMemoryStore()
+
+	req := OfflineRequest{
+		Host:       "Example.com",
+		PublicKey:  pubkey,
+		Principals: []string{"alice", "admin"},
+		Actor:      "oinit-ca sign (root)",
+		Reason:     "motley_cue is down",
+	}
+
+	cert, err := SignOffline(conf, store, req)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"oinit@example.com", "alice@example.com", "admin@example.com"}, cert.ValidPrincipals)
+	assert.Equal(t, FORCE_COMMAND+" alice,admin", cert.CriticalOptions["force-command"])
+	assert.Equal(t, uint64(3600), cert.ValidBefore-cert.ValidAfter)
+
+	stored, err := store.GetCertificate(cert.Serial)
+	assert.NoError(t, err)
+	assert.Equal(t, "oinit-ca sign (root)", stored.Subject)
+	assert.Equal(t, "alice", stored.Username)
+
+	events, _ := store.ListAuditEvents(time.Time{})
+	assert.Len(t, events, 2)
+	assert.Equal(t, AUDIT_OFFLINE_SIGN, events[1].Action)
+	assert.Equal(t, "motley_cue is down", events[1].Details["reason"])
+
+	for _, modify := range []func(*OfflineRequest){
+		func(r *OfflineRequest) { r.Validity = 7200 },
+		func(r *OfflineRequest) { r.Principals = nil },
+		func(r *OfflineRequest) { r.Principals = []string{"alice", "alice"} },
+		func(r *OfflineRequest) { r.Principals = []string{PRINCIPAL} },
+		func(r *OfflineRequest) { r.Host = "example.org" },
+		// Certificates are valid until the token expires
+		func(r *OfflineRequest) { r.Host = "example.net" },
+	} {
+		denied := req
+		modify(&denied)
+
+		_, err := SignOffline(conf, store, denied)
+		assert.True(t, errors.Is(err, ErrPolicyViolation), err)
+	}
+
+	req.Host = "example.net"
+	req.Validity = 600
+	_, err = SignOffline(conf, store, req)
+	assert.NoError(t, err)
+
+	req.Host = "unknown.example.com"
+	_, err = SignOffline(conf, store, req)
+	assert.True(t, errors.Is(err, config.ErrHostNotFound))
+}
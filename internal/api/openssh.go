@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/approved"
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/sshversion"
+	"github.com/lbrocke/oinit/internal/util"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	MSG_KEY_UNSUPPORTED_BY_HOST = "key_unsupported_by_host"
+
+	ERR_CA_UNSUPPORTED_BY_HOST = "CA key is not supported by the OpenSSH version of the host"
+)
+
+// probedVersions remembers the OpenSSH versions read from hosts.
+var probedVersions = util.NewTimedCache[string, sshversion.Version]()
+
+// hostVersion returns the OpenSSH version of the host, which is either
+// configured or probed. The second value is false if the version is unknown,
+// in which case no restrictions apply.
+func hostVersion(ctx context.Context, info config.HostInfo, host string) (sshversion.Version, bool) {
+	switch info.OpenSSHVersion {
+	case "":
+		return sshversion.Version{}, false
+	case config.OPENSSH_VERSION_PROBE:
+		if version, ok := probedVersions.Get(host); ok {
+			return version, true
+		}
+
+		version, err := sshversion.Probe(ctx, host)
+		if err != nil {
+			Logger.Printf("Could not probe OpenSSH version of %s: %s", host, err)
+			return sshversion.Version{}, false
+		}
+
+		probedVersions.Set(host, version, time.Duration(info.CacheDuration))
+
+		return version, true
+	default:
+		// Validated when loading the config
+		version, err := sshversion.Parse(info.OpenSSHVersion)
+
+		return version, err == nil
+	}
+}
+
+// certSigner returns a signer for the user CA key of the host. If the OpenSSH
+// version of the host is known, RSA CA keys sign with the algorithm the host
+// validates.
+func certSigner(conf config.Config, info config.HostInfo, version sshversion.Version, known bool) (ssh.Signer, error) {
+	var signer ssh.Signer
+	var err error
+
+	if conf.Server.ApprovedAlgorithms {
+		signer, err = approved.Signer(info.UserCAPrivateKey)
+	} else {
+		signer, err = ssh.NewSignerFromKey(info.UserCAPrivateKey)
+	}
+
+	if err != nil || !known {
+		return signer, err
+	}
+
+	if !version.SupportsKeyType(signer.PublicKey().Type()) {
+		return nil, errors.New(ERR_CA_UNSUPPORTED_BY_HOST)
+	}
+
+	if signer.PublicKey().Type() != ssh.KeyAlgoRSA {
+		return signer, nil
+	}
+
+	algorithmSigner, ok := signer.(ssh.AlgorithmSigner)
+	if !ok {
+		return nil, errors.New(ERR_CA_UNSUPPORTED_BY_HOST)
+	}
+
+	// Fails if the algorithm is not approved
+	return ssh.NewSignerWithAlgorithms(algorithmSigner, []string{version.RSASignatureAlgorithm()})
+}
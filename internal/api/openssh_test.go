@@ -0,0 +1,51 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/lbrocke/oinit/internal/approved"
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/sshversion"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCertSigner(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, approved.MIN_RSA_BITS)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	var conf config.Config
+	var info config.HostInfo
+
+	signatureFormat := func(version sshversion.Version) string {
+		signer, err := certSigner(conf, info, version, true)
+		assert.NoError(t, err)
+
+		cert := ssh.Certificate{Key: signer.PublicKey(), CertType: ssh.UserCert}
+		assert.NoError(t, cert.SignCert(rand.Reader, signer))
+
+		return cert.Signature.Format
+	}
+
+	info.UserCAPrivateKey = rsaKey
+	assert.Equal(t, ssh.KeyAlgoRSA, signatureFormat(sshversion.Version{Major: 7, Minor: 1}))
+	assert.Equal(t, ssh.KeyAlgoRSASHA512, signatureFormat(sshversion.Version{Major: 8, Minor: 9}))
+
+	// SHA-1 is not approved
+	conf.Server.ApprovedAlgorithms = true
+	_, err := certSigner(conf, info, sshversion.Version{Major: 7, Minor: 1}, true)
+	assert.Error(t, err)
+
+	conf.Server.ApprovedAlgorithms = false
+	info.UserCAPrivateKey = edKey
+	_, err = certSigner(conf, info, sshversion.Version{Major: 6, Minor: 4}, true)
+	assert.EqualError(t, err, ERR_CA_UNSUPPORTED_BY_HOST)
+
+	// Without a known version, no restrictions apply
+	_, err = certSigner(conf, info, sshversion.Version{}, false)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/storage"
+)
+
+const (
+	// Actions of audit events of CA operations. Revocations are recorded as
+	// AUDIT_REVOKE by Revoke.
+	AUDIT_CONFIG       = "config"
+	AUDIT_KEY_ROTATION = "key-rotation"
+
+	// Prefix of CA key fingerprints in config snapshots
+	SNAPSHOT_KEYS = "keys."
+)
+
+// lastSnapshot returns the config snapshot recorded at the previous start, or
+// nil if there is none. Stores written by earlier versions only have it in
+// the most recent AUDIT_CONFIG event.
+func lastSnapshot(store storage.Store) (map[string]string, error) {
+	snapshot, err := store.GetConfigSnapshot()
+	if err == nil {
+		return snapshot, nil
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+
+	events, err := store.ListAuditEvents(time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(events) - 1; i >= 0; i-- {
+		if events[i].Action != AUDIT_CONFIG || events[i].Details["snapshot"] == "" {
+			continue
+		}
+
+		var snapshot map[string]string
+		if err := json.Unmarshal([]byte(events[i].Details["snapshot"]), &snapshot); err != nil {
+			return nil, err
+		}
+
+		return snapshot, nil
+	}
+
+	return nil, nil
+}
+
+// marshalSnapshot returns the JSON representation of a (partial) snapshot.
+func marshalSnapshot(snapshot map[string]string) string {
+	data, _ := json.Marshal(snapshot)
+
+	return string(data)
+}
+
+// RecordConfig compares the configuration with the snapshot recorded at the
+// previous start and adds audit events for the changes: an AUDIT_KEY_ROTATION
+// event for each changed CA key and an AUDIT_CONFIG event with the previous
+// and new values of all other changed options, which also carries the full
+// snapshot. Nothing is recorded if the configuration is unchanged. The
+// snapshot for the next comparison is kept in the store separately, so it
+// outlives the audit retention. Secrets are masked as in 'oinit-ca config'.
+func RecordConfig(store storage.Store, conf config.Config, actor string) error {
+	previous, err := lastSnapshot(store)
+	if err != nil {
+		return err
+	}
+
+	current := conf.Snapshot()
+	now := time.Now()
+
+	if previous == nil {
+		if err := store.AddAuditEvent(storage.AuditEvent{
+			Time:   now,
+			Action: AUDIT_CONFIG,
+			Actor:  actor,
+			Details: map[string]string{
+				"snapshot": marshalSnapshot(current),
+			},
+		}); err != nil {
+			return err
+		}
+
+		return store.SetConfigSnapshot(current)
+	}
+
+	before := make(map[string]string)
+	after := make(map[string]string)
+
+	for key, value := range current {
+		if old, ok := previous[key]; !ok || old != value {
+			before[key], after[key] = previous[key], value
+		}
+	}
+
+	for key, old := range previous {
+		if _, ok := current[key]; !ok {
+			before[key], after[key] = old, ""
+		}
+	}
+
+	if len(after) == 0 {
+		// Moves snapshots of earlier versions out of the audit trail
+		return store.SetConfigSnapshot(current)
+	}
+
+	for key := range after {
+		name, found := strings.CutPrefix(key, SNAPSHOT_KEYS)
+		if !found {
+			continue
+		}
+
+		// name is <hostgroup>.<ca>, hostgroup names may contain dots
+		dot := strings.LastIndex(name, ".")
+
+		if err := store.AddAuditEvent(storage.AuditEvent{
+			Time:   now,
+			Action: AUDIT_KEY_ROTATION,
+			Actor:  actor,
+			Details: map[string]string{
+				"hostgroup": name[:dot],
+				"ca":        name[dot+1:],
+				"before":    before[key],
+				"after":     after[key],
+			},
+		}); err != nil {
+			return err
+		}
+
+		delete(before, key)
+		delete(after, key)
+	}
+
+	// Also recorded if only keys changed, so the audit trail has the full
+	// snapshot after each change.
+	if err := store.AddAuditEvent(storage.AuditEvent{
+		Time:   now,
+		Action: AUDIT_CONFIG,
+		Actor:  actor,
+		Details: map[string]string{
+			"before":   marshalSnapshot(before),
+			"after":    marshalSnapshot(after),
+			"snapshot": marshalSnapshot(current),
+		},
+	}); err != nil {
+		return err
+	}
+
+	return store.SetConfigSnapshot(current)
+}
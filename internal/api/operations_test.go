@@ -0,0 +1,90 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func newPublicKey() ssh.PublicKey {
+	pk, _, _ := ed25519.GenerateKey(nil)
+	pubkey, _ := ssh.NewPublicKey(pk)
+
+	return pubkey
+}
+
+func TestRecordConfig(t *testing.T) {
+	store := storage.NewMemoryStore()
+	conf := config.Config{
+		HostGroups: []config.HostGroup{{
+			Name:           "example.com",
+			DefaultOptions: config.DefaultOptions{CertValidity: "3600"},
+			Keys:           config.Keys{UserCAPublicKey: newPublicKey()},
+		}},
+	}
+
+	assert.NoError(t, RecordConfig(store, conf, "test"))
+	// Unchanged configs are not recorded again
+	assert.NoError(t, RecordConfig(store, conf, "test"))
+
+	events, _ := store.ListAuditEvents(time.Time{})
+	assert.Len(t, events, 1)
+	assert.Equal(t, AUDIT_CONFIG, events[0].Action)
+	assert.NotEmpty(t, events[0].Details["snapshot"])
+
+	conf.HostGroups[0].CertValidity = "7200"
+	conf.HostGroups[0].UserCAPublicKey = newPublicKey()
+
+	assert.NoError(t, RecordConfig(store, conf, "test"))
+
+	events, _ = store.ListAuditEvents(time.Time{})
+	assert.Len(t, events, 3)
+	assert.Equal(t, AUDIT_KEY_ROTATION, events[1].Action)
+	assert.Equal(t, "example.com", events[1].Details["hostgroup"])
+	assert.Equal(t, "user-ca", events[1].Details["ca"])
+	assert.NotEqual(t, events[1].Details["before"], events[1].Details["after"])
+
+	var before, after map[string]string
+	assert.NoError(t, json.Unmarshal([]byte(events[2].Details["before"]), &before))
+	assert.NoError(t, json.Unmarshal([]byte(events[2].Details["after"]), &after))
+	assert.Equal(t, map[string]string{"hostgroups.example.com.options.cert-validity": "3600"}, before)
+	assert.Equal(t, map[string]string{"hostgroups.example.com.options.cert-validity": "7200"}, after)
+}
+
+func TestRecordConfigPruned(t *testing.T) {
+	// Audit events are pruned quickly
+	store := storage.NewMemoryStore(storage.WithAuditRetention(50 * time.Millisecond))
+	conf := config.Config{
+		HostGroups: []config.HostGroup{{
+			Name:           "example.com",
+			DefaultOptions: config.DefaultOptions{CertValidity: "3600"},
+			Keys:           config.Keys{UserCAPublicKey: newPublicKey()},
+		}},
+	}
+
+	assert.NoError(t, RecordConfig(store, conf, "test"))
+
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, store.AddAuditEvent(storage.AuditEvent{Time: time.Now(), Action: AUDIT_REPLAY}))
+
+	events, _ := store.ListAuditEvents(time.Time{})
+	assert.Len(t, events, 1, "the initial snapshot event was pruned")
+
+	// The change is still compared with the previous snapshot
+	conf.HostGroups[0].CertValidity = "7200"
+
+	since := time.Now()
+	assert.NoError(t, RecordConfig(store, conf, "test"))
+
+	events, _ = store.ListAuditEvents(since)
+	assert.Len(t, events, 1)
+	assert.Equal(t, AUDIT_CONFIG, events[0].Action)
+	assert.Equal(t, `{"hostgroups.example.com.options.cert-validity":"3600"}`, events[0].Details["before"])
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/lbrocke/oinit/internal/config"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/ssh"
+)
+
+// ApiResponsePeer is returned along with 307 Temporary Redirect for hosts
+// served by a peer CA.
+type ApiResponsePeer struct {
+	Name string `json:"name" example:"other"`
+	// Base URL of the peer CA, the Location header points to the endpoint
+	// of the host there
+	URL string `json:"url" example:"https://ca.other.example.org"`
+	// Host CA key of the peer, as configured on this CA
+	PublicKey string `json:"publickey"`
+}
+
+// redirectToPeer redirects the request to the endpoint (such as
+// "/certificate") of the host at the peer CA serving it. It returns false
+// without responding if no peer serves the host.
+func redirectToPeer(c *gin.Context, conf config.Config, host, endpoint string) (config.Peer, bool) {
+	peer, ok := conf.Peer(host)
+	if !ok {
+		return peer, false
+	}
+
+	c.Header("Location", hostURL(peer.URL, host, endpoint, c.Request.URL.RawQuery))
+	c.JSON(http.StatusTemporaryRedirect, ApiResponsePeer{
+		Name:      peer.Name,
+		URL:       peer.URL,
+		PublicKey: strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(peer.HostCAPublicKey)), "\n"),
+	})
+
+	return peer, true
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lbrocke/oinit/internal/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestRedirectToPeer(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	pubkey, _ := ssh.NewPublicKey(pub)
+
+	conf := config.Config{
+		Peers: []config.Peer{
+			{
+				Name:            "other",
+				URL:             "https://ca.other.example.org",
+				HostCAPublicKey: pubkey,
+				HostPatterns:    []string{"*.other.example.org"},
+			},
+		},
+	}
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("config", conf) })
+	router.GET("/:host", GetHost)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/login.other.example.org", nil))
+
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	assert.Equal(t, "https://ca.other.example.org/api/v1/login.other.example.org", w.Header().Get("Location"))
+
+	var res ApiResponsePeer
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, "other", res.Name)
+	assert.Equal(t, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pubkey))), res.PublicKey)
+
+	// Hosts that no peer serves remain unknown
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/login.example.org", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Peer CAs are published in the trust bundle
+	bundle := trustBundle(conf)
+	assert.Len(t, bundle, 1)
+	assert.True(t, strings.HasPrefix(bundle[0], "@cert-authority *.other.example.org "))
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/storage"
+)
+
+const (
+	ERR_QUOTA_EXCEEDED = "quota_exceeded"
+
+	// Actor of revocations caused by the revoke-oldest quota action
+	AUDIT_ACTOR_QUOTA = "quota"
+)
+
+var ErrQuotaExceeded = errors.New(ERR_QUOTA_EXCEEDED)
+
+// activeCertificates returns the unexpired and unrevoked certificates of the
+// subject in the hostgroup, ordered by serial (and thus age).
+func activeCertificates(store storage.Store, subject, hostGroup string) ([]storage.Certificate, error) {
+	certs, err := store.ListCertificates(storage.CertificateFilter{
+		Subject:   subject,
+		HostGroup: hostGroup,
+		ValidAt:   time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	revocations, err := store.ListRevocations()
+	if err != nil {
+		return nil, err
+	}
+
+	revoked := make(map[uint64]bool)
+	for _, revocation := range revocations {
+		revoked[revocation.Serial] = true
+	}
+
+	var active []storage.Certificate
+	for _, cert := range certs {
+		if !revoked[cert.Serial] {
+			active = append(active, cert)
+		}
+	}
+
+	return active, nil
+}
+
+// quotaLock serializes quota checks per subject and hostgroup.
+type quotaLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+var (
+	quotaLocksMu sync.Mutex
+	quotaLocks   = make(map[string]*quotaLock)
+)
+
+// lockQuota locks the quota of the subject in the hostgroup of info and
+// returns the function to unlock it. The lock must be held from checkQuota
+// until the new certificate is recorded, otherwise concurrent requests can
+// all pass the quota.
+func lockQuota(info config.HostInfo, subject string) func() {
+	if info.MaxCertificates == 0 || subject == "" {
+		return func() {}
+	}
+
+	key := subject + " " + info.HostGroup
+
+	quotaLocksMu.Lock()
+	lock, ok := quotaLocks[key]
+	if !ok {
+		lock = &quotaLock{}
+		quotaLocks[key] = lock
+	}
+	lock.refs++
+	quotaLocksMu.Unlock()
+
+	lock.mu.Lock()
+
+	return func() {
+		lock.mu.Unlock()
+
+		quotaLocksMu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(quotaLocks, key)
+		}
+		quotaLocksMu.Unlock()
+	}
+}
+
+// checkQuota checks whether the subject may get another certificate in the
+// hostgroup of info. Depending on the quota action, ErrQuotaExceeded is
+// returned or the oldest certificates that have to make room are. These are
+// only to be revoked by supersede once the new certificate is recorded.
+func checkQuota(store storage.Store, info config.HostInfo, subject string) ([]storage.Certificate, error) {
+	// Without a subject, certificates can't be attributed.
+	if info.MaxCertificates == 0 || subject == "" {
+		return nil, nil
+	}
+
+	active, err := activeCertificates(store, subject, info.HostGroup)
+	if err != nil {
+		return nil, err
+	}
+
+	excess := len(active) - info.MaxCertificates + 1
+	if excess <= 0 {
+		return nil, nil
+	}
+
+	if info.QuotaAction != config.QUOTA_REVOKE_OLDEST {
+		return nil, fmt.Errorf("%w: %d of %d certificates in %s", ErrQuotaExceeded, len(active), info.MaxCertificates, info.HostGroup)
+	}
+
+	return active[:excess], nil
+}
+
+// supersede revokes the certificates returned by checkQuota.
+func supersede(store storage.Store, certs []storage.Certificate) error {
+	for _, cert := range certs {
+		if _, err := Revoke(store, cert.Serial, AUDIT_ACTOR_QUOTA, storage.REASON_SUPERSEDED); err != nil && !errors.Is(err, storage.ErrAlreadyRevoked) {
+			return err
+		}
+	}
+
+	return nil
+}
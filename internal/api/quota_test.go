@@ -0,0 +1,91 @@
+package api
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func addTestCertificates(store storage.Store, subject string, n int) {
+	for i := 0; i < n; i++ {
+		serial, _ := store.NextSerial()
+		store.AddCertificate(storage.Certificate{
+			Serial:      serial,
+			HostGroup:   "example",
+			Subject:     subject,
+			ValidAfter:  time.Now().Add(-time.Minute),
+			ValidBefore: time.Now().Add(time.Hour),
+		})
+	}
+}
+
+func TestCheckQuotaDeny(t *testing.T) {
+	store := storage.NewMemoryStore()
+	info := config.HostInfo{HostGroup: "example", MaxCertificates: 2, QuotaAction: config.QUOTA_DENY}
+
+	addTestCertificates(store, "alice@issuer", 1)
+	addTestCertificates(store, "bob@issuer", 2)
+
+	_, err := checkQuota(store, info, "alice@issuer")
+	assert.NoError(t, err)
+	_, err = checkQuota(store, info, "bob@issuer")
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+
+	// Revoked certificates don't count
+	Revoke(store, 2, "test", storage.REASON_KEY_COMPROMISE)
+	_, err = checkQuota(store, info, "bob@issuer")
+	assert.NoError(t, err)
+}
+
+func TestCheckQuotaRevokeOldest(t *testing.T) {
+	store := storage.NewMemoryStore()
+	info := config.HostInfo{HostGroup: "example", MaxCertificates: 2, QuotaAction: config.QUOTA_REVOKE_OLDEST}
+
+	addTestCertificates(store, "alice@issuer", 3)
+
+	// Nothing is revoked before the new certificate is recorded
+	superseded, err := checkQuota(store, info, "alice@issuer")
+	assert.NoError(t, err)
+	assert.Len(t, superseded, 2)
+	revocations, _ := store.ListRevocations()
+	assert.Empty(t, revocations)
+
+	assert.NoError(t, supersede(store, superseded))
+	revocations, _ = store.ListRevocations()
+	assert.Len(t, revocations, 2)
+
+	active, _ := activeCertificates(store, "alice@issuer", "example")
+	assert.Len(t, active, 1)
+	assert.Equal(t, uint64(3), active[0].Serial)
+}
+
+func TestLockQuota(t *testing.T) {
+	store := storage.NewMemoryStore()
+	info := config.HostInfo{HostGroup: "example", MaxCertificates: 1, QuotaAction: config.QUOTA_DENY}
+
+	var wg sync.WaitGroup
+	var exceeded atomic.Int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer lockQuota(info, "alice@issuer")()
+
+			if _, err := checkQuota(store, info, "alice@issuer"); err != nil {
+				exceeded.Add(1)
+				return
+			}
+			addTestCertificates(store, "alice@issuer", 1)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(9), exceeded.Load())
+	assert.Empty(t, quotaLocks)
+}
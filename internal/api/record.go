@@ -0,0 +1,92 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/storage"
+	"github.com/lbrocke/oinit/pkg/libmotleycue"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	AUDIT_ISSUE = "issue"
+	AUDIT_DENY  = "deny"
+)
+
+// tokenSubject returns the identity of the token owner in the form
+// sub@iss, or an empty string if the claims are missing.
+func tokenSubject(token *jwt.Token) string {
+	sub, err := token.Claims.GetSubject()
+	if err != nil || sub == "" {
+		return ""
+	}
+
+	iss, err := token.Claims.GetIssuer()
+	if err != nil || iss == "" {
+		return sub
+	}
+
+	return sub + "@" + iss
+}
+
+// recordCertificate stores the signed certificate and adds an audit event.
+func recordCertificate(store storage.Store, cert ssh.Certificate, host, hostGroup, subject, username string, vos []string) error {
+	now := time.Now()
+
+	if err := store.AddCertificate(storage.Certificate{
+		Serial:      cert.Serial,
+		KeyId:       cert.KeyId,
+		Host:        host,
+		HostGroup:   hostGroup,
+		Subject:     subject,
+		Username:    username,
+		Fingerprint: ssh.FingerprintSHA256(cert.Key),
+		CA:          ssh.FingerprintSHA256(cert.SignatureKey),
+		VOs:         vos,
+		ValidAfter:  time.Unix(int64(cert.ValidAfter), 0),
+		ValidBefore: time.Unix(int64(cert.ValidBefore), 0),
+		IssuedAt:    now,
+	}); err != nil {
+		return err
+	}
+
+	details := map[string]string{
+		"serial":   strconv.FormatUint(cert.Serial, 10),
+		"host":     host,
+		"username": username,
+	}
+
+	if len(vos) != 0 {
+		details["vos"] = strings.Join(vos, ",")
+	}
+
+	return store.AddAuditEvent(storage.AuditEvent{
+		Time:    now,
+		Action:  AUDIT_ISSUE,
+		Actor:   subject,
+		Details: details,
+	})
+}
+
+// recordDenial adds an audit event for a denied certificate request. Errors
+// are ignored, as the request is denied anyway.
+func recordDenial(store storage.Store, host, subject string, status libmotleycue.ApiResponseUserStatus, err error) {
+	reason := "state: " + string(status.State)
+	if err != nil {
+		reason = err.Error()
+	}
+
+	store.AddAuditEvent(storage.AuditEvent{
+		Time:   time.Now(),
+		Action: AUDIT_DENY,
+		Actor:  subject,
+		Details: map[string]string{
+			"host":   host,
+			"reason": reason,
+		},
+	})
+}
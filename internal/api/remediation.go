@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/i18n"
+	"github.com/lbrocke/oinit/internal/util"
+	"github.com/lbrocke/oinit/pkg/libmotleycue"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Hints on how users can resolve denials, translated like error codes
+	HINT_ENROLL    = "hint_enroll"
+	HINT_PENDING   = "hint_pending"
+	HINT_SUSPENDED = "hint_suspended"
+	HINT_TOKEN     = "hint_token"
+	HINT_QUOTA     = "hint_quota"
+	HINT_VO_QUOTA  = "hint_vo_quota"
+
+	// Duration (in seconds) that the login help of motley_cue instances is
+	// cached
+	LOGIN_HELP_CACHE_DURATION = 3600
+)
+
+// Remediation tells users what they can do about a denied request.
+type Remediation struct {
+	// Next step in the language requested by Accept-Language
+	Hint string `json:"hint" example:"Register your account on the enrollment page, then try again."`
+	// Page to register accounts at or learn more, if the hint refers to it
+	URL string `json:"url,omitempty" example:"https://login.example.com/enroll"`
+	// Who to contact if the hint doesn't help
+	Contact string `json:"contact,omitempty" example:"support@example.com"`
+}
+
+// loginHelps contains the login help of motley_cue instances by URL.
+var loginHelps = util.NewTimedCache[string, string]()
+
+// loginHelp returns the login help that motley_cue of the host offers in
+// /info, which usually points to where accounts are registered, or an empty
+// string if it can't be retrieved.
+func loginHelp(ctx context.Context, info config.HostInfo) string {
+	if help, ok := loginHelps.Get(info.URL); ok {
+		return help
+	}
+
+	res, err := motleyCueClient(info.URL, info.MotleyCueKey).GetInfoContext(ctx)
+	if err != nil {
+		return ""
+	}
+
+	loginHelps.Set(info.URL, res.LoginInfo.LoginHelp, LOGIN_HELP_CACHE_DURATION)
+
+	return res.LoginInfo.LoginHelp
+}
+
+// userStateHint returns the hint for a user that motley_cue didn't deploy,
+// or an empty string if users can't do anything about it, e.g. because
+// motley_cue is down.
+func userStateHint(status libmotleycue.ApiResponseUserStatus, err error) string {
+	if err != nil {
+		if libmotleycue.Unavailable(err) {
+			return ""
+		}
+
+		return HINT_TOKEN
+	}
+
+	switch status.State {
+	case libmotleycue.StateNotDeployed:
+		return HINT_ENROLL
+	case libmotleycue.StatePending:
+		return HINT_PENDING
+	case libmotleycue.StateSuspended, libmotleycue.StateRejected, libmotleycue.StateLimited:
+		return HINT_SUSPENDED
+	}
+
+	return ""
+}
+
+// remediation returns the translated hint along with the enrollment URL of
+// the host, if the hint refers to it, and the support contact.
+func remediation(c *gin.Context, info config.HostInfo, hint string) Remediation {
+	r := Remediation{
+		Hint:    i18n.Translate(language(c), hint),
+		Contact: info.SupportContact,
+	}
+
+	if hint == HINT_ENROLL || hint == HINT_PENDING {
+		r.URL = info.EnrollmentURL
+		if r.URL == "" {
+			r.URL = loginHelp(c.Request.Context(), info)
+		}
+	}
+
+	return r
+}
+
+// Denial responds like Error, but includes a remediation with the hint, so
+// that clients can show users actionable next steps. Without a hint, it is
+// the same as Error.
+func Denial(c *gin.Context, status int, code string, info config.HostInfo, hint string) {
+	if hint == "" {
+		Error(c, status, code)
+		return
+	}
+
+	r := remediation(c, info, hint)
+
+	c.Set("error_code", code)
+	c.JSON(status, ApiResponseError{
+		Code:        code,
+		Error:       i18n.Translate(language(c), code),
+		RequestID:   c.GetString("request_id"),
+		Remediation: &r,
+	})
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/mockmotleycue"
+	"github.com/lbrocke/oinit/pkg/libmotleycue"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserStateHint(t *testing.T) {
+	status := func(state libmotleycue.UserStatusState) libmotleycue.ApiResponseUserStatus {
+		return libmotleycue.ApiResponseUserStatus{State: state}
+	}
+
+	assert.Equal(t, HINT_ENROLL, userStateHint(status(libmotleycue.StateNotDeployed), nil))
+	assert.Equal(t, HINT_PENDING, userStateHint(status(libmotleycue.StatePending), nil))
+	assert.Equal(t, HINT_SUSPENDED, userStateHint(status(libmotleycue.StateSuspended), nil))
+	assert.Equal(t, HINT_TOKEN, userStateHint(status(""), libmotleycue.StatusError{StatusCode: http.StatusUnauthorized}))
+	assert.Empty(t, userStateHint(status(""), libmotleycue.StatusError{StatusCode: http.StatusBadGateway}))
+	assert.Empty(t, userStateHint(status(libmotleycue.StateUndefined), nil))
+	assert.Equal(t, HINT_TOKEN, userStateHint(status(""), errors.New("invalid token")))
+}
+
+func TestDenial(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	srv := httptest.NewServer(mockmotleycue.New())
+	defer srv.Close()
+
+	info := config.HostInfo{URL: srv.URL, SupportContact: "support@example.com"}
+
+	deny := func(info config.HostInfo, hint string) ApiResponseError {
+		router := gin.New()
+		router.GET("/", func(c *gin.Context) {
+			Denial(c, http.StatusUnauthorized, ERR_UNAUTHORIZED, info, hint)
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		var res ApiResponseError
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		assert.Equal(t, ERR_UNAUTHORIZED, res.Code)
+
+		return res
+	}
+
+	// The enrollment URL defaults to the login help of motley_cue
+	res := deny(info, HINT_ENROLL)
+	assert.NotNil(t, res.Remediation)
+	assert.NotEmpty(t, res.Remediation.Hint)
+	assert.NotEqual(t, HINT_ENROLL, res.Remediation.Hint)
+	assert.Equal(t, mockmotleycue.LOGIN_HELP, res.Remediation.URL)
+	assert.Equal(t, "support@example.com", res.Remediation.Contact)
+
+	info.EnrollmentURL = "https://example.com/enroll"
+	res = deny(info, HINT_ENROLL)
+	assert.Equal(t, "https://example.com/enroll", res.Remediation.URL)
+
+	res = deny(info, HINT_SUSPENDED)
+	assert.Empty(t, res.Remediation.URL)
+	assert.Equal(t, "support@example.com", res.Remediation.Contact)
+
+	res = deny(info, "")
+	assert.Nil(t, res.Remediation)
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	ERR_INVALID_RENEWAL = service.ERR_INVALID_RENEWAL
+)
+
+// FormHostRenew is a request of an enrolled host for a new host certificate,
+// signed with the key of its current host certificate using
+// 'ssh-keygen -Y sign'.
+type FormHostRenew struct {
+	// Unix timestamp of the request
+	Timestamp int64 `form:"timestamp" json:"timestamp" binding:"required"`
+	// Current, unexpired host certificate issued by the CA
+	Certificate string `form:"certificate" json:"certificate" binding:"required"`
+	// Armored SSH signature over "<host>\n<timestamp>\n<certificate serial>"
+	Signature string `form:"signature" json:"signature" binding:"required"`
+}
+
+type ApiResponseHostRenewal struct {
+	Certificate string    `json:"certificate"`
+	Serial      uint64    `json:"serial"`
+	ValidBefore time.Time `json:"valid_before"`
+}
+
+// PostHostRenew is the handler for POST /:host/renew
+//
+//	@Summary		Renew a host certificate
+//	@ID				postHostRenew
+//	@Description	Issue a new host certificate for the key of the current host certificate of an enrolled host, if
+//	@Description	host-enrollment is enabled for the hostgroup. The request must be signed with the key of the current,
+//	@Description	unexpired and unrevoked host certificate, e.g. using scripts/oinit-renew-host.sh.
+//	@Accept			json,mpfd
+//	@Produce		json
+//	@Param			host	path		string			true	"Host"	example("example.com")
+//	@Param			body	body		FormHostRenew	true	"Signed renewal request"
+//	@Success		200		{object}	ApiResponseHostRenewal
+//	@Failure		400		{object}	ApiResponseError
+//	@Failure		401		{object}	ApiResponseError
+//	@Failure		403		{object}	ApiResponseError
+//	@Failure		404		{object}	ApiResponseError
+//	@Failure		500		{object}	ApiResponseError
+//	@Failure		504		{object}	ApiResponseError
+//	@Router			/{host}/renew [post]
+func PostHostRenew(c *gin.Context) {
+	var host UriHost
+	var body FormHostRenew
+
+	if c.ShouldBindUri(&host) != nil || c.ShouldBind(&body) != nil {
+		Error(c, http.StatusBadRequest, ERR_BAD_BODY)
+		return
+	}
+
+	svc, ok := newService(c)
+	if !ok {
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	renewed, err := svc.RenewHostCertificate(c.Request.Context(), strings.ToLower(host.Host), service.RenewalRequest(body))
+	if err != nil {
+		serviceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, ApiResponseHostRenewal{
+		Certificate: strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(renewed)), "\n"),
+		Serial:      renewed.Serial,
+		ValidBefore: time.Unix(int64(renewed.ValidBefore), 0).UTC(),
+	})
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	ERR_TOKEN_REPLAYED = "token_replayed"
+
+	AUDIT_REPLAY = "replay"
+)
+
+var ErrTokenReplayed = errors.New(ERR_TOKEN_REPLAYED)
+
+// tokenID returns the jti claim of the token prefixed with its issuer, or an
+// empty string if the token has no jti.
+func tokenID(token *jwt.Token) string {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return ""
+	}
+
+	jti, ok := claims["jti"].(string)
+	if !ok || jti == "" {
+		return ""
+	}
+
+	iss, _ := claims.GetIssuer()
+
+	return iss + " " + jti
+}
+
+// detectReplay remembers the key fingerprint the token was used for at the
+// host. If the token has already been used for a different key at the same
+// host, an audit event is added and ErrTokenReplayed is returned. Using the
+// same token for the same key again is allowed, e.g. when a client retries,
+// as is using it for other hosts, e.g. jump hosts. A fingerprint is only
+// remembered within the window and while the certificate issued for it, valid
+// for the given duration, has not expired, as clients create a new key when
+// they reconnect afterwards.
+func detectReplay(store storage.Store, token *jwt.Token, fingerprint, host string, window, validity time.Duration) error {
+	id := tokenID(token)
+	if id == "" || window <= 0 {
+		return nil
+	}
+
+	if validity > 0 && validity < window {
+		window = validity
+	}
+
+	previous, err := store.MarkSeen("jti "+id+" "+host, fingerprint, window)
+	if err != nil {
+		return err
+	}
+
+	if previous == "" || previous == fingerprint {
+		return nil
+	}
+
+	subject := tokenSubject(token)
+
+	Logger.Printf("Replay of token %s by %s for host %s: first used for %s, now for %s", id, subject, host, previous, fingerprint)
+
+	store.AddAuditEvent(storage.AuditEvent{
+		Time:   time.Now(),
+		Action: AUDIT_REPLAY,
+		Actor:  subject,
+		Details: map[string]string{
+			"host":        host,
+			"jti":         id,
+			"fingerprint": fingerprint,
+			"first_used":  previous,
+		},
+	})
+
+	return fmt.Errorf("%w: %s first used for %s", ErrTokenReplayed, id, previous)
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectReplay(t *testing.T) {
+	store := storage.NewMemoryStore()
+	token := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{
+		"iss": "https://issuer",
+		"sub": "alice",
+		"jti": "1234",
+	})
+
+	assert.NoError(t, detectReplay(store, token, "SHA256:first", "example.com", time.Hour, time.Hour))
+	// Retries for the same key are fine
+	assert.NoError(t, detectReplay(store, token, "SHA256:first", "example.com", time.Hour, time.Hour))
+	assert.ErrorIs(t, detectReplay(store, token, "SHA256:second", "example.com", time.Hour, time.Hour), ErrTokenReplayed)
+
+	events, _ := store.ListAuditEvents(time.Time{})
+	assert.Len(t, events, 1)
+	assert.Equal(t, AUDIT_REPLAY, events[0].Action)
+	assert.Equal(t, "alice@https://issuer", events[0].Actor)
+
+	// Tokens without jti and disabled detection are not checked
+	noJTI := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"sub": "bob"})
+	assert.NoError(t, detectReplay(store, noJTI, "SHA256:first", "example.com", time.Hour, time.Hour))
+	assert.NoError(t, detectReplay(store, noJTI, "SHA256:second", "example.com", time.Hour, time.Hour))
+	assert.NoError(t, detectReplay(store, token, "SHA256:third", "example.com", 0, time.Hour))
+}
+
+func TestDetectReplayClientFlow(t *testing.T) {
+	store := storage.NewMemoryStore()
+	token := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{
+		"iss": "https://issuer",
+		"sub": "alice",
+		"jti": "1234",
+	})
+
+	// The client creates a new key on every connection and reuses its
+	// cached token, e.g. for the jump host and the target of a ProxyJump
+	// chain, and for other hosts later on.
+	assert.NoError(t, detectReplay(store, token, "SHA256:jump", "jump.example.com", time.Hour, 100*time.Millisecond))
+	assert.NoError(t, detectReplay(store, token, "SHA256:target", "target.example.com", time.Hour, 100*time.Millisecond))
+	assert.NoError(t, detectReplay(store, token, "SHA256:other", "other.example.com", time.Hour, time.Hour))
+
+	// Reconnecting after the certificate expired creates another key
+	time.Sleep(150 * time.Millisecond)
+	assert.NoError(t, detectReplay(store, token, "SHA256:reconnect", "target.example.com", time.Hour, 100*time.Millisecond))
+
+	// While the certificate is valid, another key for the same host is not
+	assert.ErrorIs(t, detectReplay(store, token, "SHA256:stolen", "target.example.com", time.Hour, 100*time.Millisecond), ErrTokenReplayed)
+	assert.ErrorIs(t, detectReplay(store, token, "SHA256:stolen", "other.example.com", time.Hour, time.Hour), ErrTokenReplayed)
+
+	events, _ := store.ListAuditEvents(time.Time{})
+	assert.Len(t, events, 2)
+}
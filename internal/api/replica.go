@@ -0,0 +1,118 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ERR_REPLICA     = "replica"
+	ERR_NOT_REPLICA = "not_replica"
+
+	AUDIT_PROMOTE = "promote"
+
+	// Roles of the CA, see ApiResponseHealth
+	ROLE_PRIMARY = "primary"
+	ROLE_REPLICA = "replica"
+
+	// Seconds after which clients should retry requests refused by a
+	// replica, after which a load balancer may have failed over
+	REPLICA_RETRY_AFTER = "30"
+)
+
+// replica returns the store of the context if the CA is a replica that has
+// not been promoted.
+func replica(c *gin.Context) (*storage.ReplicaStore, bool) {
+	value, _ := c.Get("store")
+
+	store, ok := value.(*storage.ReplicaStore)
+	if !ok || store.Promoted() {
+		return nil, false
+	}
+
+	return store, true
+}
+
+// role returns ROLE_REPLICA if the CA is a replica that has not been
+// promoted, and ROLE_PRIMARY otherwise.
+func role(c *gin.Context) string {
+	if _, ok := replica(c); ok {
+		return ROLE_REPLICA
+	}
+
+	return ROLE_PRIMARY
+}
+
+// RequirePrimary is a middleware that refuses requests with 503 Service
+// Unavailable while the CA is a replica, as they sign certificates or modify
+// state that would be overwritten by the replicated state of the primary.
+func RequirePrimary(c *gin.Context) {
+	if _, ok := replica(c); ok {
+		c.Header("Retry-After", REPLICA_RETRY_AFTER)
+		Error(c, http.StatusServiceUnavailable, ERR_REPLICA)
+		c.Abort()
+		return
+	}
+
+	c.Next()
+}
+
+type ApiResponseAdminReplica struct {
+	// "primary" or "replica"
+	Role     string             `json:"role" example:"primary"`
+	Promoted *storage.Promotion `json:"promoted,omitempty"`
+}
+
+// PostAdminPromote is the handler for POST /admin/replica/promote
+//
+//	@Summary		Promote replica
+//	@ID				postAdminPromote
+//	@Description	Promote this CA from a warm standby replica to the primary CA, which signs certificates and
+//	@Description	persists its state. Only promote the replica after the primary CA was stopped and replication of
+//	@Description	its storage file ended, as both would sign certificates otherwise. Replicas can also be promoted
+//	@Description	by creating their promote-file. Promoting a promoted replica again does nothing.
+//	@Tags			admin
+//	@Produce		json
+//	@Security		AdminToken
+//	@Success		200	{object}	ApiResponseAdminReplica
+//	@Failure		401	{object}	ApiResponseError
+//	@Failure		403	{object}	ApiResponseError
+//	@Failure		409	{object}	ApiResponseError
+//	@Failure		500	{object}	ApiResponseError
+//	@Router			/admin/replica/promote [post]
+func PostAdminPromote(c *gin.Context) {
+	store, ok := c.MustGet("store").(*storage.ReplicaStore)
+	if !ok {
+		Error(c, http.StatusConflict, ERR_NOT_REPLICA)
+		return
+	}
+
+	admin := c.GetString("admin")
+
+	if !store.Promoted() {
+		if err := store.Promote(admin); err != nil {
+			Logger.Println("Could not promote replica: " + err.Error())
+			Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+			return
+		}
+
+		Logger.Println("Promoted replica to primary CA, promoted by " + admin)
+
+		store.AddAuditEvent(storage.AuditEvent{
+			Time:   time.Now(),
+			Action: AUDIT_PROMOTE,
+			Actor:  admin,
+		})
+	}
+
+	promotion, _ := store.Promotion()
+
+	c.JSON(http.StatusOK, ApiResponseAdminReplica{
+		Role:     ROLE_PRIMARY,
+		Promoted: &promotion,
+	})
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplica(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	dir := t.TempDir()
+	store, err := storage.NewReplicaStore(filepath.Join(dir, "state.json"), filepath.Join(dir, "promoted"))
+	assert.NoError(t, err)
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("config", config.Config{})
+		c.Set("store", store)
+		c.Set("clock", nil)
+		c.Set("admin", "alice")
+	})
+	router.GET("/health", GetHealth)
+	router.POST("/sign", RequirePrimary, func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.POST("/admin/replica/promote", PostAdminPromote)
+
+	request := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	var health ApiResponseHealth
+	json.Unmarshal(request(http.MethodGet, "/health").Body.Bytes(), &health)
+	assert.Equal(t, ROLE_REPLICA, health.Role)
+
+	w := request(http.MethodPost, "/sign")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, REPLICA_RETRY_AFTER, w.Header().Get("Retry-After"))
+
+	var replica ApiResponseAdminReplica
+	w = request(http.MethodPost, "/admin/replica/promote")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &replica))
+	assert.Equal(t, ROLE_PRIMARY, replica.Role)
+	assert.Equal(t, "alice", replica.Promoted.By)
+
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "/sign").Code)
+
+	json.Unmarshal(request(http.MethodGet, "/health").Body.Bytes(), &health)
+	assert.Equal(t, ROLE_PRIMARY, health.Role)
+
+	// Promotions are audited once
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "/admin/replica/promote").Code)
+
+	events, _ := store.ListAuditEvents(time.Time{})
+	assert.Len(t, events, 1)
+	assert.Equal(t, AUDIT_PROMOTE, events[0].Action)
+
+	// Primary CAs can't be promoted
+	router = gin.New()
+	router.Use(func(c *gin.Context) { c.Set("store", storage.NewMemoryStore()) })
+	router.POST("/admin/replica/promote", PostAdminPromote)
+	router.POST("/sign", RequirePrimary, func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	assert.Equal(t, http.StatusConflict, request(http.MethodPost, "/admin/replica/promote").Code)
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "/sign").Code)
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/storage"
+	"github.com/lbrocke/oinit/pkg/libmotleycue"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ApiResponseRevoked lists the certificates revoked on behalf of a user.
+type ApiResponseRevoked struct {
+	// Serial numbers of the revoked certificates, empty if the user held
+	// no valid certificate for the host
+	Serials []uint64 `json:"serials"`
+}
+
+// revokeSubject revokes all certificates of subject for host that are still
+// valid as compromised and returns their serial numbers. Certificates that
+// are already revoked are skipped.
+func revokeSubject(store storage.Store, subject, host string) ([]uint64, error) {
+	certs, err := store.ListCertificates(storage.CertificateFilter{
+		Subject: subject,
+		ValidAt: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	serials := []uint64{}
+	for _, cert := range certs {
+		if cert.Host != host {
+			continue
+		}
+
+		if _, err := Revoke(store, cert.Serial, subject, storage.REASON_KEY_COMPROMISE); err != nil {
+			if errors.Is(err, storage.ErrAlreadyRevoked) {
+				continue
+			}
+
+			return serials, err
+		}
+
+		serials = append(serials, cert.Serial)
+	}
+
+	return serials, nil
+}
+
+// PostHostRevoke is the handler for POST /:host/revoke
+//
+//	@Summary		Revoke the user's certificates
+//	@ID				postHostRevoke
+//	@Description	Revoke all valid certificates issued to the user of the access token for the host, e.g. if the
+//	@Description	device holding them was lost. They are added to the KRL of the host as key-compromise. The token
+//	@Description	must be accepted by motley_cue, but the account need not be deployed.
+//	@Produce		json
+//	@Param			host			path		string	true	"Host"	example("example.com")
+//	@Param			Authorization	header		string	true	"Bearer access token"
+//	@Success		200				{object}	ApiResponseRevoked
+//	@Failure		400				{object}	ApiResponseError
+//	@Failure		401				{object}	ApiResponseError
+//	@Failure		404				{object}	ApiResponseError
+//	@Failure		500				{object}	ApiResponseError
+//	@Failure		502				{object}	ApiResponseError
+//	@Failure		504				{object}	ApiResponseError
+//	@Router			/{host}/revoke [post]
+func PostHostRevoke(c *gin.Context) {
+	var host UriHost
+
+	if c.ShouldBindUri(&host) != nil {
+		Error(c, http.StatusBadRequest, ERR_BAD_BODY)
+		return
+	}
+
+	host.Host = strings.ToLower(host.Host)
+
+	conf, ok := c.MustGet("config").(config.Config)
+	if !ok {
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	accessToken := bearerToken(c)
+	if accessToken == "" || len(accessToken) > MAX_TOKEN_LENGTH {
+		Error(c, http.StatusUnauthorized, ERR_UNAUTHORIZED)
+		return
+	}
+
+	token, _, err := new(jwt.Parser).ParseUnverified(accessToken, jwt.MapClaims{})
+	if err != nil {
+		Error(c, http.StatusUnauthorized, ERR_UNAUTHORIZED)
+		return
+	}
+
+	subject := tokenSubject(token)
+	if subject == "" {
+		Error(c, http.StatusUnauthorized, ERR_UNAUTHORIZED)
+		return
+	}
+
+	c.Set("subject", subject)
+
+	info, err := lookupHost(c.Request.Context(), conf, host.Host)
+	if err != nil {
+		if timedOut(c) {
+			return
+		}
+
+		Error(c, http.StatusNotFound, ERR_UNKNOWN_HOST)
+		return
+	}
+
+	// The token is verified by motley_cue, or against the JWKS of the
+	// workload issuer, so that only the subject can revoke its certificates.
+	if isWorkloadToken(conf, token) {
+		_, _, err = workloadUser(c.Request.Context(), conf, host.Host, accessToken)
+	} else {
+		var expiry time.Time
+		if exp, err := token.Claims.GetExpirationTime(); err == nil && exp != nil {
+			expiry = exp.Time
+		}
+
+		_, err = queryUserStatus(c.Request.Context(), conf, info, host.Host, accessToken, expiry)
+	}
+
+	if err != nil {
+		if timedOut(c) {
+			return
+		}
+
+		if libmotleycue.Unavailable(err) {
+			Error(c, http.StatusBadGateway, ERR_GATEWAY_DOWN)
+			return
+		}
+
+		Error(c, http.StatusUnauthorized, ERR_UNAUTHORIZED)
+		return
+	}
+
+	serials, err := revokeSubject(c.MustGet("store").(storage.Store), subject, host.Host)
+	if err != nil {
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	c.JSON(http.StatusOK, ApiResponseRevoked{Serials: serials})
+}
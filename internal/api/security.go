@@ -0,0 +1,88 @@
+package api
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/lbrocke/oinit/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	HEADER_HSTS                 = "Strict-Transport-Security"
+	HEADER_CONTENT_TYPE_OPTIONS = "X-Content-Type-Options"
+	HEADER_FRAME_OPTIONS        = "X-Frame-Options"
+	HEADER_REFERRER_POLICY      = "Referrer-Policy"
+	HEADER_CACHE_CONTROL        = "Cache-Control"
+	HEADER_CSP_REPORT_ONLY      = "Content-Security-Policy-Report-Only"
+	HEADER_FORWARDED_PROTO      = "X-Forwarded-Proto"
+
+	// Values of the headers set by SecurityHeaders and NoStore
+	CONTENT_TYPE_OPTIONS   = "nosniff"
+	FRAME_OPTIONS          = "DENY"
+	REFERRER_POLICY        = "no-referrer"
+	CACHE_CONTROL_NO_STORE = "no-store"
+)
+
+// SecurityHeaders returns a middleware that sets headers hardening browsers
+// against MIME sniffing, framing and leaking URLs in referrers. Responses to
+// HTTPS requests also contain a Strict-Transport-Security header with the
+// given max-age, unless it is negative. Requests are considered HTTPS if
+// they were received over TLS, or if a trusted proxy says so in
+// X-Forwarded-Proto.
+func SecurityHeaders(hstsMaxAge int, proxies []string) gin.HandlerFunc {
+	isTrusted := trustedProxies(proxies)
+	hsts := "max-age=" + strconv.Itoa(hstsMaxAge)
+
+	return func(c *gin.Context) {
+		header := c.Writer.Header()
+		header.Set(HEADER_CONTENT_TYPE_OPTIONS, CONTENT_TYPE_OPTIONS)
+		header.Set(HEADER_FRAME_OPTIONS, FRAME_OPTIONS)
+		header.Set(HEADER_REFERRER_POLICY, REFERRER_POLICY)
+
+		if hstsMaxAge >= 0 && isHTTPS(c, isTrusted) {
+			header.Set(HEADER_HSTS, hsts)
+		}
+
+		c.Next()
+	}
+}
+
+// isHTTPS reports whether the client sent the request using HTTPS.
+func isHTTPS(c *gin.Context, isTrusted func(net.IP) bool) bool {
+	if c.Request.TLS != nil {
+		return true
+	}
+
+	proto := c.GetHeader(HEADER_FORWARDED_PROTO)
+	if proto == "" || !isTrusted(net.ParseIP(c.RemoteIP())) {
+		return false
+	}
+
+	// Only the first of several values appended by proxies is used
+	first, _, _ := strings.Cut(proto, ",")
+
+	return strings.EqualFold(strings.TrimSpace(first), "https")
+}
+
+// NoStore is a middleware that forbids caching responses, for routes that
+// return certificates or receive tokens.
+func NoStore(c *gin.Context) {
+	c.Header(HEADER_CACHE_CONTROL, CACHE_CONTROL_NO_STORE)
+	c.Next()
+}
+
+// ContentSecurityPolicy returns a middleware that sets the policy in a
+// Content-Security-Policy-Report-Only header, so that browsers report
+// violations without blocking anything. config.ADMIN_CSP_NONE disables it.
+func ContentSecurityPolicy(policy string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if policy != config.ADMIN_CSP_NONE {
+			c.Header(HEADER_CSP_REPORT_ONLY, policy)
+		}
+
+		c.Next()
+	}
+}
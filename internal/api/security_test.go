@@ -0,0 +1,76 @@
+package api
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lbrocke/oinit/internal/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handler := func(c *gin.Context) {
+		c.String(http.StatusOK, "")
+	}
+
+	router := gin.New()
+	router.Use(SecurityHeaders(3600, []string{"10.0.0.0/8"}))
+	router.GET("/", handler)
+	router.POST("/certificate", NoStore, handler)
+	router.GET("/admin/", ContentSecurityPolicy("default-src 'none'"), handler)
+	router.GET("/disabled", ContentSecurityPolicy(config.ADMIN_CSP_NONE), handler)
+
+	for _, test := range []struct {
+		method, path, remote, proto string
+		tls                         bool
+		hsts, cache, csp            string
+	}{
+		{http.MethodGet, "/", "198.51.100.1:1234", "", false, "", "", ""},
+		{http.MethodGet, "/", "198.51.100.1:1234", "", true, "max-age=3600", "", ""},
+		// Only trusted proxies may claim HTTPS
+		{http.MethodGet, "/", "198.51.100.1:1234", "https", false, "", "", ""},
+		{http.MethodGet, "/", "10.1.2.3:1234", "https", false, "max-age=3600", "", ""},
+		{http.MethodGet, "/", "10.1.2.3:1234", "http, https", false, "", "", ""},
+		{http.MethodPost, "/certificate", "198.51.100.1:1234", "", false, "", "no-store", ""},
+		{http.MethodGet, "/admin/", "198.51.100.1:1234", "", false, "", "", "default-src 'none'"},
+		{http.MethodGet, "/disabled", "198.51.100.1:1234", "", false, "", "", ""},
+	} {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		req.RemoteAddr = test.remote
+		if test.proto != "" {
+			req.Header.Set(HEADER_FORWARDED_PROTO, test.proto)
+		}
+		if test.tls {
+			req.TLS = &tls.ConnectionState{}
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, CONTENT_TYPE_OPTIONS, w.Header().Get(HEADER_CONTENT_TYPE_OPTIONS))
+		assert.Equal(t, FRAME_OPTIONS, w.Header().Get(HEADER_FRAME_OPTIONS))
+		assert.Equal(t, REFERRER_POLICY, w.Header().Get(HEADER_REFERRER_POLICY))
+		assert.Equal(t, test.hsts, w.Header().Get(HEADER_HSTS), test.path)
+		assert.Equal(t, test.cache, w.Header().Get(HEADER_CACHE_CONTROL), test.path)
+		assert.Equal(t, test.csp, w.Header().Get(HEADER_CSP_REPORT_ONLY), test.path)
+	}
+
+	// Negative max-ages disable HSTS
+	router = gin.New()
+	router.Use(SecurityHeaders(-1, nil))
+	router.GET("/", handler)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.TLS = &tls.ConnectionState{}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Empty(t, w.Header().Get(HEADER_HSTS))
+}
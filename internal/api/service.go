@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/approved"
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/service"
+	"github.com/lbrocke/oinit/internal/storage"
+	"github.com/lbrocke/oinit/pkg/libmotleycue"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/ssh"
+)
+
+// configHosts looks up hosts in the configuration using lookupHost, so that
+// unknown hosts are cached and strict-hosts is honored.
+type configHosts struct {
+	conf config.Config
+}
+
+func (h configHosts) LookupHost(ctx context.Context, host string) (config.HostInfo, error) {
+	return lookupHost(ctx, h.conf, host)
+}
+
+// configSigner signs with the CA keys of the hostgroups, restricted to
+// approved algorithms if configured.
+type configSigner struct {
+	conf config.Config
+}
+
+func (s configSigner) HostSigner(info config.HostInfo) (ssh.Signer, error) {
+	if s.conf.Server.ApprovedAlgorithms {
+		return approved.Signer(info.HostCAPrivateKey)
+	}
+
+	return ssh.NewSignerFromKey(info.HostCAPrivateKey)
+}
+
+// upstreamAuthorizer asks the motley_cue upstreams of the hostgroups, except
+// for workload identity tokens, which are verified by the CA itself.
+type upstreamAuthorizer struct {
+	conf config.Config
+}
+
+func (a upstreamAuthorizer) Status(ctx context.Context, info config.HostInfo, host string, token *jwt.Token) (libmotleycue.ApiResponseUserStatus, error) {
+	if isWorkloadToken(a.conf, token) {
+		status, _, err := workloadUser(ctx, a.conf, host, token.Raw)
+		return status, err
+	}
+
+	var expiry time.Time
+	if exp, err := token.Claims.GetExpirationTime(); err == nil && exp != nil {
+		expiry = exp.Time
+	}
+
+	return queryUserStatus(ctx, a.conf, info, host, token.Raw, expiry)
+}
+
+// newService returns the service using the config and store of the request.
+// The store is nil if none is set, e.g. in tests that don't need it.
+func newService(c *gin.Context) (*service.Service, bool) {
+	conf, ok := c.MustGet("config").(config.Config)
+	if !ok {
+		return nil, false
+	}
+
+	value, _ := c.Get("store")
+	store, _ := value.(storage.Store)
+
+	return &service.Service{
+		Hosts:              configHosts{conf},
+		Authorizer:         upstreamAuthorizer{conf},
+		Signer:             configSigner{conf},
+		Store:              store,
+		ClockSkewTolerance: conf.Server.ClockSkewTolerance,
+		Logger:             Logger,
+	}, true
+}
+
+// serviceStatus maps the errors returned by the service to HTTP status codes.
+var serviceStatus = map[*service.Error]int{
+	service.ErrHostNotFound:        http.StatusNotFound,
+	service.ErrTokenRejected:       http.StatusUnauthorized,
+	service.ErrUpstreamUnavailable: http.StatusBadGateway,
+	service.ErrNotFound:            http.StatusNotFound,
+	service.ErrEnrollmentDisabled:  http.StatusForbidden,
+	service.ErrEnrollmentDecided:   http.StatusConflict,
+	service.ErrTooManyEnrollments:  http.StatusTooManyRequests,
+	service.ErrInvalidHostKey:      http.StatusBadRequest,
+	service.ErrInvalidRenewal:      http.StatusUnauthorized,
+	service.ErrInvalidHostKeys:     http.StatusUnauthorized,
+	service.ErrNoHostKeys:          http.StatusNotFound,
+}
+
+// serviceError writes the error returned by the service, which is a timeout
+// if the request timed out and an internal error if it doesn't wrap a
+// service error.
+func serviceError(c *gin.Context, err error) {
+	if timedOut(c) {
+		return
+	}
+
+	if e := service.AsError(err); e != nil {
+		if status, ok := serviceStatus[e]; ok {
+			Error(c, status, e.Code)
+			return
+		}
+	}
+
+	Logger.Printf("Internal error: %s", err)
+	Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+}
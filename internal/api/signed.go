@@ -0,0 +1,86 @@
+package api
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/tokenbind"
+	"github.com/lbrocke/oinit/pkg/oinitca"
+)
+
+const (
+	// Signed requests must have been signed within this duration (in
+	// seconds), in either direction to allow for skewed clocks
+	SIGNED_REQUEST_MAX_AGE = 300
+
+	FIELD_SIGNED_REQUEST = "signed_request"
+
+	CODE_INVALID_SIGNATURE = "invalid_signature"
+	CODE_EXPIRED           = "expired"
+	CODE_HOST_MISMATCH     = "host_mismatch"
+	CODE_TOKEN_MISMATCH    = "token_mismatch"
+
+	MSG_UNPARSABLE_SIGNED_REQUEST = "unparsable_signed_request"
+	MSG_INVALID_SIGNATURE         = "invalid_signature"
+	MSG_SIGNED_REQUEST_EXPIRED    = "signed_request_expired"
+	MSG_SIGNED_REQUEST_HOST       = "signed_request_host"
+	MSG_SIGNED_REQUEST_TOKEN      = "signed_request_token"
+	MSG_SIGNED_REQUEST_CONFLICT   = "signed_request_conflict"
+)
+
+// applySignedRequest verifies the signed request of the body, if any, and
+// returns the body with the public key and options taken from it instead,
+// which is also what delegated CAs receive. Since relays can't change a
+// signed request, fields of the body that it covers must be empty.
+func applySignedRequest(body FormHostCertificate, host string, now time.Time) (FormHostCertificate, []FieldError) {
+	if body.SignedRequest == "" {
+		return body, nil
+	}
+
+	req, _, err := oinitca.ParseSignedRequest(body.SignedRequest)
+	if err != nil {
+		if errors.Is(err, oinitca.ErrInvalidSignature) {
+			return body, []FieldError{fieldError(FIELD_SIGNED_REQUEST, CODE_INVALID_SIGNATURE, MSG_INVALID_SIGNATURE)}
+		}
+
+		return body, []FieldError{fieldError(FIELD_SIGNED_REQUEST, CODE_UNPARSABLE, MSG_UNPARSABLE_SIGNED_REQUEST)}
+	}
+
+	var errs []FieldError
+
+	for field, set := range map[string]bool{
+		FIELD_PUBLICKEY:  body.Publickey != "",
+		FIELD_EXTENSIONS: body.Extensions != nil,
+		FIELD_COMMAND:    body.Command != "",
+		FIELD_PROFILE:    body.Profile != "",
+	} {
+		if set {
+			errs = append(errs, fieldError(field, CODE_CONFLICT, MSG_SIGNED_REQUEST_CONFLICT))
+		}
+	}
+
+	if age := now.Unix() - req.IssuedAt; age > SIGNED_REQUEST_MAX_AGE || age < -SIGNED_REQUEST_MAX_AGE {
+		errs = append(errs, fieldError(FIELD_SIGNED_REQUEST, CODE_EXPIRED, MSG_SIGNED_REQUEST_EXPIRED, SIGNED_REQUEST_MAX_AGE/60))
+	}
+
+	if !strings.EqualFold(req.Host, host) {
+		errs = append(errs, fieldError(FIELD_SIGNED_REQUEST, CODE_HOST_MISMATCH, MSG_SIGNED_REQUEST_HOST, req.Host))
+	}
+
+	if req.TokenHash != "" && req.TokenHash != tokenbind.Hash(body.Token) {
+		errs = append(errs, fieldError(FIELD_SIGNED_REQUEST, CODE_TOKEN_MISMATCH, MSG_SIGNED_REQUEST_TOKEN))
+	}
+
+	if len(errs) != 0 {
+		return body, errs
+	}
+
+	body.Publickey = req.PublicKey
+	body.Extensions = req.Extensions
+	body.Command = req.Command
+	body.Profile = req.Profile
+	body.SignedRequest = ""
+
+	return body, nil
+}
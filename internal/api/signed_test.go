@@ -0,0 +1,66 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/tokenbind"
+	"github.com/lbrocke/oinit/pkg/oinitca"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestApplySignedRequest(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	signer, _ := ssh.NewSignerFromKey(priv)
+	now := time.Now()
+
+	sign := func(req oinitca.SignedRequest) string {
+		signed, err := req.Sign(signer)
+		assert.NoError(t, err)
+
+		return signed
+	}
+
+	// Bodies without signed request are unchanged
+	body, errs := applySignedRequest(FormHostCertificate{Publickey: "key", Token: TEST_TOKEN}, "login.example.com", now)
+	assert.Empty(t, errs)
+	assert.Equal(t, "key", body.Publickey)
+
+	signed := sign(oinitca.SignedRequest{
+		Host:      "login.example.com",
+		Command:   "rsync --server",
+		TokenHash: tokenbind.Hash(TEST_TOKEN),
+	})
+
+	body, errs = applySignedRequest(FormHostCertificate{Token: TEST_TOKEN, SignedRequest: signed}, "LOGIN.example.com", now)
+	assert.Empty(t, errs)
+	assert.Equal(t, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))), body.Publickey)
+	assert.Equal(t, "rsync --server", body.Command)
+	assert.Nil(t, body.Extensions)
+	assert.Empty(t, body.SignedRequest)
+
+	pubkey, _, errs := validateHostCertificate(body)
+	assert.Empty(t, errs)
+	assert.Equal(t, signer.PublicKey().Marshal(), pubkey.Marshal())
+
+	_, errs = applySignedRequest(FormHostCertificate{Token: TEST_TOKEN, SignedRequest: signed}, "other.example.com", now)
+	assert.Equal(t, map[string]string{FIELD_SIGNED_REQUEST: CODE_HOST_MISMATCH}, fieldCodes(errs))
+
+	_, errs = applySignedRequest(FormHostCertificate{Token: "other", SignedRequest: signed}, "login.example.com", now)
+	assert.Equal(t, map[string]string{FIELD_SIGNED_REQUEST: CODE_TOKEN_MISMATCH}, fieldCodes(errs))
+
+	_, errs = applySignedRequest(FormHostCertificate{Token: TEST_TOKEN, SignedRequest: signed}, "login.example.com", now.Add(time.Hour))
+	assert.Equal(t, map[string]string{FIELD_SIGNED_REQUEST: CODE_EXPIRED}, fieldCodes(errs))
+
+	_, errs = applySignedRequest(FormHostCertificate{Token: TEST_TOKEN, Command: "bash", SignedRequest: signed}, "login.example.com", now)
+	assert.Equal(t, map[string]string{FIELD_COMMAND: CODE_CONFLICT}, fieldCodes(errs))
+
+	_, errs = applySignedRequest(FormHostCertificate{Token: TEST_TOKEN, SignedRequest: signed[:len(signed)-4] + "AAAA"}, "login.example.com", now)
+	assert.Len(t, errs, 1)
+	assert.Equal(t, FIELD_SIGNED_REQUEST, errs[0].Field)
+}
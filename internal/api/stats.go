@@ -0,0 +1,127 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/buildinfo"
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Days covered by usage statistics. Certificates are kept for
+	// storage.RETENTION after they expire, so all certificates issued within
+	// this period are still stored.
+	STATS_DAYS = 30
+
+	STATS_DATE_FORMAT = "2006-01-02"
+)
+
+// ApiResponseStats contains anonymous usage statistics of the CA. Only
+// counts are included, no subjects, hosts or other personal data.
+type ApiResponseStats struct {
+	// Version of the CA
+	Version string `json:"version" example:"v1.2.3"`
+	// First day covered, in UTC
+	Since string `json:"since" example:"2024-01-01"`
+	// Certificates issued since then
+	Issuances int `json:"issuances" example:"1234"`
+	// Number of distinct hosts that certificates were issued for
+	UniqueHosts int `json:"unique_hosts" example:"42"`
+	// Number of distinct users that certificates were issued to
+	UniqueUsers int `json:"unique_users" example:"87"`
+	// Counts of each day, oldest first
+	Days []ApiResponseStatsDay `json:"days"`
+}
+
+type ApiResponseStatsDay struct {
+	Date        string `json:"date" example:"2024-01-01"`
+	Issuances   int    `json:"issuances" example:"41"`
+	UniqueHosts int    `json:"unique_hosts" example:"12"`
+}
+
+// Stats aggregates the certificates issued during the last STATS_DAYS days,
+// including today, into anonymous usage statistics. Days are in UTC.
+func Stats(store storage.Store, now time.Time) (ApiResponseStats, error) {
+	today := now.UTC().Truncate(24 * time.Hour)
+	since := today.AddDate(0, 0, -(STATS_DAYS - 1))
+
+	stats := ApiResponseStats{
+		Version: buildinfo.Get().Version,
+		Since:   since.Format(STATS_DATE_FORMAT),
+		Days:    make([]ApiResponseStatsDay, STATS_DAYS),
+	}
+
+	for i := range stats.Days {
+		stats.Days[i].Date = since.AddDate(0, 0, i).Format(STATS_DATE_FORMAT)
+	}
+
+	certs, err := store.ListCertificates(storage.CertificateFilter{})
+	if err != nil {
+		return stats, err
+	}
+
+	hosts := make(map[string]bool)
+	users := make(map[string]bool)
+	dayHosts := make([]map[string]bool, STATS_DAYS)
+
+	for _, cert := range certs {
+		issued := cert.IssuedAt.UTC()
+		if issued.Before(since) || !issued.Before(today.AddDate(0, 0, 1)) {
+			continue
+		}
+
+		day := int(issued.Sub(since) / (24 * time.Hour))
+		if dayHosts[day] == nil {
+			dayHosts[day] = make(map[string]bool)
+		}
+
+		stats.Issuances++
+		stats.Days[day].Issuances++
+		dayHosts[day][cert.Host] = true
+		hosts[cert.Host] = true
+		users[cert.Subject] = true
+	}
+
+	for i := range stats.Days {
+		stats.Days[i].UniqueHosts = len(dayHosts[i])
+	}
+
+	stats.UniqueHosts = len(hosts)
+	stats.UniqueUsers = len(users)
+
+	return stats, nil
+}
+
+// GetStats is the handler for GET /stats
+//
+//	@Summary		Get usage statistics
+//	@ID				getStats
+//	@Description	Return anonymous usage statistics of the last 30 days, such as the number of certificates issued per
+//	@Description	day and the number of distinct hosts. Only counts are included. The endpoint must be enabled with
+//	@Description	the usage-stats option, so operators can opt in to demonstrating adoption.
+//	@Produce		json
+//	@Success		200	{object}	ApiResponseStats
+//	@Failure		404	{object}	ApiResponseError
+//	@Failure		500	{object}	ApiResponseError
+//	@Router			/stats [get]
+func GetStats(c *gin.Context) {
+	conf := c.MustGet("config").(config.Config)
+	store := c.MustGet("store").(storage.Store)
+
+	if !conf.Server.UsageStats {
+		Error(c, http.StatusNotFound, ERR_NOT_FOUND)
+		return
+	}
+
+	stats, err := Stats(store, time.Now())
+	if err != nil {
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	store := storage.NewMemoryStore()
+	// Noon today, as the store prunes certificates relative to the clock
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	now := today.Add(12 * time.Hour)
+	date := func(days int) string {
+		return today.AddDate(0, 0, days).Format(STATS_DATE_FORMAT)
+	}
+
+	for _, cert := range []storage.Certificate{
+		{Host: "a.example.com", Subject: "alice", IssuedAt: now},
+		{Host: "a.example.com", Subject: "bob", IssuedAt: now.Add(-time.Hour)},
+		{Host: "b.example.com", Subject: "alice", IssuedAt: now.AddDate(0, 0, -1)},
+		{Host: "c.example.com", Subject: "alice", IssuedAt: now.AddDate(0, 0, -29).Add(-12 * time.Hour)},
+		// Too old
+		{Host: "d.example.com", Subject: "carol", IssuedAt: now.AddDate(0, 0, -30)},
+	} {
+		serial, _ := store.NextSerial()
+		cert.Serial = serial
+		cert.ValidBefore = now.Add(time.Hour)
+		assert.NoError(t, store.AddCertificate(cert))
+	}
+
+	stats, err := Stats(store, now)
+	assert.NoError(t, err)
+	assert.Equal(t, date(-29), stats.Since)
+	assert.Equal(t, 4, stats.Issuances)
+	assert.Equal(t, 3, stats.UniqueHosts)
+	assert.Equal(t, 2, stats.UniqueUsers)
+	assert.Len(t, stats.Days, STATS_DAYS)
+	assert.Equal(t, ApiResponseStatsDay{Date: date(-29), Issuances: 1, UniqueHosts: 1}, stats.Days[0])
+	assert.Equal(t, ApiResponseStatsDay{Date: date(-1), Issuances: 1, UniqueHosts: 1}, stats.Days[STATS_DAYS-2])
+	assert.Equal(t, ApiResponseStatsDay{Date: date(0), Issuances: 2, UniqueHosts: 1}, stats.Days[STATS_DAYS-1])
+
+	gin.SetMode(gin.TestMode)
+
+	get := func(conf config.Config) int {
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Set("config", conf)
+			c.Set("store", storage.Store(store))
+		})
+		router.GET("/stats", GetStats)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stats", nil))
+
+		return w.Code
+	}
+
+	// Statistics are opt-in
+	assert.Equal(t, http.StatusNotFound, get(config.Config{}))
+	assert.Equal(t, http.StatusOK, get(config.Config{Server: config.ServerOptions{UsageStats: true}}))
+}
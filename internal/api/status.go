@@ -0,0 +1,148 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/service"
+	"github.com/lbrocke/oinit/internal/tokenbind"
+	"github.com/lbrocke/oinit/internal/util"
+	"github.com/lbrocke/oinit/pkg/libmotleycue"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ApiResponseUserStatus is the state of the account of a user on a host.
+type ApiResponseUserStatus struct {
+	// State reported by motley_cue
+	State string `json:"state" example:"deployed" enums:"deployed,not_deployed,pending,rejected,suspended,limited,undefined"`
+	// Account that the user logs in as, only set if deployed
+	User string `json:"user,omitempty" example:"alice"`
+	// Message of motley_cue, such as why the account is suspended
+	Message string `json:"message,omitempty"`
+	// What users can do to be able to log in, unless deployed
+	Remediation *Remediation `json:"remediation,omitempty"`
+}
+
+// queriedStatuses contains the states of users queried with GetHostStatus by
+// token hash and host. Unlike userStatuses, all states are cached, as they
+// are only shown to users.
+var queriedStatuses = util.NewTimedCache[string, libmotleycue.ApiResponseUserStatus]()
+
+// queryUserStatus returns the state of the user of the access token on the
+// host without deploying the user. States are cached for the
+// status-cache-duration, but not beyond expiry of the token, and states of
+// deployed users cached by certificate requests are reused.
+func queryUserStatus(ctx context.Context, conf config.Config, info config.HostInfo, host, token string, expiry time.Time) (libmotleycue.ApiResponseUserStatus, error) {
+	key := tokenbind.Hash(token) + " " + host
+
+	if cached, ok := userStatuses.Get(key); ok {
+		return cached.status, nil
+	}
+
+	if status, ok := queriedStatuses.Get(key); ok {
+		return status, nil
+	}
+
+	status, _, err := withUpstream(ctx, info, func(ctx context.Context, client libmotleycue.Client) (libmotleycue.ApiResponseUserStatus, error) {
+		return client.GetUserStatusContext(ctx, token)
+	})
+	if err != nil {
+		return status, err
+	}
+
+	if duration := statusCacheDuration(conf, expiry); duration > 0 {
+		queriedStatuses.Prune()
+		queriedStatuses.Set(key, status, duration)
+	}
+
+	return status, nil
+}
+
+// GetHostStatus is the handler for GET /:host/status
+//
+//	@Summary		Get the state of the user's account
+//	@ID				getHostStatus
+//	@Description	Return the state of the account of the user of the access token on the host, as reported by
+//	@Description	motley_cue, without deploying it. Clients can show it before connecting, e.g. that the account is
+//	@Description	still pending. Unless deployed, a remediation tells users what they can do about it.
+//	@Description	States are cached for the status-cache-duration of the CA.
+//	@Produce		json
+//	@Param			host			path		string	true	"Host"	example("example.com")
+//	@Param			Authorization	header		string	true	"Bearer access token"
+//	@Success		200				{object}	ApiResponseUserStatus
+//	@Success		307				{object}	ApiResponsePeer
+//	@Failure		400				{object}	ApiResponseError
+//	@Failure		401				{object}	ApiResponseError
+//	@Failure		404				{object}	ApiResponseError
+//	@Failure		500				{object}	ApiResponseError
+//	@Failure		502				{object}	ApiResponseError
+//	@Failure		504				{object}	ApiResponseError
+//	@Router			/{host}/status [get]
+func GetHostStatus(c *gin.Context) {
+	var host UriHost
+
+	if c.ShouldBindUri(&host) != nil {
+		Error(c, http.StatusBadRequest, ERR_BAD_BODY)
+		return
+	}
+
+	host.Host = strings.ToLower(host.Host)
+
+	conf, ok := c.MustGet("config").(config.Config)
+	if !ok {
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	accessToken := bearerToken(c)
+	if accessToken == "" || len(accessToken) > MAX_TOKEN_LENGTH {
+		Error(c, http.StatusUnauthorized, ERR_UNAUTHORIZED)
+		return
+	}
+
+	svc, ok := newService(c)
+	if !ok {
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	info, status, err := svc.UserStatus(c.Request.Context(), host.Host, accessToken)
+	if err != nil {
+		if timedOut(c) {
+			return
+		}
+
+		switch {
+		case errors.Is(err, service.ErrHostNotFound):
+			if _, ok := redirectToPeer(c, conf, host.Host, "/status"); !ok {
+				Error(c, http.StatusNotFound, ERR_UNKNOWN_HOST)
+			}
+		case errors.Is(err, service.ErrUpstreamUnavailable):
+			Error(c, http.StatusBadGateway, ERR_GATEWAY_DOWN)
+		case errors.Is(err, service.ErrTokenRejected):
+			Denial(c, http.StatusUnauthorized, ERR_UNAUTHORIZED, info, userStateHint(status, err))
+		default:
+			serviceError(c, err)
+		}
+		return
+	}
+
+	res := ApiResponseUserStatus{
+		State:   string(status.State),
+		Message: status.Message,
+	}
+
+	if status.State == libmotleycue.StateDeployed {
+		res.User = status.Credentials.SSHUser
+	} else if hint := userStateHint(status, nil); hint != "" {
+		r := remediation(c, info, hint)
+		res.Remediation = &r
+	}
+
+	c.JSON(http.StatusOK, res)
+}
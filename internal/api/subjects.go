@@ -0,0 +1,30 @@
+package api
+
+import (
+	"github.com/lbrocke/oinit/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	ERR_SUBJECT_DENIED = "subject_denied"
+)
+
+// subjectPolicy reports whether the subject of the token may request
+// certificates for the host according to the subject-allow and subject-deny
+// lists of its hostgroup. Tokens without a subject only pass if there are no
+// lists.
+func subjectPolicy(info config.HostInfo, token *jwt.Token) (bool, error) {
+	if info.SubjectAllow == nil && info.SubjectDeny == nil {
+		return true, nil
+	}
+
+	sub, err := token.Claims.GetSubject()
+	if err != nil || sub == "" {
+		return false, nil
+	}
+
+	iss, _ := token.Claims.GetIssuer()
+
+	return info.SubjectAllowed(sub, iss)
+}
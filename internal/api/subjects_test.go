@@ -0,0 +1,40 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/subjects"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubjectPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deny")
+	assert.NoError(t, os.WriteFile(path, []byte("mallory\n"), 0600))
+
+	deny, err := subjects.Open(path)
+	assert.NoError(t, err)
+
+	alice := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"sub": "alice", "iss": "https://idp.example.com"})
+	mallory := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"sub": "mallory", "iss": "https://idp.example.com"})
+	anonymous := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{})
+
+	for _, test := range []struct {
+		info    config.HostInfo
+		token   *jwt.Token
+		allowed bool
+	}{
+		{config.HostInfo{}, anonymous, true},
+		{config.HostInfo{SubjectDeny: deny}, alice, true},
+		{config.HostInfo{SubjectDeny: deny}, mallory, false},
+		{config.HostInfo{SubjectDeny: deny}, anonymous, false},
+	} {
+		allowed, err := subjectPolicy(test.info, test.token)
+		assert.NoError(t, err)
+		assert.Equal(t, test.allowed, allowed)
+	}
+}
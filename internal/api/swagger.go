@@ -3,16 +3,25 @@ package api
 import (
 	"net/http"
 
+	"github.com/lbrocke/oinit/api/docs"
+
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 )
 
 func GetSwagger(c *gin.Context) {
-	if c.Param("any") == "/" {
-		c.Redirect(http.StatusMovedPermanently, "/api/docs/index.html")
-		return
-	}
+	switch c.Param("any") {
+	case "/":
+		c.Redirect(http.StatusMovedPermanently, basePath(c)+"/api/docs/index.html")
+	case "/doc.json":
+		// The base path of the API depends on the path the client reached
+		// the CA at
+		spec := *docs.SwaggerInfo
+		spec.BasePath = basePath(c) + spec.BasePath
 
-	ginSwagger.WrapHandler(swaggerFiles.Handler)(c)
+		c.Data(http.StatusOK, "application/json; charset=utf-8", []byte(spec.ReadDoc()))
+	default:
+		ginSwagger.WrapHandler(swaggerFiles.Handler)(c)
+	}
 }
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Duration since the last failure after which failed authentications
+	// are forgotten
+	TARPIT_WINDOW = 15 * time.Minute
+)
+
+// failures counts failed authentications of a client address or subject.
+type failures struct {
+	count   int
+	last    time.Time
+	alerted bool
+}
+
+// tarpit tracks failed authentications, so clients cycling through guessed
+// tokens are slowed down rather than answered at full speed.
+type tarpit struct {
+	mu       sync.Mutex
+	failures map[string]*failures
+	pruned   time.Time
+}
+
+// failedAuth is shared by the tarpit middleware of all listeners, so
+// switching listeners doesn't reset the delay.
+var failedAuth = &tarpit{failures: make(map[string]*failures)}
+
+// get returns the unexpired failures of key, or nil.
+func (t *tarpit) get(key string, now time.Time) *failures {
+	if now.Sub(t.pruned) > time.Minute {
+		for k, f := range t.failures {
+			if now.Sub(f.last) > TARPIT_WINDOW {
+				delete(t.failures, k)
+			}
+		}
+		t.pruned = now
+	}
+
+	f, ok := t.failures[key]
+	if !ok || now.Sub(f.last) > TARPIT_WINDOW {
+		return nil
+	}
+
+	return f
+}
+
+// delay returns how long requests of key are delayed: not at all for up to
+// threshold failures, then doubling from one second with each further
+// failure up to maxDelay.
+func (t *tarpit) delay(key string, threshold int, maxDelay time.Duration, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	f := t.get(key, now)
+	if f == nil || f.count < threshold {
+		return 0
+	}
+
+	// Shifting further would overflow
+	if f.count-threshold >= 32 {
+		return maxDelay
+	}
+
+	if delay := time.Second << (f.count - threshold); delay < maxDelay {
+		return delay
+	}
+
+	return maxDelay
+}
+
+// fail records a failed authentication of key and returns the number of
+// failures within the window. The second value is true once the failures
+// reach alert, and only then.
+func (t *tarpit) fail(key string, alert int, now time.Time) (int, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	f := t.get(key, now)
+	if f == nil {
+		f = &failures{}
+		t.failures[key] = f
+	}
+
+	f.count++
+	f.last = now
+
+	if f.count >= alert && !f.alerted {
+		f.alerted = true
+		return f.count, true
+	}
+
+	return f.count, false
+}
+
+// reset forgets the failures of key after a successful authentication.
+func (t *tarpit) reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.failures, key)
+}
+
+// stats returns the number of client addresses and subjects whose requests
+// are delayed and the number of those that raised an alert.
+func (t *tarpit) stats(threshold int, now time.Time) (int, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var delayed, alerted int
+	for _, f := range t.failures {
+		if now.Sub(f.last) > TARPIT_WINDOW {
+			continue
+		}
+
+		if f.count >= threshold {
+			delayed++
+		}
+
+		if f.alerted {
+			alerted++
+		}
+	}
+
+	return delayed, alerted
+}
+
+// Tarpit returns a middleware that delays requests of client addresses that
+// recently failed to authenticate (401 Unauthorized) threshold times,
+// starting with one second and doubling the delay with each further failure up to maxDelay seconds.
+// Failures are also counted per subject, if the handler set one, and an
+// alert is logged once a client address or subject fails alert times within
+// TARPIT_WINDOW. Successful requests reset the failures.
+func Tarpit(threshold, maxDelay, alert int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		client := "client " + c.ClientIP()
+
+		if delay := failedAuth.delay(client, threshold, time.Duration(maxDelay)*time.Second, time.Now()); delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-c.Request.Context().Done():
+				timer.Stop()
+				c.Abort()
+				return
+			}
+		}
+
+		c.Next()
+
+		keys := []string{client}
+		if subject := c.GetString("subject"); subject != "" {
+			keys = append(keys, "subject "+subject)
+		}
+
+		status := c.Writer.Status()
+
+		for _, key := range keys {
+			if status == http.StatusUnauthorized {
+				if count, alerted := failedAuth.fail(key, alert, time.Now()); alerted {
+					Logger.Printf("ALERT: %s failed to authenticate %d times within %s, possible token guessing", key, count, TARPIT_WINDOW)
+				}
+			} else if status < http.StatusBadRequest {
+				failedAuth.reset(key)
+			}
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTarpit(t *testing.T) {
+	pit := &tarpit{failures: make(map[string]*failures)}
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		assert.Zero(t, pit.delay("client 192.0.2.1", 3, 10*time.Second, now))
+		pit.fail("client 192.0.2.1", 5, now)
+	}
+
+	_, alerted := pit.fail("client 192.0.2.1", 5, now)
+	assert.False(t, alerted)
+	assert.Equal(t, time.Second, pit.delay("client 192.0.2.1", 3, 10*time.Second, now))
+
+	pit.fail("client 192.0.2.1", 5, now)
+	assert.Equal(t, 2*time.Second, pit.delay("client 192.0.2.1", 3, 10*time.Second, now))
+
+	// Alerts are raised once
+	count, alerted := pit.fail("client 192.0.2.1", 5, now)
+	assert.Equal(t, 5, count)
+	assert.True(t, alerted)
+	_, alerted = pit.fail("client 192.0.2.1", 5, now)
+	assert.False(t, alerted)
+
+	for i := 0; i < 40; i++ {
+		pit.fail("client 192.0.2.1", 5, now)
+	}
+	assert.Equal(t, 10*time.Second, pit.delay("client 192.0.2.1", 3, 10*time.Second, now))
+
+	delayed, alerts := pit.stats(3, now)
+	assert.Equal(t, 1, delayed)
+	assert.Equal(t, 1, alerts)
+
+	// Other clients are not affected, failures expire and are reset
+	assert.Zero(t, pit.delay("client 192.0.2.2", 3, 10*time.Second, now))
+	assert.Zero(t, pit.delay("client 192.0.2.1", 3, 10*time.Second, now.Add(TARPIT_WINDOW+time.Second)))
+
+	pit.reset("client 192.0.2.1")
+	assert.Zero(t, pit.delay("client 192.0.2.1", 3, 10*time.Second, now))
+
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(Tarpit(1, 1, 2))
+	router.GET("/", func(c *gin.Context) {
+		c.Set("subject", "mallory@https://issuer")
+		c.Status(http.StatusUnauthorized)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	start := time.Now()
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+
+	assert.NotNil(t, failedAuth.get("subject mallory@https://issuer", time.Now()))
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ERR_TIMEOUT = "timeout"
+)
+
+// Timeout returns a middleware that limits the time spent on each request.
+// The deadline is attached to the context of the request, which is passed on
+// to lookups, motley_cue and userinfo requests, so a hung upstream can't hold
+// a handler indefinitely.
+func Timeout(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// timedOut responds with 504 Gateway Timeout and returns true if the deadline
+// of the request has been exceeded.
+func timedOut(c *gin.Context) bool {
+	if c.Request.Context().Err() == nil {
+		return false
+	}
+
+	Error(c, http.StatusGatewayTimeout, ERR_TIMEOUT)
+
+	return true
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// motley_cue instance that never responds
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer hung.Close()
+
+	conf := config.Config{
+		HostGroups: []config.HostGroup{
+			{
+				Name:  "example.com",
+				Hosts: map[string]string{"hung.example.com": hung.URL},
+			},
+		},
+	}
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("config", conf) })
+	router.Use(Timeout(100 * time.Millisecond))
+	router.GET("/:host", GetHost)
+
+	start := time.Now()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/hung.example.com", nil))
+
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+
+	var res ApiResponseError
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, ERR_TIMEOUT, res.Code)
+
+	// Timeouts of the request are not failures of the instance.
+	_, down := downUpstreams.Get(hung.URL)
+	assert.False(t, down)
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	HEADER_SERVER_TIMING = "Server-Timing"
+
+	// Phases of certificate requests reported in the Server-Timing header
+	TIMING_CONFIG   = "config"   // lookup of the host in the config
+	TIMING_UPSTREAM = "upstream" // authorization by motley_cue
+	TIMING_SIGN     = "sign"     // signing of the certificate
+	// Duration of the request until the response was written
+	TIMING_TOTAL = "total"
+)
+
+// timingDescriptions contains the descriptions of the phases.
+var timingDescriptions = map[string]string{
+	TIMING_CONFIG:   "config lookup",
+	TIMING_UPSTREAM: "upstream auth",
+	TIMING_SIGN:     "signing",
+	TIMING_TOTAL:    "total",
+}
+
+// serverTiming collects the durations of the phases of a request.
+type serverTiming struct {
+	mu      sync.Mutex
+	start   time.Time
+	names   []string
+	metrics map[string]time.Duration
+}
+
+// add adds the duration to the phase, which may be measured several times.
+func (t *serverTiming) add(name string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.metrics[name]; !ok {
+		t.names = append(t.names, name)
+	}
+
+	t.metrics[name] += d
+}
+
+// header returns the value of the Server-Timing header, durations are given
+// in milliseconds.
+func (t *serverTiming) header(now time.Time) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	metrics := []string{}
+	for _, name := range t.names {
+		metrics = append(metrics, timingMetric(name, t.metrics[name]))
+	}
+
+	metrics = append(metrics, timingMetric(TIMING_TOTAL, now.Sub(t.start)))
+
+	return strings.Join(metrics, ", ")
+}
+
+func timingMetric(name string, d time.Duration) string {
+	return fmt.Sprintf("%s;desc=%q;dur=%.3f", name, timingDescriptions[name], float64(d.Microseconds())/1000)
+}
+
+// timingWriter sets the Server-Timing header right before the response is
+// written, when all phases have been measured.
+type timingWriter struct {
+	gin.ResponseWriter
+	timing *serverTiming
+}
+
+func (w *timingWriter) setHeader() {
+	if !w.Written() {
+		w.Header().Set(HEADER_SERVER_TIMING, w.timing.header(time.Now()))
+	}
+}
+
+func (w *timingWriter) WriteHeader(code int) {
+	w.setHeader()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *timingWriter) WriteHeaderNow() {
+	w.setHeader()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *timingWriter) Write(data []byte) (int, error) {
+	w.setHeader()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *timingWriter) WriteString(s string) (int, error) {
+	w.setHeader()
+	return w.ResponseWriter.WriteString(s)
+}
+
+// ServerTiming returns a middleware that reports how long certificate
+// requests spent looking up the host, waiting for motley_cue and signing in
+// a Server-Timing header, so that latency can be investigated without
+// tracing infrastructure. It reveals internals and is meant for debugging and
+// staging deployments.
+func ServerTiming() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timing := &serverTiming{
+			start:   time.Now(),
+			metrics: make(map[string]time.Duration),
+		}
+
+		c.Set("server_timing", timing)
+		c.Writer = &timingWriter{ResponseWriter: c.Writer, timing: timing}
+
+		c.Next()
+	}
+}
+
+// startTiming starts measuring the phase of the request and returns the
+// function that stops it. Nothing is measured if the server-timing middleware
+// is disabled.
+func startTiming(c *gin.Context, name string) func() {
+	value, _ := c.Get("server_timing")
+	timing, ok := value.(*serverTiming)
+	if !ok {
+		return func() {}
+	}
+
+	start := time.Now()
+
+	return func() {
+		timing.add(name, time.Since(start))
+	}
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerTiming(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handler := func(c *gin.Context) {
+		for _, name := range []string{TIMING_CONFIG, TIMING_UPSTREAM, TIMING_UPSTREAM} {
+			stop := startTiming(c, name)
+			stop()
+		}
+
+		c.JSON(http.StatusOK, gin.H{})
+	}
+
+	router := gin.New()
+	router.GET("/", handler)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Empty(t, w.Header().Get(HEADER_SERVER_TIMING))
+
+	router = gin.New()
+	router.Use(ServerTiming())
+	router.GET("/", handler)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Regexp(t, `^config;desc="config lookup";dur=[0-9.]+, upstream;desc="upstream auth";dur=[0-9.]+, total;desc="total";dur=[0-9.]+$`, w.Header().Get(HEADER_SERVER_TIMING))
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/sshutil"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/ssh"
+)
+
+type ApiResponseTrustBundle struct {
+	KnownHosts []string `json:"known_hosts"`
+}
+
+// GetTrustBundle is the handler for GET /trust-bundle
+//
+//	@Summary		Get host CA trust bundle
+//	@ID				getTrustBundle
+//	@Description	Return @cert-authority lines for all hosts served by this CA and its peer CAs, suitable for
+//	@Description	known_hosts files.
+//	@Produce		json
+//	@Success		200	{object}	ApiResponseTrustBundle
+//	@Failure		500	{object}	ApiResponseError
+//	@Router			/trust-bundle [get]
+func GetTrustBundle(c *gin.Context) {
+	conf, ok := c.MustGet("config").(config.Config)
+	if !ok {
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	c.JSON(http.StatusOK, ApiResponseTrustBundle{
+		KnownHosts: trustBundle(conf),
+	})
+}
+
+// trustBundle returns sorted @cert-authority known_hosts lines for all hosts
+// (including wildcard hosts) of all host groups and peers.
+func trustBundle(conf config.Config) []string {
+	lines := []string{}
+
+	for _, group := range conf.HostGroups {
+		pubkey := strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(group.HostCAPublicKey)), "\n")
+
+		for host := range group.Hosts {
+			line, err := sshutil.GenerateKnownHosts(strings.ToLower(host), "22", pubkey)
+			if err != nil {
+				continue
+			}
+
+			lines = append(lines, line)
+		}
+	}
+
+	for _, peer := range conf.Peers {
+		pubkey := strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(peer.HostCAPublicKey)), "\n")
+
+		for _, host := range peer.HostPatterns {
+			line, err := sshutil.GenerateKnownHosts(host, "22", pubkey)
+			if err != nil {
+				continue
+			}
+
+			lines = append(lines, line)
+		}
+	}
+
+	sort.Strings(lines)
+
+	return lines
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/buildinfo"
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/fault"
+	"github.com/lbrocke/oinit/internal/tokenbind"
+	"github.com/lbrocke/oinit/internal/util"
+	"github.com/lbrocke/oinit/pkg/libmotleycue"
+)
+
+const (
+	// Duration (in seconds) that an unavailable motley_cue instance is
+	// skipped in favor of its replicas
+	UPSTREAM_DOWN_DURATION = 30
+)
+
+// downUpstreams contains motley_cue instances that recently failed.
+var downUpstreams = util.NewTimedCache[string, bool]()
+
+// cachedStatus is the state of a deployed user along with the motley_cue
+// instance that reported it.
+type cachedStatus struct {
+	status   libmotleycue.ApiResponseUserStatus
+	upstream string
+}
+
+// userStatuses contains the states of deployed users by token hash and host.
+var userStatuses = util.NewTimedCache[string, cachedStatus]()
+
+// statusCacheDuration returns the seconds that states of users are cached:
+// the status-cache-duration, but not beyond expiry of the token if it is
+// set. Zero means the state must not be cached.
+func statusCacheDuration(conf config.Config, expiry time.Time) time.Duration {
+	duration := time.Duration(conf.Server.StatusCacheDuration) * time.Second
+	if !expiry.IsZero() && time.Until(expiry) < duration {
+		duration = time.Until(expiry)
+	}
+
+	if seconds := int(duration.Seconds()); seconds > 0 {
+		return time.Duration(seconds)
+	}
+
+	return 0
+}
+
+// motleyCueClient returns a client for the motley_cue instance at url that
+// identifies the CA by its version and signs requests if key is set.
+func motleyCueClient(url string, key []byte) libmotleycue.Client {
+	return libmotleycue.NewClient(url,
+		libmotleycue.WithUserAgent("oinit-ca/"+buildinfo.Get().Version),
+		libmotleycue.WithSigningKey(key),
+	)
+}
+
+// upstreams returns the motley_cue instances of the host in the order they
+// should be tried: available instances in configured order, followed by
+// instances that recently failed as a last resort.
+func upstreams(info config.HostInfo) []string {
+	urls := info.URLs
+	if len(urls) == 0 {
+		urls = []string{info.URL}
+	}
+
+	var up, down []string
+	for _, url := range urls {
+		if _, ok := downUpstreams.Get(url); ok {
+			down = append(down, url)
+		} else {
+			up = append(up, url)
+		}
+	}
+
+	return append(up, down...)
+}
+
+// withUpstream calls fn with a client for each motley_cue instance of the
+// host until one is available, and returns its result along with the URL of
+// the instance. Instances that are unavailable are skipped for
+// UPSTREAM_DOWN_DURATION. No further instances are tried once ctx is done.
+// fn must make its requests with the context it is passed, which records
+// the health of the instance, see upstreamMonitor.
+func withUpstream[T any](ctx context.Context, info config.HostInfo, fn func(context.Context, libmotleycue.Client) (T, error)) (T, string, error) {
+	var res T
+	var err error
+	var url string
+
+	for _, url = range upstreams(info) {
+		if err = fault.Inject(ctx, fault.TARGET_UPSTREAM); err != nil {
+			// Injected faults look like a failing instance
+			if errors.Is(err, fault.ErrInjected) {
+				err = fmt.Errorf("%s: %w", err, libmotleycue.StatusError{StatusCode: http.StatusServiceUnavailable})
+			}
+		} else {
+			start := time.Now()
+			res, err = fn(upstreamHealth.trace(ctx, url), motleyCueClient(url, info.MotleyCueKey))
+
+			// Aborted requests say nothing about the instance
+			if ctx.Err() == nil {
+				upstreamHealth.observe(url, time.Since(start), err, time.Now())
+			}
+		}
+
+		if !libmotleycue.Unavailable(err) || ctx.Err() != nil {
+			return res, url, err
+		}
+
+		if _, ok := downUpstreams.Get(url); !ok {
+			Logger.Printf("motley_cue %s is unavailable: %s", url, err)
+		}
+
+		downUpstreams.Set(url, true, UPSTREAM_DOWN_DURATION)
+	}
+
+	return res, url, err
+}
+
+// deployUser deploys the user of the access token on the host and returns
+// the state reported by motley_cue along with the URL of the instance. The
+// states of deployed users are cached for the status-cache-duration, but not
+// beyond expiry, so a burst of requests with the same token only reaches
+// motley_cue once. The third value reports whether the state was cached.
+func deployUser(ctx context.Context, conf config.Config, info config.HostInfo, host, token string, expiry time.Time) (libmotleycue.ApiResponseUserStatus, string, bool, error) {
+	key := tokenbind.Hash(token) + " " + host
+
+	if cached, ok := userStatuses.Get(key); ok {
+		return cached.status, cached.upstream, true, nil
+	}
+
+	status, upstream, err := withUpstream(ctx, info, func(ctx context.Context, client libmotleycue.Client) (libmotleycue.ApiResponseUserStatus, error) {
+		return client.GetUserDeployContext(ctx, token)
+	})
+
+	// Only cache users that may log in, so that fixed problems don't
+	// persist.
+	if err == nil && status.State == libmotleycue.StateDeployed {
+		if duration := statusCacheDuration(conf, expiry); duration > 0 {
+			userStatuses.Prune()
+			userStatuses.Set(key, cachedStatus{status, upstream}, duration)
+		}
+	}
+
+	return status, upstream, false, err
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/fault"
+	"github.com/lbrocke/oinit/internal/mockmotleycue"
+	"github.com/lbrocke/oinit/pkg/libmotleycue"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithUpstream(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	mock := mockmotleycue.New()
+	mock.AddUser(TEST_TOKEN, mockmotleycue.User{SSHUser: "alice", State: libmotleycue.StateDeployed})
+
+	replica := httptest.NewServer(mock)
+	defer replica.Close()
+
+	info := config.HostInfo{URLs: config.SplitURLs(down.URL + ", " + replica.URL)}
+
+	deploy := func(ctx context.Context, client libmotleycue.Client) (libmotleycue.ApiResponseUserStatus, error) {
+		return client.GetUserDeployContext(ctx, TEST_TOKEN)
+	}
+
+	status, url, err := withUpstream(context.Background(), info, deploy)
+	assert.NoError(t, err)
+	assert.Equal(t, replica.URL, url)
+	assert.Equal(t, "alice", status.Credentials.SSHUser)
+
+	// The failed instance is now tried last.
+	assert.Equal(t, []string{replica.URL, down.URL}, upstreams(info))
+
+	// Errors about the token are not retried with other instances.
+	_, url, err = withUpstream(context.Background(), info, func(ctx context.Context, client libmotleycue.Client) (libmotleycue.ApiResponseUserStatus, error) {
+		return client.GetUserDeployContext(ctx, "unknown")
+	})
+	assert.Error(t, err)
+	assert.False(t, libmotleycue.Unavailable(err))
+	assert.Equal(t, replica.URL, url)
+
+	// Injected faults make instances unavailable without contacting them.
+	ctx := fault.WithInjector(context.Background(), &fault.Injector{ErrorRate: 1, Targets: []string{fault.TARGET_UPSTREAM}})
+	_, _, err = withUpstream(ctx, info, func(ctx context.Context, client libmotleycue.Client) (libmotleycue.ApiResponseUserStatus, error) {
+		t.Fatal("upstream contacted despite injected fault")
+		return libmotleycue.ApiResponseUserStatus{}, nil
+	})
+	assert.True(t, libmotleycue.Unavailable(err))
+
+	// If all instances are unavailable, the last error is returned.
+	replica.Close()
+	_, _, err = withUpstream(context.Background(), info, deploy)
+	assert.True(t, libmotleycue.Unavailable(err))
+}
+
+func TestDeployUser(t *testing.T) {
+	mock := mockmotleycue.New()
+	mock.AddUser(TEST_TOKEN, mockmotleycue.User{SSHUser: "alice", State: libmotleycue.StateDeployed})
+
+	srv := httptest.NewServer(mock)
+	defer srv.Close()
+
+	conf := config.Config{Server: config.ServerOptions{StatusCacheDuration: 10}}
+	info := config.HostInfo{URLs: config.SplitURLs(srv.URL)}
+	expiry := time.Now().Add(time.Hour)
+
+	status, url, cached, err := deployUser(context.Background(), conf, info, "cache.example.com", TEST_TOKEN, expiry)
+	assert.NoError(t, err)
+	assert.False(t, cached)
+	assert.Equal(t, srv.URL, url)
+	assert.Equal(t, "alice", status.Credentials.SSHUser)
+
+	// A burst of requests reaches motley_cue only once per host.
+	status, url, cached, err = deployUser(context.Background(), conf, info, "cache.example.com", TEST_TOKEN, expiry)
+	assert.NoError(t, err)
+	assert.True(t, cached)
+	assert.Equal(t, srv.URL, url)
+	assert.Equal(t, "alice", status.Credentials.SSHUser)
+	assert.Equal(t, 1, mock.Deploys())
+
+	_, _, cached, _ = deployUser(context.Background(), conf, info, "other.example.com", TEST_TOKEN, expiry)
+	assert.False(t, cached)
+	assert.Equal(t, 2, mock.Deploys())
+
+	// States are neither cached beyond expiry nor if caching is disabled.
+	_, _, _, _ = deployUser(context.Background(), conf, info, "expiring.example.com", TEST_TOKEN, time.Now())
+	_, _, cached, _ = deployUser(context.Background(), conf, info, "expiring.example.com", TEST_TOKEN, time.Now())
+	assert.False(t, cached)
+
+	conf.Server.StatusCacheDuration = -1
+	_, _, _, _ = deployUser(context.Background(), conf, info, "disabled.example.com", TEST_TOKEN, expiry)
+	_, _, cached, _ = deployUser(context.Background(), conf, info, "disabled.example.com", TEST_TOKEN, expiry)
+	assert.False(t, cached)
+}
+
+func TestMotleyCueClient(t *testing.T) {
+	key := []byte("secret")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.True(t, strings.HasPrefix(r.Header.Get("User-Agent"), "oinit-ca/"))
+
+		timestamp := r.Header.Get(libmotleycue.HEADER_TIMESTAMP)
+		unix, err := strconv.ParseInt(timestamp, 10, 64)
+		assert.NoError(t, err)
+		assert.WithinDuration(t, time.Now(), time.Unix(unix, 0), time.Minute)
+
+		message := libmotleycue.SignatureMessage(r.Method, r.URL.RequestURI(), timestamp, "Bearer "+TEST_TOKEN)
+		assert.Equal(t, libmotleycue.Signature(key, message), r.Header.Get(libmotleycue.HEADER_SIGNATURE))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"state": "deployed", "credentials": {"ssh_user": "alice"}}`))
+	}))
+	defer srv.Close()
+
+	status, err := motleyCueClient(srv.URL, key).GetUserDeploy(TEST_TOKEN)
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", status.Credentials.SSHUser)
+
+	// Without a key, requests are not signed
+	unsigned := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get(libmotleycue.HEADER_SIGNATURE))
+		w.Write([]byte(`{}`))
+	}))
+	defer unsigned.Close()
+
+	_, err = motleyCueClient(unsigned.URL, nil).GetInfo()
+	assert.NoError(t, err)
+}
+
+func TestStatusCacheDuration(t *testing.T) {
+	conf := config.Config{Server: config.ServerOptions{StatusCacheDuration: 60}}
+
+	assert.Equal(t, time.Duration(60), statusCacheDuration(conf, time.Time{}))
+	assert.Equal(t, time.Duration(60), statusCacheDuration(conf, time.Now().Add(time.Hour)))
+	assert.InDelta(t, 30, int(statusCacheDuration(conf, time.Now().Add(30*time.Second))), 1)
+	assert.Equal(t, time.Duration(0), statusCacheDuration(conf, time.Now().Add(-time.Second)))
+}
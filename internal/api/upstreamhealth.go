@@ -0,0 +1,273 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http/httptrace"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/metrics"
+	"github.com/lbrocke/oinit/pkg/libmotleycue"
+)
+
+const (
+	// Number of recent requests to each motley_cue instance that error
+	// rates and latency percentiles are computed from
+	UPSTREAM_SAMPLES = 100
+
+	// States of the circuit breaker of motley_cue instances: closed
+	// instances are tried in order, open ones are skipped for
+	// UPSTREAM_DOWN_DURATION after they failed
+	BREAKER_CLOSED = "closed"
+	BREAKER_OPEN   = "open"
+)
+
+// latencyBuckets are the upper bounds (in seconds) of the latency histogram
+// of requests to motley_cue.
+var latencyBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var upstreamLatency = metrics.NewHistogram(
+	"oinit_ca_upstream_request_duration_seconds",
+	"Duration of requests to motley_cue instances in seconds.",
+	"upstream",
+	latencyBuckets,
+)
+
+// upstreamSample is the outcome of a request to a motley_cue instance.
+type upstreamSample struct {
+	latency time.Duration
+	failed  bool
+}
+
+// upstreamStats are the statistics of requests to a motley_cue instance since
+// the CA started.
+type upstreamStats struct {
+	requests    uint64
+	failures    uint64
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastError   string
+	// Connections of the pool of the HTTP client that were opened for
+	// requests, and that were reused
+	newConns    uint64
+	reusedConns uint64
+	// Ring buffer of the last UPSTREAM_SAMPLES requests
+	samples []upstreamSample
+	next    int
+}
+
+// upstreamMonitor collects the statistics of all motley_cue instances.
+type upstreamMonitor struct {
+	mu    sync.Mutex
+	stats map[string]*upstreamStats
+}
+
+var upstreamHealth = &upstreamMonitor{stats: make(map[string]*upstreamStats)}
+
+// trace returns ctx with a trace that records whether requests to the
+// instance reused a pooled connection.
+func (m *upstreamMonitor) trace(ctx context.Context, url string) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+
+			s := m.get(url)
+			if info.Reused {
+				s.reusedConns++
+			} else {
+				s.newConns++
+			}
+		},
+	})
+}
+
+// get returns the statistics of the instance. m.mu must be held.
+func (m *upstreamMonitor) get(url string) *upstreamStats {
+	s, ok := m.stats[url]
+	if !ok {
+		s = &upstreamStats{}
+		m.stats[url] = s
+	}
+
+	return s
+}
+
+// observe records a request to the instance. Only errors meaning that the
+// instance is unavailable count as failures, not those about the user.
+func (m *upstreamMonitor) observe(url string, latency time.Duration, err error, now time.Time) {
+	failed := libmotleycue.Unavailable(err)
+
+	upstreamLatency.Observe(url, latency.Seconds())
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.get(url)
+	s.requests++
+
+	if failed {
+		s.failures++
+		s.lastFailure = now
+		s.lastError = err.Error()
+	} else {
+		s.lastSuccess = now
+	}
+
+	sample := upstreamSample{latency: latency, failed: failed}
+	if len(s.samples) < UPSTREAM_SAMPLES {
+		s.samples = append(s.samples, sample)
+	} else {
+		s.samples[s.next] = sample
+		s.next = (s.next + 1) % UPSTREAM_SAMPLES
+	}
+}
+
+type ApiResponseUpstreamHealth struct {
+	// State of the circuit breaker, "closed" or "open" if the instance is
+	// skipped in favor of its replicas after it recently failed
+	CircuitBreaker string     `json:"circuit_breaker" example:"closed"`
+	LastSuccess    *time.Time `json:"last_success,omitempty"`
+	LastFailure    *time.Time `json:"last_failure,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	// Requests and failures since the CA started
+	Requests uint64 `json:"requests"`
+	Failures uint64 `json:"failures"`
+	// Share of failed requests and latency percentiles (in milliseconds)
+	// of the last 100 requests
+	ErrorRate    float64 `json:"error_rate" example:"0.02"`
+	LatencyP50Ms float64 `json:"latency_p50_ms"`
+	LatencyP90Ms float64 `json:"latency_p90_ms"`
+	LatencyP99Ms float64 `json:"latency_p99_ms"`
+	// Connections opened for requests and reused from the pool
+	NewConnections    uint64 `json:"new_connections"`
+	ReusedConnections uint64 `json:"reused_connections"`
+}
+
+// breakerState returns the state of the circuit breaker of the instance.
+func breakerState(url string) string {
+	if _, ok := downUpstreams.Get(url); ok {
+		return BREAKER_OPEN
+	}
+
+	return BREAKER_CLOSED
+}
+
+// health returns the health of the instance.
+func (m *upstreamMonitor) health(url string) ApiResponseUpstreamHealth {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	health := ApiResponseUpstreamHealth{CircuitBreaker: breakerState(url)}
+
+	s, ok := m.stats[url]
+	if !ok {
+		return health
+	}
+
+	health.Requests = s.requests
+	health.Failures = s.failures
+	health.LastError = s.lastError
+	health.NewConnections = s.newConns
+	health.ReusedConnections = s.reusedConns
+
+	if !s.lastSuccess.IsZero() {
+		lastSuccess := s.lastSuccess
+		health.LastSuccess = &lastSuccess
+	}
+
+	if !s.lastFailure.IsZero() {
+		lastFailure := s.lastFailure
+		health.LastFailure = &lastFailure
+	}
+
+	latencies := make([]time.Duration, len(s.samples))
+	failed := 0
+	for i, sample := range s.samples {
+		latencies[i] = sample.latency
+		if sample.failed {
+			failed++
+		}
+	}
+
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+		health.ErrorRate = float64(failed) / float64(len(latencies))
+		health.LatencyP50Ms = percentile(latencies, 0.5)
+		health.LatencyP90Ms = percentile(latencies, 0.9)
+		health.LatencyP99Ms = percentile(latencies, 0.99)
+	}
+
+	return health
+}
+
+// percentile returns the p-th percentile of the sorted latencies in
+// milliseconds, using the nearest-rank method.
+func percentile(sorted []time.Duration, p float64) float64 {
+	rank := int(p*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	} else if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+
+	return float64(sorted[rank].Microseconds()) / 1000
+}
+
+// urls returns the URLs of all instances that requests were made to.
+func (m *upstreamMonitor) urls() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	urls := make([]string, 0, len(m.stats))
+	for url := range m.stats {
+		urls = append(urls, url)
+	}
+
+	sort.Strings(urls)
+
+	return urls
+}
+
+// writeMetrics writes the metrics of all instances that requests were made
+// to.
+func (m *upstreamMonitor) writeMetrics(w io.Writer) {
+	up := make(map[string]float64)
+	requests := make(map[string]float64)
+	failures := make(map[string]float64)
+	errorRate := make(map[string]float64)
+	lastSuccess := make(map[string]float64)
+	newConns := make(map[string]float64)
+	reusedConns := make(map[string]float64)
+
+	for _, url := range m.urls() {
+		health := m.health(url)
+
+		up[url] = 1
+		if health.CircuitBreaker == BREAKER_OPEN {
+			up[url] = 0
+		}
+
+		requests[url] = float64(health.Requests)
+		failures[url] = float64(health.Failures)
+		errorRate[url] = health.ErrorRate
+		newConns[url] = float64(health.NewConnections)
+		reusedConns[url] = float64(health.ReusedConnections)
+
+		if health.LastSuccess != nil {
+			lastSuccess[url] = float64(health.LastSuccess.Unix())
+		}
+	}
+
+	metrics.WriteGauge(w, "oinit_ca_upstream_up", "Whether the circuit breaker of the motley_cue instance is closed (1) or open (0).", "upstream", up)
+	metrics.WriteCounter(w, "oinit_ca_upstream_requests_total", "Requests to the motley_cue instance.", "upstream", requests)
+	metrics.WriteCounter(w, "oinit_ca_upstream_failures_total", "Requests to the motley_cue instance that failed because it was unavailable.", "upstream", failures)
+	metrics.WriteGauge(w, "oinit_ca_upstream_error_rate", "Share of the last 100 requests to the motley_cue instance that failed.", "upstream", errorRate)
+	metrics.WriteGauge(w, "oinit_ca_upstream_last_success_timestamp_seconds", "Time of the last successful request to the motley_cue instance.", "upstream", lastSuccess)
+	metrics.WriteCounter(w, "oinit_ca_upstream_connections_new_total", "Connections opened for requests to the motley_cue instance.", "upstream", newConns)
+	metrics.WriteCounter(w, "oinit_ca_upstream_connections_reused_total", "Pooled connections reused for requests to the motley_cue instance.", "upstream", reusedConns)
+	upstreamLatency.Write(w)
+}
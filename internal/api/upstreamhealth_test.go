@@ -0,0 +1,83 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/mockmotleycue"
+	"github.com/lbrocke/oinit/pkg/libmotleycue"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpstreamHealth(t *testing.T) {
+	m := &upstreamMonitor{stats: make(map[string]*upstreamStats)}
+	now := time.Now()
+
+	health := m.health("https://unused.example.com")
+	assert.Equal(t, ApiResponseUpstreamHealth{CircuitBreaker: BREAKER_CLOSED}, health)
+
+	unavailable := libmotleycue.StatusError{StatusCode: http.StatusBadGateway}
+
+	// Errors about the user don't count as failures
+	for i := 1; i <= 90; i++ {
+		m.observe("https://mc.example.com", time.Duration(i)*time.Millisecond, errors.New("user suspended"), now)
+	}
+	for i := 91; i <= 100; i++ {
+		m.observe("https://mc.example.com", time.Duration(i)*time.Millisecond, unavailable, now.Add(time.Second))
+	}
+
+	health = m.health("https://mc.example.com")
+	assert.Equal(t, uint64(100), health.Requests)
+	assert.Equal(t, uint64(10), health.Failures)
+	assert.Equal(t, 0.1, health.ErrorRate)
+	assert.Equal(t, 50.0, health.LatencyP50Ms)
+	assert.Equal(t, 90.0, health.LatencyP90Ms)
+	assert.Equal(t, 99.0, health.LatencyP99Ms)
+	assert.Equal(t, now, *health.LastSuccess)
+	assert.Equal(t, now.Add(time.Second), *health.LastFailure)
+	assert.Equal(t, unavailable.Error(), health.LastError)
+
+	// Only the last requests are considered
+	for i := 0; i < UPSTREAM_SAMPLES; i++ {
+		m.observe("https://mc.example.com", time.Millisecond, nil, now)
+	}
+
+	health = m.health("https://mc.example.com")
+	assert.Equal(t, uint64(200), health.Requests)
+	assert.Equal(t, 0.0, health.ErrorRate)
+	assert.Equal(t, 1.0, health.LatencyP99Ms)
+
+	var buf bytes.Buffer
+	m.writeMetrics(&buf)
+	assert.Contains(t, buf.String(), `oinit_ca_upstream_up{upstream="https://mc.example.com"} 1`)
+	assert.Contains(t, buf.String(), `oinit_ca_upstream_failures_total{upstream="https://mc.example.com"} 10`)
+}
+
+func TestUpstreamHealthConnections(t *testing.T) {
+	mock := mockmotleycue.New()
+	mock.AddUser(TEST_TOKEN, mockmotleycue.User{SSHUser: "alice", State: libmotleycue.StateDeployed})
+
+	srv := httptest.NewServer(mock)
+	defer srv.Close()
+
+	info := config.HostInfo{URLs: config.SplitURLs(srv.URL)}
+
+	for i := 0; i < 3; i++ {
+		_, _, err := withUpstream(context.Background(), info, func(ctx context.Context, client libmotleycue.Client) (libmotleycue.ApiResponseInfo, error) {
+			return client.GetInfoContext(ctx)
+		})
+		assert.NoError(t, err)
+	}
+
+	health := upstreamHealth.health(srv.URL)
+	assert.Equal(t, uint64(3), health.Requests)
+	assert.Equal(t, uint64(3), health.NewConnections+health.ReusedConnections)
+	assert.NotZero(t, health.ReusedConnections)
+}
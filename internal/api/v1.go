@@ -1,14 +1,24 @@
 package api
 
 import (
+	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"path"
 	"strings"
 	"time"
 
+	"github.com/lbrocke/oinit/internal/approved"
+	"github.com/lbrocke/oinit/internal/buildinfo"
 	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/fault"
+	"github.com/lbrocke/oinit/internal/forcecmd"
+	"github.com/lbrocke/oinit/internal/i18n"
+	"github.com/lbrocke/oinit/internal/storage"
+	"github.com/lbrocke/oinit/internal/tokenbind"
 	"github.com/lbrocke/oinit/internal/util"
 	"github.com/lbrocke/oinit/pkg/libmotleycue"
 
@@ -21,30 +31,94 @@ import (
 const (
 	API_VERSION = "1.0.0"
 
-	ERR_BAD_BODY       = "Request body is malformed."
-	ERR_UNKNOWN_HOST   = "Unknown host."
-	ERR_GATEWAY_DOWN   = "motley_cue is not reachable."
-	ERR_UNAUTHORIZED   = "User is not authorized or suspended."
-	ERR_INTERNAL_ERROR = "Internal server error."
+	// Error codes, the messages are translated using package i18n
+	ERR_BAD_BODY       = "bad_body"
+	ERR_UNKNOWN_HOST   = "unknown_host"
+	ERR_GATEWAY_DOWN   = "gateway_down"
+	ERR_UNAUTHORIZED   = "unauthorized"
+	ERR_INTERNAL_ERROR = "internal_error"
 )
 
 type ApiResponseError struct {
-	Error string `json:"error"`
+	// Machine-readable error code
+	Code string `json:"code" example:"bad_body"`
+	// Message in the language requested by Accept-Language
+	Error  string       `json:"error" example:"Request body is malformed."`
+	Errors []FieldError `json:"errors,omitempty"`
+	// ID of the request, which support staff can use to look up why it
+	// failed
+	RequestID string `json:"request_id,omitempty" example:"9f86d081884c7d659a2feaa0c55ad015"`
+	// What users can do about denied requests
+	Remediation *Remediation `json:"remediation,omitempty"`
 }
 
 type ApiResponseIndex struct {
-	Version string `json:"version"`
+	Version string           `json:"version"`
+	Build   ApiResponseBuild `json:"build"`
+}
+
+// ApiResponseBuild describes the running binary, so operators can verify
+// exactly what is deployed.
+type ApiResponseBuild struct {
+	Version   string `json:"version" example:"v1.2.3"`
+	Commit    string `json:"commit" example:"5db67d0c3f4e2a1b9d8c7e6f5a4b3c2d1e0f9a8b"`
+	Date      string `json:"date" example:"2024-01-02T03:04:05Z"`
+	GoVersion string `json:"go_version" example:"go1.21.5"`
+	// Signing backends compiled into the binary
+	SigningBackends []string `json:"signing_backends" example:"file"`
 }
 
 type ApiResponseHost struct {
 	PublicKey string     `json:"publickey"`
 	Providers []Provider `json:"providers"`
+	// Message of the hostgroup that clients show to users before connecting
+	Message string `json:"message,omitempty" example:"Maintenance on Saturday, 8-12 UTC"`
+	// Certificate profiles that may be requested, the first is the default
+	Profiles []string `json:"profiles,omitempty" example:"interactive,file-transfer-only"`
+	// Site CA that issues certificates for the host, if delegated
+	Delegation *ApiResponseDelegation `json:"delegation,omitempty"`
+	// Client key types that certificates are issued for, so clients can
+	// generate a compatible key. Omitted for delegated hosts.
+	KeyAlgorithms []KeyAlgorithm `json:"key_algorithms,omitempty"`
+	// Algorithm the CA signs certificates with
+	SignatureAlgorithm string `json:"signature_algorithm,omitempty" example:"ssh-ed25519"`
+	// Force-command of issued certificates, omitted for delegated hosts
+	ForceCommand *ApiResponseForceCommand `json:"force_command,omitempty"`
+	// Whether the host requires logins to present the access token the
+	// certificate was issued for, which clients send as OINIT_BOUND_TOKEN
+	TokenBinding bool `json:"token_binding,omitempty"`
+}
+
+// ApiResponseForceCommand describes the force-command that logins with
+// issued certificates run, so clients can warn users about unsupported
+// sessions, e.g. that SFTP won't work.
+type ApiResponseForceCommand struct {
+	// Program run by the force-command
+	Program string `json:"program" example:"oinit-switch"`
+	// Whether a wrapper other than oinit-switch is run
+	Wrapper bool `json:"wrapper"`
+	// Whether the force-command is signed with a key shared with the hosts
+	Signed bool `json:"signed"`
+	// Kinds of sessions the force-command supports
+	Features []string `json:"features" example:"shell,exec,scp,sftp" enums:"shell,exec,scp,sftp"`
 }
 
 type ApiResponseCertificate struct {
 	Certificate string `json:"certificate"`
 }
 
+// ApiResponseCertificateDryRun contains the fields of the certificate that
+// would have been issued, without it being signed.
+type ApiResponseCertificateDryRun struct {
+	PublicKey       string            `json:"publickey"`
+	KeyId           string            `json:"keyid"`
+	Principals      []string          `json:"principals"`
+	ValidAfter      time.Time         `json:"valid_after"`
+	ValidBefore     time.Time         `json:"valid_before"`
+	CriticalOptions map[string]string `json:"critical_options"`
+	Extensions      map[string]string `json:"extensions"`
+}
+
 type Provider struct {
 	URL    string   `json:"url"`
 	Scopes []string `json:"scopes"`
@@ -55,13 +129,72 @@ type UriHost struct {
 }
 
 type FormHostCertificate struct {
-	Publickey string `json:"publickey" binding:"required"`
-	Token     string `json:"token" binding:"required"`
+	Publickey string `json:"publickey" binding:"required_without=SignedRequest"`
+	// Access token, may instead be sent in the Authorization header
+	Token string `json:"token,omitempty"`
+	// Extensions the certificate should contain, a subset of those allowed
+	// by the CA. If omitted, all allowed extensions are included.
+	Extensions []string `json:"extensions" example:"permit-pty"`
+	// If set, the certificate only permits running this command, optionally
+	// followed by arguments.
+	Command string `json:"command,omitempty" example:"rsync --server"`
+	// Certificate profile offered by the host, see GET /{host}. If omitted,
+	// the default profile of the host is used.
+	Profile string `json:"profile,omitempty" example:"file-transfer-only"`
+	// Request signed by the private key of the public key, which relays
+	// such as portals can't alter, see package oinitca. If set, publickey,
+	// extensions, command and profile are taken from it and must be omitted.
+	SignedRequest string `json:"signed_request,omitempty" example:"oinit-request-v1.eyJwdWJsaWNrZXkiOiJzc2gtZWQyNTUxOSBBQUFBQzNOemFDMWxaREkxTlRFNUFBQUEifQ.AAAAC3NzaC1lZDI1NTE5"`
+}
+
+type QueryHostCertificate struct {
+	DryRun bool `form:"dry_run"`
+	// Response format, "json" (default) or "bundle"
+	Format string `form:"format" binding:"omitempty,oneof=json bundle"`
 }
 
-func Error(c *gin.Context, code int, msg string) {
-	c.JSON(code, ApiResponseError{
-		Error: msg,
+// bearerToken returns the token of the Authorization header, or an empty
+// string if there is none.
+func bearerToken(c *gin.Context) string {
+	token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !found {
+		return ""
+	}
+
+	return strings.TrimSpace(token)
+}
+
+// language returns the language of messages for this request.
+func language(c *gin.Context) string {
+	return i18n.Match(c.GetHeader("Accept-Language"))
+}
+
+// Error responds with the given status and error code, along with the
+// message of the code in the requested language.
+func Error(c *gin.Context, status int, code string) {
+	c.Set("error_code", code)
+	c.JSON(status, ApiResponseError{
+		Code:      code,
+		Error:     i18n.Translate(language(c), code),
+		RequestID: c.GetString("request_id"),
+	})
+}
+
+// ValidationError responds with 400 Bad Request, listing why each field of
+// the request body is invalid.
+func ValidationError(c *gin.Context, errs []FieldError) {
+	lang := language(c)
+
+	for i, err := range errs {
+		errs[i].Message = i18n.Translate(lang, err.msg, err.args...)
+	}
+
+	c.Set("error_code", ERR_BAD_BODY)
+	c.JSON(http.StatusBadRequest, ApiResponseError{
+		Code:      ERR_BAD_BODY,
+		Error:     i18n.Translate(lang, ERR_BAD_BODY),
+		Errors:    errs,
+		RequestID: c.GetString("request_id"),
 	})
 }
 
@@ -73,32 +206,54 @@ func (writer customLog) Write(bytes []byte) (int, error) {
 	return fmt.Print("[API] " + time.Now().Format("2006/01/02 - 15:04:05") + " " + string(bytes))
 }
 
+// Logger is used by the handlers and the storage and services they use
+// instead of the standard logger, whose output and flags belong to the
+// application embedding the handlers, see oinitcaserver.
+var Logger = log.New(customLog{}, "", 0)
+
 var cache = util.NewTimedCache[string, []Provider]()
 
 // GetIndex is the handler for GET /
 //
 //	@Summary		Get API version
-//	@Description	Return the running API version.
+//	@ID				getIndex
+//	@Description	Return the running API version, along with the version, git commit and build date of the
+//	@Description	binary and its compiled-in signing backends.
 //	@Produce		json
 //	@Success		200	{object}	ApiResponseIndex
 //	@Router			/ [get]
 func GetIndex(c *gin.Context) {
+	build := buildinfo.Get()
+
 	c.JSON(http.StatusOK, ApiResponseIndex{
 		Version: API_VERSION,
+		Build: ApiResponseBuild{
+			Version:         build.Version,
+			Commit:          build.Commit,
+			Date:            build.Date,
+			GoVersion:       build.GoVersion,
+			SigningBackends: build.Backends,
+		},
 	})
 }
 
 // GetHost is the handler for GET /:host
 //
 //	@Summary		Get host information
+//	@ID				getHost
 //	@Description	Return the CA public key and supported OpenID Connect providers with their required scopes.
+//	@Description	If configured, a message of the hostgroup for users is included, such as announced maintenance.
+//	@Description	For hosts delegated to a site CA, its user CA key is included, certified by the user CA key of the
+//	@Description	hostgroup. Hosts served by a peer CA are redirected to it with 307, along with its host CA key.
 //	@Produce		json
 //	@Param			host	path		string	true	"Host"	example("example.com")
 //	@Success		200		{object}	ApiResponseHost
+//	@Success		307		{object}	ApiResponsePeer
 //	@Failure		400		{object}	ApiResponseError
 //	@Failure		404		{object}	ApiResponseError
 //	@Failure		500		{object}	ApiResponseError
 //	@Failure		502		{object}	ApiResponseError
+//	@Failure		504		{object}	ApiResponseError
 //	@Router			/{host} [get]
 func GetHost(c *gin.Context) {
 	var host UriHost
@@ -116,16 +271,30 @@ func GetHost(c *gin.Context) {
 		return
 	}
 
-	info, err := conf.GetInfo(host.Host)
+	info, err := lookupHost(c.Request.Context(), conf, host.Host)
 	if err != nil {
+		if timedOut(c) {
+			return
+		}
+
+		if _, ok := redirectToPeer(c, conf, host.Host, ""); ok {
+			return
+		}
+
 		Error(c, http.StatusNotFound, ERR_UNKNOWN_HOST)
 		return
 	}
 
 	providers, ok := cache.Get(info.URL)
 	if !ok {
-		hostInfo, err := libmotleycue.NewClient(info.URL).GetInfo()
+		hostInfo, _, err := withUpstream(c.Request.Context(), info, func(ctx context.Context, client libmotleycue.Client) (libmotleycue.ApiResponseInfo, error) {
+			return client.GetInfoContext(ctx)
+		})
 		if err != nil {
+			if timedOut(c) {
+				return
+			}
+
 			Error(c, http.StatusBadGateway, ERR_GATEWAY_DOWN)
 			return
 		}
@@ -145,39 +314,144 @@ func GetHost(c *gin.Context) {
 		cache.Set(info.URL, providers, time.Duration(info.CacheDuration))
 	}
 
+	delegation, err := delegation(conf, info, host.Host, time.Now())
+	if err != nil {
+		Logger.Printf("Could not sign delegation of %s: %s", host.Host, err)
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	var algorithms []KeyAlgorithm
+	var signatureAlg string
+	var forceCommand *ApiResponseForceCommand
+
+	if delegation == nil {
+		version, knownVersion := hostVersion(c.Request.Context(), info, host.Host)
+		algorithms = acceptedKeyAlgorithms(conf, info, version, knownVersion)
+
+		if signer, err := certSigner(conf, info, version, knownVersion); err == nil {
+			signatureAlg = signatureAlgorithm(signer)
+		}
+
+		forceCommand = &ApiResponseForceCommand{
+			Program:  info.ForceCommand.Program(),
+			Wrapper:  path.Base(info.ForceCommand.Program()) != forcecmd.COMMAND,
+			Signed:   info.ForceCommandKey != nil,
+			Features: info.ForceCommandFeatures,
+		}
+	}
+
 	c.JSON(http.StatusOK, ApiResponseHost{
-		PublicKey: strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(info.HostCAPublicKey)), "\n"),
-		Providers: providers,
+		PublicKey:          strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(info.HostCAPublicKey)), "\n"),
+		Providers:          providers,
+		Message:            info.Message,
+		Profiles:           info.ProfileNames(),
+		Delegation:         delegation,
+		KeyAlgorithms:      algorithms,
+		SignatureAlgorithm: signatureAlg,
+		ForceCommand:       forceCommand,
+		TokenBinding:       info.TokenBinding,
 	})
 }
 
 // PostHostCertificate is the handler for POST /:host/certificate
 //
 //	@Summary		Generate SSH certificate
+//	@ID				signCertificate
 //	@Description	Generate and return a new SSH certificate using the given public key and access token.
+//	@Description	If dry_run is set, the certificate is not signed and its fields are returned instead.
+//	@Description	Dry runs have no side effects: users are not deployed by motley_cue, so they must have been deployed before.
+//	@Description	The access token should be sent in the Authorization header rather than in the body.
+//	@Description	Retries with the same Idempotency-Key return the certificate issued for the first request.
+//	@Description	Requests for hosts delegated to a site CA are forwarded to it, or redirected with 307, as are
+//	@Description	requests for hosts served by a peer CA.
+//	@Description	With format=bundle, a gzipped tarball is returned instead of JSON, containing the certificate, a
+//	@Description	known_hosts file trusting the host CA, the KRL of the user CA and an ssh_config snippet, so users of
+//	@Description	plain ssh can set up access in one download.
+//	@Description	Clients sending "Prefer: respond-async" receive 202 Accepted if the request takes longer than
+//	@Description	async-after, and poll GET /requests/{id} until the response is ready.
+//	@Description	While issuance for the hostgroup is frozen, requests are answered with 503, along with a
+//	@Description	Retry-After header if the freeze ends.
 //	@Accept			json
 //	@Produce		json
-//	@Param			host	path		string				true	"Host"	example("example.com")
-//	@Param			body	body		FormHostCertificate	true	"Public key and access token"
-//	@Success		201		{object}	ApiResponseCertificate
-//	@Failure		400		{object}	ApiResponseError
-//	@Failure		401		{object}	ApiResponseError
-//	@Failure		404		{object}	ApiResponseError
-//	@Failure		500		{object}	ApiResponseError
-//	@Failure		502		{object}	ApiResponseError
+//	@Produce		application/gzip
+//	@Param			host			path		string				true	"Host"	example("example.com")
+//	@Param			dry_run			query		bool				false	"Do not sign, only return certificate fields"
+//	@Param			format			query		string				false	"Response format"	Enums(json, bundle)
+//	@Param			Authorization	header		string				false	"Bearer access token"
+//	@Param			Idempotency-Key	header		string				false	"Unique key of the request, reused for retries"
+//	@Param			Prefer			header		string				false	"respond-async to receive 202 Accepted if the request is slow"
+//	@Param			body			body		FormHostCertificate	true	"Public key and access token"
+//	@Success		200				{object}	ApiResponseCertificateDryRun
+//	@Success		201				{object}	ApiResponseCertificate
+//	@Success		202				{object}	ApiResponseAsync
+//	@Success		307				{object}	ApiResponsePeer
+//	@Failure		400				{object}	ApiResponseError
+//	@Failure		401				{object}	ApiResponseError
+//	@Failure		403				{object}	ApiResponseError
+//	@Failure		404				{object}	ApiResponseError
+//	@Failure		409				{object}	ApiResponseError
+//	@Failure		422				{object}	ApiResponseError
+//	@Failure		429				{object}	ApiResponseError
+//	@Failure		500				{object}	ApiResponseError
+//	@Failure		502				{object}	ApiResponseError
+//	@Failure		503				{object}	ApiResponseError
+//	@Failure		504				{object}	ApiResponseError
 //	@Router			/{host}/certificate [post]
 func PostHostCertificate(c *gin.Context) {
-	log.SetFlags(0)
-	log.SetOutput(new(customLog))
-
 	var host UriHost
+	var query QueryHostCertificate
 	var body FormHostCertificate
 
-	if c.ShouldBindUri(&host) != nil || c.ShouldBindJSON(&body) != nil {
+	decision := newDecisionTrace(c, strings.ToLower(c.Param("host")))
+	defer decision.save(c)
+
+	if c.ShouldBindUri(&host) != nil || c.ShouldBindQuery(&query) != nil ||
+		bindingError(c.ShouldBindJSON(&body)) {
+		decision.step(STEP_VALIDATE, false, "malformed request")
 		Error(c, http.StatusBadRequest, ERR_BAD_BODY)
 		return
 	}
 
+	if token := bearerToken(c); token != "" {
+		if body.Token != "" && body.Token != token {
+			decision.step(STEP_VALIDATE, false, "different tokens in header and body")
+			ValidationError(c, []FieldError{fieldError(FIELD_TOKEN, CODE_CONFLICT, MSG_TOKEN_CONFLICT)})
+			return
+		}
+
+		body.Token = token
+	}
+
+	body, errs := applySignedRequest(body, c.Param("host"), time.Now())
+
+	var pubkey ssh.PublicKey
+	var token *jwt.Token
+	if len(errs) == 0 {
+		pubkey, token, errs = validateHostCertificate(body)
+	}
+	if len(errs) != 0 {
+		var invalid []string
+		for _, err := range errs {
+			invalid = append(invalid, err.Field+": "+err.Code)
+		}
+
+		decision.step(STEP_VALIDATE, false, strings.Join(invalid, ", "))
+		ValidationError(c, errs)
+		return
+	}
+
+	decision.token(token)
+	// Failed requests are counted per subject by the tarpit middleware
+	c.Set("subject", tokenSubject(token))
+
+	idempotencyKey := c.GetHeader(HEADER_IDEMPOTENCY_KEY)
+	if idempotencyKey != "" && !validIdempotencyKey(idempotencyKey) {
+		decision.step(STEP_VALIDATE, false, "invalid idempotency key")
+		Error(c, http.StatusBadRequest, ERR_INVALID_IDEMPOTENCY_KEY)
+		return
+	}
+
 	host.Host = strings.ToLower(host.Host)
 
 	conf, ok := c.MustGet("config").(config.Config)
@@ -186,34 +460,248 @@ func PostHostCertificate(c *gin.Context) {
 		return
 	}
 
-	info, err := conf.GetInfo(host.Host)
+	if conf.Server.ApprovedAlgorithms {
+		if err := approved.PublicKey(pubkey); err != nil {
+			decision.step(STEP_VALIDATE, false, err.Error())
+			ValidationError(c, []FieldError{fieldError(FIELD_PUBLICKEY, CODE_UNSUPPORTED_TYPE, MSG_KEY_NOT_APPROVED, pubkey.Type())})
+			return
+		}
+	}
+
+	decision.step(STEP_VALIDATE, true, "public key "+ssh.FingerprintSHA256(pubkey))
+
+	store, ok := c.MustGet("store").(storage.Store)
+	if !ok {
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	stopTiming := startTiming(c, TIMING_CONFIG)
+	info, err := lookupHost(c.Request.Context(), conf, host.Host)
+	stopTiming()
 	if err != nil {
+		if timedOut(c) {
+			decision.step(STEP_HOST, false, "timed out")
+			return
+		}
+
+		if peer, ok := redirectToPeer(c, conf, host.Host, "/certificate"); ok {
+			decision.step(STEP_DELEGATE, true, "redirected to peer "+peer.Name)
+			return
+		}
+
+		decision.step(STEP_HOST, false, "host is not configured")
 		Error(c, http.StatusNotFound, ERR_UNKNOWN_HOST)
 		return
 	}
 
-	pubkey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(body.Publickey))
+	decision.step(STEP_HOST, true, "host group "+info.HostGroup)
+
+	freeze, err := issuanceFreeze(store, info.HostGroup, info.FreezeWindows, info.FreezeMessage, time.Now())
 	if err != nil {
-		Error(c, http.StatusBadRequest, ERR_BAD_BODY)
+		decision.step(STEP_FREEZE, false, err.Error())
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	if freeze.Frozen {
+		decision.step(STEP_FREEZE, false, freeze.describe())
+		frozenError(c, freeze)
+		return
+	}
+
+	// Hosts delegated to a site CA are authorized and issued for there.
+	if info.Delegate != "" {
+		if info.DelegateMode == config.DELEGATE_REDIRECT {
+			decision.step(STEP_DELEGATE, true, "redirected to "+info.Delegate)
+			c.Redirect(http.StatusTemporaryRedirect, hostURL(info.Delegate, host.Host, "/certificate", c.Request.URL.RawQuery))
+			return
+		}
+
+		status, err := proxyCertificate(c, info, host.Host, body)
+		if err != nil {
+			decision.step(STEP_DELEGATE, false, info.Delegate+": "+err.Error())
+			if timedOut(c) {
+				return
+			}
+
+			Error(c, http.StatusBadGateway, ERR_GATEWAY_DOWN)
+			return
+		}
+
+		decision.step(STEP_DELEGATE, status < http.StatusBadRequest, fmt.Sprintf("%s: status %d", info.Delegate, status))
 		return
 	}
 
-	// Parse JWT without verifying it, as the signer key is unknown to the CA.
-	// motley_cue will verify the token instead.
-	token, _, err := new(jwt.Parser).ParseUnverified(body.Token, jwt.MapClaims{})
+	version, knownVersion := hostVersion(c.Request.Context(), info, host.Host)
+	if knownVersion && !version.SupportsKeyType(pubkey.Type()) {
+		decision.step(STEP_VALIDATE, false, "key type "+pubkey.Type()+" is not supported by OpenSSH "+version.String())
+		ValidationError(c, []FieldError{fieldError(FIELD_PUBLICKEY, CODE_UNSUPPORTED_TYPE, MSG_KEY_UNSUPPORTED_BY_HOST, pubkey.Type(), version.String())})
+		return
+	}
+
+	if len(info.KeyTypes) != 0 && !slices.Contains(info.KeyTypes, pubkey.Type()) {
+		decision.step(STEP_VALIDATE, false, "key type "+pubkey.Type()+" is not accepted by host group "+info.HostGroup)
+		ValidationError(c, []FieldError{fieldError(FIELD_PUBLICKEY, CODE_UNSUPPORTED_TYPE, MSG_KEY_NOT_ACCEPTED, pubkey.Type())})
+		return
+	}
+
+	if bits := rsaBits(pubkey); bits != 0 && bits < info.MinRSABits {
+		decision.step(STEP_VALIDATE, false, fmt.Sprintf("rsa key has %d < %d bits", bits, info.MinRSABits))
+		ValidationError(c, []FieldError{fieldError(FIELD_PUBLICKEY, CODE_UNSUPPORTED_TYPE, MSG_RSA_KEY_TOO_SMALL, bits, info.MinRSABits)})
+		return
+	}
+
+	profile, ok := info.Profile(body.Profile)
+	if !ok {
+		decision.step(STEP_PROFILE, false, "unknown profile "+body.Profile)
+		ValidationError(c, []FieldError{fieldError(FIELD_PROFILE, CODE_UNKNOWN_PROFILE, MSG_UNKNOWN_PROFILE, body.Profile)})
+		return
+	}
+
+	if profile != nil {
+		if profile.Command != "" && body.Command != "" && body.Command != profile.Command {
+			decision.step(STEP_PROFILE, false, "command conflicts with profile "+profile.Name)
+			ValidationError(c, []FieldError{fieldError(FIELD_COMMAND, CODE_CONFLICT, MSG_PROFILE_COMMAND, profile.Name)})
+			return
+		}
+
+		info, body = applyProfile(*profile, info, body)
+		decision.step(STEP_PROFILE, true, profile.Name)
+	}
+
+	// Networks are checked before users are deployed, so no accounts are
+	// created for requests from denied networks.
+	geoAction, geoDetail, err := geoPolicy(conf, info, c.ClientIP())
 	if err != nil {
-		Error(c, http.StatusBadRequest, ERR_BAD_BODY)
+		decision.step(STEP_GEO, false, err.Error())
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	if geoDetail != "" {
+		decision.step(STEP_GEO, geoAction != config.GEO_DENY, geoDetail)
+	}
+
+	if geoAction == config.GEO_DENY {
+		Denial(c, http.StatusForbidden, ERR_GEO_DENIED, info, HINT_NETWORK)
+		return
+	}
+
+	// Blocked accounts are denied before motley_cue is asked to deploy them.
+	// Lists that can't be read deny everyone rather than nobody.
+	allowed, err := subjectPolicy(info, token)
+	if err != nil {
+		Logger.Printf("Could not read subject list: %s", err)
+		decision.step(STEP_SUBJECT, false, err.Error())
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	if !allowed {
+		decision.step(STEP_SUBJECT, false, tokenSubject(token))
+		Denial(c, http.StatusForbidden, ERR_SUBJECT_DENIED, info, HINT_SUSPENDED)
 		return
 	}
 
-	status, err := libmotleycue.NewClient(info.URL).GetUserDeploy(body.Token)
+	var expiry time.Time
+	if exp, err := token.Claims.GetExpirationTime(); err == nil && exp != nil {
+		expiry = exp.Time
+	}
+
+	// Workloads have no motley_cue account, their tokens are verified here
+	workload := isWorkloadToken(conf, token)
+
+	var status libmotleycue.ApiResponseUserStatus
+	var upstream string
+	var cached bool
+
+	stopTiming = startTiming(c, TIMING_UPSTREAM)
+	if workload {
+		status, upstream, err = workloadUser(c.Request.Context(), conf, host.Host, body.Token)
+	} else if query.DryRun {
+		// Dry runs must not create accounts, so motley_cue is only asked for
+		// the state of the user, and users that are not deployed yet are
+		// denied
+		upstream = "status query"
+		status, err = queryUserStatus(c.Request.Context(), conf, info, host.Host, body.Token, expiry)
+	} else {
+		status, upstream, cached, err = deployUser(c.Request.Context(), conf, info, host.Host, body.Token, expiry)
+	}
+	stopTiming()
+
+	if cached {
+		upstream += " (cached)"
+	}
+
+	// Keep logins alive through brief outages of motley_cue, if the subject
+	// was authorized recently
+	grace := false
+	if !workload {
+		if auth, ok := graceAuthorization(info, tokenSubject(token), host.Host, err); ok {
+			decision.step(STEP_MOTLEY_CUE, true, upstream+": "+err.Error()+", grace for authorization at "+auth.time.UTC().Format(time.RFC3339))
+			status, err, grace = auth.status, nil, true
+		} else if err == nil && status.State == libmotleycue.StateDeployed && !query.DryRun {
+			rememberAuthorization(info, tokenSubject(token), host.Host, status)
+		}
+	}
+
 	if err != nil || status.State != libmotleycue.StateDeployed {
 		// Either something went wrong with the HTTP request/deployment, the
 		// access token is not valid (e.g. expired) or the user is suspended.
-		Error(c, http.StatusUnauthorized, ERR_UNAUTHORIZED)
+		if err != nil {
+			decision.step(STEP_MOTLEY_CUE, false, upstream+": "+err.Error())
+		} else {
+			decision.step(STEP_MOTLEY_CUE, false, upstream+": state: "+string(status.State))
+		}
+
+		if timedOut(c) {
+			return
+		}
+
+		if !query.DryRun {
+			recordDenial(store, host.Host, tokenSubject(token), status, err)
+		}
+
+		Denial(c, http.StatusUnauthorized, ERR_UNAUTHORIZED, info, userStateHint(status, err))
 		return
 	}
 
+	if !grace {
+		decision.step(STEP_MOTLEY_CUE, true, upstream+": state: "+string(status.State)+", user: "+status.Credentials.SSHUser)
+	}
+
+	subject := tokenSubject(token)
+
+	// Retries of a request that has already been answered return the same
+	// certificate, without passing quotas or allocating a new serial.
+	var idempotent *idempotency
+	if !query.DryRun {
+		idempotent = newIdempotency(store, idempotencyKey, subject, host.Host, pubkey, body, time.Duration(conf.Server.IdempotencyWindow)*time.Second)
+	}
+
+	if idempotent != nil {
+		previous, err := idempotent.previous()
+		if err != nil {
+			decision.step(STEP_IDEMPOTENT, false, err.Error())
+
+			if errors.Is(err, ErrIdempotencyKeyReused) {
+				Error(c, http.StatusUnprocessableEntity, ERR_IDEMPOTENCY_KEY_REUSED)
+			} else {
+				Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+			}
+			return
+		}
+
+		if previous != "" {
+			decision.step(STEP_IDEMPOTENT, true, "returning certificate of earlier request")
+
+			c.Header(HEADER_IDEMPOTENT_REPLAYED, "true")
+			respondCertificate(c, store, info, host.Host, query.Format, previous)
+			return
+		}
+	}
+
 	certDuration := info.CertDuration
 	// If CertDuration is set to 0 or negative number, use the expiry date of the
 	// given token as "valid before" date.
@@ -223,17 +711,228 @@ func PostHostCertificate(c *gin.Context) {
 		}
 	}
 
-	cert := generateUserCertificate(host.Host, pubkey, status.Credentials.SSHUser, uint64(certDuration))
+	certDuration = geoLimit(info, geoAction, certDuration)
+	certDuration = graceLimit(info, grace, certDuration)
+
+	// Only record tokens verified by motley_cue, so forged tokens can't block
+	// the jti of legitimate ones.
+	if !query.DryRun {
+		window := time.Duration(conf.Server.ReplayWindow) * time.Second
+		if err := detectReplay(store, token, ssh.FingerprintSHA256(pubkey), host.Host, window,
+			time.Duration(certDuration)*time.Second); err != nil {
+			decision.step(STEP_REPLAY, false, err.Error())
+
+			if errors.Is(err, ErrTokenReplayed) {
+				Error(c, http.StatusUnauthorized, ERR_TOKEN_REPLAYED)
+			} else {
+				Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+			}
+			return
+		}
 
-	signer, err := ssh.NewSignerFromKey(info.UserCAPrivateKey)
-	if err != nil || cert.SignCert(rand.Reader, signer) != nil {
-		Error(c, http.StatusUnauthorized, ERR_INTERNAL_ERROR)
+		decision.step(STEP_REPLAY, true, "")
+	}
+
+	if !query.DryRun {
+		defer lockQuota(info, subject)()
+	}
+
+	superseded, err := checkQuota(store, info, subject)
+	if err != nil {
+		decision.step(STEP_QUOTA, false, err.Error())
+
+		if errors.Is(err, ErrQuotaExceeded) {
+			Denial(c, http.StatusTooManyRequests, ERR_QUOTA_EXCEEDED, info, HINT_QUOTA)
+		} else {
+			Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		}
 		return
 	}
 
-	log.Printf("Issued certificate '%s' valid until '%s'", ssh.FingerprintSHA256(cert.Key), time.Unix(int64(cert.ValidBefore-1), 0))
+	decision.step(STEP_QUOTA, true, "")
+
+	var vos []string
+	if !workload {
+		vos, err = userVOs(c.Request.Context(), conf, token, body.Token)
+	}
+	if err != nil {
+		decision.step(STEP_VO_QUOTA, false, "could not determine VOs: "+err.Error())
+		if timedOut(c) {
+			return
+		}
+
+		Error(c, http.StatusBadGateway, ERR_USERINFO_FAILED)
+		return
+	}
+
+	if err := enforceVOQuota(store, conf, vos); err != nil {
+		decision.step(STEP_VO_QUOTA, false, "VOs "+strings.Join(vos, ",")+": "+err.Error())
+
+		if errors.Is(err, ErrVOQuotaExceeded) {
+			Denial(c, http.StatusTooManyRequests, ERR_VO_QUOTA_EXCEEDED, info, HINT_VO_QUOTA)
+		} else {
+			Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		}
+		return
+	}
+
+	if len(vos) != 0 {
+		decision.step(STEP_VO_QUOTA, true, "VOs "+strings.Join(vos, ","))
+	}
+
+	extensions := allowedExtensions(info.Extensions, body.Extensions)
+
+	usernames := certificateUsernames(info.Principals, status.Credentials)
+
+	cert := generateUserCertificate(host.Host, pubkey, usernames, uint64(certDuration), uint64(conf.Server.ClockSkewTolerance), extensions)
+
+	if info.HostPrincipals {
+		cert.ValidPrincipals = hostPrincipals(cert.ValidPrincipals, host.Host)
+	}
+
+	tokenbind.Bind(&cert, body.Token)
 
-	c.JSON(http.StatusCreated, ApiResponseCertificate{
-		Certificate: strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(&cert)), "\n"),
+	issuer, _ := token.Claims.GetIssuer()
+
+	forceCommand, err := info.ForceCommand.Render(map[string]string{
+		forcecmd.VAR_USERNAMES: strings.Join(usernames, ","),
+		forcecmd.VAR_USERNAME:  usernames[0],
+		forcecmd.VAR_PROVIDER:  issuer,
+		forcecmd.VAR_TOKEN:     tokenbind.Hash(body.Token),
+		forcecmd.VAR_HOST:      host.Host,
 	})
+	if err != nil {
+		decision.step(STEP_SIGN, false, "could not create force-command: "+err.Error())
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	if info.ForceCommandKey != nil || body.Command != "" {
+		payload := forcecmd.Payload{
+			Host:      host.Host,
+			IssuedAt:  int64(cert.ValidAfter),
+			ExpiresAt: int64(cert.ValidBefore),
+			Command:   body.Command,
+		}
+
+		var arg string
+		if info.ForceCommandKey != nil {
+			arg, err = forcecmd.SignArgument(info.ForceCommandKey, FORCE_COMMAND, strings.Join(usernames, ","), payload)
+		} else {
+			arg, err = forcecmd.EncodeArgument(payload)
+		}
+
+		if err != nil {
+			decision.step(STEP_SIGN, false, "could not create force-command: "+err.Error())
+			Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+			return
+		}
+
+		forceCommand += " " + arg
+	}
+
+	cert.CriticalOptions["force-command"] = forceCommand
+
+	// In dry-run mode, the whole authorization pipeline has been passed but
+	// the certificate is neither signed nor logged.
+	if query.DryRun {
+		decision.step(STEP_SIGN, true, "dry run, not signed")
+		c.JSON(http.StatusOK, ApiResponseCertificateDryRun{
+			PublicKey:       ssh.FingerprintSHA256(cert.Key),
+			KeyId:           cert.KeyId,
+			Principals:      cert.ValidPrincipals,
+			ValidAfter:      time.Unix(int64(cert.ValidAfter), 0).UTC(),
+			ValidBefore:     time.Unix(int64(cert.ValidBefore), 0).UTC(),
+			CriticalOptions: cert.CriticalOptions,
+			Extensions:      cert.Extensions,
+		})
+		return
+	}
+
+	// Don't issue certificates the client has given up waiting for.
+	if timedOut(c) {
+		decision.step(STEP_SIGN, false, "timed out")
+		return
+	}
+
+	if idempotent != nil {
+		if err := idempotent.lock(time.Duration(conf.Server.RequestTimeout) * time.Second); err != nil {
+			decision.step(STEP_IDEMPOTENT, false, err.Error())
+
+			if errors.Is(err, ErrIdempotencyInProgress) {
+				Error(c, http.StatusConflict, ERR_IDEMPOTENCY_IN_PROGRESS)
+			} else {
+				Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+			}
+			return
+		}
+	}
+
+	serial, err := store.NextSerial()
+	if err != nil {
+		decision.step(STEP_SIGN, false, "could not allocate serial: "+err.Error())
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	cert.Serial = serial
+
+	if err := fault.Inject(c.Request.Context(), fault.TARGET_SIGN); err != nil {
+		decision.step(STEP_SIGN, false, err.Error())
+		if timedOut(c) {
+			return
+		}
+
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	stopTiming = startTiming(c, TIMING_SIGN)
+	signer, err := certSigner(conf, info, version, knownVersion)
+	if err != nil {
+		Logger.Printf("Could not sign certificate for %s: %s", host.Host, err)
+	}
+	if err == nil {
+		err = cert.SignCert(rand.Reader, signer)
+	}
+	stopTiming()
+	if err != nil {
+		decision.step(STEP_SIGN, false, "could not sign certificate")
+		Error(c, http.StatusUnauthorized, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	// Do not hand out certificates that can't be tracked (and revoked).
+	if err := recordCertificate(store, cert, host.Host, info.HostGroup, subject, status.Credentials.SSHUser, vos); err != nil {
+		Logger.Printf("Could not record certificate %d: %s", cert.Serial, err)
+		decision.step(STEP_SIGN, false, "could not record certificate: "+err.Error())
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	if err := supersede(store, superseded); err != nil {
+		Logger.Printf("Could not revoke certificates superseded by %d: %s", cert.Serial, err)
+	}
+
+	decision.step(STEP_SIGN, true, fmt.Sprintf("serial %d, principals %s", cert.Serial, strings.Join(cert.ValidPrincipals, ",")))
+
+	observeValidity(info, cert)
+
+	certificate := strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(&cert)), "\n")
+
+	if idempotent != nil {
+		if err := idempotent.record(cert, certificate); err != nil {
+			Logger.Printf("Could not record idempotency key for certificate %d: %s", cert.Serial, err)
+		}
+	}
+
+	notifyIssuance(conf, store, language(c), token, body.Token, c.ClientIP(), host.Host, status.Credentials.SSHUser, cert)
+
+	if info.EagerDeploy && !workload {
+		eagerDeploy(conf, info, host.Host, subject, body.Token)
+	}
+
+	Logger.Printf("Issued certificate %d '%s' valid until '%s'", cert.Serial, ssh.FingerprintSHA256(cert.Key), time.Unix(int64(cert.ValidBefore-1), 0))
+
+	respondCertificate(c, store, info, host.Host, query.Format, certificate)
 }
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"errors"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/forcecmd"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// Access tokens are sent in HTTP headers to motley_cue, so there is no
+	// point in accepting tokens larger than common header size limits.
+	MAX_TOKEN_LENGTH = 8192
+	// The command ends up in the force-command critical option.
+	MAX_COMMAND_LENGTH = 1024
+
+	FIELD_PUBLICKEY  = "publickey"
+	FIELD_TOKEN      = "token"
+	FIELD_EXTENSIONS = "extensions"
+	FIELD_COMMAND    = "command"
+	FIELD_PROFILE    = "profile"
+
+	CODE_MISSING          = "missing"
+	CODE_UNPARSABLE       = "unparsable"
+	CODE_TOO_LONG         = "too_long"
+	CODE_UNSUPPORTED_TYPE = "unsupported_key_type"
+	CODE_UNKNOWN_EXT      = "unknown_extension"
+	CODE_INVALID_COMMAND  = "invalid_command"
+	CODE_CONFLICT         = "conflict"
+	CODE_UNKNOWN_PROFILE  = "unknown_profile"
+
+	// Message codes, see package i18n
+	MSG_MISSING_PUBLICKEY    = "missing_publickey"
+	MSG_UNPARSABLE_PUBLICKEY = "unparsable_publickey"
+	MSG_UNSUPPORTED_TYPE     = "unsupported_key_type"
+	MSG_KEY_NOT_APPROVED     = "key_not_approved"
+	MSG_MISSING_TOKEN        = "missing_token"
+	MSG_UNPARSABLE_TOKEN     = "unparsable_token"
+	MSG_TOKEN_TOO_LONG       = "token_too_long"
+	MSG_TOKEN_CONFLICT       = "token_conflict"
+	MSG_UNKNOWN_EXTENSION    = "unknown_extension"
+	MSG_COMMAND_TOO_LONG     = "command_too_long"
+	MSG_INVALID_COMMAND      = "invalid_command"
+	MSG_UNKNOWN_PROFILE      = "unknown_profile"
+	MSG_PROFILE_COMMAND      = "profile_command_conflict"
+)
+
+// FieldError describes why a single field of the request body is invalid.
+// The message is translated by ValidationError.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	msg  string
+	args []interface{}
+}
+
+func fieldError(field, code, msg string, args ...interface{}) FieldError {
+	return FieldError{Field: field, Code: code, msg: msg, args: args}
+}
+
+// bindingError reports whether err was returned by the struct validation of
+// gin, meaning the body itself could be decoded.
+func bindingError(err error) bool {
+	var validationErrors validator.ValidationErrors
+
+	return err != nil && !errors.As(err, &validationErrors)
+}
+
+// validateHostCertificate validates all fields of the given body and returns
+// the parsed public key and token. If any field is invalid, the returned
+// slice contains one error per field.
+func validateHostCertificate(body FormHostCertificate) (ssh.PublicKey, *jwt.Token, []FieldError) {
+	var errs []FieldError
+	var pubkey ssh.PublicKey
+	var token *jwt.Token
+	var err error
+
+	if body.Publickey == "" {
+		errs = append(errs, fieldError(FIELD_PUBLICKEY, CODE_MISSING, MSG_MISSING_PUBLICKEY))
+	} else if pubkey, _, _, _, err = ssh.ParseAuthorizedKey([]byte(body.Publickey)); err != nil {
+		errs = append(errs, fieldError(FIELD_PUBLICKEY, CODE_UNPARSABLE, MSG_UNPARSABLE_PUBLICKEY))
+	} else if !slices.Contains(config.KeyTypes, pubkey.Type()) {
+		errs = append(errs, fieldError(FIELD_PUBLICKEY, CODE_UNSUPPORTED_TYPE, MSG_UNSUPPORTED_TYPE, pubkey.Type()))
+	}
+
+	if body.Token == "" {
+		errs = append(errs, fieldError(FIELD_TOKEN, CODE_MISSING, MSG_MISSING_TOKEN))
+	} else if len(body.Token) > MAX_TOKEN_LENGTH {
+		errs = append(errs, fieldError(FIELD_TOKEN, CODE_TOO_LONG, MSG_TOKEN_TOO_LONG, MAX_TOKEN_LENGTH))
+	} else if token, _, err = new(jwt.Parser).ParseUnverified(body.Token, jwt.MapClaims{}); err != nil {
+		// Parse JWT without verifying it, as the signer key is unknown to the
+		// CA. motley_cue will verify the token instead.
+		errs = append(errs, fieldError(FIELD_TOKEN, CODE_UNPARSABLE, MSG_UNPARSABLE_TOKEN))
+	}
+
+	for _, ext := range body.Extensions {
+		if !slices.Contains(config.CertificateExtensions, ext) {
+			errs = append(errs, fieldError(FIELD_EXTENSIONS, CODE_UNKNOWN_EXT, MSG_UNKNOWN_EXTENSION, ext))
+			break
+		}
+	}
+
+	if len(body.Command) > MAX_COMMAND_LENGTH {
+		errs = append(errs, fieldError(FIELD_COMMAND, CODE_TOO_LONG, MSG_COMMAND_TOO_LONG, MAX_COMMAND_LENGTH))
+	} else if body.Command != "" && !forcecmd.ValidCommand(body.Command) {
+		errs = append(errs, fieldError(FIELD_COMMAND, CODE_INVALID_COMMAND, MSG_INVALID_COMMAND))
+	}
+
+	return pubkey, token, errs
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/oidc"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	ERR_VO_QUOTA_EXCEEDED = "vo_quota_exceeded"
+	ERR_USERINFO_FAILED   = "userinfo_failed"
+
+	// Separator of the group part of AARC-G002 entitlements
+	ENTITLEMENT_GROUP = ":group:"
+)
+
+var ErrVOQuotaExceeded = errors.New(ERR_VO_QUOTA_EXCEEDED)
+
+type ApiResponseAdminVO struct {
+	VO string `json:"vo"`
+	// Certificates issued since the requested time
+	Issued int `json:"issued"`
+	// Distinct subjects that certificates were issued to
+	Subjects int `json:"subjects"`
+	// Maximum number of certificates per day, 0 = unlimited
+	Quota int `json:"quota"`
+}
+
+// voName returns the VO of an entitlement. For AARC-G002 entitlements such as
+// urn:geant:example.org:group:myvo:admins:role=member#aai.example.org, this is
+// the top-level group (myvo). Other values are used as is.
+func voName(entitlement string) string {
+	_, group, found := strings.Cut(entitlement, ENTITLEMENT_GROUP)
+	if !found {
+		return entitlement
+	}
+
+	group, _, _ = strings.Cut(group, "#")
+	group, _, _ = strings.Cut(group, ":")
+
+	return group
+}
+
+// userVOs returns the configured VOs that the owner of the access token is a
+// member of, according to the userinfo endpoint of its issuer. If no VOs are
+// configured, no request is made.
+func userVOs(ctx context.Context, conf config.Config, token *jwt.Token, accessToken string) ([]string, error) {
+	if len(conf.VOQuotas) == 0 {
+		return nil, nil
+	}
+
+	issuer, err := token.Claims.GetIssuer()
+	if err != nil || issuer == "" {
+		return nil, errors.New("token has no issuer")
+	}
+
+	claims, err := oidc.UserInfo(ctx, issuer, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	var vos []string
+	for _, entitlement := range oidc.ClaimValues(claims, conf.Server.VOClaim) {
+		vo := voName(entitlement)
+		if _, ok := conf.VOQuotas[vo]; ok && !slices.Contains(vos, vo) {
+			vos = append(vos, vo)
+		}
+	}
+
+	sort.Strings(vos)
+
+	return vos, nil
+}
+
+// startOfDay returns the start of the UTC day of t, which is when VO quotas
+// are reset.
+func startOfDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+// voUsage returns the certificates issued to each VO since the given time.
+func voUsage(store storage.Store, since time.Time) (map[string][]storage.Certificate, error) {
+	certs, err := store.ListCertificates(storage.CertificateFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	usage := make(map[string][]storage.Certificate)
+	for _, cert := range certs {
+		if cert.IssuedAt.Before(since) {
+			continue
+		}
+
+		for _, vo := range cert.VOs {
+			usage[vo] = append(usage[vo], cert)
+		}
+	}
+
+	return usage, nil
+}
+
+// enforceVOQuota returns ErrVOQuotaExceeded if any of the VOs has reached
+// its maximum number of certificates for today.
+func enforceVOQuota(store storage.Store, conf config.Config, vos []string) error {
+	if len(vos) == 0 {
+		return nil
+	}
+
+	usage, err := voUsage(store, startOfDay(time.Now()))
+	if err != nil {
+		return err
+	}
+
+	for _, vo := range vos {
+		if max := conf.VOQuotas[vo]; max > 0 && len(usage[vo]) >= max {
+			return fmt.Errorf("%w: %d of %d certificates of %s today", ErrVOQuotaExceeded, len(usage[vo]), max, vo)
+		}
+	}
+
+	return nil
+}
+
+// GetAdminVOs is the handler for GET /admin/vos
+//
+//	@Summary		Get usage per VO
+//	@ID				getAdminVos
+//	@Description	Return the number of certificates issued to each configured community/VO since the given time (default: start of the current UTC day), along with its daily quota.
+//	@Tags			admin
+//	@Produce		json
+//	@Security		AdminToken
+//	@Param			since	query		string	false	"RFC 3339 timestamp"
+//	@Success		200		{array}		ApiResponseAdminVO
+//	@Failure		400		{object}	ApiResponseError
+//	@Failure		401		{object}	ApiResponseError
+//	@Failure		403		{object}	ApiResponseError
+//	@Failure		500		{object}	ApiResponseError
+//	@Router			/admin/vos [get]
+func GetAdminVOs(c *gin.Context) {
+	var query QueryAdminAudit
+
+	if c.ShouldBindQuery(&query) != nil {
+		Error(c, http.StatusBadRequest, ERR_BAD_BODY)
+		return
+	}
+
+	if query.Since.IsZero() {
+		query.Since = startOfDay(time.Now())
+	}
+
+	conf := c.MustGet("config").(config.Config)
+
+	usage, err := voUsage(c.MustGet("store").(storage.Store), query.Since)
+	if err != nil {
+		Error(c, http.StatusInternalServerError, ERR_INTERNAL_ERROR)
+		return
+	}
+
+	vos := make([]string, 0, len(conf.VOQuotas))
+	for vo := range conf.VOQuotas {
+		vos = append(vos, vo)
+	}
+	sort.Strings(vos)
+
+	res := []ApiResponseAdminVO{}
+	for _, vo := range vos {
+		subjects := make(map[string]bool)
+		for _, cert := range usage[vo] {
+			subjects[cert.Subject] = true
+		}
+
+		res = append(res, ApiResponseAdminVO{
+			VO:       vo,
+			Issued:   len(usage[vo]),
+			Subjects: len(subjects),
+			Quota:    conf.VOQuotas[vo],
+		})
+	}
+
+	c.JSON(http.StatusOK, res)
+}
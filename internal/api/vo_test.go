@@ -0,0 +1,42 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVOName(t *testing.T) {
+	assert.Equal(t, "myvo", voName("urn:geant:example.org:group:myvo:admins:role=member#aai.example.org"))
+	assert.Equal(t, "myvo", voName("urn:geant:example.org:group:myvo#aai.example.org"))
+	assert.Equal(t, "myvo", voName("urn:geant:example.org:group:myvo"))
+	assert.Equal(t, "myvo", voName("myvo"))
+}
+
+func TestEnforceVOQuota(t *testing.T) {
+	store := storage.NewMemoryStore()
+	conf := config.Config{VOQuotas: map[string]int{"small": 2, "unlimited": 0}}
+
+	validBefore := time.Now().Add(time.Hour)
+
+	for i, vos := range [][]string{{"small"}, {"small", "unlimited"}, {"unlimited"}} {
+		store.AddCertificate(storage.Certificate{Serial: uint64(i + 1), VOs: vos, IssuedAt: time.Now(), ValidBefore: validBefore})
+	}
+
+	// Certificates of previous days don't count
+	store.AddCertificate(storage.Certificate{Serial: 4, VOs: []string{"other"}, IssuedAt: time.Now().Add(-48 * time.Hour), ValidBefore: validBefore})
+
+	assert.NoError(t, enforceVOQuota(store, conf, nil))
+	assert.NoError(t, enforceVOQuota(store, conf, []string{"unlimited"}))
+	assert.ErrorIs(t, enforceVOQuota(store, conf, []string{"small", "unlimited"}), ErrVOQuotaExceeded)
+
+	usage, err := voUsage(store, startOfDay(time.Now()))
+	assert.NoError(t, err)
+	assert.Len(t, usage["small"], 2)
+	assert.Len(t, usage["unlimited"], 2)
+	assert.Len(t, usage["other"], 0)
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"context"
+	"errors"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/oidc"
+	"github.com/lbrocke/oinit/pkg/libmotleycue"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	ERR_WORKLOAD_NOT_ALLOWED = "workload is not allowed for this host"
+)
+
+// isWorkloadToken reports whether the token was issued by an issuer of
+// workload identity tokens, which are verified by the CA instead of
+// motley_cue.
+func isWorkloadToken(conf config.Config, token *jwt.Token) bool {
+	issuer, err := token.Claims.GetIssuer()
+
+	return err == nil && conf.WorkloadIssuer(issuer)
+}
+
+// workloadUser verifies the workload identity token and returns the state
+// of the local account of the workload as motley_cue would, along with the
+// name of the workload, so that certificates are issued like for users.
+func workloadUser(ctx context.Context, conf config.Config, host, token string) (libmotleycue.ApiResponseUserStatus, string, error) {
+	var status libmotleycue.ApiResponseUserStatus
+
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		return status, "workload", err
+	}
+
+	issuer, _ := parsed.Claims.GetIssuer()
+	subject, _ := parsed.Claims.GetSubject()
+
+	workload, ok := conf.Workload(issuer, subject, host)
+	if !ok {
+		return status, "workload", errors.New(ERR_WORKLOAD_NOT_ALLOWED)
+	}
+
+	name := "workload " + workload.Name
+
+	if _, err := oidc.VerifyToken(ctx, token, workload.Issuer, workload.Audience, workload.JWKSURL); err != nil {
+		return status, name, err
+	}
+
+	status.State = libmotleycue.StateDeployed
+	status.Credentials.SSHUser = workload.User
+
+	return status, name, nil
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/pkg/libmotleycue"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkloadUser(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	encode := func(b []byte) string {
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"keys":[{"kid":"k1","kty":"EC","use":"sig","crv":"P-256","x":"` +
+			encode(key.X.FillBytes(make([]byte, 32))) + `","y":"` + encode(key.Y.FillBytes(make([]byte, 32))) + `"}]}`))
+	}))
+	defer jwks.Close()
+
+	issuer := "https://token.actions.githubusercontent.com"
+	conf := config.Config{Workloads: []config.Workload{{
+		Name:            "github",
+		Issuer:          issuer,
+		Audience:        "https://ca.example.com",
+		JWKSURL:         jwks.URL,
+		User:            "deploy",
+		SubjectPatterns: []*regexp.Regexp{regexp.MustCompile("^repo:org/deploy:.*$")},
+		HostPatterns:    []string{"*.example.com"},
+	}}}
+
+	sign := func(subject, audience string, k interface{}) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+			"iss": issuer,
+			"sub": subject,
+			"aud": audience,
+			"exp": time.Now().Add(time.Minute).Unix(),
+		})
+		token.Header["kid"] = "k1"
+
+		signed, err := token.SignedString(k)
+		assert.NoError(t, err)
+
+		return signed
+	}
+
+	token := sign("repo:org/deploy:ref:refs/heads/main", "https://ca.example.com", key)
+
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+	assert.NoError(t, err)
+	assert.True(t, isWorkloadToken(conf, parsed))
+
+	status, upstream, err := workloadUser(context.Background(), conf, "login.example.com", token)
+	assert.NoError(t, err)
+	assert.Equal(t, "workload github", upstream)
+	assert.Equal(t, libmotleycue.StateDeployed, status.State)
+	assert.Equal(t, "deploy", status.Credentials.SSHUser)
+
+	_, _, err = workloadUser(context.Background(), conf, "login.example.org", token)
+	assert.EqualError(t, err, ERR_WORKLOAD_NOT_ALLOWED)
+
+	_, _, err = workloadUser(context.Background(), conf, "login.example.com", sign("repo:org/other:ref:refs/heads/main", "https://ca.example.com", key))
+	assert.EqualError(t, err, ERR_WORKLOAD_NOT_ALLOWED)
+
+	_, _, err = workloadUser(context.Background(), conf, "login.example.com", sign("repo:org/deploy:ref:refs/heads/main", "https://other.example.com", key))
+	assert.Error(t, err)
+
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	_, _, err = workloadUser(context.Background(), conf, "login.example.com", sign("repo:org/deploy:ref:refs/heads/main", "https://ca.example.com", other))
+	assert.Error(t, err)
+}
@@ -0,0 +1,86 @@
+// Package approved restricts keys and signature algorithms to an approved
+// set for sites under FIPS-like mandates: RSA keys of at least MIN_RSA_BITS
+// signing with SHA-2, and ECDSA keys on the P-256 and P-384 curves. Ed25519,
+// P-521 and security keys (sk-*) are not approved.
+//
+// The approved mode is enabled by the approved-algorithms option, and forced
+// in binaries built with the "fips" build tag.
+package approved
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	MIN_RSA_BITS = 3072
+
+	ERR_NOT_APPROVED  = "key type is not approved"
+	ERR_RSA_TOO_SMALL = "rsa key is too small"
+)
+
+// KeyTypes contains the approved SSH key types.
+var KeyTypes = []string{
+	ssh.KeyAlgoRSA,
+	ssh.KeyAlgoECDSA256,
+	ssh.KeyAlgoECDSA384,
+}
+
+// RSASignatureAlgorithms contains the approved signature algorithms of RSA
+// keys, which excludes ssh-rsa (SHA-1).
+var RSASignatureAlgorithms = []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256}
+
+// PublicKey returns an error if the key is not approved.
+func PublicKey(pk ssh.PublicKey) error {
+	if !slices.Contains(KeyTypes, pk.Type()) {
+		return fmt.Errorf("%s: %s", ERR_NOT_APPROVED, pk.Type())
+	}
+
+	if pk.Type() != ssh.KeyAlgoRSA {
+		return nil
+	}
+
+	cpk, ok := pk.(ssh.CryptoPublicKey)
+	if !ok {
+		return errors.New(ERR_NOT_APPROVED)
+	}
+
+	rsaKey, ok := cpk.CryptoPublicKey().(*rsa.PublicKey)
+	if !ok {
+		return errors.New(ERR_NOT_APPROVED)
+	}
+
+	if bits := rsaKey.N.BitLen(); bits < MIN_RSA_BITS {
+		return fmt.Errorf("%s: %d < %d bits", ERR_RSA_TOO_SMALL, bits, MIN_RSA_BITS)
+	}
+
+	return nil
+}
+
+// Signer returns a signer for the private key that only uses approved
+// signature algorithms, or an error if the key is not approved.
+func Signer(key interface{}) (ssh.Signer, error) {
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := PublicKey(signer.PublicKey()); err != nil {
+		return nil, err
+	}
+
+	if signer.PublicKey().Type() != ssh.KeyAlgoRSA {
+		return signer, nil
+	}
+
+	algorithmSigner, ok := signer.(ssh.AlgorithmSigner)
+	if !ok {
+		return nil, errors.New(ERR_NOT_APPROVED)
+	}
+
+	return ssh.NewSignerWithAlgorithms(algorithmSigner, RSASignatureAlgorithms)
+}
@@ -0,0 +1,6 @@
+//go:build fips
+
+package approved
+
+// FORCED enables the approved mode regardless of the config.
+const FORCED = true
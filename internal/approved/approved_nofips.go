@@ -0,0 +1,7 @@
+//go:build !fips
+
+package approved
+
+// FORCED enables the approved mode regardless of the config, which is only
+// the case in binaries built with the "fips" build tag.
+const FORCED = false
@@ -0,0 +1,37 @@
+package approved
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSigner(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	p256Key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p521Key, _ := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	rsa2048Key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsa3072Key, _ := rsa.GenerateKey(rand.Reader, MIN_RSA_BITS)
+
+	for _, key := range []interface{}{edKey, p521Key, rsa2048Key} {
+		_, err := Signer(key)
+		assert.Error(t, err)
+	}
+
+	_, err := Signer(p256Key)
+	assert.NoError(t, err)
+
+	// RSA signatures use SHA-2 rather than SHA-1
+	signer, err := Signer(rsa3072Key)
+	assert.NoError(t, err)
+
+	cert := ssh.Certificate{Key: signer.PublicKey(), CertType: ssh.UserCert}
+	assert.NoError(t, cert.SignCert(rand.Reader, signer))
+	assert.Equal(t, ssh.KeyAlgoRSASHA512, cert.Signature.Format)
+}
@@ -0,0 +1,76 @@
+// Package buildinfo describes the running binary, so operators can verify
+// exactly what is deployed, e.g. during security reviews. Release builds set
+// the version, commit and date using -ldflags, see .goreleaser.yaml. Other
+// builds fall back to the information embedded by the Go toolchain.
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+const (
+	// Signing backend that loads CA private keys from files
+	BACKEND_FILE = "file"
+)
+
+// Backends contains the signing backends compiled into the binary.
+var Backends = []string{BACKEND_FILE}
+
+// Set at build time, see Set.
+var version, commit, date string
+
+type Info struct {
+	Version   string
+	Commit    string
+	Date      string
+	GoVersion string
+	Backends  []string
+}
+
+// Set records the values injected into package main at build time.
+func Set(buildVersion, buildCommit, buildDate string) {
+	version, commit, date = buildVersion, buildCommit, buildDate
+}
+
+// Get returns the build information of the running binary. Values that were
+// not set at build time are taken from debug.ReadBuildInfo, which contains
+// the module version and VCS state of builds from a git checkout.
+func Get() Info {
+	info := Info{
+		Version:   version,
+		Commit:    commit,
+		Date:      date,
+		GoVersion: runtime.Version(),
+		Backends:  Backends,
+	}
+
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+
+	if info.Version == "" {
+		info.Version = build.Main.Version
+	}
+
+	var modified bool
+
+	for _, setting := range build.Settings {
+		switch {
+		case setting.Key == "vcs.revision" && commit == "":
+			info.Commit = setting.Value
+		case setting.Key == "vcs.time" && date == "":
+			info.Date = setting.Value
+		case setting.Key == "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+
+	// Uncommitted changes make the build irreproducible from the commit.
+	if modified && commit == "" && info.Commit != "" {
+		info.Commit += "-dirty"
+	}
+
+	return info
+}
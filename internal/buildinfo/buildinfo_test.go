@@ -0,0 +1,21 @@
+package buildinfo
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGet(t *testing.T) {
+	defer Set("", "", "")
+
+	Set("v1.2.3", "0123456789abcdef", "2024-01-02T03:04:05Z")
+
+	info := Get()
+	assert.Equal(t, "v1.2.3", info.Version)
+	assert.Equal(t, "0123456789abcdef", info.Commit)
+	assert.Equal(t, "2024-01-02T03:04:05Z", info.Date)
+	assert.Equal(t, runtime.Version(), info.GoVersion)
+	assert.Contains(t, info.Backends, BACKEND_FILE)
+}
@@ -0,0 +1,261 @@
+// Package bundle implements encrypted disaster-recovery bundles of the CA
+// state. A bundle contains the configuration file as well as all files
+// referenced by it (such as CA keys), so a standby CA can be set up from a
+// single file after hardware loss.
+package bundle
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	VERSION = 1
+
+	ERR_BAD_BUNDLE     = "bundle is malformed"
+	ERR_BAD_PATH       = "bundle contains invalid path"
+	ERR_BAD_PASSPHRASE = "wrong passphrase or bundle was modified"
+	ERR_EMPTY_PASS     = "passphrase must not be empty"
+
+	// Magic bytes at the start of every bundle file
+	magic = "OINITCA\x00"
+
+	saltSize = 16
+
+	// scrypt parameters as recommended for interactive logins in 2017, see
+	// https://pkg.go.dev/golang.org/x/crypto/scrypt#Key
+	scryptN = 32768
+	scryptR = 8
+	scryptP = 1
+)
+
+// File is a single file stored in a bundle.
+type File struct {
+	Path    string      `json:"path"`
+	Mode    os.FileMode `json:"mode"`
+	Content []byte      `json:"content"`
+}
+
+// Bundle is the plaintext content of a disaster-recovery bundle.
+type Bundle struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	// Config is the absolute path of the configuration file, which is also
+	// contained in Files.
+	Config string `json:"config"`
+	Files  []File `json:"files"`
+}
+
+// New creates a new bundle containing the config file and all other given
+// files. Paths are made absolute, duplicates are removed. Files that are also
+// listed in optional are skipped if they don't exist (yet), such as the
+// storage file of a CA that hasn't issued any certificates.
+func New(config string, files, optional []string) (Bundle, error) {
+	b := Bundle{
+		Version: VERSION,
+		Created: time.Now().UTC(),
+	}
+
+	config, err := filepath.Abs(config)
+	if err != nil {
+		return b, err
+	}
+
+	b.Config = config
+
+	seen := make(map[string]bool)
+
+	skippable := make(map[string]bool)
+	for _, path := range optional {
+		if path == "" {
+			continue
+		}
+
+		if path, err := filepath.Abs(path); err == nil {
+			skippable[path] = true
+		}
+	}
+
+	for _, path := range append([]string{config}, files...) {
+		path, err := filepath.Abs(path)
+		if err != nil {
+			return b, err
+		}
+
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		stat, err := os.Stat(path)
+		if errors.Is(err, fs.ErrNotExist) && skippable[path] {
+			continue
+		} else if err != nil {
+			return b, err
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return b, err
+		}
+
+		b.Files = append(b.Files, File{
+			Path:    path,
+			Mode:    stat.Mode().Perm(),
+			Content: content,
+		})
+	}
+
+	return b, nil
+}
+
+// relativePath returns the absolute path of a bundled file relative to the
+// root of its volume. Paths that could escape the root directory of Restore
+// are rejected.
+func relativePath(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("%s: %q", ERR_BAD_PATH, path)
+	}
+
+	rel := strings.TrimPrefix(path[len(filepath.VolumeName(path)):], string(os.PathSeparator))
+
+	if filepath.IsAbs(rel) || !filepath.IsLocal(rel) || filepath.Clean(rel) != rel {
+		return "", fmt.Errorf("%s: %q", ERR_BAD_PATH, path)
+	}
+
+	return rel, nil
+}
+
+// Restore writes all files of the bundle below the given root directory,
+// keeping their original (absolute) paths and permissions. Use "/" to
+// restore to the original locations. Nothing is written if any path of the
+// bundle is invalid.
+func (b Bundle) Restore(root string) ([]string, error) {
+	var written []string
+
+	paths := make([]string, len(b.Files))
+	for i, file := range b.Files {
+		rel, err := relativePath(file.Path)
+		if err != nil {
+			return written, err
+		}
+
+		paths[i] = filepath.Join(root, rel)
+	}
+
+	for i, file := range b.Files {
+		path := paths[i]
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return written, err
+		}
+
+		if err := os.WriteFile(path, file.Content, file.Mode); err != nil {
+			return written, err
+		}
+
+		written = append(written, path)
+	}
+
+	return written, nil
+}
+
+// deriveKey derives an AES-256 key from passphrase and salt using scrypt.
+func deriveKey(passphrase string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, 32)
+}
+
+// Encrypt serializes and encrypts the bundle with the given passphrase using
+// AES-256-GCM. The resulting format is
+//
+//	magic || salt || nonce || ciphertext
+func (b Bundle) Encrypt(passphrase string) ([]byte, error) {
+	if passphrase == "" {
+		return nil, errors.New(ERR_EMPTY_PASS)
+	}
+
+	plaintext, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := append([]byte(magic), salt...)
+	out = append(out, nonce...)
+
+	// Use the header as additional data, so it can't be modified.
+	return aead.Seal(out, nonce, plaintext, out), nil
+}
+
+// Decrypt decrypts and deserializes a bundle created by Encrypt.
+func Decrypt(data []byte, passphrase string) (Bundle, error) {
+	var b Bundle
+
+	if len(data) < len(magic)+saltSize || string(data[:len(magic)]) != magic {
+		return b, errors.New(ERR_BAD_BUNDLE)
+	}
+
+	salt := data[len(magic) : len(magic)+saltSize]
+
+	aead, err := newAEAD(passphrase, salt)
+	if err != nil {
+		return b, err
+	}
+
+	headerSize := len(magic) + saltSize + aead.NonceSize()
+	if len(data) < headerSize {
+		return b, errors.New(ERR_BAD_BUNDLE)
+	}
+
+	nonce := data[len(magic)+saltSize : headerSize]
+
+	plaintext, err := aead.Open(nil, nonce, data[headerSize:], data[:headerSize])
+	if err != nil {
+		return b, errors.New(ERR_BAD_PASSPHRASE)
+	}
+
+	if json.Unmarshal(plaintext, &b) != nil || b.Version != VERSION {
+		return b, errors.New(ERR_BAD_BUNDLE)
+	}
+
+	return b, nil
+}
+
+func newAEAD(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
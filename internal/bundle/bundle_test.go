@@ -0,0 +1,98 @@
+package bundle
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	dir := t.TempDir()
+
+	config := filepath.Join(dir, "config.ini")
+	key := filepath.Join(dir, "user-ca")
+
+	assert.NoError(t, os.WriteFile(config, []byte("cert-validity = token\n"), 0644))
+	assert.NoError(t, os.WriteFile(key, []byte("secret"), 0600))
+
+	b, err := New(config, []string{key, key}, nil)
+	assert.NoError(t, err)
+	assert.Len(t, b.Files, 2)
+
+	data, err := b.Encrypt("passphrase")
+	assert.NoError(t, err)
+
+	_, err = Decrypt(data, "wrong")
+	assert.EqualError(t, err, ERR_BAD_PASSPHRASE)
+
+	restored, err := Decrypt(data, "passphrase")
+	assert.NoError(t, err)
+	assert.Equal(t, b.Config, restored.Config)
+
+	root := t.TempDir()
+	written, err := restored.Restore(root)
+	assert.NoError(t, err)
+	assert.Len(t, written, 2)
+
+	content, err := os.ReadFile(filepath.Join(root, key))
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", string(content))
+
+	stat, err := os.Stat(filepath.Join(root, key))
+	assert.NoError(t, err)
+
+	// Windows only reports whether files are read-only
+	if runtime.GOOS != "windows" {
+		assert.Equal(t, os.FileMode(0600), stat.Mode().Perm())
+	}
+}
+
+func TestDecryptMalformed(t *testing.T) {
+	_, err := Decrypt([]byte("garbage"), "passphrase")
+	assert.EqualError(t, err, ERR_BAD_BUNDLE)
+}
+
+func TestNewOptional(t *testing.T) {
+	dir := t.TempDir()
+
+	config := filepath.Join(dir, "config.ini")
+	state := filepath.Join(dir, "state.json")
+
+	assert.NoError(t, os.WriteFile(config, []byte("storage = file:"+state+"\n"), 0644))
+
+	_, err := New(config, []string{state}, nil)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	b, err := New(config, []string{state}, []string{state})
+	assert.NoError(t, err)
+	assert.Len(t, b.Files, 1)
+}
+
+func TestRestoreInvalidPath(t *testing.T) {
+	root := t.TempDir()
+	valid, _ := filepath.Abs(filepath.FromSlash("/etc/oinit-ca/config.ini"))
+
+	for _, path := range []string{
+		filepath.FromSlash("etc/passwd"),
+		filepath.FromSlash(filepath.ToSlash(valid) + "/../../../passwd"),
+		filepath.FromSlash("//passwd"),
+		filepath.FromSlash("/etc/./passwd"),
+	} {
+		b := Bundle{Files: []File{
+			{Path: valid, Mode: 0644, Content: []byte("config")},
+			{Path: path, Mode: 0644, Content: []byte("escaped")},
+		}}
+
+		written, err := b.Restore(root)
+		assert.ErrorContains(t, err, ERR_BAD_PATH, path)
+		assert.Empty(t, written)
+	}
+
+	// Nothing is written if any path is invalid
+	entries, err := os.ReadDir(root)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -2,27 +2,467 @@ package config
 
 import (
 	"errors"
+	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/lbrocke/oinit/internal/approved"
+	"github.com/lbrocke/oinit/internal/fault"
+	"github.com/lbrocke/oinit/internal/forcecmd"
+	"github.com/lbrocke/oinit/internal/geoip"
+	"github.com/lbrocke/oinit/internal/memprotect"
+	"github.com/lbrocke/oinit/internal/notify"
+	"github.com/lbrocke/oinit/internal/ntp"
+	"github.com/lbrocke/oinit/internal/sandbox"
+	"github.com/lbrocke/oinit/internal/sshversion"
+	"github.com/lbrocke/oinit/internal/storage"
+	"github.com/lbrocke/oinit/internal/subjects"
 	"github.com/lbrocke/oinit/internal/util"
 
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slices"
 	"gopkg.in/ini.v1"
 )
 
 const (
 	ERR_HOST_NOT_FOUND = "host not found in config"
+
+	DEFAULT_NEGATIVE_CACHE_DURATION = 300
+	DEFAULT_CLOCK_SKEW_TOLERANCE    = 10
+	DEFAULT_REQUEST_TIMEOUT         = 30
+	DEFAULT_IDEMPOTENCY_WINDOW      = 86400
+	DEFAULT_STATUS_CACHE_DURATION   = 10
+	DEFAULT_ASYNC_AFTER             = 10
+
+	DEFAULT_STORAGE = storage.BACKEND_FILE + ":" + storage.DEFAULT_PATH
+	// Duration (in seconds) that audit events are kept for, here: 1 year
+	DEFAULT_AUDIT_RETENTION = 365 * 24 * 3600
+
+	// Userinfo claim containing the entitlements that VOs are derived from
+	DEFAULT_VO_CLAIM = "eduperson_entitlement"
+
+	// Duration (in seconds) that IP addresses and public keys of a subject
+	// are remembered for notifications, here: 90 days
+	DEFAULT_NOTIFY_WINDOW = 90 * 24 * 3600
+
+	// Keyword that disables NTP checks of the local clock
+	NTP_SERVER_NONE = "none"
+
+	// Seconds that browsers only connect to the CA using HTTPS, here: 1 year
+	DEFAULT_HSTS_MAX_AGE = 365 * 24 * 3600
+
+	// Content-Security-Policy-Report-Only of the admin dashboard, which has
+	// inline scripts and styles and only connects to the CA. The keyword
+	// "none" disables the header.
+	DEFAULT_ADMIN_CSP = "default-src 'none'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; " +
+		"connect-src 'self'; img-src 'self' data:; base-uri 'none'; form-action 'none'; frame-ancestors 'none'"
+	ADMIN_CSP_NONE = "none"
+
+	// Extensions of issued certificates if not configured otherwise. The
+	// keyword "none" disables all extensions.
+	DEFAULT_EXTENSIONS = "permit-agent-forwarding,permit-pty"
+	EXTENSIONS_NONE    = "none"
+
+	// Validity (in seconds) of grace certificates, which are issued while
+	// motley_cue is unreachable, here: 10 minutes
+	DEFAULT_GRACE_VALIDITY = 600
+
+	// Validity (in seconds) of emergency certificates, see emergency-keys,
+	// here: 5 minutes
+	DEFAULT_EMERGENCY_VALIDITY = 300
+
+	// Interval (in seconds) at which replicas reload the replicated state
+	// and check for the promote file
+	DEFAULT_REPLICA_SYNC_INTERVAL = 10
+
+	// Validity (in seconds) of host certificates issued to enrolled hosts,
+	// here: 30 days
+	DEFAULT_HOST_CERT_VALIDITY = 30 * 24 * 3600
+
+	// Auto-approval rule of host enrollments that requires the host name to
+	// resolve to the address the request was received from
+	ENROLL_AUTO_DNS = "dns"
+
+	// What happens if a subject requests a certificate while already holding
+	// max-certificates unexpired certificates in a hostgroup
+	QUOTA_DENY          = "deny"
+	QUOTA_REVOKE_OLDEST = "revoke-oldest"
+
+	// Keyword that makes the CA read the OpenSSH version from the SSH
+	// identification string of hosts
+	OPENSSH_VERSION_PROBE = "probe"
+
+	// How a central CA hands requests for hostgroups delegated to a site CA
+	// over: by forwarding them, or by redirecting clients to the site CA
+	DELEGATE_PROXY    = "proxy"
+	DELEGATE_REDIRECT = "redirect"
+
+	// Roles of admin tokens, see api.RolePermissions
+	ROLE_VIEWER           = "viewer"
+	ROLE_OPERATOR         = "operator"
+	ROLE_SECURITY_OFFICER = "security-officer"
 )
 
+// ErrHostNotFound is returned, wrapped with the host, if no hostgroup contains
+// the host.
+var ErrHostNotFound = errors.New(ERR_HOST_NOT_FOUND)
+
+// CertificateExtensions contains the extensions that OpenSSH supports in user
+// certificates, see PROTOCOL.certkeys.
+var CertificateExtensions = []string{
+	"permit-X11-forwarding",
+	"permit-agent-forwarding",
+	"permit-port-forwarding",
+	"permit-pty",
+	"permit-user-rc",
+}
+
+// KeyTypes contains the types of client keys the CA issues certificates for.
+// Certificates themselves are parsable as public keys, but can't be
+// certified again.
+var KeyTypes = []string{
+	ssh.KeyAlgoED25519,
+	ssh.KeyAlgoECDSA256,
+	ssh.KeyAlgoECDSA384,
+	ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoRSA,
+	ssh.KeyAlgoSKED25519,
+	ssh.KeyAlgoSKECDSA256,
+}
+
+// Roles contains all admin roles, ordered by increasing privilege.
+var Roles = []string{ROLE_VIEWER, ROLE_OPERATOR, ROLE_SECURITY_OFFICER}
+
 type DefaultOptions struct {
-	PathHostCAPrivateKey string `ini:"host-ca-privkey"`
-	PathHostCAPublicKey  string `ini:"host-ca-pubkey"`
-	PathUserCAPrivateKey string `ini:"user-ca-privkey"`
-	PathUserCAPublicKey  string `ini:"user-ca-pubkey"`
-	CertValidity         string `ini:"cert-validity"` // allows non-int values, parsed manually
-	CacheDuration        int    `ini:"cache-duration"`
+	PathHostCAPrivateKey string `ini:"host-ca-privkey" validate:"required,file"`
+	PathHostCAPublicKey  string `ini:"host-ca-pubkey" validate:"required,file"`
+	PathUserCAPrivateKey string `ini:"user-ca-privkey" validate:"required,file"`
+	PathUserCAPublicKey  string `ini:"user-ca-pubkey" validate:"required,file"`
+	CertValidity         string `ini:"cert-validity" validate:"required,validity"` // allows non-int values, parsed manually
+	CacheDuration        int    `ini:"cache-duration" validate:"required,gt=0"`
+	PathForceCommandKey  string `ini:"force-command-key" validate:"omitempty,file"` // optional
+	ForceCommand         string `ini:"force-command"`                               // template, see forcecmd.Template
+	ForceCommandFeatures string `ini:"force-command-features"`                      // comma-separated, parsed manually
+	PathMotleyCueKey     string `ini:"motley-cue-key" validate:"omitempty,file"`    // optional, signs requests to motley_cue
+	Extensions           string `ini:"extensions"`                                  // comma-separated, parsed manually
+	MaxCertificates      int    `ini:"max-certificates" validate:"gte=0"`           // 0 = unlimited
+	QuotaAction          string `ini:"quota-action" validate:"omitempty,oneof=deny revoke-oldest"`
+	Message              string `ini:"message"`                                      // shown to users before connecting
+	OpenSSHVersion       string `ini:"openssh-version"`                              // version of sshd on the hosts, or "probe"
+	EagerDeploy          bool   `ini:"eager-deploy"`                                 // deploy users on all hosts at issuance
+	ProfileNames         string `ini:"profiles"`                                     // comma-separated, the first is the default
+	Principals           string `ini:"principals" validate:"omitempty,principals"`   // principals policy
+	HostPrincipals       bool   `ini:"host-principals"`                              // principals only valid for the requested host
+	TokenBinding         bool   `ini:"token-binding"`                                // hosts require logins to present the access token
+	Features             string `ini:"features"`                                     // comma-separated, "-" disables
+	SupportContact       string `ini:"support-contact"`                              // shown to users whose requests are denied
+	EnrollmentURL        string `ini:"enrollment-url" validate:"omitempty,http_url"` // where users register, defaults to motley_cue's login help
+	GeoDeny              string `ini:"geo-deny"`                                     // comma-separated countries and ASNs, see GeoAction
+	GeoLimit             string `ini:"geo-limit"`
+	GeoLimitValidity     int    `ini:"geo-limit-validity" validate:"required_with=GeoLimit,gte=0"` // maximum validity for geo-limit networks
+	GracePeriod          int    `ini:"grace-period" validate:"gte=0"`                              // 0 = disabled
+	GraceValidity        int    `ini:"grace-validity" validate:"gte=0"`
+	KeyTypeNames         string `ini:"key-types"`                     // comma-separated, parsed manually
+	MinRSABits           int    `ini:"min-rsa-bits" validate:"gte=0"` // 0 = any size
+	// Files listing subjects that may or may not request certificates, see
+	// package subjects
+	PathSubjectAllow string `ini:"subject-allow"`
+	PathSubjectDeny  string `ini:"subject-deny"`
+	// Windows during which certificates are not issued, see FreezeWindow
+	Freeze        string `ini:"freeze-windows"` // separated by "|", parsed manually
+	FreezeMessage string `ini:"freeze-message"` // shown to users during freezes
+
+	// Hosts requesting host certificates for their host keys, see
+	// EnrollmentRules
+	HostEnrollment    bool   `ini:"host-enrollment"`
+	EnrollAutoApprove string `ini:"enroll-auto-approve"` // comma-separated, parsed manually
+	HostCertValidity  int    `ini:"host-cert-validity" validate:"gte=0"`
+
+	// Hostgroups delegated to a site CA, whose user CA key is certified by
+	// the user CA key of the hostgroup
+	Delegate                string `ini:"delegate" validate:"omitempty,http_url"` // URL of the site CA
+	DelegateMode            string `ini:"delegate-mode" validate:"omitempty,oneof=proxy redirect"`
+	PathDelegateCAPublicKey string `ini:"delegate-ca-pubkey" validate:"required_with=Delegate,omitempty,file"`
+}
+
+// ServerOptions are global options that can only be set in the default
+// section and cannot be overridden by host groups.
+type ServerOptions struct {
+	// If set, hosts must also resolve in DNS to be considered known.
+	StrictHosts bool `ini:"strict-hosts"`
+	// Duration (in seconds) that unknown hosts are remembered for.
+	NegativeCacheDuration int `ini:"negative-cache-duration"`
+	// Seconds that the validity of certificates starts in the past, so hosts
+	// with slightly skewed clocks accept them.
+	ClockSkewTolerance int `ini:"clock-skew-tolerance"`
+	// Maximum duration (in seconds) of a request, including all lookups and
+	// requests to motley_cue and OIDC providers.
+	RequestTimeout int `ini:"request-timeout"`
+	// NTP server that the local clock is checked against
+	NTPServer string `ini:"ntp-server"`
+	// Duration (in seconds) that jti claims of tokens are remembered for per
+	// host to detect replays, at most until the certificate issued for them
+	// expires. 0 disables replay detection.
+	ReplayWindow int `ini:"replay-window"`
+	// Duration (in seconds) that certificates issued for requests with an
+	// Idempotency-Key are returned again on retries, negative disables it.
+	IdempotencyWindow int `ini:"idempotency-window"`
+	// Duration (in seconds) that motley_cue states of deployed users are
+	// cached per token and host, negative disables caching.
+	StatusCacheDuration int `ini:"status-cache-duration"`
+	// Duration (in seconds) after which certificate requests of clients
+	// preferring respond-async are answered with 202 Accepted, negative
+	// disables it.
+	AsyncAfter int `ini:"async-after"`
+	// Storage backend, see package storage
+	Storage string `ini:"storage"`
+	// Duration (in seconds) after which audit events are removed from the
+	// storage, negative keeps them forever
+	AuditRetention int `ini:"audit-retention"`
+	// Run as warm standby of a primary CA whose storage file is replicated
+	// to this CA, which doesn't sign certificates until it is promoted, see
+	// storage.ReplicaStore. Requires file storage.
+	Replica bool `ini:"replica"`
+	// File whose existence promotes the replica, which is created when the
+	// replica is promoted by the admin API
+	PromoteFile string `ini:"promote-file"`
+	// Interval (in seconds) at which the replica reloads the replicated state
+	// and checks for the promote file
+	ReplicaSyncInterval int `ini:"replica-sync-interval" validate:"gte=0"`
+	// File containing admin API tokens, one "<name> <token> [role]" per line
+	PathAdminTokens string `ini:"admin-tokens" validate:"omitempty,file"`
+	// File containing rules that map OIDC claims to admin roles, one
+	// "<issuer> <claim> <value> <role>" per line
+	PathAdminOIDC string `ini:"admin-oidc" validate:"omitempty,file"`
+	// File containing communities/VOs that issuances are accounted to, one
+	// "<vo> <max-certificates-per-day>" per line, 0 = unlimited
+	PathVOQuotas string `ini:"vo-quotas" validate:"omitempty,file"`
+	// Userinfo claim that VOs are derived from
+	VOClaim string `ini:"vo-claim"`
+	// Users are notified of certificates issued from a new IP address or for
+	// a new public key by email (to their email claim) and/or in a Matrix room.
+	NotifySMTPServer       string `ini:"notify-smtp-server"`
+	NotifySMTPFrom         string `ini:"notify-smtp-from" validate:"required_with=NotifySMTPServer"`
+	PathNotifySMTPAuth     string `ini:"notify-smtp-auth" validate:"omitempty,file"` // "<username> <password>"
+	NotifyMatrixHomeserver string `ini:"notify-matrix-homeserver" validate:"omitempty,http_url"`
+	NotifyMatrixRoom       string `ini:"notify-matrix-room" validate:"required_with=NotifyMatrixHomeserver"`
+	PathNotifyMatrixToken  string `ini:"notify-matrix-token" validate:"required_with=NotifyMatrixHomeserver,omitempty,file"`
+	// Duration (in seconds) that IP addresses and public keys of a subject
+	// are considered known
+	NotifyWindow int `ini:"notify-window"`
+	// File containing the hardware-backed (sk-*) public keys of incident
+	// responders in authorized_keys format, who may request emergency
+	// certificates without OIDC, see api.PostHostEmergency. The comment of
+	// a key names the responder.
+	PathEmergencyKeys string `ini:"emergency-keys" validate:"omitempty,file"`
+	// Validity (in seconds) of emergency certificates, at most 1 hour
+	EmergencyValidity int `ini:"emergency-validity" validate:"gte=0,lte=3600"`
+	// Comma-separated email addresses of security contacts, who are alerted
+	// of every emergency certificate by all notifiers
+	EmergencyContacts string `ini:"emergency-contacts" validate:"required_with=PathEmergencyKeys"`
+	// After loading keys and config, the CA switches to this user, changes
+	// its root directory and applies the comma-separated sandboxes, see
+	// package sandbox.
+	User    string `ini:"user"`
+	Chroot  string `ini:"chroot"`
+	Sandbox string `ini:"sandbox"`
+	// Restrict CA keys, certified keys and signature algorithms to the set
+	// of package approved. Always enabled in builds with the fips tag.
+	ApprovedAlgorithms bool `ini:"approved-algorithms"`
+	// Fault injection for staging deployments, see package fault. The
+	// latency is given in milliseconds.
+	FaultLatency   int     `ini:"fault-latency"`
+	FaultErrorRate float64 `ini:"fault-error-rate"`
+	FaultTargets   string  `ini:"fault-targets"`
+	// Comma-separated, ordered list of optional middleware, see
+	// MIDDLEWARE_*, which listeners may override
+	Middleware string `ini:"middleware"`
+	// Requests per minute of each client address, for the rate-limit
+	// middleware
+	RateLimit int `ini:"rate-limit"`
+	// For the tarpit middleware: failed authentications of a client address
+	// or subject after which its requests are delayed, the maximum delay in
+	// seconds, and failures after which an alert is logged
+	TarpitThreshold int `ini:"tarpit-threshold"`
+	TarpitMaxDelay  int `ini:"tarpit-max-delay"`
+	TarpitAlert     int `ini:"tarpit-alert"`
+	// Comma-separated origins allowed to make cross-origin requests, or "*",
+	// for the cors middleware
+	CORSAllowOrigins string `ini:"cors-origins"`
+	// Serve anonymous usage statistics at /stats
+	UsageStats bool `ini:"usage-stats"`
+	// Comma-separated addresses and CIDR ranges of reverse proxies whose
+	// X-Forwarded-For headers are trusted to contain the client address
+	TrustedProxies string `ini:"trusted-proxies"`
+	// Path that reverse proxies serve the CA at, such as "/oinit-ca", which
+	// URLs in responses are prefixed with, see CleanBasePath
+	BasePath string `ini:"base-path"`
+	// Seconds of the Strict-Transport-Security header of responses to HTTPS
+	// requests, negative disables it
+	HSTSMaxAge int `ini:"hsts-max-age"`
+	// Content-Security-Policy-Report-Only header of the admin dashboard
+	AdminCSP string `ini:"admin-csp"`
+	// MaxMind DB files, such as GeoLite2-Country and GeoLite2-ASN, that the
+	// geo rules of hostgroups are evaluated against
+	PathGeoIPCountryDB string `ini:"geoip-country-db" validate:"omitempty,file"`
+	PathGeoIPASNDB     string `ini:"geoip-asn-db" validate:"omitempty,file"`
+}
+
+// Faults returns the fault injector configured by the fault-* options, or nil
+// if fault injection is disabled.
+func (o ServerOptions) Faults() (*fault.Injector, error) {
+	return fault.New(time.Duration(o.FaultLatency)*time.Millisecond, o.FaultErrorRate, o.FaultTargets)
+}
+
+// CORSOrigins returns the origins of cors-origins.
+func (o ServerOptions) CORSOrigins() []string {
+	return splitList(o.CORSAllowOrigins)
+}
+
+// EmergencyContactList returns the addresses of emergency-contacts.
+func (o ServerOptions) EmergencyContactList() []string {
+	return splitList(o.EmergencyContacts)
+}
+
+// Proxies returns the addresses and CIDR ranges of trusted-proxies.
+func (o ServerOptions) Proxies() []string {
+	return splitList(o.TrustedProxies)
+}
+
+// CleanBasePath returns the base path without a trailing slash, or an empty
+// string for the root. It must be an absolute path without query or
+// fragment.
+func CleanBasePath(path string) (string, error) {
+	path = strings.TrimRight(path, "/")
+	if path == "" {
+		return "", nil
+	}
+
+	u, err := url.Parse(path)
+	if err != nil || !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || u.Path != path {
+		return "", errors.New("invalid base path " + path)
+	}
+
+	return path, nil
+}
+
+// StorageOptions returns the options of the storage backend, see
+// storage.Open.
+func (o ServerOptions) StorageOptions() []storage.Option {
+	return []storage.Option{storage.WithAuditRetention(time.Duration(o.AuditRetention) * time.Second)}
+}
+
+// setDefaults sets options that are not configured to their default values.
+func (o *ServerOptions) setDefaults() {
+	if o.NegativeCacheDuration <= 0 {
+		o.NegativeCacheDuration = DEFAULT_NEGATIVE_CACHE_DURATION
+	}
+
+	if o.ClockSkewTolerance <= 0 {
+		o.ClockSkewTolerance = DEFAULT_CLOCK_SKEW_TOLERANCE
+	}
+
+	if o.RequestTimeout <= 0 {
+		o.RequestTimeout = DEFAULT_REQUEST_TIMEOUT
+	}
+
+	if o.IdempotencyWindow == 0 {
+		o.IdempotencyWindow = DEFAULT_IDEMPOTENCY_WINDOW
+	}
+
+	if o.StatusCacheDuration == 0 {
+		o.StatusCacheDuration = DEFAULT_STATUS_CACHE_DURATION
+	}
+
+	if o.AsyncAfter == 0 {
+		o.AsyncAfter = DEFAULT_ASYNC_AFTER
+	}
+
+	if o.NTPServer == "" {
+		o.NTPServer = ntp.DEFAULT_SERVER
+	}
+
+	if o.Storage == "" {
+		o.Storage = DEFAULT_STORAGE
+	}
+
+	if o.AuditRetention == 0 {
+		o.AuditRetention = DEFAULT_AUDIT_RETENTION
+	}
+
+	if o.ReplicaSyncInterval == 0 {
+		o.ReplicaSyncInterval = DEFAULT_REPLICA_SYNC_INTERVAL
+	}
+
+	if o.VOClaim == "" {
+		o.VOClaim = DEFAULT_VO_CLAIM
+	}
+
+	if o.NotifyWindow <= 0 {
+		o.NotifyWindow = DEFAULT_NOTIFY_WINDOW
+	}
+
+	if o.EmergencyValidity <= 0 {
+		o.EmergencyValidity = DEFAULT_EMERGENCY_VALIDITY
+	}
+
+	if o.Middleware == "" {
+		o.Middleware = DEFAULT_MIDDLEWARE
+	}
+
+	if o.HSTSMaxAge == 0 {
+		o.HSTSMaxAge = DEFAULT_HSTS_MAX_AGE
+	}
+
+	if o.AdminCSP == "" {
+		o.AdminCSP = DEFAULT_ADMIN_CSP
+	}
+
+	if o.RateLimit <= 0 {
+		o.RateLimit = DEFAULT_RATE_LIMIT
+	}
+
+	if o.TarpitThreshold <= 0 {
+		o.TarpitThreshold = DEFAULT_TARPIT_THRESHOLD
+	}
+
+	if o.TarpitMaxDelay <= 0 {
+		o.TarpitMaxDelay = DEFAULT_TARPIT_MAX_DELAY
+	}
+
+	if o.TarpitAlert <= 0 {
+		o.TarpitAlert = DEFAULT_TARPIT_ALERT
+	}
+}
+
+// AdminToken is a token that grants access to the admin API. The name is
+// used to identify the admin in the audit trail, the role determines which
+// admin endpoints may be used.
+type AdminToken struct {
+	Name  string
+	Token string
+	Role  string
+}
+
+// EmergencyKey is a hardware-backed key of an incident responder, who may
+// request emergency certificates with signatures made by it.
+type EmergencyKey struct {
+	Name      string
+	PublicKey ssh.PublicKey
+}
+
+// AdminOIDCRule grants the role to holders of access tokens of the issuer
+// whose userinfo claim contains the value, such as an entitlement or group.
+type AdminOIDCRule struct {
+	Issuer string
+	Claim  string
+	Value  string
+	Role   string
 }
 
 type Keys struct {
@@ -30,26 +470,148 @@ type Keys struct {
 	HostCAPublicKey  ssh.PublicKey
 	UserCAPrivateKey interface{}
 	UserCAPublicKey  ssh.PublicKey
+	// Shared key to sign the force-command with, nil if not configured
+	ForceCommandKey []byte
+	// Shared key to sign requests to motley_cue with, nil if not configured
+	MotleyCueKey []byte
+	// User CA key of the site CA the hostgroup is delegated to, nil if not
+	// delegated
+	DelegateCAPublicKey ssh.PublicKey
 }
 
 type HostGroup struct {
 	DefaultOptions
 	Keys
 	CertDuration int
-	Name         string
-	Hosts        map[string]string
+	// Extensions that issued certificates may contain
+	AllowedExtensions []string
+	Name              string
+	Hosts             map[string]string
+	Profiles          []Profile
+	// Features disabled for the hostgroup, see parseFeatures
+	DisabledFeatures map[string]bool
+	// Countries and autonomous systems of the geo rules, see parseGeo
+	GeoDenied  []string
+	GeoLimited []string
+	// Parsed force-command option
+	ForceCommandTemplate forcecmd.Template
+	// Features supported by the force-command, see forcecmd.Features
+	SupportedFeatures []string
+	// Client key types accepted by the hostgroup, see KeyTypes
+	AcceptedKeyTypes []string
+	// Parsed enroll-auto-approve option
+	AutoApprove EnrollmentRules
+	// Lists of the subject-allow and subject-deny options, nil if not set
+	SubjectAllow *subjects.List
+	SubjectDeny  *subjects.List
+	// Parsed freeze-windows option
+	FreezeWindows FreezeWindows
+}
+
+// EnrollmentRules determine which host enrollments are approved without an
+// admin: those of hosts whose name resolves to the address the request was
+// received from if DNS is set, and those received from one of Networks.
+type EnrollmentRules struct {
+	DNS      bool
+	Networks []*net.IPNet
 }
 
 type Config struct {
-	HostGroups []HostGroup
+	Server         ServerOptions
+	AdminTokens    []AdminToken
+	AdminOIDCRules []AdminOIDCRule
+	EmergencyKeys  []EmergencyKey
+	// Maximum number of certificates per day of each VO, 0 = unlimited
+	VOQuotas  map[string]int
+	Notifiers []notify.Notifier
+	// Set if the email notifier is configured, so the email claim of users
+	// is required
+	NotifyEmail bool
+	HostGroups  []HostGroup
+	// Certificate profiles by name
+	Profiles map[string]Profile
+	// Peer CAs serving hosts of other infrastructures, in config order
+	Peers []Peer
+	// Addresses to listen on, in config order. If empty, the address and
+	// mode passed to the serve command are used.
+	Listeners []Listener
+	// Issuers of workload identity tokens, in config order
+	Workloads []Workload
+	// GeoIP databases of the geoip-* options, empty if not configured
+	GeoIP geoip.Databases
 }
 
 // HostInfo is returned from the GetInfo function
 type HostInfo struct {
-	Name          string
-	URL           string
+	Name      string
+	HostGroup string
+	// URL of the primary motley_cue instance, which is URLs[0]
+	URL string
+	// URLs of all motley_cue instances of the host, in order of preference
+	URLs          []string
 	CertDuration  int
 	CacheDuration int
+	Extensions    []string
+	// Maximum number of unexpired certificates per subject, 0 = unlimited
+	MaxCertificates int
+	QuotaAction     string
+	// Informational message for users, such as announced maintenance
+	Message string
+	// OpenSSH version of the host, OPENSSH_VERSION_PROBE or empty if unknown
+	OpenSSHVersion string
+	// Deploy users on the other hosts of the hostgroup at issuance
+	EagerDeploy bool
+	// Certificate profiles offered by the host, the first is the default
+	Profiles []Profile
+	// Principals policy, PRINCIPALS_USER or PRINCIPALS_ALL
+	Principals string
+	// Principals of certificates are suffixed with "@<host>", so that they
+	// are only accepted by the host they were requested for
+	HostPrincipals bool
+	// Hosts require logins to present the access token the certificate was
+	// issued for, so clients send it, see package tokenbind
+	TokenBinding bool
+	// URL of the site CA that issues certificates for the host, empty if
+	// not delegated, and DELEGATE_PROXY or DELEGATE_REDIRECT
+	Delegate     string
+	DelegateMode string
+	// Features disabled for the host, see FEATURE_*
+	DisabledFeatures map[string]bool
+	// Shown to users whose requests are denied
+	SupportContact string
+	EnrollmentURL  string
+	// Countries and ASNs that requests are denied from or whose
+	// certificates are valid for at most GeoLimitValidity seconds
+	GeoDeny          []string
+	GeoLimit         []string
+	GeoLimitValidity int
+	// Lists of subjects that may or may not request certificates, nil if
+	// not configured, see SubjectAllowed
+	SubjectAllow *subjects.List
+	SubjectDeny  *subjects.List
+	// Windows during which certificates are not issued, unless lifted by an
+	// admin, and the message shown to users meanwhile
+	FreezeWindows FreezeWindows
+	FreezeMessage string
+	// Duration (in seconds) that a successful authorization of a subject
+	// allows grace certificates, valid for GraceValidity seconds, to be
+	// issued while motley_cue is unreachable. 0 disables grace certificates.
+	GracePeriod   int
+	GraceValidity int
+	// Client key types accepted for the host and minimum size of RSA keys,
+	// 0 = any size
+	KeyTypes   []string
+	MinRSABits int
+	// Program and arguments of the force-command of issued certificates
+	ForceCommand forcecmd.Template
+	// Kinds of sessions the force-command supports, see forcecmd.Features
+	ForceCommandFeatures []string
+	// Whether hosts may request host certificates, valid for
+	// HostCertValidity seconds, and which requests are approved without an
+	// admin
+	HostEnrollment   bool
+	HostCertValidity int
+	AutoApprove      EnrollmentRules
 	Keys
 }
 
@@ -66,24 +628,76 @@ func Load(path string) (Config, error) {
 		return conf, err
 	}
 
+	if err := cfg.MapTo(&conf.Server); err != nil {
+		return conf, err
+	}
+
+	// Problems with options are collected and reported together
+	errs := validateOptions(&conf.Server, "")
+
+	conf.Server.setDefaults()
+
+	options := optionKeys()
+
+	conf.Profiles = make(map[string]Profile)
+
 	// ini doesn't support mapping to map[string]string, do it manually
 	for _, hostgroup := range cfg.Sections() {
 		if hostgroup.Name() == ini.DefaultSection {
 			continue
 		}
 
-		// prefill with global values
-		opts := &DefaultOptions{
-			PathHostCAPrivateKey: defOptions.PathHostCAPrivateKey,
-			PathHostCAPublicKey:  defOptions.PathHostCAPublicKey,
-			PathUserCAPrivateKey: defOptions.PathUserCAPrivateKey,
-			PathUserCAPublicKey:  defOptions.PathUserCAPublicKey,
-			CertValidity:         defOptions.CertValidity,
-			CacheDuration:        defOptions.CacheDuration,
+		if isProfileSection(hostgroup) {
+			profile, err := parseProfile(hostgroup)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+
+			conf.Profiles[profile.Name] = profile
+			continue
+		}
+
+		if isPeerSection(hostgroup) {
+			peer, err := parsePeer(hostgroup)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+
+			conf.Peers = append(conf.Peers, peer)
+			continue
 		}
 
+		if isListenSection(hostgroup) {
+			listener, err := parseListener(hostgroup, conf.Server)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+
+			conf.Listeners = append(conf.Listeners, listener)
+			continue
+		}
+
+		if isWorkloadSection(hostgroup) {
+			workload, err := parseWorkload(hostgroup)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+
+			conf.Workloads = append(conf.Workloads, workload)
+			continue
+		}
+
+		// prefill with global values
+		opts := new(DefaultOptions)
+		*opts = defOptions
+
 		if err := hostgroup.MapTo(opts); err != nil {
-			return conf, err
+			errs = append(errs, err)
+			continue
 		}
 
 		hg := &HostGroup{
@@ -94,9 +708,7 @@ func Load(path string) (Config, error) {
 
 		hosts := make(map[string]string)
 		for key, val := range hostgroup.KeysHash() {
-			if key == "host-ca-privkey" || key == "host-ca-pubkey" ||
-				key == "user-ca-privkey" || key == "user-ca-pubkey" ||
-				key == "cert-validity" || key == "cache-duration" {
+			if slices.Contains(options, key) {
 				continue
 			}
 
@@ -105,30 +717,158 @@ func Load(path string) (Config, error) {
 
 		hg.Hosts = hosts
 
-		if hg.Name != ini.DefaultSection &&
-			(hg.PathHostCAPrivateKey == "" ||
-				hg.PathHostCAPublicKey == "" ||
-				hg.PathUserCAPrivateKey == "" ||
-				hg.PathUserCAPublicKey == "" ||
-				hg.CertValidity == "" ||
-				hg.CacheDuration == 0) {
-			return conf, errors.New("missing option in hostgroup " + hg.Name)
+		if optErrs := validateOptions(&hg.DefaultOptions, " in hostgroup "+hg.Name); optErrs != nil {
+			errs = append(errs, optErrs...)
+			continue
+		}
+
+		if err := setHostGroupDefaults(hg); err != nil {
+			errs = append(errs, err)
+			continue
 		}
 
 		conf.HostGroups = append(conf.HostGroups, *hg)
 	}
 
+	if len(errs) > 0 {
+		return conf, errors.Join(errs...)
+	}
+
 	if loadKeys(&conf) != nil {
 		return conf, errors.New("could not open and parse keys")
 	}
 
+	if approved.FORCED {
+		conf.Server.ApprovedAlgorithms = true
+	}
+
+	if conf.Server.ApprovedAlgorithms {
+		if err := checkApprovedKeys(conf); err != nil {
+			return conf, err
+		}
+	}
+
+	if err := checkOpenSSHVersions(conf); err != nil {
+		return conf, err
+	}
+
 	if parseCertValidity(&conf) != nil {
 		return conf, errors.New("could not parse certificate validities")
 	}
 
+	if err := parseExtensions(&conf); err != nil {
+		return conf, errors.New("could not parse extensions: " + err.Error())
+	}
+
+	if err := resolveProfiles(&conf); err != nil {
+		return conf, err
+	}
+
+	if err := loadGeoIP(&conf); err != nil {
+		return conf, errors.New("could not open geoip database " + err.Error())
+	}
+
+	if err := parseGeo(&conf); err != nil {
+		return conf, err
+	}
+
+	if err := loadSubjectLists(&conf); err != nil {
+		return conf, err
+	}
+
+	if err := parseFreeze(&conf); err != nil {
+		return conf, err
+	}
+
+	if _, err := conf.Server.Faults(); err != nil {
+		return conf, err
+	}
+
+	if conf.Server.Replica && storage.Path(conf.Server.Storage) == "" {
+		return conf, errors.New("replica requires file storage")
+	}
+
+	if _, _, err := sandbox.Parse(conf.Server.Sandbox); err != nil {
+		return conf, err
+	}
+
+	if err := checkMiddleware(conf); err != nil {
+		return conf, err
+	}
+
+	for _, proxy := range conf.Server.Proxies() {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return conf, errors.New("invalid trusted proxy " + proxy)
+		}
+	}
+
+	if conf.Server.BasePath, err = CleanBasePath(conf.Server.BasePath); err != nil {
+		return conf, err
+	}
+
+	if conf.Server.PathAdminTokens != "" {
+		tokens, err := parseAdminTokensFile(conf.Server.PathAdminTokens)
+		if err != nil {
+			return conf, errors.New("could not parse admin tokens: " + err.Error())
+		}
+
+		conf.AdminTokens = tokens
+	}
+
+	if conf.Server.PathAdminOIDC != "" {
+		rules, err := parseAdminOIDCFile(conf.Server.PathAdminOIDC)
+		if err != nil {
+			return conf, errors.New("could not parse admin oidc rules: " + err.Error())
+		}
+
+		conf.AdminOIDCRules = rules
+	}
+
+	if conf.Server.PathVOQuotas != "" {
+		quotas, err := parseVOQuotasFile(conf.Server.PathVOQuotas)
+		if err != nil {
+			return conf, errors.New("could not parse vo quotas: " + err.Error())
+		}
+
+		conf.VOQuotas = quotas
+	}
+
+	if err := loadNotifiers(&conf); err != nil {
+		return conf, errors.New("could not configure notifications: " + err.Error())
+	}
+
+	if conf.Server.PathEmergencyKeys != "" {
+		keys, err := parseEmergencyKeysFile(conf.Server.PathEmergencyKeys)
+		if err != nil {
+			return conf, errors.New("could not parse emergency keys: " + err.Error())
+		}
+
+		// Emergency certificates must not be issued without alerting anyone
+		if len(conf.Notifiers) == 0 {
+			return conf, errors.New("emergency-keys requires notify-smtp-server or notify-matrix-homeserver")
+		}
+
+		conf.EmergencyKeys = keys
+	}
+
 	return conf, nil
 }
 
+// optionKeys returns the ini keys of all options in DefaultOptions. All other
+// keys in a hostgroup section are hosts.
+func optionKeys() []string {
+	var keys []string
+
+	t := reflect.TypeOf(DefaultOptions{})
+	for i := 0; i < t.NumField(); i++ {
+		if key := t.Field(i).Tag.Get("ini"); key != "" {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
 func loadKeys(conf *Config) error {
 	var uniqPubKeys = make(map[string]ssh.PublicKey)
 	var uniqPrivKeys = make(map[string]interface{})
@@ -164,6 +904,89 @@ func loadKeys(conf *Config) error {
 		conf.HostGroups[i].Keys.UserCAPublicKey = uniqPubKeys[group.PathUserCAPublicKey]
 		conf.HostGroups[i].Keys.HostCAPrivateKey = uniqPrivKeys[group.PathHostCAPrivateKey]
 		conf.HostGroups[i].Keys.UserCAPrivateKey = uniqPrivKeys[group.PathUserCAPrivateKey]
+
+		if group.PathDelegateCAPublicKey != "" {
+			pk, err := parsePublicKeyFile(group.PathDelegateCAPublicKey)
+			if err != nil {
+				return err
+			}
+
+			conf.HostGroups[i].Keys.DelegateCAPublicKey = pk
+		}
+
+		if group.PathForceCommandKey != "" {
+			key, err := forcecmd.LoadKey(group.PathForceCommandKey)
+			if err != nil {
+				return err
+			}
+
+			conf.HostGroups[i].Keys.ForceCommandKey = key
+		}
+
+		if group.PathMotleyCueKey != "" {
+			key, err := forcecmd.LoadKey(group.PathMotleyCueKey)
+			if err != nil {
+				return err
+			}
+
+			conf.HostGroups[i].Keys.MotleyCueKey = key
+		}
+	}
+
+	return nil
+}
+
+// setHostGroupDefaults sets the defaults of unset options of the validated
+// hostgroup and parses the options that are not covered by validate tags.
+func setHostGroupDefaults(hg *HostGroup) error {
+	var err error
+
+	if hg.QuotaAction == "" {
+		hg.QuotaAction = QUOTA_DENY
+	}
+
+	if hg.Principals == "" {
+		hg.Principals = PRINCIPALS_USER
+	}
+
+	if hg.Delegate != "" {
+		hg.Delegate = strings.TrimSuffix(hg.Delegate, "/")
+
+		if hg.DelegateMode == "" {
+			hg.DelegateMode = DELEGATE_PROXY
+		}
+	}
+
+	if hg.ForceCommand == "" {
+		hg.ForceCommand = forcecmd.DEFAULT_TEMPLATE
+	}
+
+	if hg.ForceCommandTemplate, err = forcecmd.ParseTemplate(hg.ForceCommand); err != nil {
+		return errors.New(err.Error() + " in hostgroup " + hg.Name)
+	}
+
+	if hg.SupportedFeatures, err = parseForceCommandFeatures(hg.ForceCommandFeatures); err != nil {
+		return errors.New(err.Error() + " in hostgroup " + hg.Name)
+	}
+
+	if hg.DisabledFeatures, err = parseFeatures(hg.Features); err != nil {
+		return errors.New(err.Error() + " in hostgroup " + hg.Name)
+	}
+
+	if hg.AcceptedKeyTypes, err = parseKeyTypes(hg.KeyTypeNames); err != nil {
+		return errors.New(err.Error() + " in hostgroup " + hg.Name)
+	}
+
+	if hg.GraceValidity == 0 {
+		hg.GraceValidity = DEFAULT_GRACE_VALIDITY
+	}
+
+	if hg.HostCertValidity == 0 {
+		hg.HostCertValidity = DEFAULT_HOST_CERT_VALIDITY
+	}
+
+	if hg.AutoApprove, err = parseEnrollmentRules(hg.EnrollAutoApprove); err != nil {
+		return errors.New(err.Error() + " in hostgroup " + hg.Name)
 	}
 
 	return nil
@@ -171,19 +994,172 @@ func loadKeys(conf *Config) error {
 
 func parseCertValidity(conf *Config) error {
 	for i, group := range conf.HostGroups {
-		validity := group.CertValidity
+		dur, err := parseValidity(group.CertValidity)
+		if err != nil {
+			return err
+		}
+
+		conf.HostGroups[i].CertDuration = dur
+	}
+
+	return nil
+}
+
+// parseValidity parses a cert-validity option, which is either a number of
+// seconds or "token" (returned as 0).
+func parseValidity(validity string) (int, error) {
+	if validity == "token" {
+		return 0, nil
+	}
+
+	return strconv.Atoi(validity)
+}
+
+func parseExtensions(conf *Config) error {
+	for i, group := range conf.HostGroups {
+		value := group.Extensions
+		if value == "" {
+			value = DEFAULT_EXTENSIONS
+		}
 
-		if validity == "token" {
-			conf.HostGroups[i].CertDuration = 0
+		extensions, err := parseExtensionList(value)
+		if err != nil {
+			return errors.New(err.Error() + " in hostgroup " + group.Name)
+		}
+
+		conf.HostGroups[i].AllowedExtensions = extensions
+	}
+
+	return nil
+}
+
+// parseExtensionList parses a comma-separated list of extensions or
+// EXTENSIONS_NONE.
+func parseExtensionList(value string) ([]string, error) {
+	extensions := []string{}
+
+	if value == EXTENSIONS_NONE {
+		return extensions, nil
+	}
+
+	for _, ext := range strings.Split(value, ",") {
+		ext = strings.TrimSpace(ext)
+		if !slices.Contains(CertificateExtensions, ext) {
+			return nil, errors.New("unknown extension " + ext)
+		}
+
+		extensions = append(extensions, ext)
+	}
+
+	return extensions, nil
+}
+
+// parseKeyTypes parses a comma-separated list of client key types. All
+// KeyTypes are returned if the list is empty.
+func parseKeyTypes(value string) ([]string, error) {
+	types := splitList(value)
+	if len(types) == 0 {
+		return KeyTypes, nil
+	}
+
+	for _, keyType := range types {
+		if !slices.Contains(KeyTypes, keyType) {
+			return nil, errors.New("unknown key type " + keyType)
+		}
+	}
+
+	return types, nil
+}
+
+// parseForceCommandFeatures parses a comma-separated list of features
+// supported by the force-command. All features are supported if it is empty,
+// as by oinit-switch.
+func parseForceCommandFeatures(value string) ([]string, error) {
+	features := splitList(value)
+	if len(features) == 0 {
+		return forcecmd.Features, nil
+	}
+
+	for _, feature := range features {
+		if !slices.Contains(forcecmd.Features, feature) {
+			return nil, errors.New("unknown force-command feature " + feature)
+		}
+	}
+
+	return features, nil
+}
+
+// parseEnrollmentRules parses the comma-separated enroll-auto-approve
+// option, which contains ENROLL_AUTO_DNS and networks in CIDR notation.
+func parseEnrollmentRules(value string) (EnrollmentRules, error) {
+	var rules EnrollmentRules
+
+	for _, rule := range splitList(value) {
+		if rule == ENROLL_AUTO_DNS {
+			rules.DNS = true
 			continue
 		}
 
-		dur, err := strconv.Atoi(validity)
+		_, network, err := net.ParseCIDR(rule)
 		if err != nil {
-			return err
+			return EnrollmentRules{}, errors.New("invalid enroll-auto-approve rule " + rule)
 		}
 
-		conf.HostGroups[i].CertDuration = dur
+		rules.Networks = append(rules.Networks, network)
+	}
+
+	return rules, nil
+}
+
+// checkApprovedKeys returns an error if any CA key is not approved.
+func checkApprovedKeys(conf Config) error {
+	for _, group := range conf.HostGroups {
+		publicKeys := map[string]ssh.PublicKey{
+			group.PathHostCAPublicKey: group.HostCAPublicKey,
+			group.PathUserCAPublicKey: group.UserCAPublicKey,
+		}
+		for path, key := range publicKeys {
+			if err := approved.PublicKey(key); err != nil {
+				return errors.New("key " + path + " is not approved: " + err.Error())
+			}
+		}
+
+		privateKeys := map[string]interface{}{
+			group.PathHostCAPrivateKey: group.HostCAPrivateKey,
+			group.PathUserCAPrivateKey: group.UserCAPrivateKey,
+		}
+		for path, key := range privateKeys {
+			if _, err := approved.Signer(key); err != nil {
+				return errors.New("key " + path + " is not approved: " + err.Error())
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkOpenSSHVersions returns an error if the OpenSSH version configured for
+// a hostgroup cannot be parsed or cannot validate certificates signed by its
+// user CA key. Probed versions are checked when issuing certificates.
+func checkOpenSSHVersions(conf Config) error {
+	for _, group := range conf.HostGroups {
+		if group.OpenSSHVersion == "" || group.OpenSSHVersion == OPENSSH_VERSION_PROBE {
+			continue
+		}
+
+		version, err := sshversion.Parse(group.OpenSSHVersion)
+		if err != nil {
+			return errors.New("invalid openssh-version in hostgroup " + group.Name)
+		}
+
+		caType := group.UserCAPublicKey.Type()
+		if !version.SupportsKeyType(caType) {
+			return errors.New("user CA key type " + caType + " is not supported by OpenSSH " + version.String() + " in hostgroup " + group.Name)
+		}
+
+		if conf.Server.ApprovedAlgorithms && caType == ssh.KeyAlgoRSA && !version.AtLeast(sshversion.RSASHA2Version) {
+			return errors.New("OpenSSH " + version.String() + " in hostgroup " + group.Name + " requires SHA-1 signatures, which are not approved")
+		}
 	}
 
 	return nil
@@ -208,6 +1184,7 @@ func parsePrivateKeyFile(path string) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer memprotect.Wipe(content)
 
 	pk, err := ssh.ParseRawPrivateKey(content)
 	if err != nil {
@@ -217,6 +1194,211 @@ func parsePrivateKeyFile(path string) (interface{}, error) {
 	return pk, nil
 }
 
+// parseAdminTokensFile reads admin tokens from the given file. Empty lines
+// and lines starting with '#' are ignored. Tokens without a role are given the
+// viewer role.
+func parseAdminTokensFile(path string) ([]AdminToken, error) {
+	var tokens []AdminToken
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 && len(fields) != 3 {
+			return nil, errors.New("malformed line " + strconv.Itoa(i+1))
+		}
+
+		token := AdminToken{
+			Name:  fields[0],
+			Token: fields[1],
+			Role:  ROLE_VIEWER,
+		}
+
+		if len(fields) == 3 {
+			if !slices.Contains(Roles, fields[2]) {
+				return nil, errors.New("unknown role '" + fields[2] + "' in line " + strconv.Itoa(i+1))
+			}
+
+			token.Role = fields[2]
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	return tokens, nil
+}
+
+// parseEmergencyKeysFile reads the keys of incident responders from the given
+// file in authorized_keys format. Only keys held by hardware tokens are
+// accepted. Keys without a comment are named by their fingerprint.
+func parseEmergencyKeysFile(path string) ([]EmergencyKey, error) {
+	var keys []EmergencyKey
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		pk, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return nil, errors.New("malformed line " + strconv.Itoa(i+1))
+		}
+
+		if pk.Type() != ssh.KeyAlgoSKED25519 && pk.Type() != ssh.KeyAlgoSKECDSA256 {
+			return nil, errors.New("key in line " + strconv.Itoa(i+1) + " is not held by a hardware token (sk-*)")
+		}
+
+		if comment == "" {
+			comment = ssh.FingerprintSHA256(pk)
+		}
+
+		keys = append(keys, EmergencyKey{Name: comment, PublicKey: pk})
+	}
+
+	return keys, nil
+}
+
+// parseAdminOIDCFile reads admin OIDC rules from the given file. Empty lines
+// and lines starting with '#' are ignored.
+func parseAdminOIDCFile(path string) ([]AdminOIDCRule, error) {
+	var rules []AdminOIDCRule
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 4 {
+			return nil, errors.New("malformed line " + strconv.Itoa(i+1))
+		}
+
+		if !slices.Contains(Roles, fields[3]) {
+			return nil, errors.New("unknown role '" + fields[3] + "' in line " + strconv.Itoa(i+1))
+		}
+
+		rules = append(rules, AdminOIDCRule{
+			Issuer: fields[0],
+			Claim:  fields[1],
+			Value:  fields[2],
+			Role:   fields[3],
+		})
+	}
+
+	return rules, nil
+}
+
+// parseVOQuotasFile reads VO quotas from the given file. Empty lines and lines
+// starting with '#' are ignored.
+func parseVOQuotasFile(path string) (map[string]int, error) {
+	quotas := make(map[string]int)
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, errors.New("malformed line " + strconv.Itoa(i+1))
+		}
+
+		max, err := strconv.Atoi(fields[1])
+		if err != nil || max < 0 {
+			return nil, errors.New("invalid quota in line " + strconv.Itoa(i+1))
+		}
+
+		quotas[fields[0]] = max
+	}
+
+	return quotas, nil
+}
+
+// loadNotifiers configures the email and Matrix notifiers, if set.
+func loadNotifiers(conf *Config) error {
+	opts := conf.Server
+
+	if opts.NotifySMTPServer != "" {
+		email := notify.Email{Server: opts.NotifySMTPServer, From: opts.NotifySMTPFrom}
+
+		if opts.PathNotifySMTPAuth != "" {
+			content, err := os.ReadFile(opts.PathNotifySMTPAuth)
+			if err != nil {
+				return err
+			}
+
+			fields := strings.Fields(string(content))
+			if len(fields) != 2 {
+				return errors.New("malformed " + opts.PathNotifySMTPAuth)
+			}
+
+			email.Username, email.Password = fields[0], fields[1]
+		}
+
+		conf.Notifiers = append(conf.Notifiers, email)
+		conf.NotifyEmail = true
+	}
+
+	if opts.NotifyMatrixHomeserver != "" {
+		token, err := os.ReadFile(opts.PathNotifyMatrixToken)
+		if err != nil {
+			return err
+		}
+
+		conf.Notifiers = append(conf.Notifiers, notify.Matrix{
+			Homeserver: opts.NotifyMatrixHomeserver,
+			Room:       opts.NotifyMatrixRoom,
+			Token:      strings.TrimSpace(string(token)),
+		})
+	}
+
+	return nil
+}
+
+// SplitURLs returns the motley_cue URLs of a host, which are given as a
+// comma-separated list of a primary instance followed by replicas. At least
+// one (possibly empty) URL is returned.
+func SplitURLs(value string) []string {
+	var urls []string
+	for _, url := range strings.Split(value, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
+		}
+	}
+
+	if len(urls) == 0 {
+		return []string{""}
+	}
+
+	return urls
+}
+
 func (c Config) GetInfo(host string) (HostInfo, error) {
 	host = strings.ToLower(host)
 
@@ -225,16 +1407,105 @@ func (c Config) GetInfo(host string) (HostInfo, error) {
 			hostName = strings.ToLower(hostName)
 
 			if util.MatchesHost(host, "", hostName, "") {
+				urls := SplitURLs(caURL)
+
 				return HostInfo{
-					Name:          hostName,
-					URL:           caURL,
-					CertDuration:  hostGroup.CertDuration,
-					CacheDuration: hostGroup.CacheDuration,
-					Keys:          hostGroup.Keys,
+					Name:                 hostName,
+					HostGroup:            hostGroup.Name,
+					URL:                  urls[0],
+					URLs:                 urls,
+					CertDuration:         hostGroup.CertDuration,
+					CacheDuration:        hostGroup.CacheDuration,
+					Extensions:           hostGroup.AllowedExtensions,
+					MaxCertificates:      hostGroup.MaxCertificates,
+					QuotaAction:          hostGroup.QuotaAction,
+					Message:              hostGroup.Message,
+					OpenSSHVersion:       hostGroup.OpenSSHVersion,
+					EagerDeploy:          hostGroup.EagerDeploy,
+					Profiles:             hostGroup.Profiles,
+					Principals:           hostGroup.Principals,
+					HostPrincipals:       hostGroup.HostPrincipals,
+					TokenBinding:         hostGroup.TokenBinding,
+					SubjectAllow:         hostGroup.SubjectAllow,
+					SubjectDeny:          hostGroup.SubjectDeny,
+					FreezeWindows:        hostGroup.FreezeWindows,
+					FreezeMessage:        hostGroup.FreezeMessage,
+					Delegate:             hostGroup.Delegate,
+					DelegateMode:         hostGroup.DelegateMode,
+					DisabledFeatures:     hostGroup.DisabledFeatures,
+					SupportContact:       hostGroup.SupportContact,
+					EnrollmentURL:        hostGroup.EnrollmentURL,
+					GeoDeny:              hostGroup.GeoDenied,
+					GeoLimit:             hostGroup.GeoLimited,
+					GeoLimitValidity:     hostGroup.GeoLimitValidity,
+					GracePeriod:          hostGroup.GracePeriod,
+					GraceValidity:        hostGroup.GraceValidity,
+					KeyTypes:             hostGroup.AcceptedKeyTypes,
+					MinRSABits:           hostGroup.MinRSABits,
+					ForceCommand:         hostGroup.ForceCommandTemplate,
+					ForceCommandFeatures: hostGroup.SupportedFeatures,
+					HostEnrollment:       hostGroup.HostEnrollment,
+					HostCertValidity:     hostGroup.HostCertValidity,
+					AutoApprove:          hostGroup.AutoApprove,
+					Keys:                 hostGroup.Keys,
 				}, nil
 			}
 		}
 	}
 
-	return HostInfo{}, errors.New(ERR_HOST_NOT_FOUND)
+	return HostInfo{}, fmt.Errorf("%w: %s", ErrHostNotFound, host)
+}
+
+// Files returns the paths of all files referenced by the configuration, such
+// as CA private and public keys and the storage file. Each path is only
+// returned once.
+func (c Config) Files() []string {
+	var files []string
+
+	seen := make(map[string]bool)
+
+	for _, group := range c.HostGroups {
+		for _, path := range []string{
+			group.PathHostCAPrivateKey, group.PathHostCAPublicKey,
+			group.PathUserCAPrivateKey, group.PathUserCAPublicKey,
+			group.PathForceCommandKey, group.PathMotleyCueKey,
+			group.PathDelegateCAPublicKey,
+		} {
+			if path == "" || seen[path] {
+				continue
+			}
+			seen[path] = true
+
+			files = append(files, path)
+		}
+	}
+
+	for _, peer := range c.Peers {
+		if !seen[peer.PathHostCAPublicKey] {
+			seen[peer.PathHostCAPublicKey] = true
+			files = append(files, peer.PathHostCAPublicKey)
+		}
+	}
+
+	for _, listener := range c.Listeners {
+		for _, path := range []string{listener.PathTLSCert, listener.PathTLSKey} {
+			if path != "" && !seen[path] {
+				seen[path] = true
+				files = append(files, path)
+			}
+		}
+	}
+
+	for _, path := range []string{
+		storage.Path(c.Server.Storage), c.Server.PathAdminTokens, c.Server.PathAdminOIDC,
+		c.Server.PathVOQuotas, c.Server.PathNotifySMTPAuth, c.Server.PathNotifyMatrixToken,
+		c.Server.PathEmergencyKeys,
+		c.Server.PathGeoIPCountryDB, c.Server.PathGeoIPASNDB,
+	} {
+		if path != "" {
+			files = append(files, path)
+		}
+	}
+
+	return files
 }
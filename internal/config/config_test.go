@@ -0,0 +1,772 @@
+package config
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/forcecmd"
+	"github.com/lbrocke/oinit/internal/geoip"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+// writeTestKeys writes a CA key pair to dir and returns the global section of
+// a config using it for both host and user certificates.
+func writeTestKeys(t testing.TB, dir string) string {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	assert.NoError(t, err)
+
+	signer, err := ssh.NewSignerFromKey(priv)
+	assert.NoError(t, err)
+
+	privPath := filepath.Join(dir, "ca")
+	pubPath := filepath.Join(dir, "ca.pub")
+
+	assert.NoError(t, os.WriteFile(privPath, pem.EncodeToMemory(block), 0600))
+	assert.NoError(t, os.WriteFile(pubPath, ssh.MarshalAuthorizedKey(signer.PublicKey()), 0644))
+
+	return "host-ca-privkey = " + privPath + "\n" +
+		"host-ca-pubkey = " + pubPath + "\n" +
+		"user-ca-privkey = " + privPath + "\n" +
+		"user-ca-pubkey = " + pubPath + "\n" +
+		"cert-validity = token\n" +
+		"cache-duration = 600\n"
+}
+
+func FuzzLoad(f *testing.F) {
+	dir := f.TempDir()
+	global := writeTestKeys(f, dir)
+	path := filepath.Join(dir, "config.ini")
+
+	f.Add([]byte("[example.com]\nlogin.example.com = https://login.example.com:8443\n*.example.com = https://login.example.com:8443\n"))
+	f.Add([]byte("[example.com]\nLogin.Example.com = https://login.example.com\ncert-validity = 3600\nextensions = none\n"))
+	f.Add([]byte("[a]\nmax-certificates = -1\n[b]\nquota-action = revoke-oldest\n"))
+	f.Add([]byte("[example.com]\nlogin.example.com = https://login.example.com\nmessage = Maintenance on Saturday\n"))
+	f.Add([]byte("[]\n=\n*. = \n"))
+
+	f.Fuzz(func(t *testing.T, hostgroups []byte) {
+		// Options referencing files could make the fuzzer read arbitrary
+		// (and possibly endless) files such as /dev/zero.
+		lower := bytes.ToLower(hostgroups)
+		if bytes.Contains(lower, []byte("key")) || bytes.Contains(lower, []byte("admin")) || bytes.Contains(lower, []byte("subject")) {
+			t.Skip()
+		}
+
+		if os.WriteFile(path, append([]byte(global), hostgroups...), 0600) != nil {
+			t.Skip()
+		}
+
+		conf, err := Load(path)
+		if err != nil {
+			return
+		}
+
+		for _, group := range conf.HostGroups {
+			for host := range group.Hosts {
+				if strings.HasPrefix(host, "*.") {
+					continue
+				}
+
+				// Every configured host can be looked up
+				_, err := conf.GetInfo(host)
+				assert.NoError(t, err, host)
+			}
+		}
+	})
+}
+
+func TestLoadApprovedAlgorithms(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.ini")
+	hostgroup := "[example.com]\nlogin.example.com = https://login.example.com\n"
+
+	// The test keys are Ed25519, which is not approved
+	config := "approved-algorithms = true\n" + writeTestKeys(t, dir) + hostgroup
+	assert.NoError(t, os.WriteFile(path, []byte(config), 0600))
+
+	_, err := Load(path)
+	assert.ErrorContains(t, err, "is not approved")
+}
+
+func TestLoadOpenSSHVersion(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.ini")
+	global := writeTestKeys(t, dir)
+
+	for version, valid := range map[string]bool{
+		"probe": true,
+		"8.9p1": true,
+		"6.5":   true,
+		// The test keys are Ed25519, which OpenSSH supports since 6.5
+		"6.4":    false,
+		"latest": false,
+	} {
+		config := global + "[example.com]\nlogin.example.com = https://login.example.com\nopenssh-version = " + version + "\n"
+		assert.NoError(t, os.WriteFile(path, []byte(config), 0600))
+
+		_, err := Load(path)
+		assert.Equal(t, valid, err == nil, version)
+	}
+}
+
+func TestLoadProfiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.ini")
+	global := writeTestKeys(t, dir) +
+		"[profile:interactive]\nprincipals = all\n" +
+		"[profile:file-transfer-only]\ncert-validity = 600\nextensions = none\ncommand = internal-sftp\n"
+
+	config := global + "[example.com]\nlogin.example.com = https://login.example.com\nprofiles = interactive, file-transfer-only\n"
+	assert.NoError(t, os.WriteFile(path, []byte(config), 0600))
+
+	conf, err := Load(path)
+	assert.NoError(t, err)
+	assert.Len(t, conf.HostGroups, 1)
+
+	info, err := conf.GetInfo("login.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"interactive", "file-transfer-only"}, info.ProfileNames())
+	assert.Equal(t, PRINCIPALS_USER, info.Principals)
+
+	profile, ok := info.Profile("")
+	assert.True(t, ok)
+	assert.Equal(t, "interactive", profile.Name)
+	assert.Equal(t, PRINCIPALS_ALL, profile.Principals)
+
+	profile, ok = info.Profile("file-transfer-only")
+	assert.True(t, ok)
+	assert.Equal(t, 600, profile.CertDuration)
+	assert.Equal(t, []string{}, profile.AllowedExtensions)
+	assert.Equal(t, "internal-sftp", profile.Command)
+	assert.Empty(t, profile.Principals)
+
+	_, ok = info.Profile("batch")
+	assert.False(t, ok)
+
+	// Profiles must be defined
+	config = global + "[example.com]\nlogin.example.com = https://login.example.com\nprofiles = batch\n"
+	assert.NoError(t, os.WriteFile(path, []byte(config), 0600))
+
+	_, err = Load(path)
+	assert.EqualError(t, err, "unknown profile batch in hostgroup example.com")
+
+	config = global + "[example.com]\nlogin.example.com = https://login.example.com\nprincipals = any\n"
+	assert.NoError(t, os.WriteFile(path, []byte(config), 0600))
+
+	_, err = Load(path)
+	assert.EqualError(t, err, "unknown principals policy any in hostgroup example.com")
+}
+
+func TestLoadDelegate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.ini")
+	global := writeTestKeys(t, dir)
+	hosts := "[example.com]\nlogin.example.com = https://login.example.com\n"
+
+	for _, test := range []struct {
+		options string
+		err     string
+	}{
+		{"delegate = https://ca.site.example.com/\ndelegate-ca-pubkey = " + filepath.Join(dir, "ca.pub") + "\n", ""},
+		{"delegate = ca.site.example.com\ndelegate-ca-pubkey = " + filepath.Join(dir, "ca.pub") + "\n", "invalid delegate in hostgroup example.com"},
+		{"delegate = https://ca.site.example.com\n", "missing delegate-ca-pubkey in hostgroup example.com"},
+		{"delegate = https://ca.site.example.com\ndelegate-mode = forward\ndelegate-ca-pubkey = " + filepath.Join(dir, "ca.pub") + "\n", "invalid delegate-mode in hostgroup example.com"},
+	} {
+		assert.NoError(t, os.WriteFile(path, []byte(global+hosts+test.options), 0600))
+
+		conf, err := Load(path)
+		if test.err != "" {
+			assert.EqualError(t, err, test.err)
+			continue
+		}
+
+		assert.NoError(t, err)
+
+		info, err := conf.GetInfo("login.example.com")
+		assert.NoError(t, err)
+		assert.Equal(t, "https://ca.site.example.com", info.Delegate)
+		assert.Equal(t, DELEGATE_PROXY, info.DelegateMode)
+		assert.NotNil(t, info.DelegateCAPublicKey)
+	}
+}
+
+func TestLoadValidation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.ini")
+	missing := filepath.Join(dir, "missing")
+	global := writeTestKeys(t, dir)
+
+	// All problems are reported at once
+	config := "notify-matrix-homeserver = matrix.example.com\n" +
+		"[a]\nlogin.a.example.com = https://login.a.example.com\n" +
+		"[b]\nlogin.b.example.com = https://login.b.example.com\ncert-validity = 1h\ncache-duration = 600\n" +
+		"host-ca-privkey = " + missing + "\n"
+	assert.NoError(t, os.WriteFile(path, []byte(config), 0600))
+
+	_, err := Load(path)
+	assert.EqualError(t, err, "invalid notify-matrix-homeserver\n"+
+		"missing notify-matrix-room\n"+
+		"missing notify-matrix-token\n"+
+		"missing host-ca-privkey in hostgroup a\n"+
+		"missing host-ca-pubkey in hostgroup a\n"+
+		"missing user-ca-privkey in hostgroup a\n"+
+		"missing user-ca-pubkey in hostgroup a\n"+
+		"missing cert-validity in hostgroup a\n"+
+		"missing cache-duration in hostgroup a\n"+
+		"host-ca-privkey "+missing+" does not exist in hostgroup b\n"+
+		"missing host-ca-pubkey in hostgroup b\n"+
+		"missing user-ca-privkey in hostgroup b\n"+
+		"missing user-ca-pubkey in hostgroup b\n"+
+		"invalid cert-validity in hostgroup b")
+
+	for options, expected := range map[string]string{
+		"min-rsa-bits = -1\n":                           "invalid min-rsa-bits in hostgroup example.com",
+		"max-certificates = 10\nquota-action = block\n": "invalid quota-action in hostgroup example.com",
+		"enrollment-url = login.example.com/enroll\n":   "invalid enrollment-url in hostgroup example.com",
+		"motley-cue-key = " + missing + "\n":            "motley-cue-key " + missing + " does not exist in hostgroup example.com",
+	} {
+		config = global + "[example.com]\nlogin.example.com = https://login.example.com\n" + options
+		assert.NoError(t, os.WriteFile(path, []byte(config), 0600))
+
+		_, err = Load(path)
+		assert.EqualError(t, err, expected, options)
+	}
+}
+
+func TestLoadPeers(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.ini")
+	global := writeTestKeys(t, dir) + "[example.com]\nlogin.example.com = https://login.example.com\n"
+	peer := "[peer:other]\nurl = https://ca.other.example.org/\nhost-ca-pubkey = " + filepath.Join(dir, "ca.pub") + "\n"
+
+	assert.NoError(t, os.WriteFile(path, []byte(global+peer+"hosts = login.other.example.org, *.hpc.example.org\n"), 0600))
+
+	conf, err := Load(path)
+	assert.NoError(t, err)
+	assert.Len(t, conf.HostGroups, 1)
+	assert.Contains(t, conf.Files(), filepath.Join(dir, "ca.pub"))
+
+	found, ok := conf.Peer("Node1.HPC.example.org")
+	assert.True(t, ok)
+	assert.Equal(t, "other", found.Name)
+	assert.Equal(t, "https://ca.other.example.org", found.URL)
+	assert.NotNil(t, found.HostCAPublicKey)
+
+	_, ok = conf.Peer("login.example.com")
+	assert.False(t, ok)
+
+	assert.NoError(t, os.WriteFile(path, []byte(global+peer), 0600))
+
+	_, err = Load(path)
+	assert.EqualError(t, err, "missing hosts in peer other")
+}
+
+func TestLoadListeners(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.ini")
+	global := writeTestKeys(t, dir) + "request-timeout = 20\n[example.com]\nlogin.example.com = https://login.example.com\n"
+	listeners := "[listen:public]\naddress = 0.0.0.0:8443\nmode = api\ntls-cert = /tls/cert.pem\ntls-key = /tls/key.pem\n" +
+		"[listen:admin]\naddress = unix:/run/oinit-ca/admin.sock\nmode = admin\nsocket-mode = 0600\nrequest-timeout = 5\n"
+
+	assert.NoError(t, os.WriteFile(path, []byte(global+listeners), 0600))
+
+	conf, err := Load(path)
+	assert.NoError(t, err)
+	assert.Len(t, conf.HostGroups, 1)
+	assert.Len(t, conf.Listeners, 2)
+	assert.Contains(t, conf.Files(), "/tls/key.pem")
+
+	assert.Equal(t, "public", conf.Listeners[0].Name)
+	assert.Equal(t, LISTEN_MODE_API, conf.Listeners[0].Mode)
+	assert.Equal(t, os.FileMode(0660), conf.Listeners[0].Perm)
+	assert.Equal(t, 20, conf.Listeners[0].RequestTimeout)
+
+	assert.Equal(t, os.FileMode(0600), conf.Listeners[1].Perm)
+	assert.Equal(t, 5, conf.Listeners[1].RequestTimeout)
+
+	assert.NoError(t, os.WriteFile(path, []byte(global+"[listen:public]\naddress = :8443\nmode = metrics\n"), 0600))
+
+	_, err = Load(path)
+	assert.EqualError(t, err, "unknown mode metrics in listener public")
+
+	assert.NoError(t, os.WriteFile(path, []byte(global+"[listen:public]\naddress = :8443\ntls-cert = /tls/cert.pem\n"), 0600))
+
+	_, err = Load(path)
+	assert.EqualError(t, err, "tls-cert and tls-key must be set together in listener public")
+}
+
+func TestLoadFeatures(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.ini")
+	global := writeTestKeys(t, dir) + "features = -dry-run, -host-keys\n"
+	groups := "[staging]\nfeatures = host-keys\nstaging.example.com = https://staging.example.com\n" +
+		"[production]\nlogin.example.com = https://login.example.com\n"
+
+	assert.NoError(t, os.WriteFile(path, []byte(global+groups), 0600))
+
+	conf, err := Load(path)
+	assert.NoError(t, err)
+
+	staging, err := conf.GetInfo("staging.example.com")
+	assert.NoError(t, err)
+	assert.False(t, staging.DisabledFeatures[FEATURE_HOST_KEYS])
+	assert.False(t, staging.DisabledFeatures[FEATURE_DRY_RUN])
+
+	production, err := conf.GetInfo("login.example.com")
+	assert.NoError(t, err)
+	assert.True(t, production.DisabledFeatures[FEATURE_HOST_KEYS])
+	assert.True(t, production.DisabledFeatures[FEATURE_DRY_RUN])
+	assert.False(t, production.DisabledFeatures[FEATURE_KRL])
+
+	assert.NoError(t, os.WriteFile(path, []byte(global+"[staging]\nfeatures = -teleport\nstaging.example.com = https://staging.example.com\n"), 0600))
+
+	_, err = Load(path)
+	assert.EqualError(t, err, "unknown feature teleport in hostgroup staging")
+}
+
+func TestLoadWorkloads(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.ini")
+	global := writeTestKeys(t, dir) + "[example.com]\nlogin.example.com = https://login.example.com\n"
+	workload := "[workload:github]\nissuer = https://token.actions.githubusercontent.com\naudience = https://ca.example.com\n" +
+		"subjects = repo:org/deploy:ref:refs/heads/*\nuser = deploy\nhosts = *.example.com\n"
+
+	assert.NoError(t, os.WriteFile(path, []byte(global+workload), 0600))
+
+	conf, err := Load(path)
+	assert.NoError(t, err)
+	assert.Len(t, conf.HostGroups, 1)
+	assert.Len(t, conf.Workloads, 1)
+	assert.True(t, conf.WorkloadIssuer("https://token.actions.githubusercontent.com"))
+	assert.False(t, conf.WorkloadIssuer("https://login.example.com"))
+
+	w, ok := conf.Workload("https://token.actions.githubusercontent.com", "repo:org/deploy:ref:refs/heads/main", "login.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "deploy", w.User)
+
+	_, ok = conf.Workload("https://token.actions.githubusercontent.com", "repo:org/other:ref:refs/heads/main", "login.example.com")
+	assert.False(t, ok)
+
+	_, ok = conf.Workload("https://token.actions.githubusercontent.com", "repo:org/deploy:ref:refs/heads/main", "login.example.org")
+	assert.False(t, ok)
+
+	assert.NoError(t, os.WriteFile(path, []byte(global+"[workload:github]\nissuer = http://issuer.example.com\n"), 0600))
+
+	_, err = Load(path)
+	assert.EqualError(t, err, "invalid issuer in workload github")
+
+	assert.NoError(t, os.WriteFile(path, []byte(global+"[workload:github]\nissuer = https://issuer.example.com\naudience = ca\nuser = deploy\nhosts = *\n"), 0600))
+
+	_, err = Load(path)
+	assert.EqualError(t, err, "missing subjects in workload github")
+}
+
+func TestLoadGeo(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.ini")
+	global := writeTestKeys(t, dir)
+
+	assert.NoError(t, os.WriteFile(path, []byte(global+"[example.com]\nlogin.example.com = https://login.example.com\ngeo-deny = kp\n"), 0600))
+
+	_, err := Load(path)
+	assert.EqualError(t, err, "geo rules require geoip-country-db or geoip-asn-db in hostgroup example.com")
+
+	assert.NoError(t, os.WriteFile(path, []byte(global+"[example.com]\nlogin.example.com = https://login.example.com\ngeo-deny = AS12a\n"), 0600))
+
+	_, err = Load(path)
+	assert.EqualError(t, err, "invalid country or autonomous system AS12A in hostgroup example.com")
+
+	assert.NoError(t, os.WriteFile(path, []byte(global+"[example.com]\nlogin.example.com = https://login.example.com\ngeo-limit = as64496\n"), 0600))
+
+	_, err = Load(path)
+	assert.EqualError(t, err, "missing geo-limit-validity in hostgroup example.com")
+
+	assert.NoError(t, os.WriteFile(path, []byte("trusted-proxies = 10.0.0.0/8, proxy\n"+global), 0600))
+
+	_, err = Load(path)
+	assert.EqualError(t, err, "invalid trusted proxy proxy")
+}
+
+func TestLoadSecurityHeaders(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.ini")
+	global := writeTestKeys(t, dir)
+
+	assert.NoError(t, os.WriteFile(path, []byte(global), 0600))
+
+	conf, err := Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, DEFAULT_HSTS_MAX_AGE, conf.Server.HSTSMaxAge)
+	assert.Equal(t, DEFAULT_ADMIN_CSP, conf.Server.AdminCSP)
+
+	// Policies contain ";", which starts comments unless quoted
+	assert.NoError(t, os.WriteFile(path, []byte("hsts-max-age = -1\nadmin-csp = `default-src 'self'; report-uri /csp`\n"+global), 0600))
+
+	conf, err = Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, -1, conf.Server.HSTSMaxAge)
+	assert.Equal(t, "default-src 'self'; report-uri /csp", conf.Server.AdminCSP)
+}
+
+func TestLoadEmergencyKeys(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.ini")
+	keys := filepath.Join(dir, "emergency-keys")
+	global := writeTestKeys(t, dir)
+
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	sk := ssh.Marshal(struct {
+		Name        string
+		KeyBytes    []byte
+		Application string
+	}{ssh.KeyAlgoSKED25519, pub, "ssh:"})
+	skLine := ssh.KeyAlgoSKED25519 + " " + base64.StdEncoding.EncodeToString(sk) + " alice\n"
+
+	emergency := "emergency-keys = " + keys + "\nemergency-contacts = security@example.com\n"
+	notifier := "notify-smtp-server = mail.example.com:587\nnotify-smtp-from = oinit-ca@example.com\n"
+
+	assert.NoError(t, os.WriteFile(keys, []byte("# responders\n"+skLine), 0600))
+	assert.NoError(t, os.WriteFile(path, []byte(emergency+notifier+global), 0600))
+
+	conf, err := Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, DEFAULT_EMERGENCY_VALIDITY, conf.Server.EmergencyValidity)
+	assert.Len(t, conf.EmergencyKeys, 1)
+	assert.Equal(t, "alice", conf.EmergencyKeys[0].Name)
+	assert.Equal(t, []string{"security@example.com"}, conf.Server.EmergencyContactList())
+
+	// Security contacts must be alerted
+	assert.NoError(t, os.WriteFile(path, []byte(emergency+global), 0600))
+	_, err = Load(path)
+	assert.Error(t, err)
+
+	assert.NoError(t, os.WriteFile(path, []byte("emergency-keys = "+keys+"\n"+notifier+global), 0600))
+	_, err = Load(path)
+	assert.Error(t, err)
+
+	assert.NoError(t, os.WriteFile(path, []byte("emergency-validity = 86400\n"+emergency+notifier+global), 0600))
+	_, err = Load(path)
+	assert.Error(t, err)
+
+	// Only keys held by hardware tokens are accepted
+	signer, _ := ssh.NewSignerFromKey(ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)))
+	assert.NoError(t, os.WriteFile(keys, ssh.MarshalAuthorizedKey(signer.PublicKey()), 0600))
+	assert.NoError(t, os.WriteFile(path, []byte(emergency+notifier+global), 0600))
+	_, err = Load(path)
+	assert.Error(t, err)
+}
+
+func TestLoadReplica(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.ini")
+	global := writeTestKeys(t, dir)
+
+	replica := "replica = true\npromote-file = " + filepath.Join(dir, "promoted") + "\n"
+
+	assert.NoError(t, os.WriteFile(path, []byte(replica+"storage = file:"+filepath.Join(dir, "state.json")+"\n"+global), 0600))
+
+	conf, err := Load(path)
+	assert.NoError(t, err)
+	assert.True(t, conf.Server.Replica)
+	assert.Equal(t, DEFAULT_REPLICA_SYNC_INTERVAL, conf.Server.ReplicaSyncInterval)
+
+	// The replicated state is read from the storage file, which is the
+	// default
+	assert.NoError(t, os.WriteFile(path, []byte(replica+global), 0600))
+	conf, err = Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, DEFAULT_STORAGE, conf.Server.Storage)
+
+	assert.NoError(t, os.WriteFile(path, []byte(replica+"storage = memory\n"+global), 0600))
+	_, err = Load(path)
+	assert.EqualError(t, err, "replica requires file storage")
+}
+
+func TestCleanBasePath(t *testing.T) {
+	for value, expected := range map[string]string{"": "", "/": "", "/oinit-ca": "/oinit-ca", "/portal/oinit-ca/": "/portal/oinit-ca"} {
+		path, err := CleanBasePath(value)
+		assert.NoError(t, err, value)
+		assert.Equal(t, expected, path)
+	}
+
+	for _, value := range []string{"oinit-ca", "//evil.example.com", "https://example.com/oinit-ca", "/oinit-ca?x=1", "/oinit-ca#x"} {
+		_, err := CleanBasePath(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestLoadSubjects(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.ini")
+	allow := filepath.Join(dir, "allow")
+	deny := filepath.Join(dir, "deny")
+	global := writeTestKeys(t, dir)
+
+	config := global + "subject-deny = " + deny + "\n" +
+		"[a]\na.example.com = https://login.example.com\nsubject-allow = " + allow + "\n" +
+		"[b]\nb.example.com = https://login.example.com\n"
+
+	assert.NoError(t, os.WriteFile(path, []byte(config), 0600))
+	assert.NoError(t, os.WriteFile(allow, []byte("alice\nbob@https://idp.example.com\n"), 0600))
+
+	_, err := Load(path)
+	assert.EqualError(t, err, "subject-deny: stat "+deny+": no such file or directory in hostgroup a")
+
+	assert.NoError(t, os.WriteFile(deny, []byte("# compromised\nalice@https://evil.example.com\n"), 0600))
+
+	conf, err := Load(path)
+	assert.NoError(t, err)
+
+	a, err := conf.GetInfo("a.example.com")
+	assert.NoError(t, err)
+	b, err := conf.GetInfo("b.example.com")
+	assert.NoError(t, err)
+
+	// Hostgroups share the list of the default section
+	assert.Same(t, a.SubjectDeny, b.SubjectDeny)
+	assert.Nil(t, b.SubjectAllow)
+
+	for _, test := range []struct {
+		info            HostInfo
+		subject, issuer string
+		allowed         bool
+	}{
+		{a, "alice", "https://idp.example.com", true},
+		{a, "alice", "https://evil.example.com", false},
+		{a, "bob", "https://idp.example.com", true},
+		{a, "bob", "https://other.example.com", false},
+		{a, "carol", "https://idp.example.com", false},
+		{b, "carol", "https://idp.example.com", true},
+		{b, "alice", "https://evil.example.com", false},
+	} {
+		allowed, err := test.info.SubjectAllowed(test.subject, test.issuer)
+		assert.NoError(t, err)
+		assert.Equal(t, test.allowed, allowed, test.subject+"@"+test.issuer)
+	}
+
+	// Lists that can't be read deny all subjects
+	assert.NoError(t, os.Remove(deny))
+
+	allowed, err := b.SubjectAllowed("carol", "https://idp.example.com")
+	assert.Error(t, err)
+	assert.False(t, allowed)
+}
+
+func TestGeoAction(t *testing.T) {
+	info := HostInfo{GeoDeny: []string{"KP", "AS64496"}, GeoLimit: []string{"DE", "AS64511"}}
+
+	for _, test := range []struct {
+		geo          geoip.Info
+		action, rule string
+	}{
+		{geoip.Info{Country: "KP"}, GEO_DENY, "KP"},
+		{geoip.Info{Country: "DE", ASN: 64496}, GEO_DENY, "AS64496"},
+		{geoip.Info{Country: "DE"}, GEO_LIMIT, "DE"},
+		{geoip.Info{Country: "NL", ASN: 64511}, GEO_LIMIT, "AS64511"},
+		{geoip.Info{Country: "NL", ASN: 64497}, "", ""},
+		{geoip.Info{}, "", ""},
+	} {
+		action, rule := info.GeoAction(test.geo)
+		assert.Equal(t, test.action, action)
+		assert.Equal(t, test.rule, rule)
+	}
+}
+
+func TestLoadMiddleware(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.ini")
+	global := writeTestKeys(t, dir) + "middleware = logger, rate-limit, gzip\ncors-origins = https://portal.example.com\n" +
+		"[example.com]\nlogin.example.com = https://login.example.com\n"
+	listeners := "[listen:public]\naddress = :8443\nmiddleware = cors,gzip\n" +
+		"[listen:internal]\naddress = :9090\nmode = health\nquiet = true\n"
+
+	assert.NoError(t, os.WriteFile(path, []byte(global+listeners), 0600))
+
+	conf, err := Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{MIDDLEWARE_LOGGER, MIDDLEWARE_RATE_LIMIT, MIDDLEWARE_GZIP}, conf.Server.MiddlewareChain())
+	assert.Equal(t, DEFAULT_RATE_LIMIT, conf.Server.RateLimit)
+	assert.Equal(t, []string{"https://portal.example.com"}, conf.Server.CORSOrigins())
+	assert.Equal(t, []string{MIDDLEWARE_CORS, MIDDLEWARE_GZIP}, conf.Listeners[0].Middlewares)
+	assert.Equal(t, []string{MIDDLEWARE_RATE_LIMIT, MIDDLEWARE_GZIP}, conf.Listeners[1].Middlewares)
+
+	assert.NoError(t, os.WriteFile(path, []byte(writeTestKeys(t, dir)+"middleware = none\n"), 0600))
+
+	conf, err = Load(path)
+	assert.NoError(t, err)
+	assert.Empty(t, conf.Server.MiddlewareChain())
+
+	assert.NoError(t, os.WriteFile(path, []byte(writeTestKeys(t, dir)+"middleware = logger,auth\n"), 0600))
+
+	_, err = Load(path)
+	assert.EqualError(t, err, "unknown middleware auth")
+
+	assert.NoError(t, os.WriteFile(path, []byte(writeTestKeys(t, dir)+"[listen:public]\naddress = :8443\nmiddleware = gzip,gzip\n"), 0600))
+
+	_, err = Load(path)
+	assert.EqualError(t, err, "duplicate middleware gzip in listener public")
+
+	assert.NoError(t, os.WriteFile(path, []byte(writeTestKeys(t, dir)+"[listen:public]\naddress = :8443\nmiddleware = cors\n"), 0600))
+
+	_, err = Load(path)
+	assert.EqualError(t, err, "middleware cors requires cors-origins")
+}
+
+func TestLoadForceCommand(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.ini")
+	global := writeTestKeys(t, dir)
+	groups := "[example.com]\nlogin.example.com = https://login.example.com\n" +
+		"[opt.example.com]\nforce-command = /opt/oinit/bin/oinit-switch {usernames}\nopt.example.com = https://opt.example.com\n"
+
+	assert.NoError(t, os.WriteFile(path, []byte(global+groups), 0600))
+
+	conf, err := Load(path)
+	assert.NoError(t, err)
+
+	info, err := conf.GetInfo("login.example.com")
+	assert.NoError(t, err)
+
+	command, err := info.ForceCommand.Render(map[string]string{forcecmd.VAR_USERNAMES: "alice"})
+	assert.NoError(t, err)
+	assert.Equal(t, "oinit-switch alice", command)
+
+	info, err = conf.GetInfo("opt.example.com")
+	assert.NoError(t, err)
+
+	command, err = info.ForceCommand.Render(map[string]string{forcecmd.VAR_USERNAMES: "alice"})
+	assert.NoError(t, err)
+	assert.Equal(t, "/opt/oinit/bin/oinit-switch alice", command)
+
+	assert.NoError(t, os.WriteFile(path, []byte(global+"[example.com]\nforce-command = oinit-switch {user}\nlogin.example.com = https://login.example.com\n"), 0600))
+
+	_, err = Load(path)
+	assert.EqualError(t, err, "force-command template contains unknown variable {user} in hostgroup example.com")
+}
+
+func TestLoadForceCommandFeatures(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.ini")
+	global := writeTestKeys(t, dir)
+	groups := "[example.com]\nlogin.example.com = https://login.example.com\n" +
+		"[wrapped.example.com]\nforce-command = /usr/local/bin/wrapper {username}\nforce-command-features = shell, exec\nwrapped.example.com = https://wrapped.example.com\n"
+
+	assert.NoError(t, os.WriteFile(path, []byte(global+groups), 0600))
+
+	conf, err := Load(path)
+	assert.NoError(t, err)
+
+	info, err := conf.GetInfo("login.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, forcecmd.Features, info.ForceCommandFeatures)
+	assert.Equal(t, forcecmd.COMMAND, info.ForceCommand.Program())
+
+	info, err = conf.GetInfo("wrapped.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{forcecmd.FEATURE_SHELL, forcecmd.FEATURE_EXEC}, info.ForceCommandFeatures)
+	assert.Equal(t, "/usr/local/bin/wrapper", info.ForceCommand.Program())
+
+	assert.NoError(t, os.WriteFile(path, []byte(global+"[example.com]\nforce-command-features = x11\nlogin.example.com = https://login.example.com\n"), 0600))
+
+	_, err = Load(path)
+	assert.EqualError(t, err, "unknown force-command feature x11 in hostgroup example.com")
+}
+
+func TestLoadHostEnrollment(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.ini")
+	global := writeTestKeys(t, dir)
+	groups := "[example.com]\nlogin.example.com = https://login.example.com\n" +
+		"[enrolled.example.com]\nhost-enrollment = true\nenroll-auto-approve = dns, 192.0.2.0/24\nhost-cert-validity = 86400\nenrolled.example.com = https://enrolled.example.com\n"
+
+	assert.NoError(t, os.WriteFile(path, []byte(global+groups), 0600))
+
+	conf, err := Load(path)
+	assert.NoError(t, err)
+
+	info, err := conf.GetInfo("login.example.com")
+	assert.NoError(t, err)
+	assert.False(t, info.HostEnrollment)
+	assert.Equal(t, DEFAULT_HOST_CERT_VALIDITY, info.HostCertValidity)
+
+	info, err = conf.GetInfo("enrolled.example.com")
+	assert.NoError(t, err)
+	assert.True(t, info.HostEnrollment)
+	assert.Equal(t, 86400, info.HostCertValidity)
+	assert.True(t, info.AutoApprove.DNS)
+	assert.Len(t, info.AutoApprove.Networks, 1)
+	assert.Equal(t, "192.0.2.0/24", info.AutoApprove.Networks[0].String())
+
+	assert.NoError(t, os.WriteFile(path, []byte(global+"[example.com]\nenroll-auto-approve = anyone\nlogin.example.com = https://login.example.com\n"), 0600))
+
+	_, err = Load(path)
+	assert.EqualError(t, err, "invalid enroll-auto-approve rule anyone in hostgroup example.com")
+}
+
+func TestLoadKeyTypes(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.ini")
+	global := writeTestKeys(t, dir)
+
+	assert.NoError(t, os.WriteFile(path, []byte(global+"[example.com]\nlogin.example.com = https://login.example.com\nkey-types = ssh-ed25519, ssh-rsa\nmin-rsa-bits = 3072\n"), 0600))
+
+	conf, err := Load(path)
+	assert.NoError(t, err)
+
+	info, err := conf.GetInfo("login.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ssh.KeyAlgoED25519, ssh.KeyAlgoRSA}, info.KeyTypes)
+	assert.Equal(t, 3072, info.MinRSABits)
+
+	assert.NoError(t, os.WriteFile(path, []byte(global+"[example.com]\nlogin.example.com = https://login.example.com\n"), 0600))
+
+	conf, err = Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, KeyTypes, conf.HostGroups[0].AcceptedKeyTypes)
+
+	assert.NoError(t, os.WriteFile(path, []byte(global+"[example.com]\nlogin.example.com = https://login.example.com\nkey-types = ssh-dss\n"), 0600))
+
+	_, err = Load(path)
+	assert.EqualError(t, err, "unknown key type ssh-dss in hostgroup example.com")
+}
+
+func TestFreezeWindows(t *testing.T) {
+	for _, value := range []string{"0 8 * * sat", "0 8 * * sat 0s", "0 8 * * sat 200h", "0 8 * sat 4h", "0 8 * * sat 4x"} {
+		_, err := parseFreezeWindows(value)
+		assert.Error(t, err, value)
+	}
+
+	windows, err := parseFreezeWindows("0 8 * * sat 4h | 0 10 * * * 30m |")
+	assert.NoError(t, err)
+	assert.Len(t, windows, 2)
+
+	// Saturday
+	sat := time.Date(2024, time.December, 21, 0, 0, 0, 0, time.Local)
+
+	for _, test := range []struct {
+		now    time.Time
+		active bool
+		end    time.Time
+	}{
+		{sat.Add(7*time.Hour + 59*time.Minute), false, time.Time{}},
+		{sat.Add(8 * time.Hour), true, sat.Add(12 * time.Hour)},
+		{sat.Add(10*time.Hour + 15*time.Minute), true, sat.Add(12 * time.Hour)},
+		{sat.Add(12 * time.Hour), false, time.Time{}},
+		{sat.Add(34*time.Hour + 29*time.Minute), true, sat.Add(34*time.Hour + 30*time.Minute)},
+	} {
+		end, active := windows.Active(test.now)
+		assert.Equal(t, test.active, active, test.now)
+		assert.Equal(t, test.end, end, test.now)
+	}
+}
@@ -0,0 +1,295 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slices"
+	"gopkg.in/ini.v1"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// Replaces secrets in dumped configurations
+	MASKED = "********"
+)
+
+// Dump is a configuration as output by 'oinit-ca config', keyed by the names
+// of the ini options.
+type Dump struct {
+	Server      map[string]interface{} `yaml:"server"`
+	HostGroup   map[string]interface{} `yaml:"hostgroup,omitempty"`
+	AdminTokens []DumpAdminToken       `yaml:"admin-tokens,omitempty"`
+	AdminOIDC   []DumpAdminOIDCRule    `yaml:"admin-oidc,omitempty"`
+	VOQuotas    map[string]int         `yaml:"vo-quotas,omitempty"`
+	// Options of profiles by name
+	Profiles map[string]map[string]interface{} `yaml:"profiles,omitempty"`
+	// Options of peers by name
+	Peers map[string]map[string]interface{} `yaml:"peers,omitempty"`
+	// Options of listeners by name
+	Listeners map[string]map[string]interface{} `yaml:"listeners,omitempty"`
+	// Options of workloads by name
+	Workloads  map[string]map[string]interface{} `yaml:"workloads,omitempty"`
+	HostGroups []DumpHostGroup                   `yaml:"hostgroups,omitempty"`
+}
+
+type DumpAdminToken struct {
+	Name  string `yaml:"name"`
+	Token string `yaml:"token"`
+	Role  string `yaml:"role"`
+}
+
+type DumpAdminOIDCRule struct {
+	Issuer string `yaml:"issuer"`
+	Claim  string `yaml:"claim"`
+	Value  string `yaml:"value"`
+	Role   string `yaml:"role"`
+}
+
+type DumpHostGroup struct {
+	Name    string                 `yaml:"name"`
+	Options map[string]interface{} `yaml:"options"`
+	// motley_cue instances of each host
+	Hosts map[string][]string `yaml:"hosts"`
+}
+
+// optionValues returns the values of all fields of the struct v with an ini
+// tag, keyed by the tag.
+func optionValues(v interface{}) map[string]interface{} {
+	values := make(map[string]interface{})
+
+	rv := reflect.ValueOf(v)
+	for i := 0; i < rv.NumField(); i++ {
+		key := rv.Type().Field(i).Tag.Get("ini")
+		if key == "" || key == "-" {
+			continue
+		}
+
+		values[key] = rv.Field(i).Interface()
+	}
+
+	return values
+}
+
+// maskURL replaces the password of URLs containing credentials by "xxxxx".
+func maskURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil {
+		return value
+	}
+
+	return u.Redacted()
+}
+
+// Defaults returns the default values of all options, which are empty for
+// options without default. The options of hostgroups may also be set in the
+// default section.
+func Defaults() Dump {
+	var server ServerOptions
+	server.setDefaults()
+
+	return Dump{
+		Server: optionValues(server),
+		HostGroup: optionValues(DefaultOptions{
+			Extensions:    DEFAULT_EXTENSIONS,
+			QuotaAction:   QUOTA_DENY,
+			Principals:    PRINCIPALS_USER,
+			GraceValidity: DEFAULT_GRACE_VALIDITY,
+		}),
+	}
+}
+
+// Effective returns the configuration with all defaults and overrides
+// resolved, as used by the CA. Admin tokens and credentials in URLs are
+// masked, secrets stored in files are not read.
+func (c Config) Effective() Dump {
+	dump := Dump{
+		Server:   optionValues(c.Server),
+		VOQuotas: c.VOQuotas,
+	}
+
+	for _, token := range c.AdminTokens {
+		dump.AdminTokens = append(dump.AdminTokens, DumpAdminToken{
+			Name:  token.Name,
+			Token: MASKED,
+			Role:  token.Role,
+		})
+	}
+
+	for _, rule := range c.AdminOIDCRules {
+		dump.AdminOIDC = append(dump.AdminOIDC, DumpAdminOIDCRule(rule))
+	}
+
+	for name, profile := range c.Profiles {
+		if dump.Profiles == nil {
+			dump.Profiles = make(map[string]map[string]interface{})
+		}
+
+		dump.Profiles[name] = optionValues(profile)
+	}
+
+	for _, peer := range c.Peers {
+		if dump.Peers == nil {
+			dump.Peers = make(map[string]map[string]interface{})
+		}
+
+		options := optionValues(peer)
+		options["url"] = maskURL(peer.URL)
+		dump.Peers[peer.Name] = options
+	}
+
+	for _, listener := range c.Listeners {
+		if dump.Listeners == nil {
+			dump.Listeners = make(map[string]map[string]interface{})
+		}
+
+		dump.Listeners[listener.Name] = optionValues(listener)
+	}
+
+	for _, workload := range c.Workloads {
+		if dump.Workloads == nil {
+			dump.Workloads = make(map[string]map[string]interface{})
+		}
+
+		dump.Workloads[workload.Name] = optionValues(workload)
+	}
+
+	for _, group := range c.HostGroups {
+		options := optionValues(group.DefaultOptions)
+
+		// Show the extensions as parsed, which resolves the default
+		extensions := strings.Join(group.AllowedExtensions, ",")
+		if extensions == "" {
+			extensions = EXTENSIONS_NONE
+		}
+		options["extensions"] = extensions
+		options["delegate"] = maskURL(group.Delegate)
+
+		hosts := make(map[string][]string)
+		for host, value := range group.Hosts {
+			for _, url := range SplitURLs(value) {
+				hosts[host] = append(hosts[host], maskURL(url))
+			}
+		}
+
+		dump.HostGroups = append(dump.HostGroups, DumpHostGroup{
+			Name:    group.Name,
+			Options: options,
+			Hosts:   hosts,
+		})
+	}
+
+	return dump
+}
+
+// Snapshot returns the effective configuration flattened to option paths
+// such as "hostgroups.example.com.options.cert-validity", along with the
+// fingerprints of the CA public keys of each hostgroup as
+// "keys.<hostgroup>.host-ca" and "keys.<hostgroup>.user-ca" and of the
+// emergency keys as "emergency-keys.<name>". Snapshots of
+// consecutive starts are compared to audit configuration changes and key
+// rotations.
+func (c Config) Snapshot() map[string]string {
+	snapshot := make(map[string]string)
+
+	var dump interface{}
+	if data, err := yaml.Marshal(c.Effective()); err == nil && yaml.Unmarshal(data, &dump) == nil {
+		flatten(snapshot, "", dump)
+	}
+
+	for _, group := range c.HostGroups {
+		if group.HostCAPublicKey != nil {
+			snapshot["keys."+group.Name+".host-ca"] = ssh.FingerprintSHA256(group.HostCAPublicKey)
+		}
+
+		if group.UserCAPublicKey != nil {
+			snapshot["keys."+group.Name+".user-ca"] = ssh.FingerprintSHA256(group.UserCAPublicKey)
+		}
+	}
+
+	// Responders that may request emergency certificates are audited
+	for _, key := range c.EmergencyKeys {
+		snapshot["emergency-keys."+key.Name] = ssh.FingerprintSHA256(key.PublicKey)
+	}
+
+	return snapshot
+}
+
+// flatten adds all values nested in value to snapshot, keyed by their path.
+// List entries with a name, such as hostgroups, are keyed by it rather than
+// their index, so reordering the config doesn't show up as change.
+func flatten(snapshot map[string]string, path string, value interface{}) {
+	prefix := path
+	if prefix != "" {
+		prefix += "."
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, val := range v {
+			flatten(snapshot, prefix+key, val)
+		}
+	case []interface{}:
+		for i, val := range v {
+			key := strconv.Itoa(i)
+			if entry, ok := val.(map[string]interface{}); ok {
+				if name, ok := entry["name"].(string); ok {
+					key = name
+				}
+			}
+
+			flatten(snapshot, prefix+key, val)
+		}
+	case nil:
+		snapshot[path] = ""
+	default:
+		snapshot[path] = fmt.Sprint(v)
+	}
+}
+
+// isHostGroupSection reports whether the section defines a hostgroup, rather
+// than being the default section or defining a profile, peer, listener or
+// workload.
+func isHostGroupSection(section *ini.Section) bool {
+	return section.Name() != ini.DefaultSection && !isProfileSection(section) && !isPeerSection(section) &&
+		!isListenSection(section) && !isWorkloadSection(section)
+}
+
+// Raw returns the options set in the config file at path, without defaults
+// and overrides resolved. Credentials in URLs are masked.
+func Raw(path string) (map[string]map[string]string, error) {
+	cfg, err := ini.Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	options := optionKeys()
+
+	sections := make(map[string]map[string]string)
+	for _, section := range cfg.Sections() {
+		values := make(map[string]string)
+		for key, value := range section.KeysHash() {
+			// All other keys of hostgroups are hosts
+			if isHostGroupSection(section) && !slices.Contains(options, key) {
+				var urls []string
+				for _, url := range SplitURLs(value) {
+					urls = append(urls, maskURL(url))
+				}
+
+				value = strings.Join(urls, ", ")
+			}
+
+			values[key] = value
+		}
+
+		if len(values) != 0 {
+			sections[section.Name()] = values
+		}
+	}
+
+	return sections, nil
+}
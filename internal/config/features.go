@@ -0,0 +1,44 @@
+package config
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// Feature flags of hostgroups, which can be disabled to roll out
+	// capabilities gradually
+	FEATURE_HOST_KEYS = "host-keys" // report and look up host keys
+	FEATURE_KRL       = "krl"       // download the KRL of the user CA
+	FEATURE_DRY_RUN   = "dry-run"   // request certificates without signing
+	FEATURE_BUNDLE    = "bundle"    // download certificates as bundle
+)
+
+// Features contains all feature flags, which are enabled by default.
+var Features = []string{FEATURE_HOST_KEYS, FEATURE_KRL, FEATURE_DRY_RUN, FEATURE_BUNDLE}
+
+// parseFeatures parses the comma-separated features option, in which each
+// feature is enabled by its name or disabled by its name prefixed with "-".
+// Features that are not listed remain enabled. The disabled features are
+// returned.
+func parseFeatures(value string) (map[string]bool, error) {
+	disabled := make(map[string]bool)
+
+	for _, feature := range strings.Split(value, ",") {
+		feature = strings.TrimSpace(feature)
+		if feature == "" {
+			continue
+		}
+
+		name, disable := strings.CutPrefix(feature, "-")
+		if !slices.Contains(Features, name) {
+			return nil, errors.New("unknown feature " + name)
+		}
+
+		disabled[name] = disable
+	}
+
+	return disabled, nil
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/cron"
+)
+
+const (
+	// Freeze windows may last at most this duration, as finding the active
+	// window looks back minute by minute
+	MAX_FREEZE_DURATION = 7 * 24 * time.Hour
+)
+
+// FreezeWindow is a recurring window during which certificates are not
+// issued, such as regular maintenance. It is written as a cron schedule of
+// its starts in the local time zone of the CA, followed by its duration,
+// e.g. "0 8 * * sat 4h".
+type FreezeWindow struct {
+	Spec     string
+	Schedule cron.Schedule
+	Duration time.Duration
+}
+
+// FreezeWindows are the freeze windows of a hostgroup.
+type FreezeWindows []FreezeWindow
+
+// parseFreezeWindows parses freeze windows separated by "|".
+func parseFreezeWindows(value string) (FreezeWindows, error) {
+	var windows FreezeWindows
+
+	for _, spec := range strings.Split(value, "|") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		fields := strings.Fields(spec)
+
+		schedule, err := cron.Parse(strings.Join(fields[:len(fields)-1], " "))
+		if err != nil {
+			return nil, errors.New("invalid freeze window " + spec + ": " + err.Error())
+		}
+
+		duration, err := time.ParseDuration(fields[len(fields)-1])
+		if err != nil || duration < time.Minute || duration > MAX_FREEZE_DURATION {
+			return nil, errors.New("invalid duration of freeze window " + spec)
+		}
+
+		windows = append(windows, FreezeWindow{Spec: spec, Schedule: schedule, Duration: duration})
+	}
+
+	return windows, nil
+}
+
+// parseFreeze parses the freeze windows of all hostgroups.
+func parseFreeze(conf *Config) error {
+	for i, group := range conf.HostGroups {
+		windows, err := parseFreezeWindows(group.Freeze)
+		if err != nil {
+			return errors.New(err.Error() + " in hostgroup " + group.Name)
+		}
+
+		conf.HostGroups[i].FreezeWindows = windows
+	}
+
+	return nil
+}
+
+// Active returns the end of the freeze window that is active at now, or false
+// if there is none. If windows overlap, the latest end is returned.
+func (windows FreezeWindows) Active(now time.Time) (time.Time, bool) {
+	var end time.Time
+
+	minute := now.Truncate(time.Minute)
+
+	for _, window := range windows {
+		for start := minute; now.Sub(start) < window.Duration; start = start.Add(-time.Minute) {
+			if window.Schedule.Matches(start) {
+				if e := start.Add(window.Duration); e.After(end) {
+					end = e
+				}
+				break
+			}
+		}
+	}
+
+	return end, !end.IsZero()
+}
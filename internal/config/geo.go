@@ -0,0 +1,116 @@
+package config
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/lbrocke/oinit/internal/geoip"
+)
+
+const (
+	// Actions of geo rules for requests from matching networks
+	GEO_DENY  = "deny"
+	GEO_LIMIT = "limit"
+)
+
+// Countries are ISO 3166-1 alpha-2 codes, autonomous systems are written as
+// "AS<number>".
+var (
+	geoCountry = regexp.MustCompile(`^[A-Z]{2}$`)
+	geoASN     = regexp.MustCompile(`^AS[1-9][0-9]*$`)
+)
+
+// parseGeoRules parses a comma-separated list of countries and autonomous
+// systems, which are returned in upper case.
+func parseGeoRules(value string) ([]string, error) {
+	var rules []string
+
+	for _, rule := range splitList(value) {
+		rule = strings.ToUpper(rule)
+
+		if !geoCountry.MatchString(rule) && !geoASN.MatchString(rule) {
+			return nil, errors.New("invalid country or autonomous system " + rule)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// parseGeo parses the geo rules of all hostgroups. Rules require the GeoIP
+// databases to be configured.
+func parseGeo(conf *Config) error {
+	for i, group := range conf.HostGroups {
+		deny, err := parseGeoRules(group.GeoDeny)
+		if err != nil {
+			return errors.New(err.Error() + " in hostgroup " + group.Name)
+		}
+
+		limit, err := parseGeoRules(group.GeoLimit)
+		if err != nil {
+			return errors.New(err.Error() + " in hostgroup " + group.Name)
+		}
+
+		if len(limit) != 0 && group.GeoLimitValidity <= 0 {
+			return errors.New("missing geo-limit-validity in hostgroup " + group.Name)
+		}
+
+		if len(deny)+len(limit) != 0 && len(conf.GeoIP) == 0 {
+			return errors.New("geo rules require geoip-country-db or geoip-asn-db in hostgroup " + group.Name)
+		}
+
+		conf.HostGroups[i].GeoDenied = deny
+		conf.HostGroups[i].GeoLimited = limit
+	}
+
+	return nil
+}
+
+// loadGeoIP opens the configured GeoIP databases.
+func loadGeoIP(conf *Config) error {
+	for _, path := range []string{conf.Server.PathGeoIPCountryDB, conf.Server.PathGeoIPASNDB} {
+		if path == "" {
+			continue
+		}
+
+		db, err := geoip.Open(path)
+		if err != nil {
+			return errors.New(path + ": " + err.Error())
+		}
+
+		conf.GeoIP = append(conf.GeoIP, db)
+	}
+
+	return nil
+}
+
+// matchesGeo returns the first rule that matches the country or autonomous
+// system of the address.
+func matchesGeo(rules []string, geo geoip.Info) (string, bool) {
+	for _, rule := range rules {
+		if (geo.Country != "" && rule == geo.Country) ||
+			(geo.ASN != 0 && rule == "AS"+strconv.FormatUint(uint64(geo.ASN), 10)) {
+			return rule, true
+		}
+	}
+
+	return "", false
+}
+
+// GeoAction returns the action for requests from the network described by
+// geo, GEO_DENY or GEO_LIMIT, along with the matching rule. The action is
+// empty if no rule matches. Denials take precedence over limits.
+func (info HostInfo) GeoAction(geo geoip.Info) (string, string) {
+	if rule, ok := matchesGeo(info.GeoDeny, geo); ok {
+		return GEO_DENY, rule
+	}
+
+	if rule, ok := matchesGeo(info.GeoLimit, geo); ok {
+		return GEO_LIMIT, rule
+	}
+
+	return "", ""
+}
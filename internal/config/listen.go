@@ -0,0 +1,123 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	"gopkg.in/ini.v1"
+)
+
+const (
+	// Sections named "listen:<name>" declare addresses the CA listens on,
+	// replacing --listen and --mode of the serve command.
+	LISTEN_SECTION_PREFIX = "listen:"
+
+	// Routes served by a listener
+	LISTEN_MODE_API    = "api"    // the public API used by clients
+	LISTEN_MODE_ADMIN  = "admin"  // the admin API and dashboard
+	LISTEN_MODE_ALL    = "all"    // both of the above
+	LISTEN_MODE_HEALTH = "health" // health and metrics without authentication
+
+	DEFAULT_LISTEN_SOCKET_MODE = "0660"
+)
+
+// ListenModes contains all modes of listeners.
+var ListenModes = []string{LISTEN_MODE_API, LISTEN_MODE_ADMIN, LISTEN_MODE_ALL, LISTEN_MODE_HEALTH}
+
+// Listener is an address the CA listens on, with its own routes and
+// middleware, e.g. the public API via HTTPS, health and metrics via HTTP on
+// an internal address and the admin API on a unix socket.
+type Listener struct {
+	Name string `ini:"-"`
+	// TCP host:port or unix socket path prefixed with "unix:"
+	Address string `ini:"address"`
+	Mode    string `ini:"mode"`
+	// Permissions of unix sockets, in octal
+	SocketMode string `ini:"socket-mode"`
+	// Serve HTTPS using this certificate chain and key, both PEM-encoded
+	PathTLSCert string `ini:"tls-cert"`
+	PathTLSKey  string `ini:"tls-key"`
+	// Disables the access log of the listener, e.g. for frequent health
+	// checks
+	Quiet bool `ini:"quiet"`
+	// Overrides request-timeout (in seconds) of the default section
+	RequestTimeout int `ini:"request-timeout"`
+	// Overrides middleware of the default section
+	Middleware string `ini:"middleware"`
+
+	// Optional middleware in the order they are applied, without the logger
+	// if quiet
+	Middlewares []string `ini:"-"`
+
+	Perm os.FileMode `ini:"-"`
+}
+
+// isListenSection reports whether the section defines a listener.
+func isListenSection(section *ini.Section) bool {
+	return strings.HasPrefix(section.Name(), LISTEN_SECTION_PREFIX)
+}
+
+// parseListener parses and validates a listener section. The request
+// timeout and middleware default to the ones of the server.
+func parseListener(section *ini.Section, server ServerOptions) (Listener, error) {
+	l := Listener{
+		Name:       strings.TrimPrefix(section.Name(), LISTEN_SECTION_PREFIX),
+		Mode:       LISTEN_MODE_ALL,
+		SocketMode: DEFAULT_LISTEN_SOCKET_MODE,
+	}
+
+	if err := section.MapTo(&l); err != nil {
+		return l, err
+	}
+
+	where := " in listener " + l.Name
+
+	if l.Name == "" {
+		return l, errors.New("invalid listener name")
+	}
+
+	if l.Address == "" {
+		return l, errors.New("missing address" + where)
+	}
+
+	if !slices.Contains(ListenModes, l.Mode) {
+		return l, errors.New("unknown mode " + l.Mode + where)
+	}
+
+	perm, err := strconv.ParseUint(l.SocketMode, 8, 32)
+	if err != nil {
+		return l, errors.New("invalid socket-mode" + where)
+	}
+	l.Perm = os.FileMode(perm)
+
+	if (l.PathTLSCert == "") != (l.PathTLSKey == "") {
+		return l, errors.New("tls-cert and tls-key must be set together" + where)
+	}
+
+	if l.RequestTimeout < 0 {
+		return l, errors.New("invalid request-timeout" + where)
+	}
+
+	if l.RequestTimeout == 0 {
+		l.RequestTimeout = server.RequestTimeout
+	}
+
+	if l.Middleware == "" {
+		l.Middleware = server.Middleware
+	}
+
+	if l.Middlewares, err = parseMiddleware(l.Middleware); err != nil {
+		return l, errors.New(err.Error() + where)
+	}
+
+	if l.Quiet {
+		l.Middlewares = slices.DeleteFunc(l.Middlewares, func(name string) bool {
+			return name == MIDDLEWARE_LOGGER
+		})
+	}
+
+	return l, nil
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"errors"
+
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// Optional middleware that can be enabled and ordered with the
+	// middleware option. Recovery, request IDs, security headers, timeouts
+	// and authentication of the admin API are always enabled.
+	MIDDLEWARE_LOGGER     = "logger"     // access log
+	MIDDLEWARE_RATE_LIMIT = "rate-limit" // requests per client, see rate-limit
+	MIDDLEWARE_CORS       = "cors"       // cross-origin requests, see cors-origins
+	MIDDLEWARE_GZIP       = "gzip"       // compressed responses
+	MIDDLEWARE_TARPIT     = "tarpit"     // delays clients failing to authenticate, see tarpit-threshold
+	// Server-Timing header with the durations of certificate requests, for
+	// debugging and staging deployments
+	MIDDLEWARE_SERVER_TIMING = "server-timing"
+
+	// Disables all optional middleware
+	MIDDLEWARE_NONE = "none"
+
+	DEFAULT_MIDDLEWARE = MIDDLEWARE_LOGGER
+	// Requests per minute of each client
+	DEFAULT_RATE_LIMIT = 60
+	// Failed authentications of a client before its requests are delayed,
+	// the maximum delay in seconds, and failures that raise an alert
+	DEFAULT_TARPIT_THRESHOLD = 5
+	DEFAULT_TARPIT_MAX_DELAY = 30
+	DEFAULT_TARPIT_ALERT     = 50
+)
+
+// Middlewares contains all optional middleware.
+var Middlewares = []string{MIDDLEWARE_LOGGER, MIDDLEWARE_RATE_LIMIT, MIDDLEWARE_CORS, MIDDLEWARE_GZIP, MIDDLEWARE_TARPIT, MIDDLEWARE_SERVER_TIMING}
+
+// parseMiddleware parses a comma-separated, ordered list of middleware.
+func parseMiddleware(value string) ([]string, error) {
+	chain := []string{}
+
+	for _, name := range splitList(value) {
+		if name == MIDDLEWARE_NONE {
+			continue
+		}
+
+		if !slices.Contains(Middlewares, name) {
+			return nil, errors.New("unknown middleware " + name)
+		}
+
+		if slices.Contains(chain, name) {
+			return nil, errors.New("duplicate middleware " + name)
+		}
+
+		chain = append(chain, name)
+	}
+
+	return chain, nil
+}
+
+// MiddlewareChain returns the optional middleware of the middleware option,
+// in the order they are applied.
+func (o ServerOptions) MiddlewareChain() []string {
+	// Validated when loading the config
+	chain, _ := parseMiddleware(o.Middleware)
+
+	return chain
+}
+
+// checkMiddleware validates the middleware of the server and all listeners.
+func checkMiddleware(conf Config) error {
+	chains := [][]string{}
+
+	chain, err := parseMiddleware(conf.Server.Middleware)
+	if err != nil {
+		return err
+	}
+	chains = append(chains, chain)
+
+	for _, l := range conf.Listeners {
+		chains = append(chains, l.Middlewares)
+	}
+
+	for _, chain := range chains {
+		if slices.Contains(chain, MIDDLEWARE_CORS) && len(conf.Server.CORSOrigins()) == 0 {
+			return errors.New("middleware cors requires cors-origins")
+		}
+	}
+
+	return nil
+}
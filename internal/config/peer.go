@@ -0,0 +1,97 @@
+package config
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/lbrocke/oinit/internal/util"
+
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/ini.v1"
+)
+
+const (
+	// Sections named "peer:<name>" list peer oinit-ca instances that serve
+	// hosts of other infrastructures.
+	PEER_SECTION_PREFIX = "peer:"
+)
+
+// Peer is another oinit-ca instance that is authoritative for some hosts.
+// Requests for these hosts are redirected to it, and the host CA key of the
+// peer is published along with the own keys, so that a single client config
+// spans multiple infrastructures.
+type Peer struct {
+	Name string `ini:"-"`
+	// Base URL of the peer, such as https://ca.other.example.org
+	URL                 string `ini:"url"`
+	PathHostCAPublicKey string `ini:"host-ca-pubkey"`
+	// Comma-separated hosts served by the peer, which may be wildcards
+	Hosts string `ini:"hosts"`
+
+	HostCAPublicKey ssh.PublicKey `ini:"-"`
+	HostPatterns    []string      `ini:"-"`
+}
+
+// isPeerSection reports whether the section defines a peer.
+func isPeerSection(section *ini.Section) bool {
+	return strings.HasPrefix(section.Name(), PEER_SECTION_PREFIX)
+}
+
+// parsePeer parses and validates a peer section, including its host CA key.
+func parsePeer(section *ini.Section) (Peer, error) {
+	peer := Peer{Name: strings.TrimPrefix(section.Name(), PEER_SECTION_PREFIX)}
+
+	if err := section.MapTo(&peer); err != nil {
+		return peer, err
+	}
+
+	where := " in peer " + peer.Name
+
+	if peer.Name == "" {
+		return peer, errors.New("invalid peer name")
+	}
+
+	u, err := url.Parse(peer.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return peer, errors.New("invalid url" + where)
+	}
+
+	peer.URL = strings.TrimSuffix(peer.URL, "/")
+
+	for _, host := range strings.Split(peer.Hosts, ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			peer.HostPatterns = append(peer.HostPatterns, host)
+		}
+	}
+
+	if len(peer.HostPatterns) == 0 {
+		return peer, errors.New("missing hosts" + where)
+	}
+
+	if peer.PathHostCAPublicKey == "" {
+		return peer, errors.New("missing host-ca-pubkey" + where)
+	}
+
+	if peer.HostCAPublicKey, err = parsePublicKeyFile(peer.PathHostCAPublicKey); err != nil {
+		return peer, errors.New("could not parse host-ca-pubkey" + where)
+	}
+
+	return peer, nil
+}
+
+// Peer returns the peer that serves the given host. Hosts of own hostgroups
+// take precedence and should be looked up first.
+func (c Config) Peer(host string) (Peer, bool) {
+	host = strings.ToLower(host)
+
+	for _, peer := range c.Peers {
+		for _, pattern := range peer.HostPatterns {
+			if util.MatchesHost(host, "", pattern, "") {
+				return peer, true
+			}
+		}
+	}
+
+	return Peer{}, false
+}
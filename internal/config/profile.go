@@ -0,0 +1,139 @@
+package config
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	"gopkg.in/ini.v1"
+)
+
+const (
+	// Sections named "profile:<name>" define certificate profiles rather
+	// than hostgroups.
+	PROFILE_SECTION_PREFIX = "profile:"
+
+	// Principals policies: only the account motley_cue deployed the user as,
+	// or all accounts motley_cue maps the user to, such as shared project
+	// accounts
+	PRINCIPALS_USER = "user"
+	PRINCIPALS_ALL  = "all"
+)
+
+// PrincipalsPolicies contains all principals policies.
+var PrincipalsPolicies = []string{PRINCIPALS_USER, PRINCIPALS_ALL}
+
+// Profile bundles the properties of certificates for a purpose, such as
+// interactive logins, batch jobs or file transfers. Hostgroups list the
+// profiles they offer, clients may request one of them. Options that are not
+// set fall back to those of the hostgroup.
+type Profile struct {
+	Name         string `ini:"-"`
+	CertValidity string `ini:"cert-validity"`
+	Extensions   string `ini:"extensions"`
+	// Command that certificates are restricted to
+	Command    string `ini:"command"`
+	Principals string `ini:"principals"`
+
+	// Parsed options, valid if the corresponding option is set
+	CertDuration      int      `ini:"-"`
+	AllowedExtensions []string `ini:"-"`
+}
+
+// isProfileSection reports whether the section defines a profile.
+func isProfileSection(section *ini.Section) bool {
+	return strings.HasPrefix(section.Name(), PROFILE_SECTION_PREFIX)
+}
+
+// parseProfile parses and validates a profile section.
+func parseProfile(section *ini.Section) (Profile, error) {
+	profile := Profile{Name: strings.TrimPrefix(section.Name(), PROFILE_SECTION_PREFIX)}
+
+	if err := section.MapTo(&profile); err != nil {
+		return profile, err
+	}
+
+	where := " in profile " + profile.Name
+
+	if profile.Name == "" || strings.ContainsAny(profile.Name, ", ") {
+		return profile, errors.New("invalid profile name " + profile.Name)
+	}
+
+	if profile.CertValidity != "" {
+		dur, err := parseValidity(profile.CertValidity)
+		if err != nil {
+			return profile, errors.New("invalid cert-validity" + where)
+		}
+
+		profile.CertDuration = dur
+	}
+
+	if profile.Extensions != "" {
+		extensions, err := parseExtensionList(profile.Extensions)
+		if err != nil {
+			return profile, errors.New(err.Error() + where)
+		}
+
+		profile.AllowedExtensions = extensions
+	}
+
+	if profile.Principals != "" && !slices.Contains(PrincipalsPolicies, profile.Principals) {
+		return profile, errors.New("unknown principals policy " + profile.Principals + where)
+	}
+
+	return profile, nil
+}
+
+// resolveProfiles sets the profiles of each hostgroup from its profiles
+// option.
+func resolveProfiles(conf *Config) error {
+	for i, group := range conf.HostGroups {
+		if group.ProfileNames == "" {
+			continue
+		}
+
+		for _, name := range strings.Split(group.ProfileNames, ",") {
+			name = strings.TrimSpace(name)
+
+			profile, ok := conf.Profiles[name]
+			if !ok {
+				return errors.New("unknown profile " + name + " in hostgroup " + group.Name)
+			}
+
+			conf.HostGroups[i].Profiles = append(conf.HostGroups[i].Profiles, profile)
+		}
+	}
+
+	return nil
+}
+
+// Profile returns the profile with the given name, or the default (first)
+// profile if name is empty. The second value is false if the host offers no
+// such profile. Hosts without profiles return a nil profile for an empty name.
+func (info HostInfo) Profile(name string) (*Profile, bool) {
+	if len(info.Profiles) == 0 {
+		return nil, name == ""
+	}
+
+	if name == "" {
+		return &info.Profiles[0], true
+	}
+
+	for i := range info.Profiles {
+		if info.Profiles[i].Name == name {
+			return &info.Profiles[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// ProfileNames returns the names of the profiles offered by the host.
+func (info HostInfo) ProfileNames() []string {
+	var names []string
+	for _, profile := range info.Profiles {
+		names = append(names, profile.Name)
+	}
+
+	return names
+}
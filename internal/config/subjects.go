@@ -0,0 +1,68 @@
+package config
+
+import (
+	"errors"
+
+	"github.com/lbrocke/oinit/internal/subjects"
+)
+
+// loadSubjectLists opens the subject-allow and subject-deny lists of all
+// hostgroups. Hostgroups sharing a file share the list.
+func loadSubjectLists(conf *Config) error {
+	lists := make(map[string]*subjects.List)
+
+	open := func(path string) (*subjects.List, error) {
+		if path == "" {
+			return nil, nil
+		}
+
+		if list, ok := lists[path]; ok {
+			return list, nil
+		}
+
+		list, err := subjects.Open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		lists[path] = list
+
+		return list, nil
+	}
+
+	for i, group := range conf.HostGroups {
+		allow, err := open(group.PathSubjectAllow)
+		if err != nil {
+			return errors.New("subject-allow: " + err.Error() + " in hostgroup " + group.Name)
+		}
+
+		deny, err := open(group.PathSubjectDeny)
+		if err != nil {
+			return errors.New("subject-deny: " + err.Error() + " in hostgroup " + group.Name)
+		}
+
+		conf.HostGroups[i].SubjectAllow = allow
+		conf.HostGroups[i].SubjectDeny = deny
+	}
+
+	return nil
+}
+
+// SubjectAllowed reports whether the subject of the issuer may request
+// certificates for the host: it must not be on the deny list and, if the
+// hostgroup has an allow list, must be on it. An error is returned if a list
+// can't be read, in which case the subject must be denied.
+func (info HostInfo) SubjectAllowed(subject, issuer string) (bool, error) {
+	if info.SubjectDeny != nil {
+		denied, err := info.SubjectDeny.Contains(subject, issuer)
+		if err != nil || denied {
+			return false, err
+		}
+	}
+
+	if info.SubjectAllow != nil {
+		return info.SubjectAllow.Contains(subject, issuer)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/exp/slices"
+)
+
+// Options are validated against the validate tags of DefaultOptions and
+// ServerOptions. In addition to the tags of package validator, the following
+// ones are supported:
+//
+//	validity    a cert-validity option, see parseValidity
+//	principals  one of PrincipalsPolicies
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+
+	// Report options by their names in the config file
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("ini"), ",")
+		return name
+	})
+
+	_ = v.RegisterValidation("validity", func(fl validator.FieldLevel) bool {
+		_, err := parseValidity(fl.Field().String())
+		return err == nil
+	})
+
+	_ = v.RegisterValidation("principals", func(fl validator.FieldLevel) bool {
+		return slices.Contains(PrincipalsPolicies, fl.Field().String())
+	})
+
+	return v
+}
+
+// validateOptions validates the options, which must be a pointer to
+// DefaultOptions or ServerOptions, and returns one error per invalid option,
+// each suffixed with where.
+func validateOptions(options interface{}, where string) []error {
+	err := validate.Struct(options)
+	if err == nil {
+		return nil
+	}
+
+	var fieldErrors validator.ValidationErrors
+	if !errors.As(err, &fieldErrors) {
+		return []error{err}
+	}
+
+	var errs []error
+	for _, fe := range fieldErrors {
+		errs = append(errs, errors.New(optionError(fe)+where))
+	}
+
+	return errs
+}
+
+// optionError describes why the option is invalid.
+func optionError(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required", "required_with":
+		return "missing " + fe.Field()
+	case "file":
+		return fe.Field() + " " + fe.Value().(string) + " does not exist"
+	case "principals":
+		return "unknown principals policy " + fe.Value().(string)
+	default:
+		return "invalid " + fe.Field()
+	}
+}
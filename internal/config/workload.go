@@ -0,0 +1,160 @@
+package config
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/lbrocke/oinit/internal/util"
+
+	"gopkg.in/ini.v1"
+)
+
+const (
+	// Sections named "workload:<name>" accept identity tokens of workloads,
+	// such as Kubernetes service accounts or GitHub Actions, for a local
+	// service account.
+	WORKLOAD_SECTION_PREFIX = "workload:"
+)
+
+// Workload maps identity tokens of an issuer to a local account. Unlike
+// access tokens of users, these tokens are verified by the CA itself, as
+// motley_cue doesn't know the issuer, and the account must already exist on
+// the hosts.
+type Workload struct {
+	Name   string `ini:"-"`
+	Issuer string `ini:"issuer"`
+	// Required audience of tokens, such as the URL of the CA
+	Audience string `ini:"audience"`
+	// JWKS of the issuer, discovered if empty
+	JWKSURL string `ini:"jwks-url"`
+	// Comma-separated subjects that are accepted, "*" matches any
+	// characters, e.g. "repo:org/repo:ref:refs/heads/*"
+	Subjects string `ini:"subjects"`
+	// Local account that certificates are issued for
+	User string `ini:"user"`
+	// Comma-separated hosts that certificates may be issued for, which may
+	// be wildcards
+	Hosts string `ini:"hosts"`
+
+	SubjectPatterns []*regexp.Regexp `ini:"-"`
+	HostPatterns    []string         `ini:"-"`
+}
+
+// isWorkloadSection reports whether the section defines a workload.
+func isWorkloadSection(section *ini.Section) bool {
+	return strings.HasPrefix(section.Name(), WORKLOAD_SECTION_PREFIX)
+}
+
+// splitList splits a comma-separated option into its trimmed, non-empty
+// values.
+func splitList(value string) []string {
+	var values []string
+
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
+
+// subjectPattern compiles a subject in which "*" matches any characters.
+func subjectPattern(subject string) *regexp.Regexp {
+	parts := strings.Split(subject, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+}
+
+// parseWorkload parses and validates a workload section.
+func parseWorkload(section *ini.Section) (Workload, error) {
+	w := Workload{Name: strings.TrimPrefix(section.Name(), WORKLOAD_SECTION_PREFIX)}
+
+	if err := section.MapTo(&w); err != nil {
+		return w, err
+	}
+
+	where := " in workload " + w.Name
+
+	if w.Name == "" {
+		return w, errors.New("invalid workload name")
+	}
+
+	for option, value := range map[string]string{"issuer": w.Issuer, "jwks-url": w.JWKSURL} {
+		if value == "" && option == "jwks-url" {
+			continue
+		}
+
+		u, err := url.Parse(value)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return w, errors.New("invalid " + option + where)
+		}
+	}
+
+	if w.Audience == "" {
+		return w, errors.New("missing audience" + where)
+	}
+
+	if w.User == "" {
+		return w, errors.New("missing user" + where)
+	}
+
+	for _, subject := range splitList(w.Subjects) {
+		w.SubjectPatterns = append(w.SubjectPatterns, subjectPattern(subject))
+	}
+
+	if len(w.SubjectPatterns) == 0 {
+		return w, errors.New("missing subjects" + where)
+	}
+
+	for _, host := range splitList(w.Hosts) {
+		w.HostPatterns = append(w.HostPatterns, strings.ToLower(host))
+	}
+
+	if len(w.HostPatterns) == 0 {
+		return w, errors.New("missing hosts" + where)
+	}
+
+	return w, nil
+}
+
+// Workload returns the workload of the issuer that accepts the subject for
+// the host.
+func (c Config) Workload(issuer, subject, host string) (Workload, bool) {
+	host = strings.ToLower(host)
+
+	for _, w := range c.Workloads {
+		if w.Issuer != issuer {
+			continue
+		}
+
+		subjectOK := false
+		for _, pattern := range w.SubjectPatterns {
+			subjectOK = subjectOK || pattern.MatchString(subject)
+		}
+
+		for _, pattern := range w.HostPatterns {
+			if subjectOK && util.MatchesHost(host, "", pattern, "") {
+				return w, true
+			}
+		}
+	}
+
+	return Workload{}, false
+}
+
+// WorkloadIssuer reports whether tokens of the issuer are workload tokens.
+func (c Config) WorkloadIssuer(issuer string) bool {
+	for _, w := range c.Workloads {
+		if w.Issuer == issuer {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,164 @@
+// Package cron parses schedules in the five-field format of crontab(5):
+//
+//	minute hour day-of-month month day-of-week
+//
+// Fields are "*", numbers, ranges ("1-5") and steps ("*/15", "8-18/2"),
+// separated by commas. Months and days of the week may be given as the first
+// three letters of their English names, and Sunday as both 0 and 7. As in
+// cron, if both day fields are restricted (don't start with "*"), a time
+// matches if either of them matches.
+package cron
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ERR_FIELD_COUNT   = "schedule must have 5 fields"
+	ERR_INVALID_FIELD = "invalid field"
+)
+
+var (
+	ErrFieldCount   = errors.New(ERR_FIELD_COUNT)
+	ErrInvalidField = errors.New(ERR_INVALID_FIELD)
+)
+
+var (
+	monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	dayNames   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// Schedule is a parsed cron schedule. Each field is a bit set of the values
+// it matches.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	// Whether the day fields are "*"
+	domAny, dowAny bool
+}
+
+// Parse parses the schedule.
+func Parse(spec string) (Schedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return Schedule{}, ErrFieldCount
+	}
+
+	var s Schedule
+	var err error
+
+	if s.minute, err = parseField(fields[0], 0, 59, nil); err != nil {
+		return s, err
+	}
+	if s.hour, err = parseField(fields[1], 0, 23, nil); err != nil {
+		return s, err
+	}
+	if s.dom, err = parseField(fields[2], 1, 31, nil); err != nil {
+		return s, err
+	}
+	if s.month, err = parseField(fields[3], 1, 12, monthNames); err != nil {
+		return s, err
+	}
+	if s.dow, err = parseField(fields[4], 0, 7, dayNames); err != nil {
+		return s, err
+	}
+
+	// Sunday is both 0 and 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+
+	s.domAny = strings.HasPrefix(fields[2], "*")
+	s.dowAny = strings.HasPrefix(fields[4], "*")
+
+	return s, nil
+}
+
+// parseField returns the bit set of the values of the field, which must be
+// within min and max. names are the names of the values starting at min.
+func parseField(field string, min, max int, names []string) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		rng, step, hasStep := strings.Cut(part, "/")
+
+		var lo, hi int
+		if rng == "*" {
+			lo, hi = min, max
+		} else {
+			first, last, isRange := strings.Cut(rng, "-")
+
+			var err error
+			if lo, err = parseValue(first, min, max, names); err != nil {
+				return 0, err
+			}
+
+			hi = lo
+			if isRange {
+				if hi, err = parseValue(last, min, max, names); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				// "5/15" is the same as "5-max/15"
+				hi = max
+			}
+		}
+
+		if lo > hi {
+			return 0, fmt.Errorf("%w: %s", ErrInvalidField, part)
+		}
+
+		n := 1
+		if hasStep {
+			var err error
+			if n, err = strconv.Atoi(step); err != nil || n < 1 {
+				return 0, fmt.Errorf("%w: %s", ErrInvalidField, part)
+			}
+		}
+
+		for v := lo; v <= hi; v += n {
+			bits |= 1 << v
+		}
+	}
+
+	return bits, nil
+}
+
+func parseValue(value string, min, max int, names []string) (int, error) {
+	for i, name := range names {
+		if strings.EqualFold(value, name) {
+			return min + i, nil
+		}
+	}
+
+	v, err := strconv.Atoi(value)
+	if err != nil || v < min || v > max {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidField, value)
+	}
+
+	return v, nil
+}
+
+// Matches reports whether the minute of t matches the schedule.
+func (s Schedule) Matches(t time.Time) bool {
+	if s.minute&(1<<t.Minute()) == 0 || s.hour&(1<<t.Hour()) == 0 || s.month&(1<<t.Month()) == 0 {
+		return false
+	}
+
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<t.Weekday()) != 0
+
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
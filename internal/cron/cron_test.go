@@ -0,0 +1,47 @@
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	for _, spec := range []string{"* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "* * * foo *", "1,,2 * * * *"} {
+		_, err := Parse(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	// Saturday
+	sat := time.Date(2024, time.December, 21, 8, 30, 0, 0, time.UTC)
+
+	for _, test := range []struct {
+		spec    string
+		matches bool
+	}{
+		{"* * * * *", true},
+		{"30 8 * * *", true},
+		{"*/15 8-12 * * sat", true},
+		{"*/20 * * * *", false},
+		{"0 8 * * sat", false},
+		{"30 8 * * mon-fri", false},
+		{"30 8 * dec 6", true},
+		{"30 8 * * 0,7", false},
+		{"30 8 24-26 12 *", false},
+		// Either of the restricted day fields matches
+		{"30 8 24-26 12 sat", true},
+		{"30 8 21 * mon", true},
+		{"10/20 8 * * *", true},
+	} {
+		s, err := Parse(test.spec)
+		assert.NoError(t, err, test.spec)
+		assert.Equal(t, test.matches, s.Matches(sat), test.spec)
+	}
+
+	s, err := Parse("0 0 * * 7")
+	assert.NoError(t, err)
+	assert.True(t, s.Matches(time.Date(2024, time.December, 22, 0, 0, 0, 0, time.UTC)))
+}
@@ -0,0 +1,77 @@
+package dnsutil
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// SSHFP algorithm numbers, see RFC 4255, RFC 6594 and RFC 7479
+	SSHFP_ALG_RSA     = 1
+	SSHFP_ALG_ECDSA   = 3
+	SSHFP_ALG_ED25519 = 4
+
+	// SSHFP fingerprint types
+	SSHFP_TYPE_SHA1   = 1
+	SSHFP_TYPE_SHA256 = 2
+
+	// CERT records of type URI contain this URI identifying the format,
+	// followed by the certificate in SSH wire format, see RFC 4398.
+	CERT_FORMAT_URI = "https://cvsweb.openbsd.org/src/usr.bin/ssh/PROTOCOL.certkeys"
+
+	ERR_UNSUPPORTED_KEY = "unsupported key type for SSHFP records"
+)
+
+// fqdn returns the host name with a trailing dot, as used in zone files.
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+
+	return name + "."
+}
+
+func sshfpAlgorithm(key ssh.PublicKey) (int, error) {
+	switch key.Type() {
+	case ssh.KeyAlgoRSA:
+		return SSHFP_ALG_RSA, nil
+	case ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521:
+		return SSHFP_ALG_ECDSA, nil
+	case ssh.KeyAlgoED25519:
+		return SSHFP_ALG_ED25519, nil
+	default:
+		return 0, errors.New(ERR_UNSUPPORTED_KEY)
+	}
+}
+
+// SSHFP returns the SSHFP records of the given host key in zone file format,
+// with SHA-1 and SHA-256 fingerprints like 'ssh-keygen -r'.
+func SSHFP(name string, ttl int, key ssh.PublicKey) ([]string, error) {
+	alg, err := sshfpAlgorithm(key)
+	if err != nil {
+		return nil, err
+	}
+
+	sha1Sum := sha1.Sum(key.Marshal())
+	sha256Sum := sha256.Sum256(key.Marshal())
+
+	return []string{
+		fmt.Sprintf("%s %d IN SSHFP %d %d %s", fqdn(name), ttl, alg, SSHFP_TYPE_SHA1, hex.EncodeToString(sha1Sum[:])),
+		fmt.Sprintf("%s %d IN SSHFP %d %d %s", fqdn(name), ttl, alg, SSHFP_TYPE_SHA256, hex.EncodeToString(sha256Sum[:])),
+	}, nil
+}
+
+// CERT returns a CERT record of type URI containing the given host
+// certificate in zone file format.
+func CERT(name string, ttl int, cert *ssh.Certificate) string {
+	data := append([]byte(CERT_FORMAT_URI+"\x00"), cert.Marshal()...)
+
+	return fmt.Sprintf("%s %d IN CERT URI 0 0 %s", fqdn(name), ttl, base64.StdEncoding.EncodeToString(data))
+}
@@ -0,0 +1,48 @@
+package dnsutil
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSSHFP(t *testing.T) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIDWFzgtPJlZrvyQ+jRCrhoRkxH9hiQiFvTh5tkgP1EM5"))
+	assert.NoError(t, err)
+
+	// Output of 'ssh-keygen -r login.example.com'
+	records, err := SSHFP("login.example.com", 300, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"login.example.com. 300 IN SSHFP 4 1 bc787af364a4e44667d2cb4a92b6f6b605e00fb5",
+		"login.example.com. 300 IN SSHFP 4 2 d80b99804c365f365d2ba65d877ad5383906adda17ed3f234f06bfa13f72c86c",
+	}, records)
+}
+
+func TestCERT(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	sshPub, _ := ssh.NewPublicKey(pub)
+	signer, _ := ssh.NewSignerFromKey(priv)
+
+	cert := &ssh.Certificate{Key: sshPub, CertType: ssh.HostCert, ValidPrincipals: []string{"login.example.com"}}
+	assert.NoError(t, cert.SignCert(rand.Reader, signer))
+
+	fields := strings.Fields(CERT("login.example.com.", 300, cert))
+	assert.Equal(t, []string{"login.example.com.", "300", "IN", "CERT", "URI", "0", "0"}, fields[:7])
+
+	data, err := base64.StdEncoding.DecodeString(fields[7])
+	assert.NoError(t, err)
+
+	uri, blob, _ := bytes.Cut(data, []byte{0})
+	assert.Equal(t, CERT_FORMAT_URI, string(uri))
+
+	parsed, err := ssh.ParsePublicKey(blob)
+	assert.NoError(t, err)
+	assert.Equal(t, cert.Marshal(), parsed.Marshal())
+}
@@ -0,0 +1,467 @@
+// Package doctor implements self-tests of a CA setup, such as checking key
+// files and upstream motley_cue instances, and suggests remediation steps.
+package doctor
+
+import (
+	"bytes"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/ntp"
+	"github.com/lbrocke/oinit/pkg/libmotleycue"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type Status int
+
+const (
+	StatusOK Status = iota
+	StatusWarn
+	StatusFail
+)
+
+const (
+	// Clock offsets larger than this are reported as failures, as OpenSSH
+	// hosts would reject certificates that are not yet valid.
+	MAX_CLOCK_SKEW = 5 * time.Second
+
+	MIN_RSA_BITS         = 2048
+	RECOMMENDED_RSA_BITS = 3072
+
+	// Oldest motley_cue version whose API is implemented by libmotleycue
+	MIN_MOTLEY_CUE_VERSION = "0.5.3"
+
+	// TLS certificates expiring within this duration are reported as
+	// warnings.
+	CERT_EXPIRY_WARNING = 30 * 24 * time.Hour
+
+	TLS_DIAL_TIMEOUT = 10 * time.Second
+)
+
+// Result is the outcome of a single check.
+type Result struct {
+	Name        string
+	Status      Status
+	Message     string
+	Remediation string
+}
+
+func ok(name, msg string) Result {
+	return Result{Name: name, Status: StatusOK, Message: msg}
+}
+
+func warn(name, msg, remediation string) Result {
+	return Result{Name: name, Status: StatusWarn, Message: msg, Remediation: remediation}
+}
+
+func fail(name, msg, remediation string) Result {
+	return Result{Name: name, Status: StatusFail, Message: msg, Remediation: remediation}
+}
+
+// Run executes all checks for the given configuration and returns their
+// results.
+func Run(conf config.Config) []Result {
+	var results []Result
+
+	results = append(results, CheckKeyPermissions(conf)...)
+	results = append(results, CheckKeys(conf)...)
+	results = append(results, CheckUpstreams(conf)...)
+	results = append(results, CheckListeners(conf)...)
+
+	if conf.Server.NTPServer != config.NTP_SERVER_NONE {
+		results = append(results, CheckClock(conf.Server.NTPServer))
+	}
+
+	return results
+}
+
+// Failed returns whether any of the given results has failed.
+func Failed(results []Result) bool {
+	for _, res := range results {
+		if res.Status == StatusFail {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CheckKeyPermissions verifies that private key files are not accessible by
+// group or others.
+func CheckKeyPermissions(conf config.Config) []Result {
+	var results []Result
+
+	if runtime.GOOS == "windows" {
+		return results
+	}
+
+	seen := make(map[string]bool)
+
+	for _, group := range conf.HostGroups {
+		for _, path := range []string{group.PathHostCAPrivateKey, group.PathUserCAPrivateKey} {
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+
+			name := "permissions of " + path
+
+			stat, err := os.Stat(path)
+			if err != nil {
+				results = append(results, fail(name, err.Error(), "Make sure the file exists and is readable."))
+				continue
+			}
+
+			if perm := stat.Mode().Perm(); perm&0077 != 0 {
+				results = append(results, fail(name,
+					fmt.Sprintf("private key is accessible by others (%#o)", perm),
+					"Run 'chmod 600 "+path+"'."))
+				continue
+			}
+
+			results = append(results, ok(name, "private key is only accessible by owner"))
+		}
+	}
+
+	return results
+}
+
+// checkAlgorithm checks the type and size of a private key.
+func checkAlgorithm(name string, key interface{}) Result {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		bits := k.N.BitLen()
+		msg := fmt.Sprintf("RSA key with %d bits", bits)
+
+		if bits < MIN_RSA_BITS {
+			return fail(name, msg, fmt.Sprintf("Generate a new key with at least %d bits.", RECOMMENDED_RSA_BITS))
+		} else if bits < RECOMMENDED_RSA_BITS {
+			return warn(name, msg, fmt.Sprintf("Consider generating a new key with at least %d bits.", RECOMMENDED_RSA_BITS))
+		}
+
+		return ok(name, msg)
+	case *ecdsa.PrivateKey:
+		return ok(name, "ECDSA key on curve "+k.Curve.Params().Name)
+	case ed25519.PrivateKey, *ed25519.PrivateKey:
+		return ok(name, "Ed25519 key")
+	case *dsa.PrivateKey:
+		return fail(name, "DSA key", "DSA is deprecated and disabled by OpenSSH, generate a new Ed25519 key.")
+	default:
+		return fail(name, fmt.Sprintf("unsupported key type %T", key), "Generate a new Ed25519 key.")
+	}
+}
+
+// CheckKeys verifies the algorithm of all private keys and that each private
+// key matches the configured public key.
+func CheckKeys(conf config.Config) []Result {
+	var results []Result
+
+	seen := make(map[string]bool)
+
+	for _, group := range conf.HostGroups {
+		pairs := []struct {
+			privPath string
+			pubPath  string
+			priv     interface{}
+			pub      ssh.PublicKey
+		}{
+			{group.PathHostCAPrivateKey, group.PathHostCAPublicKey, group.HostCAPrivateKey, group.HostCAPublicKey},
+			{group.PathUserCAPrivateKey, group.PathUserCAPublicKey, group.UserCAPrivateKey, group.UserCAPublicKey},
+		}
+
+		for _, pair := range pairs {
+			if seen[pair.privPath+pair.pubPath] {
+				continue
+			}
+			seen[pair.privPath+pair.pubPath] = true
+
+			results = append(results, checkAlgorithm("algorithm of "+pair.privPath, pair.priv))
+
+			name := "key pair " + pair.privPath + " / " + pair.pubPath
+
+			signer, err := ssh.NewSignerFromKey(pair.priv)
+			if err != nil {
+				results = append(results, fail(name, err.Error(), "Make sure the private key is valid."))
+				continue
+			}
+
+			if !bytes.Equal(signer.PublicKey().Marshal(), pair.pub.Marshal()) {
+				results = append(results, fail(name, "public key does not match private key",
+					"Regenerate the public key using 'ssh-keygen -y -f "+pair.privPath+"'."))
+				continue
+			}
+
+			results = append(results, ok(name, "public key matches private key"))
+		}
+	}
+
+	return results
+}
+
+// CheckUpstreams verifies that all configured motley_cue instances are
+// reachable, use TLS with a valid certificate and run a supported version.
+func CheckUpstreams(conf config.Config) []Result {
+	var results []Result
+
+	urls := make(map[string]bool)
+	for _, group := range conf.HostGroups {
+		for _, value := range group.Hosts {
+			for _, u := range config.SplitURLs(value) {
+				urls[u] = true
+			}
+		}
+	}
+
+	sorted := make([]string, 0, len(urls))
+	for u := range urls {
+		sorted = append(sorted, u)
+	}
+	sort.Strings(sorted)
+
+	for _, u := range sorted {
+		name := "motley_cue " + u
+
+		parsed, err := url.Parse(u)
+		if err != nil || parsed.Host == "" {
+			results = append(results, fail(name, "invalid URL", "Fix the URL in the config file."))
+			continue
+		}
+
+		if parsed.Scheme != "https" {
+			results = append(results, warn(name, "access tokens are sent unencrypted",
+				"Use https:// to connect to motley_cue."))
+		} else {
+			res := checkUpstreamTLS("TLS of "+u, parsed)
+			results = append(results, res)
+
+			if res.Status == StatusFail {
+				continue
+			}
+		}
+
+		client := libmotleycue.NewClient(u)
+
+		info, err := client.GetInfo()
+		if err != nil {
+			results = append(results, fail(name, "not reachable: "+err.Error(),
+				"Make sure motley_cue is running and reachable from this host, and that its TLS certificate is trusted."))
+			continue
+		}
+
+		if len(info.SupportedOPs) == 0 {
+			results = append(results, warn(name, "reachable, but no supported OPs configured",
+				"Configure at least one OP in motley_cue."))
+		} else {
+			results = append(results, ok(name, fmt.Sprintf("reachable, %d supported OPs", len(info.SupportedOPs))))
+		}
+
+		results = append(results, checkVersion("version of motley_cue "+u, client))
+	}
+
+	return results
+}
+
+// checkUpstreamTLS performs a TLS handshake with the host of the URL and
+// checks the negotiated version and the certificate of the server.
+func checkUpstreamTLS(name string, u *url.URL) Result {
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "443")
+	}
+
+	dialer := &net.Dialer{Timeout: TLS_DIAL_TIMEOUT}
+
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: u.Hostname()})
+	if err != nil {
+		return fail(name, "handshake failed: "+err.Error(),
+			"Make sure the certificate of motley_cue is valid for "+u.Hostname()+" and issued by a CA trusted by this host.")
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+
+	if state.Version < tls.VersionTLS12 {
+		return fail(name, "negotiated "+tls.VersionName(state.Version),
+			"Enable TLS 1.2 or later in the web server in front of motley_cue.")
+	}
+
+	return checkCertificate(name, tls.VersionName(state.Version), state.PeerCertificates[0], time.Now())
+}
+
+// checkCertificate checks the validity period of a TLS certificate.
+func checkCertificate(name, prefix string, cert *x509.Certificate, now time.Time) Result {
+	expiry := cert.NotAfter.UTC().Format(time.RFC3339)
+
+	if now.After(cert.NotAfter) {
+		return fail(name, prefix+", certificate expired at "+expiry, "Renew the certificate.")
+	} else if now.Before(cert.NotBefore) {
+		return fail(name, prefix+", certificate is not valid before "+cert.NotBefore.UTC().Format(time.RFC3339),
+			"Check the clock of this host and the validity period of the certificate.")
+	} else if cert.NotAfter.Sub(now) < CERT_EXPIRY_WARNING {
+		return warn(name, prefix+", certificate expires at "+expiry, "Renew the certificate soon.")
+	}
+
+	return ok(name, prefix+", certificate valid until "+expiry)
+}
+
+// checkVersion checks that motley_cue runs at least MIN_MOTLEY_CUE_VERSION.
+func checkVersion(name string, client libmotleycue.Client) Result {
+	version, err := client.GetVersion()
+	if err != nil {
+		return warn(name, "could not be determined: "+err.Error(),
+			"Verify manually that motley_cue "+MIN_MOTLEY_CUE_VERSION+" or later is installed, or enable its API docs.")
+	}
+
+	older, err := versionOlder(version, MIN_MOTLEY_CUE_VERSION)
+	if err != nil {
+		return warn(name, "unknown version "+version,
+			"Verify manually that motley_cue "+MIN_MOTLEY_CUE_VERSION+" or later is installed.")
+	} else if older {
+		return fail(name, "version "+version+" is not supported",
+			"Upgrade motley_cue to "+MIN_MOTLEY_CUE_VERSION+" or later.")
+	}
+
+	return ok(name, "version "+version)
+}
+
+// versionOlder reports whether the dotted version a is older than b. Suffixes
+// such as in "0.7.0rc1", "1.0.0-dev" or "0.5.3.dev4" are ignored.
+func versionOlder(a, b string) (bool, error) {
+	parse := func(v string) ([]int, error) {
+		var parts []int
+
+		for _, field := range strings.Split(strings.TrimPrefix(v, "v"), ".") {
+			end := strings.IndexFunc(field, func(r rune) bool { return r < '0' || r > '9' })
+			if end == 0 && len(parts) == 0 {
+				return nil, fmt.Errorf("invalid version %q", v)
+			} else if end == 0 {
+				break
+			} else if end > 0 {
+				field = field[:end]
+			}
+
+			n, err := strconv.Atoi(field)
+			if err != nil {
+				return nil, err
+			}
+
+			parts = append(parts, n)
+		}
+
+		return parts, nil
+	}
+
+	va, err := parse(a)
+	if err != nil {
+		return false, err
+	}
+
+	vb, err := parse(b)
+	if err != nil {
+		return false, err
+	}
+
+	for i := 0; i < len(va) || i < len(vb); i++ {
+		var na, nb int
+		if i < len(va) {
+			na = va[i]
+		}
+		if i < len(vb) {
+			nb = vb[i]
+		}
+
+		if na != nb {
+			return na < nb, nil
+		}
+	}
+
+	return false, nil
+}
+
+// CheckListeners verifies the TLS configuration of the listeners of the CA:
+// certificates must match their key and be valid, and the public API should
+// not be served unencrypted on network addresses.
+func CheckListeners(conf config.Config) []Result {
+	var results []Result
+
+	for _, l := range conf.Listeners {
+		name := "TLS of listener " + l.Name
+
+		if l.PathTLSCert == "" {
+			if l.Mode == config.LISTEN_MODE_HEALTH || strings.HasPrefix(l.Address, "unix:") || loopback(l.Address) {
+				continue
+			}
+
+			results = append(results, warn(name, "access tokens are received unencrypted on "+l.Address,
+				"Set tls-cert and tls-key, or make sure a reverse proxy terminates TLS in front of the CA."))
+			continue
+		}
+
+		pair, err := tls.LoadX509KeyPair(l.PathTLSCert, l.PathTLSKey)
+		if err != nil {
+			results = append(results, fail(name, err.Error(),
+				"Make sure "+l.PathTLSCert+" and "+l.PathTLSKey+" contain a matching PEM-encoded certificate chain and key."))
+			continue
+		}
+
+		leaf, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			results = append(results, fail(name, err.Error(), "Make sure "+l.PathTLSCert+" contains a valid certificate."))
+			continue
+		}
+
+		results = append(results, checkCertificate(name, l.PathTLSCert, leaf, time.Now()))
+	}
+
+	return results
+}
+
+// loopback reports whether the host:port address only accepts connections
+// from the local host.
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// CheckClock compares the local clock against the given NTP server.
+func CheckClock(server string) Result {
+	name := "clock skew against " + server
+
+	offset, err := ntp.Offset(server)
+	if err != nil {
+		return warn(name, "could not query NTP server: "+err.Error(),
+			"Make sure UDP port 123 is reachable or verify the clock manually.")
+	}
+
+	msg := fmt.Sprintf("local clock is off by %s", offset.Round(time.Millisecond))
+
+	if offset > MAX_CLOCK_SKEW || offset < -MAX_CLOCK_SKEW {
+		return fail(name, msg, "Synchronize the system clock, e.g. using systemd-timesyncd or chrony.")
+	}
+
+	return ok(name, msg)
+}
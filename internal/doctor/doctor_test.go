@@ -0,0 +1,297 @@
+package doctor
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// statuses returns the status of each result by name.
+func statuses(results []Result) map[string]Status {
+	m := make(map[string]Status)
+	for _, res := range results {
+		m[res.Name] = res.Status
+	}
+
+	return m
+}
+
+func mustPublicKey(t *testing.T, key interface{}) ssh.PublicKey {
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+
+	return signer.PublicKey()
+}
+
+func hostGroup(keys config.Keys, privPath, pubPath string) config.HostGroup {
+	group := config.HostGroup{Keys: keys}
+	group.PathHostCAPrivateKey = privPath
+	group.PathHostCAPublicKey = pubPath
+	group.PathUserCAPrivateKey = privPath
+	group.PathUserCAPublicKey = pubPath
+
+	return group
+}
+
+func TestCheckKeyPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permissions are not checked on Windows")
+	}
+
+	dir := t.TempDir()
+
+	tests := []struct {
+		name   string
+		perm   os.FileMode
+		create bool
+		status Status
+	}{
+		{"owner only", 0600, true, StatusOK},
+		{"read-only", 0400, true, StatusOK},
+		{"group readable", 0640, true, StatusFail},
+		{"world readable", 0604, true, StatusFail},
+		{"missing", 0, false, StatusFail},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(dir, test.name)
+
+			if test.create {
+				require.NoError(t, os.WriteFile(path, []byte("key"), 0600))
+				require.NoError(t, os.Chmod(path, test.perm))
+			}
+
+			conf := config.Config{HostGroups: []config.HostGroup{hostGroup(config.Keys{}, path, "")}}
+
+			results := CheckKeyPermissions(conf)
+			require.Len(t, results, 1, "the same file is only checked once")
+			assert.Equal(t, test.status, results[0].Status, results[0].Message)
+		})
+	}
+}
+
+func TestCheckKeys(t *testing.T) {
+	signer := testSigner(t)
+	other := testSigner(t)
+
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	tests := []struct {
+		name   string
+		keys   config.Keys
+		status map[string]Status
+	}{
+		{
+			name: "matching",
+			keys: config.Keys{HostCAPrivateKey: edKey, HostCAPublicKey: mustPublicKey(t, edKey)},
+			status: map[string]Status{
+				"algorithm of priv":   StatusOK,
+				"key pair priv / pub": StatusOK,
+			},
+		},
+		{
+			name: "ecdsa",
+			keys: config.Keys{HostCAPrivateKey: ecKey, HostCAPublicKey: mustPublicKey(t, ecKey)},
+			status: map[string]Status{
+				"algorithm of priv":   StatusOK,
+				"key pair priv / pub": StatusOK,
+			},
+		},
+		{
+			name: "mismatched",
+			keys: config.Keys{HostCAPrivateKey: edKey, HostCAPublicKey: other.PublicKey()},
+			status: map[string]Status{
+				"algorithm of priv":   StatusOK,
+				"key pair priv / pub": StatusFail,
+			},
+		},
+		{
+			name: "unsupported",
+			keys: config.Keys{HostCAPrivateKey: "not a key", HostCAPublicKey: signer.PublicKey()},
+			status: map[string]Status{
+				"algorithm of priv":   StatusFail,
+				"key pair priv / pub": StatusFail,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conf := config.Config{HostGroups: []config.HostGroup{hostGroup(test.keys, "priv", "pub")}}
+
+			assert.Equal(t, test.status, statuses(CheckKeys(conf)))
+		})
+	}
+}
+
+// motleyCue returns a motley_cue mock serving the given version, or no
+// OpenAPI document if version is empty.
+func motleyCue(t *testing.T, version string, ops string) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/info":
+			w.Write([]byte(`{"supported_OPs": [` + ops + `]}`))
+		case "/openapi.json":
+			if version == "" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(`{"openapi": "3.0.2", "info": {"title": "motley_cue", "version": "` + version + `"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL
+}
+
+func TestCheckUpstreams(t *testing.T) {
+	op := `"https://op.example.com"`
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	untrusted := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(untrusted.Close)
+
+	tests := []struct {
+		name     string
+		upstream string
+		status   []Status
+	}{
+		{"supported", motleyCue(t, "0.7.1", op), []Status{StatusWarn, StatusOK, StatusOK}},
+		{"minimum version", motleyCue(t, MIN_MOTLEY_CUE_VERSION, op), []Status{StatusWarn, StatusOK, StatusOK}},
+		{"pre-release", motleyCue(t, "0.8.0rc1", op), []Status{StatusWarn, StatusOK, StatusOK}},
+		{"old version", motleyCue(t, "0.4.9", op), []Status{StatusWarn, StatusOK, StatusFail}},
+		{"unknown version", motleyCue(t, "", op), []Status{StatusWarn, StatusOK, StatusWarn}},
+		{"no OPs", motleyCue(t, "0.7.1", ""), []Status{StatusWarn, StatusWarn, StatusOK}},
+		{"unreachable", closed.URL, []Status{StatusWarn, StatusFail}},
+		{"untrusted certificate", untrusted.URL, []Status{StatusFail}},
+		{"invalid URL", "https://", []Status{StatusFail}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			group := config.HostGroup{Hosts: map[string]string{"host.example.com": test.upstream}}
+			conf := config.Config{HostGroups: []config.HostGroup{group}}
+
+			var status []Status
+			for _, res := range CheckUpstreams(conf) {
+				status = append(status, res.Status)
+			}
+
+			assert.Equal(t, test.status, status)
+		})
+	}
+}
+
+// writeCertificate writes a self-signed certificate with the given validity
+// and its key to dir, and returns their paths.
+func writeCertificate(t *testing.T, dir, name string, notBefore, notAfter time.Time) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ca.example.com"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPath := filepath.Join(dir, name+".crt")
+	keyPath := filepath.Join(dir, name+".key")
+
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	return certPath, keyPath
+}
+
+func TestCheckListeners(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	validCert, validKey := writeCertificate(t, dir, "valid", now.Add(-time.Hour), now.Add(90*24*time.Hour))
+	expiringCert, expiringKey := writeCertificate(t, dir, "expiring", now.Add(-time.Hour), now.Add(7*24*time.Hour))
+	expiredCert, expiredKey := writeCertificate(t, dir, "expired", now.Add(-48*time.Hour), now.Add(-24*time.Hour))
+
+	tests := []struct {
+		name     string
+		listener config.Listener
+		status   []Status
+	}{
+		{"valid", config.Listener{Address: ":443", PathTLSCert: validCert, PathTLSKey: validKey}, []Status{StatusOK}},
+		{"expiring", config.Listener{Address: ":443", PathTLSCert: expiringCert, PathTLSKey: expiringKey}, []Status{StatusWarn}},
+		{"expired", config.Listener{Address: ":443", PathTLSCert: expiredCert, PathTLSKey: expiredKey}, []Status{StatusFail}},
+		{"mismatched key", config.Listener{Address: ":443", PathTLSCert: validCert, PathTLSKey: expiredKey}, []Status{StatusFail}},
+		{"missing file", config.Listener{Address: ":443", PathTLSCert: filepath.Join(dir, "missing"), PathTLSKey: validKey}, []Status{StatusFail}},
+		{"plain HTTP", config.Listener{Address: ":8080", Mode: config.LISTEN_MODE_API}, []Status{StatusWarn}},
+		{"plain HTTP on loopback", config.Listener{Address: "127.0.0.1:8080", Mode: config.LISTEN_MODE_API}, nil},
+		{"unix socket", config.Listener{Address: "unix:/run/oinit-ca.sock", Mode: config.LISTEN_MODE_ADMIN}, nil},
+		{"health", config.Listener{Address: ":9090", Mode: config.LISTEN_MODE_HEALTH}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.listener.Name = test.name
+			conf := config.Config{Listeners: []config.Listener{test.listener}}
+
+			var status []Status
+			for _, res := range CheckListeners(conf) {
+				status = append(status, res.Status)
+			}
+
+			assert.Equal(t, test.status, status)
+		})
+	}
+}
+
+func TestVersionOlder(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		older bool
+	}{
+		{"0.5.3", "0.5.3", false},
+		{"0.5.2", "0.5.3", true},
+		{"0.5", "0.5.3", true},
+		{"0.10.0", "0.5.3", false},
+		{"v1.0.0", "0.5.3", false},
+		{"0.5.3.dev4", "0.5.3", false},
+	}
+
+	for _, test := range tests {
+		older, err := versionOlder(test.a, test.b)
+		assert.NoError(t, err)
+		assert.Equal(t, test.older, older, test.a+" < "+test.b)
+	}
+
+	_, err := versionOlder("unknown", "0.5.3")
+	assert.Error(t, err)
+}
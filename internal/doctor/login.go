@@ -0,0 +1,251 @@
+package doctor
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/krl"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Critical options of user certificates that sshd supports, certificates
+// with other critical options are rejected.
+var supportedCriticalOptions = []string{"force-command", "source-address", "verify-required"}
+
+// Login describes a login to simulate the certificate validation of sshd
+// for, using the files of the host.
+type Login struct {
+	// User certificate in authorized_keys format, e.g. id_ed25519-cert.pub
+	Certificate []byte
+	// Contents of the TrustedUserCAKeys file
+	TrustedUserCAKeys []byte
+	// Contents of the RevokedKeys file, nil if not used
+	RevokedKeys []byte
+	// Contents of the AuthorizedPrincipalsFile of the user, nil if not used,
+	// in which case the user name must be a principal of the certificate
+	AuthorizedPrincipals []byte
+	// Account that is logged in to
+	User string
+	// Address of the client, checked against source-address if set
+	From net.IP
+	// Time of the login
+	Time time.Time
+}
+
+// signedData returns the part of the certificate that is signed by the CA,
+// which is its wire encoding without the trailing signature.
+func signedData(cert *ssh.Certificate) []byte {
+	b := cert.Marshal()
+	return b[:len(b)-4-len(ssh.Marshal(cert.Signature))]
+}
+
+// authorizedPrincipals returns the principals of an AuthorizedPrincipalsFile,
+// ignoring comments and options.
+func authorizedPrincipals(data []byte) []string {
+	var principals []string
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Principals may be preceded by options, e.g. from="..." alice
+		fields := strings.Fields(line)
+		principals = append(principals, fields[len(fields)-1])
+	}
+
+	return principals
+}
+
+// matchesSourceAddress reports whether ip is contained in the
+// comma-separated addresses and CIDR ranges of a source-address option.
+func matchesSourceAddress(ip net.IP, addresses string) bool {
+	for _, address := range strings.Split(addresses, ",") {
+		address = strings.TrimSpace(address)
+
+		if _, network, err := net.ParseCIDR(address); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if allowed := net.ParseIP(address); allowed != nil && allowed.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// VerifyLogin simulates the validation of a user certificate by sshd and
+// returns the result of each check, so that failing logins can be debugged
+// without access to the logs of sshd. Checks after a failed check are still
+// run where possible, as several problems may occur at once.
+func VerifyLogin(login Login) []Result {
+	const name = "certificate"
+
+	pubkey, _, _, _, err := ssh.ParseAuthorizedKey(login.Certificate)
+	if err != nil {
+		return []Result{fail(name, "invalid certificate: "+err.Error(), "Pass the -cert.pub file that oinit or ssh-keygen -s wrote.")}
+	}
+
+	cert, isCert := pubkey.(*ssh.Certificate)
+	if !isCert {
+		return []Result{fail(name, "not a certificate but a plain "+pubkey.Type()+" key", "Pass the -cert.pub file instead of the public key.")}
+	}
+
+	results := []Result{ok(name, fmt.Sprintf("serial %d, key ID %q, signed by %s", cert.Serial, cert.KeyId, ssh.FingerprintSHA256(cert.SignatureKey)))}
+
+	results = append(results, checkCertType(cert))
+	results = append(results, checkTrustedCA(cert, login.TrustedUserCAKeys))
+	results = append(results, checkSignature(cert))
+	results = append(results, checkValidity(cert, login.Time))
+	results = append(results, checkPrincipal(cert, login.User, login.AuthorizedPrincipals))
+	results = append(results, checkCriticalOptions(cert, login.From))
+
+	if login.RevokedKeys != nil {
+		results = append(results, checkRevoked(cert, login.RevokedKeys))
+	}
+
+	return results
+}
+
+func checkCertType(cert *ssh.Certificate) Result {
+	const name = "certificate type"
+
+	if cert.CertType != ssh.UserCert {
+		return fail(name, "host certificate", "Host certificates can't be used to log in, request a user certificate.")
+	}
+
+	return ok(name, "user certificate")
+}
+
+func checkTrustedCA(cert *ssh.Certificate, trusted []byte) Result {
+	const name = "trusted CA"
+
+	ca := cert.SignatureKey.Marshal()
+
+	for rest := trusted; len(bytes.TrimSpace(rest)) > 0; {
+		key, _, _, r, err := ssh.ParseAuthorizedKey(rest)
+		if err != nil {
+			break
+		}
+		rest = r
+
+		if bytes.Equal(key.Marshal(), ca) {
+			return ok(name, ssh.FingerprintSHA256(cert.SignatureKey)+" is trusted")
+		}
+	}
+
+	return fail(name, "untrusted CA "+ssh.FingerprintSHA256(cert.SignatureKey),
+		"Add the user CA public key of the hostgroup (GET /api/v1/<host>) to TrustedUserCAKeys, or request the certificate from the CA of the host.")
+}
+
+func checkSignature(cert *ssh.Certificate) Result {
+	const name = "signature"
+
+	if err := cert.SignatureKey.Verify(signedData(cert), cert.Signature); err != nil {
+		return fail(name, "invalid signature: "+err.Error(), "The certificate was modified or is corrupt, request a new one.")
+	}
+
+	return ok(name, "valid "+cert.Signature.Format+" signature")
+}
+
+func checkValidity(cert *ssh.Certificate, at time.Time) Result {
+	const name = "validity"
+
+	unix := uint64(at.Unix())
+	after := time.Unix(int64(cert.ValidAfter), 0).UTC()
+
+	if unix < cert.ValidAfter {
+		return fail(name, "not yet valid, valid after "+after.Format(time.RFC3339),
+			"Synchronize the clocks of the CA and the host, e.g. using chrony.")
+	}
+
+	if cert.ValidBefore != ssh.CertTimeInfinity {
+		before := time.Unix(int64(cert.ValidBefore), 0).UTC()
+
+		if unix >= cert.ValidBefore {
+			return fail(name, "expired at "+before.Format(time.RFC3339), "Request a new certificate, e.g. by running ssh again.")
+		}
+
+		return ok(name, "valid until "+before.Format(time.RFC3339))
+	}
+
+	return ok(name, "valid forever")
+}
+
+func checkPrincipal(cert *ssh.Certificate, user string, principalsFile []byte) Result {
+	const name = "principal"
+
+	if len(cert.ValidPrincipals) == 0 {
+		return fail(name, "certificate has no principals", "sshd rejects user certificates without principals, request a new one.")
+	}
+
+	accepted := []string{user}
+	source := "user " + user
+	if principalsFile != nil {
+		accepted = authorizedPrincipals(principalsFile)
+		source = "AuthorizedPrincipalsFile"
+	}
+
+	for _, principal := range cert.ValidPrincipals {
+		for _, a := range accepted {
+			if principal == a {
+				return ok(name, principal+" is accepted by "+source)
+			}
+		}
+	}
+
+	remediation := "Log in as " + strings.Join(cert.ValidPrincipals, " or ") + ", which the certificate was issued for."
+	if principalsFile != nil {
+		remediation = "Add one of " + strings.Join(cert.ValidPrincipals, ", ") + " to the AuthorizedPrincipalsFile of " + user + "."
+	}
+
+	return fail(name, "wrong principal, certificate is valid for "+strings.Join(cert.ValidPrincipals, ", ")+", accepted: "+strings.Join(accepted, ", "), remediation)
+}
+
+func checkCriticalOptions(cert *ssh.Certificate, from net.IP) Result {
+	const name = "critical options"
+
+	for option, value := range cert.CriticalOptions {
+		known := false
+		for _, supported := range supportedCriticalOptions {
+			known = known || option == supported
+		}
+
+		if !known {
+			return fail(name, "unsupported critical option "+option, "Request a certificate without this option.")
+		}
+
+		if option == "source-address" && from != nil && !matchesSourceAddress(from, value) {
+			return fail(name, "source address "+from.String()+" not in "+value, "Log in from an allowed address or request a new certificate.")
+		}
+	}
+
+	if command, found := cert.CriticalOptions["force-command"]; found {
+		return ok(name, "only the command "+command+" may be run")
+	}
+
+	return ok(name, "none that prevent the login")
+}
+
+func checkRevoked(cert *ssh.Certificate, data []byte) Result {
+	const name = "revocation"
+
+	list, err := krl.Parse(data)
+	if err != nil {
+		return fail(name, "invalid KRL: "+err.Error(), "sshd refuses all logins with an invalid RevokedKeys file, download the KRL again (GET /api/v1/<host>/krl).")
+	}
+
+	if list.IsRevoked(cert) {
+		return fail(name, "revoked", "Request a new certificate, as this one was revoked by the CA.")
+	}
+
+	return ok(name, fmt.Sprintf("not revoked by KRL version %d", list.Version))
+}
@@ -0,0 +1,99 @@
+package doctor
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/krl"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func testSigner(t *testing.T) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	signer, err := ssh.NewSignerFromKey(priv)
+	assert.NoError(t, err)
+
+	return signer
+}
+
+// failed returns the names of the failed checks.
+func failed(results []Result) []string {
+	names := []string{}
+	for _, res := range results {
+		if res.Status == StatusFail {
+			names = append(names, res.Name)
+		}
+	}
+
+	return names
+}
+
+func TestVerifyLogin(t *testing.T) {
+	ca := testSigner(t)
+	user := testSigner(t)
+	now := time.Now()
+
+	cert := &ssh.Certificate{
+		Key:             user.PublicKey(),
+		Serial:          42,
+		CertType:        ssh.UserCert,
+		KeyId:           "alice@issuer",
+		ValidPrincipals: []string{"alice"},
+		ValidAfter:      uint64(now.Add(-time.Minute).Unix()),
+		ValidBefore:     uint64(now.Add(time.Hour).Unix()),
+		Permissions: ssh.Permissions{
+			CriticalOptions: map[string]string{"source-address": "192.0.2.0/24"},
+		},
+	}
+	assert.NoError(t, cert.SignCert(rand.Reader, ca))
+
+	login := Login{
+		Certificate:       ssh.MarshalAuthorizedKey(cert),
+		TrustedUserCAKeys: ssh.MarshalAuthorizedKey(ca.PublicKey()),
+		RevokedKeys:       krl.Generate(ca.PublicKey(), []uint64{1, 2}, 1, ""),
+		User:              "alice",
+		From:              net.ParseIP("192.0.2.10"),
+		Time:              now,
+	}
+
+	assert.Empty(t, failed(VerifyLogin(login)))
+
+	expired := login
+	expired.Time = now.Add(2 * time.Hour)
+	assert.Equal(t, []string{"validity"}, failed(VerifyLogin(expired)))
+
+	wrongUser := login
+	wrongUser.User = "bob"
+	assert.Equal(t, []string{"principal"}, failed(VerifyLogin(wrongUser)))
+
+	principals := wrongUser
+	principals.AuthorizedPrincipals = []byte("# comment\nalice\n")
+	assert.Empty(t, failed(VerifyLogin(principals)))
+
+	revoked := login
+	revoked.RevokedKeys = krl.Generate(ca.PublicKey(), []uint64{42}, 2, "")
+	assert.Equal(t, []string{"revocation"}, failed(VerifyLogin(revoked)))
+
+	untrusted := login
+	untrusted.TrustedUserCAKeys = ssh.MarshalAuthorizedKey(testSigner(t).PublicKey())
+	untrusted.From = net.ParseIP("198.51.100.1")
+	assert.Equal(t, []string{"trusted CA", "critical options"}, failed(VerifyLogin(untrusted)))
+
+	tampered := *cert
+	tampered.ValidPrincipals = []string{"root"}
+	forged := login
+	forged.Certificate = ssh.MarshalAuthorizedKey(&tampered)
+	forged.User = "root"
+	assert.Equal(t, []string{"signature"}, failed(VerifyLogin(forged)))
+
+	plain := login
+	plain.Certificate = ssh.MarshalAuthorizedKey(user.PublicKey())
+	assert.Equal(t, []string{"certificate"}, failed(VerifyLogin(plain)))
+}
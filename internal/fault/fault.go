@@ -0,0 +1,105 @@
+// Package fault injects latency and errors into requests to motley_cue and
+// into signing, so that sites can validate how clients handle a slow or
+// failing CA in staging deployments. It must not be enabled in production.
+package fault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// Operations that faults can be injected into
+	TARGET_UPSTREAM = "upstream"
+	TARGET_SIGN     = "sign"
+
+	ERR_INJECTED       = "injected fault"
+	ERR_UNKNOWN_TARGET = "unknown fault target"
+	ERR_INVALID_RATE   = "fault error rate must be between 0 and 1"
+	ERR_INVALID_DELAY  = "fault latency must not be negative"
+)
+
+// Targets contains all operations that faults can be injected into.
+var Targets = []string{TARGET_UPSTREAM, TARGET_SIGN}
+
+// ErrInjected is returned by Inject, wrapped with the target, if it fails an
+// operation.
+var ErrInjected = errors.New(ERR_INJECTED)
+
+type contextKey struct{}
+
+// Injector delays operations by a random duration of up to Latency and fails
+// them with probability ErrorRate.
+type Injector struct {
+	Latency   time.Duration
+	ErrorRate float64
+	Targets   []string
+}
+
+// New returns an Injector for the comma-separated list of targets, or all
+// targets if empty. nil is returned if neither latency nor errors are
+// configured.
+func New(latency time.Duration, errorRate float64, targets string) (*Injector, error) {
+	if latency < 0 {
+		return nil, errors.New(ERR_INVALID_DELAY)
+	}
+
+	if errorRate < 0 || errorRate > 1 {
+		return nil, errors.New(ERR_INVALID_RATE)
+	}
+
+	injector := &Injector{Latency: latency, ErrorRate: errorRate}
+
+	if targets == "" {
+		injector.Targets = Targets
+	} else {
+		for _, target := range strings.Split(targets, ",") {
+			target = strings.TrimSpace(target)
+			if !slices.Contains(Targets, target) {
+				return nil, errors.New(ERR_UNKNOWN_TARGET + " " + target)
+			}
+
+			injector.Targets = append(injector.Targets, target)
+		}
+	}
+
+	if latency == 0 && errorRate == 0 {
+		return nil, nil
+	}
+
+	return injector, nil
+}
+
+// WithInjector returns a copy of ctx carrying the injector.
+func WithInjector(ctx context.Context, injector *Injector) context.Context {
+	return context.WithValue(ctx, contextKey{}, injector)
+}
+
+// Inject delays and possibly fails the target operation if ctx carries an
+// Injector for it. The delay ends early if ctx is done, returning its error.
+func Inject(ctx context.Context, target string) error {
+	injector, ok := ctx.Value(contextKey{}).(*Injector)
+	if !ok || injector == nil || !slices.Contains(injector.Targets, target) {
+		return nil
+	}
+
+	if injector.Latency > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(rand.Int63n(int64(injector.Latency) + 1))):
+		}
+	}
+
+	if rand.Float64() < injector.ErrorRate {
+		return fmt.Errorf("%w: %s", ErrInjected, target)
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package fault
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	injector, err := New(0, 0, "")
+	assert.NoError(t, err)
+	assert.Nil(t, injector)
+
+	injector, err = New(time.Second, 0, "")
+	assert.NoError(t, err)
+	assert.Equal(t, Targets, injector.Targets)
+
+	injector, err = New(0, 0.5, "sign")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{TARGET_SIGN}, injector.Targets)
+
+	_, err = New(0, 1.5, "")
+	assert.EqualError(t, err, ERR_INVALID_RATE)
+
+	_, err = New(-time.Second, 0, "")
+	assert.EqualError(t, err, ERR_INVALID_DELAY)
+
+	_, err = New(0, 0.5, "sign,storage")
+	assert.EqualError(t, err, ERR_UNKNOWN_TARGET+" storage")
+}
+
+func TestInject(t *testing.T) {
+	assert.NoError(t, Inject(context.Background(), TARGET_SIGN))
+
+	ctx := WithInjector(context.Background(), &Injector{ErrorRate: 1, Targets: []string{TARGET_SIGN}})
+	assert.ErrorIs(t, Inject(ctx, TARGET_SIGN), ErrInjected)
+	assert.NoError(t, Inject(ctx, TARGET_UPSTREAM))
+
+	// Delays end with the context
+	ctx, cancel := context.WithTimeout(WithInjector(context.Background(), &Injector{Latency: time.Hour, Targets: Targets}), 10*time.Millisecond)
+	defer cancel()
+
+	assert.ErrorIs(t, Inject(ctx, TARGET_UPSTREAM), context.DeadlineExceeded)
+}
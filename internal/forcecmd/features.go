@@ -0,0 +1,15 @@
+package forcecmd
+
+const (
+	// Kinds of sessions that the force-command program of a hostgroup
+	// supports. Hostgroups declare them, so clients can warn users before
+	// e.g. SFTP fails on a host.
+	FEATURE_SHELL = "shell" // interactive sessions
+	FEATURE_EXEC  = "exec"  // remote commands, e.g. rsync or git
+	FEATURE_SCP   = "scp"   // scp, both legacy and SFTP-based
+	FEATURE_SFTP  = "sftp"  // the sftp subsystem
+)
+
+// Features contains all force-command features. oinit-switch supports all of
+// them.
+var Features = []string{FEATURE_SHELL, FEATURE_EXEC, FEATURE_SCP, FEATURE_SFTP}
@@ -0,0 +1,219 @@
+// Package forcecmd signs and verifies the content of the force-command
+// critical option of issued certificates.
+//
+// Without a signature, a host can't tell whether the force-command of a
+// certificate was set by the oinit CA or by some other CA that happens to be
+// trusted as well. If a key is shared between CA and host, the CA appends a
+// signed payload to the force-command:
+//
+//	oinit-switch <username>[,<username>...] v1.<payload>.<mac>
+//
+// where the usernames are those the certificate permits logins as, payload is
+// the base64url encoded JSON representation of Payload and mac is the
+// base64url encoded HMAC-SHA256 over the command name, username and encoded
+// payload.
+//
+// If no key is shared but the payload carries a command restriction, it is
+// appended without mac (v1.<payload>). Such payloads are only trusted by
+// hosts without a key, which rely on the certificate signature alone.
+//
+// Hostgroups may configure the program path and arguments preceding the
+// payload as a Template, such as
+//
+//	/opt/oinit/bin/oinit-switch {usernames}
+//
+// for hosts with a non-standard install location.
+package forcecmd
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/memprotect"
+)
+
+const (
+	VERSION = "v1"
+
+	ERR_MALFORMED = "force-command payload is malformed"
+	ERR_SIGNATURE = "force-command signature is invalid"
+	ERR_EXPIRED   = "force-command payload has expired"
+	ERR_EMPTY_KEY = "force-command key is empty"
+
+	// Characters that would allow to chain or substitute commands, as the
+	// command is run by a shell.
+	SHELL_METACHARACTERS = ";&|`$<>(){}\\\n\r"
+)
+
+// Payload is the data signed into the force-command.
+type Payload struct {
+	// Host the certificate was requested for
+	Host string `json:"host"`
+	// Unix timestamps of issuance and expiry
+	IssuedAt  int64 `json:"iat"`
+	ExpiresAt int64 `json:"exp"`
+	// If set, only this command (optionally followed by arguments) may be
+	// run, see Permits
+	Command string `json:"cmd,omitempty"`
+}
+
+// LoadKey reads a shared key from the given file. Leading and trailing
+// whitespace is removed, so keys generated by e.g. 'openssl rand -base64 32'
+// can be used directly.
+func LoadKey(path string) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer memprotect.Wipe(content)
+
+	key := bytes.TrimSpace(content)
+	if len(key) == 0 {
+		return nil, errors.New(ERR_EMPTY_KEY)
+	}
+
+	return bytes.Clone(key), nil
+}
+
+func mac(key []byte, command, username, payload string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(command + "\x00" + username + "\x00" + payload))
+
+	return h.Sum(nil)
+}
+
+func encode(payload Payload) (string, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decode(encoded string) (Payload, error) {
+	var payload Payload
+
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || json.Unmarshal(data, &payload) != nil {
+		return payload, errors.New(ERR_MALFORMED)
+	}
+
+	return payload, nil
+}
+
+// Encode returns the force-command for the given command name, username and
+// payload without signature.
+func Encode(command, username string, payload Payload) (string, error) {
+	arg, err := EncodeArgument(payload)
+	if err != nil {
+		return "", err
+	}
+
+	return command + " " + username + " " + arg, nil
+}
+
+// EncodeArgument returns the unsigned argument for the given payload, which
+// is appended to a rendered Template.
+func EncodeArgument(payload Payload) (string, error) {
+	encoded, err := encode(payload)
+	if err != nil {
+		return "", err
+	}
+
+	return VERSION + "." + encoded, nil
+}
+
+// Parse returns the payload of the given signed or unsigned argument of a
+// force-command without verifying the signature.
+func Parse(arg string) (Payload, error) {
+	parts := strings.Split(arg, ".")
+	if (len(parts) != 2 && len(parts) != 3) || parts[0] != VERSION {
+		return Payload{}, errors.New(ERR_MALFORMED)
+	}
+
+	return decode(parts[1])
+}
+
+// Sign returns the signed force-command for the given command name (such as
+// oinit-switch), username and payload.
+func Sign(key []byte, command, username string, payload Payload) (string, error) {
+	arg, err := SignArgument(key, command, username, payload)
+	if err != nil {
+		return "", err
+	}
+
+	return command + " " + username + " " + arg, nil
+}
+
+// SignArgument returns the signed argument for the given command name,
+// username and payload, which is appended to a rendered Template. The
+// signature covers the command name rather than the path the program is
+// installed at.
+func SignArgument(key []byte, command, username string, payload Payload) (string, error) {
+	encoded, err := encode(payload)
+	if err != nil {
+		return "", err
+	}
+
+	sig := base64.RawURLEncoding.EncodeToString(mac(key, command, username, encoded))
+
+	return VERSION + "." + encoded + "." + sig, nil
+}
+
+// Verify checks the signed argument (the part following the username) of a
+// force-command and returns the contained payload if the signature is valid
+// and the payload has not expired.
+func Verify(key []byte, command, username, signed string) (Payload, error) {
+	var payload Payload
+
+	parts := strings.Split(signed, ".")
+	if len(parts) != 3 || parts[0] != VERSION {
+		return payload, errors.New(ERR_MALFORMED)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return payload, errors.New(ERR_MALFORMED)
+	}
+
+	if !hmac.Equal(sig, mac(key, command, username, parts[1])) {
+		return payload, errors.New(ERR_SIGNATURE)
+	}
+
+	payload, err = decode(parts[1])
+	if err != nil {
+		return payload, err
+	}
+
+	if payload.ExpiresAt != 0 && time.Now().Unix() >= payload.ExpiresAt {
+		return payload, errors.New(ERR_EXPIRED)
+	}
+
+	return payload, nil
+}
+
+// ValidCommand reports whether the given command can be used as restriction,
+// meaning it is not empty and contains no shell metacharacters.
+func ValidCommand(command string) bool {
+	return strings.TrimSpace(command) != "" && !strings.ContainsAny(command, SHELL_METACHARACTERS)
+}
+
+// Permits reports whether the command requested by the client (such as
+// $SSH_ORIGINAL_COMMAND) is allowed by the restriction. The requested command
+// must be the restriction itself or start with it followed by arguments, and
+// must not contain shell metacharacters.
+func Permits(restriction, requested string) bool {
+	if !ValidCommand(restriction) || strings.ContainsAny(requested, SHELL_METACHARACTERS) {
+		return false
+	}
+
+	return requested == restriction || strings.HasPrefix(requested, restriction+" ")
+}
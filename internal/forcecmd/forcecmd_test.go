@@ -0,0 +1,311 @@
+package forcecmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSignVerify(t *testing.T) {
+	key := []byte("secret")
+	payload := Payload{
+		Host:      "login.example.com",
+		IssuedAt:  time.Now().Unix(),
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	}
+
+	command, err := Sign(key, "oinit-switch", "alice", payload)
+	assert.NoError(t, err)
+
+	argv := strings.Fields(command)
+	assert.Len(t, argv, 3)
+	assert.Equal(t, "oinit-switch", argv[0])
+	assert.Equal(t, "alice", argv[1])
+
+	verified, err := Verify(key, "oinit-switch", "alice", argv[2])
+	assert.NoError(t, err)
+	assert.Equal(t, payload, verified)
+
+	_, err = Verify(key, "oinit-switch", "bob", argv[2])
+	assert.EqualError(t, err, ERR_SIGNATURE)
+
+	_, err = Verify([]byte("other"), "oinit-switch", "alice", argv[2])
+	assert.EqualError(t, err, ERR_SIGNATURE)
+
+	_, err = Verify(key, "oinit-switch", "alice", "v1.garbage")
+	assert.EqualError(t, err, ERR_MALFORMED)
+}
+
+func TestVerifyExpired(t *testing.T) {
+	key := []byte("secret")
+
+	command, err := Sign(key, "oinit-switch", "alice", Payload{
+		ExpiresAt: time.Now().Add(-time.Minute).Unix(),
+	})
+	assert.NoError(t, err)
+
+	_, err = Verify(key, "oinit-switch", "alice", strings.Fields(command)[2])
+	assert.EqualError(t, err, ERR_EXPIRED)
+}
+
+func TestParse(t *testing.T) {
+	payload := Payload{Host: "login.example.com", Command: "rsync --server"}
+
+	command, err := Encode("oinit-switch", "alice", payload)
+	assert.NoError(t, err)
+
+	parsed, err := Parse(strings.Fields(command)[2])
+	assert.NoError(t, err)
+	assert.Equal(t, payload, parsed)
+
+	// Unsigned payloads are rejected by hosts with a key
+	_, err = Verify([]byte("secret"), "oinit-switch", "alice", strings.Fields(command)[2])
+	assert.EqualError(t, err, ERR_MALFORMED)
+
+	signed, err := Sign([]byte("secret"), "oinit-switch", "alice", payload)
+	assert.NoError(t, err)
+
+	parsed, err = Parse(strings.Fields(signed)[2])
+	assert.NoError(t, err)
+	assert.Equal(t, payload, parsed)
+}
+
+func TestPermits(t *testing.T) {
+	assert.True(t, Permits("rsync --server", "rsync --server"))
+	assert.True(t, Permits("rsync --server", "rsync --server -vlogDtpre.iLsfxCIvu . /data"))
+	assert.False(t, Permits("rsync --server", "rsync --server-other"))
+	assert.False(t, Permits("rsync --server", "rsync --server . /data; rm -rf ~"))
+	assert.False(t, Permits("rsync --server", "rsync --server $(id)"))
+	assert.False(t, Permits("rsync --server", "bash"))
+	assert.False(t, Permits("", "bash"))
+}
+
+func TestTemplate(t *testing.T) {
+	template, err := ParseTemplate("/opt/oinit/bin/oinit-switch  {usernames} --provider={provider}")
+	assert.NoError(t, err)
+
+	command, err := template.Render(map[string]string{
+		VAR_USERNAMES: "alice,project",
+		VAR_PROVIDER:  "https://login.example.com",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "/opt/oinit/bin/oinit-switch alice,project --provider=https://login.example.com", command)
+
+	_, err = template.Render(map[string]string{VAR_USERNAMES: "alice;reboot"})
+	assert.EqualError(t, err, ERR_TEMPLATE_VALUE)
+
+	command, err = Template{}.Render(map[string]string{VAR_USERNAMES: "alice"})
+	assert.NoError(t, err)
+	assert.Equal(t, "oinit-switch alice", command)
+
+	for template, expected := range map[string]string{
+		"":                            ERR_TEMPLATE_EMPTY,
+		"bin/oinit-switch {username}": ERR_TEMPLATE_PROGRAM,
+		"oinit-switch {uid}":          ERR_TEMPLATE_VARIABLE + " {uid}",
+		"oinit-switch {username}; id": ERR_TEMPLATE_CHARS,
+	} {
+		_, err := ParseTemplate(template)
+		assert.EqualError(t, err, expected, template)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	assert.Equal(t, FEATURE_SFTP, Classify("/usr/lib/openssh/sftp-server"))
+	assert.Equal(t, FEATURE_SFTP, Classify("internal-sftp -f AUTHPRIV"))
+	assert.Equal(t, FEATURE_SCP, Classify("scp -t /data"))
+	assert.Equal(t, FEATURE_SCP, Classify("scp -v -r -f data"))
+	assert.Equal(t, FEATURE_EXEC, Classify("scp data other"))
+	assert.Equal(t, FEATURE_EXEC, Classify("rsync --server -vlogDtpre.iLsfxCIvu . /data"))
+	assert.Equal(t, FEATURE_EXEC, Classify("git-upload-pack 'repo.git'"))
+}
+
+func TestDispatch(t *testing.T) {
+	dir := t.TempDir()
+	server := filepath.Join(dir, "sftp-server")
+	assert.NoError(t, os.WriteFile(server, nil, 0755))
+
+	defer func(paths []string) { SFTPServerPaths = paths }(SFTPServerPaths)
+	SFTPServerPaths = []string{filepath.Join(dir, "missing"), server}
+
+	command, err := Dispatch("internal-sftp -f AUTHPRIV -l INFO")
+	assert.NoError(t, err)
+	assert.Equal(t, server+" -f AUTHPRIV -l INFO", command)
+
+	command, err = Dispatch("rsync --server . /data")
+	assert.NoError(t, err)
+	assert.Equal(t, "rsync --server . /data", command)
+
+	SFTPServerPaths = []string{filepath.Join(dir, "missing")}
+
+	_, err = Dispatch("internal-sftp")
+	assert.EqualError(t, err, ERR_NO_SFTP_SERVER)
+}
+
+func TestLoadAllowedSessions(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "sessions")
+
+	assert.NoError(t, os.WriteFile(file, []byte("# file transfers only\nscp, SFTP\n"), 0644))
+	allowed, err := LoadAllowedSessions(file)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{FEATURE_SCP, FEATURE_SFTP}, allowed)
+
+	assert.NoError(t, os.WriteFile(file, []byte("shell rsync"), 0644))
+	_, err = LoadAllowedSessions(file)
+	assert.EqualError(t, err, ERR_UNKNOWN_FEATURE+"rsync")
+
+	assert.NoError(t, os.WriteFile(file, []byte("# nothing\n"), 0644))
+	_, err = LoadAllowedSessions(file)
+	assert.EqualError(t, err, ERR_SESSIONS_EMPTY)
+}
+
+func FuzzParse(f *testing.F) {
+	command, _ := Encode("oinit-switch", "alice", Payload{Host: "login.example.com", Command: "rsync --server"})
+	signed, _ := Sign([]byte("secret"), "oinit-switch", "alice", Payload{Host: "login.example.com"})
+
+	f.Add(strings.Fields(command)[2])
+	f.Add(strings.Fields(signed)[2])
+	f.Add("v1..")
+	f.Add("v1.e30")
+
+	f.Fuzz(func(t *testing.T, arg string) {
+		payload, err := Parse(arg)
+		if err != nil {
+			return
+		}
+
+		// Payloads survive a round trip
+		command, err := Encode("oinit-switch", "alice", payload)
+		assert.NoError(t, err)
+
+		parsed, err := Parse(strings.Fields(command)[2])
+		assert.NoError(t, err)
+		assert.Equal(t, payload, parsed)
+	})
+}
+
+func FuzzVerify(f *testing.F) {
+	signed, _ := Sign([]byte("secret"), "oinit-switch", "alice", Payload{Host: "login.example.com"})
+
+	f.Add([]byte("secret"), "alice", strings.Fields(signed)[2])
+	f.Add([]byte("secret"), "bob", strings.Fields(signed)[2])
+	f.Add([]byte{}, "alice", "v1.e30.")
+
+	f.Fuzz(func(t *testing.T, key []byte, username, arg string) {
+		payload, err := Verify(key, "oinit-switch", username, arg)
+		if err != nil {
+			return
+		}
+
+		// Only correctly signed payloads are accepted, so they are also
+		// parsable without verification.
+		parsed, err := Parse(arg)
+		assert.NoError(t, err)
+		assert.Equal(t, payload, parsed)
+	})
+}
+
+func FuzzPermits(f *testing.F) {
+	f.Add("rsync --server", "rsync --server -vlogDtpre.iLsfxCIvu . /data")
+	f.Add("rsync --server", "rsync --server; sh")
+	f.Add("", "")
+
+	f.Fuzz(func(t *testing.T, restriction, requested string) {
+		if !Permits(restriction, requested) {
+			return
+		}
+
+		assert.True(t, strings.HasPrefix(requested, restriction))
+		assert.False(t, strings.ContainsAny(requested, SHELL_METACHARACTERS))
+		assert.False(t, strings.ContainsAny(restriction, SHELL_METACHARACTERS))
+	})
+}
+
+func TestViolations(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "forbid")
+
+	assert.NoError(t, os.WriteFile(file, []byte("permit-X11-forwarding\npermit-agent-forwarding\n"), 0644))
+	forbidden, err := LoadForbiddenExtensions(file)
+	assert.NoError(t, err)
+
+	cert := &ssh.Certificate{Permissions: ssh.Permissions{Extensions: map[string]string{
+		"permit-pty":              "",
+		"permit-agent-forwarding": "",
+		"permit-X11-forwarding":   "",
+	}}}
+	assert.Equal(t, []string{"permit-X11-forwarding", "permit-agent-forwarding"}, Violations(cert, forbidden))
+
+	delete(cert.Extensions, "permit-X11-forwarding")
+	delete(cert.Extensions, "permit-agent-forwarding")
+	assert.Empty(t, Violations(cert, forbidden))
+
+	assert.NoError(t, os.WriteFile(file, []byte("x11-forwarding"), 0644))
+	_, err = LoadForbiddenExtensions(file)
+	assert.EqualError(t, err, ERR_UNKNOWN_EXTENSION+"x11-forwarding")
+}
+
+func TestRecorder(t *testing.T) {
+	recorder, err := ParseRecorder("/usr/bin/script -q -f -c {command} /var/log/oinit/{user}-{serial}.log")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"/usr/bin/script", "-q", "-f", "-c", "/bin/su - alice -P", "/var/log/oinit/alice-42.log",
+	}, recorder.Argv(map[string]string{
+		VAR_COMMAND: "/bin/su - alice -P",
+		VAR_USER:    "alice",
+		VAR_SERIAL:  "42",
+	}))
+
+	_, err = ParseRecorder("script -c {command}")
+	assert.EqualError(t, err, ERR_RECORDER_PROGRAM)
+
+	_, err = ParseRecorder("/usr/bin/tlog-rec --writer=journal")
+	assert.EqualError(t, err, ERR_RECORDER_COMMAND)
+
+	_, err = ParseRecorder("/usr/bin/script -c {command} {host}.log")
+	assert.EqualError(t, err, ERR_RECORDER_VARIABLE+" {host}")
+
+	file := filepath.Join(t.TempDir(), "record")
+	assert.NoError(t, os.WriteFile(file, []byte("# tlog\n\n/usr/bin/tlog-rec /bin/sh -c {command}\n"), 0644))
+	recorder, err = LoadRecorder(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "/usr/bin/tlog-rec", recorder.Argv(nil)[0])
+}
+
+func TestVerifyHost(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "hosts")
+
+	assert.NoError(t, os.WriteFile(file, []byte("# names of this host\nLogin.example.com.\n  login-alias.example.com\n\nnode01\n"), 0644))
+	names, err := LoadHostNames(file)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"login.example.com", "login-alias.example.com", "node01"}, names)
+
+	tests := []struct {
+		host string
+		err  error
+	}{
+		{"login.example.com", nil},
+		{"LOGIN.example.com.", nil},
+		{"login-alias.example.com", nil},
+		{"node01", nil},
+		// Short names don't match fully qualified ones
+		{"node01.example.com", ErrWrongHost},
+		{"other.example.com", ErrWrongHost},
+		{"login.example.org", ErrWrongHost},
+		{"example.com", ErrWrongHost},
+		{"", ErrWrongHost},
+	}
+
+	for _, test := range tests {
+		err := VerifyHost(Payload{Host: test.host}, names)
+		assert.Equal(t, test.err, err, test.host)
+	}
+
+	assert.NoError(t, os.WriteFile(file, []byte("# nothing\n"), 0644))
+	_, err = LoadHostNames(file)
+	assert.EqualError(t, err, ERR_HOST_NAMES_EMPTY)
+}
@@ -0,0 +1,81 @@
+package forcecmd
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+const (
+	ERR_WRONG_HOST       = "force-command was issued for another host"
+	ERR_HOST_NAMES_EMPTY = "no host names configured"
+)
+
+var ErrWrongHost = errors.New(ERR_WRONG_HOST)
+
+// normalizeHost lowercases a host name and removes the trailing dot of fully
+// qualified names.
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// LoadHostNames reads the names a host is reached under, i.e. the names
+// certificates are requested for, from the given file. This is the hosts file
+// of oinit-enroll-host and oinit-principals, which lists one name per line.
+// Names are compared case-insensitively, lines starting with # are ignored.
+func LoadHostNames(file string) ([]string, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		// Like the shell scripts, split lines at whitespace.
+		for _, name := range strings.Fields(line) {
+			names = append(names, normalizeHost(name))
+		}
+	}
+
+	if len(names) == 0 {
+		return nil, errors.New(ERR_HOST_NAMES_EMPTY)
+	}
+
+	return names, nil
+}
+
+// SystemHostNames returns the fully qualified host name of the system, like
+// 'hostname -f'. If it can't be qualified, the plain host name is returned.
+func SystemHostNames() ([]string, error) {
+	name, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.Contains(name, ".") {
+		if canonical, err := net.LookupCNAME(name); err == nil && strings.Contains(strings.TrimSuffix(canonical, "."), ".") {
+			name = canonical
+		}
+	}
+
+	return []string{normalizeHost(name)}, nil
+}
+
+// VerifyHost returns ErrWrongHost unless the payload was issued for one of the
+// given names, so that certificates obtained for other hosts of the same CA
+// are refused. Payloads without a host are refused, too.
+func VerifyHost(payload Payload, names []string) error {
+	host := normalizeHost(payload.Host)
+	if host == "" || !slices.Contains(names, host) {
+		return ErrWrongHost
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package forcecmd
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// Prefix of the certificate extensions that permit features of a
+	// session, such as permit-X11-forwarding, see PROTOCOL.certkeys
+	EXTENSION_PREFIX = "permit-"
+
+	ERR_UNKNOWN_EXTENSION = "unknown certificate extension "
+)
+
+// LoadForbiddenExtensions reads the certificate extensions that site policy
+// forbids on a host, such as permit-X11-forwarding, from the given file. They
+// are separated by commas or whitespace and compared case-insensitively.
+// Lines starting with # are ignored.
+func LoadForbiddenExtensions(file string) ([]string, error) {
+	forbidden, err := readList(file)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, extension := range forbidden {
+		if !strings.HasPrefix(extension, EXTENSION_PREFIX) || extension == EXTENSION_PREFIX {
+			return nil, errors.New(ERR_UNKNOWN_EXTENSION + extension)
+		}
+	}
+
+	return forbidden, nil
+}
+
+// Violations returns the extensions of the certificate that are forbidden,
+// sorted by name. Certificates of a misconfigured CA may permit features that
+// sshd would grant although site policy forbids them.
+func Violations(cert *ssh.Certificate, forbidden []string) []string {
+	violations := []string{}
+
+	for extension := range cert.Permissions.Extensions {
+		for _, f := range forbidden {
+			if strings.EqualFold(extension, f) {
+				violations = append(violations, extension)
+				break
+			}
+		}
+	}
+
+	sort.Strings(violations)
+
+	return violations
+}
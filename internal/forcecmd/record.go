@@ -0,0 +1,96 @@
+package forcecmd
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// Variables of recorder commands
+	VAR_COMMAND = "command" // shell command starting the session, as one argument
+	VAR_USER    = "user"    // account the session runs as
+	VAR_SERIAL  = "serial"  // serial number of the certificate, or "unknown"
+
+	ERR_RECORDER_EMPTY    = "recorder command is empty"
+	ERR_RECORDER_PROGRAM  = "recorder command must start with an absolute path"
+	ERR_RECORDER_VARIABLE = "recorder command contains unknown variable"
+	ERR_RECORDER_COMMAND  = "recorder command must contain {" + VAR_COMMAND + "}"
+)
+
+var recorderVariables = []string{VAR_COMMAND, VAR_USER, VAR_SERIAL}
+
+// Recorder is a command that interactive sessions are wrapped with to record
+// them, such as
+//
+//	/usr/bin/script -q -f -c {command} /var/log/oinit/{user}-{serial}.log
+//	/usr/bin/tlog-rec --writer=journal /bin/sh -c {command}
+//
+// It is run without shell, each variable is replaced within its argument.
+type Recorder struct {
+	fields []string
+}
+
+// LoadRecorder reads the recorder command from the first line of the given
+// file that is neither empty nor starts with #. The program must be an
+// absolute path, and {command} must be passed to it.
+func LoadRecorder(file string) (Recorder, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return Recorder{}, err
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			return ParseRecorder(line)
+		}
+	}
+
+	return Recorder{}, errors.New(ERR_RECORDER_EMPTY)
+}
+
+// ParseRecorder parses and validates the given recorder command.
+func ParseRecorder(command string) (Recorder, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return Recorder{}, errors.New(ERR_RECORDER_EMPTY)
+	}
+
+	if !path.IsAbs(fields[0]) {
+		return Recorder{}, errors.New(ERR_RECORDER_PROGRAM)
+	}
+
+	hasCommand := false
+	for _, field := range fields[1:] {
+		for _, match := range templateVariable.FindAllStringSubmatch(field, -1) {
+			if !slices.Contains(recorderVariables, match[1]) {
+				return Recorder{}, errors.New(ERR_RECORDER_VARIABLE + " {" + match[1] + "}")
+			}
+
+			hasCommand = hasCommand || match[1] == VAR_COMMAND
+		}
+	}
+
+	if !hasCommand {
+		return Recorder{}, errors.New(ERR_RECORDER_COMMAND)
+	}
+
+	return Recorder{fields: fields}, nil
+}
+
+// Argv returns the program and arguments of the recorder with all variables
+// replaced by their values.
+func (r Recorder) Argv(vars map[string]string) []string {
+	argv := make([]string, len(r.fields))
+	for i, field := range r.fields {
+		argv[i] = templateVariable.ReplaceAllStringFunc(field, func(variable string) string {
+			return vars[strings.Trim(variable, "{}")]
+		})
+	}
+
+	return argv
+}
@@ -0,0 +1,128 @@
+package forcecmd
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// sshd passes the command of the requested subsystem on to the
+	// force-command in SSH_ORIGINAL_COMMAND, which is "internal-sftp" if sshd
+	// serves SFTP itself. Only sshd can run it, so it is replaced with the
+	// sftp-server binary.
+	INTERNAL_SFTP = "internal-sftp"
+	SFTP_SERVER   = "sftp-server"
+
+	ERR_NO_SFTP_SERVER  = "sftp-server is not installed"
+	ERR_UNKNOWN_FEATURE = "unknown session kind "
+	ERR_SESSIONS_EMPTY  = "no session kinds are allowed"
+)
+
+// SFTPServerPaths are the locations of sftp-server on common distributions,
+// in the order they are tried.
+var SFTPServerPaths = []string{
+	"/usr/lib/openssh/sftp-server",     // Debian, Ubuntu
+	"/usr/libexec/openssh/sftp-server", // RHEL, Fedora, SUSE
+	"/usr/lib/ssh/sftp-server",         // Arch, Alpine
+	"/usr/libexec/sftp-server",         // BSDs, macOS
+}
+
+// Classify returns the feature that the command requested by the client
+// (such as $SSH_ORIGINAL_COMMAND) requires: FEATURE_SFTP for the sftp
+// subsystem, FEATURE_SCP for scp in source or sink mode, and FEATURE_EXEC for
+// all other commands, including rsync. Sessions without a command require
+// FEATURE_SHELL.
+func Classify(requested string) string {
+	fields := strings.Fields(requested)
+	if len(fields) == 0 {
+		return FEATURE_EXEC
+	}
+
+	switch program := path.Base(fields[0]); program {
+	case INTERNAL_SFTP, SFTP_SERVER:
+		return FEATURE_SFTP
+	case "scp":
+		// Legacy scp runs 'scp -t' (to) or 'scp -f' (from) on the server,
+		// scp using SFTP requests the sftp subsystem instead.
+		for _, arg := range fields[1:] {
+			if !strings.HasPrefix(arg, "-") || arg == "--" {
+				break
+			}
+
+			if strings.ContainsAny(arg, "tf") {
+				return FEATURE_SCP
+			}
+		}
+	}
+
+	return FEATURE_EXEC
+}
+
+// Dispatch returns the command to run for the requested command. The
+// internal-sftp subsystem is replaced with the first sftp-server binary found
+// in SFTPServerPaths, keeping its arguments, as they are the same. All other
+// commands are returned unchanged.
+func Dispatch(requested string) (string, error) {
+	fields := strings.Fields(requested)
+	if len(fields) == 0 || fields[0] != INTERNAL_SFTP {
+		return requested, nil
+	}
+
+	for _, server := range SFTPServerPaths {
+		if info, err := os.Stat(server); err == nil && !info.IsDir() {
+			return strings.Join(append([]string{server}, fields[1:]...), " "), nil
+		}
+	}
+
+	return "", errors.New(ERR_NO_SFTP_SERVER)
+}
+
+// readList reads a list of lowercase values separated by commas or
+// whitespace from the given file. Lines starting with # are ignored.
+func readList(file string) ([]string, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	values := []string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		for _, value := range strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\r'
+		}) {
+			values = append(values, strings.ToLower(value))
+		}
+	}
+
+	return values, nil
+}
+
+// LoadAllowedSessions reads the kinds of sessions allowed on a host from the
+// given file, which contains features separated by commas or whitespace.
+// Lines starting with # are ignored.
+func LoadAllowedSessions(file string) ([]string, error) {
+	allowed, err := readList(file)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, feature := range allowed {
+		if !slices.Contains(Features, feature) {
+			return nil, errors.New(ERR_UNKNOWN_FEATURE + feature)
+		}
+	}
+
+	if len(allowed) == 0 {
+		return nil, errors.New(ERR_SESSIONS_EMPTY)
+	}
+
+	return allowed, nil
+}
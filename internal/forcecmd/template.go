@@ -0,0 +1,113 @@
+package forcecmd
+
+import (
+	"errors"
+	"path"
+	"regexp"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// Name of the force-command program, which is also the command name
+	// signed payloads are bound to regardless of where it is installed
+	COMMAND = "oinit-switch"
+
+	// Template used if a hostgroup doesn't configure a force-command
+	DEFAULT_TEMPLATE = COMMAND + " {usernames}"
+
+	// Variables of force-command templates
+	VAR_USERNAMES = "usernames" // comma-separated usernames of the certificate
+	VAR_USERNAME  = "username"  // first of these usernames
+	VAR_PROVIDER  = "provider"  // issuer URL of the access token
+	VAR_TOKEN     = "token"     // hash of the access token, see tokenbind.Hash
+	VAR_HOST      = "host"      // host the certificate was requested for
+
+	ERR_TEMPLATE_EMPTY    = "force-command template is empty"
+	ERR_TEMPLATE_PROGRAM  = "force-command template must start with an absolute path or " + COMMAND
+	ERR_TEMPLATE_VARIABLE = "force-command template contains unknown variable"
+	ERR_TEMPLATE_CHARS    = "force-command template contains shell metacharacters"
+	ERR_TEMPLATE_VALUE    = "force-command variable contains whitespace or shell metacharacters"
+)
+
+var (
+	templateVariable  = regexp.MustCompile(`\{([a-z]*)\}`)
+	templateVariables = []string{VAR_USERNAMES, VAR_USERNAME, VAR_PROVIDER, VAR_TOKEN, VAR_HOST}
+)
+
+// Template is a force-command consisting of the path of a program followed
+// by arguments, which may contain variables such as {usernames}. The signed
+// or unsigned payload is appended as last argument.
+type Template struct {
+	fields []string
+}
+
+// ParseTemplate parses and validates the given force-command template. The
+// program must be oinit-switch or an absolute path, and only known variables
+// may be used. Braces of variables are the only shell metacharacters allowed.
+func ParseTemplate(template string) (Template, error) {
+	fields := strings.Fields(template)
+	if len(fields) == 0 {
+		return Template{}, errors.New(ERR_TEMPLATE_EMPTY)
+	}
+
+	if fields[0] != COMMAND && !path.IsAbs(fields[0]) {
+		return Template{}, errors.New(ERR_TEMPLATE_PROGRAM)
+	}
+
+	for _, field := range fields {
+		for _, match := range templateVariable.FindAllStringSubmatch(field, -1) {
+			if !slices.Contains(templateVariables, match[1]) {
+				return Template{}, errors.New(ERR_TEMPLATE_VARIABLE + " {" + match[1] + "}")
+			}
+		}
+
+		if strings.ContainsAny(templateVariable.ReplaceAllString(field, ""), SHELL_METACHARACTERS) {
+			return Template{}, errors.New(ERR_TEMPLATE_CHARS)
+		}
+	}
+
+	return Template{fields: fields}, nil
+}
+
+// Program returns the program that the force-command runs, which is
+// COMMAND for a zero Template.
+func (t Template) Program() string {
+	if len(t.fields) == 0 {
+		return COMMAND
+	}
+
+	return t.fields[0]
+}
+
+// Render returns the force-command with all variables replaced by their
+// values. As the force-command is run by a shell, values must neither contain
+// whitespace nor shell metacharacters. A zero Template renders
+// DEFAULT_TEMPLATE.
+func (t Template) Render(vars map[string]string) (string, error) {
+	fields := t.fields
+	if len(fields) == 0 {
+		fields = strings.Fields(DEFAULT_TEMPLATE)
+	}
+
+	var err error
+
+	rendered := make([]string, len(fields))
+	for i, field := range fields {
+		rendered[i] = templateVariable.ReplaceAllStringFunc(field, func(variable string) string {
+			value := vars[strings.Trim(variable, "{}")]
+			if strings.ContainsAny(value, SHELL_METACHARACTERS+" \t") {
+				err = errors.New(ERR_TEMPLATE_VALUE)
+			}
+
+			return value
+		})
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join(rendered, " "), nil
+}
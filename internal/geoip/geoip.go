@@ -0,0 +1,386 @@
+// Package geoip looks up the country and autonomous system (ASN) of IP
+// addresses in MaxMind DB files, such as GeoLite2-Country and GeoLite2-ASN,
+// see https://maxmind.github.io/MaxMind-DB/.
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+)
+
+const (
+	ERR_INVALID_DATABASE = "invalid MaxMind DB"
+	ERR_NOT_FOUND        = "address not found"
+
+	// Size of the zero bytes separating the search tree and the data
+	dataSectionSeparator = 16
+
+	// Metadata is located within the last 128 KiB of the file
+	maxMetadataSize = 128 * 1024
+
+	typeExtended = 0
+	typePointer  = 1
+	typeString   = 2
+	typeDouble   = 3
+	typeBytes    = 4
+	typeUint16   = 5
+	typeUint32   = 6
+	typeMap      = 7
+	typeInt32    = 8
+	typeUint64   = 9
+	typeUint128  = 10
+	typeArray    = 11
+	typeBool     = 14
+	typeFloat    = 15
+)
+
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// ErrNotFound is returned, wrapped with the address, if a database contains
+// no record of an address.
+var ErrNotFound = errors.New(ERR_NOT_FOUND)
+
+// Reader reads a MaxMind DB file, which is held in memory.
+type Reader struct {
+	// Type of the database, e.g. GeoLite2-Country
+	DatabaseType string
+
+	tree       []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	// Node that IPv4 lookups start at in IPv6 databases
+	ipv4Start uint
+}
+
+// Info is the country and autonomous system of an address. Fields are empty
+// if the database doesn't contain them.
+type Info struct {
+	// ISO 3166-1 alpha-2 code, e.g. "DE"
+	Country string `json:"country,omitempty"`
+	// Autonomous system number, 0 if unknown
+	ASN          uint   `json:"asn,omitempty"`
+	Organization string `json:"organization,omitempty"`
+}
+
+// Open reads the MaxMind DB file at path.
+func Open(path string) (*Reader, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(data)
+}
+
+// New parses a MaxMind DB.
+func New(db []byte) (*Reader, error) {
+	start := len(db) - maxMetadataSize
+	if start < 0 {
+		start = 0
+	}
+
+	marker := bytes.LastIndex(db[start:], metadataMarker)
+	if marker < 0 {
+		return nil, errors.New(ERR_INVALID_DATABASE)
+	}
+	metaStart := start + marker + len(metadataMarker)
+
+	meta, _, err := decoder{db[metaStart:]}.decode(0)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, ok := meta.(map[string]interface{})
+	if !ok {
+		return nil, errors.New(ERR_INVALID_DATABASE)
+	}
+
+	uintField := func(name string) uint {
+		v, _ := metadata[name].(uint64)
+		return uint(v)
+	}
+
+	r := &Reader{
+		nodeCount:  uintField("node_count"),
+		recordSize: uintField("record_size"),
+		ipVersion:  uintField("ip_version"),
+	}
+	r.DatabaseType, _ = metadata["database_type"].(string)
+
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, errors.New(ERR_INVALID_DATABASE)
+	}
+
+	treeSize := r.nodeCount * r.recordSize / 4
+	if treeSize+dataSectionSeparator > uint(start+marker) {
+		return nil, errors.New(ERR_INVALID_DATABASE)
+	}
+
+	r.tree = db[:treeSize]
+	r.data = db[treeSize+dataSectionSeparator : start+marker]
+
+	if r.ipVersion == 6 {
+		// IPv4 addresses are stored as ::a.b.c.d
+		for i := 0; i < 96 && r.ipv4Start < r.nodeCount; i++ {
+			r.ipv4Start = r.record(r.ipv4Start, 0)
+		}
+	}
+
+	return r, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of the node.
+func (r *Reader) record(node, bit uint) uint {
+	switch r.recordSize {
+	case 24:
+		b := r.tree[node*6+bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := r.tree[node*7:]
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(r.tree[node*8+bit*4:]))
+	}
+}
+
+// lookup returns the record of the address.
+func (r *Reader) lookup(ip net.IP) (interface{}, error) {
+	node := uint(0)
+
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		node = r.ipv4Start
+	} else if r.ipVersion == 4 {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, ip)
+	}
+
+	for i := 0; i < len(ip)*8 && node < r.nodeCount; i++ {
+		bit := uint(ip[i/8]>>(7-i%8)) & 1
+		node = r.record(node, bit)
+	}
+
+	if node == r.nodeCount {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, ip)
+	}
+
+	if node < r.nodeCount {
+		return nil, errors.New(ERR_INVALID_DATABASE)
+	}
+
+	offset := node - r.nodeCount - dataSectionSeparator
+	if offset >= uint(len(r.data)) {
+		return nil, errors.New(ERR_INVALID_DATABASE)
+	}
+
+	value, _, err := decoder{r.data}.decode(offset)
+
+	return value, err
+}
+
+// Lookup returns the country and autonomous system of the address, as far
+// as contained in the database.
+func (r *Reader) Lookup(ip net.IP) (Info, error) {
+	var info Info
+
+	value, err := r.lookup(ip)
+	if err != nil {
+		return info, err
+	}
+
+	record, _ := value.(map[string]interface{})
+
+	if country, ok := record["country"].(map[string]interface{}); ok {
+		info.Country, _ = country["iso_code"].(string)
+	}
+
+	asn, _ := record["autonomous_system_number"].(uint64)
+	info.ASN = uint(asn)
+	info.Organization, _ = record["autonomous_system_organization"].(string)
+
+	return info, nil
+}
+
+// decoder decodes values of the data section.
+type decoder struct {
+	b []byte
+}
+
+func (d decoder) bytes(offset, n uint) ([]byte, error) {
+	if offset+n > uint(len(d.b)) || offset+n < offset {
+		return nil, errors.New(ERR_INVALID_DATABASE)
+	}
+
+	return d.b[offset : offset+n], nil
+}
+
+func (d decoder) uint(offset, n uint) (uint64, error) {
+	b, err := d.bytes(offset, n)
+	if err != nil || n > 8 {
+		return 0, errors.New(ERR_INVALID_DATABASE)
+	}
+
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+
+	return v, nil
+}
+
+// decode returns the value at offset and the offset after it. Maps are
+// returned as map[string]interface{}, arrays as []interface{} and all
+// unsigned integers as uint64.
+func (d decoder) decode(offset uint) (interface{}, uint, error) {
+	ctrl, err := d.uint(offset, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset++
+
+	typ := uint(ctrl >> 5)
+
+	if typ == typePointer {
+		ss := uint(ctrl>>3) & 3
+		v, err := d.uint(offset, ss+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		offset += ss + 1
+
+		var target uint64
+		switch ss {
+		case 0:
+			target = (ctrl&7)<<8 | v
+		case 1:
+			target = ((ctrl&7)<<16 | v) + 2048
+		case 2:
+			target = ((ctrl&7)<<24 | v) + 526336
+		default:
+			target = v
+		}
+
+		value, _, err := d.decode(uint(target))
+
+		return value, offset, err
+	}
+
+	if typ == typeExtended {
+		ext, err := d.uint(offset, 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		offset++
+		typ = 7 + uint(ext)
+	}
+
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		v, err := d.uint(offset, n)
+		if err != nil {
+			return nil, 0, err
+		}
+		offset += n
+
+		size = []uint{29, 285, 65821}[n-1] + uint(v)
+	}
+
+	switch typ {
+	case typeString:
+		b, err := d.bytes(offset, size)
+		return string(b), offset + size, err
+	case typeBytes:
+		b, err := d.bytes(offset, size)
+		return b, offset + size, err
+	case typeDouble:
+		v, err := d.uint(offset, 8)
+		return math.Float64frombits(v), offset + 8, err
+	case typeFloat:
+		v, err := d.uint(offset, 4)
+		return math.Float32frombits(uint32(v)), offset + 4, err
+	case typeUint16, typeUint32, typeUint64:
+		v, err := d.uint(offset, size)
+		return v, offset + size, err
+	case typeInt32:
+		v, err := d.uint(offset, size)
+		return int32(v), offset + size, err
+	case typeUint128:
+		b, err := d.bytes(offset, size)
+		return b, offset + size, err
+	case typeBool:
+		return size != 0, offset, nil
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New(ERR_INVALID_DATABASE)
+			}
+
+			m[k], offset, err = d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			var v interface{}
+			v, offset, err = d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+		}
+		return a, offset, nil
+	}
+
+	return nil, 0, errors.New(ERR_INVALID_DATABASE)
+}
+
+// Databases looks up addresses in several databases, such as a country and
+// an ASN database, and merges their results.
+type Databases []*Reader
+
+// Lookup returns the merged information of all databases about the address.
+// Addresses not contained in a database are not an error, the fields of
+// that database remain empty.
+func (dbs Databases) Lookup(ip net.IP) (Info, error) {
+	var info Info
+
+	for _, db := range dbs {
+		i, err := db.Lookup(ip)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			return info, err
+		}
+
+		if info.Country == "" {
+			info.Country = i.Country
+		}
+
+		if info.ASN == 0 {
+			info.ASN, info.Organization = i.ASN, i.Organization
+		}
+	}
+
+	return info, nil
+}
@@ -0,0 +1,218 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// encode encodes maps, strings and uint32 values in the MaxMind DB data
+// format. Pointers are encoded as pointer values.
+type pointer uint
+
+func encode(v interface{}) []byte {
+	switch v := v.(type) {
+	case string:
+		if len(v) >= 29 {
+			return append([]byte{typeString<<5 | 29, byte(len(v) - 29)}, v...)
+		}
+		return append([]byte{typeString<<5 | byte(len(v))}, v...)
+	case uint32:
+		b := []byte{typeUint32<<5 | 4, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], v)
+		return b
+	case uint16:
+		return []byte{typeUint16<<5 | 2, byte(v >> 8), byte(v)}
+	case pointer:
+		return []byte{typePointer<<5 | byte(v>>8)&7, byte(v)}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		b := []byte{typeMap<<5 | byte(len(v))}
+		for _, k := range keys {
+			b = append(b, encode(k)...)
+			b = append(b, encode(v[k])...)
+		}
+		return b
+	}
+
+	panic("unsupported type")
+}
+
+type testNode struct {
+	children [2]*testNode
+	// Offset of the record in the data section, -1 for inner nodes
+	data int
+}
+
+// buildDB returns a MaxMind DB that maps the networks to the records.
+func buildDB(t *testing.T, ipVersion, recordSize uint, networks map[string]map[string]interface{}) []byte {
+	var data []byte
+	root := &testNode{data: -1}
+
+	cidrs := make([]string, 0, len(networks))
+	for cidr := range networks {
+		cidrs = append(cidrs, cidr)
+	}
+	sort.Strings(cidrs)
+
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		assert.NoError(t, err)
+
+		ip := network.IP
+		ones, _ := network.Mask.Size()
+		if ipVersion == 6 && len(ip) == net.IPv4len {
+			ip = append(make(net.IP, 12), ip...)
+			ones += 96
+		}
+
+		node := root
+		for i := 0; i < ones; i++ {
+			bit := ip[i/8] >> (7 - i%8) & 1
+			if node.children[bit] == nil {
+				node.children[bit] = &testNode{data: -1}
+			}
+			node = node.children[bit]
+		}
+
+		node.data = len(data)
+		data = append(data, encode(networks[cidr])...)
+	}
+
+	// Number inner nodes in breadth-first order
+	var nodes []*testNode
+	index := make(map[*testNode]uint)
+	for queue := []*testNode{root}; len(queue) > 0; queue = queue[1:] {
+		if queue[0].data >= 0 {
+			continue
+		}
+		index[queue[0]] = uint(len(nodes))
+		nodes = append(nodes, queue[0])
+		for _, child := range queue[0].children {
+			if child != nil {
+				queue = append(queue, child)
+			}
+		}
+	}
+
+	nodeCount := uint(len(nodes))
+	record := func(child *testNode) uint {
+		switch {
+		case child == nil:
+			return nodeCount
+		case child.data >= 0:
+			return nodeCount + dataSectionSeparator + uint(child.data)
+		default:
+			return index[child]
+		}
+	}
+
+	var db []byte
+	for _, node := range nodes {
+		l, r := record(node.children[0]), record(node.children[1])
+
+		switch recordSize {
+		case 24:
+			db = append(db, byte(l>>16), byte(l>>8), byte(l), byte(r>>16), byte(r>>8), byte(r))
+		case 28:
+			db = append(db, byte(l>>16), byte(l>>8), byte(l), byte(l>>20)&0xf0|byte(r>>24)&0x0f, byte(r>>16), byte(r>>8), byte(r))
+		default:
+			db = binary.BigEndian.AppendUint32(db, uint32(l))
+			db = binary.BigEndian.AppendUint32(db, uint32(r))
+		}
+	}
+
+	db = append(db, make([]byte, dataSectionSeparator)...)
+	db = append(db, data...)
+	db = append(db, metadataMarker...)
+
+	return append(db, encode(map[string]interface{}{
+		"node_count":    uint32(nodeCount),
+		"record_size":   uint16(recordSize),
+		"ip_version":    uint16(ipVersion),
+		"database_type": "Test",
+	})...)
+}
+
+func TestLookup(t *testing.T) {
+	countries := map[string]map[string]interface{}{
+		"192.0.2.0/24": {"country": map[string]interface{}{"iso_code": "DE"}},
+		// Pointer to the country map of the first record, which follows its
+		// map control byte and the 8 bytes of the key
+		"198.51.100.0/24": {"country": pointer(9), "continent": "EU"},
+		"2001:db8::/32":   {"country": map[string]interface{}{"iso_code": "NL"}},
+	}
+
+	for _, size := range []uint{24, 28, 32} {
+		r, err := New(buildDB(t, 6, size, countries))
+		assert.NoError(t, err)
+		assert.Equal(t, "Test", r.DatabaseType)
+
+		info, err := r.Lookup(net.ParseIP("192.0.2.1"))
+		assert.NoError(t, err)
+		assert.Equal(t, Info{Country: "DE"}, info)
+
+		info, err = r.Lookup(net.ParseIP("198.51.100.255"))
+		assert.NoError(t, err)
+		assert.Equal(t, Info{Country: "DE"}, info)
+
+		info, err = r.Lookup(net.ParseIP("2001:db8::1"))
+		assert.NoError(t, err)
+		assert.Equal(t, Info{Country: "NL"}, info)
+
+		_, err = r.Lookup(net.ParseIP("203.0.113.1"))
+		assert.ErrorIs(t, err, ErrNotFound)
+	}
+
+	asns, err := New(buildDB(t, 4, 24, map[string]map[string]interface{}{
+		"192.0.2.0/25": {"autonomous_system_number": uint32(64496), "autonomous_system_organization": "Example"},
+	}))
+	assert.NoError(t, err)
+
+	info, err := asns.Lookup(net.ParseIP("192.0.2.100"))
+	assert.NoError(t, err)
+	assert.Equal(t, Info{ASN: 64496, Organization: "Example"}, info)
+
+	_, err = asns.Lookup(net.ParseIP("2001:db8::1"))
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	countryDB, err := New(buildDB(t, 6, 28, countries))
+	assert.NoError(t, err)
+
+	info, err = Databases{countryDB, asns}.Lookup(net.ParseIP("192.0.2.1"))
+	assert.NoError(t, err)
+	assert.Equal(t, Info{Country: "DE", ASN: 64496, Organization: "Example"}, info)
+
+	info, err = Databases{countryDB, asns}.Lookup(net.ParseIP("192.0.2.200"))
+	assert.NoError(t, err)
+	assert.Equal(t, Info{Country: "DE"}, info)
+}
+
+func TestNewInvalid(t *testing.T) {
+	_, err := New([]byte("not a database"))
+	assert.EqualError(t, err, ERR_INVALID_DATABASE)
+
+	db := buildDB(t, 4, 24, map[string]map[string]interface{}{"192.0.2.0/24": {}})
+
+	// Truncated metadata
+	_, err = New(db[:len(db)-3])
+	assert.EqualError(t, err, ERR_INVALID_DATABASE)
+
+	// Search tree larger than the file
+	huge := append(db[:bytes.LastIndex(db, metadataMarker)+len(metadataMarker)], encode(map[string]interface{}{
+		"node_count":  uint32(1 << 20),
+		"record_size": uint16(24),
+		"ip_version":  uint16(4),
+	})...)
+	_, err = New(huge)
+	assert.EqualError(t, err, ERR_INVALID_DATABASE)
+}
@@ -0,0 +1,109 @@
+// Package i18n translates messages of the CA API into the language requested
+// by the client. Messages are identified by machine-readable codes, their
+// translations are kept in one JSON catalog per language in locales/.
+//
+// To add a language, copy locales/en.json to locales/<language>.json and
+// translate all messages. Messages may contain fmt verbs, which must be kept
+// in the same order.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+const (
+	// Messages missing in other catalogs fall back to this language.
+	DEFAULT_LANGUAGE = "en"
+)
+
+//go:embed locales/*.json
+var locales embed.FS
+
+var (
+	catalogs  = make(map[string]map[string]string)
+	languages []string
+	matcher   language.Matcher
+)
+
+func init() {
+	files, err := locales.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+
+	for _, file := range files {
+		content, err := locales.ReadFile(path.Join("locales", file.Name()))
+		if err != nil {
+			panic(err)
+		}
+
+		var catalog map[string]string
+		if err := json.Unmarshal(content, &catalog); err != nil {
+			panic("invalid catalog " + file.Name() + ": " + err.Error())
+		}
+
+		catalogs[strings.TrimSuffix(file.Name(), ".json")] = catalog
+	}
+
+	// The first language is used if none of the requested ones match.
+	languages = []string{DEFAULT_LANGUAGE}
+	for lang := range catalogs {
+		if lang != DEFAULT_LANGUAGE {
+			languages = append(languages, lang)
+		}
+	}
+	sort.Strings(languages[1:])
+
+	tags := make([]language.Tag, len(languages))
+	for i, lang := range languages {
+		tags[i] = language.Make(lang)
+	}
+
+	matcher = language.NewMatcher(tags)
+}
+
+// Languages returns all languages that have a catalog.
+func Languages() []string {
+	return languages
+}
+
+// Match returns the supported language that best matches the given
+// Accept-Language header value.
+func Match(acceptLanguage string) string {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return DEFAULT_LANGUAGE
+	}
+
+	_, index, confidence := matcher.Match(tags...)
+	if confidence == language.No {
+		return DEFAULT_LANGUAGE
+	}
+
+	return languages[index]
+}
+
+// Translate returns the message with the given code in the given language,
+// formatted with args. If there is no such message, the default language is
+// used. Unknown codes are returned unchanged.
+func Translate(lang, code string, args ...interface{}) string {
+	msg, ok := catalogs[lang][code]
+	if !ok {
+		if msg, ok = catalogs[DEFAULT_LANGUAGE][code]; !ok {
+			return code
+		}
+	}
+
+	if len(args) == 0 {
+		return msg
+	}
+
+	return fmt.Sprintf(msg, args...)
+}
@@ -0,0 +1,33 @@
+package i18n
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCatalogsComplete(t *testing.T) {
+	for lang, catalog := range catalogs {
+		for code, msg := range catalogs[DEFAULT_LANGUAGE] {
+			translated, ok := catalog[code]
+			assert.True(t, ok, lang+" is missing "+code)
+			assert.Equal(t, strings.Count(msg, "%"), strings.Count(translated, "%"), lang+" has different verbs for "+code)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	assert.Equal(t, "en", Match(""))
+	assert.Equal(t, "de", Match("de-DE,de;q=0.9,en;q=0.8"))
+	assert.Equal(t, "de", Match("de-AT"))
+	assert.Equal(t, "en", Match("fr-FR,en;q=0.5"))
+	assert.Equal(t, "en", Match("xx"))
+}
+
+func TestTranslate(t *testing.T) {
+	assert.Equal(t, "Nicht gefunden.", Translate("de", "not_found"))
+	assert.Equal(t, "Der Schlüsseltyp ssh-dss wird nicht unterstützt.", Translate("de", "unsupported_key_type", "ssh-dss"))
+	assert.Equal(t, "Not found.", Translate("fr", "not_found"))
+	assert.Equal(t, "unknown_code", Translate("en", "unknown_code"))
+}
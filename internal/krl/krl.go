@@ -0,0 +1,89 @@
+// Package krl generates OpenSSH key revocation lists (KRLs) as specified in
+// PROTOCOL.krl of OpenSSH, which can be used by sshd via the RevokedKeys
+// option.
+package krl
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"sort"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	magic         = 0x5353484b524c0a00 // "SSHKRL\n\0"
+	formatVersion = 1
+
+	sectionCertificates   = 1
+	certSectionSerialList = 0x20
+)
+
+func appendUint32(b []byte, v uint32) []byte {
+	return binary.BigEndian.AppendUint32(b, v)
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return binary.BigEndian.AppendUint64(b, v)
+}
+
+func appendString(b []byte, s []byte) []byte {
+	return append(appendUint32(b, uint32(len(s))), s...)
+}
+
+// Generate returns a KRL revoking the certificates with the given serials
+// signed by caKey. The version should increase with every change of the
+// revoked serials, it can be used by hosts to detect updates.
+func Generate(caKey ssh.PublicKey, serials []uint64, version uint64, comment string) []byte {
+	sorted := append([]uint64{}, serials...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var b []byte
+
+	b = appendUint64(b, magic)
+	b = appendUint32(b, formatVersion)
+	b = appendUint64(b, version)
+	b = appendUint64(b, uint64(time.Now().Unix()))
+	b = appendUint64(b, 0)   // flags
+	b = appendString(b, nil) // reserved
+	b = appendString(b, []byte(comment))
+
+	if len(sorted) == 0 {
+		return b
+	}
+
+	var serialList []byte
+	for _, serial := range sorted {
+		serialList = appendUint64(serialList, serial)
+	}
+
+	var certs []byte
+	certs = appendString(certs, caKey.Marshal())
+	certs = appendString(certs, nil) // reserved
+	certs = append(certs, certSectionSerialList)
+	certs = appendString(certs, serialList)
+
+	b = append(b, sectionCertificates)
+	b = appendString(b, certs)
+
+	return b
+}
+
+// ETag returns an HTTP entity tag of a KRL, which is the SHA-256 hash of its
+// contents apart from the generation date, so that it only changes along with
+// the revoked keys. Hosts can compute it from their installed KRL.
+func ETag(data []byte) string {
+	// Offset of the generation date, after magic, format version and version
+	const dateOffset = 8 + 4 + 8
+
+	content := append([]byte{}, data...)
+	if len(content) >= dateOffset+8 {
+		copy(content[dateOffset:dateOffset+8], make([]byte, 8))
+	}
+
+	sum := sha256.Sum256(content)
+
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
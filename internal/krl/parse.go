@@ -0,0 +1,277 @@
+package krl
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/big"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	sectionExplicitKey = 2
+	sectionFingerprint = 3 // SHA1
+	sectionSignature   = 4
+	sectionSHA256      = 5
+
+	certSectionSerialRange  = 0x21
+	certSectionSerialBitmap = 0x22
+	certSectionKeyID        = 0x23
+
+	ERR_INVALID_KRL = "invalid KRL"
+)
+
+// revokedCerts are the certificates revoked for a CA, or for any CA if the
+// key is nil.
+type revokedCerts struct {
+	ca      ssh.PublicKey
+	serials []uint64
+	ranges  [][2]uint64
+	bitmaps []bitmap
+	keyIDs  []string
+}
+
+type bitmap struct {
+	offset uint64
+	bits   *big.Int
+}
+
+// KRL is a parsed key revocation list.
+type KRL struct {
+	Version uint64
+	Comment string
+
+	certs  []revokedCerts
+	keys   [][]byte
+	sha1   [][]byte
+	sha256 [][]byte
+}
+
+// reader reads the wire encoding of KRLs.
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) uint64() uint64 {
+	if r.err != nil || len(r.b) < 8 {
+		r.err = errors.New(ERR_INVALID_KRL)
+		return 0
+	}
+
+	v := binary.BigEndian.Uint64(r.b)
+	r.b = r.b[8:]
+
+	return v
+}
+
+func (r *reader) uint32() uint32 {
+	if r.err != nil || len(r.b) < 4 {
+		r.err = errors.New(ERR_INVALID_KRL)
+		return 0
+	}
+
+	v := binary.BigEndian.Uint32(r.b)
+	r.b = r.b[4:]
+
+	return v
+}
+
+func (r *reader) byte() byte {
+	if r.err != nil || len(r.b) < 1 {
+		r.err = errors.New(ERR_INVALID_KRL)
+		return 0
+	}
+
+	v := r.b[0]
+	r.b = r.b[1:]
+
+	return v
+}
+
+func (r *reader) string() []byte {
+	n := r.uint32()
+	if r.err != nil || uint32(len(r.b)) < n {
+		r.err = errors.New(ERR_INVALID_KRL)
+		return nil
+	}
+
+	v := r.b[:n]
+	r.b = r.b[n:]
+
+	return v
+}
+
+// strings reads strings until the end of the data.
+func (r *reader) strings() [][]byte {
+	var values [][]byte
+
+	for r.err == nil && len(r.b) > 0 {
+		values = append(values, r.string())
+	}
+
+	return values
+}
+
+// Parse parses a KRL in the format generated by Generate and ssh-keygen -k.
+// Signatures of KRLs are not verified, as OpenSSH doesn't either.
+func Parse(data []byte) (*KRL, error) {
+	r := &reader{b: data}
+	k := &KRL{}
+
+	if r.uint64() != magic || r.uint32() != formatVersion {
+		return nil, errors.New(ERR_INVALID_KRL)
+	}
+
+	k.Version = r.uint64()
+	r.uint64() // generated date
+	r.uint64() // flags
+	r.string() // reserved
+	k.Comment = string(r.string())
+
+	for r.err == nil && len(r.b) > 0 {
+		section := r.byte()
+		body := &reader{b: r.string()}
+
+		switch section {
+		case sectionCertificates:
+			certs, err := parseCertificates(body)
+			if err != nil {
+				return nil, err
+			}
+			k.certs = append(k.certs, certs)
+		case sectionExplicitKey:
+			k.keys = append(k.keys, body.strings()...)
+		case sectionFingerprint:
+			k.sha1 = append(k.sha1, body.strings()...)
+		case sectionSHA256:
+			k.sha256 = append(k.sha256, body.strings()...)
+		case sectionSignature:
+			// Signatures are the last sections
+			return k, r.err
+		default:
+			return nil, errors.New(ERR_INVALID_KRL)
+		}
+
+		if body.err != nil {
+			return nil, body.err
+		}
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return k, nil
+}
+
+// parseCertificates parses a certificates section.
+func parseCertificates(r *reader) (revokedCerts, error) {
+	var certs revokedCerts
+
+	if blob := r.string(); len(blob) > 0 {
+		ca, err := ssh.ParsePublicKey(blob)
+		if err != nil {
+			return certs, errors.New(ERR_INVALID_KRL)
+		}
+		certs.ca = ca
+	}
+	r.string() // reserved
+
+	for r.err == nil && len(r.b) > 0 {
+		typ := r.byte()
+		body := &reader{b: r.string()}
+
+		switch typ {
+		case certSectionSerialList:
+			for body.err == nil && len(body.b) > 0 {
+				certs.serials = append(certs.serials, body.uint64())
+			}
+		case certSectionSerialRange:
+			certs.ranges = append(certs.ranges, [2]uint64{body.uint64(), body.uint64()})
+		case certSectionSerialBitmap:
+			offset := body.uint64()
+			certs.bitmaps = append(certs.bitmaps, bitmap{offset, new(big.Int).SetBytes(body.string())})
+		case certSectionKeyID:
+			for _, id := range body.strings() {
+				certs.keyIDs = append(certs.keyIDs, string(id))
+			}
+		default:
+			return certs, errors.New(ERR_INVALID_KRL)
+		}
+
+		if body.err != nil {
+			return certs, body.err
+		}
+	}
+
+	return certs, r.err
+}
+
+// revokesCertificate reports whether the section revokes the certificate.
+func (c revokedCerts) revokesCertificate(cert *ssh.Certificate) bool {
+	if c.ca != nil && !bytes.Equal(c.ca.Marshal(), cert.SignatureKey.Marshal()) {
+		return false
+	}
+
+	for _, serial := range c.serials {
+		if serial == cert.Serial {
+			return true
+		}
+	}
+
+	for _, r := range c.ranges {
+		if cert.Serial >= r[0] && cert.Serial <= r[1] {
+			return true
+		}
+	}
+
+	for _, b := range c.bitmaps {
+		if cert.Serial >= b.offset && cert.Serial-b.offset < uint64(b.bits.BitLen()) && b.bits.Bit(int(cert.Serial-b.offset)) == 1 {
+			return true
+		}
+	}
+
+	for _, id := range c.keyIDs {
+		if id == cert.KeyId {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsRevoked reports whether the key is revoked. Certificates are revoked if
+// they are listed by serial or key ID, or if their key or the key of their CA
+// is revoked, as checked by sshd.
+func (k *KRL) IsRevoked(key ssh.PublicKey) bool {
+	if cert, ok := key.(*ssh.Certificate); ok {
+		for _, certs := range k.certs {
+			if certs.revokesCertificate(cert) {
+				return true
+			}
+		}
+
+		return k.IsRevoked(cert.Key) || k.IsRevoked(cert.SignatureKey)
+	}
+
+	blob := key.Marshal()
+	sum1 := sha1.Sum(blob)
+	sum256 := sha256.Sum256(blob)
+
+	for _, hashes := range []struct {
+		list [][]byte
+		sum  []byte
+	}{{k.keys, blob}, {k.sha1, sum1[:]}, {k.sha256, sum256[:]}} {
+		for _, h := range hashes.list {
+			if bytes.Equal(h, hashes.sum) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,105 @@
+package krl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// Maximum size of downloaded KRLs
+	MAX_KRL_SIZE = 16 << 20
+
+	ERR_KRL_TOO_LARGE = "KRL is too large"
+	ERR_KRL_RESPONSE  = "CA responded with unexpected code %d"
+)
+
+// URL returns the URL of the KRL of host at the CA with the given address,
+// such as https://ca.example.com.
+func URL(ca, host string) string {
+	return strings.TrimSuffix(ca, "/") + "/api/v1/" + url.PathEscape(host) + "/krl"
+}
+
+// Update downloads the KRL from krlURL and installs it at path, unless it
+// is unchanged. The ETag of the installed KRL is sent in If-None-Match, so
+// the CA only sends the KRL if it changed. The KRL is parsed before being
+// installed, and replaces the installed one atomically, so that sshd never
+// reads an invalid or partially written file, which would make it refuse all
+// certificates. It returns whether the KRL was updated.
+func Update(ctx context.Context, client *http.Client, krlURL, path string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, krlURL, nil)
+	if err != nil {
+		return false, err
+	}
+
+	if installed, err := os.ReadFile(path); err == nil {
+		req.Header.Set("If-None-Match", ETag(installed))
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusNotModified:
+		return false, nil
+	case http.StatusOK:
+	default:
+		return false, fmt.Errorf(ERR_KRL_RESPONSE, res.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, MAX_KRL_SIZE+1))
+	if err != nil {
+		return false, err
+	}
+
+	if len(data) > MAX_KRL_SIZE {
+		return false, errors.New(ERR_KRL_TOO_LARGE)
+	}
+
+	if _, err := Parse(data); err != nil {
+		return false, err
+	}
+
+	return true, install(path, data)
+}
+
+// install writes data to a temporary file next to path and renames it to
+// path.
+func install(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	// sshd reads the KRL as root, but ssh-keygen -Q may be run by any user
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,75 @@
+package krl
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestUpdate(t *testing.T) {
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	caKey, _ := ssh.NewPublicKey(pub)
+
+	serials := []uint64{1}
+	requests := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		data := Generate(caKey, serials, uint64(len(serials)), "test")
+		if r.Header.Get("If-None-Match") == ETag(data) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		if len(serials) > 2 {
+			w.Write([]byte("not a KRL"))
+			return
+		}
+
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "oinit.krl")
+	url := URL(srv.URL+"/", "host.example.com")
+
+	if updated, err := Update(context.Background(), srv.Client(), url, path); err != nil || !updated {
+		t.Fatalf("expected update, got %v, %v", updated, err)
+	}
+
+	// Unchanged, although the generation date differs
+	if updated, err := Update(context.Background(), srv.Client(), url, path); err != nil || updated {
+		t.Fatalf("expected no update, got %v, %v", updated, err)
+	}
+
+	serials = append(serials, 2)
+
+	if updated, err := Update(context.Background(), srv.Client(), url, path); err != nil || !updated {
+		t.Fatalf("expected update, got %v, %v", updated, err)
+	}
+
+	// Invalid KRLs are not installed
+	serials = append(serials, 3)
+
+	if _, err := Update(context.Background(), srv.Client(), url, path); err == nil {
+		t.Fatal("expected error for invalid KRL")
+	}
+
+	data, _ := os.ReadFile(path)
+	krl, err := Parse(data)
+	if err != nil || krl.Version != 2 {
+		t.Fatalf("expected installed KRL version 2, got %v", err)
+	}
+
+	if requests != 4 {
+		t.Errorf("expected 4 requests, got %d", requests)
+	}
+}
@@ -0,0 +1,171 @@
+package lambda
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// Version of events of HTTP APIs and function URLs, REST APIs send
+	// version 1.0 or none
+	EVENT_VERSION_2 = "2.0"
+
+	ERR_INVALID_EVENT = "invalid event"
+)
+
+var ErrInvalidEvent = errors.New(ERR_INVALID_EVENT)
+
+// event is a request of API Gateway REST APIs (payload format 1.0), HTTP APIs
+// or function URLs (payload format 2.0), which only differ in a few fields.
+type event struct {
+	Version         string `json:"version"`
+	Body            string `json:"body"`
+	IsBase64Encoded bool   `json:"isBase64Encoded"`
+
+	// Format 1.0
+	HTTPMethod            string              `json:"httpMethod"`
+	Path                  string              `json:"path"`
+	MultiValueHeaders     map[string][]string `json:"multiValueHeaders"`
+	MultiValueQueryParams map[string][]string `json:"multiValueQueryStringParameters"`
+
+	// Format 2.0, multiple values of headers are comma-separated
+	RawPath        string            `json:"rawPath"`
+	RawQueryString string            `json:"rawQueryString"`
+	Cookies        []string          `json:"cookies"`
+	Headers        map[string]string `json:"headers"`
+
+	RequestContext struct {
+		DomainName string `json:"domainName"`
+		// Format 1.0
+		Identity struct {
+			SourceIP string `json:"sourceIp"`
+		} `json:"identity"`
+		// Format 2.0
+		HTTP struct {
+			Method   string `json:"method"`
+			SourceIP string `json:"sourceIp"`
+		} `json:"http"`
+	} `json:"requestContext"`
+}
+
+// response is the response to an event in the payload format of the event.
+type response struct {
+	StatusCode        int                 `json:"statusCode"`
+	Headers           map[string]string   `json:"headers,omitempty"`
+	MultiValueHeaders map[string][]string `json:"multiValueHeaders,omitempty"`
+	Cookies           []string            `json:"cookies,omitempty"`
+	Body              string              `json:"body"`
+	IsBase64Encoded   bool                `json:"isBase64Encoded"`
+}
+
+// newRequest converts the event to an HTTP request. The client address is
+// taken from the request context, which API Gateway sets to the address of
+// the connection, so it can be trusted unlike X-Forwarded-For.
+func newRequest(ctx context.Context, payload []byte) (*http.Request, string, error) {
+	var ev event
+	if err := json.Unmarshal(payload, &ev); err != nil {
+		return nil, "", fmt.Errorf("%w: %w", ErrInvalidEvent, err)
+	}
+
+	body := []byte(ev.Body)
+	if ev.IsBase64Encoded {
+		var err error
+		if body, err = base64.StdEncoding.DecodeString(ev.Body); err != nil {
+			return nil, "", fmt.Errorf("%w: %w", ErrInvalidEvent, err)
+		}
+	}
+
+	u := &url.URL{Scheme: "https", Host: ev.RequestContext.DomainName}
+	headers := make(http.Header)
+	var method, sourceIP string
+
+	if ev.Version == EVENT_VERSION_2 {
+		method = ev.RequestContext.HTTP.Method
+		sourceIP = ev.RequestContext.HTTP.SourceIP
+		u.Path = ev.RawPath
+		u.RawQuery = ev.RawQueryString
+
+		for name, value := range ev.Headers {
+			headers.Set(name, value)
+		}
+
+		if len(ev.Cookies) > 0 {
+			headers.Set("Cookie", strings.Join(ev.Cookies, "; "))
+		}
+	} else {
+		method = ev.HTTPMethod
+		sourceIP = ev.RequestContext.Identity.SourceIP
+		u.Path = ev.Path
+		u.RawQuery = url.Values(ev.MultiValueQueryParams).Encode()
+
+		for name, values := range ev.MultiValueHeaders {
+			for _, value := range values {
+				headers.Add(name, value)
+			}
+		}
+	}
+
+	if method == "" || u.Path == "" {
+		return nil, "", ErrInvalidEvent
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %w", ErrInvalidEvent, err)
+	}
+
+	req.Header = headers
+	req.Host = headers.Get("Host")
+	if req.Host == "" {
+		req.Host = u.Host
+	}
+
+	if sourceIP != "" {
+		req.RemoteAddr = net.JoinHostPort(sourceIP, "0")
+	}
+
+	return req, ev.Version, nil
+}
+
+// newResponse converts the recorded response to the payload format of the
+// given version. Bodies that are not valid UTF-8 are base64-encoded.
+func newResponse(rec *httptest.ResponseRecorder, version string) response {
+	res := response{StatusCode: rec.Code}
+
+	body := rec.Body.Bytes()
+	if utf8.Valid(body) {
+		res.Body = string(body)
+	} else {
+		res.Body = base64.StdEncoding.EncodeToString(body)
+		res.IsBase64Encoded = true
+	}
+
+	header := rec.Result().Header
+
+	if version != EVENT_VERSION_2 {
+		res.MultiValueHeaders = header
+		return res
+	}
+
+	res.Headers = make(map[string]string)
+	for name, values := range header {
+		if name == "Set-Cookie" {
+			res.Cookies = values
+			continue
+		}
+
+		res.Headers[name] = strings.Join(values, ",")
+	}
+
+	return res
+}
@@ -0,0 +1,186 @@
+// Package lambda runs an http.Handler as an AWS Lambda function behind API
+// Gateway (REST or HTTP APIs) or a function URL. It implements the Lambda
+// runtime API for custom runtimes (provided.al2023), so the binary is started
+// by a "bootstrap" executable in the deployment package.
+//
+// Lambda runs a single invocation per instance at a time and may start and
+// stop instances at will, so state that is kept in memory, such as the memory
+// storage backend, is neither shared between instances nor kept long.
+package lambda
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// Environment variables set by Lambda for custom runtimes
+	ENV_RUNTIME_API = "AWS_LAMBDA_RUNTIME_API"
+	ENV_TRACE_ID    = "_X_AMZN_TRACE_ID"
+
+	RUNTIME_API_VERSION = "2018-06-01"
+
+	HEADER_REQUEST_ID  = "Lambda-Runtime-Aws-Request-Id"
+	HEADER_DEADLINE_MS = "Lambda-Runtime-Deadline-Ms"
+	HEADER_TRACE_ID    = "Lambda-Runtime-Trace-Id"
+	HEADER_ERROR_TYPE  = "Lambda-Runtime-Function-Error-Type"
+
+	ERR_NO_RUNTIME_API = "not running in AWS Lambda, " + ENV_RUNTIME_API + " is not set"
+	ERR_RUNTIME_API    = "runtime API request failed"
+)
+
+var (
+	ErrNoRuntimeAPI = errors.New(ERR_NO_RUNTIME_API)
+	ErrRuntimeAPI   = errors.New(ERR_RUNTIME_API)
+)
+
+// Runtime receives invocations from the Lambda runtime API.
+type Runtime struct {
+	// Base URL of the runtime API, such as "http://127.0.0.1:9001"
+	URL    string
+	Client *http.Client
+}
+
+// invocationError is reported to the runtime API if an event can't be
+// handled.
+type invocationError struct {
+	Message string `json:"errorMessage"`
+	Type    string `json:"errorType"`
+}
+
+// NewRuntime returns the runtime of the Lambda environment the process runs
+// in.
+func NewRuntime() (*Runtime, error) {
+	api := os.Getenv(ENV_RUNTIME_API)
+	if api == "" {
+		return nil, ErrNoRuntimeAPI
+	}
+
+	// Requests for the next invocation block until there is one
+	return &Runtime{URL: "http://" + api, Client: &http.Client{}}, nil
+}
+
+// Serve passes invocations to the handler until the runtime API fails, which
+// happens only if the environment is broken, so Lambda restarts it.
+func (r *Runtime) Serve(handler http.Handler) error {
+	for {
+		if err := r.invoke(handler); err != nil {
+			return err
+		}
+	}
+}
+
+// InitError reports an error during initialization, after which Lambda
+// discards the environment.
+func (r *Runtime) InitError(err error) error {
+	return r.post("/runtime/init/error", invocationError{Message: err.Error(), Type: "InitError"})
+}
+
+// invoke waits for the next invocation and passes it to the handler. Only
+// errors of the runtime API are returned, invalid events are reported as
+// invocation errors.
+func (r *Runtime) invoke(handler http.Handler) error {
+	res, err := r.Client.Get(r.URL + "/" + RUNTIME_API_VERSION + "/runtime/invocation/next")
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrRuntimeAPI, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: status %d", ErrRuntimeAPI, res.StatusCode)
+	}
+
+	payload, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrRuntimeAPI, err)
+	}
+
+	id := res.Header.Get(HEADER_REQUEST_ID)
+	os.Setenv(ENV_TRACE_ID, res.Header.Get(HEADER_TRACE_ID))
+
+	// Requests end with the invocation, which Lambda times out
+	ctx := context.Background()
+	if ms, err := strconv.ParseInt(res.Header.Get(HEADER_DEADLINE_MS), 10, 64); err == nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, time.UnixMilli(ms))
+		defer cancel()
+	}
+
+	req, version, err := newRequest(ctx, payload)
+	if err != nil {
+		log.Println("Invalid Lambda event: " + err.Error())
+		return r.post("/runtime/invocation/"+id+"/error", invocationError{Message: err.Error(), Type: "InvalidEvent"})
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	return r.post("/runtime/invocation/"+id+"/response", newResponse(rec, version))
+}
+
+func (r *Runtime) post(path string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, r.URL+"/"+RUNTIME_API_VERSION+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	if e, ok := body.(invocationError); ok {
+		req.Header.Set(HEADER_ERROR_TYPE, e.Type)
+	}
+
+	res, err := r.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrRuntimeAPI, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("%w: status %d", ErrRuntimeAPI, res.StatusCode)
+	}
+
+	return nil
+}
+
+// Lazy returns a handler that creates the actual handler on the first
+// request rather than when the environment is initialized, which Lambda
+// limits to 10 seconds. If it fails, the request is answered with 503 Service
+// Unavailable and the next request tries again, so keys on file systems or in
+// secret stores that are briefly unavailable don't fail the environment.
+func Lazy(create func() (http.Handler, error)) http.Handler {
+	var mu sync.Mutex
+	var handler http.Handler
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		if handler == nil {
+			h, err := create()
+			if err != nil {
+				mu.Unlock()
+				log.Println("Could not initialize: " + err.Error())
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				return
+			}
+
+			handler = h
+		}
+		mu.Unlock()
+
+		handler.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,106 @@
+package lambda
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRuntime serves the events in order and records the responses posted by
+// the runtime. Once all events are served, requests for the next invocation
+// fail.
+func fakeRuntime(t *testing.T, events ...string) (*Runtime, map[string]string) {
+	posted := make(map[string]string)
+	invocations := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			body, _ := io.ReadAll(r.Body)
+			posted[strings.TrimPrefix(r.URL.Path, "/"+RUNTIME_API_VERSION+"/runtime/invocation/")] = string(body)
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		if len(events) == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set(HEADER_REQUEST_ID, "req"+strconv.Itoa(invocations))
+		w.Header().Set(HEADER_DEADLINE_MS, "4102444800000")
+		io.WriteString(w, events[0])
+		events = events[1:]
+		invocations++
+	}))
+	t.Cleanup(srv.Close)
+
+	return &Runtime{URL: srv.URL, Client: srv.Client()}, posted
+}
+
+func TestServe(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		w.Header().Add("Set-Cookie", "a=1")
+		w.Header().Add("X-Test", "1")
+		w.Header().Add("X-Test", "2")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, r.Method+" "+r.URL.RequestURI()+" "+r.RemoteAddr+" "+r.Header.Get("Cookie")+" "+string(body))
+	})
+
+	runtime, posted := fakeRuntime(t,
+		`{"version":"2.0","rawPath":"/api/v1/example.com/certificate","rawQueryString":"a=b","cookies":["c=d"],
+		  "headers":{"content-type":"application/json"},"body":"e30=","isBase64Encoded":true,
+		  "requestContext":{"domainName":"ca.example.com","http":{"method":"POST","sourceIp":"2001:db8::1"}}}`,
+		`{"httpMethod":"GET","path":"/api/v1/","multiValueQueryStringParameters":{"x":["1"]},
+		  "multiValueHeaders":{"Accept":["application/json"]},"body":"",
+		  "requestContext":{"identity":{"sourceIp":"192.0.2.1"}}}`,
+		`{"version":"2.0"}`,
+	)
+
+	err := runtime.Serve(handler)
+	assert.True(t, errors.Is(err, ErrRuntimeAPI))
+
+	var res response
+	assert.NoError(t, json.Unmarshal([]byte(posted["req0/response"]), &res))
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.Equal(t, "POST /api/v1/example.com/certificate?a=b [2001:db8::1]:0 c=d {}", res.Body)
+	assert.Equal(t, "1,2", res.Headers["X-Test"])
+	assert.Equal(t, []string{"a=1"}, res.Cookies)
+
+	res = response{}
+	assert.NoError(t, json.Unmarshal([]byte(posted["req1/response"]), &res))
+	assert.Equal(t, "GET /api/v1/?x=1 192.0.2.1:0  ", res.Body)
+	assert.Equal(t, []string{"1", "2"}, res.MultiValueHeaders["X-Test"])
+	assert.Equal(t, []string{"a=1"}, res.MultiValueHeaders["Set-Cookie"])
+
+	// Invalid events are reported as errors of the invocation
+	assert.Contains(t, posted["req2/error"], ERR_INVALID_EVENT)
+}
+
+func TestLazy(t *testing.T) {
+	calls := 0
+	handler := Lazy(func() (http.Handler, error) {
+		calls++
+		if calls == 1 {
+			return nil, errors.New("keys not available")
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), nil
+	})
+
+	for _, code := range []int{http.StatusServiceUnavailable, http.StatusOK, http.StatusOK} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, code, w.Code)
+	}
+
+	assert.Equal(t, 2, calls)
+}
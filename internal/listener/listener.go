@@ -0,0 +1,91 @@
+// Package listener creates the network listener of the CA. Besides TCP
+// addresses, unix domain sockets and sockets passed by systemd (socket
+// activation) are supported.
+package listener
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	// Prefix of listen addresses that denote a unix domain socket.
+	PREFIX_UNIX = "unix:"
+
+	// Environment variables set by systemd for socket activation, see
+	// sd_listen_fds(3).
+	ENV_LISTEN_PID = "LISTEN_PID"
+	ENV_LISTEN_FDS = "LISTEN_FDS"
+
+	// First file descriptor passed by systemd.
+	LISTEN_FDS_START = 3
+
+	ERR_TOO_MANY_FDS = "more than one socket passed by systemd"
+	ERR_NOT_A_SOCKET = "path exists and is not a socket"
+)
+
+// Systemd returns the listener passed by systemd, or nil if the process was
+// not socket-activated. The environment variables are unset afterwards, so
+// they are not inherited by child processes.
+func Systemd() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv(ENV_LISTEN_PID))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	fds, err := strconv.Atoi(os.Getenv(ENV_LISTEN_FDS))
+	if err != nil || fds == 0 {
+		return nil, nil
+	}
+
+	if fds > 1 {
+		return nil, errors.New(ERR_TOO_MANY_FDS)
+	}
+
+	os.Unsetenv(ENV_LISTEN_PID)
+	os.Unsetenv(ENV_LISTEN_FDS)
+
+	syscall.CloseOnExec(LISTEN_FDS_START)
+
+	f := os.NewFile(uintptr(LISTEN_FDS_START), "systemd")
+	defer f.Close()
+
+	return net.FileListener(f)
+}
+
+// Listen returns a listener for the given address, which is either a TCP
+// host:port or a unix domain socket path prefixed with "unix:". A stale unix
+// socket is removed before listening and the permissions of the new socket
+// are set to the given mode.
+func Listen(addr string, mode os.FileMode) (net.Listener, error) {
+	path, isUnix := strings.CutPrefix(addr, PREFIX_UNIX)
+	if !isUnix {
+		return net.Listen("tcp", addr)
+	}
+
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, errors.New(ERR_NOT_A_SOCKET)
+		}
+
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
@@ -0,0 +1,47 @@
+package listener
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListenUnix(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes of unix domain sockets are not supported on windows")
+	}
+
+	path := filepath.Join(t.TempDir(), "oinit-ca.sock")
+
+	l, err := Listen(PREFIX_UNIX+path, 0600)
+	assert.Nil(t, err)
+
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// A stale socket is replaced
+	l.Close()
+	l, err = Listen(PREFIX_UNIX+path, 0660)
+	assert.Nil(t, err)
+	l.Close()
+
+	// Regular files are never removed
+	file := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(file, []byte("data"), 0600)
+
+	_, err = Listen(PREFIX_UNIX+file, 0660)
+	assert.EqualError(t, err, ERR_NOT_A_SOCKET)
+}
+
+func TestSystemdNotActivated(t *testing.T) {
+	t.Setenv(ENV_LISTEN_PID, "1")
+	t.Setenv(ENV_LISTEN_FDS, "1")
+
+	l, err := Systemd()
+	assert.Nil(t, err)
+	assert.Nil(t, l)
+}
@@ -0,0 +1,33 @@
+// Package memprotect hardens the CA process against leaking private keys to
+// disk or other processes: memory is locked so it is never swapped out, core
+// dumps are refused and buffers that held key material are wiped.
+//
+// Keys parsed by package crypto can't be placed in guarded memory enclaves,
+// so the whole process memory is locked instead.
+//
+// Only buffers owned by oinit, such as the raw content of key files, are
+// wiped. The decoded PEM block and any intermediate copies made while
+// parsing the key are allocated by x/crypto/ssh and package crypto and stay
+// in memory until they are garbage collected, as does the parsed key itself.
+// Locking the memory and refusing core dumps keeps them from leaking.
+package memprotect
+
+import (
+	"errors"
+)
+
+const (
+	ERR_UNSUPPORTED = "not supported on this platform"
+)
+
+// ErrUnsupported is returned by LockMemory on platforms that can't lock the
+// memory of the process.
+var ErrUnsupported = errors.New(ERR_UNSUPPORTED)
+
+// Wipe overwrites b with zeros, e.g. a buffer that contained a private key
+// after it has been parsed.
+func Wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
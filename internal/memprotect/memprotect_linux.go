@@ -0,0 +1,11 @@
+package memprotect
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// restrictAccess marks the process as not dumpable, so processes of the same
+// user can't attach to it with ptrace or read /proc/<pid>/mem.
+func restrictAccess() error {
+	return unix.Prctl(unix.PR_SET_DUMPABLE, 0, 0, 0, 0)
+}
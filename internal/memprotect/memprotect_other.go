@@ -0,0 +1,7 @@
+//go:build unix && !linux
+
+package memprotect
+
+func restrictAccess() error {
+	return nil
+}
@@ -0,0 +1,30 @@
+//go:build unix
+
+package memprotect
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// CoreDumpsEnabled returns whether the process may write core dumps, which
+// would contain the private keys, according to its RLIMIT_CORE.
+func CoreDumpsEnabled() (bool, error) {
+	var limit unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_CORE, &limit); err != nil {
+		return false, err
+	}
+
+	return limit.Cur != 0, nil
+}
+
+// LockMemory locks all current and future memory of the process, so it is
+// never swapped to disk. This requires CAP_IPC_LOCK or a sufficient
+// RLIMIT_MEMLOCK. Where supported, other processes of the same user are
+// additionally prevented from reading the memory.
+func LockMemory() error {
+	if err := unix.Mlockall(unix.MCL_CURRENT | unix.MCL_FUTURE); err != nil {
+		return err
+	}
+
+	return restrictAccess()
+}
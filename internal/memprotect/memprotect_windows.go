@@ -0,0 +1,15 @@
+//go:build windows
+
+package memprotect
+
+// CoreDumpsEnabled returns false, as crash dumps of Windows Error Reporting
+// are configured system-wide rather than per process.
+func CoreDumpsEnabled() (bool, error) {
+	return false, nil
+}
+
+// LockMemory returns ErrUnsupported, as Windows can only lock individual
+// pages up to the working set size, not all memory of a process.
+func LockMemory() error {
+	return ErrUnsupported
+}
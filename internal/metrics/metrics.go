@@ -0,0 +1,133 @@
+// Package metrics collects metrics of the CA and writes them in the
+// Prometheus text exposition format, without depending on a client library.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// Content type of the text exposition format
+	CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// Histogram counts observations in buckets, separately for each value of a
+// single label.
+type Histogram struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	label   string
+	buckets []float64
+	series  map[string]*series
+}
+
+type series struct {
+	// Number of observations in each bucket, not cumulative
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram returns a histogram with the given upper bounds of buckets,
+// which must be sorted.
+func NewHistogram(name, help, label string, buckets []float64) *Histogram {
+	return &Histogram{
+		name:    name,
+		help:    help,
+		label:   label,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+}
+
+// Observe adds the value to the series of the given label value.
+func (h *Histogram) Observe(labelValue string, value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[labelValue]
+	if !ok {
+		s = &series{counts: make([]uint64, len(h.buckets))}
+		h.series[labelValue] = s
+	}
+
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+		s.counts[i]++
+	}
+
+	s.sum += value
+	s.count++
+}
+
+// Write writes all series of the histogram.
+func (h *Histogram) Write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeHeader(w, h.name, h.help, "histogram")
+
+	for _, labelValue := range sortedKeys(h.series) {
+		s := h.series[labelValue]
+		labels := h.label + `="` + escape(labelValue) + `"`
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", h.name, labels, formatFloat(bound), cumulative)
+		}
+
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", h.name, labels, s.count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", h.name, labels, formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", h.name, labels, s.count)
+	}
+}
+
+// WriteGauge writes a gauge with one sample per value of label.
+func WriteGauge(w io.Writer, name, help, label string, values map[string]float64) {
+	writeHeader(w, name, help, "gauge")
+	writeSamples(w, name, label, values)
+}
+
+// WriteCounter writes a counter with one sample per value of label. name
+// should end with "_total".
+func WriteCounter(w io.Writer, name, help, label string, values map[string]float64) {
+	writeHeader(w, name, help, "counter")
+	writeSamples(w, name, label, values)
+}
+
+func writeSamples(w io.Writer, name, label string, values map[string]float64) {
+	for _, labelValue := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %s\n", name, label, escape(labelValue), formatFloat(values[labelValue]))
+	}
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+func sortedKeys[E any](m map[string]E) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// escape escapes a label value, see the exposition format.
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram("validity_seconds", "Validity.", "hostgroup", []float64{60, 3600})
+	h.Observe("example.com", 30)
+	h.Observe("example.com", 600)
+	h.Observe("example.com", 7200)
+	h.Observe(`"quoted"`, 60)
+
+	var buf bytes.Buffer
+	h.Write(&buf)
+
+	assert.Equal(t, `# HELP validity_seconds Validity.
+# TYPE validity_seconds histogram
+validity_seconds_bucket{hostgroup="\"quoted\"",le="60"} 1
+validity_seconds_bucket{hostgroup="\"quoted\"",le="3600"} 1
+validity_seconds_bucket{hostgroup="\"quoted\"",le="+Inf"} 1
+validity_seconds_sum{hostgroup="\"quoted\""} 60
+validity_seconds_count{hostgroup="\"quoted\""} 1
+validity_seconds_bucket{hostgroup="example.com",le="60"} 1
+validity_seconds_bucket{hostgroup="example.com",le="3600"} 2
+validity_seconds_bucket{hostgroup="example.com",le="+Inf"} 3
+validity_seconds_sum{hostgroup="example.com"} 7830
+validity_seconds_count{hostgroup="example.com"} 3
+`, buf.String())
+}
+
+func TestWriteGauge(t *testing.T) {
+	var buf bytes.Buffer
+	WriteGauge(&buf, "expiring", "Expiring.", "hostgroup", map[string]float64{"b": 0, "a": 2})
+
+	assert.Equal(t, `# HELP expiring Expiring.
+# TYPE expiring gauge
+expiring{hostgroup="a"} 2
+expiring{hostgroup="b"} 0
+`, buf.String())
+}
+
+func TestWriteCounter(t *testing.T) {
+	var buf bytes.Buffer
+	WriteCounter(&buf, "requests_total", "Requests.", "upstream", map[string]float64{"https://a": 3})
+
+	assert.Equal(t, `# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{upstream="https://a"} 3
+`, buf.String())
+}
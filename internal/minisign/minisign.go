@@ -0,0 +1,119 @@
+// Package minisign verifies detached signatures created by minisign, see
+// https://jedisct1.github.io/minisign/ for the format. Both legacy and
+// prehashed (minisign -H) signatures are supported.
+package minisign
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	ERR_BAD_PUBLIC_KEY = "malformed minisign public key"
+	ERR_BAD_SIGNATURE  = "malformed minisign signature"
+	ERR_KEY_MISMATCH   = "signature was created with a different key"
+	ERR_INVALID        = "invalid signature"
+
+	TRUSTED_COMMENT_PREFIX = "trusted comment: "
+
+	algLegacy    = "Ed"
+	algPrehashed = "ED"
+	keyIdSize    = 8
+)
+
+type PublicKey struct {
+	KeyId [keyIdSize]byte
+	Key   ed25519.PublicKey
+}
+
+type Signature struct {
+	Algorithm       string
+	KeyId           [keyIdSize]byte
+	Signature       []byte
+	TrustedComment  string
+	GlobalSignature []byte
+}
+
+// ParsePublicKey parses a public key, either the base64 encoded key alone or
+// the content of a minisign .pub file.
+func ParsePublicKey(s string) (PublicKey, error) {
+	var pk PublicKey
+
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[len(lines)-1]))
+	if err != nil || len(data) != 2+keyIdSize+ed25519.PublicKeySize || string(data[:2]) != algLegacy {
+		return pk, errors.New(ERR_BAD_PUBLIC_KEY)
+	}
+
+	copy(pk.KeyId[:], data[2:2+keyIdSize])
+	pk.Key = ed25519.PublicKey(data[2+keyIdSize:])
+
+	return pk, nil
+}
+
+// ParseSignature parses the content of a minisign .minisig file.
+func ParseSignature(data []byte) (Signature, error) {
+	var sig Signature
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 4 {
+		return sig, errors.New(ERR_BAD_SIGNATURE)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(raw) != 2+keyIdSize+ed25519.SignatureSize {
+		return sig, errors.New(ERR_BAD_SIGNATURE)
+	}
+
+	sig.Algorithm = string(raw[:2])
+	if sig.Algorithm != algLegacy && sig.Algorithm != algPrehashed {
+		return sig, errors.New(ERR_BAD_SIGNATURE)
+	}
+
+	copy(sig.KeyId[:], raw[2:2+keyIdSize])
+	sig.Signature = raw[2+keyIdSize:]
+
+	comment, ok := strings.CutPrefix(strings.TrimRight(lines[2], "\r"), TRUSTED_COMMENT_PREFIX)
+	if !ok {
+		return sig, errors.New(ERR_BAD_SIGNATURE)
+	}
+
+	sig.TrustedComment = comment
+
+	sig.GlobalSignature, err = base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(sig.GlobalSignature) != ed25519.SignatureSize {
+		return sig, errors.New(ERR_BAD_SIGNATURE)
+	}
+
+	return sig, nil
+}
+
+// Verify checks the signature of message, including the signature of the
+// trusted comment.
+func Verify(pk PublicKey, message []byte, sig Signature) error {
+	if !bytes.Equal(pk.KeyId[:], sig.KeyId[:]) {
+		return errors.New(ERR_KEY_MISMATCH)
+	}
+
+	if sig.Algorithm == algPrehashed {
+		hash := blake2b.Sum512(message)
+		message = hash[:]
+	}
+
+	if !ed25519.Verify(pk.Key, message, sig.Signature) {
+		return errors.New(ERR_INVALID)
+	}
+
+	global := append(append([]byte{}, sig.Signature...), sig.TrustedComment...)
+	if !ed25519.Verify(pk.Key, global, sig.GlobalSignature) {
+		return errors.New(ERR_INVALID)
+	}
+
+	return nil
+}
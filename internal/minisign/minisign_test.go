@@ -0,0 +1,65 @@
+package minisign
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/blake2b"
+)
+
+// sign creates a minisign signature file for message.
+func sign(priv ed25519.PrivateKey, keyId []byte, message []byte, prehashed bool, comment string) []byte {
+	alg := algLegacy
+	if prehashed {
+		alg = algPrehashed
+		hash := blake2b.Sum512(message)
+		message = hash[:]
+	}
+
+	sig := ed25519.Sign(priv, message)
+	global := ed25519.Sign(priv, append(append([]byte{}, sig...), comment...))
+
+	raw := append(append([]byte(alg), keyId...), sig...)
+
+	return []byte("untrusted comment: signature from minisign secret key\n" +
+		base64.StdEncoding.EncodeToString(raw) + "\n" +
+		TRUSTED_COMMENT_PREFIX + comment + "\n" +
+		base64.StdEncoding.EncodeToString(global) + "\n")
+}
+
+func TestVerify(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+
+	keyId := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+	pk, err := ParsePublicKey("untrusted comment: minisign public key\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte(algLegacy), keyId...), pub...)))
+	assert.NoError(t, err)
+
+	message := []byte("oinit binary")
+
+	for _, prehashed := range []bool{false, true} {
+		sig, err := ParseSignature(sign(priv, keyId, message, prehashed, "oinit v1.0.0 linux/amd64"))
+		assert.NoError(t, err)
+		assert.Equal(t, "oinit v1.0.0 linux/amd64", sig.TrustedComment)
+
+		assert.NoError(t, Verify(pk, message, sig))
+		assert.EqualError(t, Verify(pk, []byte("tampered"), sig), ERR_INVALID)
+
+		sig.TrustedComment = "oinit v0.9.0 linux/amd64"
+		assert.EqualError(t, Verify(pk, message, sig), ERR_INVALID)
+	}
+
+	sig, err := ParseSignature(sign(priv, []byte{8, 7, 6, 5, 4, 3, 2, 1}, message, false, ""))
+	assert.NoError(t, err)
+	assert.EqualError(t, Verify(pk, message, sig), ERR_KEY_MISMATCH)
+
+	_, err = ParsePublicKey("not a key")
+	assert.EqualError(t, err, ERR_BAD_PUBLIC_KEY)
+
+	_, err = ParseSignature([]byte("not a signature"))
+	assert.EqualError(t, err, ERR_BAD_SIGNATURE)
+}
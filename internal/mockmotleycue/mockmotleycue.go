@@ -0,0 +1,154 @@
+// Package mockmotleycue implements the subset of the motley_cue REST API used
+// by oinit (see package libmotleycue), so issuance and login can be tested end
+// to end without an OpenID provider.
+//
+// Access tokens are not verified, they merely identify users registered with
+// AddUser.
+package mockmotleycue
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/lbrocke/oinit/pkg/libmotleycue"
+)
+
+const (
+	DEFAULT_OP = "https://op.example.com"
+	LOGIN_HELP = "https://op.example.com/register"
+
+	ERR_NO_TOKEN      = "Authorization header is missing"
+	ERR_UNKNOWN_TOKEN = "Access token is not valid"
+	ERR_NOT_FOUND     = "Not Found"
+)
+
+// User is the state motley_cue keeps for the owner of an access token.
+type User struct {
+	SSHUser string
+	// All accounts the user is mapped to, if more than SSHUser
+	SSHUsers []string
+	State    libmotleycue.UserStatusState
+}
+
+// Server is a http.Handler serving GET /info, /user/get_status and
+// /user/deploy. It is safe for concurrent use.
+type Server struct {
+	mu      sync.Mutex
+	ops     []string
+	users   map[string]User
+	deploys int
+}
+
+// New returns a server that claims to support the given OPs, or DEFAULT_OP if
+// none are given.
+func New(ops ...string) *Server {
+	if len(ops) == 0 {
+		ops = []string{DEFAULT_OP}
+	}
+
+	return &Server{
+		ops:   ops,
+		users: make(map[string]User),
+	}
+}
+
+// AddUser registers the user that is returned for the given access token. Users
+// in state not_deployed are deployed by the first call of /user/deploy, like
+// motley_cue does.
+func (s *Server) AddUser(token string, user User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users[token] = user
+}
+
+// Deploys returns the number of successful calls of /user/deploy.
+func (s *Server) Deploys() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.deploys
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeDetail(w http.ResponseWriter, status int, detail string) {
+	writeJSON(w, status, libmotleycue.ApiResponseDetail{Detail: detail})
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeDetail(w, http.StatusMethodNotAllowed, "Method Not Allowed")
+		return
+	}
+
+	switch r.URL.Path {
+	case "/info":
+		s.info(w)
+	case "/user/get_status":
+		s.user(w, r, false)
+	case "/user/deploy":
+		s.user(w, r, true)
+	default:
+		writeDetail(w, http.StatusNotFound, ERR_NOT_FOUND)
+	}
+}
+
+func (s *Server) info(w http.ResponseWriter) {
+	info := libmotleycue.ApiResponseInfo{
+		LoginInfo: libmotleycue.LoginInfo{
+			Description: "mock motley_cue",
+			LoginHelp:   LOGIN_HELP,
+		},
+		SupportedOPs: s.ops,
+		OpsInfo:      make(map[string]libmotleycue.OpInfo),
+	}
+
+	for _, op := range s.ops {
+		info.OpsInfo[op] = libmotleycue.OpInfo{Scopes: []string{"openid", "profile", "email"}}
+	}
+
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (s *Server) user(w http.ResponseWriter, r *http.Request, deploy bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		writeDetail(w, http.StatusUnauthorized, ERR_NO_TOKEN)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[token]
+	if !ok {
+		writeDetail(w, http.StatusUnauthorized, ERR_UNKNOWN_TOKEN)
+		return
+	}
+
+	if deploy {
+		if user.State == libmotleycue.StateNotDeployed {
+			user.State = libmotleycue.StateDeployed
+			s.users[token] = user
+		}
+
+		if user.State == libmotleycue.StateDeployed {
+			s.deploys++
+		}
+	}
+
+	writeJSON(w, http.StatusOK, libmotleycue.ApiResponseUserStatus{
+		State: user.State,
+		Credentials: libmotleycue.Credentials{
+			SSHUser:  user.SSHUser,
+			SSHUsers: user.SSHUsers,
+		},
+	})
+}
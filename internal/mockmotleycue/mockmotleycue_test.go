@@ -0,0 +1,44 @@
+package mockmotleycue
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lbrocke/oinit/pkg/libmotleycue"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer(t *testing.T) {
+	mock := New()
+	mock.AddUser("alice-token", User{SSHUser: "alice", State: libmotleycue.StateNotDeployed})
+	mock.AddUser("bob-token", User{SSHUser: "bob", State: libmotleycue.StateSuspended})
+
+	srv := httptest.NewServer(mock)
+	defer srv.Close()
+
+	client := libmotleycue.NewClient(srv.URL)
+
+	info, err := client.GetInfo()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{DEFAULT_OP}, info.SupportedOPs)
+	assert.Contains(t, info.OpsInfo, DEFAULT_OP)
+
+	status, err := client.GetUserStatus("alice-token")
+	assert.NoError(t, err)
+	assert.Equal(t, libmotleycue.StateNotDeployed, status.State)
+
+	status, err = client.GetUserDeploy("alice-token")
+	assert.NoError(t, err)
+	assert.Equal(t, libmotleycue.StateDeployed, status.State)
+	assert.Equal(t, "alice", status.Credentials.SSHUser)
+
+	status, err = client.GetUserDeploy("bob-token")
+	assert.NoError(t, err)
+	assert.Equal(t, libmotleycue.StateSuspended, status.State)
+
+	assert.Equal(t, 1, mock.Deploys())
+
+	_, err = client.GetUserStatus("unknown")
+	assert.EqualError(t, err, ERR_UNKNOWN_TOKEN)
+}
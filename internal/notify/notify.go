@@ -0,0 +1,120 @@
+// Package notify sends notifications to users, such as when a certificate is
+// issued for their identity from a new IP address. Notifiers are pluggable,
+// currently email (SMTP) and Matrix rooms are supported.
+package notify
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	MATRIX_SEND_PATH = "/_matrix/client/v3/rooms/%s/send/m.room.message/%s"
+	MATRIX_TIMEOUT   = 10 * time.Second
+
+	ERR_NO_RECIPIENT = "no recipient address"
+	ERR_MATRIX       = "matrix homeserver responded with code %d"
+)
+
+// Message is a notification for a single user.
+type Message struct {
+	Subject string
+	Body    string
+}
+
+// Notifier delivers messages. The recipient is the email address of the
+// user, notifiers that post to a configured channel may ignore it.
+type Notifier interface {
+	Notify(recipient string, msg Message) error
+}
+
+// Email sends messages to the recipient via SMTP. STARTTLS is used if the
+// server supports it.
+type Email struct {
+	// Address of the SMTP server as host:port
+	Server string
+	From   string
+	// Optional credentials for PLAIN authentication
+	Username string
+	Password string
+}
+
+func (e Email) Notify(recipient string, msg Message) error {
+	if recipient == "" || strings.ContainsAny(recipient, "\r\n") {
+		return errors.New(ERR_NO_RECIPIENT)
+	}
+
+	var auth smtp.Auth
+	if e.Username != "" {
+		host, _, _ := net.SplitHostPort(e.Server)
+		auth = smtp.PlainAuth("", e.Username, e.Password, host)
+	}
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "From: %s\r\n", e.From)
+	fmt.Fprintf(&body, "To: %s\r\n", recipient)
+	fmt.Fprintf(&body, "Subject: %s\r\n", strings.ReplaceAll(msg.Subject, "\n", " "))
+	fmt.Fprintf(&body, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	body.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	body.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	return smtp.SendMail(e.Server, auth, e.From, []string{recipient}, body.Bytes())
+}
+
+// Matrix posts messages to a Matrix room, such as a room monitored by the
+// security team.
+type Matrix struct {
+	// Base URL of the homeserver, such as https://matrix.example.com
+	Homeserver string
+	// Room ID, such as !abc:example.com
+	Room string
+	// Access token of the user posting the messages
+	Token string
+}
+
+func (m Matrix) Notify(recipient string, msg Message) error {
+	text := msg.Subject + "\n\n" + msg.Body
+	if recipient != "" {
+		text = "[" + recipient + "] " + text
+	}
+
+	content, err := json.Marshal(map[string]string{"msgtype": "m.text", "body": text})
+	if err != nil {
+		return err
+	}
+
+	txn := make([]byte, 8)
+	rand.Read(txn)
+
+	endpoint := strings.TrimSuffix(m.Homeserver, "/") + fmt.Sprintf(MATRIX_SEND_PATH, url.PathEscape(m.Room), hex.EncodeToString(txn))
+
+	req, err := http.NewRequest(http.MethodPut, endpoint, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+m.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := (&http.Client{Timeout: MATRIX_TIMEOUT}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf(ERR_MATRIX, res.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatrix(t *testing.T) {
+	var body map[string]string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPut, r.Method)
+		assert.True(t, strings.HasPrefix(r.URL.EscapedPath(), "/_matrix/client/v3/rooms/%21room:example.com/send/m.room.message/"))
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Write([]byte(`{"event_id": "$1"}`))
+	}))
+	defer srv.Close()
+
+	matrix := Matrix{Homeserver: srv.URL + "/", Room: "!room:example.com", Token: "secret"}
+
+	assert.NoError(t, matrix.Notify("alice@example.com", Message{Subject: "Subject", Body: "Body"}))
+	assert.Equal(t, "m.text", body["msgtype"])
+	assert.Equal(t, "[alice@example.com] Subject\n\nBody", body["body"])
+
+	matrix.Token = ""
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	assert.Error(t, matrix.Notify("", Message{}))
+}
+
+func TestEmailRecipient(t *testing.T) {
+	email := Email{Server: "127.0.0.1:0", From: "ca@example.com"}
+
+	assert.EqualError(t, email.Notify("", Message{}), ERR_NO_RECIPIENT)
+	assert.EqualError(t, email.Notify("alice@example.com\r\nBcc: eve@example.com", Message{}), ERR_NO_RECIPIENT)
+}
@@ -0,0 +1,72 @@
+package ntp
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the result of the most recent clock check.
+type Clock struct {
+	Server string
+	// Offset of the local clock, positive if ahead
+	Offset    time.Duration
+	CheckedAt time.Time
+	// Set if the NTP server could not be queried
+	Err error
+}
+
+// Monitor periodically checks the local clock against an NTP server.
+type Monitor struct {
+	server string
+
+	mu    sync.RWMutex
+	clock Clock
+}
+
+// NewMonitor returns a monitor for the given NTP server. No check is done
+// until Check or Run is called.
+func NewMonitor(server string) *Monitor {
+	return &Monitor{
+		server: server,
+		clock:  Clock{Server: server},
+	}
+}
+
+// Check queries the NTP server once and returns the result.
+func (m *Monitor) Check() Clock {
+	offset, err := Offset(m.server)
+
+	clock := Clock{
+		Server:    m.server,
+		Offset:    offset,
+		CheckedAt: time.Now(),
+		Err:       err,
+	}
+
+	m.mu.Lock()
+	m.clock = clock
+	m.mu.Unlock()
+
+	return clock
+}
+
+// Run checks the clock every interval. It never returns.
+func (m *Monitor) Run(interval time.Duration) {
+	for range time.Tick(interval) {
+		m.Check()
+	}
+}
+
+// Clock returns the result of the most recent check.
+func (m *Monitor) Clock() Clock {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.clock
+}
+
+// Skewed reports whether the offset of the last successful check exceeds the
+// given tolerance in either direction.
+func (c Clock) Skewed(tolerance time.Duration) bool {
+	return c.Err == nil && !c.CheckedAt.IsZero() && (c.Offset > tolerance || c.Offset < -tolerance)
+}
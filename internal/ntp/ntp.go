@@ -0,0 +1,74 @@
+// Package ntp implements a minimal SNTP (RFC 4330) client, which is used to
+// check the local clock for skew.
+package ntp
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+const (
+	DEFAULT_SERVER = "pool.ntp.org"
+
+	ERR_RESPONSE = "invalid response from NTP server"
+
+	port    = "123"
+	timeout = 5 * time.Second
+
+	// Seconds between 1900-01-01 (NTP epoch) and 1970-01-01 (Unix epoch)
+	epochOffset = 2208988800
+)
+
+// ntpTime converts a 64 bit NTP timestamp into a time.Time.
+func ntpTime(b []byte) time.Time {
+	secs := binary.BigEndian.Uint32(b[0:4])
+	frac := binary.BigEndian.Uint32(b[4:8])
+
+	nanos := (int64(frac) * 1e9) >> 32
+
+	return time.Unix(int64(secs)-epochOffset, nanos)
+}
+
+// Offset queries the given NTP server and returns the offset of the local
+// clock, i.e. a positive value means the local clock is ahead.
+func Offset(server string) (time.Duration, error) {
+	conn, err := net.DialTimeout("udp", net.JoinHostPort(server, port), timeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	req := make([]byte, 48)
+	// LI = 0 (no warning), VN = 4, Mode = 3 (client)
+	req[0] = 0<<6 | 4<<3 | 3
+
+	t1 := time.Now()
+
+	if _, err := conn.Write(req); err != nil {
+		return 0, err
+	}
+
+	res := make([]byte, 48)
+	n, err := conn.Read(res)
+	if err != nil {
+		return 0, err
+	}
+
+	t4 := time.Now()
+
+	// Mode must be 4 (server) and stratum must not be 0 (kiss-o'-death)
+	if n < 48 || res[0]&0x7 != 4 || res[1] == 0 {
+		return 0, errors.New(ERR_RESPONSE)
+	}
+
+	t2 := ntpTime(res[32:40]) // receive timestamp
+	t3 := ntpTime(res[40:48]) // transmit timestamp
+
+	// From RFC 4330: d = ((T2 - T1) + (T3 - T4)) / 2 is the offset of the
+	// server clock, negate to get the offset of the local clock.
+	return -(t2.Sub(t1) + t3.Sub(t4)) / 2, nil
+}
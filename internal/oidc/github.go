@@ -0,0 +1,49 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+)
+
+const (
+	// Set by GitHub Actions for jobs with the id-token: write permission
+	ENV_GITHUB_REQUEST_URL   = "ACTIONS_ID_TOKEN_REQUEST_URL"
+	ENV_GITHUB_REQUEST_TOKEN = "ACTIONS_ID_TOKEN_REQUEST_TOKEN"
+)
+
+// GitHubActionsAvailable reports whether an OIDC token can be requested from
+// GitHub Actions.
+func GitHubActionsAvailable() bool {
+	return os.Getenv(ENV_GITHUB_REQUEST_URL) != "" && os.Getenv(ENV_GITHUB_REQUEST_TOKEN) != ""
+}
+
+// GitHubActionsToken requests an OIDC token of the running GitHub Actions job
+// for the audience.
+func GitHubActionsToken(ctx context.Context, audience string) (string, error) {
+	u, err := url.Parse(os.Getenv(ENV_GITHUB_REQUEST_URL))
+	if err != nil {
+		return "", errors.New(ERR_REQUEST)
+	}
+
+	if audience != "" {
+		query := u.Query()
+		query.Set("audience", audience)
+		u.RawQuery = query.Encode()
+	}
+
+	var res struct {
+		Value string `json:"value"`
+	}
+
+	if err := get(ctx, u.String(), os.Getenv(ENV_GITHUB_REQUEST_TOKEN), &res); err != nil {
+		return "", err
+	}
+
+	if res.Value == "" {
+		return "", errors.New(ERR_RESPONSE_BODY)
+	}
+
+	return res.Value, nil
+}
@@ -0,0 +1,200 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"strings"
+
+	"github.com/lbrocke/oinit/internal/util"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	ERR_NO_JWKS_URI = "issuer has no jwks_uri"
+	ERR_UNKNOWN_KEY = "token is signed by an unknown key"
+
+	// Duration (in seconds) that the keys of issuers are cached. Keys with
+	// unknown IDs cause a refresh, so rotated keys are picked up early.
+	JWKS_CACHE_DURATION = 3600
+)
+
+// Signature algorithms accepted for workload tokens
+var validMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// jwksKeys contains the public keys of JWKS URLs by key ID.
+var jwksKeys = util.NewTimedCache[string, map[string]crypto.PublicKey]()
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey returns the RSA or ECDSA public key of the JWK.
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+		if err != nil {
+			return nil, err
+		}
+
+		return new(big.Int).SetBytes(b), nil
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decode(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("invalid RSA exponent")
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New("unsupported curve " + k.Crv)
+		}
+
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, errors.New("unsupported key type " + k.Kty)
+}
+
+// jwksURI returns the jwks_uri of the issuer using OpenID Connect discovery.
+func jwksURI(ctx context.Context, issuer string) (string, error) {
+	var conf struct {
+		JwksURI string `json:"jwks_uri"`
+	}
+
+	if err := get(ctx, strings.TrimSuffix(issuer, "/")+DISCOVERY_PATH, "", &conf); err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+
+		return "", errors.New(ERR_DISCOVERY)
+	}
+
+	if conf.JwksURI == "" {
+		return "", errors.New(ERR_NO_JWKS_URI)
+	}
+
+	return conf.JwksURI, nil
+}
+
+// fetchKeys returns the signing keys of the JWKS at url by key ID. Keys that
+// are not supported are skipped.
+func fetchKeys(ctx context.Context, url string) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	if err := get(ctx, url, "", &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+
+	return keys, nil
+}
+
+// signingKey returns the key with the given ID of the JWKS at url, fetching
+// the JWKS again if the key is unknown.
+func signingKey(ctx context.Context, url, kid string) (crypto.PublicKey, error) {
+	if keys, ok := jwksKeys.Get(url); ok {
+		if key, ok := keys[kid]; ok {
+			return key, nil
+		}
+	}
+
+	keys, err := fetchKeys(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	jwksKeys.Set(url, keys, JWKS_CACHE_DURATION)
+
+	key, ok := keys[kid]
+	if !ok {
+		return nil, errors.New(ERR_UNKNOWN_KEY)
+	}
+
+	return key, nil
+}
+
+// VerifyToken verifies the signature, issuer, audience and expiry of the
+// token using the keys of the issuer and returns its claims. Unlike access
+// tokens of users, which are verified by motley_cue, this is meant for
+// workload identity tokens such as Kubernetes service account tokens. If
+// jwksURL is empty, it is discovered.
+func VerifyToken(ctx context.Context, token, issuer, audience, jwksURL string) (jwt.MapClaims, error) {
+	if jwksURL == "" {
+		var err error
+		if jwksURL, err = jwksURI(ctx, issuer); err != nil {
+			return nil, err
+		}
+	}
+
+	claims := jwt.MapClaims{}
+
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return signingKey(ctx, jwksURL, kid)
+	},
+		jwt.WithValidMethods(validMethods),
+		jwt.WithIssuer(issuer),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,131 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/util"
+)
+
+const (
+	ERR_DISCOVERY            = "cannot discover issuer configuration"
+	ERR_NO_USERINFO_ENDPOINT = "issuer has no userinfo endpoint"
+	ERR_REQUEST              = "http request failed"
+	ERR_RESPONSE_BODY        = "cannot parse response body"
+	ERR_SERVER_RESPONSE_CODE = "server responded with code: %d"
+
+	DISCOVERY_PATH = "/.well-known/openid-configuration"
+
+	// Duration (in seconds) that userinfo endpoints of issuers are cached
+	DISCOVERY_CACHE_DURATION = 3600
+)
+
+var (
+	httpClient = &http.Client{Timeout: 10 * time.Second}
+
+	userinfoEndpoints = util.NewTimedCache[string, string]()
+)
+
+// get requests the url, optionally with a bearer token, and unmarshals the
+// JSON response into the given struct.
+func get(ctx context.Context, url, token string, into interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return errors.New(ERR_REQUEST)
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		return errors.New(ERR_REQUEST)
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf(ERR_SERVER_RESPONSE_CODE, res.StatusCode)
+	}
+
+	if json.NewDecoder(res.Body).Decode(into) != nil {
+		return errors.New(ERR_RESPONSE_BODY)
+	}
+
+	return nil
+}
+
+// userinfoEndpoint returns the userinfo endpoint of the issuer using OpenID
+// Connect discovery.
+func userinfoEndpoint(ctx context.Context, issuer string) (string, error) {
+	if endpoint, ok := userinfoEndpoints.Get(issuer); ok {
+		return endpoint, nil
+	}
+
+	var conf struct {
+		UserinfoEndpoint string `json:"userinfo_endpoint"`
+	}
+
+	if err := get(ctx, strings.TrimSuffix(issuer, "/")+DISCOVERY_PATH, "", &conf); err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+
+		return "", errors.New(ERR_DISCOVERY)
+	}
+
+	if conf.UserinfoEndpoint == "" {
+		return "", errors.New(ERR_NO_USERINFO_ENDPOINT)
+	}
+
+	userinfoEndpoints.Set(issuer, conf.UserinfoEndpoint, DISCOVERY_CACHE_DURATION)
+
+	return conf.UserinfoEndpoint, nil
+}
+
+// UserInfo returns the claims of the userinfo endpoint of the issuer for the
+// given access token. As the issuer only answers for valid tokens, this also
+// verifies the token. The requests are aborted when ctx is done.
+func UserInfo(ctx context.Context, issuer, token string) (map[string]interface{}, error) {
+	endpoint, err := userinfoEndpoint(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := get(ctx, endpoint, token, &claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// ClaimValues returns the values of the named claim, which may either be a
+// single string or a list of strings.
+func ClaimValues(claims map[string]interface{}, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var values []string
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+
+		return values
+	}
+
+	return nil
+}
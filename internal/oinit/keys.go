@@ -0,0 +1,210 @@
+package oinit
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// Type of the key pairs that certificates are requested for
+	ENV_KEY_TYPE = "OINIT_KEY_TYPE"
+	// Whether a fresh key pair is generated for every certificate or one key
+	// pair is kept per host
+	ENV_KEY_REUSE = "OINIT_KEY_REUSE"
+	// Directory that key pairs kept per host are stored in
+	ENV_KEY_DIR = "OINIT_KEY_DIR"
+
+	KEY_TYPE_ED25519 = "ed25519"
+	KEY_TYPE_ECDSA   = "ecdsa"
+	KEY_TYPE_RSA     = "rsa"
+
+	KEY_REUSE_CERT = "per-cert"
+	KEY_REUSE_HOST = "per-host"
+
+	// Sizes of generated ECDSA and RSA keys
+	KEY_ECDSA_BITS = 256
+	KEY_RSA_BITS   = 3072
+
+	// Name of the key directory in the user's config directory
+	KEY_DIR_NAME = "oinit/keys"
+
+	ERR_KEY_TYPE  = "unsupported key type in " + ENV_KEY_TYPE + ", must be one of ed25519, ecdsa or rsa"
+	ERR_KEY_REUSE = "unsupported key reuse in " + ENV_KEY_REUSE + ", must be per-cert or per-host"
+)
+
+// KeyOptions determines the key pairs that certificates are requested for.
+type KeyOptions struct {
+	Type  string
+	Reuse string
+	// Only used if Reuse is KEY_REUSE_HOST
+	Dir string
+}
+
+// KeyOptionsFromEnv returns the key options set in OINIT_KEY_TYPE,
+// OINIT_KEY_REUSE and OINIT_KEY_DIR. By default, a fresh ed25519 key pair is
+// generated for every certificate, and key pairs kept per host are stored in
+// "oinit/keys" in the user's config directory.
+func KeyOptionsFromEnv() (KeyOptions, error) {
+	opts := KeyOptions{
+		Type:  strings.ToLower(os.Getenv(ENV_KEY_TYPE)),
+		Reuse: strings.ToLower(os.Getenv(ENV_KEY_REUSE)),
+		Dir:   os.Getenv(ENV_KEY_DIR),
+	}
+
+	switch opts.Type {
+	case "":
+		opts.Type = KEY_TYPE_ED25519
+	case KEY_TYPE_ED25519, KEY_TYPE_ECDSA, KEY_TYPE_RSA:
+	default:
+		return KeyOptions{}, errors.New(ERR_KEY_TYPE)
+	}
+
+	switch opts.Reuse {
+	case "":
+		opts.Reuse = KEY_REUSE_CERT
+	case KEY_REUSE_CERT, KEY_REUSE_HOST:
+	default:
+		return KeyOptions{}, errors.New(ERR_KEY_REUSE)
+	}
+
+	if opts.Dir == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return KeyOptions{}, err
+		}
+
+		opts.Dir = filepath.Join(dir, KEY_DIR_NAME)
+	}
+
+	return opts, nil
+}
+
+// generateKey generates a new private key of the given type.
+func generateKey(keyType string) (crypto.Signer, error) {
+	switch keyType {
+	case KEY_TYPE_ED25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	case KEY_TYPE_ECDSA:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KEY_TYPE_RSA:
+		return rsa.GenerateKey(rand.Reader, KEY_RSA_BITS)
+	default:
+		return nil, errors.New(ERR_KEY_TYPE)
+	}
+}
+
+// keyPath returns the path of the key pair kept for host.
+func (o KeyOptions) keyPath(host string) string {
+	// Host names can't contain path separators, but IPv6 addresses contain
+	// colons, which are not allowed in file names on Windows
+	name := strings.NewReplacer(":", "_", "/", "_", "\\", "_").Replace(strings.ToLower(host))
+
+	return filepath.Join(o.Dir, o.Type+"_"+name)
+}
+
+// readKey reads a private key stored with writeKey.
+func readKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ssh.ParseRawPrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// ed25519 keys are returned as pointer
+	if ed, ok := key.(*ed25519.PrivateKey); ok {
+		key = *ed
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New(ERR_KEY_TYPE)
+	}
+
+	return signer, nil
+}
+
+// writeKey stores a private key in OpenSSH format, readable by the user only.
+func writeKey(path string, key crypto.Signer) error {
+	block, err := ssh.MarshalPrivateKey(key, "oinit")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, pem.EncodeToMemory(block), 0600)
+}
+
+// Key returns the private key to request a certificate for host with, as well
+// as the marshalled public key (ssh-ed25519 AAA...). If keys are kept per
+// host, the stored key is returned and a new one is generated and stored only
+// if there is none yet. Otherwise, a fresh key is generated.
+func (o KeyOptions) Key(host string) (crypto.Signer, string, error) {
+	var key crypto.Signer
+	var err error
+
+	if o.Reuse == KEY_REUSE_HOST {
+		path := o.keyPath(host)
+
+		key, err = readKey(path)
+		if errors.Is(err, os.ErrNotExist) {
+			if key, err = generateKey(o.Type); err == nil {
+				err = writeKey(path, key)
+			}
+		}
+	} else {
+		key, err = generateKey(o.Type)
+	}
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	pubkey, err := ssh.NewPublicKey(key.Public())
+	if err != nil {
+		return nil, "", err
+	}
+
+	return key, strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(pubkey)), "\n"), nil
+}
+
+// ForgetKeys deletes the key pairs of all types kept for host. It returns
+// the number of deleted key pairs.
+func (o KeyOptions) ForgetKeys(host string) (int, error) {
+	deleted := 0
+
+	for _, keyType := range []string{KEY_TYPE_ED25519, KEY_TYPE_ECDSA, KEY_TYPE_RSA} {
+		o.Type = keyType
+
+		err := os.Remove(o.keyPath(host))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return deleted, err
+		}
+
+		deleted++
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,90 @@
+package oinit
+
+import (
+	"testing"
+)
+
+func TestKeyOptionsFromEnv(t *testing.T) {
+	t.Setenv(ENV_KEY_TYPE, "")
+	t.Setenv(ENV_KEY_REUSE, "")
+	t.Setenv(ENV_KEY_DIR, "/tmp/keys")
+
+	opts, err := KeyOptionsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.Type != KEY_TYPE_ED25519 || opts.Reuse != KEY_REUSE_CERT || opts.Dir != "/tmp/keys" {
+		t.Errorf("unexpected defaults %+v", opts)
+	}
+
+	t.Setenv(ENV_KEY_TYPE, "dsa")
+	if _, err := KeyOptionsFromEnv(); err == nil {
+		t.Error("expected error for unsupported key type")
+	}
+
+	t.Setenv(ENV_KEY_TYPE, "RSA")
+	t.Setenv(ENV_KEY_REUSE, "always")
+	if _, err := KeyOptionsFromEnv(); err == nil {
+		t.Error("expected error for unsupported key reuse")
+	}
+}
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		keyType string
+		prefix  string
+	}{
+		{KEY_TYPE_ED25519, "ssh-ed25519 "},
+		{KEY_TYPE_ECDSA, "ecdsa-sha2-nistp256 "},
+		{KEY_TYPE_RSA, "ssh-rsa "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.keyType, func(t *testing.T) {
+			perCert := KeyOptions{Type: tt.keyType, Reuse: KEY_REUSE_CERT, Dir: t.TempDir()}
+
+			_, first, err := perCert.Key("host.example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, second, _ := perCert.Key("host.example.com")
+			if first == second {
+				t.Error("expected fresh key per certificate")
+			}
+
+			if len(first) < len(tt.prefix) || first[:len(tt.prefix)] != tt.prefix {
+				t.Errorf("unexpected public key %s", first)
+			}
+
+			perHost := KeyOptions{Type: tt.keyType, Reuse: KEY_REUSE_HOST, Dir: t.TempDir()}
+
+			_, first, err = perHost.Key("[::1]:2222")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, second, err = perHost.Key("[::1]:2222")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if first != second {
+				t.Error("expected key to be reused for host")
+			}
+
+			if _, other, _ := perHost.Key("other.example.com"); other == first {
+				t.Error("expected different key for other host")
+			}
+
+			if deleted, err := perHost.ForgetKeys("[::1]:2222"); err != nil || deleted != 1 {
+				t.Errorf("expected one deleted key, got %d, %v", deleted, err)
+			}
+
+			if _, third, _ := perHost.Key("[::1]:2222"); third == first {
+				t.Error("expected new key after forgetting")
+			}
+		})
+	}
+}
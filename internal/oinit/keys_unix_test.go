@@ -0,0 +1,36 @@
+//go:build !windows
+// +build !windows
+
+package oinit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyPermissionsUnix(t *testing.T) {
+	opts := KeyOptions{Type: KEY_TYPE_ED25519, Reuse: KEY_REUSE_HOST, Dir: filepath.Join(t.TempDir(), "keys")}
+
+	if _, _, err := opts.Key("host.example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := os.Stat(opts.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dir.Mode().Perm() != 0700 {
+		t.Errorf("unexpected mode %s of key directory", dir.Mode().Perm())
+	}
+
+	key, err := os.Stat(opts.keyPath("host.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key.Mode().Perm() != 0600 {
+		t.Errorf("unexpected mode %s of key", key.Mode().Perm())
+	}
+}
@@ -0,0 +1,33 @@
+//go:build windows
+// +build windows
+
+package oinit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKeyPathWindows(t *testing.T) {
+	opts := KeyOptions{Type: KEY_TYPE_ED25519, Reuse: KEY_REUSE_HOST, Dir: t.TempDir()}
+
+	// Colons of IPv6 addresses and ports are not allowed in file names
+	path := opts.keyPath("[fe80::1]:2222")
+	if name := filepath.Base(path); strings.ContainsAny(name, `:\/`) {
+		t.Errorf("invalid file name %s", name)
+	}
+
+	if _, _, err := opts.Key("[fe80::1]:2222"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("key not stored: %v", err)
+	}
+
+	if deleted, err := opts.ForgetKeys("[fe80::1]:2222"); err != nil || deleted != 1 {
+		t.Errorf("expected one deleted key, got %d: %v", deleted, err)
+	}
+}
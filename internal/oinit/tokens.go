@@ -0,0 +1,86 @@
+package oinit
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/secretstore"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	ERR_TOKEN_NO_EXPIRY = "token has no expiry and is not cached"
+
+	// Prefix of secret store keys of cached access tokens
+	TOKEN_KEY_PREFIX = "access-token "
+
+	// Cached tokens expiring within this duration are not used anymore
+	TOKEN_EXPIRY_MARGIN = time.Minute
+)
+
+// tokenKey returns the secret store key of the access token for host at ca.
+func tokenKey(ca, host string) string {
+	return TOKEN_KEY_PREFIX + strings.ToLower(ca) + " " + strings.ToLower(host)
+}
+
+// tokenExpiry returns the expiry time of a JWT access token. The token is not
+// verified, it is only used to decide whether it is worth caching.
+func tokenExpiry(token string) (time.Time, error) {
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	exp, err := parsed.Claims.GetExpirationTime()
+	if err != nil || exp == nil {
+		return time.Time{}, errors.New(ERR_TOKEN_NO_EXPIRY)
+	}
+
+	return exp.Time, nil
+}
+
+// GetCachedToken returns the cached access token for host at ca, or an empty
+// string if none is cached or the cached token is about to expire.
+func GetCachedToken(store secretstore.Store, ca, host string) string {
+	token, err := store.Get(tokenKey(ca, host))
+	if err != nil {
+		return ""
+	}
+
+	if exp, err := tokenExpiry(token); err != nil || time.Until(exp) < TOKEN_EXPIRY_MARGIN {
+		store.Delete(tokenKey(ca, host))
+		return ""
+	}
+
+	return token
+}
+
+// CachedTokenExpiry returns the expiry time of the cached access token for
+// host at ca. The second value is false if no token is cached.
+func CachedTokenExpiry(store secretstore.Store, ca, host string) (time.Time, bool) {
+	token, err := store.Get(tokenKey(ca, host))
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	exp, err := tokenExpiry(token)
+
+	return exp, err == nil
+}
+
+// CacheToken stores the access token for host at ca. Only tokens with an
+// expiry time are cached.
+func CacheToken(store secretstore.Store, ca, host, token string) error {
+	if _, err := tokenExpiry(token); err != nil {
+		return err
+	}
+
+	return store.Set(tokenKey(ca, host), token)
+}
+
+// ForgetToken removes the cached access token for host at ca.
+func ForgetToken(store secretstore.Store, ca, host string) error {
+	return store.Delete(tokenKey(ca, host))
+}
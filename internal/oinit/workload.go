@@ -0,0 +1,53 @@
+package oinit
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/lbrocke/oinit/internal/oidc"
+)
+
+const (
+	// Path of a file containing a token, such as a Kubernetes projected
+	// service account token, which is read on every use as it is rotated
+	ENV_TOKEN_FILE = "OINIT_TOKEN_FILE"
+	// Audience of tokens requested from GitHub Actions, defaults to the CA
+	ENV_TOKEN_AUDIENCE = "OINIT_TOKEN_AUDIENCE"
+
+	ERR_EMPTY_TOKEN_FILE = "token file is empty"
+)
+
+// WorkloadToken returns a workload identity token from the file set in
+// OINIT_TOKEN_FILE or from GitHub Actions, and a description of its source.
+// The token is empty if neither is available. Workload tokens are short-lived
+// and must not be cached.
+func WorkloadToken(ctx context.Context, ca string) (string, string, error) {
+	if path := os.Getenv(ENV_TOKEN_FILE); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", path, err
+		}
+
+		token := strings.TrimSpace(string(data))
+		if token == "" {
+			return "", path, errors.New(ERR_EMPTY_TOKEN_FILE)
+		}
+
+		return token, path, nil
+	}
+
+	if oidc.GitHubActionsAvailable() {
+		audience := os.Getenv(ENV_TOKEN_AUDIENCE)
+		if audience == "" {
+			audience = ca
+		}
+
+		token, err := oidc.GitHubActionsToken(ctx, audience)
+
+		return token, "GitHub Actions", err
+	}
+
+	return "", "", nil
+}
@@ -0,0 +1,110 @@
+// Package sandbox restricts the CA process after it has loaded its keys and
+// configuration, reducing the impact of a compromised handler: privileges are
+// dropped, the root directory is optionally changed, and on Linux, Landlock
+// and seccomp can restrict access to the filesystem and dangerous syscalls.
+package sandbox
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	// Sandboxes that can be enabled, see Parse
+	SANDBOX_NONE     = "none"
+	SANDBOX_LANDLOCK = "landlock"
+	SANDBOX_SECCOMP  = "seccomp"
+
+	ERR_UNKNOWN_SANDBOX = "unknown sandbox"
+	ERR_UNSUPPORTED     = "not supported on this platform"
+)
+
+// ReadPaths contains the files and directories that remain readable with
+// Landlock, as they are needed for DNS resolution, TLS connections to
+// motley_cue and OIDC providers, and time zones. Missing paths are skipped.
+var ReadPaths = []string{
+	"/etc/resolv.conf",
+	"/etc/hosts",
+	"/etc/nsswitch.conf",
+	"/etc/localtime",
+	"/etc/ssl",
+	"/etc/pki",
+	"/etc/ca-certificates",
+	"/usr/share/ca-certificates",
+	"/usr/local/share/ca-certificates",
+	"/usr/share/zoneinfo",
+}
+
+type Options struct {
+	// Name of the user to switch to, empty to keep the current user
+	User string
+	// Directory to change the root to, empty to keep the current root
+	Chroot string
+	// Restrict the filesystem to ReadPaths, ExtraReadPaths and WritePaths
+	Landlock bool
+	// Directories that remain readable with Landlock in addition to
+	// ReadPaths, such as the directories of subject lists
+	ExtraReadPaths []string
+	// Directories that remain writable with Landlock, such as the directory
+	// of the storage file
+	WritePaths []string
+	// Deny syscalls that the CA never needs, such as execve and ptrace
+	Seccomp bool
+}
+
+// Parse parses a comma-separated list of sandboxes, or "none".
+func Parse(value string) (landlock, seccomp bool, err error) {
+	for _, name := range strings.Split(value, ",") {
+		switch strings.TrimSpace(name) {
+		case "", SANDBOX_NONE:
+		case SANDBOX_LANDLOCK:
+			landlock = true
+		case SANDBOX_SECCOMP:
+			seccomp = true
+		default:
+			return false, false, errors.New(ERR_UNKNOWN_SANDBOX + ": " + name)
+		}
+	}
+
+	return landlock, seccomp, nil
+}
+
+// Apply restricts the process according to opts. The user is looked up first,
+// as its database may not be available in the new root. Landlock is applied
+// after changing the root, so its paths are resolved inside of it, and
+// seccomp last, as it denies the syscalls needed by the other steps.
+func Apply(opts Options) error {
+	var creds *credentials
+	if opts.User != "" {
+		var err error
+		if creds, err = lookupUser(opts.User); err != nil {
+			return err
+		}
+	}
+
+	if opts.Chroot != "" {
+		if err := chroot(opts.Chroot); err != nil {
+			return errors.New("chroot: " + err.Error())
+		}
+	}
+
+	if creds != nil {
+		if err := dropPrivileges(*creds); err != nil {
+			return errors.New("dropping privileges: " + err.Error())
+		}
+	}
+
+	if opts.Landlock {
+		if err := landlock(append(ReadPaths, opts.ExtraReadPaths...), opts.WritePaths); err != nil {
+			return errors.New("landlock: " + err.Error())
+		}
+	}
+
+	if opts.Seccomp {
+		if err := seccomp(); err != nil {
+			return errors.New("seccomp: " + err.Error())
+		}
+	}
+
+	return nil
+}
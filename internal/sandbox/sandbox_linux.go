@@ -0,0 +1,216 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Not defined by package unix, see linux/seccomp.h
+	SECCOMP_SET_MODE_FILTER   = 1
+	SECCOMP_FILTER_FLAG_TSYNC = 1
+	SECCOMP_RET_KILL_PROCESS  = 0x80000000
+	SECCOMP_RET_ERRNO         = 0x00050000
+	SECCOMP_RET_ALLOW         = 0x7fff0000
+
+	// Offsets of struct seccomp_data
+	SECCOMP_DATA_NR   = 0
+	SECCOMP_DATA_ARCH = 4
+
+	// Set in the numbers of x32 syscalls, which pass the AUDIT_ARCH_X86_64
+	// check, see linux/unistd.h
+	X32_SYSCALL_BIT = 0x40000000
+
+	// Filesystem access handled by Landlock ABI version 1, which is denied
+	// unless allowed for a path
+	LANDLOCK_ACCESS_FS_V1 = 1<<13 - 1
+
+	LANDLOCK_ACCESS_READ  = unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR
+	LANDLOCK_ACCESS_WRITE = LANDLOCK_ACCESS_READ | unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_REG | unix.LANDLOCK_ACCESS_FS_REMOVE_FILE
+
+	// Access rights that apply to files rather than directories
+	LANDLOCK_ACCESS_FILE = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_WRITE_FILE
+)
+
+// deniedSyscalls are syscalls that the CA never makes, but an attacker would
+// use to escalate a compromised handler.
+var deniedSyscalls = []uint32{
+	unix.SYS_EXECVE,
+	unix.SYS_EXECVEAT,
+	unix.SYS_PTRACE,
+	unix.SYS_PROCESS_VM_READV,
+	unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_MOUNT,
+	unix.SYS_UMOUNT2,
+	unix.SYS_PIVOT_ROOT,
+	unix.SYS_CHROOT,
+	unix.SYS_UNSHARE,
+	unix.SYS_SETNS,
+	unix.SYS_INIT_MODULE,
+	unix.SYS_FINIT_MODULE,
+	unix.SYS_DELETE_MODULE,
+	unix.SYS_KEXEC_LOAD,
+	unix.SYS_BPF,
+	unix.SYS_PERF_EVENT_OPEN,
+	unix.SYS_USERFAULTFD,
+	unix.SYS_KEYCTL,
+	unix.SYS_ADD_KEY,
+	unix.SYS_REQUEST_KEY,
+	unix.SYS_SWAPON,
+	unix.SYS_SWAPOFF,
+	unix.SYS_REBOOT,
+}
+
+// auditArchs maps architectures to their AUDIT_ARCH value, which seccomp
+// filters must check as syscall numbers differ between architectures.
+var auditArchs = map[string]uint32{
+	"amd64": unix.AUDIT_ARCH_X86_64,
+	"arm64": unix.AUDIT_ARCH_AARCH64,
+	"386":   unix.AUDIT_ARCH_I386,
+	"arm":   unix.AUDIT_ARCH_ARM,
+}
+
+// allThreads makes a syscall on all threads of the process, as Landlock and
+// no_new_privs only apply to the calling thread. This fails in binaries built
+// with cgo.
+func allThreads(trap, a1, a2, a3 uintptr) error {
+	if _, _, errno := syscall.AllThreadsSyscall(trap, a1, a2, a3); errno != 0 {
+		if errno == syscall.ENOTSUP {
+			return errors.New("requires a build with CGO_ENABLED=0")
+		}
+
+		return errno
+	}
+
+	return nil
+}
+
+func noNewPrivs() error {
+	return allThreads(unix.SYS_PRCTL, unix.PR_SET_NO_NEW_PRIVS, 1, 0)
+}
+
+// landlock restricts the filesystem access of the process to reading
+// readPaths and writing to writePaths.
+func landlock(readPaths, writePaths []string) error {
+	if abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION); errno != 0 || abi < 1 {
+		return errors.New("not supported by the kernel")
+	}
+
+	attr := unix.LandlockRulesetAttr{Access_fs: LANDLOCK_ACCESS_FS_V1}
+
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return errno
+	}
+	defer unix.Close(int(fd))
+
+	for _, rule := range []struct {
+		paths  []string
+		access uint64
+	}{{readPaths, LANDLOCK_ACCESS_READ}, {writePaths, LANDLOCK_ACCESS_WRITE}} {
+		for _, path := range rule.paths {
+			if err := landlockAllow(int(fd), path, rule.access); err != nil {
+				return errors.New(path + ": " + err.Error())
+			}
+		}
+	}
+
+	if err := noNewPrivs(); err != nil {
+		return err
+	}
+
+	return allThreads(unix.SYS_LANDLOCK_RESTRICT_SELF, fd, 0, 0)
+}
+
+// landlockAllow adds a rule allowing access beneath path to the ruleset.
+// Missing paths are skipped.
+func landlockAllow(ruleset int, path string, access uint64) error {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		access &= LANDLOCK_ACCESS_FILE
+	}
+
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	attr := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(fd)}
+
+	if _, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset), unix.LANDLOCK_RULE_PATH_BENEATH,
+		uintptr(unsafe.Pointer(&attr)), 0, 0, 0); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// seccompFilter returns a BPF program that kills the process on syscalls of
+// other architectures, fails denied syscalls with EPERM and allows all others.
+// On x86_64, all x32 syscalls are denied, as they would bypass the deny list.
+func seccompFilter(arch uint32, denied []uint32) []unix.SockFilter {
+	filter := []unix.SockFilter{
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: SECCOMP_DATA_ARCH},
+		{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 1, K: arch},
+		{Code: unix.BPF_RET | unix.BPF_K, K: SECCOMP_RET_KILL_PROCESS},
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: SECCOMP_DATA_NR},
+	}
+
+	if arch == unix.AUDIT_ARCH_X86_64 {
+		// Jump over the comparisons and ALLOW to ERRNO
+		filter = append(filter, unix.SockFilter{
+			Code: unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K,
+			Jt:   uint8(len(denied) + 1),
+			K:    X32_SYSCALL_BIT,
+		})
+	}
+
+	for i, nr := range denied {
+		// Jump over the remaining comparisons and ALLOW to ERRNO
+		filter = append(filter, unix.SockFilter{
+			Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K,
+			Jt:   uint8(len(denied) - i),
+			K:    nr,
+		})
+	}
+
+	return append(filter,
+		unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: SECCOMP_RET_ALLOW},
+		unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: SECCOMP_RET_ERRNO | uint32(unix.EPERM)},
+	)
+}
+
+// seccomp installs a filter denying deniedSyscalls on all threads.
+func seccomp() error {
+	arch, ok := auditArchs[runtime.GOARCH]
+	if !ok {
+		return errors.New(ERR_UNSUPPORTED)
+	}
+
+	filter := seccompFilter(arch, deniedSyscalls)
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+
+	if err := noNewPrivs(); err != nil {
+		return err
+	}
+
+	if _, _, errno := unix.Syscall(unix.SYS_SECCOMP, SECCOMP_SET_MODE_FILTER, SECCOMP_FILTER_FLAG_TSYNC, uintptr(unsafe.Pointer(&prog))); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
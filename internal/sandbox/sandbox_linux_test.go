@@ -0,0 +1,61 @@
+package sandbox
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+// run evaluates the seccomp filter for a syscall, supporting only the
+// instructions generated by seccompFilter.
+func run(filter []unix.SockFilter, arch, nr uint32) uint32 {
+	var acc uint32
+
+	for pc := 0; pc < len(filter); pc++ {
+		ins := filter[pc]
+
+		switch ins.Code {
+		case unix.BPF_LD | unix.BPF_W | unix.BPF_ABS:
+			if ins.K == SECCOMP_DATA_ARCH {
+				acc = arch
+			} else {
+				acc = nr
+			}
+		case unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K:
+			if acc == ins.K {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		case unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K:
+			if acc >= ins.K {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		case unix.BPF_RET | unix.BPF_K:
+			return ins.K
+		}
+	}
+
+	panic("filter does not return")
+}
+
+func TestSeccompFilter(t *testing.T) {
+	filter := seccompFilter(unix.AUDIT_ARCH_X86_64, []uint32{59, 101})
+
+	assert.Equal(t, uint32(SECCOMP_RET_ERRNO|unix.EPERM), run(filter, unix.AUDIT_ARCH_X86_64, 59))
+	assert.Equal(t, uint32(SECCOMP_RET_ERRNO|unix.EPERM), run(filter, unix.AUDIT_ARCH_X86_64, 101))
+	assert.Equal(t, uint32(SECCOMP_RET_ALLOW), run(filter, unix.AUDIT_ARCH_X86_64, 0))
+	assert.Equal(t, uint32(SECCOMP_RET_KILL_PROCESS), run(filter, unix.AUDIT_ARCH_I386, 0))
+
+	// x32 syscalls are denied, whether on the deny list or not
+	assert.Equal(t, uint32(SECCOMP_RET_ERRNO|unix.EPERM), run(filter, unix.AUDIT_ARCH_X86_64, X32_SYSCALL_BIT|59))
+	assert.Equal(t, uint32(SECCOMP_RET_ERRNO|unix.EPERM), run(filter, unix.AUDIT_ARCH_X86_64, X32_SYSCALL_BIT))
+
+	// Other architectures have no x32 check
+	filter = seccompFilter(unix.AUDIT_ARCH_AARCH64, []uint32{221})
+	assert.Equal(t, uint32(SECCOMP_RET_ERRNO|unix.EPERM), run(filter, unix.AUDIT_ARCH_AARCH64, 221))
+	assert.Equal(t, uint32(SECCOMP_RET_ALLOW), run(filter, unix.AUDIT_ARCH_AARCH64, X32_SYSCALL_BIT))
+}
@@ -0,0 +1,15 @@
+//go:build unix && !linux
+
+package sandbox
+
+import (
+	"errors"
+)
+
+func landlock(readPaths, writePaths []string) error {
+	return errors.New(ERR_UNSUPPORTED)
+}
+
+func seccomp() error {
+	return errors.New(ERR_UNSUPPORTED)
+}
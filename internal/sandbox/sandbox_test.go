@@ -0,0 +1,22 @@
+package sandbox
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	landlock, seccomp, err := Parse("landlock, seccomp")
+	assert.NoError(t, err)
+	assert.True(t, landlock)
+	assert.True(t, seccomp)
+
+	landlock, seccomp, err = Parse(SANDBOX_NONE)
+	assert.NoError(t, err)
+	assert.False(t, landlock)
+	assert.False(t, seccomp)
+
+	_, _, err = Parse("landlock,apparmor")
+	assert.Error(t, err)
+}
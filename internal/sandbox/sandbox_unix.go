@@ -0,0 +1,72 @@
+//go:build unix
+
+package sandbox
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+type credentials struct {
+	uid    int
+	gid    int
+	groups []int
+}
+
+func lookupUser(name string) (*credentials, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	creds := &credentials{}
+	if creds.uid, err = strconv.Atoi(u.Uid); err != nil {
+		return nil, err
+	}
+	if creds.gid, err = strconv.Atoi(u.Gid); err != nil {
+		return nil, err
+	}
+
+	groups, err := u.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, group := range groups {
+		gid, err := strconv.Atoi(group)
+		if err != nil {
+			return nil, err
+		}
+
+		creds.groups = append(creds.groups, gid)
+	}
+
+	return creds, nil
+}
+
+func chroot(dir string) error {
+	if err := unix.Chroot(dir); err != nil {
+		return err
+	}
+
+	return os.Chdir("/")
+}
+
+// dropPrivileges switches to the given user. The group is changed first, as
+// the user would not be allowed to. Package syscall applies the changes to
+// all threads of the process.
+func dropPrivileges(creds credentials) error {
+	if err := syscall.Setgroups(creds.groups); err != nil {
+		return err
+	}
+
+	if err := syscall.Setgid(creds.gid); err != nil {
+		return err
+	}
+
+	return syscall.Setuid(creds.uid)
+}
@@ -0,0 +1,31 @@
+//go:build windows
+
+package sandbox
+
+import (
+	"errors"
+)
+
+// Windows has neither users that a process can switch to, nor chroot,
+// Landlock or seccomp, so the CA must be restricted by the service manager.
+type credentials struct{}
+
+func lookupUser(name string) (*credentials, error) {
+	return nil, errors.New(ERR_UNSUPPORTED)
+}
+
+func chroot(dir string) error {
+	return errors.New(ERR_UNSUPPORTED)
+}
+
+func dropPrivileges(creds credentials) error {
+	return errors.New(ERR_UNSUPPORTED)
+}
+
+func landlock(readPaths, writePaths []string) error {
+	return errors.New(ERR_UNSUPPORTED)
+}
+
+func seccomp() error {
+	return errors.New(ERR_UNSUPPORTED)
+}
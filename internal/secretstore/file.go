@@ -0,0 +1,223 @@
+package secretstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	ERR_NO_PASSPHRASE  = "no passphrase for the secrets file set in " + ENV_PASSPHRASE
+	ERR_BAD_FILE       = "secrets file is malformed"
+	ERR_BAD_PASSPHRASE = "wrong passphrase or secrets file was modified"
+
+	// Environment variable containing the passphrase of the secrets file
+	ENV_PASSPHRASE = "OINIT_SECRETS_PASSPHRASE"
+
+	// Name of the secrets file in the user's config directory
+	FILE_NAME = "secrets"
+
+	// Magic bytes at the start of the secrets file
+	fileMagic = "OINITSS\x00"
+
+	saltSize = 16
+
+	// scrypt parameters as recommended for interactive logins in 2017, see
+	// https://pkg.go.dev/golang.org/x/crypto/scrypt#Key
+	scryptN = 32768
+	scryptR = 8
+	scryptP = 1
+)
+
+// FileStore stores secrets in a single file encrypted with AES-GCM, using a
+// key derived from a passphrase with scrypt.
+type FileStore struct {
+	mu         sync.Mutex
+	path       string
+	passphrase string
+}
+
+// NewFileStore returns a file store using the given path, or the file
+// "oinit/secrets" in the user's config directory if path is empty. The
+// passphrase is read from OINIT_SECRETS_PASSPHRASE.
+func NewFileStore(path string) (*FileStore, error) {
+	passphrase := os.Getenv(ENV_PASSPHRASE)
+	if passphrase == "" {
+		return nil, errors.New(ERR_NO_PASSPHRASE)
+	}
+
+	if path == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, err
+		}
+
+		path = filepath.Join(dir, SERVICE, FILE_NAME)
+	}
+
+	return &FileStore{
+		path:       path,
+		passphrase: passphrase,
+	}, nil
+}
+
+func (s *FileStore) Name() string {
+	return "encrypted file " + s.path
+}
+
+func (s *FileStore) Get(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	secrets, err := s.read()
+	if err != nil {
+		return "", err
+	}
+
+	secret, ok := secrets[key]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+
+	return secret, nil
+}
+
+func (s *FileStore) Set(key, secret string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	secrets, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	secrets[key] = secret
+
+	return s.write(secrets)
+}
+
+func (s *FileStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	secrets, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := secrets[key]; !ok {
+		return nil
+	}
+
+	delete(secrets, key)
+
+	return s.write(secrets)
+}
+
+// read decrypts the secrets file. A missing file is treated as empty.
+func (s *FileStore) read() (map[string]string, error) {
+	secrets := make(map[string]string)
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return secrets, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if len(data) < len(fileMagic)+saltSize || string(data[:len(fileMagic)]) != fileMagic {
+		return nil, errors.New(ERR_BAD_FILE)
+	}
+
+	aead, err := newAEAD(s.passphrase, data[len(fileMagic):len(fileMagic)+saltSize])
+	if err != nil {
+		return nil, err
+	}
+
+	headerSize := len(fileMagic) + saltSize + aead.NonceSize()
+	if len(data) < headerSize {
+		return nil, errors.New(ERR_BAD_FILE)
+	}
+
+	plaintext, err := aead.Open(nil, data[len(fileMagic)+saltSize:headerSize], data[headerSize:], data[:headerSize])
+	if err != nil {
+		return nil, errors.New(ERR_BAD_PASSPHRASE)
+	}
+
+	if json.Unmarshal(plaintext, &secrets) != nil {
+		return nil, errors.New(ERR_BAD_FILE)
+	}
+
+	return secrets, nil
+}
+
+// write encrypts the secrets with a fresh salt and nonce and atomically
+// replaces the secrets file.
+func (s *FileStore) write(secrets map[string]string) error {
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+
+	aead, err := newAEAD(s.passphrase, salt)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	out := append([]byte(fileMagic), salt...)
+	out = append(out, nonce...)
+	out = aead.Seal(out, nonce, plaintext, out)
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), "."+FILE_NAME+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(out); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func newAEAD(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,52 @@
+package secretstore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets")
+
+	t.Setenv(ENV_PASSPHRASE, "correct horse battery staple")
+
+	store, err := NewFileStore(path)
+	assert.NoError(t, err)
+
+	_, err = store.Get("key")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.NoError(t, store.Set("key", "secret"))
+	assert.NoError(t, store.Set("other", "value"))
+
+	secret, err := store.Get("key")
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", secret)
+
+	// The file must not contain the secret in plaintext.
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "secret")
+
+	assert.NoError(t, store.Delete("key"))
+	assert.NoError(t, store.Delete("key"))
+
+	_, err = store.Get("key")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	t.Setenv(ENV_PASSPHRASE, "wrong")
+
+	store, err = NewFileStore(path)
+	assert.NoError(t, err)
+
+	_, err = store.Get("other")
+	assert.EqualError(t, err, ERR_BAD_PASSPHRASE)
+
+	t.Setenv(ENV_PASSPHRASE, "")
+
+	_, err = NewFileStore(path)
+	assert.EqualError(t, err, ERR_NO_PASSPHRASE)
+}
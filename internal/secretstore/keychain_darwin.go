@@ -0,0 +1,81 @@
+//go:build darwin
+// +build darwin
+
+package secretstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const ERR_LINE_BREAK = "key or secret contains a line break"
+
+// securityKeychain stores secrets in the macOS Keychain using security(1).
+type securityKeychain struct{}
+
+func newKeychain() (Store, error) {
+	if _, err := exec.LookPath("security"); err != nil {
+		return nil, errors.New(ERR_UNAVAILABLE)
+	}
+
+	return securityKeychain{}, nil
+}
+
+func (securityKeychain) Name() string {
+	return "macOS Keychain"
+}
+
+func (securityKeychain) Get(key string) (string, error) {
+	out, err := exec.Command("security", "find-generic-password",
+		"-s", SERVICE, "-a", key, "-w").Output()
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+func (securityKeychain) Set(key, secret string) error {
+	// security(1) reads one command per line in interactive mode
+	if strings.ContainsAny(key+secret, "\r\n") {
+		return errors.New(ERR_LINE_BREAK)
+	}
+
+	// The command is read from stdin, so the secret doesn't show up in the
+	// process list. -U updates an existing item instead of failing.
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader("add-generic-password -U -s " + quote(SERVICE) +
+		" -a " + quote(key) + " -w " + quote(secret) + "\n")
+
+	// Failing commands don't necessarily fail the interactive mode, but
+	// add-generic-password only prints errors
+	out, err := cmd.CombinedOutput()
+	if err == nil && len(bytes.TrimSpace(out)) > 0 {
+		err = errors.New(strings.TrimSpace(string(out)))
+	}
+
+	return err
+}
+
+func (securityKeychain) Delete(key string) error {
+	if _, err := exec.Command("security", "delete-generic-password",
+		"-s", SERVICE, "-a", key).Output(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			// Item doesn't exist
+			return nil
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// quote quotes s as a single argument for the interactive mode of security(1).
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
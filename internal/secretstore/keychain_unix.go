@@ -0,0 +1,56 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+package secretstore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// secretToolKeychain stores secrets in the Secret Service (such as GNOME
+// Keyring or KWallet) using secret-tool(1) of libsecret.
+type secretToolKeychain struct{}
+
+func newKeychain() (Store, error) {
+	if os.Getenv("DBUS_SESSION_BUS_ADDRESS") == "" {
+		return nil, errors.New(ERR_UNAVAILABLE)
+	}
+
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return nil, errors.New(ERR_UNAVAILABLE)
+	}
+
+	return secretToolKeychain{}, nil
+}
+
+func (secretToolKeychain) Name() string {
+	return "Secret Service"
+}
+
+func (secretToolKeychain) Get(key string) (string, error) {
+	out, err := exec.Command("secret-tool", "lookup", "service", SERVICE, "key", key).Output()
+	if err != nil || len(out) == 0 {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+func (secretToolKeychain) Set(key, secret string) error {
+	// The secret is read from stdin, so it doesn't show up in the process list.
+	cmd := exec.Command("secret-tool", "store", "--label", SERVICE+": "+key, "service", SERVICE, "key", key)
+	cmd.Stdin = strings.NewReader(secret)
+
+	return cmd.Run()
+}
+
+func (secretToolKeychain) Delete(key string) error {
+	// secret-tool exits with an error if nothing was deleted, which is fine.
+	exec.Command("secret-tool", "clear", "service", SERVICE, "key", key).Run()
+
+	return nil
+}
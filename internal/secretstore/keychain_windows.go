@@ -0,0 +1,121 @@
+//go:build windows
+// +build windows
+
+package secretstore
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	credTypeGeneric         = 1
+	credPersistLocalMachine = 2
+
+	errorNotFound = syscall.Errno(1168)
+)
+
+var (
+	advapi32       = syscall.NewLazyDLL("advapi32.dll")
+	procCredRead   = advapi32.NewProc("CredReadW")
+	procCredWrite  = advapi32.NewProc("CredWriteW")
+	procCredDelete = advapi32.NewProc("CredDeleteW")
+	procCredFree   = advapi32.NewProc("CredFree")
+)
+
+// credential is the CREDENTIALW structure, see
+// https://learn.microsoft.com/en-us/windows/win32/api/wincred/ns-wincred-credentialw
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// credentialManager stores secrets in the Windows Credential Manager.
+type credentialManager struct{}
+
+func newKeychain() (Store, error) {
+	if advapi32.Load() != nil {
+		return nil, errors.New(ERR_UNAVAILABLE)
+	}
+
+	return credentialManager{}, nil
+}
+
+func targetName(key string) (*uint16, error) {
+	return syscall.UTF16PtrFromString(SERVICE + ":" + key)
+}
+
+func (credentialManager) Name() string {
+	return "Windows Credential Manager"
+}
+
+func (credentialManager) Get(key string) (string, error) {
+	target, err := targetName(key)
+	if err != nil {
+		return "", err
+	}
+
+	var cred *credential
+
+	if ret, _, _ := procCredRead.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0,
+		uintptr(unsafe.Pointer(&cred))); ret == 0 {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	return string(unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)), nil
+}
+
+func (credentialManager) Set(key, secret string) error {
+	target, err := targetName(key)
+	if err != nil {
+		return err
+	}
+
+	blob := []byte(secret)
+
+	cred := credential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		CredentialBlobSize: uint32(len(blob)),
+		Persist:            credPersistLocalMachine,
+	}
+	if len(blob) > 0 {
+		cred.CredentialBlob = &blob[0]
+	}
+
+	if ret, _, err := procCredWrite.Call(uintptr(unsafe.Pointer(&cred)), 0); ret == 0 {
+		return err
+	}
+
+	return nil
+}
+
+func (credentialManager) Delete(key string) error {
+	target, err := targetName(key)
+	if err != nil {
+		return err
+	}
+
+	if ret, _, err := procCredDelete.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0); ret == 0 {
+		if errors.Is(err, errorNotFound) {
+			return nil
+		}
+
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+// Package secretstore stores secrets of the client, such as cached access
+// tokens, in the keychain of the operating system (macOS Keychain, Windows
+// Credential Manager or the Secret Service via libsecret). If no keychain is
+// available, secrets are stored in a file encrypted with a passphrase taken
+// from the environment. Secrets are never stored in plaintext files.
+package secretstore
+
+import (
+	"errors"
+	"os"
+)
+
+const (
+	ERR_NOT_FOUND       = "secret not found"
+	ERR_UNAVAILABLE     = "no secret store available"
+	ERR_UNKNOWN_BACKEND = "unknown secret store backend"
+
+	// Service name that secrets are stored under in the keychain
+	SERVICE = "oinit"
+
+	// Environment variable to select a backend, see Open
+	ENV_BACKEND = "OINIT_SECRET_STORE"
+
+	BACKEND_KEYCHAIN = "keychain"
+	BACKEND_FILE     = "file"
+	BACKEND_NONE     = "none"
+)
+
+// ErrNotFound is returned, wrapped with the key, if no secret is stored under
+// a key.
+var ErrNotFound = errors.New(ERR_NOT_FOUND)
+
+// Store is a key-value store for secrets.
+type Store interface {
+	// Get returns the secret stored under key, or an error wrapping
+	// ErrNotFound if there is none.
+	Get(key string) (string, error)
+	// Set stores the secret under key, replacing any previous secret.
+	Set(key, secret string) error
+	// Delete removes the secret stored under key. Deleting a secret that
+	// doesn't exist is not an error.
+	Delete(key string) error
+	// Name returns a human readable name of the backend.
+	Name() string
+}
+
+// Open returns the secret store selected by the OINIT_SECRET_STORE
+// environment variable, which is one of "keychain", "file" or "none". If not
+// set, the keychain is used if available, otherwise the encrypted file.
+func Open() (Store, error) {
+	switch backend := os.Getenv(ENV_BACKEND); backend {
+	case BACKEND_KEYCHAIN:
+		return newKeychain()
+	case BACKEND_FILE:
+		return NewFileStore("")
+	case BACKEND_NONE:
+		return nil, errors.New(ERR_UNAVAILABLE)
+	case "":
+		if store, err := newKeychain(); err == nil {
+			return store, nil
+		}
+
+		return NewFileStore("")
+	default:
+		return nil, errors.New(ERR_UNKNOWN_BACKEND)
+	}
+}
@@ -0,0 +1,248 @@
+// Package server creates the handlers serving the routes of oinit-ca, which
+// are used by the serve and lambda commands and package oinitcaserver.
+package server
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	docs "github.com/lbrocke/oinit/api/docs"
+	"github.com/lbrocke/oinit/internal/api"
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/ntp"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	SWAGGER_TITLE = "oinit CA API"
+	SWAGGER_DESC  = "Swagger documentation for the oinit CA REST API."
+)
+
+// ConfigMiddleware is a middleware function that attaches a configuration object
+// to the Gin context. This allows handlers downstream to access the configuration.
+func ConfigMiddleware(config config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("config", config)
+		c.Next()
+	}
+}
+
+// StoreMiddleware attaches the storage backend to the Gin context.
+func StoreMiddleware(store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("store", store)
+		c.Next()
+	}
+}
+
+// ClockMiddleware attaches the clock monitor to the Gin context, which is nil
+// if NTP checks are disabled.
+func ClockMiddleware(monitor *ntp.Monitor) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("clock", monitor)
+		c.Next()
+	}
+}
+
+// OpenStore opens the storage backend of the config. If the CA is a replica,
+// its replicated storage file is synced every replica-sync-interval in the
+// background until the replica is promoted.
+func OpenStore(cfg config.Config) (storage.Store, error) {
+	opts := append(cfg.Server.StorageOptions(), storage.WithLogger(api.Logger))
+
+	if !cfg.Server.Replica {
+		return storage.Open(cfg.Server.Storage, opts...)
+	}
+
+	replica, err := storage.NewReplicaStore(storage.Path(cfg.Server.Storage), cfg.Server.PromoteFile, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if replica.Promoted() {
+		api.Logger.Println("Warning: replica was promoted by " + cfg.Server.PromoteFile + ", remove replica from the config")
+	} else {
+		api.Logger.Println("Running as replica, certificates are not signed until the replica is promoted")
+		go syncReplica(replica, time.Duration(cfg.Server.ReplicaSyncInterval)*time.Second)
+	}
+
+	return replica, nil
+}
+
+// syncReplica syncs the replica every interval until it is promoted or
+// closed.
+func syncReplica(replica *storage.ReplicaStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-replica.Done():
+			return
+		}
+
+		if err := replica.Sync(); err != nil {
+			api.Logger.Println("Could not sync replica: " + err.Error())
+		}
+
+		if promotion, ok := replica.Promotion(); ok {
+			api.Logger.Println("Replica was promoted to primary CA by " + promotion.By)
+			return
+		}
+	}
+}
+
+// DefaultListener returns the listener for the address and mode, which is
+// used if the config declares no listeners or they don't apply.
+func DefaultListener(cfg config.Config, addr string, socketMode os.FileMode, mode string) config.Listener {
+	return config.Listener{
+		Name:           "default",
+		Address:        addr,
+		Mode:           mode,
+		Perm:           socketMode,
+		RequestTimeout: cfg.Server.RequestTimeout,
+		Middleware:     cfg.Server.Middleware,
+		Middlewares:    cfg.Server.MiddlewareChain(),
+	}
+}
+
+// InitSwagger sets the information of the API documentation that swag
+// doesn't take from annotations.
+func InitSwagger() {
+	docs.SwaggerInfo.Version = api.API_VERSION
+	docs.SwaggerInfo.BasePath = "/api/v1"
+	docs.SwaggerInfo.Title = SWAGGER_TITLE
+	docs.SwaggerInfo.Description = SWAGGER_DESC
+}
+
+// NewHandler returns the handler serving the routes of the listener under the
+// base path of the config.
+func NewHandler(cfg config.Config, store storage.Store, monitor *ntp.Monitor, l config.Listener) http.Handler {
+	return api.StripBasePath(cfg.Server.BasePath, NewRouter(cfg, store, monitor, l))
+}
+
+// NewRouter returns a router serving the routes of the mode of the
+// listener. Health is served in all modes, the API documentation in all but
+// the health mode.
+func NewRouter(cfg config.Config, store storage.Store, monitor *ntp.Monitor, l config.Listener) *gin.Engine {
+	mode := l.Mode
+
+	router := gin.New()
+	// Validated when loading the config. Without trusted proxies, clients
+	// are identified by the address they connect from.
+	router.SetTrustedProxies(cfg.Server.Proxies())
+	router.Use(gin.Recovery())
+	router.Use(ConfigMiddleware(cfg))
+	router.Use(StoreMiddleware(store))
+	router.Use(ClockMiddleware(monitor))
+	router.Use(api.RequestID)
+	router.Use(api.BasePath(cfg.Server.BasePath, cfg.Server.Proxies()))
+	router.Use(api.SecurityHeaders(cfg.Server.HSTSMaxAge, cfg.Server.Proxies()))
+
+	// Optional middleware in the configured order
+	for _, name := range l.Middlewares {
+		switch name {
+		case config.MIDDLEWARE_LOGGER:
+			router.Use(gin.Logger())
+		case config.MIDDLEWARE_RATE_LIMIT:
+			router.Use(api.RateLimit(cfg.Server.RateLimit))
+		case config.MIDDLEWARE_CORS:
+			router.Use(api.CORS(cfg.Server.CORSOrigins()))
+		case config.MIDDLEWARE_GZIP:
+			router.Use(api.Gzip())
+		case config.MIDDLEWARE_TARPIT:
+			router.Use(api.Tarpit(cfg.Server.TarpitThreshold, cfg.Server.TarpitMaxDelay, cfg.Server.TarpitAlert))
+		case config.MIDDLEWARE_SERVER_TIMING:
+			router.Use(api.ServerTiming())
+		}
+	}
+
+	router.Use(api.Timeout(time.Duration(l.RequestTimeout) * time.Second))
+
+	// Validated when loading the config
+	if injector, _ := cfg.Server.Faults(); injector != nil {
+		api.Logger.Printf("Warning: injecting faults into %s (latency up to %s, error rate %g), do not use in production", strings.Join(injector.Targets, ", "), injector.Latency, injector.ErrorRate)
+		router.Use(api.Faults(injector))
+	}
+
+	gAPI := router.Group("/api")
+	{
+		if mode != config.LISTEN_MODE_HEALTH {
+			gAPI.GET("/docs/*any", api.GetSwagger)
+		}
+
+		v1 := gAPI.Group("/v1")
+		v1.GET("/health", api.GetHealth)
+
+		if mode == config.LISTEN_MODE_HEALTH {
+			v1.GET("/metrics", api.GetAdminMetrics)
+		}
+
+		if mode == config.LISTEN_MODE_API || mode == config.LISTEN_MODE_ALL {
+			v1.GET("/", api.GetIndex)
+			v1.GET("/trust-bundle", api.GetTrustBundle)
+			v1.GET("/stats", api.GetStats)
+			v1.GET("/:host", api.GetHost)
+			// Although from the client perspective this route _gets_ a certificate, it
+			//  a) generates a new certificate every time (and thus is not cacheable), and
+			//  b) must accept an access token (which is a sensitive information better
+			//     transmitted in the request body, not as query parameter).
+			// Therefore this route uses the POST method rather then GET.
+			v1.POST("/:host/certificate",
+				api.NoStore,
+				api.RequirePrimary,
+				api.RequireFeature(config.FEATURE_DRY_RUN),
+				api.RequireFeature(config.FEATURE_BUNDLE),
+				api.Async(api.PostHostCertificate))
+			v1.GET("/requests/:id", api.NoStore, api.GetRequest)
+			v1.GET("/:host/status", api.NoStore, api.GetHostStatus)
+			v1.POST("/:host/revoke", api.NoStore, api.RequirePrimary, api.PostHostRevoke)
+			v1.POST("/:host/enroll", api.NoStore, api.RequirePrimary, api.PostHostEnroll)
+			v1.GET("/:host/enroll/:id", api.NoStore, api.GetHostEnrollment)
+			v1.POST("/:host/renew", api.NoStore, api.RequirePrimary, api.PostHostRenew)
+			v1.POST("/:host/emergency", api.NoStore, api.RequirePrimary, api.PostHostEmergency)
+			v1.GET("/:host/krl", api.RequireFeature(config.FEATURE_KRL), api.GetHostKRL)
+			v1.GET("/:host/hostkeys", api.RequireFeature(config.FEATURE_HOST_KEYS), api.GetHostKeys)
+			v1.POST("/:host/hostkeys", api.RequireFeature(config.FEATURE_HOST_KEYS), api.RequirePrimary, api.PostHostKeys)
+		}
+
+		if mode == config.LISTEN_MODE_ADMIN || mode == config.LISTEN_MODE_ALL {
+			admin := v1.Group("/admin", api.NoStore, api.AdminAuth)
+			{
+				admin.GET("/whoami", api.GetAdminIdentity)
+				admin.GET("/hostgroups", api.RequirePermission(api.PERM_VIEW), api.GetAdminHostGroups)
+				admin.GET("/upstreams", api.RequirePermission(api.PERM_VIEW), api.GetAdminUpstreams)
+				admin.GET("/certificates", api.RequirePermission(api.PERM_VIEW), api.GetAdminCertificates)
+				admin.GET("/certificates/:serial", api.RequirePermission(api.PERM_VIEW), api.GetAdminCertificate)
+				admin.POST("/certificates/:serial/revoke", api.RequirePermission(api.PERM_REVOKE), api.RequirePrimary, api.PostAdminRevoke)
+				admin.GET("/audit", api.RequirePermission(api.PERM_AUDIT), api.GetAdminAudit)
+				admin.GET("/dns", api.RequirePermission(api.PERM_VIEW), api.GetAdminDNS)
+				admin.GET("/vos", api.RequirePermission(api.PERM_VIEW), api.GetAdminVOs)
+				admin.GET("/decisions/:request_id", api.RequirePermission(api.PERM_AUDIT), api.GetAdminDecision)
+				admin.GET("/metrics", api.RequirePermission(api.PERM_VIEW), api.GetAdminMetrics)
+				admin.GET("/deployments", api.RequirePermission(api.PERM_VIEW), api.GetAdminDeployments)
+				admin.GET("/enrollments", api.RequirePermission(api.PERM_VIEW), api.GetAdminEnrollments)
+				admin.POST("/enrollments/:id/approve", api.RequirePermission(api.PERM_ENROLL), api.RequirePrimary, api.PostAdminEnrollmentApprove)
+				admin.POST("/enrollments/:id/reject", api.RequirePermission(api.PERM_ENROLL), api.RequirePrimary, api.PostAdminEnrollmentReject)
+				admin.GET("/freezes", api.RequirePermission(api.PERM_VIEW), api.GetAdminFreezes)
+				admin.PUT("/hostgroups/:name/freeze", api.RequirePermission(api.PERM_FREEZE), api.RequirePrimary, api.PutAdminFreeze)
+				admin.DELETE("/hostgroups/:name/freeze", api.RequirePermission(api.PERM_FREEZE), api.RequirePrimary, api.DeleteAdminFreeze)
+				admin.POST("/replica/promote", api.RequirePermission(api.PERM_PROMOTE), api.PostAdminPromote)
+			}
+		}
+	}
+
+	if mode == config.LISTEN_MODE_ADMIN || mode == config.LISTEN_MODE_ALL {
+		router.GET("/admin", func(c *gin.Context) {
+			c.Redirect(http.StatusMovedPermanently, c.GetString("base_path")+"/admin/")
+		})
+		router.GET("/admin/", api.ContentSecurityPolicy(cfg.Server.AdminCSP), api.GetAdminUI)
+	}
+
+	return router
+}
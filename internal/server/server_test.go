@@ -0,0 +1,38 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRouterModes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := config.Config{Server: config.ServerOptions{RequestTimeout: config.DEFAULT_REQUEST_TIMEOUT}}
+
+	status := func(mode, path string) int {
+		router := NewRouter(cfg, storage.NewMemoryStore(), nil, DefaultListener(cfg, "", 0, mode))
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+		return w.Code
+	}
+
+	// Health listeners serve metrics without authentication, but nothing
+	// else
+	assert.Equal(t, http.StatusOK, status(config.LISTEN_MODE_HEALTH, "/api/v1/metrics"))
+	assert.Equal(t, http.StatusNotFound, status(config.LISTEN_MODE_HEALTH, "/api/v1/"))
+	assert.Equal(t, http.StatusNotFound, status(config.LISTEN_MODE_HEALTH, "/api/v1/admin/metrics"))
+
+	assert.Equal(t, http.StatusNotFound, status(config.LISTEN_MODE_API, "/api/v1/metrics"))
+	assert.Equal(t, http.StatusForbidden, status(config.LISTEN_MODE_ADMIN, "/api/v1/admin/metrics"))
+	assert.Equal(t, http.StatusNotFound, status(config.LISTEN_MODE_ADMIN, "/api/v1/trust-bundle"))
+}
@@ -0,0 +1,357 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// Enrollments that may be pending per host, further requests are
+	// rejected until an admin decided on them
+	MAX_PENDING_ENROLLMENTS = 5
+	// Enrollments that may be pending per hostgroup, as hostgroups with
+	// wildcards would otherwise accept unlimited host names
+	MAX_PENDING_ENROLLMENTS_PER_HOSTGROUP = 100
+
+	AUDIT_ENROLL         = "enroll"
+	AUDIT_ENROLL_APPROVE = "enroll-approve"
+	AUDIT_ENROLL_REJECT  = "enroll-reject"
+
+	// Prefix of the decider of enrollments approved by a rule of
+	// enroll-auto-approve
+	ENROLL_AUTO_PREFIX = "auto:"
+)
+
+// enrollMu serializes adding enrollments, as a Service is created per request
+// but the limits of pending enrollments apply to all of them.
+var enrollMu sync.Mutex
+
+// hostKeyTypes contains the types of host keys that host certificates are
+// issued for.
+var hostKeyTypes = []string{
+	ssh.KeyAlgoED25519,
+	ssh.KeyAlgoECDSA256,
+	ssh.KeyAlgoECDSA384,
+	ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoRSA,
+}
+
+// autoApproval returns the rule of rules that approves the enrollment of
+// host requested from remote, or an empty string if none does.
+func autoApproval(ctx context.Context, rules config.EnrollmentRules, host, remote string) string {
+	ip := net.ParseIP(remote)
+	if ip == nil {
+		return ""
+	}
+
+	for _, network := range rules.Networks {
+		if network.Contains(ip) {
+			return network.String()
+		}
+	}
+
+	if rules.DNS {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return ""
+		}
+
+		for _, addr := range addrs {
+			if addr.IP.Equal(ip) {
+				return config.ENROLL_AUTO_DNS
+			}
+		}
+	}
+
+	return ""
+}
+
+// lookupHost returns the configuration of the host, or ErrHostNotFound. If
+// the context is done, its error is returned instead.
+func (s *Service) lookupHost(ctx context.Context, host string) (config.HostInfo, error) {
+	info, err := s.Hosts.LookupHost(ctx, host)
+	if err != nil {
+		if ctx.Err() != nil {
+			return info, ctx.Err()
+		}
+
+		return info, fmt.Errorf("%w: %w", ErrHostNotFound, err)
+	}
+
+	return info, nil
+}
+
+// IssueHostCertificate signs a host certificate for pubkey that is valid for
+// host only, and records it.
+func (s *Service) IssueHostCertificate(info config.HostInfo, host string, pubkey ssh.PublicKey) (*ssh.Certificate, error) {
+	signer, err := s.Signer.HostSigner(info)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := s.Store.NextSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	validAfter := uint64(now.Unix()) - uint64(s.ClockSkewTolerance)
+	validBefore := uint64(now.Unix()) + uint64(info.HostCertValidity)
+
+	cert := &ssh.Certificate{
+		Key:             pubkey,
+		Serial:          serial,
+		CertType:        ssh.HostCert,
+		KeyId:           host,
+		ValidPrincipals: []string{host},
+		ValidAfter:      validAfter,
+		ValidBefore:     validBefore,
+	}
+
+	if err := cert.SignCert(rand.Reader, signer); err != nil {
+		return nil, err
+	}
+
+	err = s.Store.AddCertificate(storage.Certificate{
+		Serial:      serial,
+		KeyId:       cert.KeyId,
+		Host:        host,
+		HostGroup:   info.HostGroup,
+		Fingerprint: ssh.FingerprintSHA256(pubkey),
+		CA:          ssh.FingerprintSHA256(signer.PublicKey()),
+		ValidAfter:  time.Unix(int64(validAfter), 0),
+		ValidBefore: time.Unix(int64(validBefore), 0),
+		IssuedAt:    now,
+	})
+
+	return cert, err
+}
+
+// addEnrollment stores a pending enrollment of the host key, unless one is
+// already pending (then true is returned) or the host or its hostgroup have
+// too many pending enrollments. The limits are checked and the enrollment is
+// stored under enrollMu, so concurrent requests can't exceed them.
+func (s *Service) addEnrollment(info config.HostInfo, host string, pubkey ssh.PublicKey, remote string) (storage.Enrollment, bool, error) {
+	fingerprint := ssh.FingerprintSHA256(pubkey)
+
+	enrollMu.Lock()
+	defer enrollMu.Unlock()
+
+	pending, err := s.Store.ListEnrollments(storage.ENROLLMENT_PENDING)
+	if err != nil {
+		return storage.Enrollment{}, false, err
+	}
+
+	count, groupCount := 0, 0
+	for _, enrollment := range pending {
+		if enrollment.HostGroup == info.HostGroup {
+			groupCount++
+		}
+
+		if enrollment.Host != host {
+			continue
+		}
+
+		if enrollment.Fingerprint == fingerprint {
+			return enrollment, true, nil
+		}
+
+		count++
+	}
+
+	if count >= MAX_PENDING_ENROLLMENTS {
+		return storage.Enrollment{}, false, fmt.Errorf("%w: %d pending for %s", ErrTooManyEnrollments, count, host)
+	}
+
+	if groupCount >= MAX_PENDING_ENROLLMENTS_PER_HOSTGROUP {
+		return storage.Enrollment{}, false, fmt.Errorf("%w: %d pending in hostgroup %s", ErrTooManyEnrollments, groupCount, info.HostGroup)
+	}
+
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	enrollment := storage.Enrollment{
+		ID:          hex.EncodeToString(id),
+		Host:        host,
+		HostGroup:   info.HostGroup,
+		PublicKey:   strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(pubkey)), "\n"),
+		Fingerprint: fingerprint,
+		RemoteAddr:  remote,
+		State:       storage.ENROLLMENT_PENDING,
+		RequestedAt: s.now(),
+	}
+
+	if err := s.Store.SetEnrollment(enrollment); err != nil {
+		return storage.Enrollment{}, false, err
+	}
+
+	return enrollment, false, nil
+}
+
+// Enroll requests a host certificate for the host key in authorized_keys
+// format, received from the address remote. The enrollment is approved
+// right away if it matches a rule of enroll-auto-approve, and held pending
+// otherwise. Repeated requests for the same key return the pending
+// enrollment, so hosts may simply retry.
+func (s *Service) Enroll(ctx context.Context, host, publicKey, remote string) (storage.Enrollment, error) {
+	info, err := s.lookupHost(ctx, host)
+	if err != nil {
+		return storage.Enrollment{}, err
+	}
+
+	if !info.HostEnrollment {
+		return storage.Enrollment{}, fmt.Errorf("%w: %s", ErrEnrollmentDisabled, host)
+	}
+
+	pubkey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		return storage.Enrollment{}, fmt.Errorf("%w: %w", ErrInvalidHostKey, err)
+	}
+
+	if !slices.Contains(hostKeyTypes, pubkey.Type()) {
+		return storage.Enrollment{}, fmt.Errorf("%w: unsupported type %s", ErrInvalidHostKey, pubkey.Type())
+	}
+
+	enrollment, existing, err := s.addEnrollment(info, host, pubkey, remote)
+	if err != nil || existing {
+		return enrollment, err
+	}
+
+	s.Store.AddAuditEvent(storage.AuditEvent{
+		Time:   enrollment.RequestedAt,
+		Action: AUDIT_ENROLL,
+		Actor:  host,
+		Details: map[string]string{
+			"id":          enrollment.ID,
+			"hostgroup":   info.HostGroup,
+			"fingerprint": enrollment.Fingerprint,
+			"remote_addr": remote,
+		},
+	})
+
+	if rule := autoApproval(ctx, info.AutoApprove, host, remote); rule != "" {
+		return s.approveEnrollment(ctx, enrollment, ENROLL_AUTO_PREFIX+rule)
+	}
+
+	return enrollment, nil
+}
+
+// Enrollment returns the enrollment with the given ID of the host.
+func (s *Service) Enrollment(host, id string) (storage.Enrollment, error) {
+	enrollment, err := s.Store.GetEnrollment(id)
+	if err != nil {
+		return storage.Enrollment{}, fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	if enrollment.Host != host {
+		return storage.Enrollment{}, fmt.Errorf("%w: enrollment %s of %s", ErrNotFound, id, host)
+	}
+
+	return enrollment, nil
+}
+
+// Enrollments returns the enrollments in the given state, or all of them if
+// it is empty.
+func (s *Service) Enrollments(state string) ([]storage.Enrollment, error) {
+	return s.Store.ListEnrollments(state)
+}
+
+// DecideEnrollment approves or rejects the pending enrollment with the given
+// ID on behalf of actor. Approving issues the host certificate, which
+// requires enrollment to still be enabled for the host.
+func (s *Service) DecideEnrollment(ctx context.Context, id string, approve bool, actor string) (storage.Enrollment, error) {
+	enrollment, err := s.Store.GetEnrollment(id)
+	if err != nil {
+		return enrollment, fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	if enrollment.State != storage.ENROLLMENT_PENDING {
+		return enrollment, fmt.Errorf("%w: enrollment %s is %s", ErrEnrollmentDecided, id, enrollment.State)
+	}
+
+	if approve {
+		return s.approveEnrollment(ctx, enrollment, actor)
+	}
+
+	return s.rejectEnrollment(enrollment, actor)
+}
+
+// approveEnrollment issues the host certificate of a pending enrollment and
+// records the approval by actor.
+func (s *Service) approveEnrollment(ctx context.Context, enrollment storage.Enrollment, actor string) (storage.Enrollment, error) {
+	info, err := s.Hosts.LookupHost(ctx, enrollment.Host)
+	if err != nil || !info.HostEnrollment {
+		return enrollment, fmt.Errorf("%w: %s", ErrEnrollmentDisabled, enrollment.Host)
+	}
+
+	pubkey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(enrollment.PublicKey))
+	if err != nil {
+		return enrollment, err
+	}
+
+	cert, err := s.IssueHostCertificate(info, enrollment.Host, pubkey)
+	if err != nil {
+		return enrollment, err
+	}
+
+	enrollment.State = storage.ENROLLMENT_APPROVED
+	enrollment.DecidedBy = actor
+	enrollment.DecidedAt = s.now()
+	enrollment.Serial = cert.Serial
+	enrollment.Certificate = strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(cert)), "\n")
+
+	if err := s.Store.SetEnrollment(enrollment); err != nil {
+		return enrollment, err
+	}
+
+	s.Store.AddAuditEvent(storage.AuditEvent{
+		Time:   enrollment.DecidedAt,
+		Action: AUDIT_ENROLL_APPROVE,
+		Actor:  actor,
+		Details: map[string]string{
+			"id":          enrollment.ID,
+			"host":        enrollment.Host,
+			"fingerprint": enrollment.Fingerprint,
+			"serial":      strconv.FormatUint(cert.Serial, 10),
+		},
+	})
+
+	return enrollment, nil
+}
+
+// rejectEnrollment records the rejection of a pending enrollment by actor.
+func (s *Service) rejectEnrollment(enrollment storage.Enrollment, actor string) (storage.Enrollment, error) {
+	enrollment.State = storage.ENROLLMENT_REJECTED
+	enrollment.DecidedBy = actor
+	enrollment.DecidedAt = s.now()
+
+	if err := s.Store.SetEnrollment(enrollment); err != nil {
+		return enrollment, err
+	}
+
+	s.Store.AddAuditEvent(storage.AuditEvent{
+		Time:   enrollment.DecidedAt,
+		Action: AUDIT_ENROLL_REJECT,
+		Actor:  actor,
+		Details: map[string]string{
+			"id":          enrollment.ID,
+			"host":        enrollment.Host,
+			"fingerprint": enrollment.Fingerprint,
+		},
+	})
+
+	return enrollment, nil
+}
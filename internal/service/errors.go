@@ -0,0 +1,41 @@
+package service
+
+import (
+	"errors"
+)
+
+// Error is an error of the service, identified by its code, which frontends
+// translate using package i18n. The service returns them wrapped with
+// context, so use errors.Is to test for them.
+type Error struct {
+	Code string
+}
+
+func (e *Error) Error() string {
+	return e.Code
+}
+
+var (
+	ErrHostNotFound        = &Error{ERR_UNKNOWN_HOST}
+	ErrTokenRejected       = &Error{ERR_UNAUTHORIZED}
+	ErrUpstreamUnavailable = &Error{ERR_GATEWAY_DOWN}
+	ErrNotFound            = &Error{ERR_NOT_FOUND}
+	ErrEnrollmentDisabled  = &Error{ERR_ENROLLMENT_DISABLED}
+	ErrEnrollmentDecided   = &Error{ERR_ENROLLMENT_DECIDED}
+	ErrTooManyEnrollments  = &Error{ERR_TOO_MANY_ENROLLMENTS}
+	ErrInvalidHostKey      = &Error{ERR_INVALID_HOST_KEY}
+	ErrInvalidRenewal      = &Error{ERR_INVALID_RENEWAL}
+	ErrInvalidHostKeys     = &Error{ERR_INVALID_HOSTKEYS}
+	ErrNoHostKeys          = &Error{ERR_NO_HOSTKEYS}
+)
+
+// AsError returns the service error wrapped by err, or nil if there is none,
+// e.g. for failures of the store.
+func AsError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	return nil
+}
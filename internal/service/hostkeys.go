@@ -0,0 +1,184 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/sshsig"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// Namespace of the signature over reported host keys, see
+	// scripts/oinit-report-hostkeys.sh
+	HOSTKEYS_NAMESPACE = "oinit-hostkeys"
+
+	// Reports must be signed within this duration before they are received,
+	// and must be newer than the stored report.
+	MAX_HOSTKEYS_AGE = 5 * time.Minute
+	MAX_HOSTKEYS     = 16
+
+	AUDIT_HOSTKEYS = "hostkeys"
+)
+
+// HostKeysReport is a report of the host keys of a host, signed with the key
+// of its host certificate using 'ssh-keygen -Y sign'.
+type HostKeysReport struct {
+	// Public keys in authorized_keys format, one per line
+	HostKeys string
+	// Unix timestamp of the report
+	Timestamp int64
+	// Host certificate issued by the host CA of this host
+	Certificate string
+	// Armored SSH signature over "<host>\n<timestamp>\n<hostkeys>"
+	Signature string
+}
+
+// hostKeysMessage returns the data that is signed by the host.
+func hostKeysMessage(host string, timestamp int64, hostKeys string) []byte {
+	return []byte(host + "\n" + strconv.FormatInt(timestamp, 10) + "\n" + hostKeys)
+}
+
+// verifyHostCertificate parses the host certificate in authorized_keys format
+// and checks that it is valid for host at now and issued by caKey.
+func verifyHostCertificate(host string, caKey ssh.PublicKey, certificate string, now time.Time) (*ssh.Certificate, error) {
+	pk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(certificate))
+	if err != nil {
+		return nil, err
+	}
+
+	cert, ok := pk.(*ssh.Certificate)
+	if !ok || cert.CertType != ssh.HostCert {
+		return nil, errors.New("not a host certificate")
+	}
+
+	checker := ssh.CertChecker{
+		IsHostAuthority: func(auth ssh.PublicKey, _ string) bool {
+			return caKey != nil && bytes.Equal(auth.Marshal(), caKey.Marshal())
+		},
+		Clock: func() time.Time { return now },
+	}
+
+	if !checker.IsHostAuthority(cert.SignatureKey, host) {
+		return nil, errors.New("certificate is not issued by the host CA")
+	}
+
+	if err := checker.CheckCert(host, cert); err != nil {
+		return nil, err
+	}
+
+	return cert, nil
+}
+
+// verifyHostKeys checks that the report was signed by the key of a valid
+// host certificate for host issued by caKey, and returns the reported keys.
+func verifyHostKeys(host string, caKey ssh.PublicKey, report HostKeysReport, now time.Time) ([]string, error) {
+	reportedAt := time.Unix(report.Timestamp, 0)
+	if reportedAt.After(now.Add(MAX_HOSTKEYS_AGE)) || reportedAt.Before(now.Add(-MAX_HOSTKEYS_AGE)) {
+		return nil, errors.New("report is too old or in the future")
+	}
+
+	cert, err := verifyHostCertificate(host, caKey, report.Certificate, now)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := sshsig.Verify([]byte(report.Signature), hostKeysMessage(host, report.Timestamp, report.HostKeys), HOSTKEYS_NAMESPACE)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(signer.Marshal(), cert.Key.Marshal()) {
+		return nil, errors.New("report is not signed by the certificate key")
+	}
+
+	var keys []string
+	for _, line := range strings.Split(report.HostKeys, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := key.(*ssh.Certificate); ok {
+			return nil, errors.New("host keys must not be certificates")
+		}
+
+		// Normalize and strip comments
+		keys = append(keys, strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(key)), "\n"))
+	}
+
+	if len(keys) == 0 || len(keys) > MAX_HOSTKEYS {
+		return nil, errors.New("invalid number of host keys")
+	}
+
+	return keys, nil
+}
+
+// ReportHostKeys verifies and stores the host keys reported by a host.
+func (s *Service) ReportHostKeys(ctx context.Context, host string, report HostKeysReport) (storage.HostKeys, error) {
+	info, err := s.lookupHost(ctx, host)
+	if err != nil {
+		return storage.HostKeys{}, err
+	}
+
+	keys, err := verifyHostKeys(host, info.HostCAPublicKey, report, s.now())
+	if err != nil {
+		s.logger().Printf("Rejected host keys reported for %s: %s", host, err)
+		return storage.HostKeys{}, fmt.Errorf("%w: %w", ErrInvalidHostKeys, err)
+	}
+
+	reportedAt := time.Unix(report.Timestamp, 0).UTC()
+
+	// Reports can't be replayed to restore previous keys
+	if previous, err := s.Store.GetHostKeys(host); err == nil && !reportedAt.After(previous.ReportedAt) {
+		return storage.HostKeys{}, fmt.Errorf("%w: report is not newer than %s", ErrInvalidHostKeys, previous.ReportedAt)
+	}
+
+	hostKeys := storage.HostKeys{
+		Host:        host,
+		Keys:        keys,
+		Certificate: strings.TrimSpace(report.Certificate),
+		ReportedAt:  reportedAt,
+	}
+
+	if err := s.Store.SetHostKeys(hostKeys); err != nil {
+		return storage.HostKeys{}, err
+	}
+
+	s.Store.AddAuditEvent(storage.AuditEvent{
+		Time:   s.now(),
+		Action: AUDIT_HOSTKEYS,
+		Actor:  host,
+		Details: map[string]string{
+			"hostgroup": info.HostGroup,
+			"keys":      strconv.Itoa(len(keys)),
+		},
+	})
+
+	return hostKeys, nil
+}
+
+// HostKeys returns the host keys last reported by the host.
+func (s *Service) HostKeys(ctx context.Context, host string) (storage.HostKeys, error) {
+	if _, err := s.lookupHost(ctx, host); err != nil {
+		return storage.HostKeys{}, err
+	}
+
+	hostKeys, err := s.Store.GetHostKeys(host)
+	if err != nil {
+		return storage.HostKeys{}, fmt.Errorf("%w: %w", ErrNoHostKeys, err)
+	}
+
+	return hostKeys, nil
+}
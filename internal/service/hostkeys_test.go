@@ -0,0 +1,76 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/sshsig"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestSigner() ssh.Signer {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	signer, _ := ssh.NewSignerFromKey(priv)
+
+	return signer
+}
+
+func TestVerifyHostKeys(t *testing.T) {
+	ca := newTestSigner()
+	hostKey := newTestSigner()
+	now := time.Now()
+
+	cert := &ssh.Certificate{
+		Key:             hostKey.PublicKey(),
+		CertType:        ssh.HostCert,
+		ValidPrincipals: []string{"login.example.com"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	assert.NoError(t, cert.SignCert(rand.Reader, ca))
+
+	other := newTestSigner()
+	hostKeys := string(ssh.MarshalAuthorizedKey(other.PublicKey())) + "\n" +
+		string(ssh.MarshalAuthorizedKey(hostKey.PublicKey()))
+
+	report := func(host string, timestamp int64, signer ssh.Signer) HostKeysReport {
+		sig, err := sshsig.Sign(signer, hostKeysMessage(host, timestamp, hostKeys), HOSTKEYS_NAMESPACE)
+		assert.NoError(t, err)
+
+		return HostKeysReport{
+			HostKeys:    hostKeys,
+			Timestamp:   timestamp,
+			Certificate: string(ssh.MarshalAuthorizedKey(cert)),
+			Signature:   string(sig),
+		}
+	}
+
+	keys, err := verifyHostKeys("login.example.com", ca.PublicKey(), report("login.example.com", now.Unix(), hostKey), now)
+	assert.NoError(t, err)
+	assert.Len(t, keys, 2)
+
+	// Signed by another key than the certified one
+	_, err = verifyHostKeys("login.example.com", ca.PublicKey(), report("login.example.com", now.Unix(), other), now)
+	assert.Error(t, err)
+
+	// Certificate not issued by the host CA
+	_, err = verifyHostKeys("login.example.com", other.PublicKey(), report("login.example.com", now.Unix(), hostKey), now)
+	assert.Error(t, err)
+
+	// Certificate not valid for the host
+	_, err = verifyHostKeys("other.example.com", ca.PublicKey(), report("other.example.com", now.Unix(), hostKey), now)
+	assert.Error(t, err)
+
+	// Report too old
+	_, err = verifyHostKeys("login.example.com", ca.PublicKey(), report("login.example.com", now.Add(-time.Hour).Unix(), hostKey), now)
+	assert.Error(t, err)
+
+	// Signature over another timestamp
+	body := report("login.example.com", now.Unix(), hostKey)
+	body.Timestamp++
+	_, err = verifyHostKeys("login.example.com", ca.PublicKey(), body, now)
+	assert.Error(t, err)
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/sshsig"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// Namespace of the signature over renewal requests, see
+	// scripts/oinit-renew-host.sh
+	RENEW_NAMESPACE = "oinit-renew"
+
+	// Renewal requests must be signed within this duration before they are
+	// received.
+	MAX_RENEW_AGE = 5 * time.Minute
+
+	AUDIT_RENEW = "renew"
+)
+
+// RenewalRequest is a request of an enrolled host for a new host
+// certificate, signed with the key of its current host certificate using
+// 'ssh-keygen -Y sign'.
+type RenewalRequest struct {
+	// Unix timestamp of the request
+	Timestamp int64
+	// Current, unexpired host certificate issued by the CA
+	Certificate string
+	// Armored SSH signature over "<host>\n<timestamp>\n<certificate serial>"
+	Signature string
+}
+
+// renewMessage returns the data that is signed by the host.
+func renewMessage(host string, timestamp int64, serial uint64) []byte {
+	return []byte(host + "\n" + strconv.FormatInt(timestamp, 10) + "\n" + strconv.FormatUint(serial, 10))
+}
+
+// verifyRenewal checks that the request was signed by the key of a valid
+// host certificate for host issued by caKey, and returns the certificate.
+func verifyRenewal(host string, caKey ssh.PublicKey, req RenewalRequest, now time.Time) (*ssh.Certificate, error) {
+	requestedAt := time.Unix(req.Timestamp, 0)
+	if requestedAt.After(now.Add(MAX_RENEW_AGE)) || requestedAt.Before(now.Add(-MAX_RENEW_AGE)) {
+		return nil, errors.New("request is too old or in the future")
+	}
+
+	cert, err := verifyHostCertificate(host, caKey, req.Certificate, now)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := sshsig.Verify([]byte(req.Signature), renewMessage(host, req.Timestamp, cert.Serial), RENEW_NAMESPACE)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(signer.Marshal(), cert.Key.Marshal()) {
+		return nil, errors.New("request is not signed by the certificate key")
+	}
+
+	return cert, nil
+}
+
+// renewable checks that the host certificate was issued by this CA for host,
+// i.e. the host is enrolled, and hasn't been revoked since.
+func renewable(store storage.Store, host string, cert *ssh.Certificate) error {
+	issued, err := store.GetCertificate(cert.Serial)
+	if err != nil || issued.Host != host || issued.Fingerprint != ssh.FingerprintSHA256(cert.Key) {
+		return errors.New("certificate was not issued by the CA")
+	}
+
+	revocations, err := store.ListRevocations()
+	if err != nil {
+		return err
+	}
+
+	for _, rev := range revocations {
+		if rev.Serial == cert.Serial {
+			return errors.New("certificate is revoked")
+		}
+	}
+
+	return nil
+}
+
+// RenewHostCertificate issues a new host certificate for the key of the
+// current host certificate of an enrolled host, if host enrollment is still
+// enabled for it.
+func (s *Service) RenewHostCertificate(ctx context.Context, host string, req RenewalRequest) (*ssh.Certificate, error) {
+	info, err := s.lookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.HostEnrollment {
+		return nil, fmt.Errorf("%w: %s", ErrEnrollmentDisabled, host)
+	}
+
+	cert, err := verifyRenewal(host, info.HostCAPublicKey, req, s.now())
+	if err == nil {
+		err = renewable(s.Store, host, cert)
+	}
+
+	if err != nil {
+		s.logger().Printf("Rejected renewal of host certificate for %s: %s", host, err)
+		return nil, fmt.Errorf("%w: %w", ErrInvalidRenewal, err)
+	}
+
+	// Requests may be replayed within MAX_RENEW_AGE, but only yield another
+	// certificate for the same key.
+	renewed, err := s.IssueHostCertificate(info, host, cert.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Store.AddAuditEvent(storage.AuditEvent{
+		Time:   s.now(),
+		Action: AUDIT_RENEW,
+		Actor:  host,
+		Details: map[string]string{
+			"hostgroup":   info.HostGroup,
+			"fingerprint": ssh.FingerprintSHA256(cert.Key),
+			"previous":    strconv.FormatUint(cert.Serial, 10),
+			"serial":      strconv.FormatUint(renewed.Serial, 10),
+		},
+	})
+
+	return renewed, nil
+}
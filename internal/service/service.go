@@ -0,0 +1,90 @@
+// Package service contains the operations of the CA independent of the
+// transport they are requested over. Package api adapts them to HTTP, other
+// frontends such as a CLI or unix socket may call them directly.
+//
+// Errors returned by the service wrap one of the Err variables, see Error,
+// unless they are caused by the store or the context.
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/storage"
+	"github.com/lbrocke/oinit/pkg/libmotleycue"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// Error codes, the messages are translated using package i18n
+	ERR_UNKNOWN_HOST         = "unknown_host"
+	ERR_UNAUTHORIZED         = "unauthorized"
+	ERR_GATEWAY_DOWN         = "gateway_down"
+	ERR_NOT_FOUND            = "not_found"
+	ERR_ENROLLMENT_DISABLED  = "enrollment_disabled"
+	ERR_ENROLLMENT_REJECTED  = "enrollment_rejected"
+	ERR_ENROLLMENT_DECIDED   = "enrollment_decided"
+	ERR_TOO_MANY_ENROLLMENTS = "too_many_enrollments"
+	ERR_INVALID_HOST_KEY     = "invalid_host_key"
+	ERR_INVALID_RENEWAL      = "invalid_renewal"
+	ERR_INVALID_HOSTKEYS     = "invalid_hostkeys"
+	ERR_NO_HOSTKEYS          = "no_hostkeys"
+)
+
+// Hosts looks up the configuration of hosts.
+type Hosts interface {
+	// LookupHost returns the configuration of the host, or an error if it
+	// isn't served by this CA.
+	LookupHost(ctx context.Context, host string) (config.HostInfo, error)
+}
+
+// Authorizer decides whether users may log in to hosts, e.g. by asking
+// motley_cue.
+type Authorizer interface {
+	// Status returns the state of the account of the user of the access
+	// token on the host, without deploying it.
+	Status(ctx context.Context, info config.HostInfo, host string, token *jwt.Token) (libmotleycue.ApiResponseUserStatus, error)
+}
+
+// Signer provides the keys certificates are signed with.
+type Signer interface {
+	// HostSigner returns the signer of host certificates for the host.
+	HostSigner(info config.HostInfo) (ssh.Signer, error)
+}
+
+// Service implements the operations of the CA. Operations only use the
+// dependencies they need, e.g. UserStatus doesn't use Store, so those that
+// aren't needed by a frontend may be nil.
+type Service struct {
+	Hosts      Hosts
+	Authorizer Authorizer
+	Signer     Signer
+	Store      storage.Store
+
+	// Seconds that certificates are valid before they are issued
+	ClockSkewTolerance int
+	// Returns the current time, time.Now if nil
+	Now func() time.Time
+	// Logs rejected requests, the standard logger if nil
+	Logger *log.Logger
+}
+
+func (s *Service) now() time.Time {
+	if s.Now == nil {
+		return time.Now()
+	}
+
+	return s.Now()
+}
+
+func (s *Service) logger() *log.Logger {
+	if s.Logger == nil {
+		return log.Default()
+	}
+
+	return s.Logger
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/pkg/libmotleycue"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// UserStatus returns the configuration of the host and the state of the
+// account of the user of the access token on it, without deploying it.
+// Errors of the Authorizer are wrapped in ErrUpstreamUnavailable if the
+// upstream couldn't answer, and in ErrTokenRejected otherwise.
+func (s *Service) UserStatus(ctx context.Context, host, accessToken string) (config.HostInfo, libmotleycue.ApiResponseUserStatus, error) {
+	var status libmotleycue.ApiResponseUserStatus
+
+	token, _, err := new(jwt.Parser).ParseUnverified(accessToken, jwt.MapClaims{})
+	if err != nil {
+		return config.HostInfo{}, status, fmt.Errorf("%w: %w", ErrTokenRejected, err)
+	}
+
+	info, err := s.lookupHost(ctx, host)
+	if err != nil {
+		return info, status, err
+	}
+
+	status, err = s.Authorizer.Status(ctx, info, host, token)
+	if err != nil {
+		if ctx.Err() != nil {
+			return info, status, ctx.Err()
+		}
+
+		if libmotleycue.Unavailable(err) {
+			return info, status, fmt.Errorf("%w: %w", ErrUpstreamUnavailable, err)
+		}
+
+		return info, status, fmt.Errorf("%w: %w", ErrTokenRejected, err)
+	}
+
+	return info, status, nil
+}
@@ -0,0 +1,162 @@
+// Package sshsig creates and verifies SSH signatures as created by
+// 'ssh-keygen -Y sign', see PROTOCOL.sshsig of OpenSSH. This allows hosts to
+// sign data with their host keys using standard tools.
+package sshsig
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/pem"
+	"errors"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	MAGIC        = "SSHSIG"
+	VERSION      = 1
+	PEM_TYPE     = "SSH SIGNATURE"
+	DEFAULT_HASH = "sha512"
+
+	ERR_MALFORMED = "ssh signature is malformed"
+	ERR_NAMESPACE = "ssh signature has wrong namespace"
+	ERR_HASH      = "ssh signature uses unsupported hash algorithm"
+	ERR_ALGORITHM = "ssh signature uses unsupported signature algorithm"
+	ERR_PRESENCE  = "ssh signature was made without user presence"
+
+	// Flag of signatures of security keys (sk-*) which is set if the user
+	// touched the key, see PROTOCOL.u2f of OpenSSH
+	SK_USER_PRESENT = 0x01
+)
+
+// wrapper is the binary representation of a signature following MAGIC.
+type wrapper struct {
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     []byte
+}
+
+// signedData is the data that is actually signed, following MAGIC.
+type signedData struct {
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          []byte
+}
+
+func hash(algorithm string, message []byte) ([]byte, error) {
+	switch algorithm {
+	case "sha256":
+		h := sha256.Sum256(message)
+		return h[:], nil
+	case "sha512":
+		h := sha512.Sum512(message)
+		return h[:], nil
+	default:
+		return nil, errors.New(ERR_HASH)
+	}
+}
+
+func blob(namespace, algorithm string, message []byte) ([]byte, error) {
+	h, err := hash(algorithm, message)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(MAGIC), ssh.Marshal(signedData{
+		Namespace:     namespace,
+		HashAlgorithm: algorithm,
+		Hash:          h,
+	})...), nil
+}
+
+// Sign returns the armored signature of message in the given namespace, as
+// 'ssh-keygen -Y sign -n <namespace>' does.
+func Sign(signer ssh.Signer, message []byte, namespace string) ([]byte, error) {
+	data, err := blob(namespace, DEFAULT_HASH, message)
+	if err != nil {
+		return nil, err
+	}
+
+	// Like ssh-keygen, prefer SHA-512 for RSA keys
+	var sig *ssh.Signature
+	if algSigner, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		sig, err = algSigner.SignWithAlgorithm(rand.Reader, data, ssh.KeyAlgoRSASHA512)
+	} else {
+		sig, err = signer.Sign(rand.Reader, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := append([]byte(MAGIC), ssh.Marshal(wrapper{
+		Version:       VERSION,
+		PublicKey:     signer.PublicKey().Marshal(),
+		Namespace:     namespace,
+		HashAlgorithm: DEFAULT_HASH,
+		Signature:     ssh.Marshal(sig),
+	})...)
+
+	return pem.EncodeToMemory(&pem.Block{Type: PEM_TYPE, Bytes: encoded}), nil
+}
+
+// Verify checks the armored signature of message in the given namespace and
+// returns the public key it was made with. The caller must check whether this
+// key is trusted. As by 'ssh-keygen -Y verify', RSA signatures using SHA-1
+// and signatures of security keys that were made without user presence are
+// rejected.
+func Verify(armored, message []byte, namespace string) (ssh.PublicKey, error) {
+	block, _ := pem.Decode(bytes.TrimSpace(armored))
+	if block == nil || block.Type != PEM_TYPE || !bytes.HasPrefix(block.Bytes, []byte(MAGIC)) {
+		return nil, errors.New(ERR_MALFORMED)
+	}
+
+	var w wrapper
+	if err := ssh.Unmarshal(block.Bytes[len(MAGIC):], &w); err != nil || w.Version != VERSION {
+		return nil, errors.New(ERR_MALFORMED)
+	}
+
+	if w.Namespace != namespace {
+		return nil, errors.New(ERR_NAMESPACE)
+	}
+
+	pubkey, err := ssh.ParsePublicKey(w.PublicKey)
+	if err != nil {
+		return nil, errors.New(ERR_MALFORMED)
+	}
+
+	var sig ssh.Signature
+	if err := ssh.Unmarshal(w.Signature, &sig); err != nil {
+		return nil, errors.New(ERR_MALFORMED)
+	}
+
+	// Like ssh-keygen, reject RSA signatures using SHA-1
+	if sig.Format == ssh.KeyAlgoRSA {
+		return nil, errors.New(ERR_ALGORITHM)
+	}
+
+	data, err := blob(namespace, w.HashAlgorithm, message)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := pubkey.Verify(data, &sig); err != nil {
+		return nil, err
+	}
+
+	// The flags of security key signatures follow the signature and are
+	// covered by it, see skEd25519PublicKey.Verify of x/crypto/ssh
+	switch pubkey.Type() {
+	case ssh.KeyAlgoSKED25519, ssh.KeyAlgoSKECDSA256:
+		if len(sig.Rest) == 0 || sig.Rest[0]&SK_USER_PRESENT == 0 {
+			return nil, errors.New(ERR_PRESENCE)
+		}
+	}
+
+	return pubkey, nil
+}
@@ -0,0 +1,131 @@
+package sshsig
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// Created with 'ssh-keygen -Y sign -n test -f key' for the message "hello\n"
+	TEST_SIGNATURE = `-----BEGIN SSH SIGNATURE-----
+U1NIU0lHAAAAAQAAADMAAAALc3NoLWVkMjU1MTkAAAAgcqZBXrAYqm08PMZgHV/Cxnszr/
+Il6dOqzRg0/nlgypoAAAAEdGVzdAAAAAAAAAAGc2hhNTEyAAAAUwAAAAtzc2gtZWQyNTUx
+OQAAAECMRtxLp7aVeN2xCg/OycOJs69ZfutDMUn1WMV3K6pCgU1flpS1YTfc5FHmf2JEwl
+7TnUCOvouXpERjxSlVnw8D
+-----END SSH SIGNATURE-----`
+	TEST_PUBKEY = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIHKmQV6wGKptPDzGYB1fwsZ7M6/yJenTqs0YNP55YMqa"
+)
+
+func TestSignVerify(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	rsaPriv, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	for _, key := range []interface{}{priv, rsaPriv} {
+		signer, _ := ssh.NewSignerFromKey(key)
+
+		sig, err := Sign(signer, []byte("message"), "test")
+		assert.NoError(t, err)
+
+		pubkey, err := Verify(sig, []byte("message"), "test")
+		assert.NoError(t, err)
+		assert.Equal(t, signer.PublicKey().Marshal(), pubkey.Marshal())
+
+		_, err = Verify(sig, []byte("other message"), "test")
+		assert.Error(t, err)
+
+		_, err = Verify(sig, []byte("message"), "other")
+		assert.EqualError(t, err, ERR_NAMESPACE)
+	}
+
+	_, err := Verify([]byte("garbage"), []byte("message"), "test")
+	assert.EqualError(t, err, ERR_MALFORMED)
+}
+
+func TestVerifySSHKeygen(t *testing.T) {
+	expected, _, _, _, _ := ssh.ParseAuthorizedKey([]byte(TEST_PUBKEY))
+
+	pubkey, err := Verify([]byte(TEST_SIGNATURE), []byte("hello\n"), "test")
+	assert.NoError(t, err)
+	assert.Equal(t, expected.Marshal(), pubkey.Marshal())
+
+	_, err = Verify([]byte(TEST_SIGNATURE), []byte("hello"), "test")
+	assert.Error(t, err)
+}
+
+// armor returns the armored signature sig made with pubkey in the namespace
+// "test".
+func armor(pubkey ssh.PublicKey, sig *ssh.Signature) []byte {
+	encoded := append([]byte(MAGIC), ssh.Marshal(wrapper{
+		Version:       VERSION,
+		PublicKey:     pubkey.Marshal(),
+		Namespace:     "test",
+		HashAlgorithm: DEFAULT_HASH,
+		Signature:     ssh.Marshal(sig),
+	})...)
+
+	return pem.EncodeToMemory(&pem.Block{Type: PEM_TYPE, Bytes: encoded})
+}
+
+// signSK returns the armored signature of message made with an Ed25519
+// security key, as its authenticator would with the given flags.
+func signSK(t *testing.T, message []byte, flags byte) []byte {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+
+	pubkey, err := ssh.ParsePublicKey(ssh.Marshal(struct {
+		Name        string
+		KeyBytes    []byte
+		Application string
+	}{ssh.KeyAlgoSKED25519, pub, "ssh:"}))
+	assert.NoError(t, err)
+
+	data, err := blob("test", DEFAULT_HASH, message)
+	assert.NoError(t, err)
+
+	appDigest := sha256.Sum256([]byte("ssh:"))
+	dataDigest := sha256.Sum256(data)
+	skFields := ssh.Marshal(struct {
+		Flags   byte
+		Counter uint32
+	}{flags, 1})
+
+	signed := append(append(appDigest[:], skFields...), dataDigest[:]...)
+
+	return armor(pubkey, &ssh.Signature{
+		Format: ssh.KeyAlgoSKED25519,
+		Blob:   ed25519.Sign(priv, signed),
+		Rest:   skFields,
+	})
+}
+
+func TestVerifySecurityKey(t *testing.T) {
+	_, err := Verify(signSK(t, []byte("message"), SK_USER_PRESENT), []byte("message"), "test")
+	assert.NoError(t, err)
+
+	_, err = Verify(signSK(t, []byte("message"), 0), []byte("message"), "test")
+	assert.EqualError(t, err, ERR_PRESENCE)
+
+	// Only user verification without presence
+	_, err = Verify(signSK(t, []byte("message"), 0x04), []byte("message"), "test")
+	assert.EqualError(t, err, ERR_PRESENCE)
+}
+
+func TestVerifyRSASHA1(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	signer, _ := ssh.NewSignerFromKey(key)
+
+	data, err := blob("test", DEFAULT_HASH, []byte("message"))
+	assert.NoError(t, err)
+
+	sig, err := signer.(ssh.AlgorithmSigner).SignWithAlgorithm(rand.Reader, data, ssh.KeyAlgoRSA)
+	assert.NoError(t, err)
+
+	_, err = Verify(armor(signer.PublicKey(), sig), []byte("message"), "test")
+	assert.EqualError(t, err, ERR_ALGORITHM)
+}
@@ -1,52 +1,47 @@
 package sshutil
 
 import (
-	"net"
-	"os"
 	"strings"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/tokenbind"
 
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
 	"golang.org/x/exp/slices"
 )
 
-type socket struct {
-	AddressEnvVar string
-	Type          string
-}
-
 const (
 	PRINCIPAL = "oinit"
+
+	ENV_AUTH_SOCK = "SSH_AUTH_SOCK"
 )
 
+// AgentIsRunning returns whether an SSH agent can be reached.
 func AgentIsRunning() bool {
-	sockets := []socket{
-		{
-			"SSH_AUTH_SOCK",
-			"unix",
-		},
+	conn, err := dialAgent()
+	if err != nil {
+		return false
 	}
 
-	for _, sock := range sockets {
-		if _, err := net.Dial(sock.Type, os.Getenv(sock.AddressEnvVar)); err == nil {
-			return true
-		}
-	}
+	conn.Close()
 
-	return false
+	return true
 }
 
+// GetAgent connects to the SSH agent, see dialAgent.
 func GetAgent() (agent.ExtendedAgent, error) {
-	sshAgentSock, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
-	return agent.NewClient(sshAgentSock), err
+	conn, err := dialAgent()
+	return agent.NewClient(conn), err
 }
 
 // agentGetOinitCertificates returns a slice of all certificates in the agent
 // that have been issued by oinit for the given host.
 //
 // The KeyId field, which is set to oinit@<host> by oinit-ca, as well as the
-// occurrences of "oinit" in the ValidPrincipals field are used to identify
-// certificates issued by oinit.
+// occurrences of "oinit" (or "oinit@<host>" if the CA restricts principals to
+// the host) in the ValidPrincipals field are used to identify certificates
+// issued by oinit.
 func agentGetOinitCertificates(agent agent.ExtendedAgent, host string) ([]ssh.Certificate, error) {
 	var certificates []ssh.Certificate
 
@@ -71,7 +66,7 @@ func agentGetOinitCertificates(agent agent.ExtendedAgent, host string) ([]ssh.Ce
 		}
 
 		if cert.CertType == ssh.UserCert && cert.KeyId == keyId &&
-			slices.Contains(cert.ValidPrincipals, PRINCIPAL) {
+			(slices.Contains(cert.ValidPrincipals, PRINCIPAL) || slices.Contains(cert.ValidPrincipals, keyId)) {
 			certificates = append(certificates, *cert)
 		}
 	}
@@ -105,3 +100,36 @@ func AgentRemoveCertificates(agent agent.ExtendedAgent, host string) error {
 
 	return nil
 }
+
+// AgentCertificateExpiry returns the time until which the longest valid
+// certificate issued by oinit-ca for the given host in the agent is valid,
+// or the zero time if there is none.
+// An error is returned when communication with the agent is not possible, for
+// example if it isn't running.
+func AgentCertificateExpiry(agent agent.ExtendedAgent, host string) (time.Time, error) {
+	certificates, err := agentGetOinitCertificates(agent, host)
+
+	var expiry time.Time
+	for _, cert := range certificates {
+		if validBefore := time.Unix(int64(cert.ValidBefore), 0); validBefore.After(expiry) {
+			expiry = validBefore
+		}
+	}
+
+	return expiry, err
+}
+
+// AgentCertificateBound returns whether a certificate issued by oinit-ca for
+// the given host in the agent may be used with the given access token, i.e.
+// it is bound to the token or not bound to any token, see package tokenbind.
+func AgentCertificateBound(agent agent.ExtendedAgent, host, token string) bool {
+	certificates, _ := agentGetOinitCertificates(agent, host)
+
+	for _, cert := range certificates {
+		if _, bound := cert.Extensions[tokenbind.EXTENSION]; !bound || tokenbind.Verify(&cert, token) == nil {
+			return true
+		}
+	}
+
+	return false
+}
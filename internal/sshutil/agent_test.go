@@ -0,0 +1,101 @@
+package sshutil
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/tokenbind"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func addTestCertificate(t *testing.T, keyring agent.Agent, keyId string, validBefore time.Time) {
+	_, caKey, _ := ed25519.GenerateKey(rand.Reader)
+	pub, key, _ := ed25519.GenerateKey(rand.Reader)
+
+	caSigner, _ := ssh.NewSignerFromKey(caKey)
+	sshPub, _ := ssh.NewPublicKey(pub)
+
+	cert := &ssh.Certificate{
+		Key:             sshPub,
+		CertType:        ssh.UserCert,
+		KeyId:           keyId,
+		ValidPrincipals: []string{PRINCIPAL},
+		ValidBefore:     uint64(validBefore.Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := keyring.Add(agent.AddedKey{PrivateKey: key, Certificate: cert}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAgentCertificateExpiry(t *testing.T) {
+	keyring := agent.NewKeyring().(agent.ExtendedAgent)
+
+	expiry, err := AgentCertificateExpiry(keyring, "host.example.com")
+	if err != nil || !expiry.IsZero() {
+		t.Fatalf("expected no certificate, got %s, %v", expiry, err)
+	}
+
+	later := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	addTestCertificate(t, keyring, "oinit@host.example.com", time.Now().Add(time.Minute))
+	addTestCertificate(t, keyring, "oinit@host.example.com", later)
+	addTestCertificate(t, keyring, "oinit@other.example.com", later.Add(time.Hour))
+
+	expiry, err = AgentCertificateExpiry(keyring, "Host.example.com")
+	if err != nil || !expiry.Equal(later) {
+		t.Errorf("expected expiry %s, got %s, %v", later, expiry, err)
+	}
+}
+
+func TestAgentCertificateBound(t *testing.T) {
+	keyring := agent.NewKeyring().(agent.ExtendedAgent)
+
+	if AgentCertificateBound(keyring, "host.example.com", "token") {
+		t.Error("expected no certificate to be bound without certificates")
+	}
+
+	_, caKey, _ := ed25519.GenerateKey(rand.Reader)
+	caSigner, _ := ssh.NewSignerFromKey(caKey)
+
+	add := func(host string, extensions map[string]string) {
+		pub, key, _ := ed25519.GenerateKey(rand.Reader)
+		sshPub, _ := ssh.NewPublicKey(pub)
+
+		cert := &ssh.Certificate{
+			Key:             sshPub,
+			CertType:        ssh.UserCert,
+			KeyId:           "oinit@" + host,
+			ValidPrincipals: []string{PRINCIPAL},
+			ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+			Permissions:     ssh.Permissions{Extensions: extensions},
+		}
+		if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := keyring.Add(agent.AddedKey{PrivateKey: key, Certificate: cert}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	add("host.example.com", map[string]string{tokenbind.EXTENSION: tokenbind.Hash("token")})
+	add("other.example.com", map[string]string{})
+
+	if !AgentCertificateBound(keyring, "host.example.com", "token") {
+		t.Error("expected certificate to be bound to token")
+	}
+	if AgentCertificateBound(keyring, "host.example.com", "other") {
+		t.Error("expected certificate not to be bound to other token")
+	}
+	if !AgentCertificateBound(keyring, "other.example.com", "other") {
+		t.Error("expected unbound certificate to be usable with any token")
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package sshutil
+
+import (
+	"io"
+	"net"
+	"os"
+)
+
+// dialAgent connects to the SSH agent listening on the Unix socket in
+// $SSH_AUTH_SOCK.
+func dialAgent() (io.ReadWriteCloser, error) {
+	return net.Dial("unix", os.Getenv(ENV_AUTH_SOCK))
+}
@@ -0,0 +1,58 @@
+//go:build !windows
+// +build !windows
+
+package sshutil
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestGetAgentUnix(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	t.Setenv(ENV_AUTH_SOCK, sock)
+
+	if AgentIsRunning() {
+		t.Error("agent is running without socket")
+	}
+
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	keyring := agent.NewKeyring()
+	addTestCertificate(t, keyring, "oinit@host.example.com", time.Now().Add(time.Hour))
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+
+	if !AgentIsRunning() {
+		t.Fatal("agent is not running")
+	}
+
+	client, err := GetAgent()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expiry, err := AgentCertificateExpiry(client, "host.example.com"); err != nil || expiry.IsZero() {
+		t.Errorf("certificate not found via socket: %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+//go:build windows
+// +build windows
+
+package sshutil
+
+import (
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// Named pipe of the ssh-agent service of Win32-OpenSSH
+const AGENT_PIPE = `\\.\pipe\openssh-ssh-agent`
+
+// agentAddress returns the address of the SSH agent, which is $SSH_AUTH_SOCK
+// or the ssh-agent service if it is not set.
+func agentAddress() string {
+	if sock := os.Getenv(ENV_AUTH_SOCK); sock != "" {
+		return sock
+	}
+
+	return AGENT_PIPE
+}
+
+// dialAgent connects to the SSH agent. Named pipes, as used by the ssh-agent
+// service, are opened like files, while other addresses are Unix sockets,
+// which Windows supports since Windows 10.
+func dialAgent() (io.ReadWriteCloser, error) {
+	address := agentAddress()
+
+	if !strings.HasPrefix(address, `\\.\pipe\`) {
+		return net.Dial("unix", address)
+	}
+
+	pipe, err := os.OpenFile(address, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return pipe, nil
+}
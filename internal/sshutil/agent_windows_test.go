@@ -0,0 +1,27 @@
+//go:build windows
+// +build windows
+
+package sshutil
+
+import "testing"
+
+func TestAgentAddressWindows(t *testing.T) {
+	t.Setenv(ENV_AUTH_SOCK, "")
+
+	if address := agentAddress(); address != AGENT_PIPE {
+		t.Errorf("expected ssh-agent service, got %s", address)
+	}
+
+	t.Setenv(ENV_AUTH_SOCK, `\\.\pipe\pageant.user`)
+
+	if address := agentAddress(); address != `\\.\pipe\pageant.user` {
+		t.Errorf("expected $SSH_AUTH_SOCK, got %s", address)
+	}
+
+	// Pipes that don't exist can't be opened
+	t.Setenv(ENV_AUTH_SOCK, `\\.\pipe\oinit-test-missing`)
+
+	if AgentIsRunning() {
+		t.Error("agent is running without pipe")
+	}
+}
@@ -0,0 +1,20 @@
+package sshutil
+
+import (
+	"os"
+	"os/exec"
+)
+
+// ControlMasterAlive returns whether a ControlMaster of the user's ssh
+// configuration is running for the destination, so that ssh multiplexes the
+// connection over it instead of authenticating again. It uses 'ssh -O check',
+// which expands the ControlPath the same way as the connecting ssh, and
+// returns false if no ControlPath is configured.
+func ControlMasterAlive(host, port string) bool {
+	// The user is set by the Match block added by oinit, which is not
+	// evaluated while ENV_RESOLVING_PROXYJUMP is set
+	cmd := exec.Command("ssh", "-O", "check", "-o", "BatchMode=yes", "-l", PRINCIPAL, "-p", port, host)
+	cmd.Env = append(os.Environ(), ENV_RESOLVING_PROXYJUMP+"=1")
+
+	return cmd.Run() == nil
+}
@@ -0,0 +1,160 @@
+package sshutil
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const (
+	// Environment variable set while resolving ProxyJump chains. As ssh -G
+	// evaluates 'Match exec' blocks, oinit match is invoked recursively and
+	// must not resolve chains itself when this is set. It is also set while
+	// checking for a ControlMaster, see ControlMasterAlive.
+	ENV_RESOLVING_PROXYJUMP = "OINIT_RESOLVING_PROXYJUMP"
+
+	// Maximum depth of nested ProxyJump configurations
+	MAX_PROXYJUMP_DEPTH = 8
+
+	ERR_PROXYJUMP_DEPTH = "ProxyJump chain is too long or contains a loop"
+)
+
+// Hop is a host that is connected to as part of a ProxyJump chain.
+type Hop struct {
+	Host string
+	Port string
+}
+
+// ParseJumpSpec parses a single ProxyJump destination of the form
+// [user@]host[:port] or ssh://[user@]host[:port]. The port is empty if not
+// given.
+func ParseJumpSpec(spec string) Hop {
+	spec = strings.TrimPrefix(spec, "ssh://")
+
+	if i := strings.LastIndex(spec, "@"); i >= 0 {
+		spec = spec[i+1:]
+	}
+
+	if host, port, err := net.SplitHostPort(spec); err == nil {
+		return Hop{Host: host, Port: port}
+	}
+
+	return Hop{Host: strings.Trim(spec, "[]")}
+}
+
+// parseSSHConfig parses the output of ssh -G into a map of lowercase keys
+// and their first value.
+func parseSSHConfig(output string) map[string]string {
+	config := make(map[string]string)
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), " ")
+		if !found {
+			continue
+		}
+
+		key = strings.ToLower(key)
+		if _, ok := config[key]; !ok {
+			config[key] = value
+		}
+	}
+
+	return config
+}
+
+// resolveHop returns the effective host name, port and ProxyJump option of a
+// host according to the user's ssh configuration.
+func resolveHop(hop Hop) (Hop, string, error) {
+	args := []string{"-G"}
+	if hop.Port != "" {
+		args = append(args, "-p", hop.Port)
+	}
+	args = append(args, hop.Host)
+
+	cmd := exec.Command("ssh", args...)
+	cmd.Env = append(os.Environ(), ENV_RESOLVING_PROXYJUMP+"=1")
+
+	out, err := cmd.Output()
+	if err != nil {
+		return hop, "", err
+	}
+
+	config := parseSSHConfig(string(out))
+
+	resolved := Hop{Host: config["hostname"], Port: config["port"]}
+	if resolved.Host == "" {
+		resolved.Host = hop.Host
+	}
+	if resolved.Port == "" {
+		resolved.Port = "22"
+	}
+
+	proxyJump := config["proxyjump"]
+	if proxyJump == "none" {
+		proxyJump = ""
+	}
+
+	return resolved, proxyJump, nil
+}
+
+// ResolveHost returns the host name and port that ssh connects to for the
+// given destination, which may be an alias or short name, by evaluating the
+// user's ssh configuration with ssh -G. Include, Match and host name
+// canonicalization are thus handled by ssh itself.
+func ResolveHost(host, port string) (Hop, error) {
+	resolved, _, err := resolveHop(Hop{Host: host, Port: port})
+	return resolved, err
+}
+
+// ProxyJumpChain returns all hosts that are jumped through when connecting to
+// the given host, in connection order, using the user's ssh configuration.
+// The host itself is not part of the chain.
+func ProxyJumpChain(host, port string) ([]Hop, error) {
+	var chain []Hop
+
+	_, proxyJump, err := resolveHop(Hop{Host: host, Port: port})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := appendProxyJump(&chain, proxyJump, 0); err != nil {
+		return nil, err
+	}
+
+	return chain, nil
+}
+
+// appendProxyJump appends the hosts of the ProxyJump option to chain,
+// preceded by the hosts they are jumped to through themselves.
+func appendProxyJump(chain *[]Hop, proxyJump string, depth int) error {
+	if proxyJump == "" {
+		return nil
+	}
+
+	if depth >= MAX_PROXYJUMP_DEPTH {
+		return errors.New(ERR_PROXYJUMP_DEPTH)
+	}
+
+	for i, spec := range strings.Split(proxyJump, ",") {
+		hop, nested, err := resolveHop(ParseJumpSpec(spec))
+		if err != nil {
+			return err
+		}
+
+		// Only the first jump host is connected to using its own ProxyJump
+		// option, later ones are reached through their predecessor.
+		if i == 0 {
+			if err := appendProxyJump(chain, nested, depth+1); err != nil {
+				return err
+			}
+		}
+
+		*chain = append(*chain, hop)
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package sshutil
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseJumpSpec(t *testing.T) {
+	assert.Equal(t, Hop{Host: "jump.example.com"}, ParseJumpSpec("jump.example.com"))
+	assert.Equal(t, Hop{Host: "jump.example.com", Port: "2222"}, ParseJumpSpec("alice@jump.example.com:2222"))
+	assert.Equal(t, Hop{Host: "jump.example.com", Port: "2222"}, ParseJumpSpec("ssh://alice@jump.example.com:2222"))
+	assert.Equal(t, Hop{Host: "::1", Port: "2222"}, ParseJumpSpec("[::1]:2222"))
+	assert.Equal(t, Hop{Host: "::1"}, ParseJumpSpec("[::1]"))
+}
+
+func TestProxyJumpChain(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ssh is a shell script")
+	}
+
+	// Fake ssh -G: target jumps through j1 and j2, j1 jumps through j0.
+	// The ProxyJump option of j2 is ignored by ssh, as it is reached
+	// through j1.
+	dir := t.TempDir()
+	script := `#!/bin/sh
+for host; do :; done
+echo "hostname $host.example.com"
+case "$host" in
+target) echo "proxyjump alice@j1:2222,j2" ;;
+j1) echo "port 2222"; echo "proxyjump j0" ;;
+j2) echo "proxyjump j3" ;;
+*) echo "proxyjump none" ;;
+esac
+`
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "ssh"), []byte(script), 0755))
+	t.Setenv("PATH", dir)
+
+	chain, err := ProxyJumpChain("target", "22")
+	assert.NoError(t, err)
+	assert.Equal(t, []Hop{
+		{Host: "j0.example.com", Port: "22"},
+		{Host: "j1.example.com", Port: "2222"},
+		{Host: "j2.example.com", Port: "22"},
+	}, chain)
+}
+
+func TestResolveHost(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ssh is a shell script")
+	}
+
+	// Fake ssh -G: 'Match exec' blocks invoking oinit must not match while
+	// resolving, so prod only resolves if ENV_RESOLVING_PROXYJUMP is set.
+	dir := t.TempDir()
+	script := `#!/bin/sh
+for host; do :; done
+[ -n "$` + ENV_RESOLVING_PROXYJUMP + `" ] || exit 1
+case "$host" in
+prod) echo "hostname login.example.com"; echo "port 2222" ;;
+*) echo "hostname $host" ;;
+esac
+`
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "ssh"), []byte(script), 0755))
+	t.Setenv("PATH", dir)
+
+	hop, err := ResolveHost("prod", "22")
+	assert.NoError(t, err)
+	assert.Equal(t, Hop{Host: "login.example.com", Port: "2222"}, hop)
+
+	hop, err = ResolveHost("other.example.com", "22")
+	assert.NoError(t, err)
+	assert.Equal(t, Hop{Host: "other.example.com", Port: "22"}, hop)
+}
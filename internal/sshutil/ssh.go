@@ -3,15 +3,24 @@ package sshutil
 import (
 	"bufio"
 	"errors"
+	"io"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+
+	"github.com/lbrocke/oinit/internal/tokenbind"
 )
 
 const (
 	DEFAULT_SSH_PORT    = 22
 	KNOWN_HOSTS_COMMENT = "Added by oinit"
-	CONFIG_COMMENT      = `# This 'Match' block was added by oinit.
+	// Comment for lines added from a trust bundle, followed by the CA URL.
+	// Lines with this comment are replaced when the bundle is updated.
+	KNOWN_HOSTS_BUNDLE_COMMENT = "Added by oinit from trust bundle of "
+	// Suffix of the backup file created by ssh-keygen(1) as well.
+	BACKUP_SUFFIX  = ".old"
+	CONFIG_COMMENT = `# This 'Match' block was added by oinit.
 #
 # Please make sure it stays positioned on top of your ssh config
 # file, assuring it will be applied before other 'Host' or 'Match'
@@ -85,8 +94,113 @@ func AddSSHKnownHost(host, port, pubkey string) error {
 	return nil
 }
 
+// writeFileAtomic writes data to a temporary file in the same directory and
+// renames it to path afterwards, so readers never see a partially written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	// no-op after successful rename
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// copyFile copies the file src to dst, keeping the permissions of src.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	stat, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, stat.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// InstallSSHKnownHostsBundle replaces all lines previously installed from the
+// trust bundle of the given CA in the user's known_hosts file with the given
+// lines. Before modifying the file, a backup with suffix BACKUP_SUFFIX is
+// created. The file is replaced atomically.
+func InstallSSHKnownHostsBundle(ca string, lines []string) error {
+	paths, err := PathsSSHKnownHosts()
+	if err != nil {
+		return err
+	}
+
+	comment := KNOWN_HOSTS_BUNDLE_COMMENT + ca
+
+	var kept []string
+
+	if fileExists(paths.User) {
+		content, err := os.ReadFile(paths.User)
+		if err != nil {
+			return err
+		}
+
+		if err := copyFile(paths.User, paths.User+BACKUP_SUFFIX); err != nil {
+			return err
+		}
+
+		for _, line := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n") {
+			if len(content) == 0 || strings.HasSuffix(line, " "+comment) {
+				continue
+			}
+
+			kept = append(kept, line)
+		}
+	} else if err := os.MkdirAll(filepath.Dir(paths.User), 0700); err != nil {
+		return err
+	}
+
+	for _, line := range lines {
+		kept = append(kept, line+" "+comment)
+	}
+
+	return writeFileAtomic(paths.User, []byte(strings.Join(kept, "\n")+"\n"), 0600)
+}
+
+// GenerateMatchBlock returns the Match block for hosts managed by oinit. A
+// second block makes ssh send the access token in tokenbind.ENV_TOKEN, if set,
+// to managed hosts that the CA marks as binding logins to the token the
+// certificate was issued for, which must 'AcceptEnv OINIT_BOUND_TOKEN'.
 func GenerateMatchBlock() string {
-	return "Match exec \"oinit match %h %p\"\n\tUser oinit"
+	return "Match exec \"oinit match %h %p\"\n\tUser oinit\n" +
+		"Match exec \"oinit match --token-binding %h %p\"\n\tSendEnv " + tokenbind.ENV_TOKEN
 }
 
 func fileExists(path string) bool {
@@ -95,18 +209,17 @@ func fileExists(path string) bool {
 	return !errors.Is(err, os.ErrNotExist)
 }
 
-// AddSSHMatchBlock adds the string generated by GenerateMatchBlock() to the
-// user's ssh config file, if not already present there or system-wide.
-// Returns boolean that indicates whether the match block was added or not.
-func AddSSHMatchBlock() (bool, error) {
+// HasSSHMatchBlock returns whether the string generated by
+// GenerateMatchBlock() is present in the user's or the system-wide ssh config
+// file.
+func HasSSHMatchBlock() (bool, error) {
 	paths, err := PathsSSHConfig()
 	if err != nil {
 		return false, err
 	}
 
 	// Search for occurrence of 'Match exec ...'
-	block := GenerateMatchBlock()
-	search := strings.Split(block, "\n")[0]
+	search := strings.Split(GenerateMatchBlock(), "\n")[0]
 
 	for _, path := range []string{paths.System, paths.User} {
 		f, err := os.Open(path)
@@ -118,11 +231,29 @@ func AddSSHMatchBlock() (bool, error) {
 		scanner := bufio.NewScanner(f)
 		for scanner.Scan() {
 			if scanner.Text() == search {
-				return false, nil
+				return true, nil
 			}
 		}
 	}
 
+	return false, nil
+}
+
+// AddSSHMatchBlock adds the string generated by GenerateMatchBlock() to the
+// user's ssh config file, if not already present there or system-wide.
+// Returns boolean that indicates whether the match block was added or not.
+func AddSSHMatchBlock() (bool, error) {
+	if present, err := HasSSHMatchBlock(); err != nil || present {
+		return false, err
+	}
+
+	paths, err := PathsSSHConfig()
+	if err != nil {
+		return false, err
+	}
+
+	block := GenerateMatchBlock()
+
 	// Prepend match block to user's ssh config file by reading the existing
 	// content, prepending the string and writing to file using truncate.
 
@@ -0,0 +1,156 @@
+// Package sshversion determines which key types and certificate signature
+// algorithms an OpenSSH server can validate, based on its version. The
+// version is either declared in the configuration or read from the
+// identification string the server sends when connecting (RFC 4253, 4.2).
+package sshversion
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	ERR_UNPARSABLE = "cannot parse OpenSSH version"
+	ERR_NOT_SSH    = "server did not send an SSH identification string"
+
+	// Prefix of the software version in identification strings
+	PREFIX = "OpenSSH_"
+
+	// Port that hosts are probed on
+	PORT = "22"
+	// Maximum length of an identification string, see RFC 4253
+	MAX_BANNER_LENGTH = 255
+	PROBE_TIMEOUT     = 5 * time.Second
+)
+
+// Version is an OpenSSH version such as 8.9, ignoring the portable release.
+type Version struct {
+	Major int
+	Minor int
+}
+
+// RSASHA2Version is the first version validating certificates signed with
+// rsa-sha2-256 and rsa-sha2-512. Later versions no longer accept ssh-rsa
+// (SHA-1) signatures by default.
+var RSASHA2Version = Version{7, 2}
+
+// MinVersions contains the first OpenSSH version supporting certificates for
+// (and signed by) keys of each type. Types not listed are supported by all
+// versions with certificate support.
+var MinVersions = map[string]Version{
+	ssh.KeyAlgoECDSA256:   {5, 7},
+	ssh.KeyAlgoECDSA384:   {5, 7},
+	ssh.KeyAlgoECDSA521:   {5, 7},
+	ssh.KeyAlgoED25519:    {6, 5},
+	ssh.KeyAlgoSKECDSA256: {8, 2},
+	ssh.KeyAlgoSKED25519:  {8, 2},
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// AtLeast reports whether v is the same as or newer than other.
+func (v Version) AtLeast(other Version) bool {
+	return v.Major > other.Major || (v.Major == other.Major && v.Minor >= other.Minor)
+}
+
+// SupportsKeyType reports whether the version supports certificates of the
+// given key type.
+func (v Version) SupportsKeyType(keyType string) bool {
+	min, ok := MinVersions[keyType]
+
+	return !ok || v.AtLeast(min)
+}
+
+// RSASignatureAlgorithm returns the algorithm that certificates for this
+// version must be signed with by RSA CA keys.
+func (v Version) RSASignatureAlgorithm() string {
+	if v.AtLeast(RSASHA2Version) {
+		return ssh.KeyAlgoRSASHA512
+	}
+
+	return ssh.KeyAlgoRSA
+}
+
+// Parse parses a version such as "8.9", "8.9p1", "OpenSSH_8.9p1" or a full
+// identification string such as "SSH-2.0-OpenSSH_8.9p1 Ubuntu-3".
+func Parse(s string) (Version, error) {
+	s = strings.TrimSpace(s)
+
+	if strings.HasPrefix(s, "SSH-") {
+		_, software, _ := strings.Cut(strings.TrimPrefix(s, "SSH-"), "-")
+		software, _, _ = strings.Cut(software, " ")
+
+		if !strings.HasPrefix(software, PREFIX) {
+			return Version{}, errors.New(ERR_UNPARSABLE)
+		}
+
+		s = software
+	}
+
+	s = strings.TrimPrefix(s, PREFIX)
+
+	// Strip the portable release, such as "p1"
+	s, _, _ = strings.Cut(s, "p")
+
+	major, minor, ok := strings.Cut(s, ".")
+	if !ok {
+		return Version{}, errors.New(ERR_UNPARSABLE)
+	}
+
+	var v Version
+	var err1, err2 error
+
+	v.Major, err1 = strconv.Atoi(major)
+	v.Minor, err2 = strconv.Atoi(minor)
+	if err1 != nil || err2 != nil || v.Major < 0 || v.Minor < 0 {
+		return Version{}, errors.New(ERR_UNPARSABLE)
+	}
+
+	return v, nil
+}
+
+// Probe connects to the SSH server of host and parses the version from its
+// identification string. Servers may send other lines before it.
+func Probe(ctx context.Context, host string) (Version, error) {
+	return probe(ctx, net.JoinHostPort(host, PORT))
+}
+
+func probe(ctx context.Context, addr string) (Version, error) {
+	ctx, cancel := context.WithTimeout(ctx, PROBE_TIMEOUT)
+	defer cancel()
+
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return Version{}, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	reader := bufio.NewReaderSize(conn, MAX_BANNER_LENGTH)
+
+	for {
+		line, err := reader.ReadSlice('\n')
+		if err != nil {
+			return Version{}, errors.New(ERR_NOT_SSH)
+		}
+
+		if strings.HasPrefix(string(line), "SSH-") {
+			return Parse(string(line))
+		}
+	}
+}
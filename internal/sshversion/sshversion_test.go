@@ -0,0 +1,62 @@
+package sshversion
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParse(t *testing.T) {
+	for input, expected := range map[string]Version{
+		"8.9":                                {8, 9},
+		"7.4p1":                              {7, 4},
+		"OpenSSH_9.6":                        {9, 6},
+		"SSH-2.0-OpenSSH_8.9p1 Ubuntu-3\r\n": {8, 9},
+	} {
+		v, err := Parse(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, expected, v, input)
+	}
+
+	for _, input := range []string{"", "8", "a.b", "SSH-2.0-dropbear_2022.83", "-1.0"} {
+		_, err := Parse(input)
+		assert.EqualError(t, err, ERR_UNPARSABLE, input)
+	}
+}
+
+func TestSupports(t *testing.T) {
+	old := Version{6, 4}
+	assert.True(t, old.SupportsKeyType(ssh.KeyAlgoRSA))
+	assert.True(t, old.SupportsKeyType(ssh.KeyAlgoECDSA256))
+	assert.False(t, old.SupportsKeyType(ssh.KeyAlgoED25519))
+	assert.Equal(t, ssh.KeyAlgoRSA, old.RSASignatureAlgorithm())
+
+	current := Version{9, 0}
+	assert.True(t, current.SupportsKeyType(ssh.KeyAlgoSKED25519))
+	assert.Equal(t, ssh.KeyAlgoRSASHA512, current.RSASignatureAlgorithm())
+}
+
+func TestProbe(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.Write([]byte("Welcome\r\nSSH-2.0-OpenSSH_7.4\r\n"))
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	v, err := probe(context.Background(), net.JoinHostPort(host, port))
+	assert.NoError(t, err)
+	assert.Equal(t, Version{7, 4}, v)
+}
@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Modifications that may be lost if the CA crashes are written at most this
+// often, see MemoryStore.modifyLazily.
+const FLUSH_INTERVAL = time.Second
+
+// FileStore keeps all state in memory and writes it to a single JSON file
+// after modifications. The file is replaced atomically, so it is always
+// consistent even if the CA crashes while writing. Frequent modifications,
+// such as rate-limit counters, are batched and written every FLUSH_INTERVAL
+// and by Close. Every write serializes the whole state, so its cost grows
+// with the number of certificates and audit events.
+type FileStore struct {
+	*MemoryStore
+	path string
+
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewFileStore opens the store at the given path. The file is created on the
+// first modification if it doesn't exist.
+func NewFileStore(path string, opts ...Option) (*FileStore, error) {
+	if path == "" {
+		return nil, errors.New("missing path for file storage")
+	}
+
+	fs := &FileStore{
+		MemoryStore: NewMemoryStore(opts...),
+		path:        path,
+		stop:        make(chan struct{}),
+	}
+
+	s, err := readState(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	} else if err == nil {
+		fs.MemoryStore.state = s
+	}
+
+	fs.MemoryStore.persist = fs.write
+
+	go fs.flushPeriodically()
+
+	return fs, nil
+}
+
+// flushPeriodically writes batched modifications every FLUSH_INTERVAL until
+// the store is closed.
+func (f *FileStore) flushPeriodically() {
+	ticker := time.NewTicker(FLUSH_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := f.Flush(); err != nil {
+				f.logger.Println("Could not write storage file: " + err.Error())
+			}
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+// Done returns a channel that is closed when the store is closed.
+func (f *FileStore) Done() <-chan struct{} {
+	return f.stop
+}
+
+// Close writes batched modifications and stops writing them periodically.
+func (f *FileStore) Close() error {
+	f.closeOnce.Do(func() { close(f.stop) })
+
+	return f.Flush()
+}
+
+// readState reads the state from the file at path.
+func readState(path string) (state, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return state{}, err
+	}
+
+	s := newState()
+	if err := json.Unmarshal(content, &s); err != nil {
+		return state{}, err
+	}
+
+	// Make sure maps are non-nil after loading an older file.
+	if s.Certificates == nil {
+		s.Certificates = make(map[uint64]Certificate)
+	}
+	if s.Revocations == nil {
+		s.Revocations = make(map[uint64]Revocation)
+	}
+	if s.Counters == nil {
+		s.Counters = make(map[string]counter)
+	}
+	if s.Seen == nil {
+		s.Seen = make(map[string]seen)
+	}
+	if s.HostKeys == nil {
+		s.HostKeys = make(map[string]HostKeys)
+	}
+	if s.Decisions == nil {
+		s.Decisions = make(map[string]Decision)
+	}
+	if s.Idempotency == nil {
+		s.Idempotency = make(map[string]IdempotentResponse)
+	}
+	if s.Enrollments == nil {
+		s.Enrollments = make(map[string]Enrollment)
+	}
+	if s.Freezes == nil {
+		s.Freezes = make(map[string]Freeze)
+	}
+
+	return s, nil
+}
+
+// write atomically replaces the file with the given state.
+func (f *FileStore) write(s state) error {
+	content, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f.path), 0700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), "."+filepath.Base(f.path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	// no-op after successful rename
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), f.path)
+}
@@ -0,0 +1,515 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+type counter struct {
+	Value   int       `json:"value"`
+	Expires time.Time `json:"expires"`
+}
+
+type seen struct {
+	Value   string    `json:"value"`
+	Expires time.Time `json:"expires"`
+}
+
+// state is the complete content of a store. Its fields are exported to be
+// serializable by the file backend.
+type state struct {
+	Serial       uint64                        `json:"serial"`
+	Certificates map[uint64]Certificate        `json:"certificates"`
+	Revocations  map[uint64]Revocation         `json:"revocations"`
+	Audit        []AuditEvent                  `json:"audit"`
+	Counters     map[string]counter            `json:"counters"`
+	Seen         map[string]seen               `json:"seen"`
+	HostKeys     map[string]HostKeys           `json:"hostkeys"`
+	Decisions    map[string]Decision           `json:"decisions"`
+	Idempotency  map[string]IdempotentResponse `json:"idempotency"`
+	Enrollments  map[string]Enrollment         `json:"enrollments"`
+	Freezes      map[string]Freeze             `json:"freezes"`
+	// Not pruned, as it is compared with the configuration at startup
+	ConfigSnapshot map[string]string `json:"config_snapshot,omitempty"`
+}
+
+func newState() state {
+	return state{
+		Certificates: make(map[uint64]Certificate),
+		Revocations:  make(map[uint64]Revocation),
+		Counters:     make(map[string]counter),
+		Seen:         make(map[string]seen),
+		HostKeys:     make(map[string]HostKeys),
+		Decisions:    make(map[string]Decision),
+		Idempotency:  make(map[string]IdempotentResponse),
+		Enrollments:  make(map[string]Enrollment),
+		Freezes:      make(map[string]Freeze),
+	}
+}
+
+// prune removes expired entries which are older than RETENTION, and audit
+// events older than auditRetention unless it is negative.
+func (s *state) prune(now time.Time, auditRetention time.Duration) {
+	for serial, cert := range s.Certificates {
+		if now.Sub(cert.ValidBefore) > RETENTION {
+			delete(s.Certificates, serial)
+		}
+	}
+
+	for serial, rev := range s.Revocations {
+		if now.Sub(rev.ValidBefore) > RETENTION {
+			delete(s.Revocations, serial)
+		}
+	}
+
+	if auditRetention >= 0 {
+		i := 0
+		for ; i < len(s.Audit) && now.Sub(s.Audit[i].Time) > auditRetention; i++ {
+		}
+		s.Audit = s.Audit[i:]
+	}
+
+	for key, c := range s.Counters {
+		if now.After(c.Expires) {
+			delete(s.Counters, key)
+		}
+	}
+
+	for key, v := range s.Seen {
+		if now.After(v.Expires) {
+			delete(s.Seen, key)
+		}
+	}
+
+	for id, d := range s.Decisions {
+		if now.Sub(d.Time) > DECISION_RETENTION {
+			delete(s.Decisions, id)
+		}
+	}
+
+	for key, r := range s.Idempotency {
+		if now.After(r.Expires) {
+			delete(s.Idempotency, key)
+		}
+	}
+
+	for id, e := range s.Enrollments {
+		if now.Sub(e.RequestedAt) > RETENTION {
+			delete(s.Enrollments, id)
+		}
+	}
+
+	for group, f := range s.Freezes {
+		if f.Expired(now) {
+			delete(s.Freezes, group)
+		}
+	}
+}
+
+// MemoryStore keeps all state in memory. It is also used by FileStore, which
+// persists the state after modifications.
+type MemoryStore struct {
+	mu    sync.Mutex
+	state state
+	// called with the lock held to persist modifications
+	persist func(state) error
+	// whether there are modifications that were not persisted yet
+	dirty bool
+	// last serial number issued from the block reserved in state.Serial,
+	// zero if no serial number was issued since loading the state
+	issued         uint64
+	auditRetention time.Duration
+	randomSerials  bool
+	logger         *log.Logger
+}
+
+// NewMemoryStore returns a new, empty MemoryStore.
+func NewMemoryStore(opts ...Option) *MemoryStore {
+	m := &MemoryStore{
+		state:          newState(),
+		auditRetention: AUDIT_RETENTION,
+		logger:         log.Default(),
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+}
+
+// modify runs fn with the lock held and persists the state afterwards.
+func (m *MemoryStore) modify(fn func(s *state) error) error {
+	return m.apply(fn, true)
+}
+
+// modifyLazily is like modify, but the modification is only persisted by the
+// next Flush or modify. It is used for frequent modifications that may be
+// lost if the CA crashes, such as rate-limit counters and seen tokens.
+func (m *MemoryStore) modifyLazily(fn func(s *state) error) error {
+	return m.apply(fn, false)
+}
+
+// apply runs fn with the lock held, prunes expired entries and persists the
+// state if requested.
+func (m *MemoryStore) apply(fn func(s *state) error, persist bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := fn(&m.state); err != nil {
+		return err
+	}
+
+	m.state.prune(time.Now(), m.auditRetention)
+
+	m.dirty = true
+
+	if !persist {
+		return nil
+	}
+
+	return m.flush()
+}
+
+// flush persists the state if it was modified since it was last persisted.
+// m.mu must be held.
+func (m *MemoryStore) flush() error {
+	if !m.dirty || m.persist == nil {
+		return nil
+	}
+
+	if err := m.persist(m.state); err != nil {
+		return err
+	}
+
+	m.dirty = false
+
+	return nil
+}
+
+// Flush persists modifications that were not persisted yet.
+func (m *MemoryStore) Flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.flush()
+}
+
+// NextSerial returns a new serial number. Serial numbers are reserved in
+// blocks of SERIAL_RESERVATION, so the state is only persisted when a new
+// block is reserved. After loading the state, serial numbers continue after
+// the last reserved block, as any of it may have been issued. Random serial
+// numbers are returned instead if enabled by WithRandomSerials.
+func (m *MemoryStore) NextSerial() (uint64, error) {
+	if m.randomSerials {
+		var b [8]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return 0, err
+		}
+
+		return binary.BigEndian.Uint64(b[:]) | RANDOM_SERIAL_BIT, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.issued == 0 || m.issued >= m.state.Serial {
+		reserved := m.state.Serial
+
+		m.state.Serial += SERIAL_RESERVATION
+		m.dirty = true
+
+		if err := m.flush(); err != nil {
+			m.state.Serial = reserved
+			return 0, err
+		}
+
+		m.issued = reserved
+	}
+
+	m.issued++
+
+	return m.issued, nil
+}
+
+func (m *MemoryStore) AddCertificate(cert Certificate) error {
+	return m.modify(func(s *state) error {
+		s.Certificates[cert.Serial] = cert
+
+		return nil
+	})
+}
+
+func (m *MemoryStore) GetCertificate(serial uint64) (Certificate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cert, ok := m.state.Certificates[serial]
+	if !ok {
+		return cert, fmt.Errorf("%w: certificate %d", ErrNotFound, serial)
+	}
+
+	return cert, nil
+}
+
+func (m *MemoryStore) ListCertificates(filter CertificateFilter) ([]Certificate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	certs := []Certificate{}
+	for _, cert := range m.state.Certificates {
+		if filter.matches(cert) {
+			certs = append(certs, cert)
+		}
+	}
+
+	sort.Slice(certs, func(i, j int) bool { return certs[i].Serial < certs[j].Serial })
+
+	return certs, nil
+}
+
+func (m *MemoryStore) Revoke(revocation Revocation) error {
+	return m.modify(func(s *state) error {
+		if _, ok := s.Revocations[revocation.Serial]; ok {
+			return fmt.Errorf("%w: certificate %d", ErrAlreadyRevoked, revocation.Serial)
+		}
+
+		s.Revocations[revocation.Serial] = revocation
+
+		return nil
+	})
+}
+
+func (m *MemoryStore) ListRevocations() ([]Revocation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	revs := []Revocation{}
+	for _, rev := range m.state.Revocations {
+		revs = append(revs, rev)
+	}
+
+	sort.Slice(revs, func(i, j int) bool { return revs[i].Serial < revs[j].Serial })
+
+	return revs, nil
+}
+
+func (m *MemoryStore) AddAuditEvent(event AuditEvent) error {
+	return m.modify(func(s *state) error {
+		s.Audit = append(s.Audit, event)
+
+		return nil
+	})
+}
+
+func (m *MemoryStore) ListAuditEvents(since time.Time) ([]AuditEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	events := []AuditEvent{}
+	for _, event := range m.state.Audit {
+		if !event.Time.Before(since) {
+			events = append(events, event)
+		}
+	}
+
+	return events, nil
+}
+
+func (m *MemoryStore) Increment(key string, window time.Duration) (int, error) {
+	var value int
+
+	err := m.modifyLazily(func(s *state) error {
+		now := time.Now()
+
+		c, ok := s.Counters[key]
+		if !ok || now.After(c.Expires) {
+			c = counter{Expires: now.Add(window)}
+		}
+
+		c.Value++
+		s.Counters[key] = c
+		value = c.Value
+
+		return nil
+	})
+
+	return value, err
+}
+
+func (m *MemoryStore) MarkSeen(key, value string, window time.Duration) (string, error) {
+	var previous string
+
+	err := m.modifyLazily(func(s *state) error {
+		now := time.Now()
+
+		if v, ok := s.Seen[key]; ok && !now.After(v.Expires) {
+			previous = v.Value
+			return nil
+		}
+
+		s.Seen[key] = seen{Value: value, Expires: now.Add(window)}
+
+		return nil
+	})
+
+	return previous, err
+}
+
+func (m *MemoryStore) SetHostKeys(keys HostKeys) error {
+	return m.modify(func(s *state) error {
+		s.HostKeys[keys.Host] = keys
+
+		return nil
+	})
+}
+
+func (m *MemoryStore) GetHostKeys(host string) (HostKeys, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys, ok := m.state.HostKeys[host]
+	if !ok {
+		return keys, fmt.Errorf("%w: host keys of %s", ErrNotFound, host)
+	}
+
+	return keys, nil
+}
+
+func (m *MemoryStore) AddDecision(decision Decision) error {
+	return m.modifyLazily(func(s *state) error {
+		s.Decisions[decision.RequestID] = decision
+
+		return nil
+	})
+}
+
+func (m *MemoryStore) GetDecision(requestID string) (Decision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	decision, ok := m.state.Decisions[requestID]
+	if !ok {
+		return decision, fmt.Errorf("%w: decision %s", ErrNotFound, requestID)
+	}
+
+	return decision, nil
+}
+
+func (m *MemoryStore) SetIdempotentResponse(response IdempotentResponse) error {
+	return m.modifyLazily(func(s *state) error {
+		s.Idempotency[response.Key] = response
+
+		return nil
+	})
+}
+
+func (m *MemoryStore) GetIdempotentResponse(key string) (IdempotentResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	response, ok := m.state.Idempotency[key]
+	if !ok || time.Now().After(response.Expires) {
+		return IdempotentResponse{}, fmt.Errorf("%w: idempotent response %s", ErrNotFound, key)
+	}
+
+	return response, nil
+}
+
+func (m *MemoryStore) SetEnrollment(enrollment Enrollment) error {
+	return m.modify(func(s *state) error {
+		s.Enrollments[enrollment.ID] = enrollment
+
+		return nil
+	})
+}
+
+func (m *MemoryStore) GetEnrollment(id string) (Enrollment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	enrollment, ok := m.state.Enrollments[id]
+	if !ok {
+		return enrollment, fmt.Errorf("%w: enrollment %s", ErrNotFound, id)
+	}
+
+	return enrollment, nil
+}
+
+func (m *MemoryStore) ListEnrollments(state string) ([]Enrollment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	enrollments := []Enrollment{}
+	for _, e := range m.state.Enrollments {
+		if state == "" || e.State == state {
+			enrollments = append(enrollments, e)
+		}
+	}
+
+	sort.Slice(enrollments, func(i, j int) bool {
+		return enrollments[i].RequestedAt.Before(enrollments[j].RequestedAt)
+	})
+
+	return enrollments, nil
+}
+
+func (m *MemoryStore) SetFreeze(freeze Freeze) error {
+	return m.modify(func(s *state) error {
+		s.Freezes[freeze.HostGroup] = freeze
+
+		return nil
+	})
+}
+
+func (m *MemoryStore) GetFreeze(hostGroup string) (Freeze, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	freeze, ok := m.state.Freezes[hostGroup]
+	if !ok || freeze.Expired(time.Now()) {
+		return Freeze{}, fmt.Errorf("%w: freeze of hostgroup %s", ErrNotFound, hostGroup)
+	}
+
+	return freeze, nil
+}
+
+func (m *MemoryStore) DeleteFreeze(hostGroup string) error {
+	return m.modify(func(s *state) error {
+		if f, ok := s.Freezes[hostGroup]; !ok || f.Expired(time.Now()) {
+			return fmt.Errorf("%w: freeze of hostgroup %s", ErrNotFound, hostGroup)
+		}
+
+		delete(s.Freezes, hostGroup)
+
+		return nil
+	})
+}
+
+func (m *MemoryStore) SetConfigSnapshot(snapshot map[string]string) error {
+	return m.modify(func(s *state) error {
+		s.ConfigSnapshot = snapshot
+
+		return nil
+	})
+}
+
+func (m *MemoryStore) GetConfigSnapshot() (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.state.ConfigSnapshot == nil {
+		return nil, fmt.Errorf("%w: config snapshot", ErrNotFound)
+	}
+
+	return m.state.ConfigSnapshot, nil
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	ERR_REPLICA = "replica has not been promoted"
+
+	// Serial numbers of a promoted replica continue after this gap, so they
+	// don't collide with certificates that the primary signed after its
+	// state was last replicated. It is also skipped when a replica starts
+	// promoted, which is harmless.
+	REPLICA_SERIAL_GAP = 1000000
+
+	// Actor of promotions by the promote file
+	PROMOTED_BY_FILE = "promote-file"
+)
+
+var ErrReplica = errors.New(ERR_REPLICA)
+
+// Promotion describes when and by whom a replica was promoted.
+type Promotion struct {
+	By string    `json:"by"`
+	At time.Time `json:"at"`
+}
+
+// ReplicaStore is the store of a warm standby CA, whose storage file is
+// replicated from a primary CA, e.g. by rsync or a shared volume. Until the
+// replica is promoted, Sync reloads the state from the file, modifications
+// are kept in memory only so the file is never written, and NextSerial fails
+// with ErrReplica so that certificates are never signed by both CAs. Once
+// promoted, it behaves like a FileStore.
+type ReplicaStore struct {
+	*FileStore
+	// The replica is promoted when this file exists, empty if it can only
+	// be promoted by Promote
+	promoteFile string
+
+	mu        sync.Mutex
+	promotion *Promotion
+}
+
+// NewReplicaStore opens the replicated store at the given path, which is
+// promoted right away if the promote file exists.
+func NewReplicaStore(path, promoteFile string, opts ...Option) (*ReplicaStore, error) {
+	fs, err := NewFileStore(path, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.MemoryStore.persist = nil
+
+	r := &ReplicaStore{
+		FileStore:   fs,
+		promoteFile: promoteFile,
+	}
+
+	if err := r.Sync(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Promotion returns when and by whom the replica was promoted, and whether
+// it was promoted at all.
+func (r *ReplicaStore) Promotion() (Promotion, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.promotion == nil {
+		return Promotion{}, false
+	}
+
+	return *r.promotion, true
+}
+
+// Promoted reports whether the replica was promoted.
+func (r *ReplicaStore) Promoted() bool {
+	_, promoted := r.Promotion()
+	return promoted
+}
+
+// Sync promotes the replica if the promote file exists, and otherwise
+// reloads the replicated state from the file. It does nothing once the
+// replica was promoted.
+func (r *ReplicaStore) Sync() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.promotion != nil {
+		return nil
+	}
+
+	if r.promoteFile != "" {
+		if _, err := os.Stat(r.promoteFile); err == nil {
+			return r.promote(PROMOTED_BY_FILE)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return r.reload()
+}
+
+// Promote makes the replica a primary CA, which signs certificates and
+// persists its state. The state is reloaded a last time, so replication must
+// have been stopped before. If configured, the promote file is created, so
+// the replica stays promoted after a restart. Promoting a promoted replica
+// does nothing.
+func (r *ReplicaStore) Promote(actor string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.promotion != nil {
+		return nil
+	}
+
+	if err := r.promote(actor); err != nil {
+		return err
+	}
+
+	if r.promoteFile == "" {
+		return nil
+	}
+
+	// Fails if the file was created since the last Sync, which promoted
+	// the replica anyway
+	file, err := os.OpenFile(r.promoteFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	_, err = file.WriteString("promoted by " + actor + " at " + r.promotion.At.UTC().Format(time.RFC3339) + "\n")
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// reload replaces the state with the content of the file, unless nothing was
+// replicated yet. r.mu must be held.
+func (r *ReplicaStore) reload() error {
+	s, err := readState(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	r.MemoryStore.mu.Lock()
+	r.MemoryStore.state = s
+	r.MemoryStore.issued = 0
+	r.MemoryStore.mu.Unlock()
+
+	return nil
+}
+
+// promote reloads the state a last time, skips REPLICA_SERIAL_GAP serial
+// numbers and persists the state from now on. r.mu must be held.
+func (r *ReplicaStore) promote(actor string) error {
+	if err := r.reload(); err != nil {
+		return err
+	}
+
+	r.MemoryStore.mu.Lock()
+	defer r.MemoryStore.mu.Unlock()
+
+	r.MemoryStore.state.Serial += REPLICA_SERIAL_GAP
+
+	if err := r.FileStore.write(r.MemoryStore.state); err != nil {
+		r.MemoryStore.state.Serial -= REPLICA_SERIAL_GAP
+		return err
+	}
+
+	r.MemoryStore.persist = r.FileStore.write
+	r.promotion = &Promotion{By: actor, At: time.Now()}
+
+	return nil
+}
+
+// NextSerial returns a new serial number, or ErrReplica if the replica was
+// not promoted.
+func (r *ReplicaStore) NextSerial() (uint64, error) {
+	if !r.Promoted() {
+		return 0, ErrReplica
+	}
+
+	return r.FileStore.NextSerial()
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplicaStore(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+	promoteFile := filepath.Join(dir, "promoted")
+
+	primary, err := NewFileStore(path)
+	assert.NoError(t, err)
+
+	serial, _ := primary.NextSerial()
+	now := time.Now()
+	assert.NoError(t, primary.AddCertificate(Certificate{Serial: serial, ValidAfter: now, ValidBefore: now.Add(time.Hour)}))
+
+	replica, err := NewReplicaStore(path, promoteFile)
+	assert.NoError(t, err)
+	assert.False(t, replica.Promoted())
+
+	_, err = replica.NextSerial()
+	assert.ErrorIs(t, err, ErrReplica)
+
+	// Modifications of the replica are not written to the replicated file
+	assert.NoError(t, replica.SetFreeze(Freeze{HostGroup: "example.com", Frozen: true}))
+	content, _ := os.ReadFile(path)
+	assert.NotContains(t, string(content), "example.com")
+
+	// State of the primary is picked up by Sync
+	assert.NoError(t, primary.Revoke(Revocation{Serial: serial, RevokedAt: now, ValidBefore: now.Add(time.Hour)}))
+	assert.NoError(t, replica.Sync())
+
+	revocations, _ := replica.ListRevocations()
+	assert.Len(t, revocations, 1)
+
+	// Creating the promote file promotes the replica on the next Sync
+	assert.NoError(t, os.WriteFile(promoteFile, nil, 0600))
+	assert.NoError(t, replica.Sync())
+
+	promotion, ok := replica.Promotion()
+	assert.True(t, ok)
+	assert.Equal(t, PROMOTED_BY_FILE, promotion.By)
+
+	serial, err = replica.NextSerial()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(SERIAL_RESERVATION+REPLICA_SERIAL_GAP+1), serial)
+
+	// The promoted replica persists its state
+	reopened, err := NewFileStore(path)
+	assert.NoError(t, err)
+
+	serial, _ = reopened.NextSerial()
+	assert.Equal(t, uint64(2*SERIAL_RESERVATION+REPLICA_SERIAL_GAP+1), serial)
+}
+
+func TestReplicaStorePromote(t *testing.T) {
+	dir := t.TempDir()
+	promoteFile := filepath.Join(dir, "promoted")
+
+	replica, err := NewReplicaStore(filepath.Join(dir, "state.json"), promoteFile)
+	assert.NoError(t, err)
+
+	assert.NoError(t, replica.Promote("alice"))
+	assert.NoError(t, replica.Promote("bob"))
+
+	promotion, ok := replica.Promotion()
+	assert.True(t, ok)
+	assert.Equal(t, "alice", promotion.By)
+
+	content, err := os.ReadFile(promoteFile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "promoted by alice")
+
+	// The replica stays promoted after a restart
+	replica, err = NewReplicaStore(filepath.Join(dir, "state.json"), promoteFile)
+	assert.NoError(t, err)
+	assert.True(t, replica.Promoted())
+}
@@ -0,0 +1,338 @@
+// Package storage defines the interface to persistent state of the CA, such
+// as certificate serial numbers, issued certificates, revocations, audit
+// events, rate-limit counters, recently seen tokens, reported host keys,
+// decision traces, idempotent responses of certificate requests, host
+// enrollments and issuance freezes.
+//
+// Backends are selected by a URL-like string:
+//
+//	memory:                            non-persistent, for testing
+//	file:/var/lib/oinit-ca/state.json  single JSON file, written atomically
+//
+// The CA uses the file backend at DEFAULT_PATH unless configured otherwise.
+//
+// Warm standby CAs open the replicated file of a primary CA with
+// NewReplicaStore instead.
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	ERR_UNKNOWN_BACKEND = "unknown storage backend"
+	ERR_NOT_FOUND       = "not found"
+	ERR_ALREADY_REVOKED = "certificate is already revoked"
+	ERR_UNKNOWN_REASON  = "unknown revocation reason"
+
+	BACKEND_MEMORY = "memory"
+	BACKEND_FILE   = "file"
+
+	// Default path of the file backend
+	DEFAULT_PATH = "/var/lib/oinit-ca/state.json"
+
+	// Expired certificates and revocations are removed after this duration.
+	RETENTION = 30 * 24 * time.Hour
+	// Audit events are removed after this duration by default, see
+	// WithAuditRetention.
+	AUDIT_RETENTION = 365 * 24 * time.Hour
+
+	// Serial numbers are reserved in blocks of this size, see
+	// Store.NextSerial. Unused serial numbers of the last block are skipped
+	// after a restart.
+	SERIAL_RESERVATION = 1000
+	// Set in all serial numbers returned with WithRandomSerials
+	RANDOM_SERIAL_BIT = 1 << 63
+	// Decision traces are larger and only needed for support requests, they
+	// are removed after this duration.
+	DECISION_RETENTION = 7 * 24 * time.Hour
+
+	// Reasons of revocations, as required by incident response procedures
+	REASON_KEY_COMPROMISE   = "key-compromise"
+	REASON_USER_LEFT        = "user-left"
+	REASON_POLICY_VIOLATION = "policy-violation"
+	REASON_SUPERSEDED       = "superseded"
+
+	// States of host enrollments
+	ENROLLMENT_PENDING  = "pending"
+	ENROLLMENT_APPROVED = "approved"
+	ENROLLMENT_REJECTED = "rejected"
+)
+
+// RevocationReasons contains all reasons that certificates can be revoked
+// for.
+var RevocationReasons = []string{REASON_KEY_COMPROMISE, REASON_USER_LEFT, REASON_POLICY_VIOLATION, REASON_SUPERSEDED}
+
+// Errors returned by stores, wrapped with the record concerned, so use
+// errors.Is to test for them.
+var (
+	ErrNotFound       = errors.New(ERR_NOT_FOUND)
+	ErrAlreadyRevoked = errors.New(ERR_ALREADY_REVOKED)
+	ErrUnknownReason  = errors.New(ERR_UNKNOWN_REASON)
+)
+
+// Certificate is a record of an issued certificate.
+type Certificate struct {
+	Serial      uint64 `json:"serial"`
+	KeyId       string `json:"key_id"`
+	Host        string `json:"host"`
+	HostGroup   string `json:"hostgroup"`
+	Subject     string `json:"subject"`
+	Username    string `json:"username"`
+	Fingerprint string `json:"fingerprint"`
+	CA          string `json:"ca"` // fingerprint of the signing CA key
+	// Communities/VOs the certificate is accounted to
+	VOs         []string  `json:"vos,omitempty"`
+	ValidAfter  time.Time `json:"valid_after"`
+	ValidBefore time.Time `json:"valid_before"`
+	IssuedAt    time.Time `json:"issued_at"`
+}
+
+// Revocation is a record of a revoked certificate.
+type Revocation struct {
+	Serial    uint64    `json:"serial"`
+	CA        string    `json:"ca"`
+	RevokedAt time.Time `json:"revoked_at"`
+	// One of RevocationReasons, empty for revocations recorded before
+	// reasons were required
+	Reason string `json:"reason,omitempty" example:"key-compromise"`
+	// Expiry of the revoked certificate, after which the revocation is
+	// irrelevant
+	ValidBefore time.Time `json:"valid_before"`
+}
+
+// AuditEvent is a single entry of the audit trail.
+type AuditEvent struct {
+	Time    time.Time         `json:"time"`
+	Action  string            `json:"action"`
+	Actor   string            `json:"actor"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// HostKeys are the SSH host keys a host reported to the CA.
+type HostKeys struct {
+	Host string `json:"host"`
+	// Public keys in authorized_keys format
+	Keys []string `json:"keys"`
+	// Host certificate the report was authenticated with
+	Certificate string    `json:"certificate"`
+	ReportedAt  time.Time `json:"reported_at"`
+}
+
+// DecisionStep is a single check made while deciding on a request.
+type DecisionStep struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Decision is the trace of a certificate request, so support staff can tell
+// why a request was denied.
+type Decision struct {
+	RequestID string    `json:"request_id"`
+	Time      time.Time `json:"time"`
+	Host      string    `json:"host"`
+	Subject   string    `json:"subject,omitempty"`
+	// Unverified claims of the access token
+	Claims  map[string]interface{} `json:"claims,omitempty"`
+	Steps   []DecisionStep         `json:"steps"`
+	Status  int                    `json:"status"`
+	Code    string                 `json:"code,omitempty"`
+	Outcome string                 `json:"outcome"`
+}
+
+// IdempotentResponse is a certificate issued for a request with an
+// Idempotency-Key, returned again when the request is retried.
+type IdempotentResponse struct {
+	Key string `json:"key"`
+	// Hash of the request the key was first used for
+	RequestHash string    `json:"request_hash"`
+	Serial      uint64    `json:"serial"`
+	Certificate string    `json:"certificate"`
+	Expires     time.Time `json:"expires"`
+}
+
+// Enrollment is a request of a host for a host certificate, which is held
+// pending until an admin or an auto-approval rule approves it.
+type Enrollment struct {
+	ID        string `json:"id"`
+	Host      string `json:"host"`
+	HostGroup string `json:"hostgroup"`
+	// Host public key in authorized_keys format
+	PublicKey   string `json:"publickey"`
+	Fingerprint string `json:"fingerprint"`
+	// Address the request was received from
+	RemoteAddr  string    `json:"remote_addr"`
+	State       string    `json:"state" example:"pending"`
+	RequestedAt time.Time `json:"requested_at"`
+	// Admin or auto-approval rule that decided on the enrollment
+	DecidedBy string    `json:"decided_by,omitempty"`
+	DecidedAt time.Time `json:"decided_at,omitempty"`
+	// Host certificate issued for approved enrollments
+	Serial      uint64 `json:"serial,omitempty"`
+	Certificate string `json:"certificate,omitempty"`
+}
+
+// Freeze pauses certificate issuance for a hostgroup, or lifts its scheduled
+// freeze windows, until it expires or is removed. It is set by admins.
+type Freeze struct {
+	HostGroup string `json:"hostgroup"`
+	// Whether issuance is paused, false lifts scheduled freeze windows
+	Frozen bool   `json:"frozen"`
+	Reason string `json:"reason,omitempty" example:"Incident 2024-17"`
+	// Zero if the freeze lasts until it is removed
+	Until time.Time `json:"until,omitempty"`
+	SetBy string    `json:"set_by"`
+	SetAt time.Time `json:"set_at"`
+}
+
+// Expired reports whether the freeze has expired at now.
+func (f Freeze) Expired(now time.Time) bool {
+	return !f.Until.IsZero() && !now.Before(f.Until)
+}
+
+// CertificateFilter restricts the certificates returned by
+// Store.ListCertificates. Zero values match any certificate.
+type CertificateFilter struct {
+	Subject   string
+	HostGroup string
+	// Only return certificates that are valid at this time
+	ValidAt time.Time
+}
+
+func (f CertificateFilter) matches(cert Certificate) bool {
+	return (f.Subject == "" || f.Subject == cert.Subject) &&
+		(f.HostGroup == "" || f.HostGroup == cert.HostGroup) &&
+		(f.ValidAt.IsZero() || (!f.ValidAt.Before(cert.ValidAfter) && f.ValidAt.Before(cert.ValidBefore)))
+}
+
+// Store is implemented by all storage backends. Implementations must be safe
+// for concurrent use.
+type Store interface {
+	// NextSerial returns a new, unique certificate serial number.
+	NextSerial() (uint64, error)
+
+	// AddCertificate records an issued certificate.
+	AddCertificate(cert Certificate) error
+	// GetCertificate returns the certificate with the given serial.
+	GetCertificate(serial uint64) (Certificate, error)
+	// ListCertificates returns all certificates matching the filter, ordered
+	// by serial.
+	ListCertificates(filter CertificateFilter) ([]Certificate, error)
+
+	// Revoke records a revocation.
+	Revoke(revocation Revocation) error
+	// ListRevocations returns all revocations, ordered by serial.
+	ListRevocations() ([]Revocation, error)
+
+	// AddAuditEvent appends an event to the audit trail.
+	AddAuditEvent(event AuditEvent) error
+	// ListAuditEvents returns all events since the given time.
+	ListAuditEvents(since time.Time) ([]AuditEvent, error)
+
+	// Increment increases the counter with the given key and returns its new
+	// value. Counters are reset after window has passed since their first
+	// increment.
+	Increment(key string, window time.Duration) (int, error)
+
+	// MarkSeen records value for key for the duration of window, unless a
+	// value has already been recorded and not yet expired. The previously
+	// recorded value is returned, or an empty string if there was none.
+	MarkSeen(key, value string, window time.Duration) (string, error)
+
+	// SetHostKeys replaces the host keys reported by a host.
+	SetHostKeys(keys HostKeys) error
+	// GetHostKeys returns the host keys reported by the given host.
+	GetHostKeys(host string) (HostKeys, error)
+
+	// AddDecision records the decision trace of a request.
+	AddDecision(decision Decision) error
+	// GetDecision returns the decision trace of the given request.
+	GetDecision(requestID string) (Decision, error)
+
+	// SetIdempotentResponse records a response until it expires.
+	SetIdempotentResponse(response IdempotentResponse) error
+	// GetIdempotentResponse returns the unexpired response recorded for the
+	// given key.
+	GetIdempotentResponse(key string) (IdempotentResponse, error)
+
+	// SetEnrollment adds or replaces a host enrollment.
+	SetEnrollment(enrollment Enrollment) error
+	// GetEnrollment returns the host enrollment with the given ID.
+	GetEnrollment(id string) (Enrollment, error)
+	// ListEnrollments returns all host enrollments in the given state, or
+	// in any state if it is empty, ordered by time of request.
+	ListEnrollments(state string) ([]Enrollment, error)
+
+	// SetFreeze adds or replaces the freeze of a hostgroup.
+	SetFreeze(freeze Freeze) error
+	// GetFreeze returns the unexpired freeze of the given hostgroup.
+	GetFreeze(hostGroup string) (Freeze, error)
+	// DeleteFreeze removes the freeze of the given hostgroup.
+	DeleteFreeze(hostGroup string) error
+
+	// SetConfigSnapshot replaces the snapshot of the configuration, which is
+	// kept regardless of the audit retention.
+	SetConfigSnapshot(snapshot map[string]string) error
+	// GetConfigSnapshot returns the snapshot of the configuration.
+	GetConfigSnapshot() (map[string]string, error)
+
+	Close() error
+}
+
+// Option configures a store.
+type Option func(*MemoryStore)
+
+// WithAuditRetention sets the duration after which audit events are removed.
+// They are kept forever if it is negative.
+func WithAuditRetention(retention time.Duration) Option {
+	return func(m *MemoryStore) {
+		m.auditRetention = retention
+	}
+}
+
+// WithRandomSerials makes NextSerial return random serial numbers with the
+// most significant bit set, which never collide with the sequential serial
+// numbers of other stores. It is used by short-lived stores sharing the CA
+// keys with a regular CA, whose sequential serial numbers would restart at 1.
+func WithRandomSerials() Option {
+	return func(m *MemoryStore) {
+		m.randomSerials = true
+	}
+}
+
+// WithLogger sets the logger of background errors, such as failed writes of
+// batched modifications. Defaults to the standard logger.
+func WithLogger(logger *log.Logger) Option {
+	return func(m *MemoryStore) {
+		m.logger = logger
+	}
+}
+
+// Open returns the store for the given backend string.
+func Open(backend string, opts ...Option) (Store, error) {
+	kind, arg, _ := strings.Cut(backend, ":")
+
+	switch kind {
+	case "", BACKEND_MEMORY:
+		return NewMemoryStore(opts...), nil
+	case BACKEND_FILE:
+		return NewFileStore(arg, opts...)
+	default:
+		return nil, fmt.Errorf("%s: %s", ERR_UNKNOWN_BACKEND, kind)
+	}
+}
+
+// Path returns the file path used by the given backend string, or an empty
+// string if the backend doesn't use a file.
+func Path(backend string) string {
+	if kind, arg, _ := strings.Cut(backend, ":"); kind == BACKEND_FILE {
+		return arg
+	}
+
+	return ""
+}
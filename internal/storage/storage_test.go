@@ -0,0 +1,268 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	store, err := Open("file:" + path)
+	assert.NoError(t, err)
+
+	serial, err := store.NextSerial()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), serial)
+
+	now := time.Now()
+	assert.NoError(t, store.AddCertificate(Certificate{
+		Serial:      serial,
+		Subject:     "alice@https://op.example.com",
+		ValidAfter:  now.Add(-time.Minute),
+		ValidBefore: now.Add(time.Hour),
+	}))
+	assert.NoError(t, store.Revoke(Revocation{Serial: serial, RevokedAt: now, ValidBefore: now.Add(time.Hour)}))
+	assert.ErrorIs(t, store.Revoke(Revocation{Serial: serial}), ErrAlreadyRevoked)
+	assert.NoError(t, store.SetHostKeys(HostKeys{Host: "login.example.com", Keys: []string{"ssh-ed25519 AAAA"}}))
+	assert.NoError(t, store.AddDecision(Decision{RequestID: "abc", Time: now, Outcome: "denied"}))
+	assert.NoError(t, store.AddDecision(Decision{RequestID: "old", Time: now.Add(-DECISION_RETENTION - time.Hour)}))
+
+	// Reopen and check that state was persisted.
+	assert.NoError(t, store.Close())
+	store, err = Open("file:" + path)
+	assert.NoError(t, err)
+
+	// Serial numbers continue after the reserved block
+	serial, err = store.NextSerial()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(SERIAL_RESERVATION+1), serial)
+
+	certs, err := store.ListCertificates(CertificateFilter{Subject: "alice@https://op.example.com", ValidAt: now})
+	assert.NoError(t, err)
+	assert.Len(t, certs, 1)
+
+	certs, err = store.ListCertificates(CertificateFilter{ValidAt: now.Add(2 * time.Hour)})
+	assert.NoError(t, err)
+	assert.Len(t, certs, 0)
+
+	revs, err := store.ListRevocations()
+	assert.NoError(t, err)
+	assert.Len(t, revs, 1)
+
+	keys, err := store.GetHostKeys("login.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ssh-ed25519 AAAA"}, keys.Keys)
+
+	_, err = store.GetHostKeys("other.example.com")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	decision, err := store.GetDecision("abc")
+	assert.NoError(t, err)
+	assert.Equal(t, "denied", decision.Outcome)
+
+	_, err = store.GetDecision("old")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestFileStoreBatching(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	store, err := NewFileStore(path)
+	assert.NoError(t, err)
+	defer store.Close()
+
+	// Reserving the first block of serial numbers is written right away
+	serial, err := store.NextSerial()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), serial)
+
+	written, err := os.ReadFile(path)
+	assert.NoError(t, err)
+
+	// Further serial numbers of the block, counters and seen tokens are not
+	// written for every modification
+	for i := 2; i <= 10; i++ {
+		serial, _ := store.NextSerial()
+		assert.Equal(t, uint64(i), serial)
+
+		store.Increment("key", time.Hour)
+		store.MarkSeen(fmt.Sprintf("jti %d", i), "fingerprint", time.Hour)
+	}
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, written, content)
+
+	// They are written by Flush, which runs periodically, and Close
+	assert.NoError(t, store.Flush())
+
+	reopened, err := NewFileStore(path)
+	assert.NoError(t, err)
+	defer reopened.Close()
+
+	previous, err := reopened.MarkSeen("jti 2", "other", time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, "fingerprint", previous)
+
+	value, err := reopened.Increment("key", time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, value)
+
+	serial, err = reopened.NextSerial()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(SERIAL_RESERVATION+1), serial)
+}
+
+func TestNextSerialReservation(t *testing.T) {
+	store := NewMemoryStore()
+
+	for i := uint64(1); i <= SERIAL_RESERVATION+1; i++ {
+		serial, err := store.NextSerial()
+		assert.NoError(t, err)
+		assert.Equal(t, i, serial)
+	}
+
+	assert.Equal(t, uint64(2*SERIAL_RESERVATION), store.state.Serial)
+}
+
+func TestRandomSerials(t *testing.T) {
+	store := NewMemoryStore(WithRandomSerials())
+
+	first, err := store.NextSerial()
+	assert.NoError(t, err)
+	second, err := store.NextSerial()
+	assert.NoError(t, err)
+
+	assert.NotEqual(t, first, second)
+	assert.NotZero(t, first&RANDOM_SERIAL_BIT)
+	assert.NotZero(t, second&RANDOM_SERIAL_BIT)
+	assert.Zero(t, store.state.Serial, "nothing is reserved")
+}
+
+func TestAuditRetention(t *testing.T) {
+	now := time.Now()
+
+	old := AuditEvent{Time: now.Add(-AUDIT_RETENTION - time.Hour), Action: "old"}
+	recent := AuditEvent{Time: now.Add(-RETENTION - time.Hour), Action: "recent"}
+
+	store := NewMemoryStore()
+	assert.NoError(t, store.AddAuditEvent(old))
+	assert.NoError(t, store.AddAuditEvent(recent))
+
+	events, _ := store.ListAuditEvents(time.Time{})
+	assert.Equal(t, []AuditEvent{recent}, events)
+
+	store = NewMemoryStore(WithAuditRetention(24 * time.Hour))
+	assert.NoError(t, store.AddAuditEvent(recent))
+
+	events, _ = store.ListAuditEvents(time.Time{})
+	assert.Empty(t, events)
+
+	store = NewMemoryStore(WithAuditRetention(-1))
+	assert.NoError(t, store.AddAuditEvent(old))
+	assert.NoError(t, store.AddAuditEvent(recent))
+
+	events, _ = store.ListAuditEvents(time.Time{})
+	assert.Equal(t, []AuditEvent{old, recent}, events)
+}
+
+func TestIncrement(t *testing.T) {
+	store := NewMemoryStore()
+
+	for i := 1; i <= 3; i++ {
+		value, err := store.Increment("key", time.Hour)
+		assert.NoError(t, err)
+		assert.Equal(t, i, value)
+	}
+
+	value, err := store.Increment("expired", -time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, value)
+
+	value, err = store.Increment("expired", -time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, value)
+}
+
+func TestMarkSeen(t *testing.T) {
+	store := NewMemoryStore()
+
+	previous, err := store.MarkSeen("jti", "first", time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, "", previous)
+
+	previous, err = store.MarkSeen("jti", "second", time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, "first", previous)
+
+	store.MarkSeen("expired", "first", -time.Second)
+	previous, _ = store.MarkSeen("expired", "second", time.Hour)
+	assert.Equal(t, "", previous)
+}
+
+func TestIdempotentResponse(t *testing.T) {
+	store := NewMemoryStore()
+
+	assert.NoError(t, store.SetIdempotentResponse(IdempotentResponse{Key: "key", Serial: 1, Expires: time.Now().Add(time.Hour)}))
+	assert.NoError(t, store.SetIdempotentResponse(IdempotentResponse{Key: "expired", Serial: 2, Expires: time.Now().Add(-time.Second)}))
+
+	response, err := store.GetIdempotentResponse("key")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), response.Serial)
+
+	_, err = store.GetIdempotentResponse("expired")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestEnrollments(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Now()
+
+	assert.NoError(t, store.SetEnrollment(Enrollment{ID: "b", State: ENROLLMENT_PENDING, RequestedAt: now}))
+	assert.NoError(t, store.SetEnrollment(Enrollment{ID: "a", State: ENROLLMENT_PENDING, RequestedAt: now.Add(-time.Minute)}))
+	assert.NoError(t, store.SetEnrollment(Enrollment{ID: "c", State: ENROLLMENT_REJECTED, RequestedAt: now}))
+	assert.NoError(t, store.SetEnrollment(Enrollment{ID: "old", State: ENROLLMENT_PENDING, RequestedAt: now.Add(-RETENTION - time.Hour)}))
+
+	pending, err := store.ListEnrollments(ENROLLMENT_PENDING)
+	assert.NoError(t, err)
+	assert.Len(t, pending, 2)
+	assert.Equal(t, "a", pending[0].ID)
+
+	all, err := store.ListEnrollments("")
+	assert.NoError(t, err)
+	assert.Len(t, all, 3)
+
+	_, err = store.GetEnrollment("old")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestFreezes(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Now()
+
+	assert.NoError(t, store.SetFreeze(Freeze{HostGroup: "a", Frozen: true, Reason: "incident"}))
+	assert.NoError(t, store.SetFreeze(Freeze{HostGroup: "b", Frozen: true, Until: now.Add(-time.Second)}))
+
+	freeze, err := store.GetFreeze("a")
+	assert.NoError(t, err)
+	assert.Equal(t, "incident", freeze.Reason)
+
+	_, err = store.GetFreeze("b")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, store.DeleteFreeze("b"), ErrNotFound)
+
+	assert.NoError(t, store.DeleteFreeze("a"))
+	_, err = store.GetFreeze("a")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestOpenUnknown(t *testing.T) {
+	_, err := Open("redis://localhost")
+	assert.EqualError(t, err, ERR_UNKNOWN_BACKEND+": redis")
+}
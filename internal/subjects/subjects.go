@@ -0,0 +1,112 @@
+// Package subjects implements lists of token subjects stored in files, which
+// operators edit to allow or block accounts without restarting the CA, e.g.
+// to lock out a compromised account before the IdP or motley_cue suspend it.
+//
+// Lists contain one entry per line, either a subject, which matches it at
+// any issuer, or "<subject>@<issuer>", as shown in audit events. Empty lines
+// and lines starting with "#" are ignored.
+package subjects
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ERR_TOO_LARGE = "subject list is too large"
+
+	// Lists larger than this are rejected
+	MAX_SIZE = 16 << 20
+)
+
+var ErrTooLarge = errors.New(ERR_TOO_LARGE)
+
+// List is a list of subjects, which is reloaded when its file changes.
+type List struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	entries map[string]bool
+}
+
+// Open reads the list of subjects from the file.
+func Open(path string) (*List, error) {
+	l := &List{path: path}
+
+	if err := l.reload(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Path returns the path of the file of the list.
+func (l *List) Path() string {
+	return l.path
+}
+
+// reload reads the file again if its modification time or size changed
+// since it was last read. l.mu must be held, unless l is not shared yet.
+func (l *List) reload() error {
+	info, err := os.Stat(l.path)
+	if err != nil {
+		return err
+	}
+
+	if l.entries != nil && info.ModTime().Equal(l.modTime) && info.Size() == l.size {
+		return nil
+	}
+
+	if info.Size() > MAX_SIZE {
+		return fmt.Errorf("%w: %s", ErrTooLarge, l.path)
+	}
+
+	f, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	entries := make(map[string]bool)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		entries[line] = true
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%s: %w", l.path, err)
+	}
+
+	l.modTime = info.ModTime()
+	l.size = info.Size()
+	l.entries = entries
+
+	return nil
+}
+
+// Contains reports whether the list contains the subject of the issuer. The
+// file is reloaded if it changed. If it can't be read, an error is returned
+// rather than the previous entries, so that callers can fail closed.
+func (l *List) Contains(subject, issuer string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.reload(); err != nil {
+		return false, err
+	}
+
+	return l.entries[subject] || (issuer != "" && l.entries[subject+"@"+issuer]), nil
+}
@@ -0,0 +1,54 @@
+package subjects
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "subjects")
+
+	_, err := Open(path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	assert.NoError(t, os.WriteFile(path, []byte("# blocked\nalice\n\n  bob@https://idp.example.com  \n"), 0600))
+
+	list, err := Open(path)
+	assert.NoError(t, err)
+	assert.Equal(t, path, list.Path())
+
+	for _, test := range []struct {
+		subject, issuer string
+		contains        bool
+	}{
+		{"alice", "https://idp.example.com", true},
+		{"alice", "", true},
+		{"bob", "https://idp.example.com", true},
+		{"bob", "https://other.example.com", false},
+		{"bob", "", false},
+		{"# blocked", "", false},
+	} {
+		contains, err := list.Contains(test.subject, test.issuer)
+		assert.NoError(t, err)
+		assert.Equal(t, test.contains, contains, test.subject+"@"+test.issuer)
+	}
+
+	// Changes are picked up without reopening the list
+	assert.NoError(t, os.WriteFile(path, []byte("carol\n"), 0600))
+
+	contains, err := list.Contains("alice", "")
+	assert.NoError(t, err)
+	assert.False(t, contains)
+
+	contains, err = list.Contains("carol", "")
+	assert.NoError(t, err)
+	assert.True(t, contains)
+
+	assert.NoError(t, os.Remove(path))
+
+	_, err = list.Contains("carol", "")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
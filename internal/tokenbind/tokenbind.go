@@ -0,0 +1,106 @@
+// Package tokenbind binds issued certificates to the access token that was
+// used to request them.
+//
+// The CA embeds a hash of the access token into a certificate extension. On
+// the host, the certificate used for authentication is read from the file
+// exposed by sshd (ExposeAuthInfo yes) and compared with the token sent by the
+// client, which is then verified against motley_cue once more.
+package tokenbind
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// Certificate extension containing the token hash. Names of custom
+	// extensions must be of the form name@domain, see PROTOCOL.certkeys.
+	EXTENSION = "token-sha256@oinit"
+
+	// Environment variable set by sshd if 'ExposeAuthInfo yes' is configured.
+	ENV_AUTH_INFO = "SSH_USER_AUTH"
+	// Environment variable containing the access token that clients send
+	// to hosts binding logins to it. It differs from the variables clients
+	// read their own token from, so the token is only sent deliberately.
+	ENV_TOKEN = "OINIT_BOUND_TOKEN"
+
+	ERR_NO_AUTH_INFO   = "no authentication info exposed by sshd"
+	ERR_NO_CERTIFICATE = "no certificate was used for authentication"
+	ERR_NO_BINDING     = "certificate is not bound to a token"
+	ERR_MISMATCH       = "token does not match certificate"
+)
+
+// Hash returns the base64url encoded SHA-256 hash of the given token.
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Bind adds the token hash extension to the given certificate. The
+// certificate must be signed afterwards.
+func Bind(cert *ssh.Certificate, token string) {
+	if cert.Extensions == nil {
+		cert.Extensions = make(map[string]string)
+	}
+
+	cert.Extensions[EXTENSION] = Hash(token)
+}
+
+// Verify checks that the given certificate is bound to the given token.
+func Verify(cert *ssh.Certificate, token string) error {
+	hash, ok := cert.Extensions[EXTENSION]
+	if !ok {
+		return errors.New(ERR_NO_BINDING)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(Hash(token))) != 1 {
+		return errors.New(ERR_MISMATCH)
+	}
+
+	return nil
+}
+
+// AuthCertificate returns the certificate that was used for public key
+// authentication of the current session, read from the file that sshd
+// exposes in $SSH_USER_AUTH. Each line of this file has the form
+//
+//	publickey <type> <base64 blob>
+func AuthCertificate() (*ssh.Certificate, error) {
+	path := os.Getenv(ENV_AUTH_INFO)
+	if path == "" {
+		return nil, errors.New(ERR_NO_AUTH_INFO)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		method, key, found := strings.Cut(scanner.Text(), " ")
+		if !found || method != "publickey" {
+			continue
+		}
+
+		pk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
+		if err != nil {
+			continue
+		}
+
+		if cert, ok := pk.(*ssh.Certificate); ok {
+			return cert, nil
+		}
+	}
+
+	return nil, errors.New(ERR_NO_CERTIFICATE)
+}
@@ -0,0 +1,93 @@
+package tokenbind
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func testCertificate(t *testing.T) *ssh.Certificate {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	key, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+
+	return &ssh.Certificate{Key: key, CertType: ssh.UserCert, KeyId: "oinit@host.example.com"}
+}
+
+func TestHash(t *testing.T) {
+	// echo -n token | sha256sum, base64url encoded without padding
+	assert.Equal(t, "PEaenWxYddN6Q_NT1PiOYfz4EsZu7jRXRlpAsNpBU-A", Hash("token"))
+	assert.NotEqual(t, Hash("token"), Hash("token2"))
+	assert.Len(t, Hash(""), 43)
+}
+
+func TestBindVerify(t *testing.T) {
+	cert := testCertificate(t)
+
+	Bind(cert, "token")
+	assert.Equal(t, Hash("token"), cert.Extensions[EXTENSION])
+	assert.NoError(t, Verify(cert, "token"))
+
+	// Other extensions are kept
+	cert = testCertificate(t)
+	cert.Extensions = map[string]string{"permit-pty": ""}
+	Bind(cert, "token")
+	assert.Contains(t, cert.Extensions, "permit-pty")
+	assert.NoError(t, Verify(cert, "token"))
+}
+
+func TestVerifyMismatch(t *testing.T) {
+	cert := testCertificate(t)
+	Bind(cert, "token")
+
+	assert.EqualError(t, Verify(cert, "other"), ERR_MISMATCH)
+	assert.EqualError(t, Verify(cert, ""), ERR_MISMATCH)
+}
+
+func TestVerifyUnbound(t *testing.T) {
+	cert := testCertificate(t)
+	assert.EqualError(t, Verify(cert, "token"), ERR_NO_BINDING)
+
+	cert.Extensions = map[string]string{"permit-pty": ""}
+	assert.EqualError(t, Verify(cert, "token"), ERR_NO_BINDING)
+}
+
+func TestAuthCertificate(t *testing.T) {
+	_, caKey, _ := ed25519.GenerateKey(rand.Reader)
+	ca, err := ssh.NewSignerFromKey(caKey)
+	require.NoError(t, err)
+
+	cert := testCertificate(t)
+	Bind(cert, "token")
+	require.NoError(t, cert.SignCert(rand.Reader, ca))
+
+	file := filepath.Join(t.TempDir(), "auth")
+
+	t.Setenv(ENV_AUTH_INFO, "")
+	_, err = AuthCertificate()
+	assert.EqualError(t, err, ERR_NO_AUTH_INFO)
+
+	t.Setenv(ENV_AUTH_INFO, file)
+
+	// Plain keys are skipped
+	require.NoError(t, os.WriteFile(file, []byte("publickey "+string(ssh.MarshalAuthorizedKey(cert.Key))), 0600))
+	_, err = AuthCertificate()
+	assert.EqualError(t, err, ERR_NO_CERTIFICATE)
+
+	content := "keyboard-interactive\npublickey " + string(ssh.MarshalAuthorizedKey(cert))
+	require.NoError(t, os.WriteFile(file, []byte(content), 0600))
+
+	parsed, err := AuthCertificate()
+	require.NoError(t, err)
+	assert.Equal(t, cert.Marshal(), parsed.Marshal())
+	assert.NoError(t, Verify(parsed, "token"))
+	assert.EqualError(t, Verify(parsed, "other"), ERR_MISMATCH)
+}
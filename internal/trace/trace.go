@@ -0,0 +1,171 @@
+// Package trace implements the verbose diagnostic mode of the client. Each
+// step of the flow is logged with the time elapsed since program start, and
+// can additionally be collected into a report that users attach to support
+// requests. Secrets such as access tokens must only be logged using Redact,
+// and JWTs are removed from all messages as a safeguard.
+package trace
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lbrocke/oinit/pkg/log"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// Verbosity levels, selected by -v, -vv and -vvv
+	LEVEL_STEPS   = 1
+	LEVEL_TIMINGS = 2
+	LEVEL_DETAILS = 3
+
+	REDACTED = "[redacted]"
+)
+
+var (
+	mu        sync.Mutex
+	level     int
+	recording bool
+	entries   []string
+	start     = time.Now()
+
+	jwtPattern = regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+)
+
+// SetLevel sets the verbosity. Messages above this level are not printed.
+func SetLevel(l int) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	level = l
+}
+
+// Record enables collecting all messages, regardless of the verbosity, so
+// they can be written with WriteReport.
+func Record() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	recording = true
+}
+
+// Logf logs a message at the given verbosity level.
+func Logf(l int, format string, args ...interface{}) {
+	msg := jwtPattern.ReplaceAllString(fmt.Sprintf(format, args...), REDACTED)
+	line := fmt.Sprintf("[%8.3fs] %s", time.Since(start).Seconds(), msg)
+
+	mu.Lock()
+	if recording {
+		entries = append(entries, line)
+	}
+	show := l <= level
+	mu.Unlock()
+
+	if show {
+		log.LogDebugTTY(line)
+	}
+}
+
+// Step logs the start of a step and returns a function that logs its
+// duration when called, e.g.
+//
+//	defer trace.Step("CA request")()
+func Step(name string) func() {
+	begin := time.Now()
+
+	Logf(LEVEL_STEPS, "%s", name)
+
+	return func() {
+		Logf(LEVEL_TIMINGS, "%s took %s", name, time.Since(begin).Round(time.Millisecond))
+	}
+}
+
+// Redact returns a representation of a secret that allows to tell secrets
+// apart without revealing them.
+func Redact(secret string) string {
+	if len(secret) <= 12 {
+		return REDACTED
+	}
+
+	return fmt.Sprintf("%s…%s (%d chars)", secret[:4], REDACTED, len(secret))
+}
+
+// TokenInfo returns the non-sensitive claims of a JWT access token without
+// verifying it, or a note that the token is opaque.
+func TokenInfo(token string) string {
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		return "opaque token " + Redact(token)
+	}
+
+	info := []string{"token " + Redact(token)}
+
+	if iss, err := parsed.Claims.GetIssuer(); err == nil && iss != "" {
+		info = append(info, "iss="+iss)
+	}
+
+	if exp, err := parsed.Claims.GetExpirationTime(); err == nil && exp != nil {
+		info = append(info, "exp="+exp.Format(time.RFC3339))
+	}
+
+	if claims, ok := parsed.Claims.(jwt.MapClaims); ok {
+		if scope, ok := claims["scope"].(string); ok {
+			info = append(info, "scope="+scope)
+		}
+	}
+
+	return strings.Join(info, " ")
+}
+
+// WriteReport writes a plain text report to path, containing information
+// about the system, the given sections and all recorded messages.
+func WriteReport(path string, sections map[string]string) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "oinit debug report, created %s\n\n", time.Now().Format(time.RFC3339))
+
+	b.WriteString("== system ==\n")
+	fmt.Fprintf(&b, "os/arch: %s/%s\n", runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(&b, "go: %s\n", runtime.Version())
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		fmt.Fprintf(&b, "oinit: %s\n", info.Main.Version)
+	}
+
+	fmt.Fprintf(&b, "args: %s\n", strings.Join(os.Args, " "))
+
+	names := make([]string, 0, len(sections))
+	for name := range sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		content := strings.TrimSuffix(sections[name], "\n")
+		if content == "" {
+			content = "(none)"
+		}
+
+		fmt.Fprintf(&b, "\n== %s ==\n%s\n", name, content)
+	}
+
+	b.WriteString("\n== trace ==\n")
+
+	mu.Lock()
+	for _, entry := range entries {
+		b.WriteString(entry + "\n")
+	}
+	mu.Unlock()
+
+	report := jwtPattern.ReplaceAllString(b.String(), REDACTED)
+
+	return os.WriteFile(path, []byte(report), 0600)
+}
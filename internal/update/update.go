@@ -0,0 +1,220 @@
+// Package update implements the self-update of the client. A release
+// manifest lists the latest version and a binary per platform. Each binary
+// has a detached minisign signature (<binary URL>.minisig), whose trusted
+// comment must be "oinit <version> <os>/<arch>", so a validly signed binary
+// of another version or platform can't be substituted.
+package update
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lbrocke/oinit/internal/minisign"
+)
+
+const (
+	ERR_REQUEST              = "http request failed"
+	ERR_RESPONSE_BODY        = "cannot parse response body"
+	ERR_SERVER_RESPONSE_CODE = "server responded with code: %d"
+	ERR_NO_BINARY            = "release has no binary for this platform"
+	ERR_TOO_LARGE            = "download exceeds maximum size"
+	ERR_TRUSTED_COMMENT      = "signature is not for this version and platform"
+
+	SIGNATURE_SUFFIX = ".minisig"
+
+	// Maximum size of downloaded binaries
+	MAX_BINARY_SIZE = 256 << 20
+	// Maximum size of the manifest and signatures
+	MAX_METADATA_SIZE = 1 << 20
+)
+
+var httpClient = &http.Client{Timeout: 5 * time.Minute}
+
+// Release is the release manifest.
+type Release struct {
+	Version string `json:"version"`
+	// Download URLs of binaries by "<os>/<arch>"
+	Binaries map[string]string `json:"binaries"`
+}
+
+// Platform returns the key of binaries for this platform.
+func Platform() string {
+	return runtime.GOOS + "/" + runtime.GOARCH
+}
+
+func download(url string, limit int64) ([]byte, error) {
+	res, err := httpClient.Get(url)
+	if err != nil {
+		return nil, errors.New(ERR_REQUEST)
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf(ERR_SERVER_RESPONSE_CODE, res.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, limit+1))
+	if err != nil {
+		return nil, errors.New(ERR_REQUEST)
+	}
+
+	if int64(len(data)) > limit {
+		return nil, errors.New(ERR_TOO_LARGE)
+	}
+
+	return data, nil
+}
+
+// Check returns the release manifest at the given URL.
+func Check(url string) (Release, error) {
+	var release Release
+
+	data, err := download(url, MAX_METADATA_SIZE)
+	if err != nil {
+		return release, err
+	}
+
+	if json.Unmarshal(data, &release) != nil || release.Version == "" {
+		return release, errors.New(ERR_RESPONSE_BODY)
+	}
+
+	return release, nil
+}
+
+// parseVersion parses a version of the form [v]MAJOR.MINOR.PATCH, ignoring
+// any pre-release or build suffix.
+func parseVersion(version string) ([3]int, bool) {
+	var parts [3]int
+
+	version = strings.TrimPrefix(version, "v")
+	if i := strings.IndexAny(version, "-+"); i >= 0 {
+		version = version[:i]
+	}
+
+	fields := strings.Split(version, ".")
+	if len(fields) != 3 {
+		return parts, false
+	}
+
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 {
+			return parts, false
+		}
+
+		parts[i] = n
+	}
+
+	return parts, true
+}
+
+// IsNewer reports whether version is newer than current. Unparsable current
+// versions, such as development builds, are always considered older.
+func IsNewer(version, current string) bool {
+	v, ok := parseVersion(version)
+	if !ok {
+		return false
+	}
+
+	c, ok := parseVersion(current)
+	if !ok {
+		return true
+	}
+
+	for i := range v {
+		if v[i] != c[i] {
+			return v[i] > c[i]
+		}
+	}
+
+	return false
+}
+
+// TrustedComment returns the trusted comment that signatures of the binary
+// for the given version and this platform must have.
+func TrustedComment(version string) string {
+	return "oinit " + version + " " + Platform()
+}
+
+// Download downloads the binary of the release for this platform and
+// verifies its signature.
+func Download(release Release, pk minisign.PublicKey) ([]byte, error) {
+	url, ok := release.Binaries[Platform()]
+	if !ok {
+		return nil, errors.New(ERR_NO_BINARY)
+	}
+
+	binary, err := download(url, MAX_BINARY_SIZE)
+	if err != nil {
+		return nil, err
+	}
+
+	sigData, err := download(url+SIGNATURE_SUFFIX, MAX_METADATA_SIZE)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := minisign.ParseSignature(sigData)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := minisign.Verify(pk, binary, sig); err != nil {
+		return nil, err
+	}
+
+	if sig.TrustedComment != TrustedComment(release.Version) {
+		return nil, errors.New(ERR_TRUSTED_COMMENT)
+	}
+
+	return binary, nil
+}
+
+// Replace atomically replaces the executable at path with binary. On
+// Windows, the running executable can't be overwritten, but it can be
+// renamed, so it is moved aside to <path>.old first.
+func Replace(path string, binary []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(binary); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	if runtime.GOOS == "windows" {
+		os.Remove(path + ".old")
+
+		if err := os.Rename(path, path+".old"); err != nil {
+			return err
+		}
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,96 @@
+package update
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lbrocke/oinit/internal/minisign"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsNewer(t *testing.T) {
+	assert.True(t, IsNewer("v1.2.0", "v1.1.9"))
+	assert.True(t, IsNewer("v2.0.0", "1.10.3"))
+	assert.True(t, IsNewer("v1.0.0", "(devel)"))
+	assert.False(t, IsNewer("v1.1.0", "v1.1.0"))
+	assert.False(t, IsNewer("v1.0.9", "v1.1.0-rc1"))
+	assert.False(t, IsNewer("latest", "v1.0.0"))
+}
+
+func TestDownloadAndReplace(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+
+	keyId := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	pk, err := minisign.ParsePublicKey(base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), keyId...), pub...)))
+	assert.NoError(t, err)
+
+	binary := []byte("new oinit binary")
+
+	signature := func(comment string) []byte {
+		sig := ed25519.Sign(priv, binary)
+		global := ed25519.Sign(priv, append(append([]byte{}, sig...), comment...))
+
+		return []byte("untrusted comment: test\n" +
+			base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), keyId...), sig...)) + "\n" +
+			minisign.TRUSTED_COMMENT_PREFIX + comment + "\n" +
+			base64.StdEncoding.EncodeToString(global) + "\n")
+	}
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/release.json":
+			json.NewEncoder(w).Encode(Release{
+				Version:  "v1.2.0",
+				Binaries: map[string]string{Platform(): server.URL + "/oinit"},
+			})
+		case "/oinit":
+			w.Write(binary)
+		case "/oinit.minisig":
+			w.Write(signature(TrustedComment("v1.2.0")))
+		case "/old":
+			w.Write(binary)
+		case "/old.minisig":
+			w.Write(signature(TrustedComment("v1.1.0")))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	release, err := Check(server.URL + "/release.json")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1.2.0", release.Version)
+
+	data, err := Download(release, pk)
+	assert.NoError(t, err)
+	assert.Equal(t, binary, data)
+
+	// A binary signed for another version is rejected.
+	release.Binaries[Platform()] = server.URL + "/old"
+	_, err = Download(release, pk)
+	assert.EqualError(t, err, ERR_TRUSTED_COMMENT)
+
+	_, err = Download(Release{Version: "v1.2.0"}, pk)
+	assert.EqualError(t, err, ERR_NO_BINARY)
+
+	path := filepath.Join(t.TempDir(), "oinit")
+	assert.NoError(t, os.WriteFile(path, []byte("old oinit binary"), 0755))
+	assert.NoError(t, Replace(path, data))
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, binary, content)
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+}
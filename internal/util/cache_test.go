@@ -83,3 +83,34 @@ func TestTimedCache_SetWithNegativeDuration(t *testing.T) {
 		}
 	})
 }
+
+func TestTimedCache_Prune(t *testing.T) {
+	cache := NewTimedCache[string, int]()
+	cache.Set("expired", 1, time.Duration(-1))
+	cache.Set("valid", 2, time.Duration(60))
+
+	cache.Prune()
+
+	if len(cache.entries) != 1 {
+		t.Errorf("Expected 1 entry after pruning, but got %d", len(cache.entries))
+	}
+	if value, exists := cache.Get("valid"); !exists || value != 2 {
+		t.Errorf("Expected valid entry to be kept")
+	}
+}
+
+func TestTimedCache_Len(t *testing.T) {
+	cache := NewTimedCache[string, int]()
+	cache.Set("expired", 1, time.Duration(-1))
+	cache.Set("valid", 2, time.Duration(60))
+
+	if cache.Len() != 2 {
+		t.Errorf("Expected 2 entries before pruning, but got %d", cache.Len())
+	}
+
+	cache.Prune()
+
+	if cache.Len() != 1 {
+		t.Errorf("Expected 1 entry after pruning, but got %d", cache.Len())
+	}
+}
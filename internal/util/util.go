@@ -22,9 +22,10 @@ import (
 //	// result will be false
 func MatchesHost(host string, port string, host2 string, port2 string) bool {
 	if strings.HasPrefix(host2, "*.") {
-		root, _ := strings.CutPrefix(host2, "*.")
+		// Keep the dot, so *.example.com doesn't match evilexample.com
+		suffix, _ := strings.CutPrefix(host2, "*")
 
-		return strings.HasSuffix(host, root) && host != root && port == port2
+		return strings.HasSuffix(host, suffix) && host != suffix && port == port2
 	} else {
 		return host == host2 && port == port2
 	}
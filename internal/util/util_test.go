@@ -2,6 +2,7 @@ package util
 
 import (
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -73,6 +74,15 @@ func TestMatchesHost(t *testing.T) {
 			},
 			matches: false,
 		},
+		{
+			args: args{
+				host:  "evilexample.com",
+				port:  "22",
+				host2: "*.example.com",
+				port2: "22",
+			},
+			matches: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -84,6 +94,29 @@ func TestMatchesHost(t *testing.T) {
 	}
 }
 
+func FuzzMatchesHost(f *testing.F) {
+	f.Add("sub.example.com", "*.example.com")
+	f.Add("evilexample.com", "*.example.com")
+	f.Add("example.com", "example.com")
+	f.Add(".", "*.")
+
+	f.Fuzz(func(t *testing.T, host, pattern string) {
+		if !MatchesHost(host, "22", pattern, "22") {
+			return
+		}
+
+		if root, ok := strings.CutPrefix(pattern, "*."); ok {
+			// Only proper subdomains match wildcards
+			assert.True(t, strings.HasSuffix(host, "."+root))
+			assert.Greater(t, len(host), len(root)+1)
+		} else {
+			assert.Equal(t, pattern, host)
+		}
+
+		assert.False(t, MatchesHost(host, "22", pattern, "2222"))
+	})
+}
+
 func TestGetenvs(t *testing.T) {
 	keys := []string{"TEST_1", "TEST_2"}
 
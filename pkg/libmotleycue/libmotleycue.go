@@ -3,12 +3,18 @@
 package libmotleycue
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -17,8 +23,20 @@ const (
 	ERR_UNEXPECTED_ERROR     = "server returned error code but no description"
 	ERR_SERVER_RESPONSE      = "server responded: "
 	ERR_SERVER_RESPONSE_CODE = "server responded with code: %d"
+
+	DEFAULT_USER_AGENT = "libmotleycue-go"
+
+	// Headers of signed requests, see WithSigningKey
+	HEADER_TIMESTAMP = "X-Oinit-Timestamp"
+	HEADER_SIGNATURE = "X-Oinit-Signature"
+
+	SIGNATURE_PREFIX = "sha256="
 )
 
+// ErrRequest is returned, wrapping the cause, if motley_cue could not be
+// reached.
+var ErrRequest = errors.New(ERR_REQUEST)
+
 type ApiResponseDetail struct {
 	Detail string `json:"detail"`
 }
@@ -39,6 +57,9 @@ type Credentials struct {
 	LoginHelp   string `json:"login_help"`
 	SSHHost     string `json:"ssh_host"`
 	SSHUser     string `json:"ssh_user"`
+	// All accounts the user may log in as, including SSHUser, if motley_cue
+	// maps the user to further local or shared service accounts
+	SSHUsers []string `json:"ssh_users,omitempty"`
 }
 
 type ApiResponseInfo struct {
@@ -47,6 +68,13 @@ type ApiResponseInfo struct {
 	OpsInfo      map[string]OpInfo `json:"ops_info"`
 }
 
+// Only the fields needed to determine the version of motley_cue
+type ApiResponseOpenAPI struct {
+	Info struct {
+		Version string `json:"version"`
+	} `json:"info"`
+}
+
 type UserStatusState string
 
 const (
@@ -68,7 +96,57 @@ type ApiResponseUserStatus struct {
 }
 
 type Client struct {
-	addr string
+	addr       string
+	userAgent  string
+	signingKey []byte
+}
+
+// Option configures a Client, see NewClient.
+type Option func(*Client)
+
+// WithUserAgent sets the User-Agent header, such as "oinit-ca/1.2.0", so
+// operators of motley_cue can identify the client.
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
+// WithSigningKey signs requests with a key shared with motley_cue, so it can
+// restrict calls to trusted clients. Requests carry the current unix time in
+// the X-Oinit-Timestamp header and "sha256=" followed by the hex-encoded
+// HMAC-SHA256 of the message built by SignatureMessage in the
+// X-Oinit-Signature header. A nil key disables signing.
+func WithSigningKey(key []byte) Option {
+	return func(c *Client) {
+		c.signingKey = key
+	}
+}
+
+// StatusError is returned if motley_cue responded with an unexpected status
+// code.
+type StatusError struct {
+	StatusCode int
+}
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf(ERR_SERVER_RESPONSE_CODE, e.StatusCode)
+}
+
+// Unavailable reports whether err means that motley_cue could not be reached
+// or failed internally, so that another instance may succeed. Errors about
+// the user or token, such as an expired token, are not affected.
+func Unavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var statusErr StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError
+	}
+
+	return errors.Is(err, ErrRequest)
 }
 
 // parseError tries to unmarshal the given response body into
@@ -103,12 +181,74 @@ func parseResponse(responseBody io.ReadCloser, into interface{}) error {
 
 // NewClient creates a new API client. addr is the server address (and port)
 // including the protocol, such as http://example.com:8080
-func NewClient(addr string) Client {
+func NewClient(addr string, opts ...Option) Client {
 	addr, _ = strings.CutSuffix(addr, "/")
 
-	return Client{
-		addr: addr,
+	c := Client{
+		addr:      addr,
+		userAgent: DEFAULT_USER_AGENT,
+	}
+
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	return c
+}
+
+// SignatureMessage returns the message that is signed for a request: the
+// method, the request URI (path and query), the timestamp and the
+// hex-encoded SHA-256 hash of the Authorization header, separated by
+// newlines. Binding the Authorization header prevents signed requests from
+// being reused with other tokens.
+func SignatureMessage(method, requestURI, timestamp, authorization string) []byte {
+	hash := sha256.Sum256([]byte(authorization))
+
+	return []byte(method + "\n" + requestURI + "\n" + timestamp + "\n" + hex.EncodeToString(hash[:]))
+}
+
+// Signature returns the value of the X-Oinit-Signature header for the
+// message, see SignatureMessage.
+func Signature(key, message []byte) string {
+	h := hmac.New(sha256.New, key)
+	h.Write(message)
+
+	return SIGNATURE_PREFIX + hex.EncodeToString(h.Sum(nil))
+}
+
+// newRequest creates a request to path with the headers common to all
+// requests, and signs it if a signing key is set.
+func (c Client) newRequest(ctx context.Context, path, token string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.addr+path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", c.userAgent)
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	if c.signingKey != nil {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		message := SignatureMessage(req.Method, req.URL.RequestURI(), timestamp, req.Header.Get("Authorization"))
+
+		req.Header.Set(HEADER_TIMESTAMP, timestamp)
+		req.Header.Set(HEADER_SIGNATURE, Signature(c.signingKey, message))
 	}
+
+	return req, nil
+}
+
+// requestError returns the error of the context if it is done, which caused
+// the request to fail, and err wrapped in ErrRequest otherwise.
+func requestError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return fmt.Errorf("%w: %w", ErrRequest, err)
 }
 
 // GetInfo calls GET /info.
@@ -119,11 +259,22 @@ func NewClient(addr string) Client {
 //   - supported OPs
 //   - OP info
 func (c Client) GetInfo() (ApiResponseInfo, error) {
+	return c.GetInfoContext(context.Background())
+}
+
+// GetInfoContext is like GetInfo, but the request is aborted when ctx is
+// done, in which case the error of ctx is returned.
+func (c Client) GetInfoContext(ctx context.Context) (ApiResponseInfo, error) {
 	var response ApiResponseInfo
 
-	res, err := http.Get(c.addr + "/info")
+	req, err := c.newRequest(ctx, "/info", "")
+	if err != nil {
+		return response, fmt.Errorf("%w: %w", ErrRequest, err)
+	}
+
+	res, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return response, errors.New(ERR_REQUEST)
+		return response, requestError(ctx, err)
 	}
 
 	defer res.Body.Close()
@@ -132,26 +283,59 @@ func (c Client) GetInfo() (ApiResponseInfo, error) {
 	case http.StatusOK:
 		return response, parseResponse(res.Body, &response)
 	default:
-		return response, fmt.Errorf(ERR_SERVER_RESPONSE_CODE, res.StatusCode)
+		return response, StatusError{res.StatusCode}
 	}
 }
 
+// GetVersion returns the version of motley_cue, which is part of the OpenAPI
+// document at GET /openapi.json. motley_cue does not serve the document if
+// its API docs are disabled, in which case a StatusError is returned.
+func (c Client) GetVersion() (string, error) {
+	return c.GetVersionContext(context.Background())
+}
+
+// GetVersionContext is like GetVersion, but the request is aborted when ctx
+// is done, in which case the error of ctx is returned.
+func (c Client) GetVersionContext(ctx context.Context) (string, error) {
+	var response ApiResponseOpenAPI
+
+	req, err := c.newRequest(ctx, "/openapi.json", "")
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrRequest, err)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", requestError(ctx, err)
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", StatusError{res.StatusCode}
+	}
+
+	if err := parseResponse(res.Body, &response); err != nil {
+		return "", err
+	}
+
+	return response.Info.Version, nil
+}
+
 // getUser is the implementation of both GET /user/get_status and GET
 // /user/deploy, as their request parameters and response are identical.
-func (c Client) getUser(path string, token string) (ApiResponseUserStatus, error) {
+func (c Client) getUser(ctx context.Context, path string, token string) (ApiResponseUserStatus, error) {
 	var response ApiResponseUserStatus
 
-	req, err := http.NewRequest(http.MethodGet, c.addr+path, nil)
+	req, err := c.newRequest(ctx, path, token)
 	if err != nil {
-		return response, errors.New(ERR_REQUEST)
+		return response, fmt.Errorf("%w: %w", ErrRequest, err)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+token)
-
 	client := http.Client{}
 	res, err := client.Do(req)
 	if err != nil {
-		return response, errors.New(ERR_REQUEST)
+		return response, requestError(ctx, err)
 	}
 
 	defer res.Body.Close()
@@ -171,7 +355,7 @@ func (c Client) getUser(path string, token string) (ApiResponseUserStatus, error
 		// custom error.
 		fallthrough
 	default:
-		return response, fmt.Errorf(ERR_SERVER_RESPONSE_CODE, res.StatusCode)
+		return response, StatusError{res.StatusCode}
 	}
 }
 
@@ -184,7 +368,13 @@ func (c Client) getUser(path string, token string) (ApiResponseUserStatus, error
 //
 // Requires an authorized user.
 func (c Client) GetUserStatus(token string) (ApiResponseUserStatus, error) {
-	return c.getUser("/user/get_status", token)
+	return c.getUser(context.Background(), "/user/get_status", token)
+}
+
+// GetUserStatusContext is like GetUserStatus, but the request is aborted when
+// ctx is done, in which case the error of ctx is returned.
+func (c Client) GetUserStatusContext(ctx context.Context, token string) (ApiResponseUserStatus, error) {
+	return c.getUser(ctx, "/user/get_status", token)
 }
 
 // GetUserDeploy calls GET /user/deploy.
@@ -192,5 +382,11 @@ func (c Client) GetUserStatus(token string) (ApiResponseUserStatus, error) {
 // Provision a local account.
 // Requires an authorized user.
 func (c Client) GetUserDeploy(token string) (ApiResponseUserStatus, error) {
-	return c.getUser("/user/deploy", token)
+	return c.getUser(context.Background(), "/user/deploy", token)
+}
+
+// GetUserDeployContext is like GetUserDeploy, but the request is aborted when
+// ctx is done, in which case the error of ctx is returned.
+func (c Client) GetUserDeployContext(ctx context.Context, token string) (ApiResponseUserStatus, error) {
+	return c.getUser(ctx, "/user/deploy", token)
 }
@@ -16,6 +16,7 @@ const (
 	SYMBOL_INFO    = "i"
 	SYMBOL_NONE    = ""
 	SYMBOL_PROMPT  = "?"
+	SYMBOL_DEBUG   = "»"
 
 	COLOR_RED    Color = "\u001b[31m"
 	COLOR_GREEN  Color = "\u001b[32m"
@@ -24,6 +25,23 @@ const (
 	COLOR_RESET  Color = "\u001b[0m"
 )
 
+// fatalHooks are run before LogFatal and LogFatalTTY exit the program.
+var fatalHooks []func()
+
+// OnFatal registers a function that is run before LogFatal and LogFatalTTY
+// exit the program, such as to flush diagnostic output.
+func OnFatal(hook func()) {
+	fatalHooks = append(fatalHooks, hook)
+}
+
+func exit() {
+	for _, hook := range fatalHooks {
+		hook()
+	}
+
+	os.Exit(1)
+}
+
 func log(msg string, color Color, symbol string, useTTY bool, lb bool) {
 	// See https://no-color.org
 	if os.Getenv("NO_COLOR") != "" {
@@ -60,12 +78,12 @@ func LogErrorTTY(msg string) {
 
 func LogFatal(msg string) {
 	LogError(msg)
-	os.Exit(1)
+	exit()
 }
 
 func LogFatalTTY(msg string) {
 	LogErrorTTY(msg)
-	os.Exit(1)
+	exit()
 }
 
 func LogSuccess(msg string) {
@@ -92,6 +110,10 @@ func LogInfoTTY(msg string) {
 	log(msg, COLOR_BLUE, SYMBOL_INFO, true, true)
 }
 
+func LogDebugTTY(msg string) {
+	log(msg, COLOR_RESET, SYMBOL_DEBUG, true, true)
+}
+
 func Log(msg string) {
 	log(msg, COLOR_RESET, SYMBOL_NONE, false, true)
 }
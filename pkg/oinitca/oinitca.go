@@ -0,0 +1,585 @@
+// Package oinitca provides a client for the REST API v1 of oinit-ca, for
+// portals and workflow systems that request certificates on behalf of users
+// without shelling out to oinit.
+//
+// Example usage:
+//
+//	client := oinitca.NewClient("https://ca.example.com", oinitca.WithRetries(3, time.Second))
+//
+//	cert, err := client.SignCertificate(ctx, "login.example.com", oinitca.CertificateRequest{
+//		PublicKey: string(ssh.MarshalAuthorizedKey(pubkey)),
+//		Token:     accessToken,
+//	})
+//
+// Portals relaying requests of users that hold the private key themselves
+// can have them sign the request (see SignedRequest), so the CA doesn't need
+// to trust the portal with the host and options:
+//
+//	signed, err := oinitca.SignedRequest{Host: "login.example.com"}.Sign(signer)
+//
+//	cert, err := client.SignCertificate(ctx, "login.example.com", oinitca.CertificateRequest{
+//		Token:         accessToken,
+//		SignedRequest: signed,
+//	})
+//
+// This package follows semantic versioning independently of the oinit
+// binaries, see VERSION. Errors returned by the CA are of type *Error.
+package oinitca
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// Version of this package, sent in the User-Agent header
+	VERSION = "1.7.0"
+
+	API_V1 = "/api/v1"
+
+	DEFAULT_RETRIES = 2
+	DEFAULT_BACKOFF = 500 * time.Millisecond
+	DEFAULT_TIMEOUT = 30 * time.Second
+
+	// Redirects followed per request, such as to the site CA a host is
+	// delegated to
+	MAX_REDIRECTS = 3
+
+	// Interval of polls for requests answered with 202 Accepted, if the CA
+	// sends no Retry-After header
+	DEFAULT_POLL_INTERVAL = time.Second
+
+	ERR_REQUEST              = "http request failed"
+	ERR_RESPONSE_BODY        = "cannot parse response body"
+	ERR_SERVER_RESPONSE_CODE = "server responded with unexpected code: %d"
+	ERR_NOT_A_CERTIFICATE    = "response does not contain a certificate"
+	ERR_INSECURE_REDIRECT    = "refusing redirect from https to http"
+	ERR_TOO_MANY_REDIRECTS   = "too many redirects"
+
+	// State of accounts that users can log in to, see UserStatus
+	STATE_DEPLOYED = "deployed"
+
+	// Error code of the CA if a request with the same Idempotency-Key is
+	// still being processed
+	CODE_IDEMPOTENCY_IN_PROGRESS = "idempotency_in_progress"
+)
+
+type Provider struct {
+	URL    string   `json:"url"`
+	Scopes []string `json:"scopes"`
+}
+
+// Host contains the host CA public key of a host and the OpenID Connect
+// providers accepted for it, along with an optional message for users, the
+// certificate profiles that may be requested (the first is the default) and
+// the site CA the host is delegated to.
+type Host struct {
+	PublicKey  string      `json:"publickey"`
+	Providers  []Provider  `json:"providers"`
+	Message    string      `json:"message,omitempty"`
+	Profiles   []string    `json:"profiles,omitempty"`
+	Delegation *Delegation `json:"delegation,omitempty"`
+	// Omitted by delegated hosts and CAs that don't advertise it
+	ForceCommand *ForceCommand `json:"force_command,omitempty"`
+	// Whether the host requires logins to present the access token the
+	// certificate was issued for, see package tokenbind of oinit
+	TokenBinding bool `json:"token_binding,omitempty"`
+}
+
+// ForceCommand describes the program that logins with issued certificates
+// run, and which kinds of sessions it supports.
+type ForceCommand struct {
+	Program  string   `json:"program"`
+	Wrapper  bool     `json:"wrapper"`
+	Signed   bool     `json:"signed"`
+	Features []string `json:"features"`
+}
+
+// Kinds of sessions a force-command may support
+const (
+	FEATURE_SHELL = "shell"
+	FEATURE_EXEC  = "exec"
+	FEATURE_SCP   = "scp"
+	FEATURE_SFTP  = "sftp"
+)
+
+// Unsupported returns the kinds of sessions that the force-command doesn't
+// support, in the order shell, exec, scp, sftp.
+func (f ForceCommand) Unsupported() []string {
+	unsupported := []string{}
+
+	for _, feature := range []string{FEATURE_SHELL, FEATURE_EXEC, FEATURE_SCP, FEATURE_SFTP} {
+		supported := false
+		for _, s := range f.Features {
+			if s == feature {
+				supported = true
+				break
+			}
+		}
+
+		if !supported {
+			unsupported = append(unsupported, feature)
+		}
+	}
+
+	return unsupported
+}
+
+// Delegation states that certificates for a host are issued by a site CA,
+// whose user CA key is certified by the central CA: Signature is an armored
+// SSH signature over Statement in namespace "oinit-delegation", which can be
+// verified with 'ssh-keygen -Y verify'.
+type Delegation struct {
+	URL         string    `json:"url"`
+	PublicKey   string    `json:"publickey"`
+	ValidBefore time.Time `json:"valid_before"`
+	Statement   string    `json:"statement"`
+	Signature   string    `json:"signature"`
+}
+
+// TrustBundle contains @cert-authority known_hosts lines for all hosts served
+// by the CA.
+type TrustBundle struct {
+	KnownHosts []string `json:"known_hosts"`
+}
+
+// Health is the state of the CA, see Client.Health.
+type Health struct {
+	Status string `json:"status"`
+	// "primary", or "replica" if the CA is a warm standby that doesn't sign
+	// certificates until it is promoted
+	Role  string `json:"role"`
+	Clock struct {
+		Status      string `json:"status"`
+		Server      string `json:"server,omitempty"`
+		OffsetMs    int64  `json:"offset_ms"`
+		ToleranceMs int64  `json:"tolerance_ms"`
+		Error       string `json:"error,omitempty"`
+	} `json:"clock"`
+}
+
+// CertificateRequest contains the parameters of Client.SignCertificate.
+type CertificateRequest struct {
+	// Public key in authorized_keys format
+	PublicKey string
+	// Access token of the user, which is sent in the Authorization header
+	Token string
+	// Requested extensions, or nil for all extensions the CA allows
+	Extensions []string
+	// If not empty, the certificate only permits running this command
+	Command string
+	// Certificate profile offered by the host, or empty for the default
+	Profile string
+	// Sent in the Idempotency-Key header, so that retries return the same
+	// certificate. A random key is used if empty.
+	IdempotencyKey string
+	// Request signed by the user's key, see SignedRequest. If set,
+	// PublicKey, Extensions, Command and Profile must be empty, as they are
+	// taken from the signed request.
+	SignedRequest string
+}
+
+// UserStatus is the state of the account of a user on a host, see
+// Client.GetUserStatus. Unless the account is deployed, Remediation tells
+// the user what to do about it, if anything.
+type UserStatus struct {
+	// State reported by motley_cue, such as "deployed" or "pending"
+	State string `json:"state"`
+	// Account the user logs in as, only set if deployed
+	User        string       `json:"user,omitempty"`
+	Message     string       `json:"message,omitempty"`
+	Remediation *Remediation `json:"remediation,omitempty"`
+}
+
+// Revoked lists the serial numbers of the certificates revoked by
+// Client.Revoke.
+type Revoked struct {
+	Serials []uint64 `json:"serials"`
+}
+
+// Certificate is a certificate issued by the CA in authorized_keys format.
+type Certificate struct {
+	Certificate string `json:"certificate"`
+}
+
+// Parse returns the parsed certificate.
+func (c Certificate) Parse() (*ssh.Certificate, error) {
+	pk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(c.Certificate))
+	if err != nil {
+		return nil, err
+	}
+
+	cert, ok := pk.(*ssh.Certificate)
+	if !ok {
+		return nil, errors.New(ERR_NOT_A_CERTIFICATE)
+	}
+
+	return cert, nil
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Remediation tells users what they can do about a denied request. Hint is
+// translated according to WithLanguage, URL and Contact may be empty.
+type Remediation struct {
+	Hint    string `json:"hint"`
+	URL     string `json:"url,omitempty"`
+	Contact string `json:"contact,omitempty"`
+}
+
+// Error is an error response of the CA. Code is machine-readable (such as
+// "unauthorized" or "quota_exceeded"), Message is translated according to
+// WithLanguage.
+type Error struct {
+	StatusCode int          `json:"-"`
+	Code       string       `json:"code"`
+	Message    string       `json:"error"`
+	Fields     []FieldError `json:"errors,omitempty"`
+	// Set if users can resolve the error, e.g. by registering an account
+	Remediation *Remediation `json:"remediation,omitempty"`
+}
+
+func (e *Error) Error() string {
+	msg := e.Message
+	for _, field := range e.Fields {
+		msg += " " + field.Message
+	}
+
+	return msg
+}
+
+// Temporary reports whether the request may succeed when repeated, which is
+// the case if the CA or its upstream motley_cue is unavailable, or if a
+// previous attempt with the same Idempotency-Key is still being processed.
+func (e *Error) Temporary() bool {
+	return e.StatusCode == http.StatusBadGateway ||
+		e.StatusCode == http.StatusServiceUnavailable ||
+		e.StatusCode == http.StatusGatewayTimeout ||
+		(e.StatusCode == http.StatusConflict && e.Code == CODE_IDEMPOTENCY_IN_PROGRESS)
+}
+
+type Client struct {
+	addr       string
+	httpClient *http.Client
+	retries    int
+	backoff    time.Duration
+	language   string
+	userAgent  string
+}
+
+// Option configures a Client, see NewClient.
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used for requests, e.g. to configure
+// TLS or proxies.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithRetries sets how often a request is repeated if it failed temporarily,
+// waiting backoff before the first retry and doubling it for every further
+// retry. Defaults to DEFAULT_RETRIES and DEFAULT_BACKOFF.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.backoff = backoff
+	}
+}
+
+// WithLanguage sets the Accept-Language header, so error messages are
+// translated by the CA.
+func WithLanguage(language string) Option {
+	return func(c *Client) {
+		c.language = language
+	}
+}
+
+// WithUserAgent prepends the given product to the User-Agent header, such as
+// "my-portal/1.2".
+func WithUserAgent(product string) Option {
+	return func(c *Client) {
+		c.userAgent = product + " " + c.userAgent
+	}
+}
+
+// NewClient creates a new API client. addr is the server address (and port)
+// including the protocol, and the path of CAs behind path-prefix reverse
+// proxies, such as https://ca.example.com or https://example.org/oinit-ca
+func NewClient(addr string, opts ...Option) *Client {
+	addr, _ = strings.CutSuffix(addr, "/")
+
+	c := &Client{
+		addr:       addr,
+		httpClient: &http.Client{Timeout: DEFAULT_TIMEOUT},
+		retries:    DEFAULT_RETRIES,
+		backoff:    DEFAULT_BACKOFF,
+		userAgent:  "oinitca-go/" + VERSION,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// do sends the request with the additional header and decodes the response
+// body into into if the status is expected. Requests are repeated for
+// connection errors and temporary errors of the CA.
+func (c *Client) do(ctx context.Context, method, path string, header http.Header, body []byte, token string, expected int, into interface{}) error {
+	backoff := c.backoff
+
+	for attempt := 0; ; attempt++ {
+		err := c.attempt(ctx, method, path, header, body, token, expected, into)
+		if err == nil || attempt >= c.retries || ctx.Err() != nil {
+			return err
+		}
+
+		var caErr *Error
+		if errors.As(err, &caErr) && !caErr.Temporary() {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+	}
+}
+
+// retryAfter returns the interval that the CA asked to wait before polling
+// again.
+func retryAfter(res *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(res.Header.Get("Retry-After"))
+	if err != nil || seconds <= 0 {
+		return DEFAULT_POLL_INTERVAL
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// attempt sends the request once. Temporary redirects are followed with the
+// same method, body and headers, including the access token, as long as they
+// don't downgrade to http. Requests answered with 202 Accepted are polled at
+// the Location until the response is ready.
+func (c *Client) attempt(ctx context.Context, method, path string, header http.Header, body []byte, token string, expected int, into interface{}) error {
+	target := c.addr + API_V1 + path
+
+	// Redirects are handled below, so that the access token is kept
+	client := *c.httpClient
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	redirects := 0
+
+	for {
+		res, err := c.send(ctx, &client, method, target, header, body, token)
+		if err != nil {
+			return err
+		}
+
+		accepted := res.StatusCode == http.StatusAccepted && res.Header.Get("Location") != ""
+		if !accepted && res.StatusCode != http.StatusTemporaryRedirect && res.StatusCode != http.StatusPermanentRedirect {
+			return c.decode(res, expected, into)
+		}
+
+		res.Body.Close()
+
+		location, err := res.Location()
+		if err != nil {
+			return errors.New(ERR_REQUEST)
+		}
+
+		if res.Request.URL.Scheme == "https" && location.Scheme != "https" {
+			return errors.New(ERR_INSECURE_REDIRECT)
+		}
+
+		target = location.String()
+
+		if accepted {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(retryAfter(res)):
+			}
+
+			method, body = http.MethodGet, nil
+			continue
+		}
+
+		if redirects == MAX_REDIRECTS {
+			return errors.New(ERR_TOO_MANY_REDIRECTS)
+		}
+
+		redirects++
+	}
+}
+
+// send sends a single request to target.
+func (c *Client) send(ctx context.Context, client *http.Client, method, target string, header http.Header, body []byte, token string) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, errors.New(ERR_REQUEST)
+	}
+
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", c.userAgent)
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	if c.language != "" {
+		req.Header.Set("Accept-Language", c.language)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		return nil, errors.New(ERR_REQUEST)
+	}
+
+	return res, nil
+}
+
+// decode reads the response and decodes its body into into if the status is
+// expected, or into an *Error otherwise.
+func (c *Client) decode(res *http.Response, expected int, into interface{}) error {
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return errors.New(ERR_RESPONSE_BODY)
+	}
+
+	if res.StatusCode == expected {
+		if json.Unmarshal(data, into) != nil {
+			return errors.New(ERR_RESPONSE_BODY)
+		}
+
+		return nil
+	}
+
+	caErr := &Error{StatusCode: res.StatusCode}
+	if json.Unmarshal(data, caErr) != nil || caErr.Code == "" {
+		caErr.Message = fmt.Sprintf(ERR_SERVER_RESPONSE_CODE, res.StatusCode)
+	}
+
+	return caErr
+}
+
+// GetHost returns the host CA public key and supported OpenID Connect
+// providers of the given host.
+func (c *Client) GetHost(ctx context.Context, host string) (Host, error) {
+	var response Host
+
+	return response, c.do(ctx, http.MethodGet, "/"+url.PathEscape(host), nil, nil, "", http.StatusOK, &response)
+}
+
+// GetUserStatus returns the state of the account of the user of the access
+// token on the given host, without deploying it.
+func (c *Client) GetUserStatus(ctx context.Context, host, token string) (UserStatus, error) {
+	var response UserStatus
+
+	return response, c.do(ctx, http.MethodGet, "/"+url.PathEscape(host)+"/status", nil, nil, token, http.StatusOK, &response)
+}
+
+// Revoke revokes all valid certificates issued to the user of the access
+// token for the given host, e.g. after losing the device holding them.
+func (c *Client) Revoke(ctx context.Context, host, token string) (Revoked, error) {
+	var response Revoked
+
+	return response, c.do(ctx, http.MethodPost, "/"+url.PathEscape(host)+"/revoke", nil, nil, token, http.StatusOK, &response)
+}
+
+// GetTrustBundle returns @cert-authority known_hosts lines for all hosts
+// served by the CA.
+func (c *Client) GetTrustBundle(ctx context.Context) (TrustBundle, error) {
+	var response TrustBundle
+
+	return response, c.do(ctx, http.MethodGet, "/trust-bundle", nil, nil, "", http.StatusOK, &response)
+}
+
+// Health returns the state of the CA. An *Error with status 503 is returned
+// if the CA is unhealthy, e.g. because its clock is skewed.
+func (c *Client) Health(ctx context.Context) (Health, error) {
+	var response Health
+
+	return response, c.do(ctx, http.MethodGet, "/health", nil, nil, "", http.StatusOK, &response)
+}
+
+// SignCertificate requests a new certificate for the public key to log in to
+// the given host. Retries of the request send the same Idempotency-Key, so
+// the CA issues at most one certificate. If the CA answers with 202 Accepted
+// because the request is slow, it is polled until the certificate is ready.
+func (c *Client) SignCertificate(ctx context.Context, host string, req CertificateRequest) (Certificate, error) {
+	var response Certificate
+
+	body, err := json.Marshal(struct {
+		Publickey     string   `json:"publickey,omitempty"`
+		Extensions    []string `json:"extensions"`
+		Command       string   `json:"command,omitempty"`
+		Profile       string   `json:"profile,omitempty"`
+		SignedRequest string   `json:"signed_request,omitempty"`
+	}{req.PublicKey, req.Extensions, req.Command, req.Profile, req.SignedRequest})
+	if err != nil {
+		return response, err
+	}
+
+	key := req.IdempotencyKey
+	if key == "" {
+		random := make([]byte, 16)
+		if _, err := rand.Read(random); err != nil {
+			return response, err
+		}
+
+		key = hex.EncodeToString(random)
+	}
+
+	// The CA may answer slow requests with 202 Accepted, which is polled
+	header := http.Header{
+		"Idempotency-Key": []string{key},
+		"Prefer":          []string{"respond-async"},
+	}
+
+	return response, c.do(ctx, http.MethodPost, "/"+url.PathEscape(host)+"/certificate", header, body, req.Token, http.StatusCreated, &response)
+}
@@ -0,0 +1,179 @@
+package oinitca
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignCertificate(t *testing.T) {
+	var attempts int32
+	var keys []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/api/v1/login.example.com/certificate", r.URL.Path)
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		assert.Equal(t, "de", r.Header.Get("Accept-Language"))
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		assert.Equal(t, "ssh-ed25519 AAAA", body["publickey"])
+		assert.Nil(t, body["extensions"])
+
+		// The first attempt fails temporarily
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"certificate": "ssh-ed25519-cert-v01@openssh.com AAAA"}`))
+	}))
+	defer srv.Close()
+
+	client := NewClient(srv.URL+"/", WithRetries(1, time.Millisecond), WithLanguage("de"))
+
+	cert, err := client.SignCertificate(context.Background(), "login.example.com", CertificateRequest{
+		PublicKey: "ssh-ed25519 AAAA",
+		Token:     "token",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "ssh-ed25519-cert-v01@openssh.com AAAA", cert.Certificate)
+	assert.Equal(t, int32(2), attempts)
+
+	// Retries use the same key
+	assert.NotEmpty(t, keys[0])
+	assert.Equal(t, keys[0], keys[1])
+}
+
+func TestError(t *testing.T) {
+	var attempts int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"code": "bad_body", "error": "Request body is malformed.", "errors": [{"field": "publickey", "code": "missing", "message": "Public key is missing."}]}`))
+	}))
+	defer srv.Close()
+
+	_, err := NewClient(srv.URL, WithRetries(3, time.Millisecond)).GetHost(context.Background(), "login.example.com")
+
+	var caErr *Error
+	assert.True(t, errors.As(err, &caErr))
+	assert.Equal(t, http.StatusBadRequest, caErr.StatusCode)
+	assert.Equal(t, "bad_body", caErr.Code)
+	assert.Equal(t, "Request body is malformed. Public key is missing.", err.Error())
+
+	// Client errors are not retried
+	assert.Equal(t, int32(1), attempts)
+}
+
+func TestContextCanceled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := NewClient(srv.URL, WithRetries(10, time.Second)).GetTrustBundle(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestRedirect(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Unlike net/http, the client keeps the token for other hosts
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		assert.Equal(t, "ssh-ed25519 AAAA", body["publickey"])
+
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"certificate": "ssh-ed25519-cert-v01@openssh.com AAAA"}`))
+	}))
+	defer site.Close()
+
+	central := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, site.URL+r.URL.Path, http.StatusTemporaryRedirect)
+	}))
+	defer central.Close()
+
+	cert, err := NewClient(central.URL).SignCertificate(context.Background(), "login.example.com", CertificateRequest{
+		PublicKey: "ssh-ed25519 AAAA",
+		Token:     "token",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "ssh-ed25519-cert-v01@openssh.com AAAA", cert.Certificate)
+
+	loop := httptest.NewServer(nil)
+	loop.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, loop.URL+r.URL.Path, http.StatusTemporaryRedirect)
+	})
+	defer loop.Close()
+
+	_, err = NewClient(loop.URL, WithRetries(0, 0)).GetHost(context.Background(), "login.example.com")
+	assert.EqualError(t, err, ERR_TOO_MANY_REDIRECTS)
+}
+
+func TestAsync(t *testing.T) {
+	var polls int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+		if r.Method == http.MethodPost {
+			assert.Equal(t, "respond-async", r.Header.Get("Prefer"))
+
+			w.Header().Set("Location", "/api/v1/requests/abc")
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{"request_id": "abc", "status": "pending"}`))
+			return
+		}
+
+		assert.Equal(t, http.MethodGet, r.Method)
+		assert.Equal(t, "/api/v1/requests/abc", r.URL.Path)
+		atomic.AddInt32(&polls, 1)
+
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"certificate": "ssh-ed25519-cert-v01@openssh.com AAAA"}`))
+	}))
+	defer srv.Close()
+
+	cert, err := NewClient(srv.URL).SignCertificate(context.Background(), "login.example.com", CertificateRequest{
+		PublicKey: "ssh-ed25519 AAAA",
+		Token:     "token",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "ssh-ed25519-cert-v01@openssh.com AAAA", cert.Certificate)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&polls))
+}
+
+func TestGetUserStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodGet, r.Method)
+		assert.Equal(t, "/api/v1/login.example.com/status", r.URL.Path)
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+		w.Write([]byte(`{"state": "pending", "remediation": {"hint": "Your account is being set up, please try again later."}}`))
+	}))
+	defer srv.Close()
+
+	status, err := NewClient(srv.URL).GetUserStatus(context.Background(), "login.example.com", "token")
+	assert.NoError(t, err)
+	assert.Equal(t, "pending", status.State)
+	assert.Empty(t, status.User)
+	assert.Equal(t, "Your account is being set up, please try again later.", status.Remediation.Hint)
+}
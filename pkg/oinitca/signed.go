@@ -0,0 +1,127 @@
+package oinitca
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// Prefix of signed requests, which is also signed, so signatures can't
+	// be confused with those of other formats
+	SIGNED_REQUEST_PREFIX = "oinit-request-v1"
+
+	ERR_MALFORMED_SIGNED_REQUEST = "signed request is malformed"
+	ERR_INVALID_SIGNATURE        = "signature of signed request is invalid"
+)
+
+// Errors returned by ParseSignedRequest, wrapping the cause if there is one.
+var (
+	ErrMalformedSignedRequest = errors.New(ERR_MALFORMED_SIGNED_REQUEST)
+	ErrInvalidSignature       = errors.New(ERR_INVALID_SIGNATURE)
+)
+
+// SignedRequest is a certificate request signed by the private key of the
+// public key to be certified. Intermediaries such as portals can relay it to
+// the CA along with the access token of the user, but can't change the host
+// or options without invalidating the signature.
+type SignedRequest struct {
+	// Public key in authorized_keys format, which the request is signed with
+	PublicKey string `json:"publickey"`
+	Host      string `json:"host"`
+	// Requested extensions, or nil for all extensions the CA allows
+	Extensions []string `json:"extensions"`
+	Command    string   `json:"command,omitempty"`
+	Profile    string   `json:"profile,omitempty"`
+	// If set, the request is only valid with the access token of this hash,
+	// see package tokenbind
+	TokenHash string `json:"token_hash,omitempty"`
+	// Unix time of signing, the CA rejects requests that are too old
+	IssuedAt int64 `json:"iat"`
+}
+
+// Sign returns the request in the compact format
+//
+//	oinit-request-v1.<payload>.<signature>
+//
+// where payload is the base64url-encoded JSON of the request and signature
+// is the base64url-encoded SSH signature of everything before the last dot.
+// The public key of the request is set to the one of signer and IssuedAt to
+// the current time if zero. RSA keys sign with SHA-256.
+func (r SignedRequest) Sign(signer ssh.Signer) (string, error) {
+	r.PublicKey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+	if r.IssuedAt == 0 {
+		r.IssuedAt = time.Now().Unix()
+	}
+
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+
+	signed := SIGNED_REQUEST_PREFIX + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var sig *ssh.Signature
+	if algSigner, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		sig, err = algSigner.SignWithAlgorithm(rand.Reader, []byte(signed), ssh.KeyAlgoRSASHA256)
+	} else {
+		sig, err = signer.Sign(rand.Reader, []byte(signed))
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(ssh.Marshal(sig)), nil
+}
+
+// ParseSignedRequest parses a signed request and verifies that it is signed
+// by its public key. Whether it is recent enough and for the right host must
+// be checked by the caller.
+func ParseSignedRequest(s string) (SignedRequest, ssh.PublicKey, error) {
+	var req SignedRequest
+
+	// Base64url contains no dots, so the request consists of three parts
+	rest, found := strings.CutPrefix(strings.TrimSpace(s), SIGNED_REQUEST_PREFIX+".")
+	encodedPayload, encodedSig, cut := strings.Cut(rest, ".")
+	if !found || !cut {
+		return req, nil, ErrMalformedSignedRequest
+	}
+
+	signed := SIGNED_REQUEST_PREFIX + "." + encodedPayload
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return req, nil, fmt.Errorf("%w: %w", ErrMalformedSignedRequest, err)
+	}
+
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return req, nil, fmt.Errorf("%w: %w", ErrMalformedSignedRequest, err)
+	}
+
+	pubkey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(req.PublicKey))
+	if err != nil {
+		return req, nil, fmt.Errorf("%w: %w", ErrMalformedSignedRequest, err)
+	}
+
+	rawSig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil {
+		return req, nil, fmt.Errorf("%w: %w", ErrMalformedSignedRequest, err)
+	}
+
+	var sig ssh.Signature
+	if err := ssh.Unmarshal(rawSig, &sig); err != nil {
+		return req, nil, fmt.Errorf("%w: %w", ErrMalformedSignedRequest, err)
+	}
+
+	if err := pubkey.Verify([]byte(signed), &sig); err != nil {
+		return req, nil, fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+
+	return req, pubkey, nil
+}
@@ -0,0 +1,55 @@
+package oinitca
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSignedRequest(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	assert.NoError(t, err)
+
+	signed, err := SignedRequest{Host: "login.example.com", Extensions: []string{}, Profile: "batch"}.Sign(signer)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(signed, SIGNED_REQUEST_PREFIX+"."))
+
+	req, pubkey, err := ParseSignedRequest(signed)
+	assert.NoError(t, err)
+	assert.Equal(t, signer.PublicKey().Marshal(), pubkey.Marshal())
+	assert.Equal(t, "login.example.com", req.Host)
+	assert.Equal(t, []string{}, req.Extensions)
+	assert.Equal(t, "batch", req.Profile)
+	assert.NotZero(t, req.IssuedAt)
+
+	// Relays can't alter the request
+	parts := strings.Split(signed, ".")
+	other, err := SignedRequest{Host: "other.example.com"}.Sign(signer)
+	assert.NoError(t, err)
+	_, _, err = ParseSignedRequest(parts[0] + "." + strings.Split(other, ".")[1] + "." + parts[2])
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	for _, malformed := range []string{"", "oinit-request-v1.", parts[1] + "." + parts[2], signed + ".x", "oinit-request-v1.e30." + parts[2]} {
+		_, _, err = ParseSignedRequest(malformed)
+		assert.ErrorIs(t, err, ErrMalformedSignedRequest, malformed)
+	}
+
+	// RSA keys sign with SHA-256
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	rsaSigner, err := ssh.NewSignerFromKey(rsaKey)
+	assert.NoError(t, err)
+
+	signed, err = SignedRequest{Host: "login.example.com"}.Sign(rsaSigner)
+	assert.NoError(t, err)
+
+	_, _, err = ParseSignedRequest(signed)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,136 @@
+// Package oinitcaserver embeds the REST API of oinit-ca into other Go
+// services, such as the backend of a site's portal, which serve it with their
+// own server and middleware rather than running oinit-ca as a separate
+// daemon.
+//
+// Example usage:
+//
+//	handler, err := oinitcaserver.NewHandler(oinitcaserver.Config{
+//		Path: "/etc/oinit-ca/config.ini",
+//	})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer handler.Close()
+//
+//	// With "base-path = /oinit-ca" in the config
+//	mux.Handle("/oinit-ca/", handler)
+//
+// The config is loaded as by "oinit-ca serve", except that listeners don't
+// apply, the process is not sandboxed, its memory is not locked and the clock
+// is not checked against the NTP server. The optional middleware of the
+// config (option middleware) is applied by the handler. The handler uses gin,
+// so set GIN_MODE=release or call gin.SetMode to silence its debug output.
+// The handler, its storage and background tasks log to stdout with their own
+// logger and leave the standard logger of the service alone.
+//
+// State, such as serial numbers, revocations and the audit trail, is kept in
+// the storage backend of the config, by default the file
+// /var/lib/oinit-ca/state.json. A storage file must only be used by a single
+// process, so with the default storage each embedding process, e.g. each
+// replica of the service, needs its own file and has its own serial numbers
+// and revocations: processes sharing the CA keys issue colliding serial
+// numbers, and certificates revoked through one process are missing from the
+// KRL of the others. Embed the CA in a single process per set of CA keys.
+// Modifications of the file storage are partly written in the background, so
+// the handler must be closed before the service exits, otherwise up to a
+// second of modifications, such as seen tokens, is lost.
+//
+// This package follows semantic versioning independently of the oinit
+// binaries, see VERSION.
+package oinitcaserver
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/lbrocke/oinit/internal/api"
+	"github.com/lbrocke/oinit/internal/config"
+	"github.com/lbrocke/oinit/internal/server"
+	"github.com/lbrocke/oinit/internal/storage"
+
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// Version of this package
+	VERSION = "1.0.0"
+
+	// Routes served by the handler, see Config
+	MODE_API    = config.LISTEN_MODE_API    // the public API used by clients
+	MODE_ADMIN  = config.LISTEN_MODE_ADMIN  // the admin API and dashboard
+	MODE_ALL    = config.LISTEN_MODE_ALL    // both of the above
+	MODE_HEALTH = config.LISTEN_MODE_HEALTH // health and metrics without authentication
+
+	// Actor of audit events of configuration changes, which are detected
+	// when the handler is created
+	AUDIT_ACTOR = "oinitcaserver"
+
+	ERR_UNKNOWN_MODE = "unknown mode"
+)
+
+var ErrUnknownMode = errors.New(ERR_UNKNOWN_MODE)
+
+// Config configures the handler returned by NewHandler.
+type Config struct {
+	// Path of the config file of the CA, see configs/config.sample.ini
+	Path string
+	// Routes that are served, see MODE_*. Defaults to MODE_API.
+	Mode string
+}
+
+// Handler serves the routes of the CA. It must be closed when it is no
+// longer used.
+type Handler struct {
+	handler http.Handler
+	store   storage.Store
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.handler.ServeHTTP(w, r)
+}
+
+// Close writes pending modifications to the storage backend and stops its
+// background tasks. Requests must not be served afterwards.
+func (h *Handler) Close() error {
+	return h.store.Close()
+}
+
+// NewHandler loads the config of the CA, including its keys, and returns the
+// handler serving its routes. Paths are expected to start with the base-path
+// of the config, which may also be removed, e.g. by http.StripPrefix. Each
+// call opens the storage backend of the config, so create a single handler
+// and share it.
+func NewHandler(cfg Config) (*Handler, error) {
+	mode := cfg.Mode
+	if mode == "" {
+		mode = MODE_API
+	}
+
+	if !slices.Contains(config.ListenModes, mode) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMode, mode)
+	}
+
+	conf, err := config.Load(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := server.OpenStore(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := api.RecordConfig(store, conf, AUDIT_ACTOR); err != nil {
+		api.Logger.Println("Could not record configuration changes: " + err.Error())
+	}
+
+	server.InitSwagger()
+
+	return &Handler{
+		handler: server.NewHandler(conf, store, nil, server.DefaultListener(conf, "", 0, mode)),
+		store:   store,
+	}, nil
+}
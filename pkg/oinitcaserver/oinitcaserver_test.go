@@ -0,0 +1,67 @@
+package oinitcaserver
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestNewHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	dir := t.TempDir()
+
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	assert.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	assert.NoError(t, err)
+
+	privPath := filepath.Join(dir, "ca")
+	pubPath := filepath.Join(dir, "ca.pub")
+	assert.NoError(t, os.WriteFile(privPath, pem.EncodeToMemory(block), 0600))
+	assert.NoError(t, os.WriteFile(pubPath, ssh.MarshalAuthorizedKey(signer.PublicKey()), 0644))
+
+	path := filepath.Join(dir, "config.ini")
+	assert.NoError(t, os.WriteFile(path, []byte("base-path = /oinit-ca\n"+
+		"host-ca-privkey = "+privPath+"\nhost-ca-pubkey = "+pubPath+"\n"+
+		"user-ca-privkey = "+privPath+"\nuser-ca-pubkey = "+pubPath+"\n"+
+		"cert-validity = token\ncache-duration = 600\n"+
+		"storage = file:"+filepath.Join(dir, "state.json")+"\n"+
+		"[example.com]\nlogin.example.com = https://login.example.com\n"), 0600))
+
+	_, err = NewHandler(Config{Path: path, Mode: "public"})
+	assert.True(t, errors.Is(err, ErrUnknownMode))
+
+	handler, err := NewHandler(Config{Path: path})
+	assert.NoError(t, err)
+	defer handler.Close()
+
+	mux := http.NewServeMux()
+	mux.Handle("/oinit-ca/", handler)
+
+	for target, code := range map[string]int{
+		"/oinit-ca/api/v1/health":       http.StatusOK,
+		"/oinit-ca/api/v1/trust-bundle": http.StatusOK,
+		// Only the public API is served by default
+		"/oinit-ca/api/v1/admin/whoami": http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, code, w.Code, target)
+	}
+
+	assert.NoError(t, handler.Close())
+	_, err = os.Stat(filepath.Join(dir, "state.json"))
+	assert.NoError(t, err)
+}
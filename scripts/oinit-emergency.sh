@@ -0,0 +1,66 @@
+#!/bin/sh
+
+# Requests an emergency certificate from the oinit CA, for incident
+# responders who must reach a host while the OIDC provider or motley_cue is
+# down. The request is signed with the hardware-backed key of the responder,
+# which must be listed in emergency-keys of the CA; the security contacts of
+# the CA are alerted of every emergency certificate.
+#
+# Usage: oinit-emergency <ca> <host> <principal>[,<principal>...] <reason>
+#
+# e.g. oinit-emergency https://ca.example.com login.example.com root "INC-1234"
+#
+# By default, the hardware-backed key itself is certified, so that logins
+# also require the token. Set PUBKEY to certify a different public key. The
+# certificate is written next to the public key, where ssh picks it up.
+
+set -eu
+
+EMERGENCY_KEY="${EMERGENCY_KEY:-${HOME}/.ssh/id_ed25519_sk}"
+PUBKEY="${PUBKEY:-${EMERGENCY_KEY}.pub}"
+NAMESPACE="oinit-emergency"
+
+if [ "$#" -ne 4 ]; then
+    echo "Usage: $(basename "$0") <ca> <host> <principal>[,<principal>...] <reason>" >&2
+    exit 1
+fi
+
+CA="${1%/}"
+HOST="$(echo "$2" | tr '[:upper:]' '[:lower:]')"
+CERT="${PUBKEY%.pub}-cert.pub"
+
+TMP="$(mktemp -d)"
+trap 'rm -rf "${TMP}"' EXIT
+
+# Escapes $1 for use in a JSON string
+json_string() {
+    printf '"%s"' "$(printf '%s' "$1" | sed 's/\\/\\\\/g; s/"/\\"/g')"
+}
+
+PRINCIPALS="$(echo "$3" | tr ',' '\n' | while read -r principal; do
+    json_string "${principal}"
+    printf ','
+done | sed 's/,$//')"
+
+printf '{"host":%s,"publickey":%s,"principals":[%s],"reason":%s,"iat":%s}' \
+    "$(json_string "${HOST}")" "$(json_string "$(cat "${PUBKEY}")")" "${PRINCIPALS}" \
+    "$(json_string "$4")" "$(date +%s)" > "${TMP}/request"
+
+echo "Touch your security key to sign the emergency request."
+ssh-keygen -q -Y sign -n "${NAMESPACE}" -f "${EMERGENCY_KEY}" "${TMP}/request" < /dev/null
+
+curl --silent --show-error --fail-with-body --output "${TMP}/response" \
+    --form "request=<${TMP}/request" \
+    --form "signature=<${TMP}/request.sig" \
+    "${CA}/api/v1/${HOST}/emergency"
+
+sed -n 's/.*"certificate":"\([^"]*\)".*/\1/p' "${TMP}/response" > "${TMP}/cert"
+
+if [ ! -s "${TMP}/cert" ]; then
+    echo "Request failed: invalid response from ${CA}" >&2
+    exit 1
+fi
+
+mv "${TMP}/cert" "${CERT}"
+
+echo "Wrote emergency certificate for ${HOST} to ${CERT}, it expires in a few minutes."
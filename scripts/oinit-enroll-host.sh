@@ -0,0 +1,64 @@
+#!/bin/sh
+
+# Requests a host certificate for the host key of this host (see
+# oinit-openssh-postinstall.sh) from the oinit CA, if host-enrollment is
+# enabled for its hostgroup. Unless the request is approved automatically, it
+# is held pending until an admin of the CA approves it, and this script waits
+# for the decision.
+#
+# Usage: oinit-enroll-host <ca> <host>
+#
+# e.g. oinit-enroll-host https://ca.example.com login.example.com
+
+set -eu
+
+HOST_KEY="${HOST_KEY:-/etc/ssh/host-key}"
+HOST_CERT="${HOST_CERT:-/etc/ssh/host-key-cert.pub}"
+HOSTS_FILE="${HOSTS_FILE:-/etc/ssh/oinit-hosts}"
+POLL_INTERVAL="${POLL_INTERVAL:-60}"
+
+if [ "$#" -ne 2 ]; then
+    echo "Usage: $(basename "$0") <ca> <host>" >&2
+    exit 1
+fi
+
+CA="${1%/}"
+HOST="$(echo "$2" | tr '[:upper:]' '[:lower:]')"
+
+TMP="$(mktemp -d)"
+trap 'rm -rf "${TMP}"' EXIT
+
+# Extracts the string field $1 from the JSON response
+field() {
+    sed -n 's/.*"'"$1"'":"\([^"]*\)".*/\1/p' "${TMP}/response"
+}
+
+CODE="$(curl --silent --show-error --output "${TMP}/response" --write-out '%{http_code}' \
+    --form "publickey=<${HOST_KEY}.pub" \
+    "${CA}/api/v1/${HOST}/enroll")"
+
+ID="$(field id)"
+
+while [ "${CODE}" = "202" ]; do
+    echo "Enrollment ${ID} of ${HOST} is pending approval by an admin of ${CA}."
+    sleep "${POLL_INTERVAL}"
+
+    CODE="$(curl --silent --show-error --output "${TMP}/response" --write-out '%{http_code}' \
+        "${CA}/api/v1/${HOST}/enroll/${ID}")"
+done
+
+if [ "${CODE}" != "200" ]; then
+    echo "Enrollment failed: $(field error)" >&2
+    exit 1
+fi
+
+field certificate > "${TMP}/cert"
+mv "${TMP}/cert" "${HOST_CERT}"
+chmod 0644 "${HOST_CERT}"
+
+# Names of this host for oinit-principals
+if ! grep -qxF "${HOST}" "${HOSTS_FILE}" 2>/dev/null; then
+    echo "${HOST}" >> "${HOSTS_FILE}"
+fi
+
+echo "Installed host certificate for ${HOST} in ${HOST_CERT}, reload sshd to use it."
@@ -21,7 +21,10 @@ echo ""
 echo ""
 
 echo "2. Please request an OpenSSH certificate from the oinit CA administrator by"
-echo "   sending him/her the file '/etc/ssh/host-key.pub'."
+echo "   sending him/her the file '/etc/ssh/host-key.pub'. If the CA allows hosts"
+echo "   to enroll, run 'oinit-enroll-host <ca> <host>' instead, which installs the"
+echo "   host certificate once it is approved, and run 'oinit-renew-host <ca> <host>'"
+echo "   daily, e.g. from a systemd timer, to renew it before it expires."
 echo ""
 echo "   You'll get two files in return:"
 echo "    - host-key-cert.pub"
@@ -33,6 +36,16 @@ echo "    HostKey           /etc/ssh/host-key"
 echo "    HostCertificate   /etc/ssh/host-key-cert.pub"
 echo "    TrustedUserCAKeys /etc/ssh/user-ca.pub"
 echo "    "
+echo "    # If the CA restricts certificates to hosts (host-principals):"
+echo "    AuthorizedPrincipalsCommand     /usr/bin/oinit-principals %u"
+echo "    AuthorizedPrincipalsCommandUser nobody"
+echo "    "
+echo "    # If logins must present the access token the certificate was issued for"
+echo "    # (/etc/ssh/oinit-switch.motley_cue and token-binding in the hostgroup of"
+echo "    # the CA), which clients send as OINIT_BOUND_TOKEN:"
+echo "    ExposeAuthInfo yes"
+echo "    AcceptEnv      OINIT_BOUND_TOKEN"
+echo "    "
 echo "    # You may put this at the bottom of your sshd_config file:"
 echo "    Match User oinit"
 echo "        PasswordAuthentication no"
@@ -0,0 +1,37 @@
+#!/bin/sh
+
+# Prints the principals that sshd accepts in certificates for logins as the
+# given user, for hostgroups with host-principals enabled: the CA suffixes
+# principals with "@<host>", so a certificate requested for one host is not
+# accepted by any other. The names this host is served under by the CA are
+# read from /etc/ssh/oinit-hosts, one per line (see oinit-enroll-host), and
+# default to the fully qualified hostname. oinit-switch reads the same file.
+#
+# Usage: oinit-principals <user>
+#
+# Add these lines to '/etc/ssh/sshd_config':
+#
+#   AuthorizedPrincipalsCommand     /usr/bin/oinit-principals %u
+#   AuthorizedPrincipalsCommandUser nobody
+#
+# Certificates without host principals are no longer accepted afterwards, so
+# configure all hosts of a hostgroup before enabling host-principals.
+
+set -eu
+
+HOSTS_FILE="${HOSTS_FILE:-/etc/ssh/oinit-hosts}"
+
+if [ "$#" -ne 1 ]; then
+    echo "Usage: $(basename "$0") <user>" >&2
+    exit 1
+fi
+
+if [ -r "${HOSTS_FILE}" ]; then
+    HOSTS="$(grep -v '^[[:space:]]*\(#\|$\)' "${HOSTS_FILE}" || true)"
+else
+    HOSTS="$(hostname -f)"
+fi
+
+for HOST in ${HOSTS}; do
+    echo "$1@$(echo "${HOST}" | tr '[:upper:]' '[:lower:]')"
+done
@@ -0,0 +1,76 @@
+#!/bin/sh
+
+# Renews the host certificate of this host (see oinit-enroll-host) at the
+# oinit CA once it expires within RENEW_BEFORE seconds. The request is signed
+# with the key of the current host certificate, which must not have expired
+# yet, so run this regularly, e.g. daily from a systemd timer.
+#
+# Usage: oinit-renew-host <ca> <host>
+#
+# e.g. oinit-renew-host https://ca.example.com login.example.com
+#
+# sshd must be reloaded to use the renewed certificate.
+
+set -eu
+
+HOST_KEY="${HOST_KEY:-/etc/ssh/host-key}"
+HOST_CERT="${HOST_CERT:-/etc/ssh/host-key-cert.pub}"
+RENEW_BEFORE="${RENEW_BEFORE:-864000}"
+NAMESPACE="oinit-renew"
+
+if [ "$#" -ne 2 ]; then
+    echo "Usage: $(basename "$0") <ca> <host>" >&2
+    exit 1
+fi
+
+CA="${1%/}"
+HOST="$(echo "$2" | tr '[:upper:]' '[:lower:]')"
+
+TMP="$(mktemp -d)"
+trap 'rm -rf "${TMP}"' EXIT
+
+# Extracts the field $1 from the output of ssh-keygen -L
+cert_field() {
+    ssh-keygen -L -f "${HOST_CERT}" | sed -n 's/^ *'"$1"': *//p'
+}
+
+# "Valid: from 2024-01-01T00:00:00 to 2024-01-31T00:00:00", in local time
+VALID_TO="$(cert_field Valid | sed -n 's/.* to \(.*\)$/\1/p')"
+
+if [ "${VALID_TO}" = "forever" ]; then
+    echo "Host certificate ${HOST_CERT} never expires, not renewing."
+    exit 0
+fi
+
+EXPIRES="$(date -d "$(echo "${VALID_TO}" | tr 'T' ' ')" +%s)"
+TIMESTAMP="$(date +%s)"
+
+if [ "$((EXPIRES - TIMESTAMP))" -gt "${RENEW_BEFORE}" ]; then
+    echo "Host certificate ${HOST_CERT} is valid until ${VALID_TO}, not renewing yet."
+    exit 0
+fi
+
+SERIAL="$(cert_field Serial)"
+
+# The signed message is "<host>\n<timestamp>\n<serial>"
+printf '%s\n%s\n%s' "${HOST}" "${TIMESTAMP}" "${SERIAL}" > "${TMP}/message"
+
+ssh-keygen -q -Y sign -n "${NAMESPACE}" -f "${HOST_KEY}" "${TMP}/message" < /dev/null
+
+curl --silent --show-error --fail-with-body --output "${TMP}/response" \
+    --form "timestamp=${TIMESTAMP}" \
+    --form "certificate=<${HOST_CERT}" \
+    --form "signature=<${TMP}/message.sig" \
+    "${CA}/api/v1/${HOST}/renew"
+
+sed -n 's/.*"certificate":"\([^"]*\)".*/\1/p' "${TMP}/response" > "${TMP}/cert"
+
+if [ ! -s "${TMP}/cert" ]; then
+    echo "Renewal failed: invalid response from ${CA}" >&2
+    exit 1
+fi
+
+mv "${TMP}/cert" "${HOST_CERT}"
+chmod 0644 "${HOST_CERT}"
+
+echo "Renewed host certificate for ${HOST} in ${HOST_CERT}, reload sshd to use it."
@@ -0,0 +1,53 @@
+#!/bin/sh
+
+# Reports the SSH host keys of this host to the oinit CA, which serves them at
+# GET /api/v1/<host>/hostkeys, so that clients can verify the identity of this
+# host on first connect. The report is signed with the key of the host
+# certificate (see oinit-openssh-postinstall.sh), which the CA verifies.
+#
+# Usage: oinit-report-hostkeys <ca> <host>
+#
+# e.g. oinit-report-hostkeys https://ca.example.com login.example.com
+#
+# Run this after enrolling the host and whenever its host keys change.
+
+set -eu
+
+HOST_KEY="${HOST_KEY:-/etc/ssh/host-key}"
+HOST_CERT="${HOST_CERT:-/etc/ssh/host-key-cert.pub}"
+NAMESPACE="oinit-hostkeys"
+
+if [ "$#" -ne 2 ]; then
+    echo "Usage: $(basename "$0") <ca> <host>" >&2
+    exit 1
+fi
+
+CA="${1%/}"
+HOST="$(echo "$2" | tr '[:upper:]' '[:lower:]')"
+
+TMP="$(mktemp -d)"
+trap 'rm -rf "${TMP}"' EXIT
+
+# All public host keys, including the key of the host certificate
+for key in /etc/ssh/ssh_host_*_key.pub "${HOST_KEY}.pub"; do
+    if [ -f "${key}" ]; then
+        cut -d ' ' -f 1,2 "${key}"
+    fi
+done | sort -u > "${TMP}/hostkeys"
+
+TIMESTAMP="$(date +%s)"
+
+# The signed message is "<host>\n<timestamp>\n<hostkeys>"
+printf '%s\n%s\n' "${HOST}" "${TIMESTAMP}" > "${TMP}/message"
+cat "${TMP}/hostkeys" >> "${TMP}/message"
+
+ssh-keygen -q -Y sign -n "${NAMESPACE}" -f "${HOST_KEY}" "${TMP}/message" < /dev/null
+
+curl --silent --show-error --fail-with-body \
+    --form "hostkeys=<${TMP}/hostkeys" \
+    --form "timestamp=${TIMESTAMP}" \
+    --form "certificate=<${HOST_CERT}" \
+    --form "signature=<${TMP}/message.sig" \
+    "${CA}/api/v1/${HOST}/hostkeys" > /dev/null
+
+echo "Reported $(wc -l < "${TMP}/hostkeys") host keys of ${HOST} to ${CA}."
@@ -0,0 +1,47 @@
+// mock-motley-cue serves the mock motley_cue API of package mockmotleycue for
+// the end-to-end test harness, see test/e2e/run.sh.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/lbrocke/oinit/internal/mockmotleycue"
+	"github.com/lbrocke/oinit/pkg/libmotleycue"
+)
+
+const (
+	USAGE = "Usage: mock-motley-cue [--listen <host:port>] [--state <state>] <ssh-user>:<token>..."
+
+	DEFAULT_LISTEN = "0.0.0.0:8080"
+)
+
+func main() {
+	listen := flag.String("listen", DEFAULT_LISTEN, "address to listen on")
+	state := flag.String("state", string(libmotleycue.StateNotDeployed), "initial state of all users")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		log.Fatal(USAGE)
+	}
+
+	mock := mockmotleycue.New()
+
+	for _, arg := range flag.Args() {
+		user, token, ok := strings.Cut(arg, ":")
+		if !ok || user == "" || token == "" {
+			log.Fatal(USAGE)
+		}
+
+		mock.AddUser(token, mockmotleycue.User{
+			SSHUser: user,
+			State:   libmotleycue.UserStatusState(*state),
+		})
+	}
+
+	log.Println("Listening on " + *listen)
+
+	log.Fatal(http.ListenAndServe(*listen, mock))
+}